@@ -0,0 +1,171 @@
+package stor
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"io"
+	"math"
+	"path"
+)
+
+// Export writes all files in s to w as a gzip-compressed tar archive (tar.gz). The archive can be
+// restored with Import, e.g. to back up a storage, to seed test fixtures, or to migrate data
+// between backends.
+func Export(s Reader, w io.Writer) error {
+	gzipWriter := gzip.NewWriter(w)
+	if err := writeTar(gzipWriter, s, "", math.MaxInt64); err != nil {
+		return err
+	}
+	return gzipWriter.Close()
+}
+
+// Import saves all files in the tar.gz archive read from r to s. The paths in the archive are
+// validated with CleanPath, so an archive can't write outside of s. Files in s that are not in the
+// archive are left untouched.
+func Import(s Saver, r io.Reader) error {
+	gzipReader, err := gzip.NewReader(r)
+	if err != nil {
+		return err
+	}
+	defer gzipReader.Close()
+
+	return readTar(gzipReader, s, "", math.MaxInt64)
+}
+
+// Pipe copies all files within the directory srcDir of src to the directory dstDir of dst. The
+// files are streamed through the tar archive format via an in-memory pipe, so no local file is
+// needed. Files are loaded and saved one at a time, so at most two files are held in memory at any
+// time: the file that is being loaded from src, and the file that is being saved to dst. The
+// maxSize argument is the maximum accepted size of a single file.
+//
+// Pipe returns after it has stopped reading src. If loading from src fails, that error is returned.
+func Pipe(src Reader, srcDir string, dst Saver, dstDir string, maxSize int64) error {
+	pipeReader, pipeWriter := io.Pipe()
+
+	writeErr := make(chan error, 1)
+	go func() {
+		err := writeTar(pipeWriter, src, srcDir, maxSize)
+		pipeWriter.CloseWithError(err)
+		writeErr <- err
+	}()
+
+	err := readTar(pipeReader, dst, dstDir, maxSize)
+
+	// Stop the writer in case the reader bailed out early, and wait until it is done
+	pipeReader.CloseWithError(io.ErrClosedPipe)
+	if wErr := <-writeErr; err == nil {
+		err = wErr
+	}
+	return err
+}
+
+// writeTar writes all files within dir of r to w as a tar archive. The names in the archive are
+// relative to dir.
+func writeTar(w io.Writer, r Reader, dir string, maxSize int64) error {
+	cleanDir, err := CleanPath(dir)
+	if err != nil {
+		return err
+	}
+
+	tarWriter := tar.NewWriter(w)
+	err = walkFiles(r, cleanDir, func(filePath string) error {
+		data, err := r.Load(filePath, maxSize)
+		if err != nil {
+			return err
+		}
+
+		header := &tar.Header{
+			Typeflag: tar.TypeReg,
+			Name:     relativePath(cleanDir, filePath),
+			Size:     int64(len(data)),
+			Mode:     0600,
+		}
+		if err := tarWriter.WriteHeader(header); err != nil {
+			return err
+		}
+		_, err = tarWriter.Write(data)
+		return err
+	})
+	if err != nil {
+		return err
+	}
+
+	return tarWriter.Close()
+}
+
+// readTar saves all regular files in the tar archive read from r to dir in s.
+func readTar(r io.Reader, s Saver, dir string, maxSize int64) error {
+	cleanDir, err := CleanPath(dir)
+	if err != nil {
+		return err
+	}
+
+	tarReader := tar.NewReader(r)
+	for {
+		header, err := tarReader.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+
+		if header.Typeflag != tar.TypeReg {
+			continue
+		}
+
+		// Validate the name on its own, so it can't escape dir
+		name, err := CleanPath(header.Name)
+		if err != nil {
+			return err
+		}
+		if name == "" {
+			return &InvalidPathError{Path: header.Name, Msg: "archive entry has no name"}
+		}
+
+		if header.Size > maxSize {
+			return &TooLargeError{What: name}
+		}
+
+		// Don't trust header.Size for allocating memory, the archive may be corrupt
+		data, err := ReadAllMax(tarReader, maxSize, name)
+		if err != nil {
+			return err
+		}
+
+		if err := s.Save(path.Join(cleanDir, name), data); err != nil {
+			return err
+		}
+	}
+}
+
+// walkFiles calls fn for every file within the directory dir (recursively).
+func walkFiles(l Lister, dir string, fn func(filePath string) error) error {
+	files, dirs, err := l.List(dir)
+	if err != nil {
+		return err
+	}
+
+	for _, filePath := range files {
+		if err := fn(filePath); err != nil {
+			return err
+		}
+	}
+
+	for _, subDir := range dirs {
+		if err := walkFiles(l, subDir, fn); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// relativePath returns filePath relative to the directory dir. Both paths must be clean, and
+// filePath must be within dir.
+func relativePath(dir, filePath string) string {
+	if dir == "" {
+		return filePath
+	}
+	return filePath[len(dir)+1:]
+}
@@ -0,0 +1,186 @@
+package stor_test
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"errors"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/suite"
+
+	"github.com/pw1/stor"
+	"github.com/pw1/stor/memory"
+)
+
+func TestArchiveSuite(t *testing.T) {
+	suite.Run(t, new(ArchiveSuite))
+}
+
+// ArchiveSuite contains the tests for streaming files between storages in the archive format.
+type ArchiveSuite struct {
+	suite.Suite
+	src *memory.Memory
+	dst *memory.Memory
+}
+
+func (s *ArchiveSuite) SetupTest() {
+	s.src, _ = memory.New(&stor.Conf{})
+	s.dst, _ = memory.New(&stor.Conf{})
+
+	files := map[string]string{
+		"file1":           "test123",
+		"dir1/file2":      "test456",
+		"dir1/file3":      "test789",
+		"dir1/dir4/file5": "test788909",
+		"dir2/dir3/file4": "test0123",
+	}
+	for filePath, content := range files {
+		s.Require().Nil(s.src.Save(filePath, []byte(content)))
+	}
+}
+
+func (s *ArchiveSuite) TestPipe() {
+	err := stor.Pipe(s.src, "dir1", s.dst, "copy", 1e6)
+	s.Nil(err)
+
+	files, dirs, err := s.dst.List("copy")
+	s.Nil(err)
+	s.ElementsMatch([]string{"copy/file2", "copy/file3"}, files)
+	s.ElementsMatch([]string{"copy/dir4"}, dirs)
+
+	data, err := s.dst.Load("copy/dir4/file5", 1e6)
+	s.Nil(err)
+	s.Equal([]byte("test788909"), data)
+}
+
+func (s *ArchiveSuite) TestPipeRoot() {
+	err := stor.Pipe(s.src, "", s.dst, "", 1e6)
+	s.Nil(err)
+
+	data, err := s.dst.Load("dir2/dir3/file4", 1e6)
+	s.Nil(err)
+	s.Equal([]byte("test0123"), data)
+}
+
+func (s *ArchiveSuite) TestPipeTooLarge() {
+	err := stor.Pipe(s.src, "", s.dst, "", 8)
+	s.True(stor.IsTooLargeError(err))
+}
+
+func (s *ArchiveSuite) TestPipeInvalidPath() {
+	err := stor.Pipe(s.src, "../dir1", s.dst, "", 1e6)
+	s.True(stor.IsInvalidPathError(err))
+
+	err = stor.Pipe(s.src, "dir1", s.dst, "../copy", 1e6)
+	s.True(stor.IsInvalidPathError(err))
+}
+
+func (s *ArchiveSuite) TestPipeSaveError() {
+	err := stor.Pipe(s.src, "", failingSaver{}, "", 1e6)
+	s.EqualError(err, "save failed")
+}
+
+// TestPipeWaitsForReader verifies that Pipe doesn't return while the source is still being read.
+func (s *ArchiveSuite) TestPipeWaitsForReader() {
+	src := &slowReader{Reader: s.src}
+	err := stor.Pipe(src, "", failingSaver{}, "", 1e6)
+	s.EqualError(err, "save failed")
+	s.Equal(int32(0), atomic.LoadInt32(&src.loading))
+
+	loads := atomic.LoadInt32(&src.loads)
+	time.Sleep(20 * time.Millisecond)
+	s.Equal(loads, atomic.LoadInt32(&src.loads), "src is read after Pipe returned")
+}
+
+func (s *ArchiveSuite) TestExportImport() {
+	var buf bytes.Buffer
+	s.Nil(stor.Export(s.src, &buf))
+	s.Equal([]byte{0x1f, 0x8b}, buf.Bytes()[:2], "gzip compressed")
+
+	s.Nil(s.dst.Save("existing", []byte("untouched")))
+	s.Nil(stor.Import(s.dst, &buf))
+
+	for _, filePath := range []string{"file1", "dir1/file2", "dir1/file3", "dir1/dir4/file5",
+		"dir2/dir3/file4"} {
+
+		expected, err := s.src.Load(filePath, 1e6)
+		s.Nil(err)
+		actual, err := s.dst.Load(filePath, 1e6)
+		s.Nil(err)
+		s.Equal(expected, actual, filePath)
+	}
+
+	data, err := s.dst.Load("existing", 1e6)
+	s.Nil(err)
+	s.Equal([]byte("untouched"), data)
+}
+
+func (s *ArchiveSuite) TestExportSizes() {
+	var buf bytes.Buffer
+	s.Nil(stor.Export(s.src, &buf))
+
+	gzipReader, err := gzip.NewReader(&buf)
+	s.Require().Nil(err)
+	tarReader := tar.NewReader(gzipReader)
+
+	sizes := make(map[string]int64)
+	for {
+		header, err := tarReader.Next()
+		if err != nil {
+			break
+		}
+		sizes[header.Name] = header.Size
+	}
+	s.Equal(map[string]int64{
+		"file1":           7,
+		"dir1/file2":      7,
+		"dir1/file3":      7,
+		"dir1/dir4/file5": 10,
+		"dir2/dir3/file4": 8,
+	}, sizes)
+}
+
+func (s *ArchiveSuite) TestImportEscapes() {
+	var buf bytes.Buffer
+	gzipWriter := gzip.NewWriter(&buf)
+	tarWriter := tar.NewWriter(gzipWriter)
+	s.Nil(tarWriter.WriteHeader(&tar.Header{Name: "../evil", Size: 4, Mode: 0600,
+		Typeflag: tar.TypeReg}))
+	tarWriter.Write([]byte("evil"))
+	tarWriter.Close()
+	gzipWriter.Close()
+
+	err := stor.Import(s.dst, &buf)
+	s.True(stor.IsInvalidPathError(err))
+}
+
+func (s *ArchiveSuite) TestImportNotGzip() {
+	err := stor.Import(s.dst, bytes.NewReader([]byte("not an archive")))
+	s.NotNil(err)
+}
+
+// failingSaver is a stor.Saver that always fails.
+type failingSaver struct{}
+
+func (f failingSaver) Save(filePath string, data []byte) error {
+	return errors.New("save failed")
+}
+
+// slowReader is a stor.Reader of which Load takes a while. It counts the Loads, and the Loads in
+// progress.
+type slowReader struct {
+	stor.Reader
+	loads   int32
+	loading int32
+}
+
+func (r *slowReader) Load(filePath string, maxSize int64) ([]byte, error) {
+	atomic.AddInt32(&r.loads, 1)
+	atomic.AddInt32(&r.loading, 1)
+	defer atomic.AddInt32(&r.loading, -1)
+	time.Sleep(10 * time.Millisecond)
+	return r.Reader.Load(filePath, maxSize)
+}
@@ -0,0 +1,434 @@
+package b2
+
+import (
+	"bytes"
+	"crypto/sha1"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/pw1/stor"
+)
+
+// session contains the result of b2_authorize_account.
+type session struct {
+	AccountID          string `json:"accountId"`
+	AuthorizationToken string `json:"authorizationToken"`
+	APIURL             string `json:"apiUrl"`
+	DownloadURL        string `json:"downloadUrl"`
+	RecommendedPart    int64  `json:"recommendedPartSize"`
+	MinimumPart        int64  `json:"absoluteMinimumPartSize"`
+	Allowed            struct {
+		BucketID   string `json:"bucketId"`
+		BucketName string `json:"bucketName"`
+	} `json:"allowed"`
+}
+
+// apiError is the error response of the B2 API.
+type apiError struct {
+	Status  int    `json:"status"`
+	Code    string `json:"code"`
+	Message string `json:"message"`
+
+	// RequestID is the X-Bz-Request-Id header of the response, if any.
+	RequestID string `json:"-"`
+}
+
+func (e *apiError) Error() string {
+	if e.RequestID != "" {
+		return fmt.Sprintf("b2: %s (status %d, request %s): %s",
+			e.Code, e.Status, e.RequestID, e.Message)
+	}
+	return fmt.Sprintf("b2: %s (status %d): %s", e.Code, e.Status, e.Message)
+}
+
+// fileInfo is a file (or folder) as returned by the listing API calls.
+type fileInfo struct {
+	FileID        string `json:"fileId"`
+	FileName      string `json:"fileName"`
+	Action        string `json:"action"`
+	ContentLength int64  `json:"contentLength"`
+}
+
+// client performs calls to the native B2 API. It authorizes lazily, and re-authorizes once when
+// the authorization token has expired.
+type client struct {
+	authURL  string
+	keyID    string
+	appKey   string
+	bucket   string
+	http     *http.Client
+	mutex    sync.Mutex
+	session  *session
+	bucketID string
+}
+
+// authorize returns the current session, authorizing the account if needed.
+func (c *client) authorize(renew bool) (*session, string, error) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	if c.session != nil && !renew {
+		return c.session, c.bucketID, nil
+	}
+
+	req, err := http.NewRequest(http.MethodGet,
+		strings.TrimSuffix(c.authURL, "/")+"/b2api/v2/b2_authorize_account", nil)
+	if err != nil {
+		return nil, "", err
+	}
+	req.SetBasicAuth(c.keyID, c.appKey)
+
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return nil, "", err
+	}
+	defer resp.Body.Close()
+
+	sess := &session{}
+	if err := decodeResponse(resp, sess); err != nil {
+		return nil, "", err
+	}
+
+	bucketID := sess.Allowed.BucketID
+	if bucketID == "" || sess.Allowed.BucketName != c.bucket {
+		bucketID, err = c.lookupBucket(sess)
+		if err != nil {
+			return nil, "", err
+		}
+	}
+
+	c.session = sess
+	c.bucketID = bucketID
+	return sess, bucketID, nil
+}
+
+// lookupBucket returns the ID of the configured bucket.
+func (c *client) lookupBucket(sess *session) (string, error) {
+	var result struct {
+		Buckets []struct {
+			BucketID   string `json:"bucketId"`
+			BucketName string `json:"bucketName"`
+		} `json:"buckets"`
+	}
+	request := map[string]string{"accountId": sess.AccountID, "bucketName": c.bucket}
+	if err := c.post(sess, "b2_list_buckets", request, &result); err != nil {
+		return "", err
+	}
+
+	for _, bucket := range result.Buckets {
+		if bucket.BucketName == c.bucket {
+			return bucket.BucketID, nil
+		}
+	}
+	return "", fmt.Errorf("b2: bucket %s does not exist", c.bucket)
+}
+
+// post calls an API function with a JSON request within a session.
+func (c *client) post(sess *session, function string, request, result interface{}) error {
+	body, err := json.Marshal(request)
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequest(http.MethodPost, sess.APIURL+"/b2api/v2/"+function,
+		bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Authorization", sess.AuthorizationToken)
+
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	return decodeResponse(resp, result)
+}
+
+// call calls an API function, and re-authorizes once if the authorization token has expired.
+func (c *client) call(function string, request func(bucketID string) interface{},
+	result interface{}) error {
+
+	sess, bucketID, err := c.authorize(false)
+	if err != nil {
+		return err
+	}
+
+	err = c.post(sess, function, request(bucketID), result)
+	if isExpired(err) {
+		if sess, bucketID, err = c.authorize(true); err != nil {
+			return err
+		}
+		err = c.post(sess, function, request(bucketID), result)
+	}
+	return err
+}
+
+// download performs a GET or HEAD request for a file. The caller must close the response body.
+// A nil response without error indicates that the file doesn't exist.
+func (c *client) download(method, fileName string) (*http.Response, error) {
+	for attempt := 0; ; attempt++ {
+		sess, _, err := c.authorize(attempt > 0)
+		if err != nil {
+			return nil, err
+		}
+
+		fileURL := sess.DownloadURL + "/file/" + url.PathEscape(c.bucket) + "/" +
+			escapeFileName(fileName)
+		req, err := http.NewRequest(method, fileURL, nil)
+		if err != nil {
+			return nil, err
+		}
+		req.Header.Set("Authorization", sess.AuthorizationToken)
+
+		resp, err := c.http.Do(req)
+		if err != nil {
+			return nil, err
+		}
+
+		switch {
+		case resp.StatusCode == http.StatusOK:
+			return resp, nil
+		case resp.StatusCode == http.StatusNotFound:
+			resp.Body.Close()
+			return nil, nil
+		}
+
+		err = decodeResponse(resp, nil)
+		resp.Body.Close()
+		if !isExpired(err) || attempt > 0 {
+			return nil, err
+		}
+	}
+}
+
+// upload uploads a complete file in a single request.
+func (c *client) upload(fileName string, data []byte) error {
+	var target struct {
+		UploadURL          string `json:"uploadUrl"`
+		AuthorizationToken string `json:"authorizationToken"`
+	}
+	request := func(bucketID string) interface{} {
+		return map[string]string{"bucketId": bucketID}
+	}
+	if err := c.call("b2_get_upload_url", request, &target); err != nil {
+		return err
+	}
+
+	headers := map[string]string{
+		"X-Bz-File-Name":    escapeFileName(fileName),
+		"Content-Type":      "b2/x-auto",
+		"X-Bz-Content-Sha1": sha1Hex(data),
+	}
+	return c.send(target.UploadURL, target.AuthorizationToken, headers, data, nil)
+}
+
+// uploadLarge uploads a file in parts with the large file API.
+func (c *client) uploadLarge(fileName string, data []byte, partSize int64) error {
+	var started struct {
+		FileID string `json:"fileId"`
+	}
+	request := func(bucketID string) interface{} {
+		return map[string]string{
+			"bucketId":    bucketID,
+			"fileName":    fileName,
+			"contentType": "b2/x-auto",
+		}
+	}
+	if err := c.call("b2_start_large_file", request, &started); err != nil {
+		return err
+	}
+
+	err := c.uploadParts(started.FileID, data, partSize)
+	if err != nil {
+		// Don't leave the unfinished upload (and its storage costs) behind
+		cancel := func(string) interface{} { return map[string]string{"fileId": started.FileID} }
+		c.call("b2_cancel_large_file", cancel, nil)
+	}
+	return err
+}
+
+func (c *client) uploadParts(fileID string, data []byte, partSize int64) error {
+	var target struct {
+		UploadURL          string `json:"uploadUrl"`
+		AuthorizationToken string `json:"authorizationToken"`
+	}
+	request := func(string) interface{} { return map[string]string{"fileId": fileID} }
+	if err := c.call("b2_get_upload_part_url", request, &target); err != nil {
+		return err
+	}
+
+	sha1s := []string{}
+	for start, partNumber := int64(0), 1; start < int64(len(data)); partNumber++ {
+		end := start + partSize
+		if end > int64(len(data)) {
+			end = int64(len(data))
+		}
+		part := data[start:end]
+
+		sum := sha1Hex(part)
+		headers := map[string]string{
+			"X-Bz-Part-Number":  strconv.Itoa(partNumber),
+			"X-Bz-Content-Sha1": sum,
+		}
+		err := c.send(target.UploadURL, target.AuthorizationToken, headers, part, nil)
+		if err != nil {
+			return err
+		}
+
+		sha1s = append(sha1s, sum)
+		start = end
+	}
+
+	finish := func(string) interface{} {
+		return map[string]interface{}{"fileId": fileID, "partSha1Array": sha1s}
+	}
+	return c.call("b2_finish_large_file", finish, nil)
+}
+
+// send posts data to an upload URL.
+func (c *client) send(uploadURL, token string, headers map[string]string, data []byte,
+	result interface{}) error {
+
+	req, err := http.NewRequest(http.MethodPost, uploadURL, bytes.NewReader(data))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Authorization", token)
+	for key, value := range headers {
+		req.Header.Set(key, value)
+	}
+
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	return decodeResponse(resp, result)
+}
+
+// listFileNames lists the file names (and folders) directly within a prefix.
+func (c *client) listFileNames(prefix string) ([]fileInfo, error) {
+	files := []fileInfo{}
+	startFileName := ""
+	for {
+		var result struct {
+			Files        []fileInfo `json:"files"`
+			NextFileName *string    `json:"nextFileName"`
+		}
+		request := func(bucketID string) interface{} {
+			req := map[string]interface{}{
+				"bucketId":     bucketID,
+				"prefix":       prefix,
+				"delimiter":    "/",
+				"maxFileCount": 1000,
+			}
+			if startFileName != "" {
+				req["startFileName"] = startFileName
+			}
+			return req
+		}
+		if err := c.call("b2_list_file_names", request, &result); err != nil {
+			return nil, err
+		}
+
+		files = append(files, result.Files...)
+		if result.NextFileName == nil || *result.NextFileName == "" {
+			return files, nil
+		}
+		startFileName = *result.NextFileName
+	}
+}
+
+// listFileVersions lists all versions of a single file.
+func (c *client) listFileVersions(fileName string) ([]fileInfo, error) {
+	versions := []fileInfo{}
+	startFileName := fileName
+	startFileID := ""
+	for {
+		var result struct {
+			Files        []fileInfo `json:"files"`
+			NextFileName *string    `json:"nextFileName"`
+			NextFileID   *string    `json:"nextFileId"`
+		}
+		request := func(bucketID string) interface{} {
+			req := map[string]interface{}{
+				"bucketId":      bucketID,
+				"startFileName": startFileName,
+				"prefix":        fileName,
+				"maxFileCount":  1000,
+			}
+			if startFileID != "" {
+				req["startFileId"] = startFileID
+			}
+			return req
+		}
+		if err := c.call("b2_list_file_versions", request, &result); err != nil {
+			return nil, err
+		}
+
+		for _, file := range result.Files {
+			if file.FileName == fileName {
+				versions = append(versions, file)
+			}
+		}
+		if result.NextFileName == nil || *result.NextFileName != fileName || result.NextFileID == nil {
+			return versions, nil
+		}
+		startFileID = *result.NextFileID
+	}
+}
+
+// decodeResponse decodes a successful response into result (if not nil), or returns the error in
+// an unsuccessful response. Throttling responses result in a stor.TemporaryError.
+func decodeResponse(resp *http.Response, result interface{}) error {
+	if resp.StatusCode != http.StatusOK {
+		apiErr := &apiError{Status: resp.StatusCode}
+		body, _ := ioutil.ReadAll(io.LimitReader(resp.Body, 4096))
+		if json.Unmarshal(body, apiErr) != nil || apiErr.Code == "" {
+			apiErr.Code = "unknown"
+			apiErr.Message = strings.TrimSpace(string(body))
+		}
+		apiErr.Status = resp.StatusCode
+		apiErr.RequestID = resp.Header.Get("X-Bz-Request-Id")
+		return stor.HTTPTemporaryError(resp, apiErr)
+	}
+
+	if result == nil {
+		io.Copy(ioutil.Discard, resp.Body)
+		return nil
+	}
+	return json.NewDecoder(resp.Body).Decode(result)
+}
+
+// isExpired checks whether err indicates an expired authorization token.
+func isExpired(err error) bool {
+	var apiErr *apiError
+	return errors.As(err, &apiErr) && apiErr.Status == http.StatusUnauthorized &&
+		apiErr.Code == "expired_auth_token"
+}
+
+// escapeFileName percent-encodes a file name for use in URLs and headers, keeping the slashes.
+func escapeFileName(fileName string) string {
+	parts := strings.Split(fileName, "/")
+	for i, part := range parts {
+		parts[i] = url.PathEscape(part)
+	}
+	return strings.Join(parts, "/")
+}
+
+func sha1Hex(data []byte) string {
+	sum := sha1.Sum(data)
+	return hex.EncodeToString(sum[:])
+}
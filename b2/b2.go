@@ -0,0 +1,388 @@
+// Package b2 implements the stor.Storage interface on top of Backblaze B2, using the native B2
+// API (not the S3 compatible API).
+//
+// The following stor.Conf fields are used:
+//
+//	Path                 Bucket name, optionally followed by a key prefix (e.g. "bucket/prefix").
+//	Options["keyid"]     Application key ID. Defaults to Credentials.AccessKey.
+//	Options["key"]       Application key. Defaults to Credentials.Secret.
+//	Options["authurl"]   URL of the B2 authorization API. Defaults to DefaultAuthURL.
+//	Options["partsize"]  Part size in bytes for large file uploads. Files that are larger than one
+//	                     part are uploaded with the large file API. Defaults to the size that is
+//	                     recommended by B2.
+//	Options["delete"]    Delete mode: "hide" (default) hides a file, so that the lifecycle rules
+//	                     of the bucket decide when old versions are removed. "versions" removes
+//	                     all versions of a file immediately.
+//
+// The HTTP client is configured with the options of stor.NewHTTPClient, e.g. "maxconnsperhost".
+//
+// With stor.NewFromEnv, the options are read from the variables STOR_B2_<OPTION>, e.g.
+// STOR_B2_KEYID and STOR_B2_KEY. Alternatively, the key can be passed as Credentials, e.g. with
+// STOR_ACCESS_KEY and STOR_SECRET_FILE, so it doesn't show up in the options.
+package b2
+
+import (
+	"errors"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"github.com/pw1/stor"
+)
+
+const (
+	// B2StorageType is the storage type of the B2 storage.
+	B2StorageType stor.Type = "B2"
+
+	// URLScheme is the scheme of B2 storage URLs (see stor.NewFromURL), e.g.
+	// "b2://bucket/prefix?keyid=...&key=...". The options are passed as query parameters.
+	URLScheme = "b2"
+
+	// DefaultAuthURL is the URL of the B2 authorization API.
+	DefaultAuthURL = "https://api.backblazeb2.com"
+
+	// DeleteHide is the delete mode that hides files.
+	DeleteHide = "hide"
+
+	// DeleteVersions is the delete mode that removes all versions of a file.
+	DeleteVersions = "versions"
+)
+
+func init() {
+	newStorageFunc := func(conf *stor.Conf) (stor.Storage, error) {
+		return New(conf)
+	}
+	stor.RegisterType(B2StorageType, newStorageFunc)
+	stor.RegisterValidator(B2StorageType, Validate)
+	stor.RegisterInfo(stor.Info{
+		Type:        B2StorageType,
+		Description: "Backblaze B2 bucket",
+		Path:        "Bucket name, optionally followed by a key prefix (e.g. \"bucket/prefix\")",
+		Options: append([]stor.OptionInfo{
+			{Name: "keyid", Description: "Application key ID. Defaults to Credentials.AccessKey."},
+			{Name: "key", Description: "Application key. Defaults to Credentials.Secret.",
+				Secret: true},
+			{Name: "authurl", Description: "URL of the authorization API. Defaults to " +
+				DefaultAuthURL + "."},
+			{Name: "partsize", Description: "Part size in bytes for large file uploads. " +
+				"Defaults to the size that is recommended by B2."},
+			{Name: "delete", Description: "Delete mode: \"hide\" (default) or \"versions\""},
+		}, stor.HTTPOptionInfos...),
+		Capabilities: []stor.Capability{stor.CapabilityPersistent},
+	})
+	stor.RegisterScheme(URLScheme, B2StorageType, nil)
+}
+
+// B2 is a stor.Storage implementation that stores files in a Backblaze B2 bucket.
+type B2 struct {
+	client     *client
+	prefix     string
+	partSize   int64
+	deleteMode string
+}
+
+// Validate checks a configuration of the B2 storage without making any requests.
+func Validate(conf *stor.Conf) error {
+	bucketPath, err := stor.CleanPath(conf.Path)
+	if err != nil {
+		return err
+	}
+	if bucketPath == "" {
+		return &stor.ConfError{Type: B2StorageType, Field: "Path", Msg: "must specify a bucket"}
+	}
+
+	if keyID(conf) == "" {
+		return &stor.ConfError{Type: B2StorageType, Field: "Options[keyid]",
+			Msg: "or Credentials.AccessKey is required"}
+	}
+	if appKey(conf) == "" {
+		return &stor.ConfError{Type: B2StorageType, Field: "Options[key]",
+			Msg: "or Credentials.Secret is required"}
+	}
+
+	if value := conf.Options["authurl"]; value != "" {
+		authURL, err := url.Parse(value)
+		if err != nil || (authURL.Scheme != "http" && authURL.Scheme != "https") {
+			return &stor.ConfError{Type: B2StorageType, Field: "Options[authurl]",
+				Msg: "must be an http or https URL"}
+		}
+	}
+
+	if value := conf.Options["partsize"]; value != "" {
+		partSize, err := strconv.ParseInt(value, 10, 64)
+		if err != nil || partSize <= 0 {
+			return &stor.ConfError{Type: B2StorageType, Field: "Options[partsize]",
+				Msg: "must be a positive number of bytes"}
+		}
+	}
+
+	switch conf.Options["delete"] {
+	case "", DeleteHide, DeleteVersions:
+	default:
+		return &stor.ConfError{Type: B2StorageType, Field: "Options[delete]",
+			Msg: "must be " + DeleteHide + " or " + DeleteVersions}
+	}
+
+	return stor.ValidateHTTPOptions(B2StorageType, conf)
+}
+
+// keyID returns the application key ID of a configuration.
+func keyID(conf *stor.Conf) string {
+	if id := conf.Options["keyid"]; id != "" {
+		return id
+	}
+	return conf.Credentials.AccessKey
+}
+
+// appKey returns the application key of a configuration.
+func appKey(conf *stor.Conf) string {
+	if key := conf.Options["key"]; key != "" {
+		return key
+	}
+	return conf.Credentials.Secret
+}
+
+// New creates a new B2 storage. No requests are made until the storage is used.
+func New(conf *stor.Conf) (*B2, error) {
+	if err := Validate(conf); err != nil {
+		return nil, err
+	}
+
+	bucketPath, _ := stor.CleanPath(conf.Path)
+	bucket, prefix := bucketPath, ""
+	if idx := strings.IndexByte(bucketPath, '/'); idx >= 0 {
+		bucket, prefix = bucketPath[:idx], bucketPath[idx+1:]
+	}
+
+	authURL := conf.Options["authurl"]
+	if authURL == "" {
+		authURL = DefaultAuthURL
+	}
+
+	var partSize int64
+	if value := conf.Options["partsize"]; value != "" {
+		partSize, _ = strconv.ParseInt(value, 10, 64)
+	}
+
+	deleteMode := conf.Options["delete"]
+	if deleteMode == "" {
+		deleteMode = DeleteHide
+	}
+
+	httpClient, _ := stor.NewHTTPClient(B2StorageType, conf)
+
+	b := &B2{
+		client: &client{
+			authURL: authURL,
+			keyID:   keyID(conf),
+			appKey:  appKey(conf),
+			bucket:  bucket,
+			http:    httpClient,
+		},
+		prefix:     prefix,
+		partSize:   partSize,
+		deleteMode: deleteMode,
+	}
+	return b, nil
+}
+
+// fileName returns the B2 file name of a cleaned path.
+func (b *B2) fileName(cleanPath string) string {
+	if b.prefix == "" {
+		return cleanPath
+	}
+	if cleanPath == "" {
+		return b.prefix
+	}
+	return b.prefix + "/" + cleanPath
+}
+
+// opError wraps an error of the B2 API in a stor.OpError, with the request ID of the failed
+// request. Like in the other HTTP backends, the stor.TemporaryError or
+// stor.StorageUnavailableError of a response that may succeed if it is retried wraps the OpError.
+func opError(op, cleanPath string, err error) error {
+	wrap := func(err error) error {
+		opErr := &stor.OpError{Op: op, Backend: B2StorageType, Path: cleanPath, Err: err}
+		var apiErr *apiError
+		if errors.As(err, &apiErr) {
+			opErr.RequestID = apiErr.RequestID
+		}
+		return opErr
+	}
+
+	switch e := err.(type) {
+	case *stor.TemporaryError:
+		return &stor.TemporaryError{Err: wrap(e.Err), RetryAfter: e.RetryAfter}
+	case *stor.StorageUnavailableError:
+		return &stor.StorageUnavailableError{Err: wrap(e.Err)}
+	default:
+		return wrap(err)
+	}
+}
+
+// Type returns B2StorageType.
+func (b *B2) Type() stor.Type {
+	return B2StorageType
+}
+
+// Meta returns meta information about a file.
+func (b *B2) Meta(filePath string) (*stor.Meta, error) {
+	cleanPath, err := stor.CleanPath(filePath)
+	if err != nil {
+		return nil, err
+	}
+
+	resp, err := b.client.download(http.MethodHead, b.fileName(cleanPath))
+	if err != nil {
+		return nil, opError(stor.OpMeta, cleanPath, err)
+	}
+	if resp == nil {
+		return nil, &stor.PathDoesntExistError{Path: cleanPath}
+	}
+	resp.Body.Close()
+
+	meta := &stor.Meta{
+		Size: resp.ContentLength,
+	}
+	return meta, nil
+}
+
+// List returns the files and subdirectories within the specified directory.
+func (b *B2) List(dirPath string) ([]string, []string, error) {
+	cleanPath, err := stor.CleanPath(dirPath)
+	if err != nil {
+		return []string{}, []string{}, err
+	}
+
+	prefix := b.fileName(cleanPath)
+	if prefix != "" {
+		prefix += "/"
+	}
+
+	entries, err := b.client.listFileNames(prefix)
+	if err != nil {
+		return []string{}, []string{}, opError(stor.OpList, cleanPath, err)
+	}
+
+	files := []string{}
+	dirs := []string{}
+	for _, entry := range entries {
+		name := strings.TrimSuffix(strings.TrimPrefix(entry.FileName, prefix), "/")
+		if name == "" {
+			continue
+		}
+
+		entryPath := name
+		if cleanPath != "" {
+			entryPath = cleanPath + "/" + name
+		}
+
+		switch entry.Action {
+		case "folder":
+			dirs = append(dirs, entryPath)
+		case "upload":
+			files = append(files, entryPath)
+		}
+	}
+
+	return files, dirs, nil
+}
+
+// Load loads the content of the specified file. If the file is larger than maxSize, the an error is
+// returned.
+func (b *B2) Load(filePath string, maxSize int64) ([]byte, error) {
+	cleanPath, err := stor.CleanPath(filePath)
+	if err != nil {
+		return []byte{}, err
+	}
+
+	resp, err := b.client.download(http.MethodGet, b.fileName(cleanPath))
+	if err != nil {
+		return []byte{}, opError(stor.OpLoad, cleanPath, err)
+	}
+	if resp == nil {
+		return []byte{}, &stor.PathDoesntExistError{Path: cleanPath}
+	}
+	defer resp.Body.Close()
+
+	if resp.ContentLength > maxSize {
+		return []byte{}, &stor.TooLargeError{What: cleanPath}
+	}
+
+	return stor.ReadAllMax(resp.Body, maxSize, cleanPath)
+}
+
+// Save saves the data to the specified file. Files that are larger than the part size are
+// uploaded in multiple parts.
+func (b *B2) Save(filePath string, data []byte) error {
+	cleanPath, err := stor.CleanPath(filePath)
+	if err != nil {
+		return err
+	}
+
+	partSize := b.partSize
+	if partSize == 0 {
+		sess, _, err := b.client.authorize(false)
+		if err != nil {
+			return opError(stor.OpSave, cleanPath, err)
+		}
+		partSize = sess.RecommendedPart
+	}
+
+	if partSize > 0 && int64(len(data)) > partSize {
+		err = b.client.uploadLarge(b.fileName(cleanPath), data, partSize)
+	} else {
+		err = b.client.upload(b.fileName(cleanPath), data)
+	}
+	if err != nil {
+		return opError(stor.OpSave, cleanPath, err)
+	}
+	return nil
+}
+
+// Delete removes a file from storage. Depending on the delete mode, the file is either hidden or
+// all its versions are removed.
+func (b *B2) Delete(filePath string) error {
+	cleanPath, err := stor.CleanPath(filePath)
+	if err != nil {
+		return err
+	}
+
+	if _, err := b.Meta(cleanPath); err != nil {
+		return err
+	}
+
+	fileName := b.fileName(cleanPath)
+	if b.deleteMode == DeleteHide {
+		request := func(bucketID string) interface{} {
+			return map[string]string{"bucketId": bucketID, "fileName": fileName}
+		}
+		err := b.client.call("b2_hide_file", request, nil)
+		// The file may have been hidden by someone else since the call to Meta
+		var apiErr *apiError
+		if errors.As(err, &apiErr) &&
+			(apiErr.Code == "no_such_file" || apiErr.Code == "already_hidden") {
+			return &stor.PathDoesntExistError{Path: cleanPath}
+		}
+		if err != nil {
+			return opError(stor.OpDelete, cleanPath, err)
+		}
+		return nil
+	}
+
+	versions, err := b.client.listFileVersions(fileName)
+	if err != nil {
+		return opError(stor.OpDelete, cleanPath, err)
+	}
+	for _, version := range versions {
+		request := func(string) interface{} {
+			return map[string]string{"fileName": fileName, "fileId": version.FileID}
+		}
+		if err := b.client.call("b2_delete_file_version", request, nil); err != nil {
+			return opError(stor.OpDelete, cleanPath, err)
+		}
+	}
+
+	return nil
+}
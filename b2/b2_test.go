@@ -0,0 +1,519 @@
+package b2
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/suite"
+
+	"github.com/pw1/stor"
+	"github.com/pw1/stor/memory"
+	"github.com/pw1/stor/tester"
+)
+
+// version is a single version of a file in the fakeB2 server.
+type version struct {
+	id     string
+	data   []byte
+	hidden bool
+}
+
+// fakeB2 emulates the parts of the native B2 API that are used by the B2 storage.
+type fakeB2 struct {
+	mutex      sync.Mutex
+	url        string
+	token      int
+	expireNext bool
+	throttle   bool
+	pageSize   int
+	nextID     int
+	files      map[string][]*version
+	large      map[string][][]byte
+	largeNames map[string]string
+	calls      []string
+}
+
+func newFakeB2() *fakeB2 {
+	f := &fakeB2{}
+	f.reset()
+	return f
+}
+
+func (f *fakeB2) reset() {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	f.pageSize = 1000
+	f.files = make(map[string][]*version)
+	f.large = make(map[string][][]byte)
+	f.largeNames = make(map[string]string)
+	f.calls = nil
+}
+
+func (f *fakeB2) newID() string {
+	f.nextID++
+	return fmt.Sprintf("id%d", f.nextID)
+}
+
+func (f *fakeB2) visible(name string) *version {
+	versions := f.files[name]
+	if len(versions) == 0 || versions[len(versions)-1].hidden {
+		return nil
+	}
+	return versions[len(versions)-1]
+}
+
+func (f *fakeB2) fail(w http.ResponseWriter, status int, code string) {
+	w.Header().Set("X-Bz-Request-Id", "req-"+code)
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"status": status, "code": code, "message": code,
+	})
+}
+
+func (f *fakeB2) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+
+	if r.URL.Path == "/b2api/v2/b2_authorize_account" {
+		keyID, key, _ := r.BasicAuth()
+		if keyID != "keyid" || key != "key" {
+			f.fail(w, http.StatusUnauthorized, "unauthorized")
+			return
+		}
+		f.token++
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"accountId":               "account",
+			"authorizationToken":      "token" + strconv.Itoa(f.token),
+			"apiUrl":                  f.url,
+			"downloadUrl":             f.url,
+			"recommendedPartSize":     100,
+			"absoluteMinimumPartSize": 5,
+		})
+		return
+	}
+
+	if r.Header.Get("Authorization") != "token"+strconv.Itoa(f.token) {
+		f.fail(w, http.StatusUnauthorized, "bad_auth_token")
+		return
+	}
+	if f.expireNext {
+		f.expireNext = false
+		f.fail(w, http.StatusUnauthorized, "expired_auth_token")
+		return
+	}
+	if f.throttle {
+		w.Header().Set("Retry-After", "2")
+		f.fail(w, http.StatusTooManyRequests, "too_many_requests")
+		return
+	}
+
+	if strings.HasPrefix(r.URL.Path, "/file/bucket/") {
+		name, _ := url.PathUnescape(strings.TrimPrefix(r.URL.Path, "/file/bucket/"))
+		v := f.visible(name)
+		if v == nil {
+			f.fail(w, http.StatusNotFound, "not_found")
+			return
+		}
+		w.Header().Set("Content-Length", strconv.Itoa(len(v.data)))
+		if r.Method == http.MethodGet {
+			w.Write(v.data)
+		}
+		return
+	}
+
+	body, _ := ioutil.ReadAll(r.Body)
+	var req map[string]interface{}
+	json.Unmarshal(body, &req)
+	str := func(key string) string {
+		value, _ := req[key].(string)
+		return value
+	}
+
+	function := strings.TrimPrefix(r.URL.Path, "/b2api/v2/")
+	f.calls = append(f.calls, function)
+
+	switch function {
+	case "upload":
+		name, _ := url.PathUnescape(r.Header.Get("X-Bz-File-Name"))
+		if sha1Hex(body) != r.Header.Get("X-Bz-Content-Sha1") {
+			f.fail(w, http.StatusBadRequest, "bad_request")
+			return
+		}
+		f.files[name] = append(f.files[name], &version{id: f.newID(), data: body})
+		json.NewEncoder(w).Encode(map[string]string{"fileName": name})
+
+	case "upload_part":
+		fileID := r.URL.Query().Get("fileId")
+		part, _ := strconv.Atoi(r.Header.Get("X-Bz-Part-Number"))
+		if part != len(f.large[fileID])+1 || sha1Hex(body) != r.Header.Get("X-Bz-Content-Sha1") {
+			f.fail(w, http.StatusBadRequest, "bad_request")
+			return
+		}
+		f.large[fileID] = append(f.large[fileID], body)
+		json.NewEncoder(w).Encode(map[string]string{"fileId": fileID})
+
+	case "b2_list_buckets":
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"buckets": []map[string]string{{"bucketId": "bucketid", "bucketName": "bucket"}},
+		})
+
+	case "b2_get_upload_url":
+		json.NewEncoder(w).Encode(map[string]string{
+			"uploadUrl":          f.url + "/b2api/v2/upload",
+			"authorizationToken": "token" + strconv.Itoa(f.token),
+		})
+
+	case "b2_start_large_file":
+		fileID := f.newID()
+		f.largeNames[fileID] = str("fileName")
+		json.NewEncoder(w).Encode(map[string]string{"fileId": fileID})
+
+	case "b2_get_upload_part_url":
+		json.NewEncoder(w).Encode(map[string]string{
+			"uploadUrl":          f.url + "/b2api/v2/upload_part?fileId=" + str("fileId"),
+			"authorizationToken": "token" + strconv.Itoa(f.token),
+		})
+
+	case "b2_finish_large_file":
+		fileID := str("fileId")
+		name := f.largeNames[fileID]
+		data := bytes.Join(f.large[fileID], nil)
+		f.files[name] = append(f.files[name], &version{id: fileID, data: data})
+		json.NewEncoder(w).Encode(map[string]string{"fileId": fileID})
+
+	case "b2_cancel_large_file":
+		delete(f.large, str("fileId"))
+		json.NewEncoder(w).Encode(map[string]string{"fileId": str("fileId")})
+
+	case "b2_list_file_names":
+		f.listFileNames(w, str("prefix"), str("delimiter"), str("startFileName"))
+
+	case "b2_list_file_versions":
+		files := []map[string]interface{}{}
+		for _, v := range f.files[str("prefix")] {
+			action := "upload"
+			if v.hidden {
+				action = "hide"
+			}
+			files = append(files, map[string]interface{}{
+				"fileId": v.id, "fileName": str("prefix"), "action": action,
+			})
+		}
+		json.NewEncoder(w).Encode(map[string]interface{}{"files": files})
+
+	case "b2_hide_file":
+		if f.visible(str("fileName")) == nil {
+			f.fail(w, http.StatusBadRequest, "no_such_file")
+			return
+		}
+		f.files[str("fileName")] = append(f.files[str("fileName")],
+			&version{id: f.newID(), hidden: true})
+		json.NewEncoder(w).Encode(map[string]string{"fileName": str("fileName")})
+
+	case "b2_delete_file_version":
+		versions := f.files[str("fileName")]
+		for i, v := range versions {
+			if v.id == str("fileId") {
+				f.files[str("fileName")] = append(versions[:i], versions[i+1:]...)
+				break
+			}
+		}
+		json.NewEncoder(w).Encode(map[string]string{"fileId": str("fileId")})
+
+	default:
+		f.fail(w, http.StatusBadRequest, "bad_request")
+	}
+}
+
+func (f *fakeB2) listFileNames(w http.ResponseWriter, prefix, delimiter, start string) {
+	entries := make(map[string]map[string]interface{})
+	for name := range f.files {
+		if f.visible(name) == nil || !strings.HasPrefix(name, prefix) {
+			continue
+		}
+		rest := name[len(prefix):]
+		if idx := strings.Index(rest, delimiter); delimiter != "" && idx >= 0 {
+			folder := prefix + rest[:idx+1]
+			entries[folder] = map[string]interface{}{"fileName": folder, "action": "folder"}
+		} else {
+			entries[name] = map[string]interface{}{
+				"fileName": name, "action": "upload", "contentLength": len(f.visible(name).data),
+			}
+		}
+	}
+
+	names := []string{}
+	for name := range entries {
+		if name >= start {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+
+	result := map[string]interface{}{"files": []interface{}{}, "nextFileName": nil}
+	files := []interface{}{}
+	for i, name := range names {
+		if i == f.pageSize {
+			result["nextFileName"] = name
+			break
+		}
+		files = append(files, entries[name])
+	}
+	result["files"] = files
+	json.NewEncoder(w).Encode(result)
+}
+
+func newTestConf(serverURL string, options map[string]string) *stor.Conf {
+	conf := &stor.Conf{
+		Type: B2StorageType,
+		Path: "bucket/prefix",
+		Options: map[string]string{
+			"authurl": serverURL,
+			"keyid":   "keyid",
+			"key":     "key",
+		},
+	}
+	for key, value := range options {
+		conf.Options[key] = value
+	}
+	return conf
+}
+
+// TestB2StorageTester calls the generic storage tests against a fake B2 server.
+func TestB2StorageTester(t *testing.T) {
+	fake := newFakeB2()
+	server := httptest.NewServer(fake)
+	defer server.Close()
+	fake.url = server.URL
+
+	for _, deleteMode := range []string{DeleteHide, DeleteVersions} {
+		testSuite := &tester.StorageTester{
+			ConfFactory: func() *stor.Conf {
+				return newTestConf(server.URL, map[string]string{"delete": deleteMode})
+			},
+			SetupTestFunc: func(s *tester.StorageTester) { fake.reset() },
+			ReferenceFactory: func() stor.Storage {
+				m, _ := memory.New(&stor.Conf{})
+				return m
+			},
+		}
+		t.Run(deleteMode, func(t *testing.T) {
+			suite.Run(t, testSuite)
+		})
+	}
+
+	// Files of 8 MiB are uploaded in 8 parts
+	testSuite := &tester.StorageTester{
+		ConfFactory: func() *stor.Conf {
+			return newTestConf(server.URL, map[string]string{"partsize": "1048576"})
+		},
+		SetupTestFunc: func(s *tester.StorageTester) { fake.reset() },
+		LargeFileSize: 8 << 20,
+	}
+	t.Run("large", func(t *testing.T) {
+		suite.Run(t, testSuite)
+	})
+}
+
+func TestB2Suite(t *testing.T) {
+	suite.Run(t, new(B2Suite))
+}
+
+// B2Suite contains tests that are specific for the B2 storage.
+type B2Suite struct {
+	suite.Suite
+	fake   *fakeB2
+	server *httptest.Server
+}
+
+func (s *B2Suite) SetupTest() {
+	s.fake = newFakeB2()
+	s.server = httptest.NewServer(s.fake)
+	s.fake.url = s.server.URL
+}
+
+func (s *B2Suite) TearDownTest() {
+	s.server.Close()
+}
+
+func (s *B2Suite) newB2(options map[string]string) *B2 {
+	b, err := New(newTestConf(s.server.URL, options))
+	s.Require().Nil(err)
+	return b
+}
+
+func (s *B2Suite) TestNewInvalidConf() {
+	b, err := New(&stor.Conf{})
+	s.Nil(b)
+	s.NotNil(err)
+
+	b, err = New(&stor.Conf{Path: "../bucket"})
+	s.Nil(b)
+	s.True(stor.IsInvalidPathError(err))
+
+	b, err = New(&stor.Conf{Path: "bucket", Options: map[string]string{"partsize": "-1"}})
+	s.Nil(b)
+	s.NotNil(err)
+
+	b, err = New(&stor.Conf{Path: "bucket", Options: map[string]string{"delete": "shred"}})
+	s.Nil(b)
+	s.NotNil(err)
+}
+
+func (s *B2Suite) TestValidate() {
+	table := map[string]*stor.Conf{
+		"Path":              {Type: B2StorageType},
+		"Options[keyid]":    {Type: B2StorageType, Path: "bucket"},
+		"Options[key]":      newTestConf(s.server.URL, map[string]string{"key": ""}),
+		"Options[authurl]":  newTestConf(s.server.URL, map[string]string{"authurl": "ftp://b2"}),
+		"Options[partsize]": newTestConf(s.server.URL, map[string]string{"partsize": "big"}),
+		"Options[delete]":   newTestConf(s.server.URL, map[string]string{"delete": "shred"}),
+	}
+	for field, conf := range table {
+		err := stor.Validate(conf)
+		s.True(stor.IsConfError(err), field)
+		if confErr, ok := err.(*stor.ConfError); ok {
+			s.Equal(field, confErr.Field)
+			s.Equal(B2StorageType, confErr.Type)
+		}
+	}
+
+	s.Nil(stor.Validate(newTestConf(s.server.URL, nil)))
+}
+
+func (s *B2Suite) TestCredentials() {
+	conf := newTestConf(s.server.URL, map[string]string{"keyid": "", "key": ""})
+	conf.Credentials = stor.Credentials{AccessKey: "keyid", Secret: "key"}
+	b, err := New(conf)
+	s.Require().Nil(err)
+	s.Nil(b.Save("file1", []byte("test")))
+
+	s.NotContains(fmt.Sprint(conf), "Secret:key")
+	s.NotContains(fmt.Sprint(newTestConf(s.server.URL, nil)), "key:key")
+}
+
+func (s *B2Suite) TestUnauthorized() {
+	b := s.newB2(map[string]string{"key": "wrong"})
+	err := b.Save("file1", []byte("test"))
+	s.NotNil(err)
+	s.Contains(err.Error(), "unauthorized")
+	s.Contains(err.Error(), "request req-unauthorized")
+
+	var opErr *stor.OpError
+	s.Require().True(errors.As(err, &opErr))
+	s.Equal(stor.OpSave, opErr.Op)
+	s.Equal(B2StorageType, opErr.Backend)
+	s.Equal("file1", opErr.Path)
+	s.Equal("req-unauthorized", opErr.RequestID)
+	var apiErr *apiError
+	s.Require().True(errors.As(err, &apiErr))
+	s.Equal("unauthorized", apiErr.Code)
+}
+
+// TestLargeFile verifies that files larger than the part size are uploaded in parts.
+func (s *B2Suite) TestLargeFile() {
+	b := s.newB2(map[string]string{"partsize": "10"})
+	data := bytes.Repeat([]byte("0123456789"), 5)
+	data = append(data, 'x')
+
+	s.Nil(b.Save("dir1/large", data))
+	s.Contains(s.fake.calls, "b2_finish_large_file")
+	s.Equal(6, len(s.fake.large[s.fake.files["prefix/dir1/large"][0].id]))
+
+	loaded, err := b.Load("dir1/large", 1e6)
+	s.Nil(err)
+	s.Equal(data, loaded)
+}
+
+// TestRecommendedPartSize verifies that the part size recommended by B2 is used by default.
+func (s *B2Suite) TestRecommendedPartSize() {
+	b := s.newB2(nil)
+
+	s.Nil(b.Save("small", bytes.Repeat([]byte("x"), 100)))
+	s.NotContains(s.fake.calls, "b2_start_large_file")
+
+	s.Nil(b.Save("large", bytes.Repeat([]byte("x"), 101)))
+	s.Contains(s.fake.calls, "b2_start_large_file")
+}
+
+// TestDeleteHide verifies that the default delete mode only hides files.
+func (s *B2Suite) TestDeleteHide() {
+	b := s.newB2(nil)
+	s.Nil(b.Save("file1", []byte("test")))
+	s.Nil(b.Delete("file1"))
+
+	s.Len(s.fake.files["prefix/file1"], 2)
+	_, err := b.Load("file1", 1e6)
+	s.True(stor.IsPathDoesntExistError(err))
+}
+
+// TestDeleteVersions verifies that the versions delete mode removes all versions of a file.
+func (s *B2Suite) TestDeleteVersions() {
+	b := s.newB2(map[string]string{"delete": DeleteVersions})
+	s.Nil(b.Save("file1", []byte("test")))
+	s.Nil(b.Save("file1", []byte("test2")))
+	s.Nil(b.Delete("file1"))
+
+	s.Empty(s.fake.files["prefix/file1"])
+}
+
+// TestListPagination verifies that List follows nextFileName.
+func (s *B2Suite) TestListPagination() {
+	b := s.newB2(nil)
+	s.fake.pageSize = 2
+	for i := 0; i < 5; i++ {
+		s.Nil(b.Save(fmt.Sprintf("dir1/file%d", i), []byte("test")))
+	}
+	s.Nil(b.Save("dir1/sub/file", []byte("test")))
+
+	files, dirs, err := b.List("dir1")
+	s.Nil(err)
+	s.Len(files, 5)
+	s.Equal([]string{"dir1/sub"}, dirs)
+}
+
+// TestExpiredToken verifies that an expired authorization token is renewed.
+func (s *B2Suite) TestExpiredToken() {
+	b := s.newB2(nil)
+	s.Nil(b.Save("file1", []byte("test")))
+
+	s.fake.expireNext = true
+	data, err := b.Load("file1", 1e6)
+	s.Nil(err)
+	s.Equal([]byte("test"), data)
+
+	s.fake.expireNext = true
+	s.Nil(b.Save("file2", []byte("test")))
+}
+
+// TestThrottled verifies that throttling responses result in a stor.TemporaryError that wraps the
+// OpError of the operation.
+func (s *B2Suite) TestThrottled() {
+	b := s.newB2(nil)
+	s.Nil(b.Save("dir/file1", []byte("test")))
+
+	s.fake.throttle = true
+	_, err := b.Load("dir/file1", 1e6)
+	s.True(stor.IsTemporaryError(err))
+	retryAfter, ok := stor.RetryAfter(err)
+	s.True(ok)
+	s.Equal(2*time.Second, retryAfter)
+
+	var opErr *stor.OpError
+	s.Require().True(errors.As(err, &opErr))
+	s.Equal(stor.OpLoad, opErr.Op)
+	s.Equal("dir/file1", opErr.Path)
+	s.Equal("req-too_many_requests", opErr.RequestID)
+}
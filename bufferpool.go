@@ -0,0 +1,151 @@
+package stor
+
+import (
+	"math/bits"
+	"sync"
+	"sync/atomic"
+)
+
+const (
+	// DefaultBufferPoolMinSize is the smallest buffer size of the default BufferPool: 4 KiB.
+	DefaultBufferPoolMinSize = 4 << 10
+
+	// DefaultBufferPoolMaxSize is the largest buffer size of the default BufferPool: 16 MiB.
+	DefaultBufferPoolMaxSize = 16 << 20
+)
+
+// defaultBufferPool is the current default BufferPool. It holds a *BufferPool.
+var defaultBufferPool atomic.Value
+
+func init() {
+	defaultBufferPool.Store(NewBufferPool(DefaultBufferPoolMinSize, DefaultBufferPoolMaxSize))
+}
+
+// SetDefaultBufferPool replaces the BufferPool that the backends and wrappers of this module use
+// for temporary buffers, e.g. with a pool with other buffer sizes (see BufferPool.Stats). It is
+// safe to call SetDefaultBufferPool concurrently with operations on Storages.
+func SetDefaultBufferPool(pool *BufferPool) {
+	defaultBufferPool.Store(pool)
+}
+
+// GetDefaultBufferPool returns the BufferPool that the backends and wrappers of this module use for
+// temporary buffers (see SetDefaultBufferPool).
+func GetDefaultBufferPool() *BufferPool {
+	return defaultBufferPool.Load().(*BufferPool)
+}
+
+// BufferPoolStats are the counters of a BufferPool since it was created.
+type BufferPoolStats struct {
+	// Gets is the number of buffers that were requested.
+	Gets uint64
+
+	// Misses is the number of requested buffers that had to be allocated, because the pool had no
+	// buffer of their size class. Many misses relative to Gets indicate that buffers are rarely
+	// returned, or that the sizes vary too much to reuse them.
+	Misses uint64
+
+	// Oversized is the number of requested buffers that were larger than the largest size class.
+	// They are always allocated, and not pooled when they are returned. Many oversized buffers
+	// indicate that the maximum size of the pool is too small.
+	Oversized uint64
+
+	// Puts is the number of buffers that were returned to the pool.
+	Puts uint64
+
+	// Discarded is the number of returned buffers that were not pooled, because they were smaller
+	// than the smallest or larger than the largest size class.
+	Discarded uint64
+}
+
+// BufferPool is a pool of byte buffers for temporary data, e.g. for data that is read before it is
+// copied into a buffer of its exact size. It avoids allocating large buffers for every operation.
+// Buffers are pooled in size classes of powers of two between a minimum and a maximum size. Like
+// with a sync.Pool, pooled buffers may be freed at any time. A BufferPool is safe for concurrent
+// use.
+//
+// Buffers must only be returned to the pool when nothing refers to them anymore, so never return a
+// buffer that was passed to Save (e.g. a Memory in zero-copy mode keeps it) or to a caller of Load.
+type BufferPool struct {
+	// The counters are first, so they are aligned for atomic access on 32-bit platforms
+	gets      uint64
+	misses    uint64
+	oversized uint64
+	puts      uint64
+	discarded uint64
+
+	minShift uint
+	maxShift uint
+	classes  []sync.Pool
+}
+
+// NewBufferPool creates a BufferPool with size classes from minSize to maxSize. Both are rounded up
+// to a power of two.
+func NewBufferPool(minSize, maxSize int) *BufferPool {
+	minShift := shiftFor(minSize)
+	maxShift := shiftFor(maxSize)
+	if maxShift < minShift {
+		maxShift = minShift
+	}
+
+	p := &BufferPool{
+		minShift: minShift,
+		maxShift: maxShift,
+		classes:  make([]sync.Pool, maxShift-minShift+1),
+	}
+	return p
+}
+
+// shiftFor returns the exponent of the smallest power of two that is at least size.
+func shiftFor(size int) uint {
+	if size <= 1 {
+		return 0
+	}
+	return uint(bits.Len(uint(size - 1)))
+}
+
+// Get returns an empty buffer with a capacity of at least size.
+func (p *BufferPool) Get(size int) []byte {
+	atomic.AddUint64(&p.gets, 1)
+
+	shift := shiftFor(size)
+	if shift > p.maxShift {
+		atomic.AddUint64(&p.oversized, 1)
+		return make([]byte, 0, size)
+	}
+	if shift < p.minShift {
+		shift = p.minShift
+	}
+
+	if buf, ok := p.classes[shift-p.minShift].Get().(*[]byte); ok {
+		return (*buf)[:0]
+	}
+	atomic.AddUint64(&p.misses, 1)
+	return make([]byte, 0, 1<<shift)
+}
+
+// Put returns a buffer to the pool, so it can be reused by Get. The buffer must not be used
+// afterwards.
+func (p *BufferPool) Put(buf []byte) {
+	atomic.AddUint64(&p.puts, 1)
+
+	// The buffer is pooled in the largest class that it can hold
+	shift := uint(bits.Len(uint(cap(buf)))) - 1
+	if cap(buf) == 0 || shift < p.minShift || shift > p.maxShift {
+		atomic.AddUint64(&p.discarded, 1)
+		return
+	}
+
+	buf = buf[:0]
+	p.classes[shift-p.minShift].Put(&buf)
+}
+
+// Stats returns the counters of the pool, to tune its buffer sizes.
+func (p *BufferPool) Stats() BufferPoolStats {
+	return BufferPoolStats{
+		Gets:      atomic.LoadUint64(&p.gets),
+		Misses:    atomic.LoadUint64(&p.misses),
+		Oversized: atomic.LoadUint64(&p.oversized),
+		Puts:      atomic.LoadUint64(&p.puts),
+		Discarded: atomic.LoadUint64(&p.discarded),
+	}
+}
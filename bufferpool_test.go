@@ -0,0 +1,62 @@
+package stor_test
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/suite"
+
+	"github.com/pw1/stor"
+)
+
+func TestBufferPoolSuite(t *testing.T) {
+	suite.Run(t, new(BufferPoolSuite))
+}
+
+// BufferPoolSuite contains the tests for BufferPool.
+type BufferPoolSuite struct {
+	suite.Suite
+}
+
+func (s *BufferPoolSuite) TestGet() {
+	pool := stor.NewBufferPool(1000, 5000)
+	for size, expectedCap := range map[int]int{0: 1024, 1: 1024, 1024: 1024, 1025: 2048,
+		8192: 8192, 8193: 8193} {
+		buf := pool.Get(size)
+		s.Len(buf, 0, size)
+		s.Equal(expectedCap, cap(buf), size)
+	}
+
+	stats := pool.Stats()
+	s.Equal(uint64(6), stats.Gets)
+	s.Equal(uint64(5), stats.Misses)
+	s.Equal(uint64(1), stats.Oversized)
+}
+
+func (s *BufferPoolSuite) TestPut() {
+	pool := stor.NewBufferPool(1024, 8192)
+	pool.Put(make([]byte, 10, 3000))
+	pool.Put(make([]byte, 0, 100))
+	pool.Put(make([]byte, 0, 20000))
+	pool.Put(nil)
+
+	// Pooled buffers may be freed at any time, so a Get doesn't necessarily reuse the buffer
+	buf := pool.Get(2048)
+	s.Len(buf, 0)
+	s.True(cap(buf) >= 2048)
+
+	stats := pool.Stats()
+	s.Equal(uint64(4), stats.Puts)
+	s.Equal(uint64(3), stats.Discarded)
+	s.Equal(uint64(1), stats.Gets)
+	s.True(stats.Misses <= 1)
+}
+
+func (s *BufferPoolSuite) TestDefault() {
+	pool := stor.GetDefaultBufferPool()
+	s.NotNil(pool)
+
+	other := stor.NewBufferPool(16, 16)
+	stor.SetDefaultBufferPool(other)
+	defer stor.SetDefaultBufferPool(pool)
+	s.Same(other, stor.GetDefaultBufferPool())
+}
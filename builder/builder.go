@@ -0,0 +1,141 @@
+// Package builder assembles stacks of stor.Storage wrappers in a fixed, validated order.
+//
+// Stacking wrappers by hand is error prone: e.g. a Retry outside of a Transform would re-encode the
+// data on every attempt, and a PathHash outside of a Quota would make the quota count hashed
+// directories. A Builder always stacks the wrappers in the following order, from the outermost
+// (the one that is called by the application) to the innermost (the one that calls the base
+// Storage), no matter in which order they are configured:
+//
+//	quota      Limits apply to the directories that the application sees.
+//	transform  Data is compressed before it is encrypted.
+//	crypt      Data is encrypted before its path is hashed.
+//	pathhash   Paths are hashed before they reach the backend.
+//	retry      Only the calls to the backend are retried.
+//
+// Example:
+//
+//	st, err := builder.New(base).
+//		WithRetry(retry.Policy{Attempts: 5}).
+//		WithTransform(transform.Rule{Transformers: []transform.Transformer{transform.Gzip{}}}).
+//		Build()
+package builder
+
+import (
+	"fmt"
+
+	"github.com/pw1/stor"
+	"github.com/pw1/stor/crypt"
+	"github.com/pw1/stor/pathhash"
+	"github.com/pw1/stor/quota"
+	"github.com/pw1/stor/retry"
+	"github.com/pw1/stor/transform"
+)
+
+// layer identifies a wrapper in the stack. Layers are applied from the highest to the lowest value,
+// so the layer with the lowest value ends up as the outermost wrapper.
+type layer int
+
+const (
+	layerQuota layer = iota
+	layerTransform
+	layerCrypt
+	layerPathHash
+	layerRetry
+)
+
+var layerNames = map[layer]string{
+	layerQuota:     "quota",
+	layerTransform: "transform",
+	layerCrypt:     "crypt",
+	layerPathHash:  "pathhash",
+	layerRetry:     "retry",
+}
+
+// Builder collects the configuration of a stack of wrappers. Its methods can be chained. Errors are
+// reported by Build.
+type Builder struct {
+	base   stor.Storage
+	layers map[layer]func(stor.Storage) (stor.Storage, error)
+	errors []error
+}
+
+// New creates a new Builder for a stack of wrappers around base.
+func New(base stor.Storage) *Builder {
+	b := &Builder{
+		base:   base,
+		layers: make(map[layer]func(stor.Storage) (stor.Storage, error)),
+	}
+	return b
+}
+
+// add adds a layer to the stack. Every layer can only be added once.
+func (b *Builder) add(l layer, wrap func(stor.Storage) (stor.Storage, error)) *Builder {
+	if _, ok := b.layers[l]; ok {
+		b.errors = append(b.errors, fmt.Errorf("%s is configured more than once", layerNames[l]))
+		return b
+	}
+	b.layers[l] = wrap
+	return b
+}
+
+// WithQuota adds a quota.Quota with the specified limits.
+func (b *Builder) WithQuota(limits quota.Limits) *Builder {
+	return b.add(layerQuota, func(s stor.Storage) (stor.Storage, error) {
+		return quota.New(s, limits)
+	})
+}
+
+// WithTransform adds a transform.Transform with the specified rules.
+func (b *Builder) WithTransform(rules ...transform.Rule) *Builder {
+	return b.add(layerTransform, func(s stor.Storage) (stor.Storage, error) {
+		return transform.New(s, rules...)
+	})
+}
+
+// WithEncryption adds a crypt.Crypt with the specified key.
+func (b *Builder) WithEncryption(key []byte) *Builder {
+	return b.add(layerCrypt, func(s stor.Storage) (stor.Storage, error) {
+		return crypt.New(s, key)
+	})
+}
+
+// WithPathHash adds a pathhash.PathHash with the specified secret key.
+func (b *Builder) WithPathHash(key []byte) *Builder {
+	return b.add(layerPathHash, func(s stor.Storage) (stor.Storage, error) {
+		return pathhash.New(s, key)
+	})
+}
+
+// WithRetry adds a retry.Retry with the specified policy.
+func (b *Builder) WithRetry(policy retry.Policy) *Builder {
+	return b.add(layerRetry, func(s stor.Storage) (stor.Storage, error) {
+		return retry.New(s, policy)
+	})
+}
+
+// Build creates the stack of wrappers, and returns its outermost wrapper. If no wrappers are
+// configured, then the base Storage is returned.
+func (b *Builder) Build() (stor.Storage, error) {
+	if b.base == nil {
+		return nil, fmt.Errorf("Invalid builder: no base storage")
+	}
+	if len(b.errors) > 0 {
+		return nil, fmt.Errorf("Invalid builder: %v", b.errors[0])
+	}
+
+	s := b.base
+	for l := layerRetry; l >= layerQuota; l-- {
+		wrap, ok := b.layers[l]
+		if !ok {
+			continue
+		}
+
+		var err error
+		s, err = wrap(s)
+		if err != nil {
+			return nil, fmt.Errorf("failed to create %s: %v", layerNames[l], err)
+		}
+	}
+
+	return s, nil
+}
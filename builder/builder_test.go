@@ -0,0 +1,89 @@
+package builder
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/stretchr/testify/suite"
+
+	"github.com/pw1/stor"
+	"github.com/pw1/stor/crypt"
+	"github.com/pw1/stor/header"
+	"github.com/pw1/stor/memory"
+	"github.com/pw1/stor/pathhash"
+	"github.com/pw1/stor/quota"
+	"github.com/pw1/stor/retry"
+	"github.com/pw1/stor/transform"
+)
+
+var testKey = []byte("0123456789abcdef")
+
+func TestBuilderSuite(t *testing.T) {
+	suite.Run(t, new(BuilderSuite))
+}
+
+// BuilderSuite contains the tests for the Builder.
+type BuilderSuite struct {
+	suite.Suite
+	base *memory.Memory
+}
+
+func (s *BuilderSuite) SetupTest() {
+	s.base, _ = memory.New(&stor.Conf{})
+}
+
+func (s *BuilderSuite) TestNoWrappers() {
+	st, err := New(s.base).Build()
+	s.Nil(err)
+	s.Equal(s.base, st)
+}
+
+func (s *BuilderSuite) TestNoBase() {
+	_, err := New(nil).WithRetry(retry.Policy{}).Build()
+	s.NotNil(err)
+}
+
+func (s *BuilderSuite) TestDuplicate() {
+	_, err := New(s.base).WithRetry(retry.Policy{}).WithRetry(retry.Policy{}).Build()
+	s.NotNil(err)
+	s.Contains(err.Error(), "retry")
+}
+
+func (s *BuilderSuite) TestInvalidWrapper() {
+	_, err := New(s.base).WithPathHash([]byte("short")).Build()
+	s.NotNil(err)
+	s.Contains(err.Error(), "pathhash")
+}
+
+func (s *BuilderSuite) TestOrder() {
+	// Configured in the wrong order on purpose
+	st, err := New(s.base).
+		WithRetry(retry.Policy{}).
+		WithPathHash(testKey).
+		WithEncryption(bytes.Repeat([]byte{1}, crypt.KeySize)).
+		WithTransform(transform.Rule{Transformers: []transform.Transformer{transform.Gzip{}}}).
+		WithQuota(quota.Limits{MaxEntries: 1}).
+		Build()
+	s.Require().Nil(err)
+	s.IsType(&quota.Quota{}, st)
+
+	content := bytes.Repeat([]byte("test123"), 100)
+	s.Require().Nil(st.Save("dir1/file1", content))
+
+	// The quota applies to the plain paths
+	s.True(quota.IsLimitExceededError(st.Save("dir1/file2", content)))
+
+	// The data is compressed, then encrypted, and then stored under a hashed path
+	hashed, err := pathhash.New(s.base, testKey)
+	s.Require().Nil(err)
+	stored, err := hashed.Load("dir1/file1", 1e6)
+	s.Nil(err)
+	s.True(len(stored) < len(content))
+	h, _, err := header.Parse(stored)
+	s.Nil(err)
+	s.Equal([]header.Layer{crypt.Layer}, h.Layers)
+
+	data, err := st.Load("dir1/file1", 1e6)
+	s.Nil(err)
+	s.Equal(content, data)
+}
@@ -0,0 +1,496 @@
+// Package cache implements a stor.Storage wrapper that caches the files of a slow backing Storage
+// (e.g. a cloud storage) in a fast cache Storage (e.g. a Memory or LocalDir storage).
+//
+// Loads are served from the cache if possible. Files that are not cached yet are loaded from the
+// backing Storage and added to the cache. Writes are handled according to the Mode of the Cache:
+//
+//	WriteThrough  Writes go to the backing Storage first, and then to the cache. A successful write
+//	              is durable in the backing Storage.
+//	WriteBack     Writes go to the cache, and are flushed to the backing Storage asynchronously.
+//	              Writes to the same file are coalesced until they are flushed. Flush and Close
+//	              drain the pending writes.
+//
+// The Cache must be the only writer of the backing Storage, otherwise it serves stale data.
+package cache
+
+import (
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pw1/stor"
+)
+
+// Mode selects how a Cache handles writes.
+type Mode int
+
+const (
+	// WriteThrough writes synchronously to the backing Storage and the cache.
+	WriteThrough Mode = iota
+
+	// WriteBack writes to the cache, and flushes to the backing Storage asynchronously.
+	WriteBack
+)
+
+const (
+	// DefaultMaxDirty is the default maximum number of files with pending writes.
+	DefaultMaxDirty = 1000
+
+	// DefaultFlushInterval is the default interval of the background flushes.
+	DefaultFlushInterval = time.Second
+
+	// pathLocks is the number of locks that serialize the writes of the same path.
+	pathLocks = 64
+)
+
+// Options configures a Cache. Zero values are replaced by the defaults.
+type Options struct {
+	// Mode selects how writes are handled. Defaults to WriteThrough.
+	Mode Mode
+
+	// MaxDirty is the maximum number of files with pending writes in WriteBack mode. Writes block
+	// while this limit is reached, until the pending writes have been flushed.
+	MaxDirty int
+
+	// FlushInterval is the interval at which pending writes are flushed in the background in
+	// WriteBack mode. Pending writes are also flushed as soon as MaxDirty is reached.
+	FlushInterval time.Duration
+
+	// OnFlushError is called in WriteBack mode for every pending write that is dropped because it
+	// failed with an error that isn't retryable (see stor.IsRetryable), e.g. an invalid path or a
+	// quota error of the backing Storage. It is called without locks held. Optional.
+	OnFlushError func(path string, err error)
+}
+
+// dirty is a pending write of a file. Deleted is true for a pending delete.
+type dirty struct {
+	data    []byte
+	deleted bool
+}
+
+// Cache is a stor.Storage wrapper that caches the files of a backing Storage.
+type Cache struct {
+	backing stor.Storage
+	cache   stor.Storage
+	options Options
+
+	// pathLocks serialize the WriteBack writes of the same path (by hash), so the cache Storage
+	// holds the data of the last pending write of a file. They are taken before the mutex.
+	pathLocks [pathLocks]sync.Mutex
+
+	// mutex protects the pending writes. It is never held across the I/O of the backing or the
+	// cache Storage.
+	mutex  sync.Mutex
+	cond   *sync.Cond
+	dirty  map[string]*dirty
+	order  []string
+	closed bool
+
+	flushMutex sync.Mutex
+	wake       chan struct{}
+	done       chan struct{}
+	stopped    chan struct{}
+}
+
+// New creates a new Cache that caches the files of backing in cache. In WriteBack mode, Close must
+// be called to flush the pending writes and to stop the background flushes.
+func New(backing, cache stor.Storage, options Options) (*Cache, error) {
+	if options.Mode != WriteThrough && options.Mode != WriteBack {
+		return nil, fmt.Errorf("Invalid cache mode %d", options.Mode)
+	}
+	if options.MaxDirty < 0 || options.FlushInterval < 0 {
+		return nil, fmt.Errorf("Invalid cache options %+v: must not be negative", options)
+	}
+	if options.MaxDirty == 0 {
+		options.MaxDirty = DefaultMaxDirty
+	}
+	if options.FlushInterval == 0 {
+		options.FlushInterval = DefaultFlushInterval
+	}
+
+	c := &Cache{
+		backing: backing,
+		cache:   cache,
+		options: options,
+		dirty:   make(map[string]*dirty),
+		wake:    make(chan struct{}, 1),
+		done:    make(chan struct{}),
+		stopped: make(chan struct{}),
+	}
+	c.cond = sync.NewCond(&c.mutex)
+
+	if options.Mode == WriteBack {
+		go c.flushLoop()
+	} else {
+		close(c.stopped)
+	}
+	return c, nil
+}
+
+// flushLoop flushes the pending writes periodically, or when it is woken up, until Close is called.
+func (c *Cache) flushLoop() {
+	defer close(c.stopped)
+
+	ticker := time.NewTicker(c.options.FlushInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-c.done:
+			return
+		case <-ticker.C:
+		case <-c.wake:
+		}
+		c.Flush()
+	}
+}
+
+// Flush writes all pending writes to the backing Storage, in the order in which the files were
+// first written. Writes that fail with a retryable error (see stor.IsRetryable) stay pending, and
+// are retried by the next flush; the flush continues with the other files. Writes that fail with
+// other errors would never succeed, so they are dropped: the file is removed from the cache, and
+// the error is reported to Options.OnFlushError. Flush returns the first error. Flush does nothing
+// in WriteThrough mode.
+func (c *Cache) Flush() error {
+	c.flushMutex.Lock()
+	defer c.flushMutex.Unlock()
+
+	var firstErr error
+	retry := make(map[string]bool)
+	for {
+		c.mutex.Lock()
+		idx := 0
+		for idx < len(c.order) && retry[c.order[idx]] {
+			idx++
+		}
+		if idx == len(c.order) {
+			c.mutex.Unlock()
+			return firstErr
+		}
+		cleanPath := c.order[idx]
+		pending := c.dirty[cleanPath]
+		c.mutex.Unlock()
+
+		var err error
+		if pending.deleted {
+			err = c.backing.Delete(cleanPath)
+			if stor.IsPathDoesntExistError(err) {
+				err = nil
+			}
+		} else {
+			err = c.backing.Save(cleanPath, pending.data)
+		}
+
+		if err != nil && firstErr == nil {
+			firstErr = err
+		}
+		if stor.IsRetryable(err) {
+			retry[cleanPath] = true
+			continue
+		}
+		if err != nil {
+			// Never serve data from the cache that the backing Storage doesn't have. The write
+			// is still pending, so it is served until it is dropped below, and a write of the
+			// file in the meantime is served until it is flushed.
+			c.cache.Delete(cleanPath)
+		}
+
+		c.mutex.Lock()
+		c.order = append(c.order[:idx], c.order[idx+1:]...)
+		dropped := false
+		if c.dirty[cleanPath] == pending {
+			delete(c.dirty, cleanPath)
+			dropped = err != nil
+		} else {
+			// The file was written again during the flush
+			c.order = append(c.order, cleanPath)
+		}
+		c.cond.Broadcast()
+		c.mutex.Unlock()
+
+		if dropped && c.options.OnFlushError != nil {
+			c.options.OnFlushError(cleanPath, err)
+		}
+	}
+}
+
+// Close flushes the pending writes, stops the background flushes, and closes the backing and the
+// cache Storage. The Cache can't be written to anymore afterwards.
+func (c *Cache) Close() error {
+	c.mutex.Lock()
+	if c.closed {
+		c.mutex.Unlock()
+		return nil
+	}
+	c.closed = true
+	c.mutex.Unlock()
+
+	if c.options.Mode == WriteBack {
+		close(c.done)
+		<-c.stopped
+	}
+
+	err := c.Flush()
+	for _, s := range []stor.Storage{c.backing, c.cache} {
+		if closeErr := stor.Close(s); err == nil {
+			err = closeErr
+		}
+	}
+	return err
+}
+
+// Type returns the Type of the backing Storage.
+func (c *Cache) Type() stor.Type {
+	return stor.TypeOf(c.backing)
+}
+
+// Pending returns the number of files with pending writes.
+func (c *Cache) Pending() int {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	return len(c.dirty)
+}
+
+// pathLock returns the lock that serializes the writes of a path.
+func (c *Cache) pathLock(cleanPath string) *sync.Mutex {
+	hash := fnv.New32a()
+	hash.Write([]byte(cleanPath))
+	return &c.pathLocks[hash.Sum32()%pathLocks]
+}
+
+// isClosed returns true if Close was called.
+func (c *Cache) isClosed() bool {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	return c.closed
+}
+
+// markDirty records a pending write. It blocks while the maximum number of dirty files is reached.
+// The caller must hold the mutex.
+func (c *Cache) markDirty(cleanPath string, pending *dirty) {
+	for len(c.dirty) >= c.options.MaxDirty && c.dirty[cleanPath] == nil && !c.closed {
+		select {
+		case c.wake <- struct{}{}:
+		default:
+		}
+		c.cond.Wait()
+	}
+
+	if c.dirty[cleanPath] == nil {
+		c.order = append(c.order, cleanPath)
+	}
+	c.dirty[cleanPath] = pending
+}
+
+// pending returns the pending write of a file, or nil.
+func (c *Cache) pending(cleanPath string) *dirty {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	return c.dirty[cleanPath]
+}
+
+// Meta returns meta information about a file.
+func (c *Cache) Meta(filePath string) (*stor.Meta, error) {
+	cleanPath, err := stor.CleanPath(filePath)
+	if err != nil {
+		return nil, err
+	}
+
+	if pending := c.pending(cleanPath); pending != nil {
+		if pending.deleted {
+			return nil, &stor.PathDoesntExistError{Path: cleanPath}
+		}
+		return &stor.Meta{Size: int64(len(pending.data))}, nil
+	}
+
+	meta, err := c.cache.Meta(cleanPath)
+	if err == nil {
+		return meta, nil
+	}
+	return c.backing.Meta(cleanPath)
+}
+
+// List returns the files and subdirectories within the specified directory. Listings are not
+// cached, but pending writes are taken into account.
+func (c *Cache) List(dirPath string) ([]string, []string, error) {
+	cleanPath, err := stor.CleanPath(dirPath)
+	if err != nil {
+		return []string{}, []string{}, err
+	}
+
+	files, dirs, err := c.backing.List(cleanPath)
+	if err != nil {
+		return []string{}, []string{}, err
+	}
+
+	c.mutex.Lock()
+	if len(c.dirty) == 0 {
+		c.mutex.Unlock()
+		return files, dirs, nil
+	}
+
+	fileSet := make(map[string]bool)
+	for _, file := range files {
+		fileSet[file] = true
+	}
+	dirSet := make(map[string]bool)
+	for _, dir := range dirs {
+		dirSet[dir] = true
+	}
+
+	prefix := cleanPath
+	if prefix != "" {
+		prefix += "/"
+	}
+
+	for filePath, pending := range c.dirty {
+		if !strings.HasPrefix(filePath, prefix) {
+			continue
+		}
+
+		rest := filePath[len(prefix):]
+		if idx := strings.IndexByte(rest, '/'); idx >= 0 {
+			if !pending.deleted {
+				dirSet[prefix+rest[:idx]] = true
+			}
+			continue
+		}
+
+		fileSet[filePath] = !pending.deleted
+	}
+	c.mutex.Unlock()
+
+	files = []string{}
+	for file, exists := range fileSet {
+		if exists {
+			files = append(files, file)
+		}
+	}
+	dirs = []string{}
+	for dir := range dirSet {
+		dirs = append(dirs, dir)
+	}
+
+	return files, dirs, nil
+}
+
+// Load loads the content of the specified file, from the cache if possible.
+func (c *Cache) Load(filePath string, maxSize int64) ([]byte, error) {
+	cleanPath, err := stor.CleanPath(filePath)
+	if err != nil {
+		return []byte{}, err
+	}
+
+	if pending := c.pending(cleanPath); pending != nil {
+		if pending.deleted {
+			return []byte{}, &stor.PathDoesntExistError{Path: cleanPath}
+		}
+		if int64(len(pending.data)) > maxSize {
+			return []byte{}, &stor.TooLargeError{What: cleanPath}
+		}
+		return append([]byte{}, pending.data...), nil
+	}
+
+	data, err := c.cache.Load(cleanPath, maxSize)
+	if !stor.IsPathDoesntExistError(err) {
+		return data, err
+	}
+
+	data, err = c.backing.Load(cleanPath, maxSize)
+	if err != nil {
+		return []byte{}, err
+	}
+
+	// Caching is best effort; the data is valid even if it can't be cached
+	c.cache.Save(cleanPath, data)
+	return data, nil
+}
+
+// Save saves the data to the specified file.
+func (c *Cache) Save(filePath string, data []byte) error {
+	cleanPath, err := stor.CleanPath(filePath)
+	if err != nil {
+		return err
+	}
+
+	if c.options.Mode == WriteThrough {
+		if err := c.backing.Save(cleanPath, data); err != nil {
+			return err
+		}
+		if err := c.cache.Save(cleanPath, data); err != nil {
+			// Never serve the old content from the cache
+			c.cache.Delete(cleanPath)
+		}
+		return nil
+	}
+
+	pathLock := c.pathLock(cleanPath)
+	pathLock.Lock()
+	defer pathLock.Unlock()
+
+	if c.isClosed() {
+		return errors.New("cache is closed")
+	}
+	if err := c.cache.Save(cleanPath, data); err != nil {
+		return err
+	}
+
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	if c.closed {
+		return errors.New("cache is closed")
+	}
+	// The caller may reuse data after Save returns, so keep a copy until it is flushed
+	c.markDirty(cleanPath, &dirty{data: append([]byte{}, data...)})
+	return nil
+}
+
+// Delete removes a file from storage.
+func (c *Cache) Delete(filePath string) error {
+	cleanPath, err := stor.CleanPath(filePath)
+	if err != nil {
+		return err
+	}
+
+	if c.options.Mode == WriteThrough {
+		if err := c.backing.Delete(cleanPath); err != nil {
+			return err
+		}
+		if err := c.cache.Delete(cleanPath); err != nil && !stor.IsPathDoesntExistError(err) {
+			return err
+		}
+		return nil
+	}
+
+	// A pending delete can't report a missing file later, so check that now
+	if _, err := c.Meta(cleanPath); err != nil {
+		return err
+	}
+
+	pathLock := c.pathLock(cleanPath)
+	pathLock.Lock()
+	defer pathLock.Unlock()
+
+	if c.isClosed() {
+		return errors.New("cache is closed")
+	}
+	if err := c.cache.Delete(cleanPath); err != nil && !stor.IsPathDoesntExistError(err) {
+		return err
+	}
+
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	if c.closed {
+		return errors.New("cache is closed")
+	}
+	c.markDirty(cleanPath, &dirty{deleted: true})
+	return nil
+}
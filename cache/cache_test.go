@@ -0,0 +1,300 @@
+package cache
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/suite"
+
+	"github.com/pw1/stor"
+	"github.com/pw1/stor/memory"
+	"github.com/pw1/stor/tester"
+)
+
+// TestCacheStorageTester calls the generic storage tests for both write modes
+func TestCacheStorageTester(t *testing.T) {
+	for _, mode := range []Mode{WriteThrough, WriteBack} {
+		var c *Cache
+		testSuite := &tester.StorageTester{
+			SetupTestFunc: func(s *tester.StorageTester) {
+				backing, _ := memory.New(&stor.Conf{})
+				cache, _ := memory.New(&stor.Conf{})
+				var err error
+				c, err = New(backing, cache, Options{Mode: mode})
+				s.Require().Nil(err)
+				s.Storage = c
+			},
+			TearDownTestFunc: func(s *tester.StorageTester) {
+				s.Nil(c.Close())
+			},
+		}
+
+		suite.Run(t, testSuite)
+	}
+}
+
+// failingStorage is a Storage of which the writes fail while fail is set, or if they are of
+// failPath. The error is temporary, unless permanent is also set.
+type failingStorage struct {
+	stor.Storage
+	fail      bool
+	failPath  string
+	permanent bool
+	saves     int
+	closes    int
+}
+
+func (f *failingStorage) Close() error {
+	f.closes++
+	return nil
+}
+
+func (f *failingStorage) Save(filePath string, data []byte) error {
+	if f.permanent {
+		return &stor.ReadOnlyError{Path: filePath}
+	}
+	if f.fail || filePath == f.failPath {
+		return &stor.TemporaryError{Err: errors.New("backend down")}
+	}
+	f.saves++
+	return f.Storage.Save(filePath, data)
+}
+
+func TestCacheSuite(t *testing.T) {
+	suite.Run(t, new(CacheSuite))
+}
+
+// CacheSuite contains tests that are specific for the Cache wrapper.
+type CacheSuite struct {
+	suite.Suite
+	backing *failingStorage
+	cache   *memory.Memory
+}
+
+func (s *CacheSuite) SetupTest() {
+	base, _ := memory.New(&stor.Conf{})
+	s.backing = &failingStorage{Storage: base}
+	s.cache, _ = memory.New(&stor.Conf{})
+}
+
+func (s *CacheSuite) TestNewInvalid() {
+	_, err := New(s.backing, s.cache, Options{Mode: Mode(5)})
+	s.NotNil(err)
+	_, err = New(s.backing, s.cache, Options{MaxDirty: -1})
+	s.NotNil(err)
+}
+
+func (s *CacheSuite) TestLoadPopulatesCache() {
+	s.Require().Nil(s.backing.Save("dir1/file1", []byte("test123")))
+
+	c, err := New(s.backing, s.cache, Options{})
+	s.Require().Nil(err)
+
+	data, err := c.Load("dir1/file1", 100)
+	s.Nil(err)
+	s.Equal("test123", string(data))
+
+	data, err = s.cache.Load("dir1/file1", 100)
+	s.Nil(err)
+	s.Equal("test123", string(data))
+
+	// Served from the cache, even if the backing storage changes behind its back
+	s.Require().Nil(s.backing.Storage.Delete("dir1/file1"))
+	data, err = c.Load("dir1/file1", 100)
+	s.Nil(err)
+	s.Equal("test123", string(data))
+}
+
+func (s *CacheSuite) TestWriteThroughFailure() {
+	c, err := New(s.backing, s.cache, Options{})
+	s.Require().Nil(err)
+
+	s.backing.fail = true
+	s.NotNil(c.Save("file1", []byte("test123")))
+
+	_, err = s.cache.Meta("file1")
+	s.True(stor.IsPathDoesntExistError(err), "nothing is cached when the write fails")
+}
+
+func (s *CacheSuite) TestWriteBackCoalesces() {
+	c, err := New(s.backing, s.cache, Options{Mode: WriteBack, FlushInterval: time.Hour})
+	s.Require().Nil(err)
+	defer c.Close()
+
+	s.Nil(c.Save("dir1/file1", []byte("v1")))
+	s.Nil(c.Save("dir1/file1", []byte("v2")))
+	s.Nil(c.Save("file2", []byte("test123")))
+	s.Nil(c.Delete("file2"))
+	s.Equal(2, c.Pending())
+
+	_, err = s.backing.Meta("dir1/file1")
+	s.True(stor.IsPathDoesntExistError(err), "nothing is written before the flush")
+
+	files, dirs, err := c.List("")
+	s.Nil(err)
+	s.Empty(files)
+	s.Equal([]string{"dir1"}, dirs)
+
+	s.Nil(c.Flush())
+	s.Equal(0, c.Pending())
+	s.Equal(1, s.backing.saves)
+
+	data, err := s.backing.Load("dir1/file1", 100)
+	s.Nil(err)
+	s.Equal("v2", string(data))
+}
+
+func (s *CacheSuite) TestWriteBackFlushError() {
+	c, err := New(s.backing, s.cache, Options{Mode: WriteBack, FlushInterval: time.Hour})
+	s.Require().Nil(err)
+
+	s.backing.fail = true
+	s.Nil(c.Save("file1", []byte("test123")))
+	s.NotNil(c.Flush())
+	s.Equal(1, c.Pending(), "failed writes stay pending")
+
+	s.backing.fail = false
+	s.Nil(c.Close())
+	s.Equal(0, c.Pending())
+
+	s.NotNil(c.Save("file2", []byte("test")), "closed")
+}
+
+// TestWriteBackFlushSkipsFailing verifies that a write that keeps failing doesn't hold up the
+// writes after it.
+func (s *CacheSuite) TestWriteBackFlushSkipsFailing() {
+	c, err := New(s.backing, s.cache, Options{Mode: WriteBack, FlushInterval: time.Hour})
+	s.Require().Nil(err)
+	defer c.Close()
+
+	s.backing.failPath = "file1"
+	s.Nil(c.Save("file1", []byte("test123")))
+	s.Nil(c.Save("file2", []byte("test456")))
+	s.True(stor.IsTemporaryError(c.Flush()))
+	s.Equal(1, c.Pending(), "the failed write stays pending")
+
+	data, err := s.backing.Load("file2", 100)
+	s.Nil(err)
+	s.Equal("test456", string(data))
+
+	s.backing.failPath = ""
+	s.Nil(c.Flush())
+	s.Equal(0, c.Pending())
+}
+
+// blockingSave is a Storage of which the Saves block until unblock is closed.
+type blockingSave struct {
+	stor.Storage
+	saving  chan struct{}
+	unblock chan struct{}
+}
+
+func (b *blockingSave) Save(filePath string, data []byte) error {
+	b.saving <- struct{}{}
+	<-b.unblock
+	return b.Storage.Save(filePath, data)
+}
+
+// TestWriteBackSaveUnlocked verifies that a slow write to the cache Storage doesn't hold up the
+// other operations.
+func (s *CacheSuite) TestWriteBackSaveUnlocked() {
+	s.Require().Nil(s.backing.Save("file2", []byte("test456")))
+
+	cache := &blockingSave{s.cache, make(chan struct{}), make(chan struct{})}
+	c, err := New(s.backing, cache, Options{Mode: WriteBack, FlushInterval: time.Hour})
+	s.Require().Nil(err)
+	defer c.Close()
+
+	done := make(chan error)
+	go func() {
+		done <- c.Save("file1", []byte("test123"))
+	}()
+	<-cache.saving
+
+	s.Equal(0, c.Pending())
+	s.Nil(c.Delete("file2"))
+	s.Nil(c.Flush())
+
+	close(cache.unblock)
+	s.Nil(<-done)
+	s.Equal(1, c.Pending())
+}
+
+// TestWriteBackPermanentError verifies that writes that can never succeed are dropped and
+// reported, so they don't block the writes after them.
+func (s *CacheSuite) TestWriteBackPermanentError() {
+	var failed []string
+	c, err := New(s.backing, s.cache, Options{Mode: WriteBack, MaxDirty: 1,
+		FlushInterval: time.Hour, OnFlushError: func(path string, err error) {
+			s.True(stor.IsReadOnlyError(err))
+			failed = append(failed, path)
+		}})
+	s.Require().Nil(err)
+	defer c.Close()
+
+	s.backing.permanent = true
+	s.Nil(c.Save("file1", []byte("test123")))
+	s.True(stor.IsReadOnlyError(c.Flush()))
+	s.Equal(0, c.Pending(), "permanently failed writes are dropped")
+	s.Equal([]string{"file1"}, failed)
+
+	_, err = c.Load("file1", 100)
+	s.True(stor.IsPathDoesntExistError(err), "dropped writes are removed from the cache")
+
+	s.backing.permanent = false
+	s.Nil(c.Save("file2", []byte("a")))
+	s.Nil(c.Save("file3", []byte("b")))
+	s.Nil(c.Flush())
+	s.Equal([]string{"file1"}, failed)
+}
+
+// TestWriteBackCopiesData verifies that the caller may reuse its buffer after Save.
+func (s *CacheSuite) TestWriteBackCopiesData() {
+	c, err := New(s.backing, s.cache, Options{Mode: WriteBack, FlushInterval: time.Hour})
+	s.Require().Nil(err)
+	defer c.Close()
+
+	data := []byte("test123")
+	s.Nil(c.Save("file1", data))
+	copy(data, "XXXXXXX")
+
+	loaded, err := c.Load("file1", 100)
+	s.Nil(err)
+	s.Equal("test123", string(loaded))
+	copy(loaded, "YYYYYYY")
+
+	s.Nil(c.Flush())
+	loaded, err = s.backing.Load("file1", 100)
+	s.Nil(err)
+	s.Equal("test123", string(loaded))
+}
+
+func (s *CacheSuite) TestWriteBackBounded() {
+	c, err := New(s.backing, s.cache,
+		Options{Mode: WriteBack, MaxDirty: 2, FlushInterval: time.Hour})
+	s.Require().Nil(err)
+	defer c.Close()
+
+	s.Nil(c.Save("file1", []byte("a")))
+	s.Nil(c.Save("file2", []byte("b")))
+
+	// The dirty set is full, so this blocks until the background flush made room
+	s.Nil(c.Save("file3", []byte("c")))
+	s.True(c.Pending() <= 2)
+	s.True(s.backing.saves >= 2)
+}
+
+func (s *CacheSuite) TestTypeAndClose() {
+	c, err := New(s.backing, s.cache, Options{Mode: WriteBack, FlushInterval: time.Hour})
+	s.Require().Nil(err)
+	s.Equal(stor.TypeUnspecified, c.Type(), "the backing Storage hides the Type of its base")
+
+	s.Nil(c.Save("file1", []byte("test123")))
+	s.Nil(stor.Close(c))
+	s.Equal(1, s.backing.closes)
+	s.Equal(1, s.backing.saves, "the pending writes are flushed before")
+
+	s.Nil(c.Close())
+	s.Equal(1, s.backing.closes, "a closed Cache isn't closed again")
+}
@@ -0,0 +1,198 @@
+// Package casefold implements a stor.Storage wrapper that makes paths case-insensitive, while
+// preserving the case with which files and directories were first saved.
+//
+// Paths are resolved one component at a time, by listing the parent directory in the underlying
+// Storage and comparing the names with strings.EqualFold. An exact match is preferred. This
+// behaves the same on every backend, so data from a case-insensitive file system keeps working
+// when it is moved to e.g. S3 or memory. Note that this makes every operation cost one List per
+// path component.
+//
+// Save overwrites an existing file that only differs in case, and creates missing directories with
+// the case of the path that is saved. If the underlying Storage already contains several entries
+// that only differ in case (e.g. because they were saved without the wrapper), then the first one
+// in sorted order is used.
+package casefold
+
+import (
+	"errors"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/pw1/stor"
+)
+
+// CaseFold is a stor.Storage wrapper with case-insensitive, case-preserving paths. Saves are
+// serialized, so concurrent saves of paths that only differ in case end up in the same file.
+type CaseFold struct {
+	base  stor.Storage
+	mutex sync.Mutex
+}
+
+// New creates a new CaseFold around base.
+func New(base stor.Storage) *CaseFold {
+	return &CaseFold{base: base}
+}
+
+// resolve returns the stored path of cleanPath, and whether it exists. The last component is
+// looked up among the directories if dir is set, and among the files otherwise. For components
+// that don't exist, the case of cleanPath is used in the returned path.
+func (c *CaseFold) resolve(cleanPath string, dir bool) (string, bool, error) {
+	if cleanPath == "" {
+		return "", true, nil
+	}
+
+	components := strings.Split(cleanPath, "/")
+	resolved := ""
+	for i, component := range components {
+		files, dirs, err := c.base.List(resolved)
+		if isNotExist(err) {
+			files, dirs = nil, nil
+		} else if err != nil {
+			return "", false, err
+		}
+
+		candidates := dirs
+		if i == len(components)-1 && !dir {
+			candidates = files
+		}
+
+		entry, ok := match(candidates, component)
+		if !ok {
+			rest := strings.Join(components[i:], "/")
+			if resolved == "" {
+				return rest, false, nil
+			}
+			return resolved + "/" + rest, false, nil
+		}
+		resolved = entry
+	}
+
+	return resolved, true, nil
+}
+
+// match returns the entry of which the name equals name, ignoring case. Exact matches are
+// preferred, and otherwise the first matching entry in sorted order is returned.
+func match(entries []string, name string) (string, bool) {
+	sorted := append([]string{}, entries...)
+	sort.Strings(sorted)
+
+	found := ""
+	for _, entry := range sorted {
+		entryName := entry[strings.LastIndexByte(entry, '/')+1:]
+		if entryName == name {
+			return entry, true
+		}
+		if found == "" && strings.EqualFold(entryName, name) {
+			found = entry
+		}
+	}
+	return found, found != ""
+}
+
+// isNotExist checks whether err indicates that a directory doesn't exist.
+func isNotExist(err error) bool {
+	return stor.IsPathDoesntExistError(err) || errors.Is(err, os.ErrNotExist)
+}
+
+// Meta returns meta information about a file.
+func (c *CaseFold) Meta(filePath string) (*stor.Meta, error) {
+	cleanPath, err := stor.CleanPath(filePath)
+	if err != nil {
+		return nil, err
+	}
+
+	resolved, ok, err := c.resolve(cleanPath, false)
+	if err != nil {
+		return nil, err
+	}
+	if !ok {
+		return nil, &stor.PathDoesntExistError{Path: cleanPath}
+	}
+	return c.base.Meta(resolved)
+}
+
+// List returns the files and subdirectories within the specified directory. The returned paths
+// have the case in which they are stored.
+func (c *CaseFold) List(dirPath string) ([]string, []string, error) {
+	cleanPath, err := stor.CleanPath(dirPath)
+	if err != nil {
+		return []string{}, []string{}, err
+	}
+
+	resolved, ok, err := c.resolve(cleanPath, true)
+	if err != nil {
+		return []string{}, []string{}, err
+	}
+	if !ok {
+		return []string{}, []string{}, nil
+	}
+	return c.base.List(resolved)
+}
+
+// Load loads the content of the specified file. If the file is larger than maxSize, the an error is
+// returned.
+func (c *CaseFold) Load(filePath string, maxSize int64) ([]byte, error) {
+	cleanPath, err := stor.CleanPath(filePath)
+	if err != nil {
+		return []byte{}, err
+	}
+
+	resolved, ok, err := c.resolve(cleanPath, false)
+	if err != nil {
+		return []byte{}, err
+	}
+	if !ok {
+		return []byte{}, &stor.PathDoesntExistError{Path: cleanPath}
+	}
+	return c.base.Load(resolved, maxSize)
+}
+
+// Save saves the data to the specified file. An existing file of which the path only differs in
+// case is overwritten.
+func (c *CaseFold) Save(filePath string, data []byte) error {
+	cleanPath, err := stor.CleanPath(filePath)
+	if err != nil {
+		return err
+	}
+
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	resolved, _, err := c.resolve(cleanPath, false)
+	if err != nil {
+		return err
+	}
+	return c.base.Save(resolved, data)
+}
+
+// Delete removes a file from storage.
+func (c *CaseFold) Delete(filePath string) error {
+	cleanPath, err := stor.CleanPath(filePath)
+	if err != nil {
+		return err
+	}
+
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	resolved, ok, err := c.resolve(cleanPath, false)
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return &stor.PathDoesntExistError{Path: cleanPath}
+	}
+	return c.base.Delete(resolved)
+}
+
+// Type returns the Type of the base Storage.
+func (c *CaseFold) Type() stor.Type {
+	return stor.TypeOf(c.base)
+}
+
+// Close closes the base Storage.
+func (c *CaseFold) Close() error {
+	return stor.Close(c.base)
+}
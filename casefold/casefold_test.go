@@ -0,0 +1,136 @@
+package casefold
+
+import (
+	"io/ioutil"
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/suite"
+
+	"github.com/pw1/stor"
+	"github.com/pw1/stor/localdir"
+	"github.com/pw1/stor/memory"
+	"github.com/pw1/stor/tester"
+)
+
+func TestCaseFoldStorageTester(t *testing.T) {
+	testSuite := &tester.StorageTester{
+		SetupTestFunc: func(s *tester.StorageTester) {
+			base, _ := memory.New(&stor.Conf{})
+			s.Storage = New(base)
+		},
+	}
+
+	suite.Run(t, testSuite)
+}
+
+// TestCaseFoldLocalDirStorageTester runs the generic tests on a LocalDir, of which List returns an
+// error for directories that don't exist.
+func TestCaseFoldLocalDirStorageTester(t *testing.T) {
+	var dir string
+	testSuite := &tester.StorageTester{
+		SetupTestFunc: func(s *tester.StorageTester) {
+			var err error
+			dir, err = ioutil.TempDir("", "stor-casefold")
+			s.Require().Nil(err)
+			base, err := localdir.New(&stor.Conf{Path: dir})
+			s.Require().Nil(err)
+			s.Storage = New(base)
+		},
+		TearDownTestFunc: func(s *tester.StorageTester) {
+			os.RemoveAll(dir)
+		},
+	}
+
+	suite.Run(t, testSuite)
+}
+
+func TestCaseFoldSuite(t *testing.T) {
+	suite.Run(t, new(CaseFoldSuite))
+}
+
+// CaseFoldSuite contains tests that are specific for the CaseFold wrapper.
+type CaseFoldSuite struct {
+	suite.Suite
+	base *memory.Memory
+	c    *CaseFold
+}
+
+func (s *CaseFoldSuite) SetupTest() {
+	s.base, _ = memory.New(&stor.Conf{})
+	s.c = New(s.base)
+	s.Require().Nil(s.c.Save("Dir1/File1.TXT", []byte("test123")))
+}
+
+func (s *CaseFoldSuite) TestLoad() {
+	for _, filePath := range []string{"Dir1/File1.TXT", "dir1/file1.txt", "DIR1/FILE1.txt"} {
+		data, err := s.c.Load(filePath, 100)
+		s.Nil(err, filePath)
+		s.Equal("test123", string(data), filePath)
+
+		meta, err := s.c.Meta(filePath)
+		s.Nil(err, filePath)
+		s.Equal(int64(7), meta.Size)
+	}
+
+	_, err := s.c.Load("dir1/file2.txt", 100)
+	s.Equal(&stor.PathDoesntExistError{Path: "dir1/file2.txt"}, err)
+	_, err = s.c.Meta("dir1")
+	s.True(stor.IsPathDoesntExistError(err), "directories are not files")
+}
+
+func (s *CaseFoldSuite) TestSavePreservesCase() {
+	s.Nil(s.c.Save("DIR1/file1.txt", []byte("new")))
+	s.Nil(s.c.Save("dir1/NEW/File2", []byte("test456")))
+
+	files, dirs, err := s.base.List("Dir1")
+	s.Nil(err)
+	s.Equal([]string{"Dir1/File1.TXT"}, files, "the file is overwritten, with its original case")
+	s.Equal([]string{"Dir1/NEW"}, dirs)
+
+	data, err := s.base.Load("Dir1/File1.TXT", 100)
+	s.Nil(err)
+	s.Equal("new", string(data))
+}
+
+func (s *CaseFoldSuite) TestList() {
+	s.Nil(s.c.Save("dir1/dir2/file3", []byte("test")))
+
+	files, dirs, err := s.c.List("DIR1")
+	s.Nil(err)
+	s.Equal([]string{"Dir1/File1.TXT"}, files)
+	s.Equal([]string{"Dir1/dir2"}, dirs)
+
+	files, dirs, err = s.c.List("missing")
+	s.Nil(err)
+	s.Empty(files)
+	s.Empty(dirs)
+}
+
+func (s *CaseFoldSuite) TestDelete() {
+	s.Nil(s.c.Delete("dir1/FILE1.txt"))
+	_, err := s.base.Meta("Dir1/File1.TXT")
+	s.True(stor.IsPathDoesntExistError(err))
+
+	err = s.c.Delete("dir1/FILE1.txt")
+	s.Equal(&stor.PathDoesntExistError{Path: "dir1/FILE1.txt"}, err)
+}
+
+// TestExactMatch verifies that an exact match is preferred over entries that only differ in case.
+func (s *CaseFoldSuite) TestExactMatch() {
+	s.Require().Nil(s.base.Save("Dir1/file1.txt", []byte("exact")))
+
+	data, err := s.c.Load("Dir1/file1.txt", 100)
+	s.Nil(err)
+	s.Equal("exact", string(data))
+
+	data, err = s.c.Load("Dir1/FILE1.txt", 100)
+	s.Nil(err)
+	s.Equal("test123", string(data), "the first entry in sorted order")
+}
+
+func (s *CaseFoldSuite) TestInvalidPath() {
+	_, err := s.c.Load("../file1", 100)
+	s.True(stor.IsInvalidPathError(err))
+	s.True(stor.IsInvalidPathError(s.c.Save("../file1", nil)))
+}
@@ -0,0 +1,162 @@
+// Package checksum implements a stor.Storage wrapper that stores a SHA-256 checksum with every
+// file, and verifies it on every Load. This detects bit rot on local disks, and truncated or
+// otherwise damaged transfers from network backends.
+//
+// Every file starts with a header (see package header) with a single layer, followed by the
+// checksum and the data:
+//
+//	[header][SHA-256 of the data (32 bytes)][data]
+//
+// The decoder of the layer is registered with header.RegisterDecoder, so checksummed files can also
+// be read with header.Decode.
+package checksum
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"math"
+
+	"github.com/pw1/stor"
+	"github.com/pw1/stor/header"
+)
+
+// Layer identifies checksummed data in the header of stored files.
+var Layer = header.Layer{Name: "sha256", Version: 1}
+
+// overhead is the number of bytes that is added to every file.
+var overhead = int64(header.Header{Layers: []header.Layer{Layer}}.Size() + sha256.Size)
+
+func init() {
+	header.RegisterDecoder(Layer, decode)
+}
+
+// decode verifies the checksum of a payload, and returns the data. It returns a
+// stor.CorruptDataError without path if the checksum doesn't match.
+func decode(payload []byte, maxSize int64) ([]byte, error) {
+	if len(payload) < sha256.Size {
+		return nil, &stor.CorruptDataError{Msg: "checksum is truncated"}
+	}
+
+	data := payload[sha256.Size:]
+	if int64(len(data)) > maxSize {
+		return nil, &stor.TooLargeError{}
+	}
+
+	sum := sha256.Sum256(data)
+	if !bytes.Equal(sum[:], payload[:sha256.Size]) {
+		return nil, &stor.CorruptDataError{Msg: "checksum mismatch"}
+	}
+	return data, nil
+}
+
+// Checksum is a stor.Storage wrapper that stores a SHA-256 checksum with every file, and verifies
+// it on every Load. Files whose content doesn't match their checksum are reported with a
+// stor.CorruptDataError. Files that weren't saved by a Checksum are reported with a
+// header.FormatError.
+type Checksum struct {
+	base stor.Storage
+}
+
+// New creates a new Checksum that stores its files in base.
+func New(base stor.Storage) *Checksum {
+	return &Checksum{base: base}
+}
+
+// Meta returns meta information about a file. The reported size is the size of the data, without
+// the checksum. The checksum is not verified.
+func (c *Checksum) Meta(filePath string) (*stor.Meta, error) {
+	meta, err := c.base.Meta(filePath)
+	if err != nil {
+		return nil, err
+	}
+
+	size := meta.Size - overhead
+	if size < 0 {
+		size = 0
+	}
+	return &stor.Meta{Size: size, ModTime: meta.ModTime}, nil
+}
+
+// List returns the files and subdirectories within the specified directory.
+func (c *Checksum) List(dirPath string) ([]string, []string, error) {
+	return c.base.List(dirPath)
+}
+
+// Load loads the content of the specified file, and verifies its checksum. If the file is larger
+// than maxSize, then an error is returned.
+func (c *Checksum) Load(filePath string, maxSize int64) ([]byte, error) {
+	cleanPath, err := stor.CleanPath(filePath)
+	if err != nil {
+		return []byte{}, err
+	}
+
+	baseMaxSize := int64(math.MaxInt64)
+	if maxSize <= math.MaxInt64-overhead {
+		baseMaxSize = maxSize + overhead
+	}
+
+	stored, err := c.base.Load(cleanPath, baseMaxSize)
+	if err != nil {
+		return []byte{}, err
+	}
+
+	h, payload, err := header.Parse(stored)
+	if err != nil {
+		return []byte{}, err
+	}
+	if len(h.Layers) != 1 || h.Layers[0] != Layer {
+		return []byte{}, &header.FormatError{Msg: "not checksummed"}
+	}
+
+	data, err := decode(payload, maxSize)
+	switch e := err.(type) {
+	case nil:
+		return data, nil
+	case *stor.TooLargeError:
+		return []byte{}, &stor.TooLargeError{What: cleanPath}
+	case *stor.CorruptDataError:
+		return []byte{}, &stor.CorruptDataError{Path: cleanPath, Msg: e.Msg}
+	default:
+		return []byte{}, err
+	}
+}
+
+// Save saves the data with its checksum to the specified file.
+func (c *Checksum) Save(filePath string, data []byte) error {
+	cleanPath, err := stor.CleanPath(filePath)
+	if err != nil {
+		return err
+	}
+
+	// Wrap copies the payload, so it can be assembled in a pooled buffer
+	pool := stor.GetDefaultBufferPool()
+	payload := pool.Get(sha256.Size + len(data))
+	defer func() {
+		pool.Put(payload)
+	}()
+
+	sum := sha256.Sum256(data)
+	payload = append(payload, sum[:]...)
+	payload = append(payload, data...)
+
+	stored, err := header.Header{Layers: []header.Layer{Layer}}.Wrap(payload)
+	if err != nil {
+		return err
+	}
+	return c.base.Save(cleanPath, stored)
+}
+
+// Delete removes a file from storage.
+func (c *Checksum) Delete(filePath string) error {
+	return c.base.Delete(filePath)
+}
+
+// Type returns the Type of the base Storage.
+func (c *Checksum) Type() stor.Type {
+	return stor.TypeOf(c.base)
+}
+
+// Close closes the base Storage.
+func (c *Checksum) Close() error {
+	return stor.Close(c.base)
+}
@@ -0,0 +1,113 @@
+package checksum
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/suite"
+
+	"github.com/pw1/stor"
+	"github.com/pw1/stor/header"
+	"github.com/pw1/stor/memory"
+	"github.com/pw1/stor/tester"
+)
+
+// TestChecksumStorageTester calls the generic storage tests
+func TestChecksumStorageTester(t *testing.T) {
+	testSuite := &tester.StorageTester{
+		SetupTestFunc: func(s *tester.StorageTester) {
+			base, _ := memory.New(&stor.Conf{})
+			s.Storage = New(base)
+		},
+	}
+
+	suite.Run(t, testSuite)
+}
+
+func TestChecksumSuite(t *testing.T) {
+	suite.Run(t, new(ChecksumSuite))
+}
+
+// ChecksumSuite contains tests that are specific for the Checksum wrapper.
+type ChecksumSuite struct {
+	suite.Suite
+	base *memory.Memory
+	st   *Checksum
+}
+
+func (s *ChecksumSuite) SetupTest() {
+	s.base, _ = memory.New(&stor.Conf{})
+	s.st = New(s.base)
+	s.Require().Nil(s.st.Save("dir/file1", []byte("test123")))
+}
+
+// TestBitRot verifies that a flipped bit is detected.
+func (s *ChecksumSuite) TestBitRot() {
+	stored, err := s.base.Load("dir/file1", 1e6)
+	s.Require().Nil(err)
+	stored[len(stored)-1] ^= 0x01
+	s.Require().Nil(s.base.Save("dir/file1", stored))
+
+	data, err := s.st.Load("dir/file1", 1e6)
+	s.True(stor.IsCorruptDataError(err))
+	s.Equal("data of dir/file1 is corrupt: checksum mismatch", err.Error())
+	s.Equal([]byte{}, data)
+}
+
+// TestTruncated verifies that a truncated file is detected.
+func (s *ChecksumSuite) TestTruncated() {
+	stored, err := s.base.Load("dir/file1", 1e6)
+	s.Require().Nil(err)
+
+	for _, cut := range []int{1, 7, 20} {
+		s.Require().Nil(s.base.Save("dir/file1", stored[:len(stored)-cut]))
+		_, err = s.st.Load("dir/file1", 1e6)
+		s.True(stor.IsCorruptDataError(err), "cut %d", cut)
+	}
+}
+
+func (s *ChecksumSuite) TestNotChecksummed() {
+	s.Require().Nil(s.base.Save("file2", []byte("plain data")))
+
+	_, err := s.st.Load("file2", 1e6)
+	s.True(header.IsFormatError(err))
+
+	wrapped, err := header.Header{Layers: []header.Layer{{Name: "gzip", Version: 1}}}.Wrap(nil)
+	s.Require().Nil(err)
+	s.Require().Nil(s.base.Save("file3", wrapped))
+
+	_, err = s.st.Load("file3", 1e6)
+	s.True(header.IsFormatError(err))
+}
+
+func (s *ChecksumSuite) TestLoadMaxSize() {
+	data, err := s.st.Load("dir/file1", 7)
+	s.Nil(err)
+	s.Equal("test123", string(data))
+
+	_, err = s.st.Load("dir/file1", 6)
+	s.True(stor.IsTooLargeError(err))
+}
+
+func (s *ChecksumSuite) TestMeta() {
+	meta, err := s.st.Meta("dir/file1")
+	s.Nil(err)
+	s.Equal(int64(7), meta.Size)
+
+	stored, err := s.base.Meta("dir/file1")
+	s.Nil(err)
+	s.Equal(7+overhead, stored.Size)
+}
+
+// TestHeaderDecode verifies that checksummed files can be read with header.Decode.
+func (s *ChecksumSuite) TestHeaderDecode() {
+	stored, err := s.base.Load("dir/file1", 1e6)
+	s.Require().Nil(err)
+
+	data, err := header.Decode(stored, 1e6)
+	s.Nil(err)
+	s.Equal("test123", string(data))
+
+	stored[len(stored)-1] ^= 0x01
+	_, err = header.Decode(stored, 1e6)
+	s.True(stor.IsCorruptDataError(err))
+}
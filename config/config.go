@@ -0,0 +1,169 @@
+// Package config constructs stacks of stor.Storage wrappers from JSON or YAML documents, so the
+// storage topology of a deployment can change without recompiling.
+//
+// A document describes a backend, and a chain of wrappers around it, from the outermost to the
+// innermost. For example, a cache in front of an encrypted LocalDir:
+//
+//	backend:
+//	  url: file:///var/data
+//	wrappers:
+//	  - type: cache
+//	    storage:
+//	      backend:
+//	        url: mem://
+//	  - type: crypt
+//	    options:
+//	      passphrase: correct horse battery staple
+//	      salt: 8c2f1a6e9b7d4c3e
+//
+// The backend is either specified by a URL (see stor.ParseURL), or by its type, path and options
+// (see stor.Conf). The packages of the backends must be imported to register their types. See
+// RegisterWrapper for the available wrappers and their options. All option values are strings.
+package config
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+
+	"gopkg.in/yaml.v2"
+
+	"github.com/pw1/stor"
+)
+
+// Config describes a stack of a backend and its wrappers.
+type Config struct {
+	// Backend is the innermost Storage of the stack.
+	Backend Backend `json:"backend" yaml:"backend"`
+
+	// Wrappers are stacked around the Backend. The first wrapper is the outermost one.
+	Wrappers []Wrapper `json:"wrappers" yaml:"wrappers"`
+}
+
+// Backend describes a backend Storage. Either URL or Type must be specified.
+type Backend struct {
+	// URL is a storage URL (see stor.ParseURL).
+	URL string `json:"url" yaml:"url"`
+
+	// Type, Path and Options are the fields of a stor.Conf.
+	Type    stor.Type         `json:"type" yaml:"type"`
+	Path    string            `json:"path" yaml:"path"`
+	Options map[string]string `json:"options" yaml:"options"`
+
+	// CredentialsFile is the path of a JSON file with the stor.Credentials of the backend (see
+	// stor.ReadCredentialsFile), so the secrets don't have to be stored in the config itself.
+	CredentialsFile string `json:"credentialsFile" yaml:"credentialsFile"`
+}
+
+// Wrapper describes a wrapper in the stack.
+type Wrapper struct {
+	// Type is the registered name of the wrapper, e.g. "crypt".
+	Type string `json:"type" yaml:"type"`
+
+	// Options configure the wrapper.
+	Options map[string]string `json:"options" yaml:"options"`
+
+	// Storage is an additional Storage that some wrappers need, e.g. the cache Storage of a cache
+	// wrapper.
+	Storage *Config `json:"storage" yaml:"storage"`
+}
+
+// ParseJSON parses a JSON document.
+func ParseJSON(data []byte) (*Config, error) {
+	c := &Config{}
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(c); err != nil {
+		return nil, fmt.Errorf("Invalid storage config: %v", err)
+	}
+	return c, nil
+}
+
+// ParseYAML parses a YAML document.
+func ParseYAML(data []byte) (*Config, error) {
+	c := &Config{}
+	if err := yaml.UnmarshalStrict(data, c); err != nil {
+		return nil, fmt.Errorf("Invalid storage config: %v", err)
+	}
+	return c, nil
+}
+
+// LoadFile reads and parses a config file. Files with the extension ".json" are parsed as JSON,
+// files with the extension ".yaml" or ".yml" as YAML.
+func LoadFile(fileName string) (*Config, error) {
+	data, err := ioutil.ReadFile(fileName)
+	if err != nil {
+		return nil, err
+	}
+
+	switch ext := strings.ToLower(filepath.Ext(fileName)); ext {
+	case ".json":
+		return ParseJSON(data)
+	case ".yaml", ".yml":
+		return ParseYAML(data)
+	default:
+		return nil, fmt.Errorf("Invalid storage config %s: unknown extension %q", fileName, ext)
+	}
+}
+
+// Open reads a config file, and builds the Storage that it describes.
+func Open(fileName string) (stor.Storage, error) {
+	c, err := LoadFile(fileName)
+	if err != nil {
+		return nil, err
+	}
+	return c.Build()
+}
+
+// Build builds the Storage that the Config describes. It returns the outermost wrapper.
+func (c *Config) Build() (stor.Storage, error) {
+	st, err := c.Backend.build()
+	if err != nil {
+		return nil, err
+	}
+
+	for i := len(c.Wrappers) - 1; i >= 0; i-- {
+		w := &c.Wrappers[i]
+		factory, ok := lookupWrapper(w.Type)
+		if !ok {
+			return nil, fmt.Errorf("Invalid storage config: unknown wrapper %q", w.Type)
+		}
+
+		st, err = factory(st, w)
+		if err != nil {
+			return nil, fmt.Errorf("Invalid storage config: wrapper %s: %v", w.Type, err)
+		}
+	}
+
+	return st, nil
+}
+
+// build creates the backend Storage.
+func (b *Backend) build() (stor.Storage, error) {
+	conf := &stor.Conf{Type: b.Type, Path: b.Path, Options: b.Options}
+	if b.URL != "" {
+		if b.Type != stor.TypeUnspecified || b.Path != "" || len(b.Options) > 0 {
+			return nil, fmt.Errorf("Invalid storage config: backend has both a URL and a type, " +
+				"path or options")
+		}
+
+		var err error
+		conf, err = stor.ParseURL(b.URL)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if b.CredentialsFile != "" {
+		credentials, err := stor.ReadCredentialsFile(b.CredentialsFile)
+		if err != nil {
+			return nil, err
+		}
+		conf.Credentials = credentials
+	}
+
+	return stor.New(conf)
+}
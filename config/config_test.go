@@ -0,0 +1,271 @@
+package config
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/suite"
+
+	"github.com/pw1/stor"
+	"github.com/pw1/stor/cache"
+	"github.com/pw1/stor/crypt"
+	_ "github.com/pw1/stor/localdir"
+	"github.com/pw1/stor/memory"
+	"github.com/pw1/stor/quota"
+	"github.com/pw1/stor/retry"
+)
+
+const testYAML = `
+backend:
+  type: Memory
+wrappers:
+  - type: cache
+    storage:
+      backend:
+        url: mem://
+  - type: quota
+    options:
+      maxobjectsize: 10
+  - type: crypt
+    options:
+      passphrase: secret
+      salt: salt
+      iterations: 1000
+  - type: retry
+    options:
+      attempts: "5"
+      backoff: 10ms
+`
+
+const testJSON = `{
+	"backend": {"type": "Memory"},
+	"wrappers": [
+		{"type": "cache", "storage": {"backend": {"url": "mem://"}}},
+		{"type": "quota", "options": {"maxobjectsize": "10"}},
+		{"type": "crypt", "options": {"passphrase": "secret", "salt": "salt", "iterations": "1000"}},
+		{"type": "retry", "options": {"attempts": "5", "backoff": "10ms"}}
+	]
+}`
+
+func TestConfigSuite(t *testing.T) {
+	suite.Run(t, new(ConfigSuite))
+}
+
+// ConfigSuite contains the tests for the config package.
+type ConfigSuite struct {
+	suite.Suite
+}
+
+func (s *ConfigSuite) TestParse() {
+	expected := &Config{
+		Backend: Backend{Type: memory.MemoryStorageType},
+		Wrappers: []Wrapper{
+			{Type: "cache", Storage: &Config{Backend: Backend{URL: "mem://"}}},
+			{Type: "quota", Options: map[string]string{"maxobjectsize": "10"}},
+			{Type: "crypt", Options: map[string]string{
+				"passphrase": "secret",
+				"salt":       "salt",
+				"iterations": "1000",
+			}},
+			{Type: "retry", Options: map[string]string{"attempts": "5", "backoff": "10ms"}},
+		},
+	}
+
+	c, err := ParseYAML([]byte(testYAML))
+	s.Nil(err)
+	s.Equal(expected, c)
+
+	c, err = ParseJSON([]byte(testJSON))
+	s.Nil(err)
+	s.Equal(expected, c)
+}
+
+func (s *ConfigSuite) TestParseUnknownField() {
+	_, err := ParseYAML([]byte("backend:\n  url: mem://\n  bucket: test\n"))
+	s.NotNil(err)
+
+	_, err = ParseJSON([]byte(`{"backend": {"url": "mem://", "bucket": "test"}}`))
+	s.NotNil(err)
+}
+
+func (s *ConfigSuite) TestBuild() {
+	c, err := ParseYAML([]byte(testYAML))
+	s.Require().Nil(err)
+
+	st, err := c.Build()
+	s.Require().Nil(err)
+	s.IsType(&cache.Cache{}, st)
+
+	s.Nil(st.Save("dir/file1", []byte("test123")))
+	data, err := st.Load("dir/file1", 100)
+	s.Nil(err)
+	s.Equal("test123", string(data))
+
+	// The quota is within the cache
+	s.True(stor.IsTooLargeError(st.Save("file2", []byte("12345678901"))))
+}
+
+// TestBuildOrder verifies that the first wrapper is the outermost one.
+func (s *ConfigSuite) TestBuildOrder() {
+	c := &Config{
+		Backend:  Backend{URL: "mem://"},
+		Wrappers: []Wrapper{{Type: "retry"}, {Type: "quota"}},
+	}
+	st, err := c.Build()
+	s.Require().Nil(err)
+	s.IsType(&retry.Retry{}, st)
+
+	c.Wrappers = []Wrapper{{Type: "quota"}, {Type: "retry"}}
+	st, err = c.Build()
+	s.Require().Nil(err)
+	s.IsType(&quota.Quota{}, st)
+}
+
+func (s *ConfigSuite) TestBuildCryptKey() {
+	key := "000102030405060708090a0b0c0d0e0f101112131415161718191a1b1c1d1e1f"
+	c := &Config{
+		Backend:  Backend{URL: "mem://"},
+		Wrappers: []Wrapper{{Type: "crypt", Options: map[string]string{"key": key}}},
+	}
+	st, err := c.Build()
+	s.Nil(err)
+	s.IsType(&crypt.Crypt{}, st)
+
+	c.Wrappers[0].Options["passphrase"] = "secret"
+	_, err = c.Build()
+	s.NotNil(err)
+}
+
+func (s *ConfigSuite) TestBuildMaxSize() {
+	c := &Config{
+		Backend:  Backend{URL: "mem://"},
+		Wrappers: []Wrapper{{Type: "maxsize", Options: map[string]string{"size": "4"}}},
+	}
+	st, err := c.Build()
+	s.Require().Nil(err)
+	s.Nil(st.Save("file1", []byte("12345")))
+	_, err = stor.LoadDefault(st, "file1")
+	s.True(stor.IsTooLargeError(err))
+}
+
+func (s *ConfigSuite) TestBuildSorted() {
+	c := &Config{
+		Backend:  Backend{URL: "mem://"},
+		Wrappers: []Wrapper{{Type: "sorted"}},
+	}
+	st, err := c.Build()
+	s.Require().Nil(err)
+	for _, filePath := range []string{"file3", "file1", "file2"} {
+		s.Nil(st.Save(filePath, []byte("test123")))
+	}
+	files, _, err := st.List("")
+	s.Nil(err)
+	s.Equal([]string{"file1", "file2", "file3"}, files)
+
+	c.Wrappers[0].Options = map[string]string{"order": "desc"}
+	_, err = c.Build()
+	s.NotNil(err)
+}
+
+func (s *ConfigSuite) TestBuildInvalid() {
+	table := []Config{
+		{Backend: Backend{}},
+		{Backend: Backend{URL: "mem://", Type: memory.MemoryStorageType}},
+		{Backend: Backend{URL: "mem://"}, Wrappers: []Wrapper{{Type: "unknown"}}},
+		{Backend: Backend{URL: "mem://"}, Wrappers: []Wrapper{{Type: "cache"}}},
+		{Backend: Backend{URL: "mem://"}, Wrappers: []Wrapper{{
+			Type:    "quota",
+			Options: map[string]string{"maxentries": "ten"},
+		}}},
+		{Backend: Backend{URL: "mem://"}, Wrappers: []Wrapper{{
+			Type:    "retry",
+			Options: map[string]string{"attempt": "5"},
+		}}},
+		{Backend: Backend{URL: "mem://"}, Wrappers: []Wrapper{{
+			Type:    "checksum",
+			Storage: &Config{Backend: Backend{URL: "mem://"}},
+		}}},
+		{Backend: Backend{URL: "mem://"}, Wrappers: []Wrapper{{Type: "maxsize"}}},
+		{Backend: Backend{URL: "mem://"}, Wrappers: []Wrapper{{
+			Type:    "crypt",
+			Options: map[string]string{"passphrase": "secret"},
+		}}},
+	}
+
+	for i, c := range table {
+		st, err := c.Build()
+		s.Nil(st, "entry %d", i)
+		s.NotNil(err, "entry %d", i)
+	}
+}
+
+func (s *ConfigSuite) TestUnknownOptionError() {
+	c := &Config{
+		Backend: Backend{URL: "mem://"},
+		Wrappers: []Wrapper{{
+			Type:    "retry",
+			Options: map[string]string{"attempt": "5", "backof": "1s"},
+		}},
+	}
+	_, err := c.Build()
+	s.EqualError(err, "Invalid storage config: wrapper retry: unknown options [attempt backof]")
+}
+
+func (s *ConfigSuite) TestOpen() {
+	dir := s.T().TempDir()
+	data := filepath.Join(dir, "data")
+	s.Require().Nil(os.Mkdir(data, 0700))
+
+	yamlFile := filepath.Join(dir, "stor.yaml")
+	s.Require().Nil(ioutil.WriteFile(yamlFile, []byte("backend:\n  url: file://"+data+"\n"), 0600))
+	st, err := Open(yamlFile)
+	s.Require().Nil(err)
+	s.Nil(st.Save("file1", []byte("test")))
+
+	jsonFile := filepath.Join(dir, "stor.json")
+	s.Require().Nil(ioutil.WriteFile(jsonFile,
+		[]byte(`{"backend": {"type": "LocalDir", "path": "`+data+`"}}`), 0600))
+	st, err = Open(jsonFile)
+	s.Require().Nil(err)
+	loaded, err := st.Load("file1", 100)
+	s.Nil(err)
+	s.Equal("test", string(loaded))
+
+	tomlFile := filepath.Join(dir, "stor.toml")
+	s.Require().Nil(ioutil.WriteFile(tomlFile, []byte("[backend]\n"), 0600))
+	_, err = Open(tomlFile)
+	s.NotNil(err)
+}
+
+func (s *ConfigSuite) TestCredentialsFile() {
+	credentialsFile := filepath.Join(s.T().TempDir(), "credentials.json")
+	s.Require().Nil(ioutil.WriteFile(credentialsFile, []byte(`{"secret": "s3cr3t"}`), 0600))
+
+	var credentials []stor.Credentials
+	restore := stor.ReplaceType(memory.MemoryStorageType, func(conf *stor.Conf) (stor.Storage, error) {
+		credentials = append(credentials, conf.Credentials)
+		return memory.New(conf)
+	})
+	defer restore()
+
+	for _, backend := range []Backend{
+		{Type: memory.MemoryStorageType, CredentialsFile: credentialsFile},
+		{URL: "mem://", CredentialsFile: credentialsFile},
+	} {
+		_, err := (&Config{Backend: backend}).Build()
+		s.Nil(err)
+	}
+	s.Equal([]stor.Credentials{{Secret: "s3cr3t"}, {Secret: "s3cr3t"}}, credentials)
+
+	_, err := (&Config{Backend: Backend{Type: memory.MemoryStorageType,
+		CredentialsFile: credentialsFile + ".missing"}}).Build()
+	s.NotNil(err)
+}
+
+func (s *ConfigSuite) TestRegisterWrapperDuplicate() {
+	s.Panics(func() {
+		RegisterWrapper("cache", newCache)
+	})
+}
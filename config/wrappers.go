@@ -0,0 +1,443 @@
+package config
+
+import (
+	"encoding/hex"
+	"fmt"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/pw1/stor"
+	"github.com/pw1/stor/cache"
+	"github.com/pw1/stor/casefold"
+	"github.com/pw1/stor/checksum"
+	"github.com/pw1/stor/crypt"
+	"github.com/pw1/stor/escape"
+	"github.com/pw1/stor/pathhash"
+	"github.com/pw1/stor/quota"
+	"github.com/pw1/stor/ratelimit"
+	"github.com/pw1/stor/retry"
+	"github.com/pw1/stor/transform"
+)
+
+// WrapperFactory creates a wrapper around base, configured by w.
+type WrapperFactory func(base stor.Storage, w *Wrapper) (stor.Storage, error)
+
+var (
+	wrappersMutex sync.RWMutex
+
+	// wrappers contains the registered wrapper factories by their names.
+	wrappers = make(map[string]WrapperFactory)
+)
+
+func init() {
+	RegisterWrapper("cache", newCache)
+	RegisterWrapper("casefold", newCaseFold)
+	RegisterWrapper("checksum", newChecksum)
+	RegisterWrapper("crypt", newCrypt)
+	RegisterWrapper("escape", newEscape)
+	RegisterWrapper("gzip", newGzip)
+	RegisterWrapper("maxsize", newMaxSize)
+	RegisterWrapper("pathhash", newPathHash)
+	RegisterWrapper("quota", newQuota)
+	RegisterWrapper("ratelimit", newRateLimit)
+	RegisterWrapper("readonly", newReadOnly)
+	RegisterWrapper("retry", newRetry)
+	RegisterWrapper("sorted", newSorted)
+}
+
+// RegisterWrapper registers a wrapper, so it can be used in a Config. If the name is already
+// registered, then this function will panic.
+//
+// The following wrappers are registered by default (see their packages for details):
+//
+//	cache      Options "mode" ("writethrough" or "writeback"), "maxdirty" and "flushinterval".
+//	           Requires a Storage: the cache. In writeback mode the cache must be closed, so it
+//	           must be the outermost wrapper.
+//	casefold   No options.
+//	checksum   No options.
+//	crypt      Options "key" (hex), or "passphrase", "salt" and "iterations" (see
+//	           crypt.DeriveKey).
+//	escape     No options.
+//	gzip       Options "prefix" (default: all files) and "level".
+//	maxsize    Option "size": the default maximum size of stor.LoadDefault for the Storage. It
+//	           must be the outermost wrapper.
+//	pathhash   Option "key" (hex).
+//	quota      Options "maxentries", "maxbytes", "maxobjectsize" and "maxlistentries".
+//	ratelimit  Options "readops", "readbytes", "writeops" and "writebytes" (per second), each
+//	           optionally with a burst, e.g. "readopsburst".
+//	readonly   No options.
+//	retry      Options "attempts", "backoff" and "maxbackoff".
+//	sorted     No options. List returns sorted results (see stor.WithSortedList).
+func RegisterWrapper(name string, factory WrapperFactory) {
+	wrappersMutex.Lock()
+	defer wrappersMutex.Unlock()
+
+	if _, ok := wrappers[name]; ok {
+		panic(fmt.Sprintf("config: wrapper %s is already registered", name))
+	}
+	wrappers[name] = factory
+}
+
+// lookupWrapper returns the factory of a registered wrapper.
+func lookupWrapper(name string) (WrapperFactory, bool) {
+	wrappersMutex.RLock()
+	defer wrappersMutex.RUnlock()
+
+	factory, ok := wrappers[name]
+	return factory, ok
+}
+
+// Options reads the options of a wrapper. Its methods return the zero value for options that are
+// not set. The first error (e.g. an option that can't be parsed) is returned by Done.
+type Options struct {
+	values map[string]string
+	used   map[string]bool
+	err    error
+}
+
+// NewOptions creates Options to read the options of w.
+func NewOptions(w *Wrapper) *Options {
+	return &Options{
+		values: w.Options,
+		used:   make(map[string]bool),
+	}
+}
+
+// get returns the value of an option, and marks the option as used.
+func (o *Options) get(key string) (string, bool) {
+	o.used[key] = true
+	value, ok := o.values[key]
+	return value, ok && value != ""
+}
+
+// fail records an invalid option.
+func (o *Options) fail(key string, err error) {
+	if o.err == nil {
+		o.err = fmt.Errorf("invalid option %s: %v", key, err)
+	}
+}
+
+// String returns a string option.
+func (o *Options) String(key string) string {
+	value, _ := o.get(key)
+	return value
+}
+
+// Int returns an integer option.
+func (o *Options) Int(key string) int64 {
+	value, ok := o.get(key)
+	if !ok {
+		return 0
+	}
+
+	i, err := strconv.ParseInt(value, 10, 64)
+	if err != nil {
+		o.fail(key, err)
+	}
+	return i
+}
+
+// Float returns a floating point option.
+func (o *Options) Float(key string) float64 {
+	value, ok := o.get(key)
+	if !ok {
+		return 0
+	}
+
+	f, err := strconv.ParseFloat(value, 64)
+	if err != nil {
+		o.fail(key, err)
+	}
+	return f
+}
+
+// Duration returns a duration option, e.g. "1.5s".
+func (o *Options) Duration(key string) time.Duration {
+	value, ok := o.get(key)
+	if !ok {
+		return 0
+	}
+
+	d, err := time.ParseDuration(value)
+	if err != nil {
+		o.fail(key, err)
+	}
+	return d
+}
+
+// Hex returns a hex encoded binary option.
+func (o *Options) Hex(key string) []byte {
+	value, ok := o.get(key)
+	if !ok {
+		return nil
+	}
+
+	b, err := hex.DecodeString(value)
+	if err != nil {
+		o.fail(key, err)
+	}
+	return b
+}
+
+// Done returns the first error of the options, or an error if there are options that have not
+// been read (e.g. because of a typo).
+func (o *Options) Done() error {
+	if o.err != nil {
+		return o.err
+	}
+
+	unknown := []string{}
+	for key := range o.values {
+		if !o.used[key] {
+			unknown = append(unknown, key)
+		}
+	}
+	if len(unknown) > 0 {
+		sort.Strings(unknown)
+		return fmt.Errorf("unknown options %v", unknown)
+	}
+	return nil
+}
+
+// noStorage returns an error if w has a Storage.
+func noStorage(w *Wrapper) error {
+	if w.Storage != nil {
+		return fmt.Errorf("wrapper doesn't use a storage")
+	}
+	return nil
+}
+
+func newCache(base stor.Storage, w *Wrapper) (stor.Storage, error) {
+	o := NewOptions(w)
+	var options cache.Options
+	switch mode := o.String("mode"); mode {
+	case "", "writethrough":
+		options.Mode = cache.WriteThrough
+	case "writeback":
+		options.Mode = cache.WriteBack
+	default:
+		o.fail("mode", fmt.Errorf("unknown mode %q", mode))
+	}
+	options.MaxDirty = int(o.Int("maxdirty"))
+	options.FlushInterval = o.Duration("flushinterval")
+	if err := o.Done(); err != nil {
+		return nil, err
+	}
+
+	if w.Storage == nil {
+		return nil, fmt.Errorf("a storage is required for the cache")
+	}
+	cacheStorage, err := w.Storage.Build()
+	if err != nil {
+		return nil, err
+	}
+
+	st, err := cache.New(base, cacheStorage, options)
+	if err != nil {
+		return nil, err
+	}
+	return st, nil
+}
+
+func newCaseFold(base stor.Storage, w *Wrapper) (stor.Storage, error) {
+	if err := NewOptions(w).Done(); err != nil {
+		return nil, err
+	}
+	if err := noStorage(w); err != nil {
+		return nil, err
+	}
+	return casefold.New(base), nil
+}
+
+func newChecksum(base stor.Storage, w *Wrapper) (stor.Storage, error) {
+	if err := NewOptions(w).Done(); err != nil {
+		return nil, err
+	}
+	if err := noStorage(w); err != nil {
+		return nil, err
+	}
+	return checksum.New(base), nil
+}
+
+func newCrypt(base stor.Storage, w *Wrapper) (stor.Storage, error) {
+	o := NewOptions(w)
+	key := o.Hex("key")
+	passphrase := o.String("passphrase")
+	salt := o.String("salt")
+	iterations := o.Int("iterations")
+	if err := o.Done(); err != nil {
+		return nil, err
+	}
+	if err := noStorage(w); err != nil {
+		return nil, err
+	}
+
+	switch {
+	case key != nil && passphrase != "":
+		return nil, fmt.Errorf("either a key or a passphrase is required, not both")
+	case passphrase != "":
+		if salt == "" {
+			return nil, fmt.Errorf("a salt is required with a passphrase")
+		}
+		if iterations == 0 {
+			iterations = crypt.DefaultIterations
+		}
+		key = crypt.DeriveKey(passphrase, []byte(salt), int(iterations))
+	}
+
+	st, err := crypt.New(base, key)
+	if err != nil {
+		return nil, err
+	}
+	return st, nil
+}
+
+func newEscape(base stor.Storage, w *Wrapper) (stor.Storage, error) {
+	if err := NewOptions(w).Done(); err != nil {
+		return nil, err
+	}
+	if err := noStorage(w); err != nil {
+		return nil, err
+	}
+	return escape.New(base), nil
+}
+
+func newGzip(base stor.Storage, w *Wrapper) (stor.Storage, error) {
+	o := NewOptions(w)
+	prefix := o.String("prefix")
+	level := o.Int("level")
+	if err := o.Done(); err != nil {
+		return nil, err
+	}
+	if err := noStorage(w); err != nil {
+		return nil, err
+	}
+
+	gzip := transform.Gzip{Level: int(level)}
+	st, err := transform.New(base, transform.Rule{
+		Prefix:       prefix,
+		Transformers: []transform.Transformer{gzip},
+	})
+	if err != nil {
+		return nil, err
+	}
+	return st, nil
+}
+
+func newMaxSize(base stor.Storage, w *Wrapper) (stor.Storage, error) {
+	o := NewOptions(w)
+	size := o.Int("size")
+	if err := o.Done(); err != nil {
+		return nil, err
+	}
+	if err := noStorage(w); err != nil {
+		return nil, err
+	}
+	if size <= 0 {
+		return nil, fmt.Errorf("a positive size is required")
+	}
+	return stor.WithMaxSize(base, size), nil
+}
+
+func newPathHash(base stor.Storage, w *Wrapper) (stor.Storage, error) {
+	o := NewOptions(w)
+	key := o.Hex("key")
+	if err := o.Done(); err != nil {
+		return nil, err
+	}
+	if err := noStorage(w); err != nil {
+		return nil, err
+	}
+	st, err := pathhash.New(base, key)
+	if err != nil {
+		return nil, err
+	}
+	return st, nil
+}
+
+func newQuota(base stor.Storage, w *Wrapper) (stor.Storage, error) {
+	o := NewOptions(w)
+	limits := quota.Limits{
+		MaxEntries:     int(o.Int("maxentries")),
+		MaxBytes:       o.Int("maxbytes"),
+		MaxObjectSize:  o.Int("maxobjectsize"),
+		MaxListEntries: int(o.Int("maxlistentries")),
+	}
+	if err := o.Done(); err != nil {
+		return nil, err
+	}
+	if err := noStorage(w); err != nil {
+		return nil, err
+	}
+	st, err := quota.New(base, limits)
+	if err != nil {
+		return nil, err
+	}
+	return st, nil
+}
+
+func newRateLimit(base stor.Storage, w *Wrapper) (stor.Storage, error) {
+	o := NewOptions(w)
+	rate := func(key string) ratelimit.Rate {
+		return ratelimit.Rate{PerSecond: o.Float(key), Burst: o.Float(key + "burst")}
+	}
+	limits := ratelimit.Limits{
+		ReadOps:    rate("readops"),
+		ReadBytes:  rate("readbytes"),
+		WriteOps:   rate("writeops"),
+		WriteBytes: rate("writebytes"),
+	}
+	if err := o.Done(); err != nil {
+		return nil, err
+	}
+	if err := noStorage(w); err != nil {
+		return nil, err
+	}
+	st, err := ratelimit.New(base, limits)
+	if err != nil {
+		return nil, err
+	}
+	return st, nil
+}
+
+func newReadOnly(base stor.Storage, w *Wrapper) (stor.Storage, error) {
+	if err := NewOptions(w).Done(); err != nil {
+		return nil, err
+	}
+	if err := noStorage(w); err != nil {
+		return nil, err
+	}
+	return stor.ReadOnly(base), nil
+}
+
+func newRetry(base stor.Storage, w *Wrapper) (stor.Storage, error) {
+	o := NewOptions(w)
+	policy := retry.Policy{
+		Attempts:   int(o.Int("attempts")),
+		Backoff:    o.Duration("backoff"),
+		MaxBackoff: o.Duration("maxbackoff"),
+	}
+	if err := o.Done(); err != nil {
+		return nil, err
+	}
+	if err := noStorage(w); err != nil {
+		return nil, err
+	}
+	st, err := retry.New(base, policy)
+	if err != nil {
+		return nil, err
+	}
+	return st, nil
+}
+
+func newSorted(base stor.Storage, w *Wrapper) (stor.Storage, error) {
+	if err := NewOptions(w).Done(); err != nil {
+		return nil, err
+	}
+	if err := noStorage(w); err != nil {
+		return nil, err
+	}
+	return stor.WithSortedList(base), nil
+}
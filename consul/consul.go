@@ -0,0 +1,340 @@
+// Package consul implements the stor.Storage interface on top of the key/value store of HashiCorp
+// Consul.
+//
+// Every file is stored as a single key. Directories are emulated by the slash-separated key names,
+// and listed using the "separator" mode of the keys API. Note that Consul limits the size of a
+// single value (512 KiB by default).
+//
+// The following stor.Conf fields are used:
+//
+//	Path                  Key prefix under which all files are stored (optional).
+//	Options["address"]    URL of the Consul HTTP API. Defaults to http://127.0.0.1:8500.
+//	Options["datacenter"] Datacenter to use. Defaults to the datacenter of the agent.
+//	Options["token"]      ACL token (optional). Defaults to Credentials.Token.
+//
+// The HTTP client is configured with the options of stor.NewHTTPClient, e.g. "maxconnsperhost".
+//
+// With stor.NewFromEnv, the options are read from the variables STOR_CONSUL_<OPTION>, e.g.
+// STOR_CONSUL_ADDRESS and STOR_CONSUL_TOKEN.
+package consul
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"math"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/pw1/stor"
+)
+
+const (
+	// ConsulStorageType is the storage type of the Consul storage.
+	ConsulStorageType stor.Type = "Consul"
+
+	// URLScheme is the scheme of Consul storage URLs (see stor.NewFromURL), e.g.
+	// "consul://prefix?address=http://consul:8500". The options are passed as query parameters.
+	URLScheme = "consul"
+
+	// DefaultAddress is the address of the Consul HTTP API that is used if none is configured.
+	DefaultAddress = "http://127.0.0.1:8500"
+)
+
+func init() {
+	newStorageFunc := func(conf *stor.Conf) (stor.Storage, error) {
+		return New(conf)
+	}
+	stor.RegisterType(ConsulStorageType, newStorageFunc)
+	stor.RegisterValidator(ConsulStorageType, Validate)
+	stor.RegisterInfo(stor.Info{
+		Type:        ConsulStorageType,
+		Description: "Key/value store of HashiCorp Consul",
+		Path:        "Key prefix under which all files are stored (optional)",
+		Options: append([]stor.OptionInfo{
+			{Name: "address", Description: "URL of the Consul HTTP API. Defaults to " +
+				DefaultAddress + "."},
+			{Name: "datacenter", Description: "Datacenter. Defaults to the datacenter of the " +
+				"agent."},
+			{Name: "token", Description: "ACL token. Defaults to Credentials.Token.",
+				Secret: true},
+		}, stor.HTTPOptionInfos...),
+		Capabilities: []stor.Capability{stor.CapabilityPersistent},
+	})
+	stor.RegisterScheme(URLScheme, ConsulStorageType, nil)
+}
+
+// Consul is a stor.Storage implementation that stores files in the Consul key/value store.
+type Consul struct {
+	address    *url.URL
+	prefix     string
+	datacenter string
+	token      string
+	client     *http.Client
+}
+
+// Validate checks a configuration of the Consul storage without making any requests.
+func Validate(conf *stor.Conf) error {
+	if address := conf.Options["address"]; address != "" {
+		addressURL, err := url.Parse(address)
+		if err != nil || (addressURL.Scheme != "http" && addressURL.Scheme != "https") {
+			return &stor.ConfError{Type: ConsulStorageType, Field: "Options[address]",
+				Msg: "must be an http or https URL"}
+		}
+	}
+
+	if _, err := stor.CleanPath(conf.Path); err != nil {
+		return err
+	}
+	return stor.ValidateHTTPOptions(ConsulStorageType, conf)
+}
+
+// New creates a new Consul storage.
+func New(conf *stor.Conf) (*Consul, error) {
+	if err := Validate(conf); err != nil {
+		return nil, err
+	}
+
+	address := conf.Options["address"]
+	if address == "" {
+		address = DefaultAddress
+	}
+	addressURL, _ := url.Parse(address)
+	prefix, _ := stor.CleanPath(conf.Path)
+	client, _ := stor.NewHTTPClient(ConsulStorageType, conf)
+	token := conf.Options["token"]
+	if token == "" {
+		token = conf.Credentials.Token
+	}
+
+	c := &Consul{
+		address:    addressURL,
+		prefix:     prefix,
+		datacenter: conf.Options["datacenter"],
+		token:      token,
+		client:     client,
+	}
+	return c, nil
+}
+
+// key returns the Consul key of a cleaned path.
+func (c *Consul) key(cleanPath string) string {
+	if c.prefix == "" {
+		return cleanPath
+	}
+	if cleanPath == "" {
+		return c.prefix
+	}
+	return c.prefix + "/" + cleanPath
+}
+
+// do performs a request on the KV endpoint for a key.
+func (c *Consul) do(method, key string, query url.Values, body []byte) (*http.Response, error) {
+	if c.datacenter != "" {
+		query.Set("dc", c.datacenter)
+	}
+
+	reqURL := *c.address
+	reqURL.Path = strings.TrimSuffix(reqURL.Path, "/") + "/v1/kv/" + key
+	reqURL.RawQuery = query.Encode()
+
+	var bodyReader io.Reader
+	if body != nil {
+		bodyReader = bytes.NewReader(body)
+	}
+
+	req, err := http.NewRequest(method, reqURL.String(), bodyReader)
+	if err != nil {
+		return nil, err
+	}
+	if c.token != "" {
+		req.Header.Set("X-Consul-Token", c.token)
+	}
+
+	return c.client.Do(req)
+}
+
+// opError wraps an error of a request in a stor.OpError.
+func opError(op, cleanPath string, err error) error {
+	return &stor.OpError{Op: op, Backend: ConsulStorageType, Path: cleanPath, Err: err}
+}
+
+// statusError creates an error for an unexpected response to a request for op. Responses that
+// indicate a temporary condition result in a stor.TemporaryError. Consul has no request IDs, so the
+// X-Consul-Index of the response identifies the request instead.
+func statusError(op, cleanPath, method, key string, resp *http.Response) error {
+	msg, _ := ioutil.ReadAll(io.LimitReader(resp.Body, 512))
+	err := &stor.OpError{
+		Op:      op,
+		Backend: ConsulStorageType,
+		Path:    cleanPath,
+		Err: fmt.Errorf("consul: %s %s failed with status %s: %s",
+			method, key, resp.Status, strings.TrimSpace(string(msg))),
+		RequestID: resp.Header.Get("X-Consul-Index"),
+	}
+	return stor.HTTPTemporaryError(resp, err)
+}
+
+// Type returns ConsulStorageType.
+func (c *Consul) Type() stor.Type {
+	return ConsulStorageType
+}
+
+// Meta returns meta information about a file.
+func (c *Consul) Meta(filePath string) (*stor.Meta, error) {
+	cleanPath, err := stor.CleanPath(filePath)
+	if err != nil {
+		return nil, err
+	}
+
+	// The KV API doesn't report value sizes, so the value has to be fetched.
+	data, err := c.load(stor.OpMeta, cleanPath, math.MaxInt64)
+	if err != nil {
+		return nil, err
+	}
+
+	meta := &stor.Meta{
+		Size: int64(len(data)),
+	}
+	return meta, nil
+}
+
+// List returns the files and subdirectories within the specified directory.
+func (c *Consul) List(dirPath string) ([]string, []string, error) {
+	cleanPath, err := stor.CleanPath(dirPath)
+	if err != nil {
+		return []string{}, []string{}, err
+	}
+
+	keyPrefix := c.key(cleanPath)
+	if keyPrefix != "" {
+		keyPrefix += "/"
+	}
+
+	query := url.Values{}
+	query.Set("keys", "")
+	query.Set("separator", "/")
+	resp, err := c.do(http.MethodGet, keyPrefix, query, nil)
+	if err != nil {
+		return []string{}, []string{}, opError(stor.OpList, cleanPath, err)
+	}
+	defer resp.Body.Close()
+
+	files := []string{}
+	dirs := []string{}
+	if resp.StatusCode == http.StatusNotFound {
+		return files, dirs, nil
+	}
+	if resp.StatusCode != http.StatusOK {
+		return []string{}, []string{}, statusError(stor.OpList, cleanPath, http.MethodGet, keyPrefix, resp)
+	}
+
+	var keys []string
+	if err := json.NewDecoder(resp.Body).Decode(&keys); err != nil {
+		return []string{}, []string{}, fmt.Errorf("consul: invalid key list: %v", err)
+	}
+
+	for _, key := range keys {
+		name := strings.TrimPrefix(key, keyPrefix)
+		if name == "" || name == "/" {
+			continue
+		}
+
+		var entryPath string
+		if cleanPath == "" {
+			entryPath = strings.TrimSuffix(name, "/")
+		} else {
+			entryPath = cleanPath + "/" + strings.TrimSuffix(name, "/")
+		}
+
+		if strings.HasSuffix(name, "/") {
+			dirs = append(dirs, entryPath)
+		} else {
+			files = append(files, entryPath)
+		}
+	}
+
+	return files, dirs, nil
+}
+
+// Load loads the content of the specified file. If the file is larger than maxSize, the an error is
+// returned.
+func (c *Consul) Load(filePath string, maxSize int64) ([]byte, error) {
+	cleanPath, err := stor.CleanPath(filePath)
+	if err != nil {
+		return []byte{}, err
+	}
+
+	return c.load(stor.OpLoad, cleanPath, maxSize)
+}
+
+// load fetches a value for op.
+func (c *Consul) load(op, cleanPath string, maxSize int64) ([]byte, error) {
+	key := c.key(cleanPath)
+	query := url.Values{}
+	query.Set("raw", "")
+	resp, err := c.do(http.MethodGet, key, query, nil)
+	if err != nil {
+		return []byte{}, opError(op, cleanPath, err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode == http.StatusNotFound {
+		return []byte{}, &stor.PathDoesntExistError{Path: cleanPath}
+	}
+	if resp.StatusCode != http.StatusOK {
+		return []byte{}, statusError(op, cleanPath, http.MethodGet, key, resp)
+	}
+
+	return stor.ReadAllMax(resp.Body, maxSize, cleanPath)
+}
+
+// Save saves the data to the specified file.
+func (c *Consul) Save(filePath string, data []byte) error {
+	cleanPath, err := stor.CleanPath(filePath)
+	if err != nil {
+		return err
+	}
+
+	key := c.key(cleanPath)
+	resp, err := c.do(http.MethodPut, key, url.Values{}, data)
+	if err != nil {
+		return opError(stor.OpSave, cleanPath, err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return statusError(stor.OpSave, cleanPath, http.MethodPut, key, resp)
+	}
+
+	return nil
+}
+
+// Delete removes a file from storage.
+func (c *Consul) Delete(filePath string) error {
+	cleanPath, err := stor.CleanPath(filePath)
+	if err != nil {
+		return err
+	}
+
+	// Consul doesn't report whether a deleted key existed, so check that first
+	if _, err := c.Meta(cleanPath); err != nil {
+		return err
+	}
+
+	key := c.key(cleanPath)
+	resp, err := c.do(http.MethodDelete, key, url.Values{}, nil)
+	if err != nil {
+		return opError(stor.OpDelete, cleanPath, err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return statusError(stor.OpDelete, cleanPath, http.MethodDelete, key, resp)
+	}
+
+	return nil
+}
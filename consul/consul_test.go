@@ -0,0 +1,208 @@
+package consul
+
+import (
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/suite"
+
+	"github.com/pw1/stor"
+	"github.com/pw1/stor/memory"
+	"github.com/pw1/stor/tester"
+)
+
+// fakeConsul emulates the parts of the Consul KV HTTP API that are used by the Consul storage.
+type fakeConsul struct {
+	mutex    sync.Mutex
+	kv       map[string][]byte
+	token    string
+	requests []*http.Request
+}
+
+func newFakeConsul() *fakeConsul {
+	return &fakeConsul{kv: make(map[string][]byte)}
+}
+
+func (f *fakeConsul) reset() {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	f.kv = make(map[string][]byte)
+	f.requests = nil
+}
+
+func (f *fakeConsul) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	f.requests = append(f.requests, r)
+
+	if r.Header.Get("X-Consul-Token") != f.token {
+		w.Header().Set("X-Consul-Index", "42")
+		http.Error(w, "ACL not found", http.StatusForbidden)
+		return
+	}
+
+	key := strings.TrimPrefix(r.URL.Path, "/v1/kv/")
+	query := r.URL.Query()
+
+	switch {
+	case r.Method == http.MethodGet && query["keys"] != nil:
+		separator := query.Get("separator")
+		keySet := make(map[string]bool)
+		for k := range f.kv {
+			if !strings.HasPrefix(k, key) {
+				continue
+			}
+			rest := k[len(key):]
+			if idx := strings.Index(rest, separator); separator != "" && idx >= 0 {
+				keySet[key+rest[:idx+1]] = true
+			} else {
+				keySet[k] = true
+			}
+		}
+		if len(keySet) == 0 {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		keys := []string{}
+		for k := range keySet {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		json.NewEncoder(w).Encode(keys)
+
+	case r.Method == http.MethodGet:
+		value, ok := f.kv[key]
+		if !ok {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		w.Write(value)
+
+	case r.Method == http.MethodPut:
+		data, _ := ioutil.ReadAll(r.Body)
+		f.kv[key] = data
+		w.Write([]byte("true"))
+
+	case r.Method == http.MethodDelete:
+		delete(f.kv, key)
+		w.Write([]byte("true"))
+
+	default:
+		w.WriteHeader(http.StatusMethodNotAllowed)
+	}
+}
+
+// TestConsulStorageTester calls the generic storage tests against a fake Consul server.
+func TestConsulStorageTester(t *testing.T) {
+	fake := newFakeConsul()
+	fake.token = "secret"
+	server := httptest.NewServer(fake)
+	defer server.Close()
+
+	myConfFactory := func() *stor.Conf {
+		return &stor.Conf{
+			Type: ConsulStorageType,
+			Path: "stor/test",
+			Options: map[string]string{
+				"address": server.URL,
+				"token":   "secret",
+			},
+		}
+	}
+
+	testSuite := &tester.StorageTester{
+		ConfFactory:   myConfFactory,
+		SetupTestFunc: func(s *tester.StorageTester) { fake.reset() },
+		ReferenceFactory: func() stor.Storage {
+			m, _ := memory.New(&stor.Conf{})
+			return m
+		},
+	}
+	suite.Run(t, testSuite)
+}
+
+func TestConsulSuite(t *testing.T) {
+	suite.Run(t, new(ConsulSuite))
+}
+
+// ConsulSuite contains tests that are specific for the Consul storage.
+type ConsulSuite struct {
+	suite.Suite
+	fake   *fakeConsul
+	server *httptest.Server
+}
+
+func (s *ConsulSuite) SetupTest() {
+	s.fake = newFakeConsul()
+	s.server = httptest.NewServer(s.fake)
+}
+
+func (s *ConsulSuite) TearDownTest() {
+	s.server.Close()
+}
+
+func (s *ConsulSuite) newConsul(conf *stor.Conf) *Consul {
+	c, err := New(conf)
+	s.Require().Nil(err)
+	return c
+}
+
+func (s *ConsulSuite) TestNewInvalidAddress() {
+	c, err := New(&stor.Conf{Options: map[string]string{"address": "ftp://localhost"}})
+	s.Nil(c)
+	s.NotNil(err)
+}
+
+func (s *ConsulSuite) TestNewInvalidPrefix() {
+	c, err := New(&stor.Conf{Path: "../prefix"})
+	s.Nil(c)
+	s.True(stor.IsInvalidPathError(err))
+}
+
+func (s *ConsulSuite) TestNewDefaultAddress() {
+	c := s.newConsul(&stor.Conf{})
+	s.Equal(DefaultAddress, c.address.String())
+}
+
+func (s *ConsulSuite) TestKeyPrefix() {
+	c := s.newConsul(&stor.Conf{
+		Path:    "my/prefix",
+		Options: map[string]string{"address": s.server.URL},
+	})
+	s.Nil(c.Save("dir1/file1", []byte("test")))
+	s.Contains(s.fake.kv, "my/prefix/dir1/file1")
+}
+
+func (s *ConsulSuite) TestDatacenter() {
+	c := s.newConsul(&stor.Conf{
+		Options: map[string]string{"address": s.server.URL, "datacenter": "dc2"},
+	})
+	s.Nil(c.Save("file1", []byte("test")))
+	s.Require().Len(s.fake.requests, 1)
+	s.Equal("dc2", s.fake.requests[0].URL.Query().Get("dc"))
+}
+
+func (s *ConsulSuite) TestUnexpectedStatus() {
+	s.fake.token = "secret"
+	c := s.newConsul(&stor.Conf{Options: map[string]string{"address": s.server.URL}})
+
+	err := c.Save("file1", []byte("test"))
+	s.NotNil(err)
+	s.Contains(err.Error(), "403")
+	var opErr *stor.OpError
+	s.Require().True(errors.As(err, &opErr))
+	s.Equal(stor.OpSave, opErr.Op)
+	s.Equal("file1", opErr.Path)
+	s.Equal("42", opErr.RequestID)
+
+	_, err = c.Load("file1", 1e6)
+	s.NotNil(err)
+	s.False(stor.IsPathDoesntExistError(err))
+}
@@ -0,0 +1,31 @@
+package stor
+
+// Copier can natively copy files from another Storage of the same backend, e.g. with a
+// server-side copy, so the data isn't transferred through the caller.
+type Copier interface {
+	// CopyFrom copies the file at path in src to the same path in the Copier, and overwrites the
+	// file if it exists. If src isn't a Storage that the Copier can copy from natively, then it
+	// returns false and does nothing. If the file doesn't exist in src, then it returns true and a
+	// PathDoesntExistError.
+	// The path argument is a slash-separated path.
+	CopyFrom(src Reader, path string) (bool, error)
+}
+
+// CopyBetween copies a file from src to the same path in dst, and overwrites the file if it exists
+// in dst. If dst implements Copier and can copy from src natively (typically if both are of the
+// same backend), then the native copy is used. Otherwise the file is loaded from src with
+// LoadDefault, and saved to dst. Wrappers hide the backend that they wrap, so src and dst must be
+// the backends themselves to use the native copy.
+func CopyBetween(src Reader, dst Storage, filePath string) error {
+	if copier, ok := dst.(Copier); ok {
+		if copied, err := copier.CopyFrom(src, filePath); copied {
+			return err
+		}
+	}
+
+	data, err := LoadDefault(src, filePath)
+	if err != nil {
+		return err
+	}
+	return dst.Save(filePath, data)
+}
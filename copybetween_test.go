@@ -0,0 +1,63 @@
+package stor_test
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/suite"
+
+	"github.com/pw1/stor"
+	"github.com/pw1/stor/memory"
+)
+
+func TestCopyBetweenSuite(t *testing.T) {
+	suite.Run(t, new(CopyBetweenSuite))
+}
+
+// CopyBetweenSuite contains the tests for CopyBetween.
+type CopyBetweenSuite struct {
+	suite.Suite
+	src *memory.Memory
+	dst *memory.Memory
+}
+
+func (s *CopyBetweenSuite) SetupTest() {
+	s.src, _ = memory.New(&stor.Conf{})
+	s.dst, _ = memory.New(&stor.Conf{})
+	s.Require().Nil(s.src.Save("dir1/file1", []byte("test123")))
+}
+
+func (s *CopyBetweenSuite) TestNative() {
+	var _ stor.Copier = s.dst
+
+	s.Nil(stor.CopyBetween(s.src, s.dst, "dir1/file1"))
+	data, err := s.dst.Load("dir1/file1", 100)
+	s.Nil(err)
+	s.Equal("test123", string(data))
+}
+
+func (s *CopyBetweenSuite) TestFallback() {
+	for _, pair := range []struct {
+		src stor.Reader
+		dst stor.Storage
+	}{
+		{&plainStorage{s.src}, s.dst},
+		{s.src, &plainStorage{s.dst}},
+	} {
+		s.Nil(stor.CopyBetween(pair.src, pair.dst, "dir1/file1"))
+		data, err := pair.dst.Load("dir1/file1", 100)
+		s.Nil(err)
+		s.Equal("test123", string(data))
+		s.Nil(pair.dst.Delete("dir1/file1"))
+	}
+}
+
+func (s *CopyBetweenSuite) TestMissing() {
+	for _, src := range []stor.Reader{s.src, &plainStorage{s.src}} {
+		err := stor.CopyBetween(src, s.dst, "dir1/file2")
+		s.True(stor.IsPathDoesntExistError(err))
+	}
+
+	files, _, err := s.dst.List("")
+	s.Nil(err)
+	s.Equal([]string{}, files)
+}
@@ -0,0 +1,36 @@
+package stor
+
+// Creator can atomically create a file only if it doesn't exist yet, e.g. to implement locks or
+// "create once" semantics.
+type Creator interface {
+	// Create saves data to a new file. If the file already exists, then an AlreadyExistsError is
+	// returned and the file is not modified. If several Creates of the same file run concurrently,
+	// then exactly one of them succeeds.
+	// The path argument is a slash-separated path.
+	Create(path string, data []byte) error
+}
+
+// Create saves data to a new file, and returns an AlreadyExistsError if the file already exists. If
+// s implements Creator, then the native (atomic) create is used. Otherwise the file is checked
+// with Meta before it is saved, so concurrent calls may both succeed; use a Storage that
+// implements Creator if that matters.
+func Create(s Storage, filePath string, data []byte) error {
+	if creator, ok := s.(Creator); ok {
+		return creator.Create(filePath, data)
+	}
+
+	cleanPath, err := CleanPath(filePath)
+	if err != nil {
+		return err
+	}
+
+	_, err = s.Meta(cleanPath)
+	if err == nil {
+		return &AlreadyExistsError{Path: cleanPath}
+	}
+	if !IsPathDoesntExistError(err) {
+		return err
+	}
+
+	return s.Save(cleanPath, data)
+}
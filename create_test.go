@@ -0,0 +1,68 @@
+package stor_test
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/suite"
+
+	"github.com/pw1/stor"
+	"github.com/pw1/stor/memory"
+)
+
+func TestCreateSuite(t *testing.T) {
+	suite.Run(t, new(CreateSuite))
+}
+
+// CreateSuite contains the tests for Create.
+type CreateSuite struct {
+	suite.Suite
+	mem *memory.Memory
+}
+
+// failingMeta is a Storage of which Meta fails.
+type failingMeta struct {
+	stor.Storage
+}
+
+func (f *failingMeta) Meta(filePath string) (*stor.Meta, error) {
+	return nil, errors.New("broken")
+}
+
+func (s *CreateSuite) SetupTest() {
+	s.mem, _ = memory.New(&stor.Conf{})
+	s.Require().Nil(s.mem.Save("file1", []byte("test123")))
+}
+
+func (s *CreateSuite) TestNative() {
+	var _ stor.Creator = s.mem
+
+	s.Nil(stor.Create(s.mem, "dir1/file2", []byte("qwerty")))
+	err := stor.Create(s.mem, "file1", []byte("qwerty"))
+	s.True(stor.IsAlreadyExistsError(err))
+	s.Equal(&stor.AlreadyExistsError{Path: "file1"}, err)
+}
+
+func (s *CreateSuite) TestFallback() {
+	st := &plainStorage{s.mem}
+
+	s.Nil(stor.Create(st, "dir1//file2", []byte("qwerty")))
+	data, err := s.mem.Load("dir1/file2", 100)
+	s.Nil(err)
+	s.Equal("qwerty", string(data))
+
+	err = stor.Create(st, "file1", []byte("qwerty"))
+	s.Equal(&stor.AlreadyExistsError{Path: "file1"}, err)
+	data, _ = s.mem.Load("file1", 100)
+	s.Equal("test123", string(data))
+
+	s.True(stor.IsInvalidPathError(stor.Create(st, "../file1", nil)))
+}
+
+func (s *CreateSuite) TestFallbackMetaError() {
+	err := stor.Create(&failingMeta{s.mem}, "file2", []byte("qwerty"))
+	s.EqualError(err, "broken")
+
+	_, err = s.mem.Meta("file2")
+	s.True(stor.IsPathDoesntExistError(err), "the file isn't saved")
+}
@@ -0,0 +1,199 @@
+package stor
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strings"
+)
+
+// Redacted replaces secrets in the output of Conf.String, Credentials.String and their MarshalJSON
+// methods.
+const Redacted = "[redacted]"
+
+// Credentials contains the secrets that a backend needs to access its storage. See the
+// documentation of each backend for the fields that it uses. String and MarshalJSON redact the
+// secrets, so Credentials (and a Conf that contains them) can be logged safely. Note that this
+// means that the JSON output can't be read back; use ReadCredentialsFile to read Credentials from a
+// file.
+type Credentials struct {
+	// AccessKey identifies the account or key, e.g. the application key ID of B2. It is not
+	// considered secret.
+	AccessKey string `json:"accessKey,omitempty"`
+
+	// Secret is the secret that belongs to the AccessKey.
+	Secret string `json:"secret,omitempty"`
+
+	// Token is a token that is used instead of, or in addition to, the AccessKey and Secret.
+	Token string `json:"token,omitempty"`
+
+	// KeyFile is the path of a file that contains a key, e.g. a service account key. The path is
+	// not considered secret.
+	KeyFile string `json:"keyFile,omitempty"`
+
+	// Passphrase is a passphrase, e.g. to decrypt the KeyFile.
+	Passphrase string `json:"passphrase,omitempty"`
+}
+
+// IsZero returns true if none of the fields is set.
+func (c Credentials) IsZero() bool {
+	return c == Credentials{}
+}
+
+// redacted returns a copy of the Credentials in which the secrets are replaced with Redacted.
+func (c Credentials) redacted() Credentials {
+	redact := func(secret string) string {
+		if secret == "" {
+			return ""
+		}
+		return Redacted
+	}
+
+	c.Secret = redact(c.Secret)
+	c.Token = redact(c.Token)
+	c.Passphrase = redact(c.Passphrase)
+	return c
+}
+
+// String returns the Credentials with the secrets redacted.
+func (c Credentials) String() string {
+	r := c.redacted()
+	return fmt.Sprintf("{AccessKey:%s Secret:%s Token:%s KeyFile:%s Passphrase:%s}",
+		r.AccessKey, r.Secret, r.Token, r.KeyFile, r.Passphrase)
+}
+
+// MarshalJSON encodes the Credentials with the secrets redacted.
+func (c Credentials) MarshalJSON() ([]byte, error) {
+	// The conversion drops the methods, so this doesn't call MarshalJSON again
+	type plain Credentials
+	return json.Marshal(plain(c.redacted()))
+}
+
+// ReadCredentialsFile reads Credentials from a JSON file, e.g.:
+//
+//	{"accessKey": "...", "secret": "..."}
+//
+// Unknown fields result in an error. Errors don't contain the values in the file.
+func ReadCredentialsFile(filePath string) (Credentials, error) {
+	data, err := ioutil.ReadFile(filePath)
+	if err != nil {
+		return Credentials{}, err
+	}
+
+	type plain Credentials
+	var credentials plain
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(&credentials); err != nil {
+		return Credentials{}, fmt.Errorf("Invalid credentials file %s: %v", filePath, err)
+	}
+	return Credentials(credentials), nil
+}
+
+// CredentialsFromEnv reads Credentials from environment variables with the specified prefix
+// (DefaultEnvPrefix if it is empty). See ConfFromEnv for the variables.
+func CredentialsFromEnv(prefix string) (Credentials, error) {
+	if prefix == "" {
+		prefix = DefaultEnvPrefix
+	}
+	return credentialsFromEnv(prefix, environMap(os.Environ()))
+}
+
+// credentialsFromEnv reads Credentials from env, which maps variable names to values. Every field
+// is read from <prefix>_<FIELD>, or from the file named in <prefix>_<FIELD>_FILE. The latter is
+// useful for secrets that are mounted as files (e.g. Docker or Kubernetes secrets).
+func credentialsFromEnv(prefix string, env map[string]string) (Credentials, error) {
+	var err error
+	read := func(name string) string {
+		value := env[prefix+"_"+name]
+		fileName := env[prefix+"_"+name+"_FILE"]
+		if fileName == "" || err != nil {
+			return value
+		}
+		if value != "" {
+			err = fmt.Errorf("Invalid storage environment: %s_%s can't be combined with %s_%s_FILE",
+				prefix, name, prefix, name)
+			return ""
+		}
+
+		data, readErr := ioutil.ReadFile(fileName)
+		if readErr != nil {
+			err = readErr
+			return ""
+		}
+		// Files often end with a newline, which is never part of the secret
+		return strings.TrimRight(string(data), "\r\n")
+	}
+
+	credentials := Credentials{
+		AccessKey:  read("ACCESS_KEY"),
+		Secret:     read("SECRET"),
+		Token:      read("TOKEN"),
+		KeyFile:    env[prefix+"_KEY_FILE"],
+		Passphrase: read("PASSPHRASE"),
+	}
+	if err != nil {
+		return Credentials{}, err
+	}
+	return credentials, nil
+}
+
+// secretOptionNames are the names of options that are always redacted, also for Types that didn't
+// register an Info.
+var secretOptionNames = map[string]bool{
+	"key":        true,
+	"passphrase": true,
+	"password":   true,
+	"secret":     true,
+	"token":      true,
+}
+
+// redactedOptions returns a copy of the options of conf, in which the values of secret options
+// (see OptionInfo.Secret) are replaced with Redacted.
+func (conf Conf) redactedOptions() map[string]string {
+	if conf.Options == nil {
+		return nil
+	}
+
+	info, _ := TypeInfo(conf.Type)
+	secret := make(map[string]bool)
+	for _, option := range info.Options {
+		secret[option.Name] = option.Secret
+	}
+
+	options := make(map[string]string, len(conf.Options))
+	for name, value := range conf.Options {
+		if value != "" && (secret[name] || secretOptionNames[name]) {
+			value = Redacted
+		}
+		options[name] = value
+	}
+	return options
+}
+
+// String returns the Conf with its secrets redacted, so it can be logged safely. Secrets are the
+// Credentials and the options that are marked as secret in the Info of the Type.
+func (conf Conf) String() string {
+	return fmt.Sprintf("{Type:%s Path:%s Options:%v Credentials:%v}",
+		conf.Type, conf.Path, conf.redactedOptions(), conf.Credentials)
+}
+
+// MarshalJSON encodes the Conf with its secrets redacted (see String).
+func (conf Conf) MarshalJSON() ([]byte, error) {
+	redacted := struct {
+		Type        Type
+		Path        string
+		Options     map[string]string `json:",omitempty"`
+		Credentials *Credentials      `json:",omitempty"`
+	}{
+		Type:    conf.Type,
+		Path:    conf.Path,
+		Options: conf.redactedOptions(),
+	}
+	if !conf.Credentials.IsZero() {
+		redacted.Credentials = &conf.Credentials
+	}
+	return json.Marshal(redacted)
+}
@@ -0,0 +1,142 @@
+package stor
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/suite"
+)
+
+func TestCredentialsSuite(t *testing.T) {
+	suite.Run(t, new(CredentialsSuite))
+}
+
+// CredentialsSuite contains the tests for Credentials and the redaction of secrets.
+type CredentialsSuite struct {
+	suite.Suite
+	dir string
+}
+
+func (s *CredentialsSuite) SetupTest() {
+	dir, err := ioutil.TempDir("", "stor-credentials")
+	s.Require().Nil(err)
+	s.dir = dir
+}
+
+func (s *CredentialsSuite) TearDownTest() {
+	os.RemoveAll(s.dir)
+}
+
+func (s *CredentialsSuite) writeFile(name, content string) string {
+	filePath := filepath.Join(s.dir, name)
+	s.Require().Nil(ioutil.WriteFile(filePath, []byte(content), 0600))
+	return filePath
+}
+
+func (s *CredentialsSuite) TestString() {
+	credentials := Credentials{AccessKey: "id", Secret: "s3cr3t", Passphrase: "p4ss"}
+	s.Equal("{AccessKey:id Secret:[redacted] Token: KeyFile: Passphrase:[redacted]}",
+		credentials.String())
+	s.Equal(credentials.String(), fmt.Sprintf("%v", credentials))
+	s.Equal(credentials.String(), fmt.Sprintf("%s", &credentials))
+}
+
+func (s *CredentialsSuite) TestMarshalJSON() {
+	credentials := Credentials{AccessKey: "id", Token: "t0ken", KeyFile: "/key.json"}
+	data, err := json.Marshal(credentials)
+	s.Nil(err)
+	s.JSONEq(`{"accessKey":"id","token":"[redacted]","keyFile":"/key.json"}`, string(data))
+}
+
+func (s *CredentialsSuite) TestConfString() {
+	RegisterType("CredentialsTest", nil)
+	RegisterInfo(Info{Type: "CredentialsTest", Options: []OptionInfo{
+		{Name: "apikey", Secret: true},
+		{Name: "region"},
+	}})
+
+	conf := Conf{
+		Type:        "CredentialsTest",
+		Path:        "bucket",
+		Options:     map[string]string{"apikey": "s3cr3t", "region": "eu", "token": "t0ken"},
+		Credentials: Credentials{Secret: "s3cr3t"},
+	}
+	str := fmt.Sprintf("%v", &conf)
+	s.Equal("{Type:CredentialsTest Path:bucket Options:map[apikey:[redacted] region:eu "+
+		"token:[redacted]] Credentials:{AccessKey: Secret:[redacted] Token: KeyFile: Passphrase:}}",
+		str)
+
+	data, err := json.Marshal(&conf)
+	s.Nil(err)
+	s.NotContains(string(data), "s3cr3t")
+	s.NotContains(string(data), "t0ken")
+	s.Contains(string(data), `"region":"eu"`)
+
+	// The Options of the Conf itself are not modified
+	s.Equal("s3cr3t", conf.Options["apikey"])
+}
+
+func (s *CredentialsSuite) TestConfMarshalJSONNoCredentials() {
+	data, err := json.Marshal(&Conf{Type: "LocalDir", Path: "/data"})
+	s.Nil(err)
+	s.JSONEq(`{"Type":"LocalDir","Path":"/data"}`, string(data))
+}
+
+func (s *CredentialsSuite) TestReadCredentialsFile() {
+	filePath := s.writeFile("credentials.json", `{"accessKey": "id", "secret": "s3cr3t"}`)
+	credentials, err := ReadCredentialsFile(filePath)
+	s.Nil(err)
+	s.Equal(Credentials{AccessKey: "id", Secret: "s3cr3t"}, credentials)
+
+	filePath = s.writeFile("unknown.json", `{"accessKey": "id", "sekret": "s3cr3t"}`)
+	_, err = ReadCredentialsFile(filePath)
+	s.NotNil(err)
+	s.NotContains(err.Error(), "s3cr3t")
+
+	_, err = ReadCredentialsFile(filepath.Join(s.dir, "missing.json"))
+	s.True(os.IsNotExist(err))
+}
+
+func (s *CredentialsSuite) TestFromEnv() {
+	secretFile := s.writeFile("secret", "s3cr3t\n")
+	credentials, err := credentialsFromEnv("STOR", map[string]string{
+		"STOR_ACCESS_KEY":  "id",
+		"STOR_SECRET_FILE": secretFile,
+		"STOR_TOKEN":       "t0ken",
+		"STOR_KEY_FILE":    "/key.json",
+		"STOR_PASSPHRASE":  "p4ss",
+	})
+	s.Nil(err)
+	s.Equal(Credentials{AccessKey: "id", Secret: "s3cr3t", Token: "t0ken", KeyFile: "/key.json",
+		Passphrase: "p4ss"}, credentials)
+}
+
+func (s *CredentialsSuite) TestFromEnvErrors() {
+	_, err := credentialsFromEnv("STOR", map[string]string{
+		"STOR_SECRET":      "s3cr3t",
+		"STOR_SECRET_FILE": s.writeFile("secret", "s3cr3t"),
+	})
+	s.NotNil(err)
+	s.NotContains(err.Error(), "s3cr3t")
+
+	_, err = credentialsFromEnv("STOR", map[string]string{
+		"STOR_TOKEN_FILE": filepath.Join(s.dir, "missing"),
+	})
+	s.True(os.IsNotExist(err))
+}
+
+func (s *CredentialsSuite) TestConfFromEnv() {
+	conf, err := confFromEnviron("", []string{
+		"STOR_TYPE=LocalDir",
+		"STOR_PATH=/data",
+		"STOR_ACCESS_KEY=id",
+		"STOR_SECRET=" + strings.Repeat("x", 3),
+	})
+	s.Nil(err)
+	s.Equal(Credentials{AccessKey: "id", Secret: "xxx"}, conf.Credentials)
+}
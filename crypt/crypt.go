@@ -0,0 +1,497 @@
+// Package crypt implements a stor.Storage wrapper that transparently encrypts files with
+// AES-256-GCM.
+//
+// Every encrypted file starts with a header (see package header) with a single layer that
+// identifies the encryption format. The payload of that layer records the ID of the key that
+// encrypted it, followed by a random nonce and the sealed data:
+//
+//	[header][key ID (4 bytes, big endian)][nonce (12 bytes)][ciphertext][tag (16 bytes)]
+//
+// A Crypt can hold multiple keys in a Keyring. New files are always encrypted with the current key,
+// while files that were encrypted with older keys remain readable. ReEncrypt and ReEncryptAll
+// migrate files to the current key, after which old keys can be retired.
+//
+// If the Keyring has a NameKey, then the names of the files and directories are encrypted too (see
+// nameCipher), so the underlying Storage reveals neither contents nor names.
+//
+// Files in the format of version 1 of the layer don't record a key ID. They are decrypted by trying
+// every key of the Keyring, and are migrated to the current format by ReEncrypt.
+//
+// Without a NameKey, only the file contents are encrypted, and the paths are visible to the
+// underlying Storage. The (approximate) sizes of the files are always visible. The files are not
+// bound to their paths, so an encrypted file can be moved within the underlying Storage (e.g. by a
+// native rename).
+//
+// To combine encryption with other transformations (e.g. compression), use a Transformer in the
+// rules of a transform.Transform instead of a Crypt.
+package crypt
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"fmt"
+	"hash/fnv"
+	"math"
+	"path"
+	"sync"
+
+	"github.com/pw1/stor"
+	"github.com/pw1/stor/header"
+)
+
+const (
+	// KeySize is the size of an encryption key in bytes.
+	KeySize = 32
+
+	// DefaultIterations is the recommended number of PBKDF2 iterations for DeriveKey.
+	DefaultIterations = 600000
+
+	keyIDSize = 4
+
+	// pathLocks is the number of locks that serialize the writes of the same path.
+	pathLocks = 64
+)
+
+var (
+	// Layer identifies the encryption format in the header of encrypted files.
+	Layer = header.Layer{Name: "aes-256-gcm", Version: 2}
+
+	// LayerV1 identifies the previous encryption format, without key IDs. It can only be read.
+	LayerV1 = header.Layer{Name: "aes-256-gcm", Version: 1}
+)
+
+// Keyring contains the keys of a Crypt, by their IDs.
+type Keyring struct {
+	// Keys maps key IDs to keys. Every key must be KeySize bytes long.
+	Keys map[uint32][]byte
+
+	// Current is the ID of the key that encrypts new files.
+	Current uint32
+
+	// NameKey enables the encryption of file and directory names if it is not nil. It must be
+	// KeySize bytes long. Unlike Keys, it can't be rotated: the names are looked up by their
+	// encrypted form, so changing NameKey makes all existing files inaccessible.
+	NameKey []byte
+}
+
+// DeriveKey derives an encryption key from a passphrase with PBKDF2-HMAC-SHA256. The salt should
+// be random, at least 16 bytes long, and stored along with the configuration of the Storage; the
+// same passphrase, salt and iterations are needed to derive the same key again.
+func DeriveKey(passphrase string, salt []byte, iterations int) []byte {
+	prf := hmac.New(sha256.New, []byte(passphrase))
+
+	// A single block of PBKDF2 suffices, because KeySize equals the size of the hash
+	prf.Write(salt)
+	var blockIndex [4]byte
+	binary.BigEndian.PutUint32(blockIndex[:], 1)
+	prf.Write(blockIndex[:])
+	u := prf.Sum(nil)
+
+	key := make([]byte, len(u))
+	copy(key, u)
+	for i := 1; i < iterations; i++ {
+		prf.Reset()
+		prf.Write(u)
+		u = prf.Sum(u[:0])
+		for j := range key {
+			key[j] ^= u[j]
+		}
+	}
+
+	return key
+}
+
+// Crypt is a stor.Storage wrapper that encrypts files on Save, and decrypts them on Load.
+type Crypt struct {
+	base     stor.Storage
+	aeads    map[uint32]cipher.AEAD
+	current  uint32
+	names    *nameCipher
+	header   header.Header
+	overhead int64
+
+	// pathLocks serialize the writes of the same path (by hash), so a ReEncrypt doesn't overwrite
+	// a concurrent Save or Delete of the same file.
+	pathLocks [pathLocks]sync.Mutex
+}
+
+// New creates a new Crypt that stores its data in base, encrypted with key. The key must be
+// KeySize bytes long. It gets key ID 0.
+func New(base stor.Storage, key []byte) (*Crypt, error) {
+	return NewWithKeyring(base, Keyring{Keys: map[uint32][]byte{0: key}})
+}
+
+// NewWithKeyring creates a new Crypt that stores its data in base, encrypted with the current key
+// of ring.
+func NewWithKeyring(base stor.Storage, ring Keyring) (*Crypt, error) {
+	if _, ok := ring.Keys[ring.Current]; !ok {
+		return nil, fmt.Errorf("Invalid keyring: current key %d does not exist", ring.Current)
+	}
+
+	aeads, err := newAEADs(ring.Keys)
+	if err != nil {
+		return nil, err
+	}
+
+	var names *nameCipher
+	if ring.NameKey != nil {
+		if len(ring.NameKey) != KeySize {
+			return nil, fmt.Errorf("Invalid name key: must be %d bytes", KeySize)
+		}
+		var err error
+		if names, err = newNameCipher(ring.NameKey); err != nil {
+			return nil, err
+		}
+	}
+
+	h := header.Header{Layers: []header.Layer{Layer}}
+	aead := aeads[ring.Current]
+	c := &Crypt{
+		base:     base,
+		aeads:    aeads,
+		current:  ring.Current,
+		names:    names,
+		header:   h,
+		overhead: int64(h.Size() + keyIDSize + aead.NonceSize() + aead.Overhead()),
+	}
+	return c, nil
+}
+
+// newAEADs creates the AES-256-GCM ciphers of keys, by their IDs.
+func newAEADs(keys map[uint32][]byte) (map[uint32]cipher.AEAD, error) {
+	aeads := make(map[uint32]cipher.AEAD)
+	for id, key := range keys {
+		if len(key) != KeySize {
+			return nil, fmt.Errorf("Invalid key %d: must be %d bytes", id, KeySize)
+		}
+
+		block, err := aes.NewCipher(key)
+		if err != nil {
+			return nil, err
+		}
+		aead, err := cipher.NewGCM(block)
+		if err != nil {
+			return nil, err
+		}
+		aeads[id] = aead
+	}
+	return aeads, nil
+}
+
+// encrypt encrypts plain data with the current key, and adds the header.
+func (c *Crypt) encrypt(plain []byte) ([]byte, error) {
+	payload, err := seal(c.aeads[c.current], c.current, plain, int64(len(plain))+c.overhead)
+	if err != nil {
+		return nil, err
+	}
+	return c.header.Wrap(payload)
+}
+
+// seal encrypts plain data with the key with the given ID, and returns the payload of the layer
+// (without header). The payload is allocated with the given capacity.
+func seal(aead cipher.AEAD, id uint32, plain []byte, capacity int64) ([]byte, error) {
+	payload := make([]byte, keyIDSize+aead.NonceSize(), capacity)
+	binary.BigEndian.PutUint32(payload, id)
+	nonce := payload[keyIDSize:]
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+
+	return aead.Seal(payload, nonce, plain, nil), nil
+}
+
+// unseal decrypts the payload of the layer (without header) with the key of which the ID is
+// recorded in the payload. It also returns that ID.
+func unseal(aeads map[uint32]cipher.AEAD, payload []byte) ([]byte, uint32, error) {
+	if len(payload) < keyIDSize {
+		return nil, 0, fmt.Errorf("data is truncated")
+	}
+	id := binary.BigEndian.Uint32(payload)
+	aead, ok := aeads[id]
+	if !ok {
+		return nil, 0, fmt.Errorf("key %d is not in the keyring", id)
+	}
+
+	plain, err := open(aead, payload[keyIDSize:])
+	return plain, id, err
+}
+
+// decrypt checks the header of stored data, and decrypts it. It also returns whether the data is
+// stored in the current format with the current key.
+func (c *Crypt) decrypt(cleanPath string, stored []byte) ([]byte, bool, error) {
+	h, payload, err := header.Parse(stored)
+	if err != nil {
+		return nil, false, err
+	}
+	if len(h.Layers) != 1 || (h.Layers[0] != Layer && h.Layers[0] != LayerV1) {
+		msg := fmt.Sprintf("%s is not encrypted with %v", cleanPath, Layer)
+		return nil, false, &header.FormatError{Msg: msg}
+	}
+
+	if h.Layers[0] == LayerV1 {
+		for _, aead := range c.aeads {
+			if plain, err := open(aead, payload); err == nil {
+				return plain, false, nil
+			}
+		}
+		return nil, false, fmt.Errorf("failed to decrypt %s: data is corrupt or has another key",
+			cleanPath)
+	}
+
+	plain, id, err := unseal(c.aeads, payload)
+	if err != nil {
+		return nil, false, fmt.Errorf("failed to decrypt %s: %v", cleanPath, err)
+	}
+	return plain, id == c.current, nil
+}
+
+// open decrypts a nonce followed by sealed data.
+func open(aead cipher.AEAD, payload []byte) ([]byte, error) {
+	nonceSize := aead.NonceSize()
+	if len(payload) < nonceSize {
+		return nil, fmt.Errorf("data is truncated")
+	}
+	plain, err := aead.Open(nil, payload[:nonceSize], payload[nonceSize:], nil)
+	if err != nil {
+		return nil, fmt.Errorf("data is corrupt or has another key")
+	}
+	return plain, nil
+}
+
+// physical returns the path of a file in the underlying Storage.
+func (c *Crypt) physical(cleanPath string) string {
+	if c.names == nil {
+		return cleanPath
+	}
+	return c.names.encryptPath(cleanPath)
+}
+
+// pathLock returns the lock that serializes the writes of a path.
+func (c *Crypt) pathLock(cleanPath string) *sync.Mutex {
+	hash := fnv.New32a()
+	hash.Write([]byte(cleanPath))
+	return &c.pathLocks[hash.Sum32()%pathLocks]
+}
+
+// storedMaxSize returns the maximum size of the stored data of a file with at most maxSize bytes
+// of plain data.
+func (c *Crypt) storedMaxSize(maxSize int64) int64 {
+	if maxSize >= 0 && maxSize <= math.MaxInt64-c.overhead {
+		return maxSize + c.overhead
+	}
+	return maxSize
+}
+
+// logicalError replaces the physical path in errors of the underlying Storage.
+func logicalError(cleanPath string, err error) error {
+	switch {
+	case stor.IsPathDoesntExistError(err):
+		return &stor.PathDoesntExistError{Path: cleanPath}
+	case stor.IsTooLargeError(err):
+		return &stor.TooLargeError{What: cleanPath}
+	default:
+		return err
+	}
+}
+
+// Meta returns meta information about a file. The reported size is the size of the plain data.
+// Files in the version 1 format report a size that is 4 bytes too large, until they are
+// re-encrypted.
+func (c *Crypt) Meta(filePath string) (*stor.Meta, error) {
+	cleanPath, err := stor.CleanPath(filePath)
+	if err != nil {
+		return nil, err
+	}
+
+	meta, err := c.base.Meta(c.physical(cleanPath))
+	if err != nil {
+		return nil, logicalError(cleanPath, err)
+	}
+
+	plainMeta := *meta
+	plainMeta.Size -= c.overhead
+	if plainMeta.Size < 0 {
+		return nil, fmt.Errorf("failed to decrypt %s: data is truncated", cleanPath)
+	}
+	return &plainMeta, nil
+}
+
+// List returns the files and subdirectories within the specified directory.
+func (c *Crypt) List(dirPath string) ([]string, []string, error) {
+	if c.names == nil {
+		return c.base.List(dirPath)
+	}
+
+	cleanPath, err := stor.CleanPath(dirPath)
+	if err != nil {
+		return []string{}, []string{}, err
+	}
+
+	files, dirs, err := c.base.List(c.physical(cleanPath))
+	if err != nil {
+		return []string{}, []string{}, logicalError(cleanPath, err)
+	}
+
+	if files, err = c.logicalPaths(cleanPath, files); err != nil {
+		return []string{}, []string{}, err
+	}
+	if dirs, err = c.logicalPaths(cleanPath, dirs); err != nil {
+		return []string{}, []string{}, err
+	}
+	return files, dirs, nil
+}
+
+// logicalPaths decrypts the names of the entries of a directory, and prefixes them with the
+// cleaned path of that directory.
+func (c *Crypt) logicalPaths(cleanDir string, entries []string) ([]string, error) {
+	paths := make([]string, 0, len(entries))
+	for _, entry := range entries {
+		name, err := c.names.decryptComponent(path.Base(entry))
+		if err != nil {
+			return nil, fmt.Errorf("invalid entry %s in %q: %v", entry, cleanDir, err)
+		}
+		paths = append(paths, path.Join(cleanDir, name))
+	}
+	return paths, nil
+}
+
+// Load loads and decrypts the content of the specified file. If the plain data is larger than
+// maxSize, then an error is returned.
+func (c *Crypt) Load(filePath string, maxSize int64) ([]byte, error) {
+	cleanPath, err := stor.CleanPath(filePath)
+	if err != nil {
+		return []byte{}, err
+	}
+
+	stored, err := c.base.Load(c.physical(cleanPath), c.storedMaxSize(maxSize))
+	if err != nil {
+		return []byte{}, logicalError(cleanPath, err)
+	}
+
+	plain, _, err := c.decrypt(cleanPath, stored)
+	if err != nil {
+		return []byte{}, err
+	}
+	if int64(len(plain)) > maxSize {
+		return []byte{}, &stor.TooLargeError{What: cleanPath}
+	}
+
+	return plain, nil
+}
+
+// Save encrypts the data and saves it to the specified file.
+func (c *Crypt) Save(filePath string, data []byte) error {
+	cleanPath, err := stor.CleanPath(filePath)
+	if err != nil {
+		return err
+	}
+
+	sealed, err := c.encrypt(data)
+	if err != nil {
+		return err
+	}
+
+	pathLock := c.pathLock(cleanPath)
+	pathLock.Lock()
+	defer pathLock.Unlock()
+
+	return logicalError(cleanPath, c.base.Save(c.physical(cleanPath), sealed))
+}
+
+// Delete removes a file from storage.
+func (c *Crypt) Delete(filePath string) error {
+	cleanPath, err := stor.CleanPath(filePath)
+	if err != nil {
+		return err
+	}
+
+	pathLock := c.pathLock(cleanPath)
+	pathLock.Lock()
+	defer pathLock.Unlock()
+
+	return logicalError(cleanPath, c.base.Delete(c.physical(cleanPath)))
+}
+
+// ReEncrypt encrypts a file with the current key, if it isn't already. It returns true if the
+// file was re-encrypted. Files can be re-encrypted while the Crypt is in use; Saves and Deletes
+// through the same Crypt are never overwritten by a ReEncrypt. A stor.TooLargeError is returned
+// for files that are larger than stor.GetDefaultMaxSize.
+func (c *Crypt) ReEncrypt(filePath string) (bool, error) {
+	cleanPath, err := stor.CleanPath(filePath)
+	if err != nil {
+		return false, err
+	}
+
+	pathLock := c.pathLock(cleanPath)
+	pathLock.Lock()
+	defer pathLock.Unlock()
+
+	stored, err := c.base.Load(c.physical(cleanPath), c.storedMaxSize(stor.GetDefaultMaxSize()))
+	if err != nil {
+		return false, logicalError(cleanPath, err)
+	}
+
+	plain, current, err := c.decrypt(cleanPath, stored)
+	if err != nil || current {
+		return false, err
+	}
+
+	sealed, err := c.encrypt(plain)
+	if err != nil {
+		return false, err
+	}
+	if err := c.base.Save(c.physical(cleanPath), sealed); err != nil {
+		return false, logicalError(cleanPath, err)
+	}
+	return true, nil
+}
+
+// ReEncryptAll re-encrypts all files within a directory (and its subdirectories) with the current
+// key. It returns the number of re-encrypted files. If it fails half-way, it can simply be called
+// again.
+func (c *Crypt) ReEncryptAll(dirPath string) (int, error) {
+	cleanPath, err := stor.CleanPath(dirPath)
+	if err != nil {
+		return 0, err
+	}
+
+	count := 0
+	dirs := []string{cleanPath}
+	for len(dirs) > 0 {
+		files, subdirs, err := c.List(dirs[0])
+		if err != nil {
+			return count, err
+		}
+		dirs = append(dirs[1:], subdirs...)
+
+		for _, file := range files {
+			reEncrypted, err := c.ReEncrypt(file)
+			if stor.IsPathDoesntExistError(err) {
+				// Deleted in the meantime
+				continue
+			}
+			if err != nil {
+				return count, err
+			}
+			if reEncrypted {
+				count++
+			}
+		}
+	}
+
+	return count, nil
+}
+
+// Type returns the Type of the base Storage.
+func (c *Crypt) Type() stor.Type {
+	return stor.TypeOf(c.base)
+}
+
+// Close closes the base Storage.
+func (c *Crypt) Close() error {
+	return stor.Close(c.base)
+}
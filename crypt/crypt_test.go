@@ -0,0 +1,366 @@
+package crypt
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"encoding/hex"
+	"testing"
+
+	"github.com/stretchr/testify/suite"
+
+	"github.com/pw1/stor"
+	"github.com/pw1/stor/header"
+	"github.com/pw1/stor/memory"
+	"github.com/pw1/stor/tester"
+)
+
+var (
+	testKey     = bytes.Repeat([]byte{0x42}, KeySize)
+	testNameKey = bytes.Repeat([]byte{0x24}, KeySize)
+)
+
+// TestCryptStorageTester calls the generic storage tests
+func TestCryptStorageTester(t *testing.T) {
+	testSuite := &tester.StorageTester{
+		SetupTestFunc: func(s *tester.StorageTester) {
+			base, _ := memory.New(&stor.Conf{})
+			st, err := New(base, testKey)
+			s.Require().Nil(err)
+			s.Storage = st
+		},
+	}
+
+	suite.Run(t, testSuite)
+}
+
+// TestCryptNamesStorageTester calls the generic storage tests with encrypted names
+func TestCryptNamesStorageTester(t *testing.T) {
+	testSuite := &tester.StorageTester{
+		SetupTestFunc: func(s *tester.StorageTester) {
+			base, _ := memory.New(&stor.Conf{})
+			st, err := NewWithKeyring(base,
+				Keyring{Keys: map[uint32][]byte{0: testKey}, NameKey: testNameKey})
+			s.Require().Nil(err)
+			s.Storage = st
+		},
+	}
+
+	suite.Run(t, testSuite)
+}
+
+func TestCryptSuite(t *testing.T) {
+	suite.Run(t, new(CryptSuite))
+}
+
+// CryptSuite contains tests that are specific for the Crypt wrapper.
+type CryptSuite struct {
+	suite.Suite
+	base *memory.Memory
+	st   *Crypt
+}
+
+func (s *CryptSuite) SetupTest() {
+	s.base, _ = memory.New(&stor.Conf{})
+
+	var err error
+	s.st, err = New(s.base, testKey)
+	s.Require().Nil(err)
+}
+
+func (s *CryptSuite) TestNewInvalidKey() {
+	_, err := New(s.base, []byte("short"))
+	s.NotNil(err)
+}
+
+func (s *CryptSuite) TestEncrypted() {
+	plain := []byte("secret data secret data")
+	s.Require().Nil(s.st.Save("file1", plain))
+
+	stored, err := s.base.Load("file1", 1000)
+	s.Nil(err)
+	s.False(bytes.Contains(stored, []byte("secret")))
+
+	h, _, err := header.Parse(stored)
+	s.Nil(err)
+	s.Equal([]header.Layer{Layer}, h.Layers)
+
+	// Every save uses a new nonce
+	s.Require().Nil(s.st.Save("file2", plain))
+	stored2, err := s.base.Load("file2", 1000)
+	s.Nil(err)
+	s.NotEqual(stored, stored2)
+}
+
+func (s *CryptSuite) TestMetaPlainSize() {
+	s.Require().Nil(s.st.Save("file1", []byte("test123")))
+
+	meta, err := s.st.Meta("file1")
+	s.Nil(err)
+	s.Equal(int64(7), meta.Size)
+}
+
+func (s *CryptSuite) TestLoadMaxSize() {
+	s.Require().Nil(s.st.Save("file1", []byte("test123")))
+
+	data, err := s.st.Load("file1", 7)
+	s.Nil(err)
+	s.Equal("test123", string(data))
+
+	_, err = s.st.Load("file1", 6)
+	s.True(stor.IsTooLargeError(err))
+
+	_, err = s.st.Load("file1", -1)
+	s.True(stor.IsTooLargeError(err))
+}
+
+func (s *CryptSuite) TestWrongKey() {
+	s.Require().Nil(s.st.Save("file1", []byte("test123")))
+
+	other, err := New(s.base, bytes.Repeat([]byte{0x43}, KeySize))
+	s.Require().Nil(err)
+
+	_, err = other.Load("file1", 100)
+	s.NotNil(err)
+	s.False(stor.IsPathDoesntExistError(err))
+}
+
+func (s *CryptSuite) TestTampered() {
+	s.Require().Nil(s.st.Save("file1", []byte("test123")))
+
+	stored, err := s.base.Load("file1", 1000)
+	s.Require().Nil(err)
+	stored[len(stored)-1] ^= 1
+	s.Require().Nil(s.base.Save("file1", stored))
+
+	_, err = s.st.Load("file1", 100)
+	s.NotNil(err)
+}
+
+func (s *CryptSuite) TestNotEncrypted() {
+	s.Require().Nil(s.base.Save("file1", []byte("plain")))
+
+	_, err := s.st.Load("file1", 100)
+	s.True(header.IsFormatError(err))
+}
+
+func (s *CryptSuite) TestDeriveKey() {
+	// Test vector of PBKDF2-HMAC-SHA256 from RFC 7914, section 11
+	key := DeriveKey("passwd", []byte("salt"), 1)
+	s.Equal("55ac046e56e3089fec1691c22544b605f94185216dde0465e68b9d57c20dacbc",
+		hex.EncodeToString(key))
+
+	key = DeriveKey("Password", []byte("NaCl"), 80000)
+	s.Equal("4ddcd8f60b98be21830cee5ef22701f9641a4418d04c0414aeff08876b34ab56",
+		hex.EncodeToString(key))
+}
+
+func (s *CryptSuite) TestNewWithKeyringInvalid() {
+	_, err := NewWithKeyring(s.base, Keyring{Keys: map[uint32][]byte{1: testKey}, Current: 2})
+	s.NotNil(err)
+
+	_, err = NewWithKeyring(s.base, Keyring{Keys: map[uint32][]byte{1: testKey, 2: []byte("x")}})
+	s.NotNil(err)
+}
+
+func (s *CryptSuite) TestKeyRotation() {
+	key1 := bytes.Repeat([]byte{1}, KeySize)
+	key2 := bytes.Repeat([]byte{2}, KeySize)
+
+	old, err := NewWithKeyring(s.base, Keyring{Keys: map[uint32][]byte{1: key1}, Current: 1})
+	s.Require().Nil(err)
+	s.Require().Nil(old.Save("file1", []byte("test123")))
+	s.Require().Nil(old.Save("dir1/file2", []byte("test456")))
+
+	rotated, err := NewWithKeyring(s.base,
+		Keyring{Keys: map[uint32][]byte{1: key1, 2: key2}, Current: 2})
+	s.Require().Nil(err)
+	s.Require().Nil(rotated.Save("file3", []byte("test789")))
+
+	// Old files remain readable
+	data, err := rotated.Load("dir1/file2", 100)
+	s.Nil(err)
+	s.Equal("test456", string(data))
+
+	count, err := rotated.ReEncryptAll("")
+	s.Nil(err)
+	s.Equal(2, count)
+
+	count, err = rotated.ReEncryptAll("")
+	s.Nil(err)
+	s.Equal(0, count, "all files use the current key")
+
+	// The old key can be retired
+	retired, err := NewWithKeyring(s.base, Keyring{Keys: map[uint32][]byte{2: key2}, Current: 2})
+	s.Require().Nil(err)
+	for filePath, content := range map[string]string{
+		"file1": "test123", "dir1/file2": "test456", "file3": "test789"} {
+		data, err := retired.Load(filePath, 100)
+		s.Nil(err)
+		s.Equal(content, string(data))
+	}
+
+	// Files of retired keys can't be read
+	s.Require().Nil(old.Save("file4", []byte("test")))
+	_, err = retired.Load("file4", 100)
+	s.NotNil(err)
+	s.Contains(err.Error(), "key 1")
+}
+
+func (s *CryptSuite) TestReEncryptV1() {
+	// Encrypt a file in the version 1 format, without key ID
+	block, _ := aes.NewCipher(testKey)
+	aead, _ := cipher.NewGCM(block)
+	nonce := make([]byte, aead.NonceSize())
+	stored, err := header.Header{Layers: []header.Layer{LayerV1}}.Wrap(
+		aead.Seal(nonce, nonce, []byte("test123"), nil))
+	s.Require().Nil(err)
+	s.Require().Nil(s.base.Save("file1", stored))
+
+	data, err := s.st.Load("file1", 100)
+	s.Nil(err)
+	s.Equal("test123", string(data))
+
+	reEncrypted, err := s.st.ReEncrypt("file1")
+	s.Nil(err)
+	s.True(reEncrypted)
+
+	meta, err := s.st.Meta("file1")
+	s.Nil(err)
+	s.Equal(int64(7), meta.Size)
+
+	_, err = s.st.ReEncrypt("nonexistent")
+	s.True(stor.IsPathDoesntExistError(err))
+}
+
+func (s *CryptSuite) TestReEncryptMaxSize() {
+	defer stor.SetDefaultMaxSize(stor.GetDefaultMaxSize())
+	stor.SetDefaultMaxSize(100)
+
+	key1 := bytes.Repeat([]byte{0x01}, KeySize)
+	old, err := NewWithKeyring(s.base, Keyring{Keys: map[uint32][]byte{1: key1}, Current: 1})
+	s.Require().Nil(err)
+	s.Require().Nil(old.Save("small", make([]byte, 100)))
+	s.Require().Nil(old.Save("large", make([]byte, 101)))
+
+	rotated, err := NewWithKeyring(s.base,
+		Keyring{Keys: map[uint32][]byte{1: key1, 2: testKey}, Current: 2})
+	s.Require().Nil(err)
+	reEncrypted, err := rotated.ReEncrypt("small")
+	s.Nil(err)
+	s.True(reEncrypted)
+	_, err = rotated.ReEncrypt("large")
+	s.True(stor.IsTooLargeError(err))
+}
+
+// blockingLoad is a Storage of which the Loads block until unblock is closed.
+type blockingLoad struct {
+	*memory.Memory
+	loading chan struct{}
+	unblock chan struct{}
+}
+
+func (b *blockingLoad) Load(filePath string, maxSize int64) ([]byte, error) {
+	b.loading <- struct{}{}
+	<-b.unblock
+	return b.Memory.Load(filePath, maxSize)
+}
+
+func (s *CryptSuite) TestReEncryptLocksPath() {
+	key1 := bytes.Repeat([]byte{0x01}, KeySize)
+	old, err := NewWithKeyring(s.base, Keyring{Keys: map[uint32][]byte{1: key1}, Current: 1})
+	s.Require().Nil(err)
+	s.Require().Nil(old.Save("file1", []byte("test123")))
+
+	base := &blockingLoad{s.base, make(chan struct{}), make(chan struct{})}
+	rotated, err := NewWithKeyring(base,
+		Keyring{Keys: map[uint32][]byte{1: key1, 2: testKey}, Current: 2})
+	s.Require().Nil(err)
+
+	done := make(chan error)
+	go func() {
+		_, err := rotated.ReEncrypt("file1")
+		done <- err
+	}()
+	<-base.loading
+
+	// A ReEncrypt doesn't hold up the writes of other files
+	s.Nil(rotated.Save("file2", []byte("test456")))
+	s.Nil(rotated.Delete("file2"))
+
+	close(base.unblock)
+	s.Nil(<-done)
+}
+
+func (s *CryptSuite) TestEncryptedNames() {
+	st, err := NewWithKeyring(s.base,
+		Keyring{Keys: map[uint32][]byte{0: testKey}, NameKey: testNameKey})
+	s.Require().Nil(err)
+
+	s.Require().Nil(st.Save("secret/report.txt", []byte("test123")))
+	s.Require().Nil(st.Save("secret/other.txt", []byte("test456")))
+
+	_, dirs, err := s.base.List("")
+	s.Nil(err)
+	s.Len(dirs, 1)
+	s.NotContains(dirs[0], "secret")
+
+	files, _, err := s.base.List(dirs[0])
+	s.Nil(err)
+	s.Len(files, 2)
+	for _, file := range files {
+		s.NotContains(file, "report")
+		s.NotContains(file, "other")
+		_, err := stor.CleanPath(file)
+		s.Nil(err)
+	}
+
+	files, dirs, err = st.List("secret")
+	s.Nil(err)
+	s.ElementsMatch([]string{"secret/report.txt", "secret/other.txt"}, files)
+	s.Empty(dirs)
+
+	_, err = st.Load("secret/missing.txt", 100)
+	s.Equal(&stor.PathDoesntExistError{Path: "secret/missing.txt"}, err)
+
+	count, err := st.ReEncryptAll("")
+	s.Nil(err)
+	s.Equal(0, count)
+
+	// Another name key can't find or list the files
+	other, err := NewWithKeyring(s.base, Keyring{
+		Keys: map[uint32][]byte{0: testKey}, NameKey: bytes.Repeat([]byte{1}, KeySize)})
+	s.Require().Nil(err)
+	_, err = other.Load("secret/report.txt", 100)
+	s.True(stor.IsPathDoesntExistError(err))
+	_, _, err = other.List("")
+	s.NotNil(err)
+}
+
+func (s *CryptSuite) TestInvalidNameKey() {
+	_, err := NewWithKeyring(s.base,
+		Keyring{Keys: map[uint32][]byte{0: testKey}, NameKey: []byte("short")})
+	s.NotNil(err)
+}
+
+func (s *CryptSuite) TestNameCipher() {
+	names, err := newNameCipher(testNameKey)
+	s.Require().Nil(err)
+
+	for _, component := range []string{"a", "file1", stor.ValidBytes} {
+		encrypted := names.encryptComponent(component)
+		s.Equal(encrypted, names.encryptComponent(component), "deterministic")
+
+		decrypted, err := names.decryptComponent(encrypted)
+		s.Nil(err)
+		s.Equal(component, decrypted)
+	}
+
+	_, err = names.decryptComponent("file1")
+	s.NotNil(err)
+
+	encrypted := []byte(names.encryptComponent("file1"))
+	encrypted[len(encrypted)-1] ^= 1
+	_, err = names.decryptComponent(string(encrypted))
+	s.NotNil(err)
+}
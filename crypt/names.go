@@ -0,0 +1,93 @@
+package crypt
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"strings"
+)
+
+// sivSize is the size of the synthetic IV of an encrypted name.
+const sivSize = 16
+
+// nameCipher deterministically encrypts the components of paths, so an encrypted path can be
+// looked up in the underlying Storage.
+//
+// Every component is encrypted separately, with a construction similar to AES-SIV: the IV is the
+// (truncated) HMAC-SHA256 of the plain component, and the component is encrypted with AES-CTR
+// using that IV. The encrypted component is the base64 (URL alphabet, without padding) encoding of
+// the IV followed by the ciphertext, which only contains bytes that are valid in Storage paths.
+// Equal components encrypt to equal names, but nothing else about them is revealed except their
+// length.
+type nameCipher struct {
+	macKey []byte
+	block  cipher.Block
+}
+
+// newNameCipher creates a nameCipher from a key of KeySize bytes.
+func newNameCipher(key []byte) (*nameCipher, error) {
+	block, err := aes.NewCipher(deriveSubkey(key, "name encryption"))
+	if err != nil {
+		return nil, err
+	}
+
+	n := &nameCipher{
+		macKey: deriveSubkey(key, "name authentication"),
+		block:  block,
+	}
+	return n, nil
+}
+
+// deriveSubkey derives an independent key for a purpose from a key.
+func deriveSubkey(key []byte, purpose string) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte("stor/crypt " + purpose))
+	return mac.Sum(nil)
+}
+
+// siv returns the synthetic IV of a plain component.
+func (n *nameCipher) siv(component string) []byte {
+	mac := hmac.New(sha256.New, n.macKey)
+	mac.Write([]byte(component))
+	return mac.Sum(nil)[:sivSize]
+}
+
+// encryptComponent encrypts a single path component.
+func (n *nameCipher) encryptComponent(component string) string {
+	out := make([]byte, sivSize+len(component))
+	iv := n.siv(component)
+	copy(out, iv)
+	cipher.NewCTR(n.block, iv).XORKeyStream(out[sivSize:], []byte(component))
+	return base64.RawURLEncoding.EncodeToString(out)
+}
+
+// decryptComponent decrypts a single path component, and verifies its authenticity.
+func (n *nameCipher) decryptComponent(encrypted string) (string, error) {
+	raw, err := base64.RawURLEncoding.DecodeString(encrypted)
+	if err != nil || len(raw) <= sivSize {
+		return "", errors.New("not an encrypted name")
+	}
+
+	plain := make([]byte, len(raw)-sivSize)
+	cipher.NewCTR(n.block, raw[:sivSize]).XORKeyStream(plain, raw[sivSize:])
+	if !hmac.Equal(n.siv(string(plain)), raw[:sivSize]) {
+		return "", errors.New("encrypted name is corrupt or has another key")
+	}
+	return string(plain), nil
+}
+
+// encryptPath encrypts every component of a cleaned path.
+func (n *nameCipher) encryptPath(cleanPath string) string {
+	if cleanPath == "" {
+		return ""
+	}
+
+	components := strings.Split(cleanPath, "/")
+	for i, component := range components {
+		components[i] = n.encryptComponent(component)
+	}
+	return strings.Join(components, "/")
+}
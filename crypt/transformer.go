@@ -0,0 +1,65 @@
+package crypt
+
+import (
+	"crypto/cipher"
+	"fmt"
+
+	"github.com/pw1/stor"
+	"github.com/pw1/stor/header"
+)
+
+// Transformer encrypts data like a Crypt, as a transform.Transformer. This allows encryption to be
+// combined with other Transformers (e.g. compression), and to be configured per path prefix, by a
+// transform.Transform. The payload of its layer has the same format as that of the files of a
+// Crypt.
+//
+// The decoder of the layer needs the keys, so it isn't registered with header.RegisterDecoder.
+// Encrypted files can therefore only be loaded by a Transform that has a Transformer with the
+// same keys in one of its rules.
+type Transformer struct {
+	aeads   map[uint32]cipher.AEAD
+	current uint32
+}
+
+// NewTransformer creates a new Transformer that encrypts with the current key of ring. Names
+// aren't encrypted by a Transformer, so ring must not have a NameKey.
+func NewTransformer(ring Keyring) (*Transformer, error) {
+	if _, ok := ring.Keys[ring.Current]; !ok {
+		return nil, fmt.Errorf("Invalid keyring: current key %d does not exist", ring.Current)
+	}
+	if ring.NameKey != nil {
+		return nil, fmt.Errorf("Invalid keyring: a Transformer can't encrypt names")
+	}
+
+	aeads, err := newAEADs(ring.Keys)
+	if err != nil {
+		return nil, err
+	}
+	return &Transformer{aeads: aeads, current: ring.Current}, nil
+}
+
+// Layer returns Layer.
+func (t *Transformer) Layer() header.Layer {
+	return Layer
+}
+
+// Encode encrypts data with the current key.
+func (t *Transformer) Encode(data []byte) ([]byte, error) {
+	aead := t.aeads[t.current]
+	return seal(aead, t.current, data, int64(keyIDSize+aead.NonceSize()+len(data)+aead.Overhead()))
+}
+
+// Decode decrypts data with the key that encrypted it. The plain data is as large as the
+// ciphertext, so data that is too large is rejected before it is decrypted.
+func (t *Transformer) Decode(data []byte, maxSize int64) ([]byte, error) {
+	aead := t.aeads[t.current]
+	if int64(len(data)-keyIDSize-aead.NonceSize()-aead.Overhead()) > maxSize {
+		return nil, &stor.TooLargeError{What: "decrypted data"}
+	}
+
+	plain, _, err := unseal(t.aeads, data)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decrypt: %v", err)
+	}
+	return plain, nil
+}
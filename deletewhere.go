@@ -0,0 +1,160 @@
+package stor
+
+import (
+	"errors"
+	"io/fs"
+	"time"
+)
+
+// DefaultDeleteBatchSize is the default number of files that DeleteWhere deletes per batch.
+const DefaultDeleteBatchSize = 100
+
+// DeleteOptions configures DeleteWhere.
+type DeleteOptions struct {
+	// BatchSize is the number of files that is deleted per batch. The files of a batch are
+	// collected before they are deleted, and the progress is reported after every batch. The
+	// default (0) is DefaultDeleteBatchSize.
+	BatchSize int
+
+	// OnProgress is called after every batch, if it is set.
+	OnProgress func(DeleteProgress)
+}
+
+// DeleteProgress reports the progress of DeleteWhere.
+type DeleteProgress struct {
+	// Checked is the number of files that has been checked.
+	Checked int64
+
+	// Deleted is the number of files that has been deleted.
+	Deleted int64
+
+	// Bytes is the total size of the deleted files.
+	Bytes int64
+
+	// UnknownAge is the number of files that has been kept because the Storage doesn't report
+	// their ModTime.
+	UnknownAge int64
+}
+
+// DeleteWhere deletes the files within the directory prefix and its subdirectories that were last
+// saved before olderThan, e.g. for retention cleanups. If olderThan is zero, then all files within
+// prefix are deleted. Files of which the Storage doesn't report the ModTime are only deleted if
+// olderThan is zero; otherwise they are counted as UnknownAge. Files that are deleted concurrently
+// are skipped, and so are files that are saved again after they were listed: the ModTime of every
+// file is checked again right before it is deleted.
+//
+// The directories are listed with ListWithMeta, and the files are deleted in batches. If
+// DeleteWhere fails, then the returned DeleteProgress contains the files that were deleted so far.
+// Calling it again with the same arguments continues the cleanup.
+func DeleteWhere(s Storage, prefix string, olderThan time.Time,
+	options DeleteOptions) (DeleteProgress, error) {
+	cleanPrefix, err := CleanPath(prefix)
+	if err != nil {
+		return DeleteProgress{}, err
+	}
+	batchSize := options.BatchSize
+	if batchSize <= 0 {
+		batchSize = DefaultDeleteBatchSize
+	}
+
+	d := &deleter{s: s, olderThan: olderThan, batchSize: batchSize, options: options}
+	if err := d.deleteWithin(cleanPrefix); err != nil {
+		return d.progress, err
+	}
+	if err := d.flush(); err != nil {
+		return d.progress, err
+	}
+	return d.progress, nil
+}
+
+// deleter holds the state of DeleteWhere.
+type deleter struct {
+	s         Storage
+	olderThan time.Time
+	batchSize int
+	options   DeleteOptions
+
+	batch    []Entry
+	progress DeleteProgress
+}
+
+// deleteWithin adds the matching files within dirPath and its subdirectories to batches, and
+// deletes every full batch.
+func (d *deleter) deleteWithin(dirPath string) error {
+	entries, dirs, err := ListWithMeta(d.s, dirPath)
+	// A directory that doesn't exist (anymore) contains no files
+	if IsPathDoesntExistError(err) || errors.Is(err, fs.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	for _, entry := range entries {
+		d.progress.Checked++
+		if !d.olderThan.IsZero() {
+			if entry.Meta.ModTime.IsZero() {
+				d.progress.UnknownAge++
+				continue
+			}
+			if !entry.Meta.ModTime.Before(d.olderThan) {
+				continue
+			}
+		}
+
+		d.batch = append(d.batch, entry)
+		if len(d.batch) >= d.batchSize {
+			if err := d.flush(); err != nil {
+				return err
+			}
+		}
+	}
+
+	for _, dir := range dirs {
+		if err := d.deleteWithin(dir); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// flush deletes the files of the batch, and reports the progress. Files that have been saved since
+// they were listed are kept.
+func (d *deleter) flush() error {
+	if len(d.batch) == 0 {
+		return nil
+	}
+
+	for _, entry := range d.batch {
+		size := entry.Meta.Size
+		if !d.olderThan.IsZero() {
+			meta, err := d.s.Meta(entry.Path)
+			if IsPathDoesntExistError(err) {
+				continue
+			}
+			if err != nil {
+				return err
+			}
+			if !meta.ModTime.Before(d.olderThan) {
+				continue
+			}
+			size = meta.Size
+		}
+
+		err := d.s.Delete(entry.Path)
+		if IsPathDoesntExistError(err) {
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		d.progress.Deleted++
+		d.progress.Bytes += size
+	}
+	d.batch = d.batch[:0]
+
+	if d.options.OnProgress != nil {
+		d.options.OnProgress(d.progress)
+	}
+	return nil
+}
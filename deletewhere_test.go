@@ -0,0 +1,146 @@
+package stor_test
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/suite"
+
+	"github.com/pw1/stor"
+	"github.com/pw1/stor/localdir"
+	"github.com/pw1/stor/memory"
+)
+
+func TestDeleteWhereSuite(t *testing.T) {
+	suite.Run(t, new(DeleteWhereSuite))
+}
+
+// DeleteWhereSuite contains the tests for DeleteWhere.
+type DeleteWhereSuite struct {
+	suite.Suite
+	dir      string
+	localDir *localdir.LocalDir
+	now      time.Time
+}
+
+// failingDelete is a Storage of which Delete fails.
+type failingDelete struct {
+	stor.Storage
+}
+
+func (f *failingDelete) Delete(filePath string) error {
+	return errors.New("broken")
+}
+
+// resavingDelete is a Storage that saves a file again on the first Delete, after DeleteWhere has
+// listed it.
+type resavingDelete struct {
+	stor.Storage
+	resave string
+}
+
+func (r *resavingDelete) Delete(filePath string) error {
+	if r.resave != "" {
+		if err := r.Storage.Save(r.resave, []byte("new")); err != nil {
+			return err
+		}
+		r.resave = ""
+	}
+	return r.Storage.Delete(filePath)
+}
+
+func (s *DeleteWhereSuite) SetupTest() {
+	s.dir = s.T().TempDir()
+	var err error
+	s.localDir, err = localdir.New(&stor.Conf{Path: s.dir})
+	s.Require().Nil(err)
+
+	// The files are one to five days old
+	s.now = time.Now()
+	ages := map[string]int{
+		"logs/app/day1":     1,
+		"logs/app/day3":     3,
+		"logs/app/day5":     5,
+		"logs/db/day4":      4,
+		"logs/day2":         2,
+		"other/day5":        5,
+		"logs.txt":          5,
+		"logs/db/sub/day5a": 5,
+	}
+	for filePath, age := range ages {
+		s.Require().Nil(s.localDir.Save(filePath, []byte("test123")))
+		modTime := s.now.Add(-time.Duration(age) * 24 * time.Hour)
+		s.Require().Nil(os.Chtimes(filepath.Join(s.dir, filepath.FromSlash(filePath)), modTime,
+			modTime))
+	}
+}
+
+// remaining returns the files that remain.
+func (s *DeleteWhereSuite) remaining() []string {
+	files, err := stor.ListRecursive(s.localDir, "", 100)
+	s.Require().Nil(err)
+	return files
+}
+
+func (s *DeleteWhereSuite) TestOlderThan() {
+	var reported []stor.DeleteProgress
+	progress, err := stor.DeleteWhere(s.localDir, "logs/", s.now.Add(-72*time.Hour+time.Minute),
+		stor.DeleteOptions{BatchSize: 2, OnProgress: func(p stor.DeleteProgress) {
+			reported = append(reported, p)
+		}})
+	s.Nil(err)
+	s.Equal(stor.DeleteProgress{Checked: 6, Deleted: 4, Bytes: 28}, progress)
+	s.Equal([]string{"logs.txt", "logs/app/day1", "logs/day2", "other/day5"}, s.remaining())
+
+	s.Require().Len(reported, 2)
+	s.Equal(int64(2), reported[0].Deleted)
+	s.Equal(progress, reported[1])
+}
+
+func (s *DeleteWhereSuite) TestAll() {
+	progress, err := stor.DeleteWhere(s.localDir, "logs", time.Time{}, stor.DeleteOptions{})
+	s.Nil(err)
+	s.Equal(int64(6), progress.Deleted)
+	s.Equal([]string{"logs.txt", "other/day5"}, s.remaining())
+
+	progress, err = stor.DeleteWhere(s.localDir, "logs", time.Time{}, stor.DeleteOptions{})
+	s.Nil(err)
+	s.Equal(stor.DeleteProgress{}, progress, "a missing directory is empty")
+}
+
+func (s *DeleteWhereSuite) TestResaved() {
+	storage := &resavingDelete{Storage: s.localDir, resave: "logs/app/day5"}
+	progress, err := stor.DeleteWhere(storage, "logs/app", s.now.Add(-48*time.Hour),
+		stor.DeleteOptions{})
+	s.Nil(err)
+	s.Equal(stor.DeleteProgress{Checked: 3, Deleted: 1, Bytes: 7}, progress)
+	s.Equal([]string{"logs.txt", "logs/app/day1", "logs/app/day5", "logs/day2", "logs/db/day4",
+		"logs/db/sub/day5a", "other/day5"}, s.remaining())
+}
+
+func (s *DeleteWhereSuite) TestUnknownAge() {
+	mem, _ := memory.New(&stor.Conf{})
+	s.Require().Nil(mem.Save("logs/day1", []byte("test123")))
+
+	progress, err := stor.DeleteWhere(mem, "logs", s.now, stor.DeleteOptions{})
+	s.Nil(err)
+	s.Equal(stor.DeleteProgress{Checked: 1, UnknownAge: 1}, progress)
+
+	progress, err = stor.DeleteWhere(mem, "logs", time.Time{}, stor.DeleteOptions{})
+	s.Nil(err)
+	s.Equal(stor.DeleteProgress{Checked: 1, Deleted: 1, Bytes: 7}, progress)
+}
+
+func (s *DeleteWhereSuite) TestErrors() {
+	_, err := stor.DeleteWhere(s.localDir, "../logs", time.Time{}, stor.DeleteOptions{})
+	s.True(stor.IsInvalidPathError(err))
+
+	progress, err := stor.DeleteWhere(&failingDelete{s.localDir}, "logs", time.Time{},
+		stor.DeleteOptions{})
+	s.EqualError(err, "broken")
+	s.Equal(int64(0), progress.Deleted)
+	s.Len(s.remaining(), 8)
+}
@@ -0,0 +1,19 @@
+package stor
+
+// DirMaker can represent directories explicitly. Without it, directories only exist implicitly,
+// as long as they contain files.
+type DirMaker interface {
+	// MkDir creates a directory, and its parents if they don't exist. It is not an error if the
+	// directory already exists. If a file exists at the path, then an AlreadyExistsError is
+	// returned. The created directory is returned by List, also while it is empty. Whether it is
+	// pruned when its last file is deleted again depends on the Storage (e.g. whether it keeps
+	// empty directories).
+	// The path argument is a slash-separated path.
+	MkDir(path string) error
+
+	// RemoveDir removes an empty directory. Its parent directories are not removed. If the
+	// directory doesn't exist, then a PathDoesntExistError is returned, and if it contains files
+	// or subdirectories, a DirNotEmptyError.
+	// The path argument is a slash-separated path.
+	RemoveDir(path string) error
+}
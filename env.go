@@ -0,0 +1,106 @@
+package stor
+
+import (
+	"fmt"
+	"os"
+	"strings"
+)
+
+// DefaultEnvPrefix is the prefix of the environment variables of NewFromEnv if none is specified.
+const DefaultEnvPrefix = "STOR"
+
+// ConfFromEnv reads a Conf from environment variables with the specified prefix (DefaultEnvPrefix
+// if it is empty):
+//
+//	<PREFIX>_URL              Storage URL (see ParseURL). Can't be combined with TYPE and PATH.
+//	<PREFIX>_TYPE             Type, e.g. "LocalDir".
+//	<PREFIX>_PATH             Path.
+//	<PREFIX>_<TYPE>_<OPTION>  Option of the backend, e.g. STOR_B2_KEYID for Options["keyid"].
+//	<PREFIX>_ACCESS_KEY       Credentials.AccessKey.
+//	<PREFIX>_SECRET           Credentials.Secret.
+//	<PREFIX>_TOKEN            Credentials.Token.
+//	<PREFIX>_KEY_FILE         Credentials.KeyFile.
+//	<PREFIX>_PASSPHRASE       Credentials.Passphrase.
+//
+// The ACCESS_KEY, SECRET, TOKEN and PASSPHRASE can also be read from a file, e.g. a mounted
+// Docker or Kubernetes secret, whose name is in the variable with the suffix _FILE, e.g.
+// STOR_SECRET_FILE. Trailing newlines are removed from the content of the file.
+//
+// The type in the names of the options is in upper case. Option names are converted to lower case.
+// Options in the query of the URL take precedence over options in the environment.
+func ConfFromEnv(prefix string) (*Conf, error) {
+	return confFromEnviron(prefix, os.Environ())
+}
+
+// confFromEnviron reads a Conf from environ, which contains "key=value" strings.
+func confFromEnviron(prefix string, environ []string) (*Conf, error) {
+	if prefix == "" {
+		prefix = DefaultEnvPrefix
+	}
+
+	env := environMap(environ)
+
+	var err error
+	conf := &Conf{
+		Type: Type(env[prefix+"_TYPE"]),
+		Path: env[prefix+"_PATH"],
+	}
+	if rawURL := env[prefix+"_URL"]; rawURL != "" {
+		if conf.Type != TypeUnspecified || conf.Path != "" {
+			return nil, fmt.Errorf("Invalid storage environment: %s_URL can't be combined with "+
+				"%s_TYPE or %s_PATH", prefix, prefix, prefix)
+		}
+
+		conf, err = ParseURL(rawURL)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if conf.Type == TypeUnspecified {
+		return nil, &UnspecifiedTypeError{}
+	}
+	if conf.Options == nil {
+		conf.Options = make(map[string]string)
+	}
+
+	optionPrefix := prefix + "_" + strings.ToUpper(string(conf.Type)) + "_"
+	for key, value := range env {
+		if !strings.HasPrefix(key, optionPrefix) || len(key) == len(optionPrefix) {
+			continue
+		}
+
+		option := strings.ToLower(key[len(optionPrefix):])
+		if _, ok := conf.Options[option]; !ok {
+			conf.Options[option] = value
+		}
+	}
+
+	conf.Credentials, err = credentialsFromEnv(prefix, env)
+	if err != nil {
+		return nil, err
+	}
+
+	return conf, nil
+}
+
+// environMap converts environ, which contains "key=value" strings, to a map.
+func environMap(environ []string) map[string]string {
+	env := make(map[string]string)
+	for _, entry := range environ {
+		if i := strings.IndexByte(entry, '='); i > 0 {
+			env[entry[:i]] = entry[i+1:]
+		}
+	}
+	return env
+}
+
+// NewFromEnv creates a new Storage object based on environment variables. See ConfFromEnv for the
+// variables that are used.
+func NewFromEnv(prefix string) (Storage, error) {
+	conf, err := ConfFromEnv(prefix)
+	if err != nil {
+		return nil, err
+	}
+	return New(conf)
+}
@@ -0,0 +1,73 @@
+package stor
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/suite"
+)
+
+func TestEnvSuite(t *testing.T) {
+	suite.Run(t, new(EnvSuite))
+}
+
+// EnvSuite contains the tests for reading a Conf from the environment.
+type EnvSuite struct {
+	suite.Suite
+}
+
+func (s *EnvSuite) TestTypeAndPath() {
+	conf, err := confFromEnviron("", []string{
+		"STOR_TYPE=B2",
+		"STOR_PATH=bucket/prefix",
+		"STOR_B2_KEYID=id",
+		"STOR_B2_KEY=secret=",
+		"STOR_CONSUL_TOKEN=other",
+		"STOR_B2_=empty",
+		"HOME=/root",
+	})
+	s.Nil(err)
+	s.Equal(&Conf{
+		Type:    "B2",
+		Path:    "bucket/prefix",
+		Options: map[string]string{"keyid": "id", "key": "secret="},
+	}, conf)
+}
+
+func (s *EnvSuite) TestPrefix() {
+	conf, err := confFromEnviron("APP_STORAGE", []string{
+		"STOR_TYPE=B2",
+		"APP_STORAGE_TYPE=LocalDir",
+		"APP_STORAGE_PATH=/var/data",
+		"APP_STORAGE_LOCALDIR_STATCACHETTL=2s",
+	})
+	s.Nil(err)
+	s.Equal(&Conf{
+		Type:    "LocalDir",
+		Path:    "/var/data",
+		Options: map[string]string{"statcachettl": "2s"},
+	}, conf)
+}
+
+func (s *EnvSuite) TestURL() {
+	RegisterScheme("envtest", "EnvTest", nil)
+
+	conf, err := confFromEnviron("", []string{
+		"STOR_URL=envtest://bucket/prefix?region=eu-west-1",
+		"STOR_ENVTEST_REGION=us-east-1",
+		"STOR_ENVTEST_KEY=secret",
+	})
+	s.Nil(err)
+	s.Equal(&Conf{
+		Type:    "EnvTest",
+		Path:    "bucket/prefix",
+		Options: map[string]string{"region": "eu-west-1", "key": "secret"},
+	}, conf)
+
+	_, err = confFromEnviron("", []string{"STOR_URL=envtest://bucket", "STOR_PATH=bucket"})
+	s.NotNil(err)
+}
+
+func (s *EnvSuite) TestUnspecified() {
+	_, err := confFromEnviron("", []string{"STOR_PATH=/var/data"})
+	s.True(IsUnspecifiedTypeError(err))
+}
@@ -0,0 +1,207 @@
+// Package escape implements a stor.Storage wrapper that lets callers use characters in paths that
+// stor.CleanPath doesn't allow, e.g. "report 2024(final).pdf".
+//
+// Every byte of a path component that is not one of stor.ValidBytes is stored as an escape
+// sequence: the escape character '_' followed by the two upper case hex digits of the byte, like
+// percent-encoding in URLs. The escape character itself is escaped as well, as is a dot that
+// follows another dot (stor.CleanPath doesn't allow ".."). For example:
+//
+//	report 2024(final).pdf  ->  report_202024_28final_29.pdf
+//	my_file                 ->  my_5Ffile
+//
+// Paths are still split on '/', must be relative, and may not contain ".." components. List
+// decodes the names again. Names in the underlying Storage that weren't saved through the wrapper
+// are decoded as well, so the underlying Storage should only be written through the wrapper.
+package escape
+
+import (
+	"path"
+	"strings"
+
+	"github.com/pw1/stor"
+)
+
+// Char is the character that starts an escape sequence.
+const Char = '_'
+
+const hexDigits = "0123456789ABCDEF"
+
+// Escape is a stor.Storage wrapper that escapes the characters in paths that the underlying
+// Storage can't store.
+type Escape struct {
+	base stor.Storage
+}
+
+// New creates a new Escape around base.
+func New(base stor.Storage) *Escape {
+	return &Escape{base: base}
+}
+
+// encodePath cleans filePath and encodes it for the underlying Storage. It returns the cleaned
+// path and the encoded path.
+func encodePath(filePath string) (string, string, error) {
+	if strings.HasPrefix(filePath, "/") {
+		return "", "", &stor.InvalidPathError{Path: filePath, Msg: "path must be relative"}
+	}
+	for _, component := range strings.Split(filePath, "/") {
+		if component == ".." {
+			return "", "", &stor.InvalidPathError{Path: filePath, Msg: "path may not contain .."}
+		}
+	}
+
+	cleanPath := path.Clean(filePath)
+	if cleanPath == "." {
+		return "", "", nil
+	}
+
+	components := strings.Split(cleanPath, "/")
+	for i, component := range components {
+		components[i] = encode(component)
+	}
+
+	encoded, err := stor.CleanPath(strings.Join(components, "/"))
+	if err != nil {
+		return "", "", err
+	}
+	return cleanPath, encoded, nil
+}
+
+// encode escapes the bytes of a path component that stor.CleanPath doesn't allow.
+func encode(component string) string {
+	var b strings.Builder
+	b.Grow(len(component))
+
+	for i := 0; i < len(component); i++ {
+		c := component[i]
+		if c == Char || (c == '.' && i > 0 && component[i-1] == '.') ||
+			strings.IndexByte(stor.ValidBytes, c) < 0 {
+			b.WriteByte(Char)
+			b.WriteByte(hexDigits[c>>4])
+			b.WriteByte(hexDigits[c&0xF])
+		} else {
+			b.WriteByte(c)
+		}
+	}
+
+	return b.String()
+}
+
+// decode reverses encode. An escape character that isn't followed by two hex digits is kept as-is.
+func decode(encoded string) string {
+	if strings.IndexByte(encoded, Char) < 0 {
+		return encoded
+	}
+
+	var b strings.Builder
+	b.Grow(len(encoded))
+
+	for i := 0; i < len(encoded); i++ {
+		if encoded[i] == Char && i+2 < len(encoded) && isHex(encoded[i+1]) && isHex(encoded[i+2]) {
+			b.WriteByte(unhex(encoded[i+1])<<4 | unhex(encoded[i+2]))
+			i += 2
+		} else {
+			b.WriteByte(encoded[i])
+		}
+	}
+
+	return b.String()
+}
+
+// isHex checks whether c is an upper case hex digit, as written by encode.
+func isHex(c byte) bool {
+	return strings.IndexByte(hexDigits, c) >= 0
+}
+
+// unhex returns the value of the upper case hex digit c.
+func unhex(c byte) byte {
+	return byte(strings.IndexByte(hexDigits, c))
+}
+
+// decodePaths decodes paths returned by the underlying Storage.
+func decodePaths(encoded []string) []string {
+	decoded := make([]string, 0, len(encoded))
+	for _, p := range encoded {
+		decoded = append(decoded, decode(p))
+	}
+	return decoded
+}
+
+// notExist replaces the encoded path in a PathDoesntExistError by the path of the caller.
+func notExist(err error, cleanPath string) error {
+	if stor.IsPathDoesntExistError(err) {
+		return &stor.PathDoesntExistError{Path: cleanPath}
+	}
+	return err
+}
+
+// Meta returns meta information about a file.
+func (e *Escape) Meta(filePath string) (*stor.Meta, error) {
+	cleanPath, encoded, err := encodePath(filePath)
+	if err != nil {
+		return nil, err
+	}
+
+	meta, err := e.base.Meta(encoded)
+	if err != nil {
+		return nil, notExist(err, cleanPath)
+	}
+	return meta, nil
+}
+
+// List returns the files and subdirectories within the specified directory. The returned paths are
+// decoded.
+func (e *Escape) List(dirPath string) ([]string, []string, error) {
+	_, encoded, err := encodePath(dirPath)
+	if err != nil {
+		return []string{}, []string{}, err
+	}
+
+	files, dirs, err := e.base.List(encoded)
+	if err != nil {
+		return []string{}, []string{}, err
+	}
+	return decodePaths(files), decodePaths(dirs), nil
+}
+
+// Load loads the content of the specified file. If the file is larger than maxSize, the an error is
+// returned.
+func (e *Escape) Load(filePath string, maxSize int64) ([]byte, error) {
+	cleanPath, encoded, err := encodePath(filePath)
+	if err != nil {
+		return []byte{}, err
+	}
+
+	data, err := e.base.Load(encoded, maxSize)
+	if err != nil {
+		return []byte{}, notExist(err, cleanPath)
+	}
+	return data, nil
+}
+
+// Save saves the data to the specified file.
+func (e *Escape) Save(filePath string, data []byte) error {
+	_, encoded, err := encodePath(filePath)
+	if err != nil {
+		return err
+	}
+	return e.base.Save(encoded, data)
+}
+
+// Delete removes a file from storage.
+func (e *Escape) Delete(filePath string) error {
+	cleanPath, encoded, err := encodePath(filePath)
+	if err != nil {
+		return err
+	}
+	return notExist(e.base.Delete(encoded), cleanPath)
+}
+
+// Type returns the Type of the base Storage.
+func (e *Escape) Type() stor.Type {
+	return stor.TypeOf(e.base)
+}
+
+// Close closes the base Storage.
+func (e *Escape) Close() error {
+	return stor.Close(e.base)
+}
@@ -0,0 +1,126 @@
+package escape
+
+import (
+	"io/ioutil"
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/suite"
+
+	"github.com/pw1/stor"
+	"github.com/pw1/stor/localdir"
+	"github.com/pw1/stor/memory"
+	"github.com/pw1/stor/tester"
+)
+
+func TestEscapeStorageTester(t *testing.T) {
+	testSuite := &tester.StorageTester{
+		SetupTestFunc: func(s *tester.StorageTester) {
+			base, _ := memory.New(&stor.Conf{})
+			s.Storage = New(base)
+		},
+	}
+
+	suite.Run(t, testSuite)
+}
+
+func TestEscapeLocalDirStorageTester(t *testing.T) {
+	var dir string
+	testSuite := &tester.StorageTester{
+		SetupTestFunc: func(s *tester.StorageTester) {
+			var err error
+			dir, err = ioutil.TempDir("", "stor-escape")
+			s.Require().Nil(err)
+			base, err := localdir.New(&stor.Conf{Path: dir})
+			s.Require().Nil(err)
+			s.Storage = New(base)
+		},
+		TearDownTestFunc: func(s *tester.StorageTester) {
+			os.RemoveAll(dir)
+		},
+	}
+
+	suite.Run(t, testSuite)
+}
+
+func TestEscapeSuite(t *testing.T) {
+	suite.Run(t, new(EscapeSuite))
+}
+
+// EscapeSuite contains tests that are specific for the Escape wrapper.
+type EscapeSuite struct {
+	suite.Suite
+	base *memory.Memory
+	e    *Escape
+}
+
+func (s *EscapeSuite) SetupTest() {
+	s.base, _ = memory.New(&stor.Conf{})
+	s.e = New(s.base)
+}
+
+func (s *EscapeSuite) TestEncode() {
+	table := [][]string{
+		{"file1.txt", "file1.txt"},
+		{"report 2024(final).pdf", "report_202024_28final_29.pdf"},
+		{"my_file", "my_5Ffile"},
+		{"a..b", "a._2Eb"},
+		{"...", "._2E_2E"},
+		{"dir\\file", "dir_5Cfile"},
+		{"café", "caf_C3_A9"},
+	}
+
+	for _, row := range table {
+		s.Equal(row[1], encode(row[0]), row[0])
+		s.Equal(row[0], decode(row[1]), row[1])
+	}
+
+	// Escape characters without two upper case hex digits are kept
+	for _, encoded := range []string{"_", "a_", "a_4", "a_4g", "a_4f"} {
+		s.Equal(encoded, decode(encoded))
+	}
+}
+
+func (s *EscapeSuite) TestRoundTrip() {
+	filePath := "my docs/report 2024(final).pdf"
+	s.Require().Nil(s.e.Save(filePath, []byte("test123")))
+
+	data, err := s.e.Load(filePath, 100)
+	s.Nil(err)
+	s.Equal("test123", string(data))
+
+	meta, err := s.e.Meta(filePath)
+	s.Nil(err)
+	s.Equal(int64(7), meta.Size)
+
+	files, dirs, err := s.e.List("")
+	s.Nil(err)
+	s.Equal([]string{}, files)
+	s.Equal([]string{"my docs"}, dirs)
+
+	files, _, err = s.e.List("my docs/")
+	s.Nil(err)
+	s.Equal([]string{filePath}, files)
+
+	// The underlying Storage only contains valid paths
+	files, _, err = s.base.List("my_20docs")
+	s.Nil(err)
+	s.Equal([]string{"my_20docs/report_202024_28final_29.pdf"}, files)
+
+	s.Nil(s.e.Delete(filePath))
+	_, err = s.e.Load(filePath, 100)
+	s.Equal(&stor.PathDoesntExistError{Path: filePath}, err)
+	s.Equal(&stor.PathDoesntExistError{Path: filePath}, s.e.Delete(filePath))
+}
+
+func (s *EscapeSuite) TestInvalidPath() {
+	for _, filePath := range []string{"/file1", "../file1", "dir1/../../file1", "dir1/.."} {
+		s.True(stor.IsInvalidPathError(s.e.Save(filePath, []byte("test123"))), filePath)
+		_, err := s.e.Load(filePath, 100)
+		s.True(stor.IsInvalidPathError(err), filePath)
+	}
+
+	// Components that are only valid because of the escaping
+	s.Nil(s.e.Save("dir*1/file?1", []byte("test123")))
+	s.Nil(s.e.Save("a..b", []byte("test123")))
+}
@@ -0,0 +1,207 @@
+// Package failover implements a stor.Storage wrapper that fails over to the next of an ordered list
+// of backends when a backend is unavailable.
+//
+// Reads are served by the first healthy backend. A backend that fails with an unavailable error is
+// marked unhealthy, and the operation is retried on the next backend. Unhealthy backends are
+// skipped until a health probe (see Probe and ProbeEvery) finds them healthy again, so the
+// Failover automatically fails back to the preferred backends.
+//
+// Writes go to the first backend only, unless Options.FailoverWrites is set. Note that the backends
+// are not synchronized: files that are written to a secondary backend during a failover are not
+// visible on the primary backend after the fail-back, unless the backends replicate themselves.
+package failover
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/pw1/stor"
+)
+
+// Options configures a Failover.
+type Options struct {
+	// FailoverWrites enables failing over Saves and Deletes to the next backend as well.
+	FailoverWrites bool
+}
+
+// Failover is a stor.Storage wrapper that fails over between backends.
+type Failover struct {
+	// IsUnavailable decides whether an error of a backend means that it is unavailable. By default,
+	// all retryable errors (see stor.IsRetryable) are considered unavailable errors.
+	IsUnavailable func(err error) bool
+
+	backends []stor.Storage
+	options  Options
+	mutex    sync.Mutex
+	healthy  []bool
+}
+
+// New creates a new Failover for backends, ordered from the most to the least preferred backend.
+func New(backends []stor.Storage, options Options) (*Failover, error) {
+	if len(backends) == 0 {
+		return nil, fmt.Errorf("Invalid backends: at least one backend is required")
+	}
+
+	healthy := make([]bool, len(backends))
+	for i := range healthy {
+		healthy[i] = true
+	}
+
+	f := &Failover{
+		IsUnavailable: stor.IsRetryable,
+		backends:      backends,
+		options:       options,
+		healthy:       healthy,
+	}
+	return f, nil
+}
+
+// Healthy returns the health of each backend.
+func (f *Failover) Healthy() []bool {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+
+	healthy := make([]bool, len(f.healthy))
+	copy(healthy, f.healthy)
+	return healthy
+}
+
+// Probe checks the health of the unhealthy backends by listing their root directory.
+func (f *Failover) Probe() {
+	for i, backend := range f.backends {
+		if f.Healthy()[i] {
+			continue
+		}
+
+		_, _, err := backend.List("")
+		if err == nil || !f.IsUnavailable(err) {
+			f.setHealthy(i, true)
+		}
+	}
+}
+
+// ProbeEvery probes the unhealthy backends periodically in the background. Call the returned
+// function to stop.
+func (f *Failover) ProbeEvery(interval time.Duration) (stop func()) {
+	done := make(chan struct{})
+	ticker := time.NewTicker(interval)
+
+	go func() {
+		defer ticker.Stop()
+		for {
+			select {
+			case <-done:
+				return
+			case <-ticker.C:
+				f.Probe()
+			}
+		}
+	}()
+
+	var once sync.Once
+	return func() { once.Do(func() { close(done) }) }
+}
+
+func (f *Failover) setHealthy(i int, healthy bool) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+
+	f.healthy[i] = healthy
+}
+
+// do performs an operation on the first healthy backend, and fails over to the next healthy
+// backend as long as the operation fails with an unavailable error. If all backends are
+// unhealthy, then they are all tried anyway. If failover is false, then only the first backend is
+// tried.
+func (f *Failover) do(failover bool, operation func(stor.Storage) error) error {
+	healthy := f.Healthy()
+	candidates := []int{}
+	for i := range f.backends {
+		if healthy[i] {
+			candidates = append(candidates, i)
+		}
+	}
+	if len(candidates) == 0 {
+		for i := range f.backends {
+			candidates = append(candidates, i)
+		}
+	}
+	if !failover {
+		candidates = []int{0}
+	}
+
+	var err error
+	for _, i := range candidates {
+		err = operation(f.backends[i])
+		if err == nil || !f.IsUnavailable(err) {
+			if err == nil && !healthy[i] {
+				f.setHealthy(i, true)
+			}
+			return err
+		}
+		f.setHealthy(i, false)
+	}
+	return err
+}
+
+// Meta returns meta information about a file.
+func (f *Failover) Meta(filePath string) (*stor.Meta, error) {
+	var meta *stor.Meta
+	err := f.do(true, func(backend stor.Storage) (err error) {
+		meta, err = backend.Meta(filePath)
+		return err
+	})
+	return meta, err
+}
+
+// List returns the files and subdirectories within the specified directory.
+func (f *Failover) List(dirPath string) ([]string, []string, error) {
+	var files, dirs []string
+	err := f.do(true, func(backend stor.Storage) (err error) {
+		files, dirs, err = backend.List(dirPath)
+		return err
+	})
+	return files, dirs, err
+}
+
+// Load loads the content of the specified file.
+func (f *Failover) Load(filePath string, maxSize int64) ([]byte, error) {
+	var data []byte
+	err := f.do(true, func(backend stor.Storage) (err error) {
+		data, err = backend.Load(filePath, maxSize)
+		return err
+	})
+	return data, err
+}
+
+// Save saves the data to the specified file.
+func (f *Failover) Save(filePath string, data []byte) error {
+	return f.do(f.options.FailoverWrites, func(backend stor.Storage) error {
+		return backend.Save(filePath, data)
+	})
+}
+
+// Delete removes a file from storage.
+func (f *Failover) Delete(filePath string) error {
+	return f.do(f.options.FailoverWrites, func(backend stor.Storage) error {
+		return backend.Delete(filePath)
+	})
+}
+
+// Type returns the Type of the primary (first) backend.
+func (f *Failover) Type() stor.Type {
+	return stor.TypeOf(f.backends[0])
+}
+
+// Close closes all backends. It returns the first error, after it has tried to close all of them.
+// The probing of ProbeEvery must be stopped separately.
+func (f *Failover) Close() error {
+	var err error
+	for _, backend := range f.backends {
+		if closeErr := stor.Close(backend); err == nil {
+			err = closeErr
+		}
+	}
+	return err
+}
@@ -0,0 +1,208 @@
+package failover
+
+import (
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/suite"
+
+	"github.com/pw1/stor"
+	"github.com/pw1/stor/memory"
+	"github.com/pw1/stor/tester"
+)
+
+// flakyStorage is a stor.Storage that fails with a temporary error while it is down.
+type flakyStorage struct {
+	stor.Storage
+	mutex  sync.Mutex
+	down   bool
+	calls  int
+	closed bool
+}
+
+func (f *flakyStorage) Close() error {
+	f.closed = true
+	return f.err()
+}
+
+func newFlakyStorage() *flakyStorage {
+	base, _ := memory.New(&stor.Conf{})
+	return &flakyStorage{Storage: base}
+}
+
+func (f *flakyStorage) setDown(down bool) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	f.down = down
+}
+
+func (f *flakyStorage) err() error {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	f.calls++
+	if f.down {
+		return &stor.TemporaryError{Err: errors.New("service unavailable")}
+	}
+	return nil
+}
+
+func (f *flakyStorage) List(dirPath string) ([]string, []string, error) {
+	if err := f.err(); err != nil {
+		return []string{}, []string{}, err
+	}
+	return f.Storage.List(dirPath)
+}
+
+func (f *flakyStorage) Load(filePath string, maxSize int64) ([]byte, error) {
+	if err := f.err(); err != nil {
+		return []byte{}, err
+	}
+	return f.Storage.Load(filePath, maxSize)
+}
+
+func (f *flakyStorage) Save(filePath string, data []byte) error {
+	if err := f.err(); err != nil {
+		return err
+	}
+	return f.Storage.Save(filePath, data)
+}
+
+// TestFailoverStorageTester calls the generic storage tests with a single healthy backend.
+func TestFailoverStorageTester(t *testing.T) {
+	testSuite := &tester.StorageTester{
+		SetupTestFunc: func(s *tester.StorageTester) {
+			st, err := New([]stor.Storage{newFlakyStorage(), newFlakyStorage()}, Options{})
+			s.Require().Nil(err)
+			s.Storage = st
+		},
+	}
+
+	suite.Run(t, testSuite)
+}
+
+func TestFailoverSuite(t *testing.T) {
+	suite.Run(t, new(FailoverSuite))
+}
+
+// FailoverSuite contains tests that are specific for the Failover wrapper.
+type FailoverSuite struct {
+	suite.Suite
+	primary   *flakyStorage
+	secondary *flakyStorage
+}
+
+func (s *FailoverSuite) SetupTest() {
+	s.primary = newFlakyStorage()
+	s.secondary = newFlakyStorage()
+	s.Require().Nil(s.primary.Save("file1", []byte("primary")))
+	s.Require().Nil(s.secondary.Save("file1", []byte("secondary")))
+}
+
+func (s *FailoverSuite) TestNewNoBackends() {
+	_, err := New(nil, Options{})
+	s.NotNil(err)
+}
+
+func (s *FailoverSuite) TestFailoverAndBack() {
+	f, err := New([]stor.Storage{s.primary, s.secondary}, Options{})
+	s.Require().Nil(err)
+
+	data, err := f.Load("file1", 100)
+	s.Nil(err)
+	s.Equal("primary", string(data))
+
+	s.primary.setDown(true)
+	data, err = f.Load("file1", 100)
+	s.Nil(err)
+	s.Equal("secondary", string(data))
+	s.Equal([]bool{false, true}, f.Healthy())
+
+	// The unhealthy primary is skipped until it is probed
+	calls := s.primary.calls
+	_, err = f.Load("file1", 100)
+	s.Nil(err)
+	s.Equal(calls, s.primary.calls)
+
+	f.Probe()
+	s.Equal([]bool{false, true}, f.Healthy())
+
+	s.primary.setDown(false)
+	f.Probe()
+	s.Equal([]bool{true, true}, f.Healthy())
+
+	data, err = f.Load("file1", 100)
+	s.Nil(err)
+	s.Equal("primary", string(data))
+}
+
+func (s *FailoverSuite) TestNotUnavailable() {
+	f, err := New([]stor.Storage{s.primary, s.secondary}, Options{})
+	s.Require().Nil(err)
+
+	s.Require().Nil(s.secondary.Save("file2", []byte("secondary")))
+	_, err = f.Load("file2", 100)
+	s.True(stor.IsPathDoesntExistError(err), "a missing file is not a reason to fail over")
+}
+
+func (s *FailoverSuite) TestAllDown() {
+	f, err := New([]stor.Storage{s.primary, s.secondary}, Options{})
+	s.Require().Nil(err)
+
+	s.primary.setDown(true)
+	s.secondary.setDown(true)
+	_, err = f.Load("file1", 100)
+	s.True(stor.IsTemporaryError(err))
+	s.Equal([]bool{false, false}, f.Healthy())
+
+	// All backends are tried if none is healthy, and a success marks the backend healthy
+	s.secondary.setDown(false)
+	data, err := f.Load("file1", 100)
+	s.Nil(err)
+	s.Equal("secondary", string(data))
+	s.Equal([]bool{false, true}, f.Healthy())
+}
+
+func (s *FailoverSuite) TestWrites() {
+	f, err := New([]stor.Storage{s.primary, s.secondary}, Options{})
+	s.Require().Nil(err)
+
+	s.primary.setDown(true)
+	s.True(stor.IsTemporaryError(f.Save("file2", []byte("test"))))
+
+	f, err = New([]stor.Storage{s.primary, s.secondary}, Options{FailoverWrites: true})
+	s.Require().Nil(err)
+	s.Nil(f.Save("file2", []byte("test")))
+
+	data, err := s.secondary.Load("file2", 100)
+	s.Nil(err)
+	s.Equal("test", string(data))
+}
+
+func (s *FailoverSuite) TestProbeEvery() {
+	f, err := New([]stor.Storage{s.primary, s.secondary}, Options{})
+	s.Require().Nil(err)
+
+	s.primary.setDown(true)
+	f.Load("file1", 100)
+	s.primary.setDown(false)
+
+	stop := f.ProbeEvery(time.Millisecond)
+	defer stop()
+
+	for i := 0; i < 1000 && !f.Healthy()[0]; i++ {
+		time.Sleep(time.Millisecond)
+	}
+	s.True(f.Healthy()[0])
+}
+
+func (s *FailoverSuite) TestClose() {
+	f, err := New([]stor.Storage{s.primary, s.secondary}, Options{})
+	s.Require().Nil(err)
+
+	s.primary.setDown(true)
+	s.True(stor.IsTemporaryError(f.Close()))
+	s.True(s.primary.closed)
+	s.True(s.secondary.closed, "the other backends are closed despite the error")
+}
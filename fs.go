@@ -0,0 +1,291 @@
+package stor
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"io/fs"
+	"math"
+	"path"
+	"sort"
+	"time"
+)
+
+// FromFS returns a read-only Storage that serves the files of fsys (e.g. an embed.FS, or
+// os.DirFS). Save and Delete always return a ReadOnlyError. Entries of fsys with names that are not
+// valid Storage paths (see CleanPath) are listed, but can't be loaded.
+func FromFS(fsys fs.FS) Storage {
+	return &fsStorage{fsys: fsys}
+}
+
+// fsStorage is the Storage returned by FromFS.
+type fsStorage struct {
+	fsys fs.FS
+}
+
+// fsPath converts a cleaned Storage path into an fs.FS path.
+func fsPath(cleanPath string) string {
+	if cleanPath == "" {
+		return "."
+	}
+	return cleanPath
+}
+
+func (f *fsStorage) Meta(filePath string) (*Meta, error) {
+	cleanPath, err := CleanPath(filePath)
+	if err != nil {
+		return nil, err
+	}
+
+	info, err := fs.Stat(f.fsys, fsPath(cleanPath))
+	if errors.Is(err, fs.ErrNotExist) || (err == nil && info.IsDir()) {
+		return nil, &PathDoesntExistError{Path: cleanPath}
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return &Meta{Size: info.Size(), ModTime: info.ModTime()}, nil
+}
+
+func (f *fsStorage) List(dirPath string) ([]string, []string, error) {
+	cleanPath, err := CleanPath(dirPath)
+	if err != nil {
+		return []string{}, []string{}, err
+	}
+
+	entries, err := fs.ReadDir(f.fsys, fsPath(cleanPath))
+	if errors.Is(err, fs.ErrNotExist) {
+		return []string{}, []string{}, nil
+	}
+	if err != nil {
+		return []string{}, []string{}, err
+	}
+
+	files := []string{}
+	dirs := []string{}
+	for _, entry := range entries {
+		entryPath := path.Join(cleanPath, entry.Name())
+		if entry.IsDir() {
+			dirs = append(dirs, entryPath)
+		} else {
+			files = append(files, entryPath)
+		}
+	}
+
+	return files, dirs, nil
+}
+
+func (f *fsStorage) Load(filePath string, maxSize int64) ([]byte, error) {
+	cleanPath, err := CleanPath(filePath)
+	if err != nil {
+		return []byte{}, err
+	}
+
+	file, err := f.fsys.Open(fsPath(cleanPath))
+	if errors.Is(err, fs.ErrNotExist) {
+		return []byte{}, &PathDoesntExistError{Path: cleanPath}
+	}
+	if err != nil {
+		return []byte{}, err
+	}
+	defer file.Close()
+
+	info, err := file.Stat()
+	if err != nil {
+		return []byte{}, err
+	}
+	if info.IsDir() {
+		return []byte{}, &PathDoesntExistError{Path: cleanPath}
+	}
+	if info.Size() > maxSize {
+		return []byte{}, &TooLargeError{What: cleanPath}
+	}
+
+	return ReadAllMax(file, maxSize, cleanPath)
+}
+
+func (f *fsStorage) Save(filePath string, data []byte) error {
+	return &ReadOnlyError{Path: filePath}
+}
+
+func (f *fsStorage) Delete(filePath string) error {
+	return &ReadOnlyError{Path: filePath}
+}
+
+// AsFS returns an fs.FS that exposes the files in s, so s can be used with the standard library
+// (e.g. http.FS, template.ParseFS). Directories exist implicitly if they contain files. Opened
+// files are loaded completely into memory.
+func AsFS(s Reader) fs.FS {
+	return &storageFS{s: s}
+}
+
+// storageFS is the fs.FS returned by AsFS.
+type storageFS struct {
+	s Reader
+}
+
+// cleanFSPath converts an fs.FS path into a cleaned Storage path.
+func cleanFSPath(op, name string) (string, error) {
+	if !fs.ValidPath(name) {
+		return "", &fs.PathError{Op: op, Path: name, Err: fs.ErrInvalid}
+	}
+
+	cleanPath, err := CleanPath(name)
+	if err != nil {
+		return "", &fs.PathError{Op: op, Path: name, Err: fs.ErrNotExist}
+	}
+	return cleanPath, nil
+}
+
+// Open opens the named file or directory.
+func (f *storageFS) Open(name string) (fs.File, error) {
+	cleanPath, err := cleanFSPath("open", name)
+	if err != nil {
+		return nil, err
+	}
+
+	if cleanPath != "" {
+		data, err := f.s.Load(cleanPath, math.MaxInt64)
+		if err == nil {
+			info := &fileInfo{name: path.Base(cleanPath), size: int64(len(data))}
+			return &memFile{Reader: bytes.NewReader(data), info: info}, nil
+		}
+		if !IsPathDoesntExistError(err) {
+			return nil, &fs.PathError{Op: "open", Path: name, Err: err}
+		}
+	}
+
+	entries, err := f.readDir(cleanPath)
+	if err != nil {
+		return nil, &fs.PathError{Op: "open", Path: name, Err: err}
+	}
+	if len(entries) == 0 && cleanPath != "" {
+		return nil, &fs.PathError{Op: "open", Path: name, Err: fs.ErrNotExist}
+	}
+
+	info := &fileInfo{name: path.Base(fsPath(cleanPath)), dir: true}
+	return &dirFile{info: info, entries: entries}, nil
+}
+
+// ReadFile reads the named file.
+func (f *storageFS) ReadFile(name string) ([]byte, error) {
+	cleanPath, err := cleanFSPath("readfile", name)
+	if err != nil {
+		return nil, err
+	}
+
+	data, err := f.s.Load(cleanPath, math.MaxInt64)
+	if IsPathDoesntExistError(err) {
+		return nil, &fs.PathError{Op: "readfile", Path: name, Err: fs.ErrNotExist}
+	}
+	if err != nil {
+		return nil, &fs.PathError{Op: "readfile", Path: name, Err: err}
+	}
+	return data, nil
+}
+
+// ReadDir reads the named directory, and returns its entries sorted by name.
+func (f *storageFS) ReadDir(name string) ([]fs.DirEntry, error) {
+	cleanPath, err := cleanFSPath("readdir", name)
+	if err != nil {
+		return nil, err
+	}
+
+	entries, err := f.readDir(cleanPath)
+	if err != nil {
+		return nil, &fs.PathError{Op: "readdir", Path: name, Err: err}
+	}
+	if len(entries) == 0 && cleanPath != "" {
+		return nil, &fs.PathError{Op: "readdir", Path: name, Err: fs.ErrNotExist}
+	}
+	return entries, nil
+}
+
+// readDir lists a directory, and returns its entries sorted by name.
+func (f *storageFS) readDir(cleanPath string) ([]fs.DirEntry, error) {
+	files, dirs, err := f.s.List(cleanPath)
+	if err != nil {
+		return nil, err
+	}
+
+	entries := make([]fs.DirEntry, 0, len(files)+len(dirs))
+	for _, filePath := range files {
+		meta, err := f.s.Meta(filePath)
+		if err != nil {
+			return nil, err
+		}
+		entries = append(entries, fs.FileInfoToDirEntry(
+			&fileInfo{name: path.Base(filePath), size: meta.Size}))
+	}
+	for _, dirPath := range dirs {
+		entries = append(entries, fs.FileInfoToDirEntry(
+			&fileInfo{name: path.Base(dirPath), dir: true}))
+	}
+
+	sort.Slice(entries, func(i, j int) bool {
+		return entries[i].Name() < entries[j].Name()
+	})
+	return entries, nil
+}
+
+// fileInfo implements fs.FileInfo for files and directories of AsFS.
+type fileInfo struct {
+	name string
+	size int64
+	dir  bool
+}
+
+func (i *fileInfo) Name() string       { return i.name }
+func (i *fileInfo) Size() int64        { return i.size }
+func (i *fileInfo) ModTime() time.Time { return time.Time{} }
+func (i *fileInfo) IsDir() bool        { return i.dir }
+func (i *fileInfo) Sys() interface{}   { return nil }
+
+func (i *fileInfo) Mode() fs.FileMode {
+	if i.dir {
+		return fs.ModeDir | 0555
+	}
+	return 0444
+}
+
+// memFile is an opened file of AsFS. It is seekable, as required by e.g. http.FileServer.
+type memFile struct {
+	*bytes.Reader
+	info *fileInfo
+}
+
+func (f *memFile) Stat() (fs.FileInfo, error) { return f.info, nil }
+func (f *memFile) Close() error               { return nil }
+
+// dirFile is an opened directory of AsFS.
+type dirFile struct {
+	info    *fileInfo
+	entries []fs.DirEntry
+	offset  int
+}
+
+func (d *dirFile) Stat() (fs.FileInfo, error) { return d.info, nil }
+func (d *dirFile) Close() error               { return nil }
+
+func (d *dirFile) Read([]byte) (int, error) {
+	return 0, &fs.PathError{Op: "read", Path: d.info.name, Err: errors.New("is a directory")}
+}
+
+// ReadDir reads the entries of the directory, see fs.ReadDirFile.
+func (d *dirFile) ReadDir(n int) ([]fs.DirEntry, error) {
+	remaining := d.entries[d.offset:]
+	if n <= 0 {
+		d.offset = len(d.entries)
+		return remaining, nil
+	}
+
+	if len(remaining) == 0 {
+		return nil, io.EOF
+	}
+	if n > len(remaining) {
+		n = len(remaining)
+	}
+	d.offset += n
+	return remaining[:n], nil
+}
@@ -0,0 +1,146 @@
+package stor_test
+
+import (
+	"errors"
+	"io/fs"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"testing/fstest"
+
+	"github.com/stretchr/testify/suite"
+
+	"github.com/pw1/stor"
+	"github.com/pw1/stor/memory"
+	"github.com/pw1/stor/tester"
+)
+
+// TestFromFSStorageTester calls the generic storage tests for a Storage on top of an fs.FS
+func TestFromFSStorageTester(t *testing.T) {
+	testSuite := &tester.StorageTester{
+		SetupTestFunc: func(s *tester.StorageTester) {
+			fsys := fstest.MapFS{}
+			for filePath, content := range tester.StandardFiles {
+				fsys[filePath] = &fstest.MapFile{Data: []byte(content)}
+			}
+			s.Storage = stor.FromFS(fsys)
+		},
+		ReadOnly: true,
+	}
+
+	suite.Run(t, testSuite)
+}
+
+func TestFSSuite(t *testing.T) {
+	suite.Run(t, new(FSSuite))
+}
+
+// FSSuite contains the tests for the io/fs adapters.
+type FSSuite struct {
+	suite.Suite
+	mapFS fstest.MapFS
+	mem   *memory.Memory
+}
+
+func (s *FSSuite) SetupTest() {
+	s.mapFS = fstest.MapFS{
+		"file1":           {Data: []byte("test123")},
+		"dir1/file2":      {Data: []byte("test456")},
+		"dir1/dir4/file5": {Data: []byte("test788909")},
+	}
+
+	s.mem, _ = memory.New(&stor.Conf{})
+	for name, file := range s.mapFS {
+		s.Require().Nil(s.mem.Save(name, file.Data))
+	}
+}
+
+func (s *FSSuite) TestFromFSList() {
+	storage := stor.FromFS(s.mapFS)
+
+	files, dirs, err := storage.List("")
+	s.Nil(err)
+	s.ElementsMatch([]string{"file1"}, files)
+	s.ElementsMatch([]string{"dir1"}, dirs)
+
+	files, dirs, err = storage.List("dir1")
+	s.Nil(err)
+	s.ElementsMatch([]string{"dir1/file2"}, files)
+	s.ElementsMatch([]string{"dir1/dir4"}, dirs)
+
+	files, dirs, err = storage.List("nonexistent")
+	s.Nil(err)
+	s.Empty(files)
+	s.Empty(dirs)
+}
+
+func (s *FSSuite) TestFromFSLoad() {
+	storage := stor.FromFS(s.mapFS)
+
+	data, err := storage.Load("dir1/dir4/file5", 10)
+	s.Nil(err)
+	s.Equal("test788909", string(data))
+
+	_, err = storage.Load("dir1/dir4/file5", 9)
+	s.True(stor.IsTooLargeError(err))
+
+	_, err = storage.Load("dir1", 100)
+	s.True(stor.IsPathDoesntExistError(err))
+
+	_, err = storage.Load("nonexistent", 100)
+	s.True(stor.IsPathDoesntExistError(err))
+
+	_, err = storage.Load("../file1", 100)
+	s.True(stor.IsInvalidPathError(err))
+}
+
+func (s *FSSuite) TestFromFSMeta() {
+	storage := stor.FromFS(s.mapFS)
+
+	meta, err := storage.Meta("dir1/file2")
+	s.Nil(err)
+	s.Equal(int64(7), meta.Size)
+
+	_, err = storage.Meta("dir1")
+	s.True(stor.IsPathDoesntExistError(err))
+}
+
+func (s *FSSuite) TestFromFSReadOnly() {
+	storage := stor.FromFS(s.mapFS)
+
+	s.True(stor.IsReadOnlyError(storage.Save("file1", []byte("new"))))
+	s.True(stor.IsReadOnlyError(storage.Delete("file1")))
+}
+
+func (s *FSSuite) TestAsFS() {
+	fsys := stor.AsFS(s.mem)
+	s.Nil(fstest.TestFS(fsys, "file1", "dir1/file2", "dir1/dir4/file5"))
+}
+
+func (s *FSSuite) TestAsFSNotExist() {
+	fsys := stor.AsFS(s.mem)
+
+	_, err := fsys.Open("nonexistent")
+	s.True(errors.Is(err, fs.ErrNotExist))
+
+	_, err = fs.ReadFile(fsys, "dir1/nonexistent")
+	s.True(errors.Is(err, fs.ErrNotExist))
+
+	_, err = fsys.Open("/file1")
+	s.True(errors.Is(err, fs.ErrInvalid))
+}
+
+func (s *FSSuite) TestAsFSFileServer() {
+	server := httptest.NewServer(http.FileServer(http.FS(stor.AsFS(s.mem))))
+	defer server.Close()
+
+	resp, err := http.Get(server.URL + "/dir1/file2")
+	s.Require().Nil(err)
+	defer resp.Body.Close()
+
+	body, err := ioutil.ReadAll(resp.Body)
+	s.Nil(err)
+	s.Equal(http.StatusOK, resp.StatusCode)
+	s.Equal("test456", string(body))
+}
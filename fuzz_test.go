@@ -0,0 +1,60 @@
+//go:build go1.18
+// +build go1.18
+
+package stor
+
+import (
+	"path"
+	"strings"
+	"testing"
+)
+
+// FuzzCleanPath verifies that CleanPath either returns an InvalidPathError, or a clean, relative
+// path with only valid bytes, that can't escape the root.
+func FuzzCleanPath(f *testing.F) {
+	for _, seed := range []string{"", ".", "file1", "dir1/file2", "dir1//file3/", "./dir1/./file4",
+		"../file1", "dir1/../file1", "/absolute", "c:\\dir1", "file*1", "dir1/filé1", "a\x00b",
+		strings.Repeat("a", 300)} {
+		f.Add(seed)
+	}
+
+	f.Fuzz(func(t *testing.T, filePath string) {
+		cleanPath, err := CleanPath(filePath)
+		if err != nil {
+			if !IsInvalidPathError(err) {
+				t.Fatalf("CleanPath(%q) returned %v, expected an InvalidPathError", filePath, err)
+			}
+			if cleanPath != "" {
+				t.Fatalf("CleanPath(%q) returned %q with an error", filePath, cleanPath)
+			}
+			return
+		}
+
+		if expected := path.Clean(filePath); cleanPath != expected && expected != "." {
+			t.Fatalf("CleanPath(%q) returned %q, expected %q", filePath, cleanPath, expected)
+		}
+		if cleanPath == "" {
+			return
+		}
+		if strings.HasPrefix(cleanPath, "/") || path.Clean(cleanPath) != cleanPath {
+			t.Fatalf("CleanPath(%q) returned %q, which is not clean", filePath, cleanPath)
+		}
+		for _, component := range strings.Split(cleanPath, "/") {
+			if component == "." || component == ".." {
+				t.Fatalf("CleanPath(%q) returned %q, which contains %q", filePath, cleanPath,
+					component)
+			}
+		}
+		for i := 0; i < len(cleanPath); i++ {
+			if cleanPath[i] != Delimiter && strings.IndexByte(ValidBytes, cleanPath[i]) < 0 {
+				t.Fatalf("CleanPath(%q) returned %q, which contains byte %q", filePath, cleanPath,
+					cleanPath[i])
+			}
+		}
+
+		again, err := CleanPath(cleanPath)
+		if err != nil || again != cleanPath {
+			t.Fatalf("CleanPath(%q) returned %q, %v, expected it unchanged", cleanPath, again, err)
+		}
+	})
+}
@@ -0,0 +1,82 @@
+package stor
+
+import "time"
+
+// DefaultGCMinAge is the default minimum age of the artifacts that CollectGarbage removes.
+const DefaultGCMinAge = time.Hour
+
+// GarbageCollector can find and remove the artifacts that interrupted operations leave behind,
+// e.g. the temporary file of a Save that crashed before it renamed the file into place.
+type GarbageCollector interface {
+	// CollectGarbage finds the artifacts within a directory and its subdirectories that are older
+	// than the MinAge of the options, and removes them unless DryRun is set. Files that List
+	// returns are never removed. Artifacts that disappear while they are collected are skipped.
+	// The path argument is a slash-separated path.
+	CollectGarbage(path string, options GCOptions) (*GCReport, error)
+}
+
+// GCOptions configures CollectGarbage.
+type GCOptions struct {
+	// MinAge is the minimum age of an artifact that is removed. Younger artifacts may still belong
+	// to an operation that is in progress. The default (0) is DefaultGCMinAge.
+	MinAge time.Duration
+
+	// DryRun only reports the artifacts, without removing them.
+	DryRun bool
+}
+
+// Artifact is a leftover of an interrupted operation that CollectGarbage found.
+type Artifact struct {
+	// Path is the slash-separated path of the artifact within the storage.
+	Path string
+
+	// Size is the size of the artifact.
+	Size int64
+
+	// ModTime is the time at which the artifact was last modified.
+	ModTime time.Time
+
+	// Removed is true if the artifact was removed. It is false for a dry run.
+	Removed bool
+}
+
+// GCReport is the result of CollectGarbage.
+type GCReport struct {
+	// Artifacts are the artifacts that were found.
+	Artifacts []Artifact
+}
+
+// Bytes returns the total size of the artifacts that were removed.
+func (r *GCReport) Bytes() int64 {
+	var bytes int64
+	for _, artifact := range r.Artifacts {
+		if artifact.Removed {
+			bytes += artifact.Size
+		}
+	}
+	return bytes
+}
+
+// Cutoff returns the time before which artifacts must have been modified to be removed, given the
+// current time. It is meant for implementations of GarbageCollector.
+func (o GCOptions) Cutoff(now time.Time) time.Time {
+	if o.MinAge <= 0 {
+		return now.Add(-DefaultGCMinAge)
+	}
+	return now.Add(-o.MinAge)
+}
+
+// CollectGarbage finds the artifacts of interrupted operations within a directory of s and its
+// subdirectories, and removes the ones that are older than the MinAge of the options unless DryRun
+// is set. If s doesn't implement GarbageCollector, then it leaves no artifacts behind, and the
+// report is empty.
+func CollectGarbage(s Reader, dir string, options GCOptions) (*GCReport, error) {
+	if collector, ok := s.(GarbageCollector); ok {
+		return collector.CollectGarbage(dir, options)
+	}
+
+	if _, err := CleanPath(dir); err != nil {
+		return nil, err
+	}
+	return &GCReport{Artifacts: []Artifact{}}, nil
+}
@@ -0,0 +1,39 @@
+package stor_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/suite"
+
+	"github.com/pw1/stor"
+	"github.com/pw1/stor/memory"
+)
+
+func TestGCSuite(t *testing.T) {
+	suite.Run(t, new(GCSuite))
+}
+
+// GCSuite contains the tests for CollectGarbage.
+type GCSuite struct {
+	suite.Suite
+}
+
+func (s *GCSuite) TestFallback() {
+	mem, _ := memory.New(&stor.Conf{})
+	s.Require().Nil(mem.Save("dir1/file1", []byte("test123")))
+
+	report, err := stor.CollectGarbage(mem, "", stor.GCOptions{MinAge: time.Nanosecond})
+	s.Nil(err)
+	s.Equal([]stor.Artifact{}, report.Artifacts)
+	s.Equal(int64(0), report.Bytes())
+
+	_, err = stor.CollectGarbage(mem, "../dir1", stor.GCOptions{})
+	s.True(stor.IsInvalidPathError(err))
+}
+
+func (s *GCSuite) TestCutoff() {
+	now := time.Now()
+	s.Equal(now.Add(-stor.DefaultGCMinAge), stor.GCOptions{}.Cutoff(now))
+	s.Equal(now.Add(-time.Minute), stor.GCOptions{MinAge: time.Minute}.Cutoff(now))
+}
@@ -0,0 +1,14 @@
+package stor
+
+// Generationer can cheaply tell whether the content of a directory has changed, so a cached listing
+// can be validated without listing the directory again.
+type Generationer interface {
+	// Generation returns the generation of a directory. The generation changes whenever the result
+	// of List for the directory changes. It may also change when the listing doesn't change, so a
+	// changed generation only indicates that the directory must be listed again. The generation of
+	// a directory that doesn't exist is valid too, and changes when the directory is created.
+	// Implementations that derive the generation from timestamps may miss changes within the
+	// granularity of those timestamps; their documentation states the limits.
+	// The path argument is a slash-separated path.
+	Generation(path string) (uint64, error)
+}
@@ -0,0 +1,110 @@
+// Package generation implements a stor.Storage wrapper that adds stor.Generationer to any
+// Storage.
+//
+// If the wrapped Storage implements stor.Generationer natively, then its generations are used.
+// Otherwise the wrapper counts the writes per directory itself. Those counters only see the writes
+// through the wrapper, so it must be the only writer of the wrapped Storage.
+package generation
+
+import (
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pw1/stor"
+)
+
+// Generation is a stor.Storage wrapper that implements stor.Generationer.
+type Generation struct {
+	stor.Storage
+	native stor.Generationer
+
+	mutex    sync.Mutex
+	epoch    uint64
+	counters map[string]uint64
+}
+
+// New creates a new Generation around base.
+func New(base stor.Storage) *Generation {
+	g := &Generation{
+		Storage: base,
+		// The counters start at the creation time, so generations from before a restart are
+		// unlikely to be valid again afterwards.
+		epoch:    uint64(time.Now().UnixNano()),
+		counters: make(map[string]uint64),
+	}
+	if native, ok := base.(stor.Generationer); ok {
+		g.native = native
+	}
+	return g
+}
+
+// Generation returns the generation of a directory.
+func (g *Generation) Generation(dirPath string) (uint64, error) {
+	if g.native != nil {
+		return g.native.Generation(dirPath)
+	}
+
+	cleanPath, err := stor.CleanPath(dirPath)
+	if err != nil {
+		return 0, err
+	}
+
+	g.mutex.Lock()
+	defer g.mutex.Unlock()
+
+	return g.epoch + g.counters[cleanPath], nil
+}
+
+// bump increments the counters of all directories that contain a file.
+func (g *Generation) bump(cleanPath string) {
+	if g.native != nil {
+		return
+	}
+
+	g.mutex.Lock()
+	defer g.mutex.Unlock()
+
+	for {
+		idx := strings.LastIndexByte(cleanPath, '/')
+		if idx < 0 {
+			g.counters[""]++
+			return
+		}
+		cleanPath = cleanPath[:idx]
+		g.counters[cleanPath]++
+	}
+}
+
+// Save saves the data to the specified file.
+func (g *Generation) Save(filePath string, data []byte) error {
+	cleanPath, err := stor.CleanPath(filePath)
+	if err != nil {
+		return err
+	}
+
+	// A failed write may still have changed something, so always bump
+	defer g.bump(cleanPath)
+	return g.Storage.Save(cleanPath, data)
+}
+
+// Delete removes a file from storage.
+func (g *Generation) Delete(filePath string) error {
+	cleanPath, err := stor.CleanPath(filePath)
+	if err != nil {
+		return err
+	}
+
+	defer g.bump(cleanPath)
+	return g.Storage.Delete(cleanPath)
+}
+
+// Type returns the Type of the base Storage.
+func (g *Generation) Type() stor.Type {
+	return stor.TypeOf(g.Storage)
+}
+
+// Close closes the base Storage.
+func (g *Generation) Close() error {
+	return stor.Close(g.Storage)
+}
@@ -0,0 +1,87 @@
+package generation
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/suite"
+
+	"github.com/pw1/stor"
+	"github.com/pw1/stor/memory"
+	"github.com/pw1/stor/tester"
+)
+
+// plainStorage hides the optional interfaces of the wrapped Storage.
+type plainStorage struct {
+	stor.Storage
+}
+
+// TestGenerationStorageTester calls the generic storage tests
+func TestGenerationStorageTester(t *testing.T) {
+	testSuite := &tester.StorageTester{
+		SetupTestFunc: func(s *tester.StorageTester) {
+			base, _ := memory.New(&stor.Conf{})
+			s.Storage = New(plainStorage{base})
+		},
+	}
+
+	suite.Run(t, testSuite)
+}
+
+func TestGenerationSuite(t *testing.T) {
+	suite.Run(t, new(GenerationSuite))
+}
+
+// GenerationSuite contains tests that are specific for the Generation wrapper.
+type GenerationSuite struct {
+	suite.Suite
+	base *memory.Memory
+}
+
+func (s *GenerationSuite) SetupTest() {
+	s.base, _ = memory.New(&stor.Conf{})
+}
+
+func (s *GenerationSuite) TestCounters() {
+	g := New(plainStorage{s.base})
+
+	root, err := g.Generation("")
+	s.Nil(err)
+	dir1, err := g.Generation("dir1")
+	s.Nil(err)
+	dir3, err := g.Generation("dir3")
+	s.Nil(err)
+
+	s.Nil(g.Save("dir1/dir2/file1", []byte("test123")))
+
+	gen, err := g.Generation("")
+	s.Nil(err)
+	s.NotEqual(root, gen)
+	gen, err = g.Generation("dir1")
+	s.Nil(err)
+	s.NotEqual(dir1, gen)
+	gen, err = g.Generation("dir3")
+	s.Nil(err)
+	s.Equal(dir3, gen)
+
+	dir1, _ = g.Generation("dir1")
+	s.True(stor.IsPathDoesntExistError(g.Delete("dir1/nope")))
+	s.Nil(g.Delete("dir1/dir2/file1"))
+	gen, err = g.Generation("dir1")
+	s.Nil(err)
+	s.NotEqual(dir1, gen)
+
+	_, err = g.Generation("../escape")
+	s.True(stor.IsInvalidPathError(err))
+}
+
+func (s *GenerationSuite) TestNative() {
+	g := New(s.base)
+
+	s.Nil(s.base.Save("dir1/file1", []byte("test123")))
+
+	native, err := s.base.Generation("dir1")
+	s.Nil(err)
+	gen, err := g.Generation("dir1")
+	s.Nil(err)
+	s.Equal(native, gen)
+}
@@ -0,0 +1,244 @@
+// Package header implements a small, versioned header for stored objects whose content has been
+// encoded by one or more layers (e.g. compression, encryption, chunking, versioning).
+//
+// The header records which layers encoded the payload, and the format version of each layer. This
+// keeps stored objects decodable when a layer changes its format, or when the configuration of a
+// storage changes. It also makes corrupted or foreign objects detectable before any layer tries to
+// decode them.
+//
+// The binary format is:
+//
+//	[magic "STOR"][format version (1 byte)][layer count (1 byte)]
+//	[name length (1 byte)][name][layer version (1 byte)]  (repeated for each layer)
+//	[payload]
+//
+// The layers are listed in the order in which they were applied when encoding.
+package header
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"sync"
+)
+
+const (
+	// Magic is the start of every header.
+	Magic = "STOR"
+
+	// FormatVersion is the version of the header format itself.
+	FormatVersion uint8 = 1
+
+	// MaxLayers is the maximum number of layers in a header.
+	MaxLayers = 255
+
+	// MaxNameLen is the maximum length of a layer name.
+	MaxNameLen = 255
+)
+
+// Layer identifies an encoding layer and the version of its format.
+type Layer struct {
+	// Name of the layer, e.g. "gzip".
+	Name string
+
+	// Version of the format of the layer.
+	Version uint8
+}
+
+func (l Layer) String() string {
+	return fmt.Sprintf("%s/v%d", l.Name, l.Version)
+}
+
+// Header lists the layers that encoded a payload.
+type Header struct {
+	// Layers in the order in which they were applied.
+	Layers []Layer
+}
+
+// Size returns the size of the encoded header.
+func (h Header) Size() int {
+	size := len(Magic) + 2
+	for _, layer := range h.Layers {
+		size += len(layer.Name) + 2
+	}
+	return size
+}
+
+// Wrap returns the encoded header followed by payload.
+func (h Header) Wrap(payload []byte) ([]byte, error) {
+	if len(h.Layers) > MaxLayers {
+		return nil, fmt.Errorf("too many layers: %d", len(h.Layers))
+	}
+
+	data := make([]byte, 0, h.Size()+len(payload))
+	data = append(data, Magic...)
+	data = append(data, FormatVersion, uint8(len(h.Layers)))
+	for _, layer := range h.Layers {
+		if layer.Name == "" || len(layer.Name) > MaxNameLen {
+			return nil, fmt.Errorf("invalid layer name %q", layer.Name)
+		}
+		data = append(data, uint8(len(layer.Name)))
+		data = append(data, layer.Name...)
+		data = append(data, layer.Version)
+	}
+
+	return append(data, payload...), nil
+}
+
+// Parse parses the header at the start of data. It returns the header and the payload. A
+// FormatError is returned if data doesn't start with a valid header.
+func Parse(data []byte) (Header, []byte, error) {
+	if len(data) < len(Magic)+2 || string(data[:len(Magic)]) != Magic {
+		return Header{}, nil, &FormatError{Msg: "no header found"}
+	}
+
+	pos := len(Magic)
+	if version := data[pos]; version != FormatVersion {
+		return Header{}, nil, &FormatError{Msg: fmt.Sprintf("unsupported header version %d", version)}
+	}
+
+	count := int(data[pos+1])
+	pos += 2
+
+	h := Header{Layers: make([]Layer, 0, count)}
+	for i := 0; i < count; i++ {
+		if pos >= len(data) {
+			return Header{}, nil, &FormatError{Msg: "truncated header"}
+		}
+		nameLen := int(data[pos])
+		pos++
+
+		if nameLen == 0 || pos+nameLen+1 > len(data) {
+			return Header{}, nil, &FormatError{Msg: "truncated header"}
+		}
+		h.Layers = append(h.Layers, Layer{
+			Name:    string(data[pos : pos+nameLen]),
+			Version: data[pos+nameLen],
+		})
+		pos += nameLen + 1
+	}
+
+	return h, data[pos:], nil
+}
+
+// Decoder decodes the payload of a single layer. If the decoded data is larger than maxSize, then
+// it returns a stor.TooLargeError, and it must stop decoding as soon as that limit is exceeded.
+// The encoding of a layer must not be larger than twice its input plus 64 KiB, because Decode
+// bounds the intermediate results of nested layers accordingly.
+type Decoder func(payload []byte, maxSize int64) ([]byte, error)
+
+var (
+	decodersMutex sync.RWMutex
+
+	// decoders contains the registered decoder of each layer version.
+	decoders = make(map[Layer]Decoder)
+)
+
+// RegisterDecoder registers the decoder for a version of a layer. Layers that change their format
+// should register a decoder for each version that may still be stored. If the layer is already
+// registered, then this function will panic. This function is intended to be called from the init
+// function of packages that implement layers.
+func RegisterDecoder(layer Layer, decoder Decoder) {
+	decodersMutex.Lock()
+	defer decodersMutex.Unlock()
+
+	if _, ok := decoders[layer]; ok {
+		panic(fmt.Sprintf("header: Layer %s is already registered", layer))
+	}
+	decoders[layer] = decoder
+}
+
+// LookupDecoder returns the registered decoder of a layer version.
+func LookupDecoder(layer Layer) (Decoder, bool) {
+	decodersMutex.RLock()
+	defer decodersMutex.RUnlock()
+
+	decoder, ok := decoders[layer]
+	return decoder, ok
+}
+
+// Bounds of the intermediate steps of Decode. The encoding of a layer is expected to be at most
+// maxLayerExpansion times the size of its input plus maxLayerOverhead bytes.
+const (
+	maxLayerExpansion = 2
+	maxLayerOverhead  = 64 << 10
+)
+
+// Decode parses the header at the start of data, and decodes the payload with the registered
+// decoders of its layers (in reverse order). The maxSize argument bounds the size of the fully
+// decoded data. The intermediate results are bounded too, by the size that the encoding of data
+// of maxSize bytes can have (see maxLayerExpansion). All layers are checked before any decoding is
+// done, so data with unknown layers is rejected early with a FormatError.
+func Decode(data []byte, maxSize int64) ([]byte, error) {
+	return DecodeWith(data, maxSize, LookupDecoder)
+}
+
+// DecodeWith is like Decode, but uses lookup to find the decoder of each layer.
+func DecodeWith(data []byte, maxSize int64, lookup func(Layer) (Decoder, bool)) ([]byte, error) {
+	h, payload, err := Parse(data)
+	if err != nil {
+		return nil, err
+	}
+
+	chain := make([]Decoder, len(h.Layers))
+	for i, layer := range h.Layers {
+		decoder, ok := lookup(layer)
+		if !ok {
+			return nil, &FormatError{Msg: fmt.Sprintf("unknown layer %s", layer)}
+		}
+		chain[i] = decoder
+	}
+
+	// The output of step i is the input of the layer before it, which encoded data of at most
+	// stepMaxSize[i-1] bytes. That bounds every step, so a nested decompression bomb is caught at
+	// the outermost layer.
+	stepMaxSize := make([]int64, len(chain))
+	for i := range chain {
+		if i == 0 {
+			stepMaxSize[i] = maxSize
+		} else {
+			stepMaxSize[i] = expandedSize(stepMaxSize[i-1])
+		}
+	}
+
+	for i := len(chain) - 1; i >= 0; i-- {
+		payload, err = chain[i](payload, stepMaxSize[i])
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return payload, nil
+}
+
+// expandedSize returns the maximum size of the encoding of size bytes by a single layer.
+func expandedSize(size int64) int64 {
+	if size > (math.MaxInt64-maxLayerOverhead)/maxLayerExpansion {
+		return math.MaxInt64
+	}
+	return size*maxLayerExpansion + maxLayerOverhead
+}
+
+// FormatError indicates that data doesn't start with a valid header, or that its layers can't be
+// decoded. This typically means that the data is corrupt, or that it wasn't written by the layers
+// that are trying to read it.
+type FormatError struct {
+	Msg string
+}
+
+// ErrFormat is matched by a FormatError with errors.Is.
+var ErrFormat = errors.New("invalid object header")
+
+func (e *FormatError) Error() string {
+	return "invalid object header: " + e.Msg
+}
+
+// Is returns true for ErrFormat, so errors.Is works for wrapped errors.
+func (e *FormatError) Is(target error) bool {
+	return target == ErrFormat
+}
+
+// IsFormatError returns true if an error is, or wraps, a FormatError. Returns false otherwise.
+func IsFormatError(err error) bool {
+	return errors.Is(err, ErrFormat)
+}
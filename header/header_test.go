@@ -0,0 +1,159 @@
+package header
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"math"
+	"testing"
+
+	"github.com/stretchr/testify/suite"
+
+	"github.com/pw1/stor"
+)
+
+func TestHeaderSuite(t *testing.T) {
+	suite.Run(t, new(HeaderSuite))
+}
+
+// HeaderSuite contains the tests for encoding, parsing and decoding headers.
+type HeaderSuite struct {
+	suite.Suite
+}
+
+// upper is a test layer that upper-cases data on Encode.
+var upper = Layer{Name: "test-upper", Version: 2}
+
+func init() {
+	RegisterDecoder(upper, func(payload []byte, maxSize int64) ([]byte, error) {
+		if int64(len(payload)) > maxSize {
+			return nil, &stor.TooLargeError{}
+		}
+		return bytes.ToLower(payload), nil
+	})
+}
+
+func (s *HeaderSuite) TestWrapParse() {
+	h := Header{Layers: []Layer{{Name: "gzip", Version: 1}, {Name: "crypt", Version: 3}}}
+	data, err := h.Wrap([]byte("payload"))
+	s.Nil(err)
+	s.Equal(h.Size()+7, len(data))
+	s.Equal([]byte(Magic), data[:4])
+
+	parsed, payload, err := Parse(data)
+	s.Nil(err)
+	s.Equal(h, parsed)
+	s.Equal([]byte("payload"), payload)
+}
+
+func (s *HeaderSuite) TestWrapInvalidLayer() {
+	_, err := Header{Layers: []Layer{{Name: ""}}}.Wrap(nil)
+	s.NotNil(err)
+
+	_, err = Header{Layers: make([]Layer, MaxLayers+1)}.Wrap(nil)
+	s.NotNil(err)
+}
+
+func (s *HeaderSuite) TestParseInvalid() {
+	valid, err := Header{Layers: []Layer{{Name: "gzip", Version: 1}}}.Wrap(nil)
+	s.Require().Nil(err)
+
+	table := [][]byte{
+		nil,
+		[]byte("STO"),
+		[]byte("plain data"),
+		append([]byte(Magic), 2, 0),
+		valid[:len(valid)-1],
+		valid[:len(Magic)+3],
+	}
+	for _, data := range table {
+		_, _, err := Parse(data)
+		s.True(IsFormatError(err), "data: %q", data)
+	}
+}
+
+func (s *HeaderSuite) TestDecode() {
+	data, err := Header{Layers: []Layer{upper}}.Wrap([]byte("TEST123"))
+	s.Require().Nil(err)
+
+	decoded, err := Decode(data, 7)
+	s.Nil(err)
+	s.Equal([]byte("test123"), decoded)
+
+	_, err = Decode(data, 6)
+	s.True(stor.IsTooLargeError(err))
+}
+
+// TestDecodeUnknownLayer verifies that unknown layers are rejected before any decoding is done.
+func (s *HeaderSuite) TestDecodeUnknownLayer() {
+	data, err := Header{Layers: []Layer{{Name: "test-upper", Version: 1}}}.Wrap([]byte("TEST"))
+	s.Require().Nil(err)
+
+	_, err = Decode(data, 1e6)
+	s.True(IsFormatError(err))
+	s.Contains(err.Error(), "test-upper/v1")
+}
+
+func (s *HeaderSuite) TestDecodeWith() {
+	data, err := Header{Layers: []Layer{upper, {Name: "test-reverse"}}}.Wrap([]byte("321TSET"))
+	s.Require().Nil(err)
+
+	reverse := func(payload []byte, maxSize int64) ([]byte, error) {
+		reversed := make([]byte, len(payload))
+		for i := range payload {
+			reversed[len(payload)-1-i] = payload[i]
+		}
+		return reversed, nil
+	}
+	lookup := func(layer Layer) (Decoder, bool) {
+		if layer.Name == "test-reverse" {
+			return reverse, true
+		}
+		return LookupDecoder(layer)
+	}
+
+	decoded, err := DecodeWith(data, 1e6, lookup)
+	s.Nil(err)
+	s.Equal([]byte("test123"), decoded)
+}
+
+// TestDecodeBoundsSteps verifies that every step of the decoding is bounded, not only the last.
+func (s *HeaderSuite) TestDecodeBoundsSteps() {
+	layers := []Layer{{Name: "test-a"}, {Name: "test-b"}, {Name: "test-c"}}
+	data, err := Header{Layers: layers}.Wrap([]byte("test123"))
+	s.Require().Nil(err)
+
+	maxSizes := map[string]int64{}
+	lookup := func(layer Layer) (Decoder, bool) {
+		return func(payload []byte, maxSize int64) ([]byte, error) {
+			maxSizes[layer.Name] = maxSize
+			return payload, nil
+		}, true
+	}
+
+	_, err = DecodeWith(data, 100, lookup)
+	s.Nil(err)
+	s.Equal(map[string]int64{
+		"test-a": 100,
+		"test-b": 2*100 + 64<<10,
+		"test-c": 2*(2*100+64<<10) + 64<<10,
+	}, maxSizes)
+
+	_, err = DecodeWith(data, math.MaxInt64, lookup)
+	s.Nil(err)
+	s.Equal(int64(math.MaxInt64), maxSizes["test-c"])
+}
+
+func (s *HeaderSuite) TestRegisterDecoderDuplicate() {
+	s.Panics(func() {
+		RegisterDecoder(upper, nil)
+	})
+}
+
+func (s *HeaderSuite) TestIsFormatError() {
+	s.True(IsFormatError(&FormatError{}))
+	s.False(IsFormatError(errors.New("test")))
+	s.False(IsFormatError(nil))
+	s.True(IsFormatError(fmt.Errorf("load: %w", &FormatError{})))
+	s.True(errors.Is(&FormatError{}, ErrFormat))
+}
@@ -0,0 +1,174 @@
+package stor
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// The options of the HTTP client of network backends (see NewHTTPClient). With NewFromEnv, they
+// are read like the other options of the backend, e.g. STOR_B2_MAXCONNSPERHOST.
+const (
+	// OptionHTTPClient selects an http.Client that was registered with RegisterHTTPClient. It
+	// can't be combined with the other options of the HTTP client.
+	OptionHTTPClient = "httpclient"
+
+	// OptionMaxIdleConns is the maximum number of idle (keep-alive) connections to all hosts.
+	OptionMaxIdleConns = "maxidleconns"
+
+	// OptionMaxIdleConnsPerHost is the maximum number of idle (keep-alive) connections per host.
+	// The default of net/http is 2, which throttles workloads with many concurrent requests.
+	OptionMaxIdleConnsPerHost = "maxidleconnsperhost"
+
+	// OptionMaxConnsPerHost limits the number of connections per host, including connections that
+	// are in use. Requests wait for a free connection when the limit is reached.
+	OptionMaxConnsPerHost = "maxconnsperhost"
+
+	// OptionHTTPTimeout is the time limit of a request, including reading the response body.
+	OptionHTTPTimeout = "timeout"
+
+	// OptionIdleConnTimeout is how long idle connections are kept before they are closed.
+	OptionIdleConnTimeout = "idleconntimeout"
+
+	// OptionKeepAlive is the interval of TCP keep-alive probes. A negative value disables them.
+	OptionKeepAlive = "keepalive"
+)
+
+// HTTPOptionInfos describes the options of the HTTP client, for the Info of network backends.
+var HTTPOptionInfos = []OptionInfo{
+	{Name: OptionHTTPClient, Description: "Name of an http.Client that was registered with " +
+		"RegisterHTTPClient"},
+	{Name: OptionMaxIdleConns, Description: "Maximum number of idle connections to all hosts"},
+	{Name: OptionMaxIdleConnsPerHost, Description: "Maximum number of idle connections per " +
+		"host. Default: 2."},
+	{Name: OptionMaxConnsPerHost, Description: "Maximum number of connections per host. " +
+		"Unlimited by default."},
+	{Name: OptionHTTPTimeout, Description: "Time limit of a request (e.g. \"30s\"), " +
+		"including reading the response. Unlimited by default."},
+	{Name: OptionIdleConnTimeout, Description: "Duration after which idle connections are " +
+		"closed (e.g. \"90s\")"},
+	{Name: OptionKeepAlive, Description: "Interval of TCP keep-alive probes (e.g. \"30s\"). " +
+		"A negative value disables them."},
+}
+
+var (
+	httpClientsMutex sync.RWMutex
+	httpClients      = make(map[string]*http.Client)
+)
+
+// RegisterHTTPClient registers an http.Client under a name, so network backends can use it with
+// OptionHTTPClient, e.g. a client with a custom Transport for proxies, TLS settings or
+// instrumentation. The same client may be used by several Storages. It panics if the name is
+// empty or already registered.
+func RegisterHTTPClient(name string, client *http.Client) {
+	if name == "" {
+		panic("stor: empty name of HTTP client")
+	}
+
+	httpClientsMutex.Lock()
+	defer httpClientsMutex.Unlock()
+
+	if _, ok := httpClients[name]; ok {
+		panic(fmt.Sprintf("stor: HTTP client %s is already registered", name))
+	}
+	httpClients[name] = client
+}
+
+// lookupHTTPClient returns the http.Client that was registered under a name.
+func lookupHTTPClient(name string) (*http.Client, bool) {
+	httpClientsMutex.RLock()
+	defer httpClientsMutex.RUnlock()
+
+	client, ok := httpClients[name]
+	return client, ok
+}
+
+// ValidateHTTPOptions checks the options of the HTTP client (see NewHTTPClient) in a
+// configuration of a network backend of the specified Type.
+func ValidateHTTPOptions(storageType Type, conf *Conf) error {
+	_, err := NewHTTPClient(storageType, conf)
+	return err
+}
+
+// NewHTTPClient returns the http.Client of a network backend of the specified Type. This is the
+// registered client if OptionHTTPClient is set, a new client with its own Transport if any of the
+// other options of the HTTP client are set, and http.DefaultClient otherwise. The new Transport
+// starts from the settings of http.DefaultTransport. It returns a ConfError if an option is
+// invalid.
+func NewHTTPClient(storageType Type, conf *Conf) (*http.Client, error) {
+	confError := func(option, msg string) error {
+		return &ConfError{Type: storageType, Field: "Options[" + option + "]", Msg: msg}
+	}
+
+	tuned := false
+	for _, info := range HTTPOptionInfos {
+		if info.Name != OptionHTTPClient && conf.Options[info.Name] != "" {
+			tuned = true
+		}
+	}
+
+	if name := conf.Options[OptionHTTPClient]; name != "" {
+		if tuned {
+			return nil, confError(OptionHTTPClient, "can't be combined with other HTTP options")
+		}
+		client, ok := lookupHTTPClient(name)
+		if !ok {
+			return nil, confError(OptionHTTPClient, "must be a registered HTTP client")
+		}
+		return client, nil
+	}
+	if !tuned {
+		return http.DefaultClient, nil
+	}
+
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	client := &http.Client{Transport: transport}
+
+	for _, limit := range []struct {
+		option string
+		field  *int
+	}{
+		{OptionMaxIdleConns, &transport.MaxIdleConns},
+		{OptionMaxIdleConnsPerHost, &transport.MaxIdleConnsPerHost},
+		{OptionMaxConnsPerHost, &transport.MaxConnsPerHost},
+	} {
+		if value := conf.Options[limit.option]; value != "" {
+			n, err := strconv.Atoi(value)
+			if err != nil || n < 0 {
+				return nil, confError(limit.option, "must be a positive number")
+			}
+			*limit.field = n
+		}
+	}
+
+	for _, timeout := range []struct {
+		option string
+		field  *time.Duration
+	}{
+		{OptionHTTPTimeout, &client.Timeout},
+		{OptionIdleConnTimeout, &transport.IdleConnTimeout},
+	} {
+		if value := conf.Options[timeout.option]; value != "" {
+			d, err := time.ParseDuration(value)
+			if err != nil || d < 0 {
+				return nil, confError(timeout.option, "must be a positive duration")
+			}
+			*timeout.field = d
+		}
+	}
+
+	if value := conf.Options[OptionKeepAlive]; value != "" {
+		keepAlive, err := time.ParseDuration(value)
+		if err != nil {
+			return nil, confError(OptionKeepAlive, "must be a duration")
+		}
+		// These are the dialer settings of http.DefaultTransport, apart from the keep-alive
+		dialer := &net.Dialer{Timeout: 30 * time.Second, KeepAlive: keepAlive}
+		transport.DialContext = dialer.DialContext
+	}
+
+	return client, nil
+}
@@ -0,0 +1,92 @@
+package stor_test
+
+import (
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/suite"
+
+	"github.com/pw1/stor"
+)
+
+// registeredClient is registered as "httpclient-test".
+var registeredClient = &http.Client{Timeout: time.Minute}
+
+func init() {
+	stor.RegisterHTTPClient("httpclient-test", registeredClient)
+}
+
+func TestHTTPClientSuite(t *testing.T) {
+	suite.Run(t, new(HTTPClientSuite))
+}
+
+// HTTPClientSuite contains the tests for NewHTTPClient.
+type HTTPClientSuite struct {
+	suite.Suite
+}
+
+func (s *HTTPClientSuite) TestDefault() {
+	client, err := stor.NewHTTPClient("Test", &stor.Conf{})
+	s.Nil(err)
+	s.Same(http.DefaultClient, client)
+}
+
+func (s *HTTPClientSuite) TestRegistered() {
+	conf := &stor.Conf{Options: map[string]string{stor.OptionHTTPClient: "httpclient-test"}}
+	client, err := stor.NewHTTPClient("Test", conf)
+	s.Nil(err)
+	s.Same(registeredClient, client)
+
+	conf.Options[stor.OptionHTTPClient] = "unknown"
+	_, err = stor.NewHTTPClient("Test", conf)
+	s.EqualError(err, "invalid Test configuration: Options[httpclient] must be a registered "+
+		"HTTP client")
+
+	conf.Options[stor.OptionHTTPClient] = "httpclient-test"
+	conf.Options[stor.OptionHTTPTimeout] = "1s"
+	_, err = stor.NewHTTPClient("Test", conf)
+	s.True(stor.IsConfError(err))
+
+	s.Panics(func() { stor.RegisterHTTPClient("httpclient-test", &http.Client{}) })
+	s.Panics(func() { stor.RegisterHTTPClient("", &http.Client{}) })
+}
+
+func (s *HTTPClientSuite) TestTuned() {
+	client, err := stor.NewHTTPClient("Test", &stor.Conf{Options: map[string]string{
+		stor.OptionMaxIdleConns:        "200",
+		stor.OptionMaxIdleConnsPerHost: "100",
+		stor.OptionMaxConnsPerHost:     "150",
+		stor.OptionHTTPTimeout:         "30s",
+		stor.OptionIdleConnTimeout:     "2m",
+		stor.OptionKeepAlive:           "-1s",
+	}})
+	s.Require().Nil(err)
+	s.False(client == http.DefaultClient)
+	s.Equal(30*time.Second, client.Timeout)
+
+	transport := client.Transport.(*http.Transport)
+	s.False(http.DefaultTransport == transport)
+	s.Equal(200, transport.MaxIdleConns)
+	s.Equal(100, transport.MaxIdleConnsPerHost)
+	s.Equal(150, transport.MaxConnsPerHost)
+	s.Equal(2*time.Minute, transport.IdleConnTimeout)
+	s.NotNil(transport.DialContext)
+	s.NotNil(transport.Proxy, "the settings of http.DefaultTransport are kept")
+}
+
+func (s *HTTPClientSuite) TestInvalid() {
+	for option, value := range map[string]string{
+		stor.OptionMaxIdleConns:        "-1",
+		stor.OptionMaxIdleConnsPerHost: "many",
+		stor.OptionMaxConnsPerHost:     "1.5",
+		stor.OptionHTTPTimeout:         "-1s",
+		stor.OptionIdleConnTimeout:     "2",
+		stor.OptionKeepAlive:           "forever",
+	} {
+		conf := &stor.Conf{Options: map[string]string{option: value}}
+		err := stor.ValidateHTTPOptions("Test", conf)
+		s.True(stor.IsConfError(err), option)
+		s.Contains(err.Error(), "Options["+option+"]")
+	}
+}
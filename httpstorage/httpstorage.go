@@ -0,0 +1,304 @@
+// Package httpstorage implements a read-only stor.Storage on top of a plain HTTP file server, such
+// as a CDN or a static web server.
+//
+// Load maps to a GET request and Meta to a HEAD request of the base URL joined with the file path.
+// Listing directories requires a server that exposes a directory index in a known format. Save and
+// Delete always return a stor.ReadOnlyError.
+//
+// The following stor.Conf fields are used:
+//
+//	Path              Base URL of the files, e.g. https://cdn.example.com/data.
+//	Options["index"]  Format of directory indexes: "none" (default) or "nginx-json" (nginx with
+//	                  "autoindex on; autoindex_format json;").
+//
+// The HTTP client is configured with the options of stor.NewHTTPClient, e.g. "maxconnsperhost".
+//
+// With stor.NewFromEnv, the index format is read from the variable STOR_HTTP_INDEX.
+package httpstorage
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"math"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/pw1/stor"
+)
+
+const (
+	// HTTPStorageType is the storage type of the HTTP storage.
+	HTTPStorageType stor.Type = "HTTP"
+
+	// IndexNone disables List.
+	IndexNone = "none"
+	// IndexNginxJSON parses the JSON directory index of the nginx autoindex module.
+	IndexNginxJSON = "nginx-json"
+)
+
+func init() {
+	newStorageFunc := func(conf *stor.Conf) (stor.Storage, error) {
+		return New(conf)
+	}
+	stor.RegisterType(HTTPStorageType, newStorageFunc)
+	stor.RegisterValidator(HTTPStorageType, Validate)
+	stor.RegisterInfo(stor.Info{
+		Type:        HTTPStorageType,
+		Description: "Files on a plain HTTP server (read-only)",
+		Path:        "Base URL of the files",
+		Options: append([]stor.OptionInfo{
+			{Name: "index", Description: "Format of directory indexes: \"none\" (default) or " +
+				"\"nginx-json\""},
+		}, stor.HTTPOptionInfos...),
+		Capabilities: []stor.Capability{stor.CapabilityPersistent, stor.CapabilityReadOnly},
+	})
+
+	// Storage URLs (see stor.NewFromURL) are the base URL, with the options as query parameters,
+	// e.g. "https://example.com/data?index=nginx-json"
+	stor.RegisterScheme("http", HTTPStorageType, baseURL)
+	stor.RegisterScheme("https", HTTPStorageType, baseURL)
+}
+
+// baseURL returns a storage URL without its query parameters.
+func baseURL(u *url.URL) string {
+	base := *u
+	base.RawQuery = ""
+	base.ForceQuery = false
+	return base.String()
+}
+
+// HTTP is a read-only stor.Storage that serves files from an HTTP server.
+type HTTP struct {
+	base   *url.URL
+	index  string
+	client *http.Client
+}
+
+// Validate checks a configuration of the HTTP storage without making any requests.
+func Validate(conf *stor.Conf) error {
+	base, err := url.Parse(conf.Path)
+	if err != nil || (base.Scheme != "http" && base.Scheme != "https") {
+		return &stor.ConfError{Type: HTTPStorageType, Field: "Path",
+			Msg: "must be an http or https URL"}
+	}
+
+	switch conf.Options["index"] {
+	case "", IndexNone, IndexNginxJSON:
+	default:
+		return &stor.ConfError{Type: HTTPStorageType, Field: "Options[index]",
+			Msg: "must be " + IndexNone + " or " + IndexNginxJSON}
+	}
+
+	return stor.ValidateHTTPOptions(HTTPStorageType, conf)
+}
+
+// New creates a new HTTP storage.
+func New(conf *stor.Conf) (*HTTP, error) {
+	if err := Validate(conf); err != nil {
+		return nil, err
+	}
+
+	base, _ := url.Parse(conf.Path)
+	base.Path = strings.TrimSuffix(base.Path, "/")
+
+	index := conf.Options["index"]
+	if index == "" {
+		index = IndexNone
+	}
+
+	client, _ := stor.NewHTTPClient(HTTPStorageType, conf)
+
+	h := &HTTP{
+		base:   base,
+		index:  index,
+		client: client,
+	}
+	return h, nil
+}
+
+// url returns the URL of a cleaned path. Directories get a trailing slash.
+func (h *HTTP) url(cleanPath string, dir bool) string {
+	fileURL := *h.base
+	fileURL.Path += "/" + cleanPath
+	if dir && cleanPath != "" {
+		fileURL.Path += "/"
+	}
+	return fileURL.String()
+}
+
+// get performs a request for a cleaned path.
+func (h *HTTP) get(method, cleanPath string, dir bool) (*http.Response, error) {
+	req, err := http.NewRequest(method, h.url(cleanPath, dir), nil)
+	if err != nil {
+		return nil, err
+	}
+	return h.client.Do(req)
+}
+
+// isDirectory checks whether the server redirected a request for a file to the directory of the
+// same name (e.g. from "/dir" to "/dir/"), like file servers do for directories.
+func (h *HTTP) isDirectory(cleanPath string, resp *http.Response) bool {
+	return resp.Request != nil && resp.Request.URL.Path == h.base.Path+"/"+cleanPath+"/"
+}
+
+// opError wraps an error of a request in a stor.OpError.
+func opError(op, cleanPath string, err error) error {
+	return &stor.OpError{Op: op, Backend: HTTPStorageType, Path: cleanPath, Err: err}
+}
+
+// statusError creates an error for an unexpected response to a request for op. Responses that
+// indicate a temporary condition result in a stor.TemporaryError.
+func statusError(op, method, cleanPath string, resp *http.Response) error {
+	msg, _ := ioutil.ReadAll(io.LimitReader(resp.Body, 512))
+	err := &stor.OpError{
+		Op:      op,
+		Backend: HTTPStorageType,
+		Path:    cleanPath,
+		Err: fmt.Errorf("http: %s %s failed with status %s: %s",
+			method, cleanPath, resp.Status, strings.TrimSpace(string(msg))),
+		RequestID: resp.Header.Get("X-Request-Id"),
+	}
+	return stor.HTTPTemporaryError(resp, err)
+}
+
+// Type returns HTTPStorageType.
+func (h *HTTP) Type() stor.Type {
+	return HTTPStorageType
+}
+
+// Meta returns meta information about a file.
+func (h *HTTP) Meta(filePath string) (*stor.Meta, error) {
+	cleanPath, err := stor.CleanPath(filePath)
+	if err != nil {
+		return nil, err
+	}
+	if cleanPath == "" {
+		return nil, &stor.PathDoesntExistError{Path: cleanPath}
+	}
+
+	resp, err := h.get(http.MethodHead, cleanPath, false)
+	if err != nil {
+		return nil, opError(stor.OpMeta, cleanPath, err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode == http.StatusNotFound || h.isDirectory(cleanPath, resp) {
+		return nil, &stor.PathDoesntExistError{Path: cleanPath}
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, statusError(stor.OpMeta, http.MethodHead, cleanPath, resp)
+	}
+
+	// Servers that don't report the size (e.g. because of chunked encoding) require a GET.
+	size := resp.ContentLength
+	if size < 0 {
+		data, err := h.Load(cleanPath, math.MaxInt64)
+		if err != nil {
+			return nil, err
+		}
+		size = int64(len(data))
+	}
+
+	meta := &stor.Meta{
+		Size: size,
+	}
+	return meta, nil
+}
+
+// nginxEntry is an entry of the JSON directory index of nginx.
+type nginxEntry struct {
+	Name string `json:"name"`
+	Type string `json:"type"`
+}
+
+// List returns the files and subdirectories within the specified directory. This requires the index
+// option to be set.
+func (h *HTTP) List(dirPath string) ([]string, []string, error) {
+	cleanPath, err := stor.CleanPath(dirPath)
+	if err != nil {
+		return []string{}, []string{}, err
+	}
+	if h.index == IndexNone {
+		return []string{}, []string{}, errors.New("http storage has no directory index")
+	}
+
+	resp, err := h.get(http.MethodGet, cleanPath, true)
+	if err != nil {
+		return []string{}, []string{}, opError(stor.OpList, cleanPath, err)
+	}
+	defer resp.Body.Close()
+
+	files := []string{}
+	dirs := []string{}
+	if resp.StatusCode == http.StatusNotFound {
+		return files, dirs, nil
+	}
+	if resp.StatusCode != http.StatusOK {
+		return []string{}, []string{}, statusError(stor.OpList, http.MethodGet, cleanPath, resp)
+	}
+
+	var entries []nginxEntry
+	if err := json.NewDecoder(resp.Body).Decode(&entries); err != nil {
+		return []string{}, []string{}, fmt.Errorf("http: invalid directory index: %v", err)
+	}
+
+	for _, entry := range entries {
+		entryPath := entry.Name
+		if cleanPath != "" {
+			entryPath = cleanPath + "/" + entry.Name
+		}
+
+		switch entry.Type {
+		case "directory":
+			dirs = append(dirs, entryPath)
+		case "file":
+			files = append(files, entryPath)
+		}
+	}
+
+	return files, dirs, nil
+}
+
+// Load loads the content of the specified file. If the file is larger than maxSize, the an error is
+// returned.
+func (h *HTTP) Load(filePath string, maxSize int64) ([]byte, error) {
+	cleanPath, err := stor.CleanPath(filePath)
+	if err != nil {
+		return []byte{}, err
+	}
+	if cleanPath == "" {
+		return []byte{}, &stor.PathDoesntExistError{Path: cleanPath}
+	}
+
+	resp, err := h.get(http.MethodGet, cleanPath, false)
+	if err != nil {
+		return []byte{}, opError(stor.OpLoad, cleanPath, err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode == http.StatusNotFound || h.isDirectory(cleanPath, resp) {
+		return []byte{}, &stor.PathDoesntExistError{Path: cleanPath}
+	}
+	if resp.StatusCode != http.StatusOK {
+		return []byte{}, statusError(stor.OpLoad, http.MethodGet, cleanPath, resp)
+	}
+	if resp.ContentLength > maxSize {
+		return []byte{}, &stor.TooLargeError{What: cleanPath}
+	}
+
+	return stor.ReadAllMax(resp.Body, maxSize, cleanPath)
+}
+
+// Save always returns a stor.ReadOnlyError, because an HTTP storage is read-only.
+func (h *HTTP) Save(filePath string, data []byte) error {
+	return &stor.ReadOnlyError{Path: filePath}
+}
+
+// Delete always returns a stor.ReadOnlyError, because an HTTP storage is read-only.
+func (h *HTTP) Delete(filePath string) error {
+	return &stor.ReadOnlyError{Path: filePath}
+}
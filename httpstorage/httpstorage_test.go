@@ -0,0 +1,331 @@
+package httpstorage
+
+import (
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/suite"
+
+	"github.com/pw1/stor"
+	"github.com/pw1/stor/tester"
+)
+
+// fakeServer emulates a static file server with the nginx JSON directory index.
+type fakeServer struct {
+	files      map[string]string
+	chunked    bool
+	status     int
+	retryAfter string
+	requestID  string
+}
+
+func (f *fakeServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	name := strings.TrimPrefix(r.URL.Path, "/static/")
+
+	if f.status != 0 {
+		w.Header().Set("Retry-After", f.retryAfter)
+		w.Header().Set("X-Request-Id", f.requestID)
+		http.Error(w, "slow down", f.status)
+		return
+	}
+
+	if name == "" || strings.HasSuffix(name, "/") {
+		entries := map[string]nginxEntry{}
+		for filePath := range f.files {
+			if !strings.HasPrefix(filePath, name) {
+				continue
+			}
+			rest := filePath[len(name):]
+			if idx := strings.Index(rest, "/"); idx >= 0 {
+				entries[rest[:idx]] = nginxEntry{Name: rest[:idx], Type: "directory"}
+			} else {
+				entries[rest] = nginxEntry{Name: rest, Type: "file"}
+			}
+		}
+		if len(entries) == 0 {
+			http.NotFound(w, r)
+			return
+		}
+		list := []nginxEntry{}
+		for _, entry := range entries {
+			list = append(list, entry)
+		}
+		sort.Slice(list, func(i, j int) bool { return list[i].Name < list[j].Name })
+		json.NewEncoder(w).Encode(list)
+		return
+	}
+
+	content, ok := f.files[name]
+	if !ok {
+		http.NotFound(w, r)
+		return
+	}
+	if f.chunked {
+		// Flushing before writing forces a response without Content-Length
+		w.(http.Flusher).Flush()
+		if r.Method == http.MethodGet {
+			w.Write([]byte(content))
+		}
+		return
+	}
+	http.ServeContent(w, r, name, time.Time{}, strings.NewReader(content))
+}
+
+// TestHTTPStorageTester calls the generic storage tests
+func TestHTTPStorageTester(t *testing.T) {
+	server := httptest.NewServer(&fakeServer{files: tester.StandardFiles})
+	defer server.Close()
+
+	testSuite := &tester.StorageTester{
+		ConfFactory: func() *stor.Conf {
+			return &stor.Conf{
+				Type:    HTTPStorageType,
+				Path:    server.URL + "/static",
+				Options: map[string]string{"index": IndexNginxJSON},
+			}
+		},
+		ReadOnly: true,
+	}
+
+	suite.Run(t, testSuite)
+}
+
+func TestHTTPSuite(t *testing.T) {
+	suite.Run(t, new(HTTPSuite))
+}
+
+// HTTPSuite contains the tests for the HTTP storage.
+type HTTPSuite struct {
+	suite.Suite
+	fake    *fakeServer
+	server  *httptest.Server
+	storage *HTTP
+}
+
+func (s *HTTPSuite) SetupTest() {
+	s.fake = &fakeServer{files: map[string]string{
+		"file1":           "test123",
+		"dir1/file2":      "test456",
+		"dir1/dir4/file5": "test788909",
+	}}
+	s.server = httptest.NewServer(s.fake)
+
+	var err error
+	s.storage, err = New(&stor.Conf{
+		Path:    s.server.URL + "/static/",
+		Options: map[string]string{"index": IndexNginxJSON},
+	})
+	s.Require().Nil(err)
+}
+
+func (s *HTTPSuite) TearDownTest() {
+	s.server.Close()
+}
+
+func (s *HTTPSuite) TestNewInvalid() {
+	_, err := New(&stor.Conf{Path: "ftp://example.com"})
+	s.NotNil(err)
+
+	_, err = New(&stor.Conf{
+		Path:    "https://example.com",
+		Options: map[string]string{"index": "apache"},
+	})
+	s.True(stor.IsConfError(err))
+	s.Equal("invalid HTTP configuration: Options[index] must be none or nginx-json", err.Error())
+}
+
+func (s *HTTPSuite) TestRegistered() {
+	storage, err := stor.New(&stor.Conf{Type: HTTPStorageType, Path: s.server.URL})
+	s.Nil(err)
+	s.IsType(&HTTP{}, storage)
+}
+
+func (s *HTTPSuite) TestHTTPClient() {
+	storage, err := New(&stor.Conf{
+		Path:    s.server.URL + "/static/",
+		Options: map[string]string{stor.OptionHTTPClient: "httpstorage-test"},
+	})
+	s.Require().Nil(err)
+
+	before := atomic.LoadInt64(&countedRequests)
+	data, err := storage.Load("file1", 100)
+	s.Nil(err)
+	s.Equal("test123", string(data))
+	s.Equal(before+1, atomic.LoadInt64(&countedRequests))
+
+	_, err = New(&stor.Conf{
+		Path:    s.server.URL,
+		Options: map[string]string{stor.OptionMaxConnsPerHost: "-1"},
+	})
+	s.True(stor.IsConfError(err))
+}
+
+// countedRequests is the number of requests of the HTTP client "httpstorage-test".
+var countedRequests int64
+
+// roundTripFunc is an http.RoundTripper that calls itself.
+type roundTripFunc func(req *http.Request) (*http.Response, error)
+
+func (f roundTripFunc) RoundTrip(req *http.Request) (*http.Response, error) {
+	return f(req)
+}
+
+func init() {
+	transport := roundTripFunc(func(req *http.Request) (*http.Response, error) {
+		atomic.AddInt64(&countedRequests, 1)
+		return http.DefaultTransport.RoundTrip(req)
+	})
+	stor.RegisterHTTPClient("httpstorage-test", &http.Client{Transport: transport})
+}
+
+func (s *HTTPSuite) TestParseURL() {
+	_, err := stor.ParseURL("https://user@example.com:8443/data/?index=nginx-json")
+	s.NotNil(err, "user info is not supported")
+
+	conf, err := stor.ParseURL("https://example.com:8443/data/?index=nginx-json")
+	s.Nil(err)
+	s.Equal(&stor.Conf{
+		Type:    HTTPStorageType,
+		Path:    "https://example.com:8443/data/",
+		Options: map[string]string{"index": IndexNginxJSON},
+	}, conf)
+
+	storage, err := stor.NewFromURL(s.server.URL + "?index=nginx-json")
+	s.Nil(err)
+	s.IsType(&HTTP{}, storage)
+}
+
+func (s *HTTPSuite) TestLoad() {
+	data, err := s.storage.Load("dir1/dir4/file5", 10)
+	s.Nil(err)
+	s.Equal("test788909", string(data))
+
+	_, err = s.storage.Load("dir1/dir4/file5", 9)
+	s.True(stor.IsTooLargeError(err))
+
+	_, err = s.storage.Load("nonexistent", 100)
+	s.True(stor.IsPathDoesntExistError(err))
+
+	_, err = s.storage.Load("", 100)
+	s.True(stor.IsPathDoesntExistError(err))
+
+	_, err = s.storage.Load("../file1", 100)
+	s.True(stor.IsInvalidPathError(err))
+}
+
+func (s *HTTPSuite) TestLoadChunked() {
+	s.fake.chunked = true
+
+	_, err := s.storage.Load("dir1/dir4/file5", 9)
+	s.True(stor.IsTooLargeError(err))
+}
+
+func (s *HTTPSuite) TestMeta() {
+	meta, err := s.storage.Meta("dir1/file2")
+	s.Nil(err)
+	s.Equal(int64(7), meta.Size)
+
+	_, err = s.storage.Meta("nonexistent")
+	s.True(stor.IsPathDoesntExistError(err))
+}
+
+func (s *HTTPSuite) TestMetaChunked() {
+	s.fake.chunked = true
+
+	meta, err := s.storage.Meta("dir1/file2")
+	s.Nil(err)
+	s.Equal(int64(7), meta.Size)
+}
+
+func (s *HTTPSuite) TestList() {
+	files, dirs, err := s.storage.List("")
+	s.Nil(err)
+	s.Equal([]string{"file1"}, files)
+	s.Equal([]string{"dir1"}, dirs)
+
+	files, dirs, err = s.storage.List("dir1")
+	s.Nil(err)
+	s.Equal([]string{"dir1/file2"}, files)
+	s.Equal([]string{"dir1/dir4"}, dirs)
+
+	files, dirs, err = s.storage.List("nonexistent")
+	s.Nil(err)
+	s.Empty(files)
+	s.Empty(dirs)
+}
+
+func (s *HTTPSuite) TestListWithoutIndex() {
+	storage, err := New(&stor.Conf{Path: s.server.URL + "/static"})
+	s.Require().Nil(err)
+
+	_, _, err = storage.List("")
+	s.NotNil(err)
+}
+
+func (s *HTTPSuite) TestReadOnly() {
+	s.True(stor.IsReadOnlyError(s.storage.Save("file1", []byte("new"))))
+	s.True(stor.IsReadOnlyError(s.storage.Delete("file1")))
+}
+
+func (s *HTTPSuite) TestThrottled() {
+	s.fake.status = http.StatusServiceUnavailable
+	s.fake.retryAfter = "3"
+
+	_, err := s.storage.Load("file1", 100)
+	s.True(stor.IsTemporaryError(err))
+	retryAfter, ok := stor.RetryAfter(err)
+	s.True(ok)
+	s.Equal(3*time.Second, retryAfter)
+
+	s.fake.status = http.StatusInternalServerError
+	_, err = s.storage.Meta("file1")
+	s.NotNil(err)
+	s.False(stor.IsTemporaryError(err))
+}
+
+// TestDirectoryRedirect verifies that directories aren't mistaken for files when the server
+// redirects them to their index, like http.FileServer does.
+func (s *HTTPSuite) TestDirectoryRedirect() {
+	dir := s.T().TempDir()
+	s.Require().Nil(os.MkdirAll(filepath.Join(dir, "static", "dir1"), 0700))
+	s.Require().Nil(ioutil.WriteFile(filepath.Join(dir, "static", "dir1", "file2"),
+		[]byte("test456"), 0600))
+	server := httptest.NewServer(http.FileServer(http.Dir(dir)))
+	defer server.Close()
+
+	storage, err := New(&stor.Conf{Path: server.URL + "/static"})
+	s.Require().Nil(err)
+
+	_, err = storage.Meta("dir1")
+	s.True(stor.IsPathDoesntExistError(err))
+	data, err := storage.Load("dir1", 1e6)
+	s.True(stor.IsPathDoesntExistError(err))
+	s.Equal([]byte{}, data)
+
+	data, err = storage.Load("dir1/file2", 1e6)
+	s.Nil(err)
+	s.Equal([]byte("test456"), data)
+}
+
+func (s *HTTPSuite) TestRequestID() {
+	s.fake.status = http.StatusForbidden
+	s.fake.requestID = "req-1"
+
+	_, err := s.storage.Load("dir1/file2", 100)
+	var opErr *stor.OpError
+	s.Require().True(errors.As(err, &opErr))
+	s.Equal(stor.OpLoad, opErr.Op)
+	s.Equal("dir1/file2", opErr.Path)
+	s.Equal("req-1", opErr.RequestID)
+	s.Contains(err.Error(), "req-1")
+}
@@ -0,0 +1,30 @@
+package stor
+
+import "io"
+
+// Typer can report the Type of a Storage. Backends return their own Type, and wrappers return the
+// Type of the Storage that they wrap, so the Type of a stack of wrappers is the Type of its
+// backend.
+type Typer interface {
+	// Type returns the Type of the Storage.
+	Type() Type
+}
+
+// TypeOf returns the Type of s if it implements Typer, or TypeUnspecified otherwise.
+func TypeOf(s Reader) Type {
+	if typer, ok := s.(Typer); ok {
+		return typer.Type()
+	}
+	return TypeUnspecified
+}
+
+// Close releases the resources of s, e.g. connections or background goroutines, if it implements
+// io.Closer. A Storage that doesn't implement io.Closer holds no resources, so Close does nothing
+// then. Wrappers close the Storages that they wrap, so closing the outermost wrapper of a stack
+// closes the whole stack.
+func Close(s Reader) error {
+	if closer, ok := s.(io.Closer); ok {
+		return closer.Close()
+	}
+	return nil
+}
@@ -0,0 +1,65 @@
+package stor_test
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/suite"
+
+	"github.com/pw1/stor"
+	"github.com/pw1/stor/memory"
+)
+
+func TestLifecycleSuite(t *testing.T) {
+	suite.Run(t, new(LifecycleSuite))
+}
+
+// LifecycleSuite contains the tests for TypeOf and Close.
+type LifecycleSuite struct {
+	suite.Suite
+	mem *memory.Memory
+}
+
+// closeCounter is a Storage that counts how often it is closed.
+type closeCounter struct {
+	stor.Storage
+	closed int
+	err    error
+}
+
+func (c *closeCounter) Close() error {
+	c.closed++
+	return c.err
+}
+
+func (s *LifecycleSuite) SetupTest() {
+	s.mem, _ = memory.New(&stor.Conf{})
+}
+
+func (s *LifecycleSuite) TestTypeOf() {
+	s.Equal(memory.MemoryStorageType, stor.TypeOf(s.mem))
+	s.Equal(stor.TypeUnspecified, stor.TypeOf(&plainStorage{s.mem}))
+
+	for _, st := range []stor.Storage{stor.ReadOnly(s.mem), stor.WithSortedList(s.mem),
+		stor.WithMaxSize(stor.WithSortedList(s.mem), 100)} {
+		s.Equal(memory.MemoryStorageType, stor.TypeOf(st))
+	}
+}
+
+func (s *LifecycleSuite) TestClose() {
+	s.Nil(stor.Close(&plainStorage{s.mem}))
+
+	counter := &closeCounter{Storage: s.mem}
+	s.Nil(stor.Close(stor.WithMaxSize(stor.WithSortedList(counter), 100)))
+	s.Equal(1, counter.closed)
+
+	counter.err = errors.New("broken")
+	s.EqualError(stor.Close(stor.WithSortedList(counter)), "broken")
+	s.Equal(2, counter.closed)
+}
+
+func (s *LifecycleSuite) TestReadOnlyDoesntClose() {
+	counter := &closeCounter{Storage: s.mem}
+	s.Nil(stor.Close(stor.ReadOnly(counter)))
+	s.Equal(0, counter.closed)
+}
@@ -0,0 +1,47 @@
+package stor
+
+// MetaLister can list a directory together with the Meta of its files, e.g. because the backend
+// returns this information with the listing anyway. This saves a Meta call for every file.
+type MetaLister interface {
+	// ListWithMeta returns the files within a directory with their Meta, and the subdirectories,
+	// like List.
+	// The path argument is a slash-separated path.
+	ListWithMeta(path string) ([]Entry, []string, error)
+}
+
+// Entry is a file with its Meta, as returned by ListWithMeta.
+type Entry struct {
+	// Path is the slash-separated path of the file.
+	Path string
+
+	// Meta is the meta information of the file.
+	Meta Meta
+}
+
+// ListWithMeta returns the files within a directory of s with their Meta, and the subdirectories.
+// If s implements MetaLister, then the native listing is used. Otherwise the directory is listed,
+// and the Meta of every file is requested separately. Files that are deleted meanwhile are
+// skipped.
+func ListWithMeta(s Reader, dirPath string) ([]Entry, []string, error) {
+	if metaLister, ok := s.(MetaLister); ok {
+		return metaLister.ListWithMeta(dirPath)
+	}
+
+	files, dirs, err := s.List(dirPath)
+	if err != nil {
+		return []Entry{}, []string{}, err
+	}
+
+	entries := make([]Entry, 0, len(files))
+	for _, filePath := range files {
+		meta, err := s.Meta(filePath)
+		if IsPathDoesntExistError(err) {
+			continue
+		}
+		if err != nil {
+			return []Entry{}, []string{}, err
+		}
+		entries = append(entries, Entry{Path: filePath, Meta: *meta})
+	}
+	return entries, dirs, nil
+}
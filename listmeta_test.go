@@ -0,0 +1,73 @@
+package stor_test
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/suite"
+
+	"github.com/pw1/stor"
+	"github.com/pw1/stor/memory"
+)
+
+func TestListWithMetaSuite(t *testing.T) {
+	suite.Run(t, new(ListWithMetaSuite))
+}
+
+// ListWithMetaSuite contains the tests for ListWithMeta.
+type ListWithMetaSuite struct {
+	suite.Suite
+	mem *memory.Memory
+}
+
+// vanishingMeta is a Storage of which Meta reports that the file doesn't exist.
+type vanishingMeta struct {
+	stor.Storage
+}
+
+func (v *vanishingMeta) Meta(filePath string) (*stor.Meta, error) {
+	return nil, &stor.PathDoesntExistError{Path: filePath}
+}
+
+func (s *ListWithMetaSuite) SetupTest() {
+	s.mem, _ = memory.New(&stor.Conf{})
+	s.Require().Nil(s.mem.Save("file1", []byte("test123")))
+	s.Require().Nil(s.mem.Save("dir1/file2", []byte("test")))
+	s.Require().Nil(s.mem.Save("dir1/dir2/file3", []byte("")))
+}
+
+func (s *ListWithMetaSuite) TestNative() {
+	var _ stor.MetaLister = s.mem
+
+	entries, dirs, err := stor.ListWithMeta(s.mem, "dir1")
+	s.Nil(err)
+	s.Equal([]stor.Entry{{Path: "dir1/file2", Meta: stor.Meta{Size: 4}}}, entries)
+	s.Equal([]string{"dir1/dir2"}, dirs)
+}
+
+func (s *ListWithMetaSuite) TestFallback() {
+	st := &plainStorage{s.mem}
+
+	for _, dir := range []string{"", "dir1", "dir1/dir2", "dir3"} {
+		expectedEntries, expectedDirs, err := s.mem.ListWithMeta(dir)
+		s.Require().Nil(err)
+		entries, dirs, err := stor.ListWithMeta(st, dir)
+		s.Nil(err, dir)
+		s.Equal(expectedEntries, entries, dir)
+		s.Equal(expectedDirs, dirs, dir)
+	}
+
+	entries, dirs, err := stor.ListWithMeta(st, "../dir1")
+	s.True(stor.IsInvalidPathError(err))
+	s.Equal([]stor.Entry{}, entries)
+	s.Equal([]string{}, dirs)
+}
+
+func (s *ListWithMetaSuite) TestFallbackMetaErrors() {
+	entries, dirs, err := stor.ListWithMeta(&vanishingMeta{&plainStorage{s.mem}}, "dir1")
+	s.Nil(err)
+	s.Equal([]stor.Entry{}, entries, "deleted files are skipped")
+	s.Equal([]string{"dir1/dir2"}, dirs)
+
+	_, _, err = stor.ListWithMeta(&failingMeta{&plainStorage{s.mem}}, "dir1")
+	s.EqualError(err, "broken")
+}
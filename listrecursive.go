@@ -0,0 +1,54 @@
+package stor
+
+import (
+	"errors"
+	"io/fs"
+	"sort"
+)
+
+// RecursiveLister can natively list all files within a directory and its subdirectories, e.g.
+// with a single prefix query instead of a listing per directory.
+type RecursiveLister interface {
+	// ListRecursive returns the paths of all files within a directory and its subdirectories,
+	// sorted lexicographically. If there are more than maxEntries files, then a TooLargeError is
+	// returned. A directory that doesn't exist contains no files.
+	// The path argument is a slash-separated path.
+	ListRecursive(path string, maxEntries int) ([]string, error)
+}
+
+// errTooManyEntries stops the walk of ListRecursive.
+var errTooManyEntries = errors.New("too many entries")
+
+// ListRecursive returns the paths of all files within a directory of s and its subdirectories,
+// sorted lexicographically. If there are more than maxEntries files, then a TooLargeError is
+// returned. If s implements RecursiveLister, then the native listing is used. Otherwise every
+// directory is listed, and the listing stops as soon as there are too many files.
+func ListRecursive(s Reader, dirPath string, maxEntries int) ([]string, error) {
+	if recursiveLister, ok := s.(RecursiveLister); ok {
+		return recursiveLister.ListRecursive(dirPath, maxEntries)
+	}
+
+	cleanDir, err := CleanPath(dirPath)
+	if err != nil {
+		return []string{}, err
+	}
+
+	files := []string{}
+	err = walkFiles(s, cleanDir, func(filePath string) error {
+		if len(files) >= maxEntries {
+			return errTooManyEntries
+		}
+		files = append(files, filePath)
+		return nil
+	})
+	if err == errTooManyEntries {
+		return []string{}, &TooLargeError{What: "recursive list of " + cleanDir}
+	}
+	// Backends that can't list a directory that doesn't exist may report an fs.ErrNotExist
+	if err != nil && !IsPathDoesntExistError(err) && !errors.Is(err, fs.ErrNotExist) {
+		return []string{}, err
+	}
+
+	sort.Strings(files)
+	return files, nil
+}
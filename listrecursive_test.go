@@ -0,0 +1,64 @@
+package stor_test
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/suite"
+
+	"github.com/pw1/stor"
+	"github.com/pw1/stor/localdir"
+	"github.com/pw1/stor/memory"
+)
+
+func TestListRecursiveSuite(t *testing.T) {
+	suite.Run(t, new(ListRecursiveSuite))
+}
+
+// ListRecursiveSuite contains the tests for ListRecursive.
+type ListRecursiveSuite struct {
+	suite.Suite
+	mem *memory.Memory
+}
+
+func (s *ListRecursiveSuite) SetupTest() {
+	s.mem, _ = memory.New(&stor.Conf{})
+	for _, filePath := range []string{"file1", "dir1/file2", "dir1/dir2/file3", "dir1.txt"} {
+		s.Require().Nil(s.mem.Save(filePath, []byte("test123")))
+	}
+}
+
+func (s *ListRecursiveSuite) TestNative() {
+	var _ stor.RecursiveLister = s.mem
+
+	files, err := stor.ListRecursive(s.mem, "", 4)
+	s.Nil(err)
+	s.Equal([]string{"dir1.txt", "dir1/dir2/file3", "dir1/file2", "file1"}, files)
+}
+
+func (s *ListRecursiveSuite) TestFallback() {
+	st := &plainStorage{s.mem}
+
+	files, err := stor.ListRecursive(st, "", 4)
+	s.Nil(err)
+	s.Equal([]string{"dir1.txt", "dir1/dir2/file3", "dir1/file2", "file1"}, files)
+
+	files, err = stor.ListRecursive(st, "dir1", 2)
+	s.Nil(err)
+	s.Equal([]string{"dir1/dir2/file3", "dir1/file2"}, files)
+
+	files, err = stor.ListRecursive(st, "dir1", 1)
+	s.EqualError(err, "recursive list of dir1 is too large")
+	s.Equal([]string{}, files)
+
+	_, err = stor.ListRecursive(st, "../dir1", 1)
+	s.True(stor.IsInvalidPathError(err))
+}
+
+func (s *ListRecursiveSuite) TestFallbackMissingDir() {
+	localDir, err := localdir.New(&stor.Conf{Path: s.T().TempDir()})
+	s.Require().Nil(err)
+
+	files, err := stor.ListRecursive(&plainStorage{localDir}, "dir1", 1)
+	s.Nil(err)
+	s.Equal([]string{}, files)
+}
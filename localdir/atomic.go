@@ -0,0 +1,139 @@
+package localdir
+
+import (
+	"math/rand"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	// tempPrefix is the prefix of the names of the temporary files that Save writes before it
+	// renames them into place. List skips these files, and paths with such names are invalid.
+	tempPrefix = ".stor-tmp-"
+
+	// windowsRenameAttempts is the number of attempts to rename a temporary file over an existing
+	// file on Windows, where this fails while another process has the file open.
+	windowsRenameAttempts = 10
+
+	// windowsRenameBackoff is the time between two attempts to rename a temporary file on Windows.
+	windowsRenameBackoff = 10 * time.Millisecond
+)
+
+// isTempName checks whether a file name is the name of a temporary file of Save.
+func isTempName(name string) bool {
+	return strings.HasPrefix(name, tempPrefix)
+}
+
+// writeFileAtomic writes data to a temporary file in the directory of fullPath, syncs it, and then
+// renames it to fullPath. Readers therefore see either the old or the new content, and never a
+// partial file, also not after a crash. The directory is created if it doesn't exist. The
+// temporary file is in the same directory, so the rename never crosses file systems.
+func writeFileAtomic(fullPath string, data []byte) error {
+	return writeAtomic(fullPath, func(file *os.File) error {
+		_, err := file.Write(data)
+		return err
+	})
+}
+
+// createFileAtomic is like writeFileAtomic, but fails with an error for which os.IsExist returns
+// true if fullPath already exists. The temporary file is hard linked to fullPath, which (unlike a
+// rename) never replaces an existing file.
+func createFileAtomic(fullPath string, data []byte) error {
+	return publishAtomic(fullPath, func(file *os.File) error {
+		_, err := file.Write(data)
+		return err
+	}, linkNew)
+}
+
+// writeAtomic is writeFileAtomic, of which write writes the content of the temporary file.
+func writeAtomic(fullPath string, write func(file *os.File) error) error {
+	return publishAtomic(fullPath, write, renameReplace)
+}
+
+// publishAtomic writes and syncs a temporary file in the directory of fullPath, like writeAtomic,
+// and then calls publish to move it to fullPath.
+func publishAtomic(fullPath string, write func(file *os.File) error,
+	publish func(tempPath, fullPath string) error) error {
+
+	file, err := createTempFile(filepath.Dir(fullPath))
+	if err != nil {
+		return err
+	}
+	tempPath := file.Name()
+
+	err = write(file)
+	if err == nil {
+		err = file.Sync()
+	}
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = publish(tempPath, fullPath)
+	}
+	if err != nil {
+		os.Remove(tempPath)
+		return err
+	}
+
+	syncDir(filepath.Dir(fullPath))
+	return nil
+}
+
+// createTempFile creates a new temporary file in dirPath, and creates dirPath if it doesn't exist.
+func createTempFile(dirPath string) (*os.File, error) {
+	for attempt := 0; ; attempt++ {
+		tempPath := filepath.Join(dirPath, tempPrefix+strconv.FormatUint(rand.Uint64(), 36))
+		var file *os.File
+		err := inParentDir(tempPath, func() error {
+			var err error
+			file, err = os.OpenFile(tempPath, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0660)
+			return err
+		})
+		// Retry with another name if the name is taken
+		if os.IsExist(err) && attempt < 10 {
+			continue
+		}
+		return file, err
+	}
+}
+
+// renameReplace renames oldPath to newPath, and replaces newPath if it exists. On Windows this
+// fails while another process (e.g. a concurrent Load) has newPath open, so it is retried a few
+// times.
+func renameReplace(oldPath, newPath string) error {
+	for attempt := 1; ; attempt++ {
+		err := os.Rename(oldPath, newPath)
+		if err == nil || runtime.GOOS != "windows" || !os.IsPermission(err) ||
+			attempt == windowsRenameAttempts {
+			return err
+		}
+		time.Sleep(windowsRenameBackoff)
+	}
+}
+
+// linkNew hard links tempPath to newPath, which fails if newPath exists, and then removes
+// tempPath. The file is complete at that point, so failing to remove tempPath isn't an error: the
+// leftover temporary file isn't listed.
+func linkNew(tempPath, newPath string) error {
+	if err := os.Link(tempPath, newPath); err != nil {
+		return err
+	}
+	os.Remove(tempPath)
+	return nil
+}
+
+// syncDir syncs a directory, so a rename within it survives a crash. Not all platforms (e.g.
+// Windows) support this, so errors are ignored.
+func syncDir(dirPath string) {
+	dir, err := os.Open(dirPath)
+	if err != nil {
+		return
+	}
+	dir.Sync()
+	dir.Close()
+}
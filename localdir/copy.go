@@ -0,0 +1,68 @@
+package localdir
+
+import (
+	"io"
+	"os"
+
+	"github.com/pw1/stor"
+)
+
+// CopyFrom copies a file from another LocalDir, or within the same LocalDir, without loading it
+// into memory. The file is copied into a temporary file that is renamed into place, like for
+// Save. On platforms that support it (e.g. Linux), the file system copies the data itself. It
+// returns false for other Storages, including wrappers around a LocalDir.
+func (l *LocalDir) CopyFrom(src stor.Reader, filePath string) (bool, error) {
+	srcDir, ok := src.(*LocalDir)
+	if !ok {
+		return false, nil
+	}
+
+	srcPath, err := srcDir.getFullPath(filePath)
+	if err != nil {
+		return true, err
+	}
+	fullPath, err := l.getFullPath(filePath)
+	if err != nil {
+		return true, err
+	}
+
+	if l.statCache != nil {
+		defer l.statCache.invalidate(fullPath)
+	}
+	if l.mmap != nil {
+		defer l.mmap.forget(fullPath)
+	}
+
+	srcFile, err := os.Open(srcPath)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return true, &stor.PathDoesntExistError{Path: filePath}
+		}
+		return true, opError(stor.OpCopy, filePath, err)
+	}
+	defer srcFile.Close()
+
+	info, err := srcFile.Stat()
+	if err != nil {
+		return true, opError(stor.OpCopy, filePath, err)
+	}
+	if info.IsDir() {
+		return true, &stor.PathDoesntExistError{Path: filePath}
+	}
+	if srcPath == fullPath {
+		return true, nil
+	}
+
+	if err := l.checkFreeSpace(filePath, info.Size()); err != nil {
+		return true, err
+	}
+
+	err = writeAtomic(fullPath, func(file *os.File) error {
+		_, err := io.Copy(file, srcFile)
+		return err
+	})
+	if err != nil {
+		return true, opError(stor.OpCopy, filePath, err)
+	}
+	return true, nil
+}
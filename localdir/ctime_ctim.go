@@ -0,0 +1,18 @@
+//go:build dragonfly || linux || openbsd
+// +build dragonfly linux openbsd
+
+package localdir
+
+import (
+	"os"
+	"syscall"
+)
+
+// changeTime returns the status change time of a file in nanoseconds, or 0 if it is unknown.
+func changeTime(info os.FileInfo) int64 {
+	st, ok := info.Sys().(*syscall.Stat_t)
+	if !ok {
+		return 0
+	}
+	return st.Ctim.Nano()
+}
@@ -0,0 +1,12 @@
+//go:build !darwin && !dragonfly && !freebsd && !linux && !netbsd && !openbsd
+// +build !darwin,!dragonfly,!freebsd,!linux,!netbsd,!openbsd
+
+package localdir
+
+import "os"
+
+// changeTime returns the status change time of a file in nanoseconds. It is unknown on this
+// platform, so it returns 0.
+func changeTime(info os.FileInfo) int64 {
+	return 0
+}
@@ -0,0 +1,70 @@
+package localdir
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+
+	"github.com/pw1/stor"
+)
+
+// MkDir creates a directory and its parents. The directory remains until it is removed with
+// RemoveDir, or until it is pruned when the last file within it is deleted, unless
+// OptionKeepEmptyDirs is set.
+func (l *LocalDir) MkDir(dirPath string) error {
+	fullPath, err := l.getFullPath(dirPath)
+	if err != nil {
+		return err
+	}
+
+	if l.statCache != nil {
+		defer l.statCache.invalidate(fullPath)
+	}
+
+	if err := os.MkdirAll(fullPath, 0700); err != nil {
+		if info, statErr := os.Stat(fullPath); statErr == nil && !info.IsDir() {
+			return &stor.AlreadyExistsError{Path: dirPath}
+		}
+		return opError(stor.OpMkDir, dirPath, err)
+	}
+	return nil
+}
+
+// RemoveDir removes an empty directory. The directory of the LocalDir itself can't be removed.
+func (l *LocalDir) RemoveDir(dirPath string) error {
+	fullPath, err := l.getFullPath(dirPath)
+	if err != nil {
+		return err
+	}
+	if fullPath == l.BaseDir {
+		msg := fmt.Sprintf("the root directory %v can't be removed", dirPath)
+		return &stor.InvalidPathError{Path: msg}
+	}
+
+	if l.statCache != nil {
+		defer l.statCache.invalidate(fullPath)
+	}
+
+	info, err := os.Stat(fullPath)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return &stor.PathDoesntExistError{Path: dirPath}
+		}
+		return opError(stor.OpRemoveDir, dirPath, err)
+	}
+	if !info.IsDir() {
+		return &stor.PathDoesntExistError{Path: dirPath}
+	}
+
+	if err := os.Remove(fullPath); err != nil {
+		if os.IsNotExist(err) {
+			return &stor.PathDoesntExistError{Path: dirPath}
+		}
+		// The errors for a directory that isn't empty differ per platform, so check the entries
+		if entries, readErr := ioutil.ReadDir(fullPath); readErr == nil && len(entries) > 0 {
+			return &stor.DirNotEmptyError{Path: dirPath}
+		}
+		return opError(stor.OpRemoveDir, dirPath, err)
+	}
+	return nil
+}
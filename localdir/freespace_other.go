@@ -0,0 +1,12 @@
+//go:build !darwin && !dragonfly && !freebsd && !linux
+// +build !darwin,!dragonfly,!freebsd,!linux
+
+package localdir
+
+import "errors"
+
+// diskFree returns the number of bytes that are available to unprivileged users in the file system
+// that contains dirPath. This is not supported on this platform.
+func diskFree(dirPath string) (int64, error) {
+	return 0, errors.New("the free space of the file system is unknown on this platform")
+}
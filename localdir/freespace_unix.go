@@ -0,0 +1,16 @@
+//go:build darwin || dragonfly || freebsd || linux
+// +build darwin dragonfly freebsd linux
+
+package localdir
+
+import "syscall"
+
+// diskFree returns the number of bytes that are available to unprivileged users in the file system
+// that contains dirPath.
+func diskFree(dirPath string) (int64, error) {
+	var st syscall.Statfs_t
+	if err := syscall.Statfs(dirPath, &st); err != nil {
+		return 0, err
+	}
+	return int64(st.Bavail) * int64(st.Bsize), nil
+}
@@ -0,0 +1,73 @@
+//go:build go1.18
+// +build go1.18
+
+package localdir
+
+import (
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"testing"
+
+	"github.com/pw1/stor"
+)
+
+// FuzzStorageRoundTrip verifies that a path is either rejected with an InvalidPathError, or that a
+// file round-trips through Save, List, Load and Delete within the base directory.
+func FuzzStorageRoundTrip(f *testing.F) {
+	for _, seed := range []string{"file1", "dir1/file2", "dir1//file3/", "./dir1/./file4",
+		"../file1", "dir1/../../file1", "/absolute", "c:\\dir1", "CON", "file1.", "a\x00b"} {
+		f.Add(seed)
+	}
+
+	f.Fuzz(func(t *testing.T, filePath string) {
+		// The base directory is within root, so files that escape it end up in root
+		root := t.TempDir()
+		baseDir := filepath.Join(root, "base")
+		if err := os.Mkdir(baseDir, 0700); err != nil {
+			t.Fatal(err)
+		}
+		l, err := New(&stor.Conf{Type: LocalDirStorageType, Path: baseDir})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		cleanPath, err := stor.CleanPath(filePath)
+		if err != nil || cleanPath == "" {
+			if err := l.Save(filePath, []byte("test123")); err == nil {
+				t.Fatalf("Save(%q) succeeded for an invalid path", filePath)
+			}
+			return
+		}
+
+		err = l.Save(filePath, []byte("test123"))
+		if stor.IsInvalidPathError(err) {
+			return
+		}
+		if err != nil {
+			t.Fatalf("Save(%q): %v", filePath, err)
+		}
+
+		if entries, _ := ioutil.ReadDir(root); len(entries) != 1 {
+			t.Fatalf("Save(%q) escaped the base directory", filePath)
+		}
+
+		data, err := l.Load(filePath, 100)
+		if err != nil || string(data) != "test123" {
+			t.Fatalf("Load(%q) returned %q, %v", filePath, data, err)
+		}
+
+		files, _, err := l.List(path.Dir(cleanPath))
+		if err != nil || len(files) != 1 || files[0] != cleanPath {
+			t.Fatalf("List of the directory of %q returned %v, %v", filePath, files, err)
+		}
+
+		if err := l.Delete(filePath); err != nil {
+			t.Fatalf("Delete(%q): %v", filePath, err)
+		}
+		if _, err := l.Load(filePath, 100); !stor.IsPathDoesntExistError(err) {
+			t.Fatalf("Load(%q) after Delete returned %v", filePath, err)
+		}
+	})
+}
@@ -0,0 +1,74 @@
+package localdir
+
+import (
+	"os"
+	"path"
+	"path/filepath"
+	"time"
+
+	"github.com/pw1/stor"
+)
+
+// CollectGarbage finds the temporary files that interrupted Saves left behind, and removes the
+// ones that are older than the MinAge of the options unless DryRun is set. The directories that
+// are empty afterwards are pruned, like after Delete.
+func (l *LocalDir) CollectGarbage(dirPath string, options stor.GCOptions) (*stor.GCReport, error) {
+	cleanDir, err := stor.CleanPath(dirPath)
+	if err != nil {
+		return nil, err
+	}
+	fullPath, err := l.getFullPath(cleanDir)
+	if err != nil {
+		return nil, err
+	}
+
+	// Collect the temporary files first, so removing them and their parents doesn't affect the walk
+	cutoff := options.Cutoff(time.Now())
+	report := &stor.GCReport{Artifacts: []stor.Artifact{}}
+	tempPaths := []string{}
+	err = filepath.Walk(fullPath, func(entryPath string, info os.FileInfo, err error) error {
+		if err != nil {
+			if os.IsNotExist(err) {
+				return nil
+			}
+			return err
+		}
+		if info.IsDir() || !isTempName(info.Name()) || !info.ModTime().Before(cutoff) {
+			return nil
+		}
+
+		relPath, err := filepath.Rel(fullPath, entryPath)
+		if err != nil {
+			return err
+		}
+		report.Artifacts = append(report.Artifacts, stor.Artifact{
+			Path:    path.Join(cleanDir, filepath.ToSlash(relPath)),
+			Size:    info.Size(),
+			ModTime: info.ModTime(),
+		})
+		tempPaths = append(tempPaths, entryPath)
+		return nil
+	})
+	if err != nil {
+		return nil, opError(stor.OpGC, dirPath, err)
+	}
+	if options.DryRun {
+		return report, nil
+	}
+
+	for i, tempPath := range tempPaths {
+		if err := os.Remove(tempPath); err != nil {
+			if os.IsNotExist(err) {
+				continue
+			}
+			return nil, opError(stor.OpGC, report.Artifacts[i].Path, err)
+		}
+		report.Artifacts[i].Removed = true
+
+		if err := l.removeEmptyParents(tempPath); err != nil {
+			return nil, opError(stor.OpGC, report.Artifacts[i].Path, err)
+		}
+	}
+
+	return report, nil
+}
@@ -0,0 +1,67 @@
+package localdir
+
+import (
+	"errors"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+
+	"github.com/pw1/stor"
+)
+
+// errTooManyEntries stops the walk of ListRecursive.
+var errTooManyEntries = errors.New("too many entries")
+
+// ListRecursive returns the paths of all files within a directory and its subdirectories, sorted
+// lexicographically. It walks the directory once, without a stat of every file. If there are more
+// than maxEntries files, then the walk stops, and a stor.TooLargeError is returned.
+func (l *LocalDir) ListRecursive(dirPath string, maxEntries int) ([]string, error) {
+	cleanDir, err := stor.CleanPath(dirPath)
+	if err != nil {
+		return []string{}, err
+	}
+	fullPath, err := l.getFullPath(cleanDir)
+	if err != nil {
+		return []string{}, err
+	}
+
+	files := []string{}
+	err = filepath.WalkDir(fullPath, func(entryPath string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			if os.IsNotExist(err) {
+				return nil
+			}
+			return err
+		}
+		if entryPath == fullPath {
+			if !entry.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if entry.IsDir() || isTempName(entry.Name()) {
+			return nil
+		}
+
+		if len(files) >= maxEntries {
+			return errTooManyEntries
+		}
+		relPath, err := filepath.Rel(fullPath, entryPath)
+		if err != nil {
+			return err
+		}
+		files = append(files, path.Join(cleanDir, filepath.ToSlash(relPath)))
+		return nil
+	})
+	if err == errTooManyEntries {
+		return []string{}, &stor.TooLargeError{What: "recursive list of " + cleanDir}
+	}
+	if err != nil {
+		return []string{}, opError(stor.OpList, dirPath, err)
+	}
+
+	sort.Strings(files)
+	return files, nil
+}
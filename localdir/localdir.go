@@ -1,12 +1,20 @@
 package localdir
 
 import (
+	"encoding/binary"
+	"errors"
 	"fmt"
+	"hash/fnv"
+	"io"
 	"io/ioutil"
+	"math"
 	"os"
 	"path"
 	"path/filepath"
+	"runtime"
+	"strconv"
 	"strings"
+	"time"
 
 	"github.com/pw1/stor"
 )
@@ -14,28 +22,263 @@ import (
 const (
 	// LocalDirStorageType is the storage type of the LocalDir storage.
 	LocalDirStorageType stor.Type = "LocalDir"
+
+	// URLScheme is the scheme of LocalDir storage URLs (see stor.NewFromURL), e.g.
+	// "file:///var/data" for the absolute path /var/data, or "file://data" for a relative path.
+	URLScheme = "file"
+
+	// OptionStatCacheTTL is the stor.Conf option that enables a short-lived cache of file
+	// information. The value is a duration, e.g. "2s". When enabled, List remembers the information
+	// of the listed entries for that duration, and Meta uses it instead of calling stat. Changes
+	// made through the LocalDir invalidate the cache, but changes made by other processes may not be
+	// visible to Meta until the cache expires. With stor.NewFromEnv, the option is read from the
+	// variable STOR_LOCALDIR_STATCACHETTL.
+	OptionStatCacheTTL = "statcachettl"
+
+	// OptionWindowsNames is the stor.Conf option that applies the naming rules of Windows, even
+	// when not running on Windows. This is useful for directories that are shared with Windows
+	// machines, e.g. over SMB. The value is "true" or "false". On Windows the rules always apply.
+	OptionWindowsNames = "windowsnames"
+
+	// OptionMinFreeBytes is the stor.Conf option that keeps a number of bytes free in the file
+	// system. Before Save and Create write a file, they check the available space, and return a
+	// stor.InsufficientSpaceError if the file doesn't fit with this many bytes to spare. This
+	// prevents writes from failing half-way on a full disk. The value is a number of bytes; 0 (the
+	// default) disables the check. It is not supported on all platforms.
+	OptionMinFreeBytes = "minfreebytes"
+
+	// OptionCreateIfMissing is the stor.Conf option that creates the directory (and its parents)
+	// in New if it doesn't exist. The value is "true" or "false". A CreateBaseDirError is returned
+	// if the directory can't be created.
+	OptionCreateIfMissing = "createifmissing"
+
+	// OptionCreateMode is the stor.Conf option with the permissions of the directories that
+	// OptionCreateIfMissing creates, in octal, e.g. "0750". The default is "0700". The umask of
+	// the process applies.
+	OptionCreateMode = "createmode"
+
+	// OptionKeepEmptyDirs is the stor.Conf option that keeps directories when the last file within
+	// them is deleted or renamed. The value is "true" or "false". By default, such directories are
+	// removed, so directories only exist as long as they contain files. Directories created with
+	// MkDir are then also removed with RemoveDir.
+	OptionKeepEmptyDirs = "keepemptydirs"
+
+	// OptionMmapMinSize is the stor.Conf option that memory-maps files on Load instead of reading
+	// them, for files of at least this many bytes. This avoids copying large files that are read
+	// often. The data that Load returns then refers to the mapping: it must not be modified, and
+	// it is only valid until it is passed to Release, or until Close. Repeated Loads of an
+	// unchanged file return the same mapping.
+	//
+	// A mapping of a file that is replaced or deleted stays until all Loads that returned it have
+	// been released (see Release). Without Release, all mappings stay until Close, so the memory
+	// and address space of every version of every file that was loaded is kept. The value is a
+	// number of bytes; 0 (the default) disables it. It is not supported on all platforms; Load
+	// reads files there.
+	OptionMmapMinSize = "mmapminsize"
+)
+
+// defaultCreateMode is the default permission of the directories created by OptionCreateIfMissing.
+const defaultCreateMode os.FileMode = 0700
+
+// CreateBaseDirError is returned by New if the directory of the LocalDir doesn't exist and can't
+// be created (see OptionCreateIfMissing).
+type CreateBaseDirError struct {
+	// Path is the absolute path of the directory.
+	Path string
+
+	// Err is the underlying error.
+	Err error
+}
+
+// ErrCreateBaseDir is matched by a CreateBaseDirError with errors.Is.
+var ErrCreateBaseDir = errors.New("unable to create local dir")
+
+func (e *CreateBaseDirError) Error() string {
+	return fmt.Sprintf("unable to create local dir %v: %v", e.Path, e.Err)
+}
+
+// Is returns true for ErrCreateBaseDir, so errors.Is works for wrapped errors.
+func (e *CreateBaseDirError) Is(target error) bool {
+	return target == ErrCreateBaseDir
+}
+
+// Unwrap returns the underlying error.
+func (e *CreateBaseDirError) Unwrap() error {
+	return e.Err
+}
+
+// IsCreateBaseDirError returns true if an error is, or wraps, a CreateBaseDirError. Returns false
+// otherwise.
+func IsCreateBaseDirError(err error) bool {
+	return errors.Is(err, ErrCreateBaseDir)
+}
+
+const (
+	// maxNameLen is the maximum length in bytes of a file or directory name on most file systems
+	// (ext4, NTFS, APFS).
+	maxNameLen = 255
+
+	// windowsMaxPath is MAX_PATH, the maximum length of a full path on Windows, including the
+	// terminating null character.
+	windowsMaxPath = 260
+
+	// parentDirAttempts is the number of attempts to create a file in a directory that a
+	// concurrent Delete removes while it is empty (see inParentDir), and to remove an empty
+	// directory that concurrent writers use (see removeEmptyDir).
+	parentDirAttempts = 10
 )
 
+// windowsReservedNames are the device names that can't be used as file or directory name on
+// Windows, not even with an extension.
+var windowsReservedNames = map[string]bool{
+	"CON": true, "PRN": true, "AUX": true, "NUL": true,
+	"COM1": true, "COM2": true, "COM3": true, "COM4": true, "COM5": true,
+	"COM6": true, "COM7": true, "COM8": true, "COM9": true,
+	"LPT1": true, "LPT2": true, "LPT3": true, "LPT4": true, "LPT5": true,
+	"LPT6": true, "LPT7": true, "LPT8": true, "LPT9": true,
+}
+
 func init() {
 	newStorageFunc := func(conf *stor.Conf) (stor.Storage, error) {
 		return New(conf)
 	}
 	stor.RegisterType(LocalDirStorageType, newStorageFunc)
+	stor.RegisterValidator(LocalDirStorageType, Validate)
+	stor.RegisterInfo(stor.Info{
+		Type:        LocalDirStorageType,
+		Description: "Directory in the local file system",
+		Path:        "Path of the directory, which must exist unless createifmissing is set",
+		Options: []stor.OptionInfo{
+			{Name: OptionStatCacheTTL, Description: "Duration of the cache of file information " +
+				"(e.g. \"2s\"). Disabled by default."},
+			{Name: OptionWindowsNames, Description: "Apply the naming rules of Windows " +
+				"(\"true\" or \"false\"). Always enabled on Windows."},
+			{Name: OptionMinFreeBytes, Description: "Number of bytes to keep free in the file " +
+				"system. Disabled by default."},
+			{Name: OptionCreateIfMissing, Description: "Create the directory if it doesn't " +
+				"exist (\"true\" or \"false\"). Disabled by default."},
+			{Name: OptionCreateMode, Description: "Permissions of the created directories, in " +
+				"octal. Default: \"0700\"."},
+			{Name: OptionKeepEmptyDirs, Description: "Keep directories without files " +
+				"(\"true\" or \"false\"). Disabled by default."},
+			{Name: OptionMmapMinSize, Description: "Minimum size in bytes of the files that " +
+				"Load memory-maps. Disabled by default."},
+		},
+		Capabilities: []stor.Capability{stor.CapabilityPersistent, stor.CapabilityRename,
+			stor.CapabilityGeneration, stor.CapabilityCreate, stor.CapabilitySortedList,
+			stor.CapabilityDirs, stor.CapabilityStats, stor.CapabilityGC, stor.CapabilityListMeta,
+			stor.CapabilityListRecursive, stor.CapabilityCopy},
+	})
+	stor.RegisterScheme(URLScheme, LocalDirStorageType, nil)
 }
 
 // LocalDir is a Storage object that uses a directory in the local file system as storage backend.
 type LocalDir struct {
 	BaseDir string
+
+	// statCache caches file information of listed directories. It is nil if the cache is disabled.
+	statCache *statCache
+
+	// windowsNames is true if paths are checked against the naming rules of Windows.
+	windowsNames bool
+
+	// minFreeBytes is the number of bytes to keep free in the file system, or 0 to not check it.
+	minFreeBytes int64
+
+	// keepEmptyDirs is true if directories aren't removed when their last file is removed.
+	keepEmptyDirs bool
+
+	// mmap keeps the files that Load memory-maps. It is nil if memory-mapping is disabled.
+	mmap *mmapCache
+
+	// diskFree returns the available space in the file system of a directory. It is replaced in
+	// tests.
+	diskFree func(dirPath string) (int64, error)
+}
+
+// Validate checks a configuration of the LocalDir storage. It doesn't check whether the directory
+// exists; New does that.
+func Validate(conf *stor.Conf) error {
+	if value := conf.Options[OptionStatCacheTTL]; value != "" {
+		ttl, err := time.ParseDuration(value)
+		if err != nil || ttl < 0 {
+			return &stor.ConfError{Type: LocalDirStorageType,
+				Field: "Options[" + OptionStatCacheTTL + "]", Msg: "must be a positive duration"}
+		}
+	}
+	if value := conf.Options[OptionWindowsNames]; value != "" {
+		if _, err := strconv.ParseBool(value); err != nil {
+			return &stor.ConfError{Type: LocalDirStorageType,
+				Field: "Options[" + OptionWindowsNames + "]", Msg: "must be true or false"}
+		}
+	}
+	if value := conf.Options[OptionMinFreeBytes]; value != "" {
+		minFreeBytes, err := strconv.ParseInt(value, 10, 64)
+		if err != nil || minFreeBytes < 0 {
+			return &stor.ConfError{Type: LocalDirStorageType,
+				Field: "Options[" + OptionMinFreeBytes + "]", Msg: "must be a positive number"}
+		}
+	}
+	if value := conf.Options[OptionCreateIfMissing]; value != "" {
+		if _, err := strconv.ParseBool(value); err != nil {
+			return &stor.ConfError{Type: LocalDirStorageType,
+				Field: "Options[" + OptionCreateIfMissing + "]", Msg: "must be true or false"}
+		}
+	}
+	if value := conf.Options[OptionKeepEmptyDirs]; value != "" {
+		if _, err := strconv.ParseBool(value); err != nil {
+			return &stor.ConfError{Type: LocalDirStorageType,
+				Field: "Options[" + OptionKeepEmptyDirs + "]", Msg: "must be true or false"}
+		}
+	}
+	if value := conf.Options[OptionMmapMinSize]; value != "" {
+		minSize, err := strconv.ParseInt(value, 10, 64)
+		if err != nil || minSize < 0 {
+			return &stor.ConfError{Type: LocalDirStorageType,
+				Field: "Options[" + OptionMmapMinSize + "]", Msg: "must be a positive number"}
+		}
+	}
+	if _, err := createMode(conf); err != nil {
+		return &stor.ConfError{Type: LocalDirStorageType,
+			Field: "Options[" + OptionCreateMode + "]", Msg: "must be an octal mode, e.g. 0750"}
+	}
+	return nil
+}
+
+// createMode returns the permissions of the directories created by OptionCreateIfMissing.
+func createMode(conf *stor.Conf) (os.FileMode, error) {
+	value := conf.Options[OptionCreateMode]
+	if value == "" {
+		return defaultCreateMode, nil
+	}
+
+	mode, err := strconv.ParseUint(value, 8, 32)
+	if err != nil || mode > 0777 {
+		return 0, fmt.Errorf("invalid mode %v", value)
+	}
+	return os.FileMode(mode), nil
 }
 
 // New creates a new LocalDir object.
 func New(conf *stor.Conf) (*LocalDir, error) {
+	if err := Validate(conf); err != nil {
+		return nil, err
+	}
+
 	absPath, err := filepath.Abs(conf.Path)
 	if err != nil {
 		return nil, fmt.Errorf("Invalid base dir %v: %v", conf.Path, err)
 	}
 
+	createIfMissing, _ := strconv.ParseBool(conf.Options[OptionCreateIfMissing])
 	info, err := os.Stat(absPath)
+	if err != nil && createIfMissing {
+		mode, _ := createMode(conf)
+		if err := os.MkdirAll(absPath, mode); err != nil {
+			return nil, &CreateBaseDirError{Path: absPath, Err: err}
+		}
+		info, err = os.Stat(absPath)
+	}
 	if err != nil {
 		return nil, fmt.Errorf("Unable to use local dir %v: %v", absPath, err)
 	}
@@ -44,8 +287,34 @@ func New(conf *stor.Conf) (*LocalDir, error) {
 		return nil, fmt.Errorf("Local dir %v is not a directory", absPath)
 	}
 
+	windowsNames, _ := strconv.ParseBool(conf.Options[OptionWindowsNames])
+	minFreeBytes, _ := strconv.ParseInt(conf.Options[OptionMinFreeBytes], 10, 64)
+	keepEmptyDirs, _ := strconv.ParseBool(conf.Options[OptionKeepEmptyDirs])
 	ldir := &LocalDir{
-		BaseDir: absPath,
+		BaseDir:       absPath,
+		windowsNames:  windowsNames || runtime.GOOS == "windows",
+		minFreeBytes:  minFreeBytes,
+		keepEmptyDirs: keepEmptyDirs,
+		diskFree:      diskFree,
+	}
+
+	// Fail early if the free space can't be checked
+	if minFreeBytes > 0 {
+		if _, err := diskFree(absPath); err != nil {
+			return nil, fmt.Errorf("Unable to check the free space of local dir %v: %v", absPath, err)
+		}
+	}
+
+	if value := conf.Options[OptionStatCacheTTL]; value != "" {
+		ttl, _ := time.ParseDuration(value)
+		if ttl > 0 {
+			ldir.statCache = newStatCache(ttl)
+		}
+	}
+
+	mmapMinSize, _ := strconv.ParseInt(conf.Options[OptionMmapMinSize], 10, 64)
+	if mmapMinSize > 0 && mmapSupported {
+		ldir.mmap = newMmapCache(mmapMinSize)
 	}
 
 	return ldir, nil
@@ -73,9 +342,101 @@ func (l *LocalDir) getFullPath(filePath string) (string, error) {
 		return "", &stor.InvalidPathError{Path: msg}
 	}
 
+	if err := checkOSLimits(filePath, fullPath, l.windowsNames); err != nil {
+		return "", err
+	}
+
 	return fullPath, nil
 }
 
+// checkOSLimits checks the cleaned filePath and its fullPath against the limits of the local file
+// system, and against the naming rules of Windows if windows is true. Paths which can't be stored
+// fail with an InvalidPathError instead of an error deep within the file system.
+func checkOSLimits(filePath, fullPath string, windows bool) error {
+	for _, name := range strings.Split(filePath, "/") {
+		if isTempName(name) {
+			msg := fmt.Sprintf("names that start with %s are reserved for temporary files",
+				tempPrefix)
+			return &stor.InvalidPathError{Path: filePath, Msg: msg}
+		}
+		if len(name) > maxNameLen {
+			msg := fmt.Sprintf("name %.16s... is %d bytes long, the local file system allows %d",
+				name, len(name), maxNameLen)
+			return &stor.InvalidPathError{Path: filePath, Msg: msg}
+		}
+		if windows {
+			if err := checkWindowsName(name); err != nil {
+				return &stor.InvalidPathError{Path: filePath, Msg: err.Error()}
+			}
+		}
+	}
+
+	if windows && len(fullPath) >= windowsMaxPath {
+		msg := fmt.Sprintf("full path is %d bytes long, Windows allows %d", len(fullPath),
+			windowsMaxPath-1)
+		return &stor.InvalidPathError{Path: filePath, Msg: msg}
+	}
+
+	return nil
+}
+
+// checkWindowsName returns an error if name can't be used as file or directory name on Windows.
+// CleanPath already rejects all other characters that Windows doesn't allow.
+func checkWindowsName(name string) error {
+	if strings.HasSuffix(name, ".") {
+		return fmt.Errorf("name %v ends with a dot, which Windows doesn't allow", name)
+	}
+
+	base := name
+	if i := strings.IndexByte(base, '.'); i >= 0 {
+		base = base[:i]
+	}
+	if windowsReservedNames[strings.ToUpper(base)] {
+		return fmt.Errorf("name %v is a reserved device name on Windows", name)
+	}
+	return nil
+}
+
+// stat returns the file information of fullPath, from the stat cache if possible.
+func (l *LocalDir) stat(fullPath string) (os.FileInfo, error) {
+	if l.statCache != nil {
+		if info, ok := l.statCache.get(fullPath); ok {
+			if info == nil {
+				return nil, &os.PathError{Op: "stat", Path: fullPath, Err: os.ErrNotExist}
+			}
+			return info, nil
+		}
+	}
+
+	return os.Stat(fullPath)
+}
+
+// Close releases the files that Load memory-mapped (see OptionMmapMinSize). Their data must not be
+// used afterwards. The LocalDir can still be used.
+func (l *LocalDir) Close() error {
+	if l.mmap == nil {
+		return nil
+	}
+	return l.mmap.close()
+}
+
+// Release releases data that Load returned, once it is no longer used. If the data is a mapping
+// of a file that has been replaced or deleted (see OptionMmapMinSize), then the mapping is released
+// when all Loads that returned it have been released. The data must not be used afterwards, and
+// must be released only once per Load. Data that isn't memory-mapped is ignored, so all data of
+// Load can be passed.
+func (l *LocalDir) Release(data []byte) error {
+	if l.mmap == nil {
+		return nil
+	}
+	return l.mmap.release(data)
+}
+
+// Type returns LocalDirStorageType.
+func (l *LocalDir) Type() stor.Type {
+	return LocalDirStorageType
+}
+
 // Meta returns meta information about a file.
 func (l *LocalDir) Meta(filePath string) (*stor.Meta, error) {
 	fullPath, err := l.getFullPath(filePath)
@@ -83,16 +444,17 @@ func (l *LocalDir) Meta(filePath string) (*stor.Meta, error) {
 		return nil, err
 	}
 
-	info, err := os.Stat(fullPath)
+	info, err := l.stat(fullPath)
 	if err != nil {
 		if os.IsNotExist(err) {
 			return nil, &stor.PathDoesntExistError{Path: filePath}
 		}
-		return nil, err
+		return nil, opError(stor.OpMeta, filePath, err)
 	}
 
 	meta := &stor.Meta{
-		Size: info.Size(),
+		Size:    info.Size(),
+		ModTime: info.ModTime(),
 	}
 
 	return meta, nil
@@ -100,12 +462,7 @@ func (l *LocalDir) Meta(filePath string) (*stor.Meta, error) {
 
 // List returns the files and subdirectories within the specified directory.
 func (l *LocalDir) List(filePath string) ([]string, []string, error) {
-	fullPath, err := l.getFullPath(filePath)
-	if err != nil {
-		return []string{}, []string{}, err
-	}
-
-	entries, err := ioutil.ReadDir(fullPath)
+	_, entries, err := l.readDir(filePath)
 	if err != nil {
 		return []string{}, []string{}, err
 	}
@@ -124,45 +481,235 @@ func (l *LocalDir) List(filePath string) ([]string, []string, error) {
 	return files, dirs, nil
 }
 
+// ListIsSorted marks List as sorted (see stor.SortedLister). The directory entries are read in
+// sorted order.
+func (l *LocalDir) ListIsSorted() {}
+
+// ListWithMeta returns the files within the specified directory with their Meta, and the
+// subdirectories. The Meta is taken from the directory entries, so the files aren't stat'ed
+// separately, except for symbolic links: their Meta is the Meta of the target, like for Meta.
+func (l *LocalDir) ListWithMeta(filePath string) ([]stor.Entry, []string, error) {
+	fullPath, entries, err := l.readDir(filePath)
+	if err != nil {
+		return []stor.Entry{}, []string{}, err
+	}
+
+	files := []stor.Entry{}
+	dirs := []string{}
+	for _, entry := range entries {
+		slashPathWithinStorage := path.Join(filePath, entry.Name())
+		if entry.IsDir() {
+			dirs = append(dirs, slashPathWithinStorage)
+			continue
+		}
+
+		info := entry
+		if entry.Mode()&os.ModeSymlink != 0 {
+			info, err = os.Stat(filepath.Join(fullPath, entry.Name()))
+			if os.IsNotExist(err) {
+				continue
+			}
+			if err != nil {
+				return []stor.Entry{}, []string{}, opError(stor.OpList, filePath, err)
+			}
+		}
+		files = append(files, stor.Entry{
+			Path: slashPathWithinStorage,
+			Meta: stor.Meta{Size: info.Size(), ModTime: info.ModTime()},
+		})
+	}
+
+	return files, dirs, nil
+}
+
+// readDir reads the entries of a directory, except for the temporary files of Save, and returns
+// them with the full path of the directory. The entries are put in the stat cache.
+func (l *LocalDir) readDir(filePath string) (string, []os.FileInfo, error) {
+	fullPath, err := l.getFullPath(filePath)
+	if err != nil {
+		return "", nil, err
+	}
+
+	entries, err := ioutil.ReadDir(fullPath)
+	if err != nil {
+		return "", nil, opError(stor.OpList, filePath, err)
+	}
+
+	if l.statCache != nil {
+		l.statCache.put(fullPath, entries)
+	}
+
+	result := entries[:0]
+	for _, entry := range entries {
+		if !isTempName(entry.Name()) {
+			result = append(result, entry)
+		}
+	}
+	return fullPath, result, nil
+}
+
+// Generation returns the generation of a directory, which is a hash of the modification time and
+// the status change time of the directory, and of its number of entries. The file system updates
+// the times whenever an entry is added to or removed from the directory. Save renames a temporary
+// file into place, so overwriting a file changes them too. The status change time can't be set
+// back, and the number of entries changes with every added or removed file, so the generation
+// changes even if the modification time is restored (e.g. by a backup tool) or if the changes are
+// within the same timestamp tick. Overwrites of a file within the same tick, however, may get the
+// same generation on file systems with coarse timestamps (e.g. 2 seconds on FAT). The generation of
+// a directory that doesn't exist is 0.
+func (l *LocalDir) Generation(dirPath string) (uint64, error) {
+	fullPath, err := l.getFullPath(dirPath)
+	if err != nil {
+		return 0, err
+	}
+
+	info, err := os.Stat(fullPath)
+	if os.IsNotExist(err) {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, opError(stor.OpGeneration, dirPath, err)
+	}
+
+	dir, err := os.Open(fullPath)
+	if err != nil {
+		return 0, opError(stor.OpGeneration, dirPath, err)
+	}
+	names, err := dir.Readdirnames(-1)
+	dir.Close()
+	if err != nil {
+		return 0, opError(stor.OpGeneration, dirPath, err)
+	}
+
+	hash := fnv.New64a()
+	var buf [8]byte
+	for _, value := range []int64{info.ModTime().UnixNano(), changeTime(info), int64(len(names))} {
+		binary.BigEndian.PutUint64(buf[:], uint64(value))
+		hash.Write(buf[:])
+	}
+	if gen := hash.Sum64(); gen != 0 {
+		return gen, nil
+	}
+	// 0 is the generation of a directory that doesn't exist
+	return 1, nil
+}
+
 // Load loads the content of the specified file. If the file is larger than maxSize, the an error is
-// returned.
+// returned. Files of at least OptionMmapMinSize bytes are memory-mapped if that option is set.
 func (l *LocalDir) Load(filePath string, maxSize int64) ([]byte, error) {
 	fullPath, err := l.getFullPath(filePath)
 	if err != nil {
 		return []byte{}, err
 	}
 
-	info, err := os.Stat(fullPath)
+	file, err := os.Open(fullPath)
 	if err != nil {
 		if os.IsNotExist(err) {
 			return []byte{}, &stor.PathDoesntExistError{Path: filePath}
 		}
-		return []byte{}, err
+		return []byte{}, opError(stor.OpLoad, filePath, err)
+	}
+	defer file.Close()
+
+	// Save replaces files instead of writing them in place, so the size of the open file doesn't
+	// change while it is read. That allows reading it into a single buffer of exactly its size.
+	info, err := file.Stat()
+	if err != nil {
+		return []byte{}, opError(stor.OpLoad, filePath, err)
 	}
 
 	if info.Size() > maxSize {
 		return []byte{}, &stor.TooLargeError{What: filePath}
 	}
 
-	return ioutil.ReadFile(fullPath)
+	if l.mmap != nil && info.Size() >= l.mmap.minSize {
+		data, err := l.mmap.load(fullPath, file, info)
+		if err != nil {
+			return []byte{}, opError(stor.OpLoad, filePath, err)
+		}
+		return data, nil
+	}
+
+	data := make([]byte, info.Size())
+	if _, err := io.ReadFull(file, data); err != nil {
+		return []byte{}, opError(stor.OpLoad, filePath, err)
+	}
+	return data, nil
 }
 
-// Save saves the data to the specified file.
+// Save saves the data to the specified file. The data is written to a temporary file first, which
+// then replaces the file, so readers never see partial content.
 func (l *LocalDir) Save(filePath string, data []byte) error {
 	fullPath, err := l.getFullPath(filePath)
 	if err != nil {
 		return err
 	}
 
-	// Make sure that the parent directory exists
-	dirPath := filepath.Dir(fullPath)
-	err = os.MkdirAll(dirPath, 0700)
+	if l.statCache != nil {
+		defer l.statCache.invalidate(fullPath)
+	}
+	if l.mmap != nil {
+		defer l.mmap.forget(fullPath)
+	}
+
+	if err := l.checkFreeSpace(filePath, int64(len(data))); err != nil {
+		return err
+	}
+
+	if err := writeFileAtomic(fullPath, data); err != nil {
+		return opError(stor.OpSave, filePath, err)
+	}
+	return nil
+}
+
+// checkFreeSpace returns an InsufficientSpaceError if size bytes don't fit in the file system
+// with minFreeBytes to spare.
+func (l *LocalDir) checkFreeSpace(filePath string, size int64) error {
+	if l.minFreeBytes <= 0 {
+		return nil
+	}
 
-	err = ioutil.WriteFile(fullPath, data, 0660)
+	available, err := l.diskFree(l.BaseDir)
+	if err != nil {
+		return opError(stor.OpSave, filePath, err)
+	}
+	required := int64(math.MaxInt64)
+	if size <= math.MaxInt64-l.minFreeBytes {
+		required = size + l.minFreeBytes
+	}
+	if available < required {
+		return &stor.InsufficientSpaceError{Path: filePath, Required: required, Available: available}
+	}
+	return nil
+}
+
+// Create saves the data to the specified file, if it doesn't exist yet. Like Save, it writes and
+// syncs a temporary file first, so readers never see a partial file. The temporary file is then
+// hard linked into place, which fails if the file exists, so concurrent creates (also by other
+// processes) are safe on local file systems.
+func (l *LocalDir) Create(filePath string, data []byte) error {
+	fullPath, err := l.getFullPath(filePath)
 	if err != nil {
 		return err
 	}
 
+	if l.statCache != nil {
+		defer l.statCache.invalidate(fullPath)
+	}
+	if l.mmap != nil {
+		defer l.mmap.forget(fullPath)
+	}
+
+	if err := l.checkFreeSpace(filePath, int64(len(data))); err != nil {
+		return err
+	}
+
+	if err := createFileAtomic(fullPath, data); err != nil {
+		if os.IsExist(err) {
+			return &stor.AlreadyExistsError{Path: filePath}
+		}
+		return opError(stor.OpSave, filePath, err)
+	}
 	return nil
 }
 
@@ -173,39 +720,188 @@ func (l *LocalDir) Delete(filePath string) error {
 		return err
 	}
 
+	if l.statCache != nil {
+		defer l.statCache.invalidate(fullPath)
+	}
+	if l.mmap != nil {
+		defer l.mmap.forget(fullPath)
+	}
+
 	err = os.Remove(fullPath)
 	if err != nil {
 		if os.IsNotExist(err) {
 			return &stor.PathDoesntExistError{Path: filePath}
 		}
+		return opError(stor.OpDelete, filePath, err)
+	}
+
+	if err := l.removeEmptyParents(fullPath); err != nil {
+		return opError(stor.OpDelete, filePath, err)
+	}
+	return nil
+}
+
+// removeEmptyParents removes the empty parent directories of fullPath, from the deepest up to (but
+// not including) the BaseDir. It does nothing if OptionKeepEmptyDirs is set. A directory is only
+// removed while it is empty, so a concurrent writer that saves a file in it (or recreates it) is
+// never affected; the removal then stops without an error. Other errors are returned.
+func (l *LocalDir) removeEmptyParents(fullPath string) error {
+	if l.keepEmptyDirs {
+		return nil
+	}
+
+	relPath, err := filepath.Rel(l.BaseDir, filepath.Dir(fullPath))
+	if err != nil {
 		return err
 	}
+	if relPath == "." || escapesDir(filepath.Dir(fullPath), l.BaseDir) {
+		return nil
+	}
 
-	// Remove all empty parent directories (until we reach the basedir)
-	parentDir := fullPath
-	for i := 0; true; i++ {
-		if i > 1000 {
-			return fmt.Errorf("Infinite loop in LocalDir.Delete()")
+	parentDir := filepath.Dir(fullPath)
+	depth := strings.Count(relPath, string(filepath.Separator)) + 1
+	for i := 0; i < depth; i++ {
+		empty, err := removeEmptyDir(parentDir)
+		if err != nil {
+			return err
 		}
-
+		if !empty {
+			return nil
+		}
+		// A directory that doesn't exist was removed by a concurrent Delete, which may have stopped
+		// at its parent before this file was removed. So continue with the parent either way.
 		parentDir = filepath.Dir(parentDir)
-		if escapesDir(parentDir, l.BaseDir) || (parentDir == l.BaseDir) {
-			break
+	}
+
+	return nil
+}
+
+// removeEmptyDir removes fullPath if it is an empty directory. It returns false if the directory
+// isn't empty, and true if it is removed or doesn't exist.
+func removeEmptyDir(fullPath string) (bool, error) {
+	for attempt := 1; ; attempt++ {
+		err := os.Remove(fullPath)
+		if err == nil || os.IsNotExist(err) {
+			return true, nil
+		}
+
+		// The errors for a directory that isn't empty differ per platform, so check the entries.
+		// Concurrent writers may have emptied the directory again in the meantime, so then retry.
+		entries, readErr := ioutil.ReadDir(fullPath)
+		if os.IsNotExist(readErr) {
+			return true, nil
+		}
+		if readErr == nil && len(entries) > 0 {
+			return false, nil
 		}
+		if attempt == parentDirAttempts {
+			return false, err
+		}
+	}
+}
+
+// Rename moves all files within the directory oldPath to the directory newPath. The files are
+// moved one by one with os.Rename, so files that already exist in newPath are overwritten. With
+// OptionKeepEmptyDirs, the empty directories within oldPath are recreated in newPath. Files that
+// are saved into oldPath during the Rename may be left behind in oldPath.
+func (l *LocalDir) Rename(oldPath, newPath string) error {
+	oldFullPath, err := l.getFullPath(oldPath)
+	if err != nil {
+		return err
+	}
 
-		entries, err := ioutil.ReadDir(parentDir)
+	newFullPath, err := l.getFullPath(newPath)
+	if err != nil {
+		return err
+	}
+
+	// The old directory is removed afterwards, so the target can't be within it (or vice versa)
+	if !escapesDir(newFullPath, oldFullPath) || !escapesDir(oldFullPath, newFullPath) {
+		return &stor.InvalidPathError{Path: newPath, Msg: "the paths are nested"}
+	}
+
+	if l.statCache != nil {
+		defer l.statCache.clear()
+	}
+
+	// Collect the files first, so files that are moved aren't visited again
+	files := []string{}
+	dirs := []string{}
+	err = filepath.Walk(oldFullPath, func(filePath string, info os.FileInfo, err error) error {
 		if err != nil {
 			return err
 		}
-		if len(entries) > 0 {
-			break
+		if info.IsDir() {
+			dirs = append(dirs, filePath)
+		} else if !isTempName(info.Name()) {
+			files = append(files, filePath)
 		}
-		os.Remove(parentDir)
+		return nil
+	})
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return opError(stor.OpRename, oldPath, err)
 	}
 
+	for _, filePath := range files {
+		target := filepath.Join(newFullPath, filePath[len(oldFullPath):])
+		err := inParentDir(target, func() error {
+			return os.Rename(filePath, target)
+		})
+		if err != nil {
+			return opError(stor.OpRename, oldPath, err)
+		}
+		if l.mmap != nil {
+			l.mmap.forget(filePath)
+			l.mmap.forget(target)
+		}
+	}
+
+	if l.keepEmptyDirs {
+		for _, dirPath := range dirs {
+			target := filepath.Join(newFullPath, dirPath[len(oldFullPath):])
+			if err := os.MkdirAll(target, 0700); err != nil {
+				return opError(stor.OpRename, oldPath, err)
+			}
+		}
+	}
+
+	// Remove the directories that are empty now, deepest first. Directories with files that were
+	// saved after the walk, or with temporary files of Saves in progress, are left alone.
+	for i := len(dirs) - 1; i >= 0; i-- {
+		if _, err := removeEmptyDir(dirs[i]); err != nil {
+			return opError(stor.OpRename, oldPath, err)
+		}
+	}
+	if err := l.removeEmptyParents(oldFullPath); err != nil {
+		return opError(stor.OpRename, oldPath, err)
+	}
 	return nil
 }
 
+// inParentDir creates the parent directory of fullPath if it doesn't exist, and then calls fn to
+// create fullPath. A concurrent Delete may remove the new (empty) directories before fn creates
+// fullPath in them, or even while MkdirAll creates them, so both are retried as long as they fail
+// because something doesn't exist.
+func inParentDir(fullPath string, fn func() error) error {
+	for attempt := 1; ; attempt++ {
+		err := os.MkdirAll(filepath.Dir(fullPath), 0700)
+		if err == nil {
+			err = fn()
+		}
+		if !os.IsNotExist(err) || attempt == parentDirAttempts {
+			return err
+		}
+	}
+}
+
+// opError wraps an error of the file system in a stor.OpError.
+func opError(op, filePath string, err error) error {
+	return &stor.OpError{Op: op, Backend: LocalDirStorageType, Path: filePath, Err: err}
+}
+
 // escapesDir checks whether a path escapes a certain baseDir directory.
 // Return true if path is not within the baseDir. Returns false if path is within the baseDir, or
 // equal to baseDir.
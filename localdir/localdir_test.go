@@ -1,14 +1,20 @@
 package localdir
 
 import (
+	"errors"
+	"fmt"
 	"io/ioutil"
 	"os"
 	"path/filepath"
+	"runtime"
+	"strings"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/suite"
 
 	"github.com/pw1/stor"
+	"github.com/pw1/stor/memory"
 	"github.com/pw1/stor/tester"
 )
 
@@ -32,6 +38,109 @@ func TestLocalDirWithStorageTester(t *testing.T) {
 		ConfFactory:       myConfFactory,
 		SetupTestFunc:     func(s *tester.StorageTester) { cleanDir(t, tempDir) },
 		TearDownSuiteFunc: func(s *tester.StorageTester) { os.RemoveAll(tempDir) },
+		ReferenceFactory: func() stor.Storage {
+			m, _ := memory.New(&stor.Conf{})
+			return m
+		},
+	}
+	if !testing.Short() {
+		testSuite.LargeFileSize = 256 << 20
+		testSuite.SoakOps = 4000
+	}
+	suite.Run(t, testSuite)
+}
+
+// Call the generic storage tests with fixtures that are hard for file systems
+func TestLocalDirFixturesWithStorageTester(t *testing.T) {
+	fixtures := map[string]tester.Fixture{
+		"deep":     tester.DeepFixture(20),
+		"empty":    tester.EmptyFilesFixture(),
+		"longpath": tester.LongPathFixture(),
+		"unusual":  tester.UnusualNamesFixture(),
+	}
+
+	for name, fixture := range fixtures {
+		testSuite := &tester.StorageTester{
+			SetupTestFunc: func(s *tester.StorageTester) {
+				l, err := New(&stor.Conf{Type: LocalDirStorageType, Path: s.T().TempDir()})
+				s.Require().Nil(err)
+				s.Storage = l
+			},
+			Fixture: fixture,
+		}
+		t.Run(name, func(t *testing.T) {
+			suite.Run(t, testSuite)
+		})
+	}
+}
+
+// Call the generic storage tests with the stat cache enabled
+func TestLocalDirStatCacheWithStorageTester(t *testing.T) {
+	tempDir, err := ioutil.TempDir("", "TestLocalDirStatCache")
+	if err != nil {
+		t.FailNow()
+	}
+
+	myConfFactory := func() *stor.Conf {
+		return &stor.Conf{
+			Type:    LocalDirStorageType,
+			Path:    tempDir,
+			Options: map[string]string{OptionStatCacheTTL: "1m"},
+		}
+	}
+
+	testSuite := &tester.StorageTester{
+		ConfFactory:       myConfFactory,
+		SetupTestFunc:     func(s *tester.StorageTester) { cleanDir(t, tempDir) },
+		TearDownSuiteFunc: func(s *tester.StorageTester) { os.RemoveAll(tempDir) },
+	}
+	suite.Run(t, testSuite)
+}
+
+// Call the generic storage tests with empty directories kept
+func TestLocalDirKeepEmptyDirsWithStorageTester(t *testing.T) {
+	tempDir, err := ioutil.TempDir("", "TestLocalDirKeepEmptyDirs")
+	if err != nil {
+		t.FailNow()
+	}
+
+	myConfFactory := func() *stor.Conf {
+		return &stor.Conf{
+			Type:    LocalDirStorageType,
+			Path:    tempDir,
+			Options: map[string]string{OptionKeepEmptyDirs: "true"},
+		}
+	}
+
+	testSuite := &tester.StorageTester{
+		ConfFactory:       myConfFactory,
+		SetupTestFunc:     func(s *tester.StorageTester) { cleanDir(t, tempDir) },
+		TearDownSuiteFunc: func(s *tester.StorageTester) { os.RemoveAll(tempDir) },
+		KeepEmptyDirs:     true,
+	}
+	suite.Run(t, testSuite)
+}
+
+// Call the generic storage tests with all files memory-mapped
+func TestLocalDirMmapWithStorageTester(t *testing.T) {
+	tempDir, err := ioutil.TempDir("", "TestLocalDirMmap")
+	if err != nil {
+		t.FailNow()
+	}
+
+	myConfFactory := func() *stor.Conf {
+		return &stor.Conf{
+			Type:    LocalDirStorageType,
+			Path:    tempDir,
+			Options: map[string]string{OptionMmapMinSize: "1"},
+		}
+	}
+
+	testSuite := &tester.StorageTester{
+		ConfFactory:       myConfFactory,
+		SetupTestFunc:     func(s *tester.StorageTester) { cleanDir(t, tempDir) },
+		TearDownTestFunc:  func(s *tester.StorageTester) { stor.Close(s.Storage) },
+		TearDownSuiteFunc: func(s *tester.StorageTester) { os.RemoveAll(tempDir) },
 	}
 	suite.Run(t, testSuite)
 }
@@ -145,3 +254,827 @@ func (s *LocalDirSuite) TestNewLocalDirFileBase() {
 	s.NotNil(err)
 	s.Nil(localDir)
 }
+
+func (s *LocalDirSuite) TestRename() {
+	testDir, err := makeTestDir(s.tempDir)
+	s.Nil(err)
+
+	localDir, err := New(&stor.Conf{Type: LocalDirStorageType, Path: testDir})
+	s.Nil(err)
+	s.Nil(localDir.Save("dir1/file1", []byte("test123")))
+	s.Nil(localDir.Save("dir1/dir2/file2", []byte("test456")))
+	s.Nil(localDir.Save("dir3/file3", []byte("test789")))
+
+	s.Nil(localDir.Rename("dir1", "dir3/dir4"))
+
+	data, err := localDir.Load("dir3/dir4/dir2/file2", 1e6)
+	s.Nil(err)
+	s.Equal([]byte("test456"), data)
+
+	_, err = os.Stat(filepath.Join(testDir, "dir1"))
+	s.True(os.IsNotExist(err))
+
+	s.Nil(localDir.Rename("nope", "dir5"))
+}
+
+func (s *LocalDirSuite) TestRenameKeepsTempFiles() {
+	testDir, err := makeTestDir(s.tempDir)
+	s.Require().Nil(err)
+	localDir, err := New(&stor.Conf{Type: LocalDirStorageType, Path: testDir})
+	s.Require().Nil(err)
+
+	s.Nil(localDir.Save("dir1/file1", []byte("test123")))
+	s.Nil(localDir.Save("dir1/dir2/file2", []byte("test456")))
+	s.Nil(localDir.Save("dir1/dir3/file3", []byte("test789")))
+
+	// The temporary file of a Save in progress is not moved, and not removed
+	tempPath := filepath.Join(testDir, "dir1", "dir2", tempPrefix+"123")
+	s.Require().Nil(ioutil.WriteFile(tempPath, []byte("tes"), 0600))
+
+	s.Nil(localDir.Rename("dir1", "dir4"))
+
+	files, err := stor.ListRecursive(localDir, "", 10)
+	s.Nil(err)
+	s.Equal([]string{"dir4/dir2/file2", "dir4/dir3/file3", "dir4/file1"}, files)
+
+	data, err := ioutil.ReadFile(tempPath)
+	s.Nil(err)
+	s.Equal("tes", string(data))
+	_, err = os.Stat(filepath.Join(testDir, "dir1", "dir3"))
+	s.True(os.IsNotExist(err), "the empty directories are removed")
+}
+
+func (s *LocalDirSuite) TestRenameNested() {
+	testDir, err := makeTestDir(s.tempDir)
+	s.Nil(err)
+
+	localDir, err := New(&stor.Conf{Type: LocalDirStorageType, Path: testDir})
+	s.Nil(err)
+	s.Nil(localDir.Save("dir1/file1", []byte("test123")))
+
+	s.True(stor.IsInvalidPathError(localDir.Rename("dir1", "dir1/dir2")))
+	s.True(stor.IsInvalidPathError(localDir.Rename("dir1", "")))
+
+	data, err := localDir.Load("dir1/file1", 1e6)
+	s.Nil(err)
+	s.Equal([]byte("test123"), data)
+}
+
+func (s *LocalDirSuite) TestNewLocalDirInvalidStatCacheTTL() {
+	stConf := &stor.Conf{
+		Type:    LocalDirStorageType,
+		Path:    s.tempDir,
+		Options: map[string]string{OptionStatCacheTTL: "forever"},
+	}
+
+	localDir, err := New(stConf)
+	s.True(stor.IsConfError(err))
+	s.Nil(localDir)
+
+	err = stor.Validate(stConf)
+	s.Equal(&stor.ConfError{
+		Type:  LocalDirStorageType,
+		Field: "Options[statcachettl]",
+		Msg:   "must be a positive duration",
+	}, err)
+}
+
+// TestStatCache verifies that Meta uses the file information that was collected by List.
+func (s *LocalDirSuite) TestStatCache() {
+	testDir, err := makeTestDir(s.tempDir)
+	s.Nil(err)
+
+	localDir, err := New(&stor.Conf{
+		Type:    LocalDirStorageType,
+		Path:    testDir,
+		Options: map[string]string{OptionStatCacheTTL: "100ms"},
+	})
+	s.Nil(err)
+	s.Nil(localDir.Save("dir1/file1", []byte("test123")))
+
+	_, _, err = localDir.List("dir1")
+	s.Nil(err)
+
+	// Changes by other processes are not visible until the cache expires
+	s.Nil(ioutil.WriteFile(filepath.Join(testDir, "dir1", "file1"), []byte("test"), 0600))
+	s.Nil(ioutil.WriteFile(filepath.Join(testDir, "dir1", "file2"), []byte("test"), 0600))
+
+	meta, err := localDir.Meta("dir1/file1")
+	s.Nil(err)
+	s.Equal(int64(7), meta.Size)
+
+	_, err = localDir.Meta("dir1/file2")
+	s.True(stor.IsPathDoesntExistError(err))
+
+	time.Sleep(150 * time.Millisecond)
+
+	meta, err = localDir.Meta("dir1/file1")
+	s.Nil(err)
+	s.Equal(int64(4), meta.Size)
+}
+
+// TestStatCacheInvalidate verifies that changes through the LocalDir invalidate the stat cache.
+func (s *LocalDirSuite) TestStatCacheInvalidate() {
+	testDir, err := makeTestDir(s.tempDir)
+	s.Nil(err)
+
+	localDir, err := New(&stor.Conf{
+		Type:    LocalDirStorageType,
+		Path:    testDir,
+		Options: map[string]string{OptionStatCacheTTL: "1m"},
+	})
+	s.Nil(err)
+	s.Nil(localDir.Save("dir1/file1", []byte("test123")))
+
+	_, _, err = localDir.List("dir1")
+	s.Nil(err)
+
+	s.Nil(localDir.Save("dir1/file1", []byte("test")))
+	meta, err := localDir.Meta("dir1/file1")
+	s.Nil(err)
+	s.Equal(int64(4), meta.Size)
+
+	_, _, err = localDir.List("dir1")
+	s.Nil(err)
+
+	s.Nil(localDir.Delete("dir1/file1"))
+	_, err = localDir.Meta("dir1/file1")
+	s.True(stor.IsPathDoesntExistError(err))
+}
+
+// TestStatCacheSymlinks verifies that Meta reports the target of a symbolic link, whether or not its
+// directory is in the stat cache.
+func (s *LocalDirSuite) TestStatCacheSymlinks() {
+	if runtime.GOOS == "windows" {
+		s.T().Skip("symbolic links require privileges on Windows")
+	}
+	testDir, err := makeTestDir(s.tempDir)
+	s.Require().Nil(err)
+	localDir, err := New(&stor.Conf{
+		Type:    LocalDirStorageType,
+		Path:    testDir,
+		Options: map[string]string{OptionStatCacheTTL: "1m"},
+	})
+	s.Require().Nil(err)
+
+	s.Nil(localDir.Save("dir1/file1", []byte("test123")))
+	s.Require().Nil(os.Symlink("file1", filepath.Join(testDir, "dir1", "link")))
+	s.Require().Nil(os.Symlink("missing", filepath.Join(testDir, "dir1", "dangling")))
+
+	uncached, err := localDir.Meta("dir1/link")
+	s.Require().Nil(err)
+
+	_, _, err = localDir.List("dir1")
+	s.Require().Nil(err)
+
+	cached, err := localDir.Meta("dir1/link")
+	s.Require().Nil(err)
+	s.Equal(*uncached, *cached)
+	s.Equal(int64(7), cached.Size)
+
+	_, err = localDir.Meta("dir1/dangling")
+	s.True(stor.IsPathDoesntExistError(err))
+}
+
+func (s *LocalDirSuite) TestGeneration() {
+	testDir, err := makeTestDir(s.tempDir)
+	s.Nil(err)
+
+	localDir, err := New(&stor.Conf{Type: LocalDirStorageType, Path: testDir})
+	s.Nil(err)
+
+	gen, err := localDir.Generation("dir1")
+	s.Nil(err)
+	s.Equal(uint64(0), gen)
+
+	s.Nil(localDir.Save("dir1/file1", []byte("test123")))
+
+	// Use an old modification time, so the timestamp granularity doesn't matter
+	past := time.Now().Add(-time.Hour)
+	s.Nil(os.Chtimes(filepath.Join(testDir, "dir1"), past, past))
+	gen1, err := localDir.Generation("dir1")
+	s.Nil(err)
+	s.NotEqual(uint64(0), gen1)
+
+	// Save renames a temporary file into place, so overwriting a file changes the generation too
+	s.Nil(localDir.Save("dir1/file1", []byte("test456")))
+	gen2, err := localDir.Generation("dir1")
+	s.Nil(err)
+	s.NotEqual(gen1, gen2)
+
+	s.Nil(os.Chtimes(filepath.Join(testDir, "dir1"), past, past))
+	s.Nil(localDir.Save("dir1/file2", []byte("test789")))
+	gen3, err := localDir.Generation("dir1")
+	s.Nil(err)
+	s.NotEqual(gen1, gen3)
+
+	// Restoring the modification time doesn't restore the generation
+	s.Nil(os.Chtimes(filepath.Join(testDir, "dir1"), past, past))
+	gen4, err := localDir.Generation("dir1")
+	s.Nil(err)
+	s.NotEqual(gen1, gen4)
+
+	_, err = localDir.Generation("../escape")
+	s.True(stor.IsInvalidPathError(err))
+}
+
+func (s *LocalDirSuite) TestOpError() {
+	testDir, err := makeTestDir(s.tempDir)
+	s.Nil(err)
+
+	localDir, err := New(&stor.Conf{Type: LocalDirStorageType, Path: testDir})
+	s.Nil(err)
+
+	_, _, err = localDir.List("missing")
+	var opErr *stor.OpError
+	s.Require().True(errors.As(err, &opErr))
+	s.Equal(stor.OpList, opErr.Op)
+	s.Equal(LocalDirStorageType, opErr.Backend)
+	s.Equal("missing", opErr.Path)
+	s.True(errors.Is(err, os.ErrNotExist), "the underlying error is exposed")
+
+	// A file where a directory is expected
+	s.Nil(localDir.Save("file1", []byte("test")))
+	err = localDir.Save("file1/file2", []byte("test"))
+	s.Require().True(errors.As(err, &opErr))
+	s.Equal(stor.OpSave, opErr.Op)
+	s.Equal("file1/file2", opErr.Path)
+
+	// Typed errors are not wrapped
+	_, err = localDir.Load("missing", 100)
+	s.IsType(&stor.PathDoesntExistError{}, err)
+}
+
+func (s *LocalDirSuite) TestDeletePrunesParents() {
+	testDir, err := makeTestDir(s.tempDir)
+	s.Require().Nil(err)
+	localDir, err := New(&stor.Conf{Type: LocalDirStorageType, Path: testDir})
+	s.Require().Nil(err)
+
+	s.Nil(localDir.Save("dir1/file1", []byte("test123")))
+	s.Nil(localDir.Save("dir1/dir2/dir3/dir4/file2", []byte("test123")))
+	s.Nil(localDir.Delete("dir1/dir2/dir3/dir4/file2"))
+
+	// The pruning stops at the first directory that isn't empty
+	_, err = os.Stat(filepath.Join(testDir, "dir1", "dir2"))
+	s.True(os.IsNotExist(err))
+	_, dirs, err := localDir.List("")
+	s.Nil(err)
+	s.Equal([]string{"dir1"}, dirs)
+
+	// The BaseDir itself is never removed
+	s.Nil(localDir.Delete("dir1/file1"))
+	info, err := os.Stat(testDir)
+	s.Require().Nil(err)
+	s.True(info.IsDir())
+	_, dirs, err = localDir.List("")
+	s.Nil(err)
+	s.Equal([]string{}, dirs)
+}
+
+func (s *LocalDirSuite) TestDeletePruneRace() {
+	testDir, err := makeTestDir(s.tempDir)
+	s.Require().Nil(err)
+	localDir, err := New(&stor.Conf{Type: LocalDirStorageType, Path: testDir})
+	s.Require().Nil(err)
+
+	// Writers keep recreating the directories that the Deletes of other goroutines prune. Neither
+	// side may fail because of the other.
+	const workers = 8
+	const iterations = 200
+	errs := make(chan error, workers)
+	for worker := 0; worker < workers; worker++ {
+		go func(worker int) {
+			for i := 0; i < iterations; i++ {
+				filePath := fmt.Sprintf("dir1/dir2/file%d", worker)
+				var err error
+				switch i % 3 {
+				case 0:
+					err = localDir.Save(filePath, []byte("test123"))
+				case 1:
+					err = localDir.Create(filePath+"-created", []byte("test123"))
+					if err == nil {
+						err = localDir.Delete(filePath + "-created")
+					}
+				case 2:
+					err = localDir.Delete(filePath)
+				}
+				if err != nil {
+					errs <- fmt.Errorf("worker %d, iteration %d: %v", worker, i, err)
+					return
+				}
+			}
+			errs <- nil
+		}(worker)
+	}
+	for worker := 0; worker < workers; worker++ {
+		s.Nil(<-errs)
+	}
+
+	// The last Save of every worker remains
+	for worker := 0; worker < workers; worker++ {
+		s.Nil(localDir.Delete(fmt.Sprintf("dir1/dir2/file%d", worker)))
+	}
+	_, dirs, err := localDir.List("")
+	s.Nil(err)
+	s.Equal([]string{}, dirs)
+}
+
+func (s *LocalDirSuite) TestCreateConcurrent() {
+	testDir, err := makeTestDir(s.tempDir)
+	s.Nil(err)
+
+	localDir, err := New(&stor.Conf{Type: LocalDirStorageType, Path: testDir})
+	s.Nil(err)
+
+	const n = 10
+	results := make(chan error, n)
+	for i := 0; i < n; i++ {
+		go func(i int) {
+			results <- localDir.Create("dir1/lock", []byte{byte('0' + i)})
+		}(i)
+	}
+
+	created := 0
+	for i := 0; i < n; i++ {
+		err := <-results
+		if err == nil {
+			created++
+		} else {
+			s.True(stor.IsAlreadyExistsError(err), "unexpected error: %v", err)
+		}
+	}
+	s.Equal(1, created)
+
+	meta, err := localDir.Meta("dir1/lock")
+	s.Nil(err)
+	s.Equal(int64(1), meta.Size)
+
+	// The temporary files of the Creates are removed, also of those that failed
+	entries, err := ioutil.ReadDir(filepath.Join(testDir, "dir1"))
+	s.Nil(err)
+	s.Len(entries, 1)
+}
+
+func (s *LocalDirSuite) TestOSLimits() {
+	defer func(maxComponentLen int) { stor.MaxComponentLen = maxComponentLen }(stor.MaxComponentLen)
+	stor.MaxComponentLen = 0
+
+	localDir, err := New(&stor.Conf{Type: LocalDirStorageType, Path: s.tempDir})
+	s.Require().Nil(err)
+
+	// The name limit of the file system applies, even if CleanPath doesn't check it
+	err = localDir.Save("dir1/"+strings.Repeat("a", maxNameLen+1), []byte("test123"))
+	s.True(stor.IsInvalidPathError(err))
+	s.Nil(localDir.Save("dir1/"+strings.Repeat("a", maxNameLen), []byte("test123")))
+
+	long := "C:\\base\\" + strings.Repeat("a", windowsMaxPath)
+	s.Nil(checkOSLimits("file1", long, false))
+	s.True(stor.IsInvalidPathError(checkOSLimits("file1", long, true)))
+	s.Nil(checkOSLimits("file1", long[:windowsMaxPath-1], true))
+}
+
+func (s *LocalDirSuite) TestWindowsNames() {
+	localDir, err := New(&stor.Conf{Type: LocalDirStorageType, Path: s.tempDir,
+		Options: map[string]string{OptionWindowsNames: "true"}})
+	s.Require().Nil(err)
+
+	for _, filePath := range []string{"CON", "dir1/nul", "aux.txt", "dir1/Com1.tar.gz/file1",
+		"lpt9", "file1.", "dir1./file1"} {
+		err := localDir.Save(filePath, []byte("test123"))
+		s.True(stor.IsInvalidPathError(err), filePath)
+		_, err = localDir.Load(filePath, 100)
+		s.True(stor.IsInvalidPathError(err), filePath)
+	}
+
+	for _, filePath := range []string{"CONSOLE", "dir1/null.txt", "com10", "file.1", "my_aux"} {
+		s.Nil(localDir.Save(filePath, []byte("test123")), filePath)
+	}
+
+	_, err = New(&stor.Conf{Type: LocalDirStorageType, Path: s.tempDir,
+		Options: map[string]string{OptionWindowsNames: "yes"}})
+	s.True(stor.IsConfError(err))
+}
+
+func (s *LocalDirSuite) TestSaveAtomic() {
+	testDir, err := makeTestDir(s.tempDir)
+	s.Require().Nil(err)
+	localDir, err := New(&stor.Conf{Type: LocalDirStorageType, Path: testDir})
+	s.Require().Nil(err)
+
+	s.Nil(localDir.Save("dir1/file1", []byte("test123")))
+	s.Nil(localDir.Save("dir1/file1", []byte("test456")))
+
+	// No temporary files are left behind
+	entries, err := ioutil.ReadDir(filepath.Join(testDir, "dir1"))
+	s.Nil(err)
+	s.Len(entries, 1)
+	s.Equal("file1", entries[0].Name())
+
+	// Temporary files of Saves in progress (or of a crash) are not listed
+	tempPath := filepath.Join(testDir, "dir1", tempPrefix+"123")
+	s.Nil(ioutil.WriteFile(tempPath, []byte("tes"), 0600))
+	files, _, err := localDir.List("dir1")
+	s.Nil(err)
+	s.Equal([]string{"dir1/file1"}, files)
+
+	// Temporary files are not renamed along with the directory
+	s.Nil(localDir.Rename("dir1", "dir2"))
+	files, _, err = localDir.List("dir2")
+	s.Nil(err)
+	s.Equal([]string{"dir2/file1"}, files)
+
+	for _, filePath := range []string{tempPrefix + "123", "dir1/" + tempPrefix, tempPrefix + "/f"} {
+		_, err = localDir.Load(filePath, 100)
+		s.True(stor.IsInvalidPathError(err), filePath)
+		s.True(stor.IsInvalidPathError(localDir.Save(filePath, []byte("test123"))), filePath)
+	}
+}
+
+func (s *LocalDirSuite) TestMinFreeBytes() {
+	testDir, err := makeTestDir(s.tempDir)
+	s.Require().Nil(err)
+	localDir, err := New(&stor.Conf{Type: LocalDirStorageType, Path: testDir,
+		Options: map[string]string{OptionMinFreeBytes: "100"}})
+	s.Require().Nil(err)
+	localDir.diskFree = func(dirPath string) (int64, error) {
+		s.Equal(testDir, dirPath)
+		return 107, nil
+	}
+
+	s.Nil(localDir.Save("dir1/file1", []byte("test123")))
+
+	err = localDir.Save("dir1/file2", []byte("test4567"))
+	s.True(stor.IsInsufficientSpaceError(err))
+	var spaceErr *stor.InsufficientSpaceError
+	s.Require().True(errors.As(err, &spaceErr))
+	s.Equal(stor.InsufficientSpaceError{Path: "dir1/file2", Required: 108, Available: 107},
+		*spaceErr)
+	s.True(stor.IsInsufficientSpaceError(localDir.Create("dir1/file2", []byte("test4567"))))
+
+	// Nothing is written
+	files, _, err := localDir.List("dir1")
+	s.Nil(err)
+	s.Equal([]string{"dir1/file1"}, files)
+
+	localDir.diskFree = func(dirPath string) (int64, error) {
+		return 0, errors.New("statfs failed")
+	}
+	err = localDir.Save("dir1/file2", []byte("test4567"))
+	s.NotNil(err)
+	s.False(stor.IsInsufficientSpaceError(err))
+}
+
+func (s *LocalDirSuite) TestMinFreeBytesDiskFree() {
+	if _, err := diskFree(s.tempDir); err != nil {
+		s.T().Skip("free space is unknown on this platform")
+	}
+
+	localDir, err := New(&stor.Conf{Type: LocalDirStorageType, Path: s.tempDir,
+		Options: map[string]string{OptionMinFreeBytes: "1"}})
+	s.Require().Nil(err)
+	s.Nil(localDir.Save("file1", []byte("test123")))
+
+	// No file system has this much space to spare
+	localDir, err = New(&stor.Conf{Type: LocalDirStorageType, Path: s.tempDir,
+		Options: map[string]string{OptionMinFreeBytes: "9223372036854775807"}})
+	s.Require().Nil(err)
+	s.True(stor.IsInsufficientSpaceError(localDir.Save("file2", []byte("test123"))))
+
+	for _, value := range []string{"-1", "ten"} {
+		_, err = New(&stor.Conf{Type: LocalDirStorageType, Path: s.tempDir,
+			Options: map[string]string{OptionMinFreeBytes: value}})
+		s.True(stor.IsConfError(err), value)
+	}
+}
+
+func (s *LocalDirSuite) TestCreateIfMissing() {
+	testDir, err := makeTestDir(s.tempDir)
+	s.Require().Nil(err)
+	baseDir := filepath.Join(testDir, "dir1", "dir2")
+
+	localDir, err := New(&stor.Conf{Type: LocalDirStorageType, Path: baseDir,
+		Options: map[string]string{OptionCreateIfMissing: "true", OptionCreateMode: "0750"}})
+	s.Require().Nil(err)
+	s.Equal(baseDir, localDir.BaseDir)
+	s.Nil(localDir.Save("file1", []byte("test123")))
+
+	info, err := os.Stat(baseDir)
+	s.Require().Nil(err)
+	s.True(info.IsDir())
+	if runtime.GOOS != "windows" {
+		s.Equal(os.FileMode(0750)&^umask(), info.Mode().Perm())
+	}
+
+	// An existing directory is used as-is
+	localDir, err = New(&stor.Conf{Type: LocalDirStorageType, Path: baseDir,
+		Options: map[string]string{OptionCreateIfMissing: "true"}})
+	s.Require().Nil(err)
+	data, err := localDir.Load("file1", 100)
+	s.Nil(err)
+	s.Equal("test123", string(data))
+}
+
+// umask returns the umask of the process.
+func umask() os.FileMode {
+	dir, err := ioutil.TempDir("", "umask")
+	if err != nil {
+		return 0
+	}
+	defer os.RemoveAll(dir)
+
+	os.Mkdir(filepath.Join(dir, "d"), 0777)
+	info, err := os.Stat(filepath.Join(dir, "d"))
+	if err != nil {
+		return 0
+	}
+	return 0777 &^ info.Mode().Perm()
+}
+
+func (s *LocalDirSuite) TestCreateIfMissingFails() {
+	testDir, err := makeTestDir(s.tempDir)
+	s.Require().Nil(err)
+	s.Require().Nil(ioutil.WriteFile(filepath.Join(testDir, "file1"), []byte("test123"), 0600))
+
+	// A file is in the way
+	baseDir := filepath.Join(testDir, "file1", "dir1")
+	localDir, err := New(&stor.Conf{Type: LocalDirStorageType, Path: baseDir,
+		Options: map[string]string{OptionCreateIfMissing: "true"}})
+	s.Nil(localDir)
+	s.True(IsCreateBaseDirError(err))
+	var createErr *CreateBaseDirError
+	s.Require().True(errors.As(err, &createErr))
+	s.Equal(baseDir, createErr.Path)
+	s.NotNil(errors.Unwrap(err))
+
+	// Without the option, the directory isn't created
+	_, err = New(&stor.Conf{Type: LocalDirStorageType, Path: filepath.Join(testDir, "dir1")})
+	s.NotNil(err)
+	s.False(IsCreateBaseDirError(err))
+	_, err = os.Stat(filepath.Join(testDir, "dir1"))
+	s.True(os.IsNotExist(err))
+
+	for _, options := range []map[string]string{
+		{OptionCreateIfMissing: "yes"},
+		{OptionCreateMode: "0778"},
+		{OptionCreateMode: "01777"},
+		{OptionCreateMode: "rwx"},
+	} {
+		_, err = New(&stor.Conf{Type: LocalDirStorageType, Path: testDir, Options: options})
+		s.True(stor.IsConfError(err), options)
+	}
+}
+
+func (s *LocalDirSuite) TestKeepEmptyDirs() {
+	testDir, err := makeTestDir(s.tempDir)
+	s.Require().Nil(err)
+	localDir, err := New(&stor.Conf{Type: LocalDirStorageType, Path: testDir,
+		Options: map[string]string{OptionKeepEmptyDirs: "true"}})
+	s.Require().Nil(err)
+
+	s.Nil(localDir.Save("dir1/dir2/file1", []byte("test123")))
+	s.Nil(localDir.Delete("dir1/dir2/file1"))
+	info, err := os.Stat(filepath.Join(testDir, "dir1", "dir2"))
+	s.Require().Nil(err)
+	s.True(info.IsDir())
+
+	// The empty directories are moved along with the files
+	s.Nil(localDir.MkDir("dir1/dir3"))
+	s.Nil(localDir.Save("dir1/file2", []byte("test123")))
+	s.Nil(localDir.Rename("dir1", "dir4/dir5"))
+	_, dirs, err := localDir.List("dir4/dir5")
+	s.Nil(err)
+	s.Equal([]string{"dir4/dir5/dir2", "dir4/dir5/dir3"}, dirs)
+	_, dirs, err = localDir.List("")
+	s.Nil(err)
+	s.Equal([]string{"dir4"}, dirs)
+
+	_, err = New(&stor.Conf{Type: LocalDirStorageType, Path: testDir,
+		Options: map[string]string{OptionKeepEmptyDirs: "yes"}})
+	s.True(stor.IsConfError(err))
+}
+
+func (s *LocalDirSuite) TestMkDirPruned() {
+	testDir, err := makeTestDir(s.tempDir)
+	s.Require().Nil(err)
+	localDir, err := New(&stor.Conf{Type: LocalDirStorageType, Path: testDir})
+	s.Require().Nil(err)
+
+	// Without OptionKeepEmptyDirs, a created directory is pruned with its last file
+	s.Nil(localDir.MkDir("dir1"))
+	s.Nil(localDir.Save("dir1/file1", []byte("test123")))
+	s.Nil(localDir.Delete("dir1/file1"))
+	_, dirs, err := localDir.List("")
+	s.Nil(err)
+	s.Equal([]string{}, dirs)
+
+	s.True(stor.IsInvalidPathError(localDir.RemoveDir("")))
+	s.Nil(localDir.Save("file1", []byte("test123")))
+	s.True(stor.IsPathDoesntExistError(localDir.RemoveDir("file1")))
+}
+
+func (s *LocalDirSuite) TestCollectGarbage() {
+	testDir, err := makeTestDir(s.tempDir)
+	s.Require().Nil(err)
+	localDir, err := New(&stor.Conf{Type: LocalDirStorageType, Path: testDir})
+	s.Require().Nil(err)
+	s.Nil(localDir.Save("dir1/file1", []byte("test123")))
+
+	// The leftovers of crashed Saves, of which one is still recent
+	past := time.Now().Add(-2 * time.Hour)
+	stale := []string{"dir1/" + tempPrefix + "a", "dir2/dir3/" + tempPrefix + "b"}
+	for _, tempPath := range append(stale, "dir1/"+tempPrefix+"c") {
+		fullPath := filepath.Join(testDir, filepath.FromSlash(tempPath))
+		s.Require().Nil(os.MkdirAll(filepath.Dir(fullPath), 0700))
+		s.Require().Nil(ioutil.WriteFile(fullPath, []byte("test"), 0600))
+		if tempPath != "dir1/"+tempPrefix+"c" {
+			s.Require().Nil(os.Chtimes(fullPath, past, past))
+		}
+	}
+
+	report, err := stor.CollectGarbage(localDir, "", stor.GCOptions{DryRun: true})
+	s.Require().Nil(err)
+	s.Require().Len(report.Artifacts, 2)
+	for i, artifact := range report.Artifacts {
+		s.Equal(stale[i], artifact.Path)
+		s.Equal(int64(4), artifact.Size)
+		s.False(artifact.Removed)
+	}
+	s.Equal(int64(0), report.Bytes())
+
+	report, err = stor.CollectGarbage(localDir, "dir2", stor.GCOptions{})
+	s.Require().Nil(err)
+	s.Require().Len(report.Artifacts, 1)
+	s.True(report.Artifacts[0].Removed)
+	_, err = os.Stat(filepath.Join(testDir, "dir2"))
+	s.True(os.IsNotExist(err), "the empty directories are pruned")
+
+	report, err = stor.CollectGarbage(localDir, "", stor.GCOptions{MinAge: time.Nanosecond})
+	s.Require().Nil(err)
+	s.Len(report.Artifacts, 2)
+	s.Equal(int64(8), report.Bytes())
+	entries, err := ioutil.ReadDir(filepath.Join(testDir, "dir1"))
+	s.Require().Nil(err)
+	s.Require().Len(entries, 1)
+	s.Equal("file1", entries[0].Name())
+}
+
+func (s *LocalDirSuite) TestListWithMetaSymlinks() {
+	if runtime.GOOS == "windows" {
+		s.T().Skip("symbolic links require privileges on Windows")
+	}
+	testDir, err := makeTestDir(s.tempDir)
+	s.Require().Nil(err)
+	localDir, err := New(&stor.Conf{Type: LocalDirStorageType, Path: testDir})
+	s.Require().Nil(err)
+
+	s.Nil(localDir.Save("dir1/file1", []byte("test123")))
+	s.Require().Nil(os.Symlink("file1", filepath.Join(testDir, "dir1", "link")))
+	s.Require().Nil(os.Symlink("missing", filepath.Join(testDir, "dir1", "dangling")))
+
+	entries, _, err := localDir.ListWithMeta("dir1")
+	s.Require().Nil(err)
+	s.Require().Len(entries, 2, "the dangling link is skipped")
+	for _, entry := range entries {
+		meta, err := localDir.Meta(entry.Path)
+		s.Nil(err)
+		s.Equal(*meta, entry.Meta, entry.Path)
+		s.Equal(int64(7), entry.Meta.Size, entry.Path)
+	}
+}
+
+func (s *LocalDirSuite) TestCopyFrom() {
+	srcDir, err := makeTestDir(s.tempDir)
+	s.Require().Nil(err)
+	src, err := New(&stor.Conf{Type: LocalDirStorageType, Path: srcDir})
+	s.Require().Nil(err)
+	dstDir, err := makeTestDir(s.tempDir)
+	s.Require().Nil(err)
+	dst, err := New(&stor.Conf{Type: LocalDirStorageType, Path: dstDir})
+	s.Require().Nil(err)
+
+	s.Nil(src.Save("dir1/file1", []byte("test123")))
+	s.Nil(stor.CopyBetween(src, dst, "dir1/file1"))
+	data, err := dst.Load("dir1/file1", 100)
+	s.Nil(err)
+	s.Equal("test123", string(data))
+
+	// The copy is independent of the original
+	s.Nil(src.Save("dir1/file1", []byte("test456")))
+	data, err = dst.Load("dir1/file1", 100)
+	s.Nil(err)
+	s.Equal("test123", string(data))
+
+	// No temporary files are left behind
+	entries, err := ioutil.ReadDir(filepath.Join(dstDir, "dir1"))
+	s.Nil(err)
+	s.Len(entries, 1)
+
+	s.Require().Nil(os.Mkdir(filepath.Join(srcDir, "dir2"), 0700))
+	for _, filePath := range []string{"dir1/file2", "dir2"} {
+		copied, err := dst.CopyFrom(src, filePath)
+		s.True(copied, filePath)
+		s.True(stor.IsPathDoesntExistError(err), filePath)
+	}
+}
+
+func (s *LocalDirSuite) TestMmap() {
+	if !mmapSupported {
+		s.T().Skip("memory-mapping is not supported on this platform")
+	}
+	testDir, err := makeTestDir(s.tempDir)
+	s.Require().Nil(err)
+	localDir, err := New(&stor.Conf{Type: LocalDirStorageType, Path: testDir,
+		Options: map[string]string{OptionMmapMinSize: "5"}})
+	s.Require().Nil(err)
+	defer localDir.Close()
+
+	s.Nil(localDir.Save("dir1/file1", []byte("test123")))
+	data1, err := localDir.Load("dir1/file1", 100)
+	s.Nil(err)
+	s.Equal("test123", string(data1))
+	data2, err := localDir.Load("dir1/file1", 100)
+	s.Nil(err)
+	s.Equal(&data1[0], &data2[0], "an unchanged file is mapped once")
+
+	// The mapping of the replaced file remains valid
+	s.Nil(localDir.Save("dir1/file1", []byte("test456")))
+	data3, err := localDir.Load("dir1/file1", 100)
+	s.Nil(err)
+	s.Equal("test456", string(data3))
+	s.Equal("test123", string(data1))
+
+	// Smaller files are read
+	s.Nil(localDir.Save("dir1/file2", []byte("test")))
+	data, err := localDir.Load("dir1/file2", 100)
+	s.Nil(err)
+	s.Equal("test", string(data))
+	s.Len(localDir.mmap.current, 1)
+	s.Len(localDir.mmap.mappings, 2)
+	s.Nil(localDir.Release(data))
+
+	_, err = localDir.Load("dir1/file1", 6)
+	s.True(stor.IsTooLargeError(err))
+
+	s.Nil(localDir.Close())
+	s.Len(localDir.mmap.current, 0)
+	s.Len(localDir.mmap.mappings, 0)
+}
+
+// TestMmapRelease verifies that the mappings of replaced and deleted files are released once they
+// aren't referenced anymore.
+func (s *LocalDirSuite) TestMmapRelease() {
+	if !mmapSupported {
+		s.T().Skip("memory-mapping is not supported on this platform")
+	}
+	testDir, err := makeTestDir(s.tempDir)
+	s.Require().Nil(err)
+	localDir, err := New(&stor.Conf{Type: LocalDirStorageType, Path: testDir,
+		Options: map[string]string{OptionMmapMinSize: "5"}})
+	s.Require().Nil(err)
+	defer localDir.Close()
+
+	s.Nil(localDir.Save("dir1/file1", []byte("test123")))
+	data1, err := localDir.Load("dir1/file1", 100)
+	s.Require().Nil(err)
+	data2, err := localDir.Load("dir1/file1", 100)
+	s.Require().Nil(err)
+
+	// A referenced mapping stays when the file is replaced
+	s.Nil(localDir.Save("dir1/file1", []byte("test456")))
+	s.Len(localDir.mmap.current, 0)
+	s.Len(localDir.mmap.mappings, 1)
+	s.Nil(localDir.Release(data1))
+	s.Equal("test123", string(data2))
+	s.Nil(localDir.Release(data2))
+	s.Len(localDir.mmap.mappings, 0)
+
+	// An unreferenced mapping is kept for reuse until the file is deleted
+	data3, err := localDir.Load("dir1/file1", 100)
+	s.Require().Nil(err)
+	s.Nil(localDir.Release(data3))
+	s.Len(localDir.mmap.mappings, 1)
+	s.Nil(localDir.Delete("dir1/file1"))
+	s.Len(localDir.mmap.mappings, 0)
+
+	// Renamed files are forgotten at both paths
+	s.Nil(localDir.Save("dir1/file2", []byte("test789")))
+	data4, err := localDir.Load("dir1/file2", 100)
+	s.Require().Nil(err)
+	s.Nil(localDir.Rename("dir1", "dir2"))
+	s.Len(localDir.mmap.current, 0)
+	s.Nil(localDir.Release(data4))
+	s.Len(localDir.mmap.mappings, 0)
+
+	// Data that isn't mapped is ignored
+	s.Nil(localDir.Release([]byte("test")))
+	s.Nil(localDir.Release(nil))
+}
+
+func (s *LocalDirSuite) TestMmapInvalidMinSize() {
+	for _, value := range []string{"-1", "1k"} {
+		_, err := New(&stor.Conf{Type: LocalDirStorageType, Path: s.tempDir,
+			Options: map[string]string{OptionMmapMinSize: value}})
+		s.True(stor.IsConfError(err), value)
+	}
+}
@@ -0,0 +1,130 @@
+package localdir
+
+import (
+	"os"
+	"sync"
+)
+
+// mapping is a memory-mapped file.
+type mapping struct {
+	data []byte
+	info os.FileInfo
+
+	// refs is the number of Loads that returned the mapping, and that haven't been released.
+	refs int
+
+	// stale is true if the mapping is no longer the current mapping of its file.
+	stale bool
+}
+
+// mmapCache keeps the files that Load memory-maps (see OptionMmapMinSize). A mapping can't be
+// released while callers may still use its data, so the mappings are reference-counted: a mapping
+// of a file that was replaced or deleted since is released as soon as all Loads that returned it
+// are released. Mappings of which the data is never released stay until close.
+type mmapCache struct {
+	minSize int64
+
+	// mutex protects current and mappings
+	mutex    sync.Mutex
+	current  map[string]*mapping
+	mappings map[*byte]*mapping
+}
+
+func newMmapCache(minSize int64) *mmapCache {
+	return &mmapCache{
+		minSize:  minSize,
+		current:  make(map[string]*mapping),
+		mappings: make(map[*byte]*mapping),
+	}
+}
+
+// load returns the mapped content of an open file. The mapping of an earlier load is reused if
+// the file wasn't replaced or changed since.
+func (c *mmapCache) load(fullPath string, file *os.File, info os.FileInfo) ([]byte, error) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	old, ok := c.current[fullPath]
+	if ok && os.SameFile(old.info, info) && old.info.Size() == info.Size() &&
+		old.info.ModTime().Equal(info.ModTime()) {
+		old.refs++
+		return old.data, nil
+	}
+
+	data, err := mapFile(file, int(info.Size()))
+	if err != nil {
+		return nil, err
+	}
+
+	if ok {
+		c.retire(old)
+	}
+	m := &mapping{data: data, info: info, refs: 1}
+	c.current[fullPath] = m
+	c.mappings[&data[0]] = m
+	return data, nil
+}
+
+// forget retires the current mapping of fullPath, after the file was replaced or deleted. The
+// mapping is released once it isn't referenced anymore. It returns the error of the release.
+func (c *mmapCache) forget(fullPath string) error {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	m, ok := c.current[fullPath]
+	if !ok {
+		return nil
+	}
+	delete(c.current, fullPath)
+	return c.retire(m)
+}
+
+// release drops a reference to the mapping of data, which must have been returned by load. Data
+// that isn't mapped (anymore) is ignored. It returns the error of the release of the mapping.
+func (c *mmapCache) release(data []byte) error {
+	if len(data) == 0 {
+		return nil
+	}
+
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	m, ok := c.mappings[&data[0]]
+	if !ok || m.refs == 0 {
+		return nil
+	}
+	m.refs--
+	if m.refs > 0 || !m.stale {
+		return nil
+	}
+	delete(c.mappings, &data[0])
+	return unmapFile(m.data)
+}
+
+// retire marks a mapping that was removed from current as stale, and releases it if it isn't
+// referenced. The mutex must be held.
+func (c *mmapCache) retire(m *mapping) error {
+	m.stale = true
+	if m.refs > 0 {
+		return nil
+	}
+	delete(c.mappings, &m.data[0])
+	return unmapFile(m.data)
+}
+
+// close releases all mappings. It returns the first error.
+func (c *mmapCache) close() error {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	var firstErr error
+	for _, m := range c.mappings {
+		if err := unmapFile(m.data); err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+
+	c.current = make(map[string]*mapping)
+	c.mappings = make(map[*byte]*mapping)
+	return firstErr
+}
@@ -0,0 +1,22 @@
+//go:build !darwin && !dragonfly && !freebsd && !linux && !netbsd && !openbsd
+// +build !darwin,!dragonfly,!freebsd,!linux,!netbsd,!openbsd
+
+package localdir
+
+import (
+	"errors"
+	"os"
+)
+
+// mmapSupported is true if files can be memory-mapped on this platform (see OptionMmapMinSize).
+const mmapSupported = false
+
+// mapFile always fails, because memory-mapping is not supported on this platform.
+func mapFile(f *os.File, size int) ([]byte, error) {
+	return nil, errors.New("memory-mapping is not supported")
+}
+
+// unmapFile releases memory returned by mapFile.
+func unmapFile(data []byte) error {
+	return nil
+}
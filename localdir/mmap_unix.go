@@ -0,0 +1,22 @@
+//go:build darwin || dragonfly || freebsd || linux || netbsd || openbsd
+// +build darwin dragonfly freebsd linux netbsd openbsd
+
+package localdir
+
+import (
+	"os"
+	"syscall"
+)
+
+// mmapSupported is true if files can be memory-mapped on this platform (see OptionMmapMinSize).
+const mmapSupported = true
+
+// mapFile maps a file read-only into memory.
+func mapFile(f *os.File, size int) ([]byte, error) {
+	return syscall.Mmap(int(f.Fd()), 0, size, syscall.PROT_READ, syscall.MAP_SHARED)
+}
+
+// unmapFile releases memory returned by mapFile.
+func unmapFile(data []byte) error {
+	return syscall.Munmap(data)
+}
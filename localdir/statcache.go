@@ -0,0 +1,101 @@
+package localdir
+
+import (
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// statCache is a short-lived cache of the file information within directories. It is populated by
+// List, so the common pattern of listing a directory and then calling Meta for each entry doesn't
+// need a stat syscall per entry.
+type statCache struct {
+	ttl   time.Duration
+	mutex sync.Mutex
+	dirs  map[string]*dirStats
+}
+
+// dirStats contains the cached file information of the entries within a directory. Symbolic
+// links have a nil os.FileInfo: the directory entry describes the link (os.Lstat), not its target
+// (os.Stat), so they aren't cached.
+type dirStats struct {
+	expires time.Time
+	infos   map[string]os.FileInfo
+}
+
+func newStatCache(ttl time.Duration) *statCache {
+	return &statCache{
+		ttl:  ttl,
+		dirs: make(map[string]*dirStats),
+	}
+}
+
+// put caches the entries of the directory dirPath.
+func (c *statCache) put(dirPath string, entries []os.FileInfo) {
+	infos := make(map[string]os.FileInfo, len(entries))
+	for _, entry := range entries {
+		if entry.Mode()&os.ModeSymlink != 0 {
+			infos[entry.Name()] = nil
+			continue
+		}
+		infos[entry.Name()] = entry
+	}
+
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	// Drop expired directories, so the cache doesn't grow without bound
+	now := time.Now()
+	for dir, stats := range c.dirs {
+		if now.After(stats.expires) {
+			delete(c.dirs, dir)
+		}
+	}
+
+	c.dirs[dirPath] = &dirStats{
+		expires: now.Add(c.ttl),
+		infos:   infos,
+	}
+}
+
+// get returns the cached file information of fullPath. The second return value indicates whether
+// the file information of fullPath is cached. If it is, then a nil os.FileInfo means that the path
+// doesn't exist. Symbolic links are never cached.
+func (c *statCache) get(fullPath string) (os.FileInfo, bool) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	stats, ok := c.dirs[filepath.Dir(fullPath)]
+	if !ok || time.Now().After(stats.expires) {
+		return nil, false
+	}
+
+	info, ok := stats.infos[filepath.Base(fullPath)]
+	if ok && info == nil {
+		return nil, false
+	}
+	return info, true
+}
+
+// invalidate removes the directories that are affected by a change of fullPath (its parent
+// directory and all further ancestors) from the cache.
+func (c *statCache) invalidate(fullPath string) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	for dir := filepath.Dir(fullPath); ; dir = filepath.Dir(dir) {
+		delete(c.dirs, dir)
+		if dir == filepath.Dir(dir) {
+			break
+		}
+	}
+}
+
+// clear removes all directories from the cache.
+func (c *statCache) clear() {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	c.dirs = make(map[string]*dirStats)
+}
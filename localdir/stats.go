@@ -0,0 +1,54 @@
+package localdir
+
+import (
+	"os"
+	"path"
+	"path/filepath"
+
+	"github.com/pw1/stor"
+)
+
+// Stats returns the statistics of all files within a directory and its subdirectories. It walks
+// the directory once, and takes the sizes from the directory entries, so it doesn't stat every
+// file separately. Files and directories that are deleted during the walk are skipped.
+func (l *LocalDir) Stats(dirPath string) (*stor.StorageStats, error) {
+	cleanDir, err := stor.CleanPath(dirPath)
+	if err != nil {
+		return nil, err
+	}
+	fullPath, err := l.getFullPath(cleanDir)
+	if err != nil {
+		return nil, err
+	}
+
+	stats := stor.NewStorageStats()
+	err = filepath.Walk(fullPath, func(entryPath string, info os.FileInfo, err error) error {
+		if err != nil {
+			if os.IsNotExist(err) {
+				return nil
+			}
+			return err
+		}
+		if entryPath == fullPath {
+			if !info.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if isTempName(info.Name()) || info.IsDir() {
+			return nil
+		}
+
+		relPath, err := filepath.Rel(fullPath, entryPath)
+		if err != nil {
+			return err
+		}
+		stats.Add(cleanDir, path.Join(cleanDir, filepath.ToSlash(relPath)), info.Size())
+		return nil
+	})
+	if err != nil {
+		return nil, opError(stor.OpStats, dirPath, err)
+	}
+
+	return stats, nil
+}
@@ -0,0 +1,64 @@
+package stor
+
+import "sync/atomic"
+
+// DefaultMaxSize is the initial default maximum size of LoadDefault: 64 MiB.
+const DefaultMaxSize = 64 << 20
+
+// defaultMaxSize is the current default maximum size. It is accessed atomically.
+var defaultMaxSize int64 = DefaultMaxSize
+
+// SetDefaultMaxSize sets the default maximum size of LoadDefault, for Storages that don't have
+// their own (see WithMaxSize). This allows operators to tune the limit centrally, instead of in
+// every call to Load. It is safe to call SetDefaultMaxSize concurrently with LoadDefault.
+func SetDefaultMaxSize(maxSize int64) {
+	atomic.StoreInt64(&defaultMaxSize, maxSize)
+}
+
+// GetDefaultMaxSize returns the default maximum size of LoadDefault (see SetDefaultMaxSize).
+func GetDefaultMaxSize() int64 {
+	return atomic.LoadInt64(&defaultMaxSize)
+}
+
+// MaxSizer is implemented by Storages that have their own default maximum size.
+type MaxSizer interface {
+	// MaxSize returns the maximum size that LoadDefault uses for the Storage.
+	MaxSize() int64
+}
+
+// WithMaxSize returns a Storage with its own default maximum size for LoadDefault. The Load method
+// itself is not affected. Note that the Storage only implements the Storage, MaxSizer, Typer and
+// io.Closer interfaces, and that wrappers around it hide the MaxSizer, so it should be the
+// outermost Storage.
+func WithMaxSize(s Storage, maxSize int64) Storage {
+	return &withMaxSize{Storage: s, maxSize: maxSize}
+}
+
+// withMaxSize is the Storage returned by WithMaxSize.
+type withMaxSize struct {
+	Storage
+	maxSize int64
+}
+
+func (w *withMaxSize) MaxSize() int64 {
+	return w.maxSize
+}
+
+func (w *withMaxSize) Type() Type {
+	return TypeOf(w.Storage)
+}
+
+func (w *withMaxSize) Close() error {
+	return Close(w.Storage)
+}
+
+// LoadDefault loads a file with the default maximum size: the MaxSize of l if it implements
+// MaxSizer, or the package default (see SetDefaultMaxSize) otherwise. Like Load, it returns a
+// TooLargeError if the file is larger.
+func LoadDefault(l Loader, filePath string) ([]byte, error) {
+	maxSize := GetDefaultMaxSize()
+	if m, ok := l.(MaxSizer); ok {
+		maxSize = m.MaxSize()
+	}
+	return l.Load(filePath, maxSize)
+}
@@ -0,0 +1,63 @@
+package stor_test
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/suite"
+
+	"github.com/pw1/stor"
+	"github.com/pw1/stor/memory"
+)
+
+func TestMaxSizeSuite(t *testing.T) {
+	suite.Run(t, new(MaxSizeSuite))
+}
+
+// MaxSizeSuite contains the tests for the default maximum size of LoadDefault.
+type MaxSizeSuite struct {
+	suite.Suite
+	base stor.Storage
+}
+
+func (s *MaxSizeSuite) SetupTest() {
+	s.base, _ = memory.New(&stor.Conf{})
+	s.Require().Nil(s.base.Save("file1", []byte("0123456789")))
+}
+
+func (s *MaxSizeSuite) TearDownTest() {
+	stor.SetDefaultMaxSize(stor.DefaultMaxSize)
+}
+
+func (s *MaxSizeSuite) TestDefault() {
+	s.Equal(int64(stor.DefaultMaxSize), stor.GetDefaultMaxSize())
+
+	data, err := stor.LoadDefault(s.base, "file1")
+	s.Nil(err)
+	s.Equal("0123456789", string(data))
+}
+
+func (s *MaxSizeSuite) TestSetDefaultMaxSize() {
+	stor.SetDefaultMaxSize(9)
+	_, err := stor.LoadDefault(s.base, "file1")
+	s.True(stor.IsTooLargeError(err))
+
+	stor.SetDefaultMaxSize(10)
+	_, err = stor.LoadDefault(s.base, "file1")
+	s.Nil(err)
+}
+
+func (s *MaxSizeSuite) TestWithMaxSize() {
+	st := stor.WithMaxSize(s.base, 9)
+	s.Equal(int64(9), st.(stor.MaxSizer).MaxSize())
+
+	_, err := stor.LoadDefault(st, "file1")
+	s.True(stor.IsTooLargeError(err), "the Storage overrides the package default")
+
+	data, err := st.Load("file1", 10)
+	s.Nil(err, "Load is not affected")
+	s.Equal("0123456789", string(data))
+
+	stor.SetDefaultMaxSize(5)
+	_, err = stor.LoadDefault(stor.WithMaxSize(s.base, 10), "file1")
+	s.Nil(err)
+}
@@ -0,0 +1,97 @@
+package memory
+
+import "path"
+
+// dirIndex contains the names of the entries directly within a directory, so List doesn't need to
+// scan all files. A directory is indexed as long as a file exists within it or its subdirectories,
+// and the root is always indexed.
+type dirIndex struct {
+	files map[string]bool
+	dirs  map[string]bool
+
+	// fileCount is the number of files within the directory and its subdirectories. Expired files
+	// are counted until they are swept.
+	fileCount int
+}
+
+func newDirIndex() *dirIndex {
+	return &dirIndex{files: make(map[string]bool), dirs: make(map[string]bool)}
+}
+
+// splitPath splits a clean path into its directory and its name. The directory of files in the
+// root is "".
+func splitPath(cleanPath string) (string, string) {
+	dir, name := path.Split(cleanPath)
+	return path.Clean("/" + dir)[1:], name
+}
+
+// indexAdd adds a file to the index of its directory, and its directories to the indexes of their
+// parents, and counts it in all of them. The mutex must be held for writing.
+func (m *Memory) indexAdd(cleanPath string) {
+	dir, name := splitPath(cleanPath)
+	isFile := true
+	for {
+		index, ok := m.index[dir]
+		if !ok {
+			index = newDirIndex()
+			m.index[dir] = index
+		}
+		if isFile {
+			index.files[name] = true
+		} else {
+			index.dirs[name] = true
+		}
+		index.fileCount++
+		if dir == "" {
+			return
+		}
+		dir, name = splitPath(dir)
+		isFile = false
+	}
+}
+
+// indexRemove removes a file from the index of its directory and uncounts it in the indexes of its
+// directories. Directories that contain no files afterwards are removed from the indexes of their
+// parents. The mutex must be held for writing.
+func (m *Memory) indexRemove(cleanPath string) {
+	dir, name := splitPath(cleanPath)
+	isFile := true
+	for {
+		index := m.index[dir]
+		if index == nil {
+			return
+		}
+		if isFile {
+			delete(index.files, name)
+		} else if m.index[path.Join(dir, name)] == nil {
+			delete(index.dirs, name)
+		}
+		index.fileCount--
+		if dir == "" {
+			return
+		}
+		if index.fileCount == 0 {
+			delete(m.index, dir)
+		}
+		dir, name = splitPath(dir)
+		isFile = false
+	}
+}
+
+// filesWithin returns the paths of all files within a directory and its subdirectories. The mutex
+// must be held.
+func (m *Memory) filesWithin(dir string) []string {
+	index := m.index[dir]
+	if index == nil {
+		return []string{}
+	}
+
+	result := []string{}
+	for name := range index.files {
+		result = append(result, path.Join(dir, name))
+	}
+	for name := range index.dirs {
+		result = append(result, m.filesWithin(path.Join(dir, name))...)
+	}
+	return result
+}
@@ -0,0 +1,179 @@
+package memory
+
+import (
+	"container/list"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/pw1/stor"
+)
+
+// CapacityError is returned by Save and Create if a file doesn't fit within the MaxBytes or
+// MaxObjects limits of the Memory, either because the EvictPolicy is EvictReject, or because the
+// file is larger than MaxBytes by itself. It matches stor.ErrNoSpace, so
+// stor.IsInsufficientSpaceError returns true for it.
+type CapacityError struct {
+	// Path is the path of the file that can't be saved.
+	Path string
+
+	// Limit is the name of the limit that would be exceeded: "bytes" or "objects".
+	Limit string
+
+	// Max is the value of the limit.
+	Max int64
+}
+
+// ErrCapacity is matched by a CapacityError with errors.Is.
+var ErrCapacity = errors.New("memory capacity exceeded")
+
+func (e *CapacityError) Error() string {
+	return fmt.Sprintf("saving %s would exceed the limit of %d %s", e.Path, e.Max, e.Limit)
+}
+
+// Is returns true for ErrCapacity and stor.ErrNoSpace, so errors.Is works for wrapped errors.
+func (e *CapacityError) Is(target error) bool {
+	return target == ErrCapacity || target == stor.ErrNoSpace
+}
+
+// IsCapacityError returns true if an error is, or wraps, a CapacityError. Returns false otherwise.
+func IsCapacityError(err error) bool {
+	return errors.Is(err, ErrCapacity)
+}
+
+// lru tracks the order in which files were last used (saved or loaded), so the least recently
+// used files can be evicted when the limits of the Memory are exceeded. It is protected by the
+// mutex of the Memory.
+type lru struct {
+	// order contains the paths of all files, the most recently used first.
+	order    *list.List
+	elements map[string]*list.Element
+}
+
+func newLRU() *lru {
+	return &lru{
+		order:    list.New(),
+		elements: make(map[string]*list.Element),
+	}
+}
+
+// touch marks a file as the most recently used.
+func (l *lru) touch(cleanPath string) {
+	if element, ok := l.elements[cleanPath]; ok {
+		l.order.MoveToFront(element)
+		return
+	}
+	l.elements[cleanPath] = l.order.PushFront(cleanPath)
+}
+
+// remove stops tracking a file.
+func (l *lru) remove(cleanPath string) {
+	if element, ok := l.elements[cleanPath]; ok {
+		l.order.Remove(element)
+		delete(l.elements, cleanPath)
+	}
+}
+
+// rename tracks a file under a new path, without changing when it was last used.
+func (l *lru) rename(oldPath, newPath string) {
+	element, ok := l.elements[oldPath]
+	if !ok {
+		return
+	}
+	l.remove(newPath)
+	delete(l.elements, oldPath)
+	element.Value = newPath
+	l.elements[newPath] = element
+}
+
+// oldest returns the least recently used file, except for the file at skip, or "" if there is no
+// such file.
+func (l *lru) oldest(skip string) string {
+	for element := l.order.Back(); element != nil; element = element.Prev() {
+		if cleanPath := element.Value.(string); cleanPath != skip {
+			return cleanPath
+		}
+	}
+	return ""
+}
+
+// makeRoom makes sure that a file of size bytes fits at cleanPath, by removing the expired files,
+// and then evicting the least recently used other files if the EvictPolicy is EvictLRU. Otherwise,
+// or if the file doesn't fit at all, a CapacityError is returned. The mutex must be held for
+// writing.
+func (m *Memory) makeRoom(cleanPath string, size int64) error {
+	if m.lru == nil {
+		return nil
+	}
+	if m.maxBytes > 0 && size > m.maxBytes {
+		return &CapacityError{Path: cleanPath, Limit: "bytes", Max: m.maxBytes}
+	}
+
+	swept := false
+	for {
+		bytes := m.bytes + size
+		objects := int64(len(m.data)) + 1
+		if old, ok := m.data[cleanPath]; ok {
+			bytes -= int64(len(old))
+			objects--
+		}
+
+		var err error
+		if m.maxBytes > 0 && bytes > m.maxBytes {
+			err = &CapacityError{Path: cleanPath, Limit: "bytes", Max: m.maxBytes}
+		} else if m.maxObjects > 0 && objects > m.maxObjects {
+			err = &CapacityError{Path: cleanPath, Limit: "objects", Max: m.maxObjects}
+		}
+		if err == nil {
+			return nil
+		}
+		if !swept {
+			swept = true
+			if m.sweep() > 0 {
+				continue
+			}
+		}
+
+		victim := m.lru.oldest(cleanPath)
+		if m.evictPolicy == EvictReject || victim == "" {
+			return err
+		}
+		m.remove(victim)
+	}
+}
+
+// store saves data at cleanPath, which expires after ttl (if it is positive), and updates the
+// bookkeeping. The mutex must be held for writing.
+func (m *Memory) store(cleanPath string, data []byte, ttl time.Duration) {
+	old, exists := m.data[cleanPath]
+	m.bytes += int64(len(data)) - int64(len(old))
+	m.data[cleanPath] = data
+	if !exists {
+		m.indexAdd(cleanPath)
+	}
+	if ttl > 0 {
+		m.setExpiry(cleanPath, m.now().Add(ttl))
+	} else {
+		delete(m.expires, cleanPath)
+	}
+	if m.lru != nil {
+		m.lru.touch(cleanPath)
+	}
+	m.bumpGenerations(cleanPath)
+}
+
+// remove removes the file at cleanPath, and updates the bookkeeping. The mutex must be held for
+// writing.
+func (m *Memory) remove(cleanPath string) {
+	if _, ok := m.data[cleanPath]; !ok {
+		return
+	}
+	m.bytes -= int64(len(m.data[cleanPath]))
+	delete(m.data, cleanPath)
+	m.indexRemove(cleanPath)
+	delete(m.expires, cleanPath)
+	if m.lru != nil {
+		m.lru.remove(cleanPath)
+	}
+	m.bumpGenerations(cleanPath)
+}
@@ -2,7 +2,13 @@
 package memory
 
 import (
+	"fmt"
+	"path"
+	"sort"
+	"strconv"
 	"strings"
+	"sync"
+	"time"
 
 	"github.com/pw1/stor"
 )
@@ -10,6 +16,53 @@ import (
 const (
 	// MemoryStorageType is the storage type of the Memory storage.
 	MemoryStorageType stor.Type = "Memory"
+
+	// URLScheme is the scheme of Memory storage URLs (see stor.NewFromURL): "mem://".
+	URLScheme = "mem"
+
+	// OptionMaxBytes is the stor.Conf option with the maximum total size in bytes of the files in
+	// the Memory. The value is a number of bytes; 0 (the default) disables the limit.
+	OptionMaxBytes = "maxbytes"
+
+	// OptionMaxObjects is the stor.Conf option with the maximum number of files in the Memory. The
+	// value is a number; 0 (the default) disables the limit.
+	OptionMaxObjects = "maxobjects"
+
+	// OptionEvictPolicy is the stor.Conf option that selects what happens when a Save would exceed
+	// OptionMaxBytes or OptionMaxObjects: EvictLRU (the default) or EvictReject.
+	OptionEvictPolicy = "evictpolicy"
+
+	// EvictLRU evicts the least recently used files (saved or loaded) until the new file fits. The
+	// Memory can then be used as a cache.
+	EvictLRU = "lru"
+
+	// EvictReject rejects the Save with a CapacityError, and keeps all files.
+	EvictReject = "reject"
+
+	// OptionZeroCopy is the stor.Conf option that stops Save and Load from copying the data. The
+	// value is "true" or "false" (the default). This saves memory and time for large files, but
+	// the caller must then follow an ownership contract: the data passed to Save (or Create or
+	// SaveWithTTL) belongs to the Memory afterwards and must not be modified anymore, and the data
+	// returned by Load is shared with the Memory and with other callers of Load, and must not be
+	// modified either.
+	OptionZeroCopy = "zerocopy"
+
+	// OptionPersistFile is the stor.Conf option with the name of a file to which the content of
+	// the Memory is persisted, so it survives restarts. New restores the content from the file if
+	// it exists. The content is persisted by Persist and Close, and periodically with
+	// OptionPersistInterval. Changes after the last Persist are lost if the process exits.
+	OptionPersistFile = "persistfile"
+
+	// OptionPersistInterval is the stor.Conf option with the interval at which the content is
+	// persisted to the OptionPersistFile in the background, e.g. "30s". Nothing is written if
+	// nothing changed. Close must be called to stop the background persisting. By default, the
+	// content is only persisted by Persist and Close.
+	OptionPersistInterval = "persistinterval"
+
+	// OptionTTL is the stor.Conf option with the default time to live of the saved files, e.g.
+	// "10m". Expired files are not returned anymore, and are removed by Sweep (see SweepEvery).
+	// By default, files don't expire. Use SaveWithTTL to save a file with another TTL.
+	OptionTTL = "ttl"
 )
 
 func init() {
@@ -17,23 +70,174 @@ func init() {
 		return New(conf)
 	}
 	stor.RegisterType(MemoryStorageType, newStorageFunc)
+	stor.RegisterValidator(MemoryStorageType, Validate)
+	stor.RegisterScheme(URLScheme, MemoryStorageType, nil)
+	stor.RegisterInfo(stor.Info{
+		Type:        MemoryStorageType,
+		Description: "Map in memory",
+		Options: []stor.OptionInfo{
+			{Name: OptionMaxBytes, Description: "Maximum total size of the files in bytes. " +
+				"Unlimited by default."},
+			{Name: OptionMaxObjects, Description: "Maximum number of files. Unlimited by default."},
+			{Name: OptionEvictPolicy, Description: "What to do when a limit is exceeded: evict " +
+				"the least recently used files (\"lru\") or reject the save (\"reject\"). " +
+				"Default: \"lru\"."},
+			{Name: OptionTTL, Description: "Time to live of the saved files (e.g. \"10m\"). " +
+				"Files don't expire by default."},
+			{Name: OptionPersistFile, Description: "File to which the content is persisted, " +
+				"and from which it is restored. Not persisted by default."},
+			{Name: OptionPersistInterval, Description: "Interval at which the content is " +
+				"persisted (e.g. \"30s\"). By default, only when the Memory is closed."},
+			{Name: OptionZeroCopy, Description: "Share the data of Save and Load with the " +
+				"caller instead of copying it (\"true\" or \"false\"). Disabled by default."},
+		},
+		Capabilities: []stor.Capability{stor.CapabilityRename, stor.CapabilityGeneration,
+			stor.CapabilityCreate, stor.CapabilitySortedList, stor.CapabilityStats,
+			stor.CapabilityListMeta, stor.CapabilityListRecursive, stor.CapabilityCopy},
+	})
 }
 
 // Memory is a stor.Storage implementation. It stores everything in memory. Can, for example, be
-// used as memory cache, or for testing.
+// used as memory cache, or for testing. It is safe for concurrent use by multiple goroutines.
 type Memory struct {
-	data map[string][]byte
+	// mutex protects all other fields. It is held for reading by Meta, List, Load and
+	// Generation, and for writing by the operations that modify files. Load holds it for writing
+	// if the Memory has limits, because it updates the lru. List and Generation briefly hold it
+	// for writing when they sweep expired files (see sweepAt).
+	mutex       sync.RWMutex
+	data        map[string][]byte
+	generations map[string]uint64
+
+	// bytes is the total size of the files in data.
+	bytes int64
+
+	// maxBytes and maxObjects are the limits of the Memory, or 0 if there is no limit.
+	maxBytes    int64
+	maxObjects  int64
+	evictPolicy string
+
+	// lru tracks the use of the files. It is nil if the Memory has no limits.
+	lru *lru
+
+	// ttl is the default time to live of the files, or 0 if they don't expire. expires contains
+	// the expiry times of the files that expire.
+	ttl     time.Duration
+	expires map[string]time.Time
+
+	// sweepAt is a time before which no file expires. Once it has passed, the operations that
+	// depend on the set of files (List and Generation) sweep the expired files first, so that
+	// expired files disappear and the generations change at the same time.
+	sweepAt time.Time
+
+	// zeroCopy is true if the data of Save and Load is not copied (see OptionZeroCopy).
+	zeroCopy bool
+
+	// persister persists the content to a file. It is nil if the Memory isn't persisted.
+	persister *persister
+	closeOnce sync.Once
+
+	// index contains the entries of every directory that contains files, including the root.
+	index map[string]*dirIndex
+
+	// now returns the current time. It is replaced in tests.
+	now func() time.Time
+}
+
+// Validate checks a configuration of the Memory storage.
+func Validate(conf *stor.Conf) error {
+	for _, option := range []string{OptionMaxBytes, OptionMaxObjects} {
+		if value := conf.Options[option]; value != "" {
+			limit, err := strconv.ParseInt(value, 10, 64)
+			if err != nil || limit < 0 {
+				return &stor.ConfError{Type: MemoryStorageType,
+					Field: "Options[" + option + "]", Msg: "must be a positive number"}
+			}
+		}
+	}
+	if value := conf.Options[OptionTTL]; value != "" {
+		ttl, err := time.ParseDuration(value)
+		if err != nil || ttl < 0 {
+			return &stor.ConfError{Type: MemoryStorageType,
+				Field: "Options[" + OptionTTL + "]", Msg: "must be a positive duration"}
+		}
+	}
+	if value := conf.Options[OptionPersistInterval]; value != "" {
+		interval, err := time.ParseDuration(value)
+		if err != nil || interval < 0 {
+			return &stor.ConfError{Type: MemoryStorageType,
+				Field: "Options[" + OptionPersistInterval + "]", Msg: "must be a positive duration"}
+		}
+		if conf.Options[OptionPersistFile] == "" {
+			return &stor.ConfError{Type: MemoryStorageType,
+				Field: "Options[" + OptionPersistInterval + "]", Msg: "requires " + OptionPersistFile}
+		}
+	}
+	if value := conf.Options[OptionZeroCopy]; value != "" {
+		if _, err := strconv.ParseBool(value); err != nil {
+			return &stor.ConfError{Type: MemoryStorageType,
+				Field: "Options[" + OptionZeroCopy + "]", Msg: "must be true or false"}
+		}
+	}
+	switch conf.Options[OptionEvictPolicy] {
+	case "", EvictLRU, EvictReject:
+	default:
+		return &stor.ConfError{Type: MemoryStorageType,
+			Field: "Options[" + OptionEvictPolicy + "]", Msg: "must be lru or reject"}
+	}
+	return nil
 }
 
-// New creates a new Memory storage.
-// The supplied configuration has not effect on the created Memory object.
+// New creates a new Memory storage. The path of the configuration has no effect, and the options
+// set the limits of the Memory (see OptionMaxBytes, OptionMaxObjects and OptionEvictPolicy), and
+// the default TTL of its files (see OptionTTL). With OptionZeroCopy, the data is shared with the
+// callers of Save and Load. With OptionPersistFile, the content is restored from the file.
 func New(conf *stor.Conf) (*Memory, error) {
+	if err := Validate(conf); err != nil {
+		return nil, err
+	}
+
 	mem := &Memory{
-		data: make(map[string][]byte),
+		data:        make(map[string][]byte),
+		generations: make(map[string]uint64),
+		evictPolicy: EvictLRU,
+		expires:     make(map[string]time.Time),
+		index:       map[string]*dirIndex{"": newDirIndex()},
+		now:         time.Now,
+	}
+	mem.maxBytes, _ = strconv.ParseInt(conf.Options[OptionMaxBytes], 10, 64)
+	mem.maxObjects, _ = strconv.ParseInt(conf.Options[OptionMaxObjects], 10, 64)
+	if policy := conf.Options[OptionEvictPolicy]; policy != "" {
+		mem.evictPolicy = policy
+	}
+	if mem.maxBytes > 0 || mem.maxObjects > 0 {
+		mem.lru = newLRU()
+	}
+	mem.ttl, _ = time.ParseDuration(conf.Options[OptionTTL])
+	mem.zeroCopy, _ = strconv.ParseBool(conf.Options[OptionZeroCopy])
+
+	if persistFile := conf.Options[OptionPersistFile]; persistFile != "" {
+		interval, _ := time.ParseDuration(conf.Options[OptionPersistInterval])
+		mem.persister = &persister{
+			file:     persistFile,
+			interval: interval,
+			done:     make(chan struct{}),
+			stopped:  make(chan struct{}),
+		}
+		if err := mem.loadPersisted(); err != nil {
+			return nil, fmt.Errorf("Unable to restore memory from %v: %v", persistFile, err)
+		}
+		if interval > 0 {
+			go mem.persistLoop()
+		}
 	}
 	return mem, nil
 }
 
+// Type returns MemoryStorageType.
+func (m *Memory) Type() stor.Type {
+	return MemoryStorageType
+}
+
 // Meta returns meta information about a file.
 func (m *Memory) Meta(filePath string) (*stor.Meta, error) {
 	cleanPath, err := stor.CleanPath(filePath)
@@ -41,7 +245,9 @@ func (m *Memory) Meta(filePath string) (*stor.Meta, error) {
 		return nil, err
 	}
 
-	data, ok := m.data[cleanPath]
+	m.mutex.RLock()
+	data, ok := m.lookup(cleanPath)
+	m.mutex.RUnlock()
 	if !ok {
 		return nil, &stor.PathDoesntExistError{Path: cleanPath}
 	}
@@ -60,38 +266,80 @@ func (m *Memory) List(filePath string) ([]string, []string, error) {
 		return []string{}, []string{}, err
 	}
 
-	var prefix string
-	if cleanPath == "" {
-		prefix = cleanPath
-	} else {
-		prefix = cleanPath + "/"
-	}
+	m.sweepIfDue()
 
 	files := make([]string, 0)
-	dirsMap := make(map[string]bool)
-	for key := range m.data {
-		if !strings.HasPrefix(key, prefix) {
-			continue
+	dirs := make([]string, 0)
+	m.mutex.RLock()
+	if index := m.index[cleanPath]; index != nil {
+		now := m.now()
+		for name := range index.files {
+			if filePath := path.Join(cleanPath, name); !m.isExpired(filePath, now) {
+				files = append(files, filePath)
+			}
+		}
+		// Every indexed directory contains a file. The file may have expired since the sweep,
+		// but this is the same race as when it expires right after List.
+		for name := range index.dirs {
+			dirs = append(dirs, path.Join(cleanPath, name))
 		}
+	}
+	m.mutex.RUnlock()
+
+	sort.Strings(files)
+	sort.Strings(dirs)
+	return files, dirs, nil
+}
+
+// ListIsSorted marks List as sorted (see stor.SortedLister).
+func (m *Memory) ListIsSorted() {}
+
+// ListWithMeta returns the files within the specified directory with their Meta, and the
+// subdirectories. The Memory doesn't track modification times, so the ModTime is zero.
+func (m *Memory) ListWithMeta(filePath string) ([]stor.Entry, []string, error) {
+	files, dirs, err := m.List(filePath)
+	if err != nil {
+		return []stor.Entry{}, []string{}, err
+	}
 
-		withoutPrefix := key[len(prefix):]
-		slashIdx := strings.Index(withoutPrefix, "/")
-		if slashIdx < 0 {
-			fullPath := prefix + withoutPrefix
-			files = append(files, fullPath)
-		} else {
-			fullPath := prefix + withoutPrefix[:slashIdx]
-			dirsMap[fullPath] = true
+	entries := make([]stor.Entry, 0, len(files))
+	m.mutex.RLock()
+	for _, file := range files {
+		// Skip the files that are deleted or expire after they are listed
+		if data, ok := m.lookup(file); ok {
+			entries = append(entries, stor.Entry{Path: file, Meta: stor.Meta{Size: int64(len(data))}})
 		}
 	}
+	m.mutex.RUnlock()
+	return entries, dirs, nil
+}
 
-	// Convert the map with directories to a slice. We used the map to avoid duplicates
-	dirs := make([]string, 0, len(dirsMap))
-	for dir := range dirsMap {
-		dirs = append(dirs, dir)
+// ListRecursive returns the paths of all files within a directory and its subdirectories, sorted
+// lexicographically. The files are taken from the directory index. If there are more than
+// maxEntries files, then a stor.TooLargeError is returned.
+func (m *Memory) ListRecursive(dirPath string, maxEntries int) ([]string, error) {
+	cleanPath, err := stor.CleanPath(dirPath)
+	if err != nil {
+		return []string{}, err
 	}
 
-	return files, dirs, nil
+	m.sweepIfDue()
+
+	files := []string{}
+	m.mutex.RLock()
+	now := m.now()
+	for _, filePath := range m.filesWithin(cleanPath) {
+		if !m.isExpired(filePath, now) {
+			files = append(files, filePath)
+		}
+	}
+	m.mutex.RUnlock()
+
+	if len(files) > maxEntries {
+		return []string{}, &stor.TooLargeError{What: "recursive list of " + cleanPath}
+	}
+	sort.Strings(files)
+	return files, nil
 }
 
 // Load loads the content of the specified file. If the file is larger than maxSize, the an error is
@@ -102,7 +350,8 @@ func (m *Memory) Load(filePath string, maxSize int64) ([]byte, error) {
 		return []byte{}, err
 	}
 
-	dataInStorage, ok := m.data[cleanPath]
+	// Saved data is never modified, only replaced, so it can be copied without the lock
+	dataInStorage, ok := m.get(cleanPath)
 	if !ok {
 		return []byte{}, &stor.PathDoesntExistError{Path: cleanPath}
 	}
@@ -111,21 +360,169 @@ func (m *Memory) Load(filePath string, maxSize int64) ([]byte, error) {
 		return []byte{}, &stor.TooLargeError{What: cleanPath}
 	}
 
-	dataCopy := make([]byte, len(dataInStorage))
-	copy(dataCopy, dataInStorage)
+	return m.copyData(dataInStorage), nil
+}
+
+// copyData returns a copy of data, or data itself if the Memory is in zero-copy mode (see
+// OptionZeroCopy). The result is never nil.
+func (m *Memory) copyData(data []byte) []byte {
+	if m.zeroCopy && data != nil {
+		return data
+	}
 
-	return dataCopy, nil
+	dataCopy := make([]byte, len(data))
+	copy(dataCopy, data)
+	return dataCopy
 }
 
-// Save saves the data to the specified file.
+// get returns the data of a file, and marks the file as used.
+func (m *Memory) get(cleanPath string) ([]byte, bool) {
+	if m.lru == nil {
+		m.mutex.RLock()
+		defer m.mutex.RUnlock()
+		return m.lookup(cleanPath)
+	}
+
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	data, ok := m.lookup(cleanPath)
+	if ok {
+		m.lru.touch(cleanPath)
+	}
+	return data, ok
+}
+
+// Save saves the data to the specified file. If the file doesn't fit within the limits of the
+// Memory, then least recently used files are evicted, or a CapacityError is returned (see
+// OptionEvictPolicy).
 func (m *Memory) Save(filePath string, data []byte) error {
 	cleanPath, err := stor.CleanPath(filePath)
 	if err != nil {
 		return err
 	}
 
-	m.data[cleanPath] = make([]byte, len(data))
-	copy(m.data[cleanPath], data)
+	stored := m.copyData(data)
+
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	if err := m.makeRoom(cleanPath, int64(len(stored))); err != nil {
+		return err
+	}
+	m.store(cleanPath, stored, m.ttl)
+
+	return nil
+}
+
+// Create saves the data to the specified file, if it doesn't exist yet. The limits apply like for
+// Save.
+func (m *Memory) Create(filePath string, data []byte) error {
+	cleanPath, err := stor.CleanPath(filePath)
+	if err != nil {
+		return err
+	}
+
+	stored := m.copyData(data)
+
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	if _, ok := m.lookup(cleanPath); ok {
+		return &stor.AlreadyExistsError{Path: cleanPath}
+	}
+	if err := m.makeRoom(cleanPath, int64(len(stored))); err != nil {
+		return err
+	}
+	m.store(cleanPath, stored, m.ttl)
+
+	return nil
+}
+
+// CopyFrom copies a file from another Memory, or within the same Memory. The data is shared
+// instead of copied, because saved data is never modified. It returns false for other Storages.
+// The limits apply like for Save.
+func (m *Memory) CopyFrom(src stor.Reader, filePath string) (bool, error) {
+	srcMemory, ok := src.(*Memory)
+	if !ok {
+		return false, nil
+	}
+
+	cleanPath, err := stor.CleanPath(filePath)
+	if err != nil {
+		return true, err
+	}
+
+	data, ok := srcMemory.get(cleanPath)
+	if !ok {
+		return true, &stor.PathDoesntExistError{Path: cleanPath}
+	}
+
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	if err := m.makeRoom(cleanPath, int64(len(data))); err != nil {
+		return true, err
+	}
+	m.store(cleanPath, data, m.ttl)
+
+	return true, nil
+}
+
+// Rename moves all files within the directory oldPath to the directory newPath. The paths must not
+// be equal or nested.
+func (m *Memory) Rename(oldPath, newPath string) error {
+	cleanOld, err := stor.CleanPath(oldPath)
+	if err != nil {
+		return err
+	}
+
+	cleanNew, err := stor.CleanPath(newPath)
+	if err != nil {
+		return err
+	}
+
+	if cleanOld == "" || cleanNew == "" {
+		return &stor.InvalidPathError{Path: oldPath, Msg: "can't rename the root directory"}
+	}
+
+	// Files that are moved must not be moved onto files that are still to be moved
+	if cleanOld == cleanNew || strings.HasPrefix(cleanNew, cleanOld+"/") ||
+		strings.HasPrefix(cleanOld, cleanNew+"/") {
+		msg := fmt.Sprintf("can't rename %s to %s, the paths are nested", cleanOld, cleanNew)
+		return &stor.InvalidPathError{Path: newPath, Msg: msg}
+	}
+
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	// Collect the keys first, so keys that are moved aren't visited again
+	keys := m.filesWithin(cleanOld)
+
+	now := m.now()
+	for _, key := range keys {
+		if m.isExpired(key, now) {
+			m.remove(key)
+			continue
+		}
+
+		newKey := cleanNew + key[len(cleanOld):]
+		data := m.data[key]
+		delete(m.data, key)
+		m.indexRemove(key)
+		old, exists := m.data[newKey]
+		m.bytes -= int64(len(old))
+		m.data[newKey] = data
+		if !exists {
+			m.indexAdd(newKey)
+		}
+		if m.lru != nil {
+			m.lru.rename(key, newKey)
+		}
+		delete(m.expires, newKey)
+		if expires, ok := m.expires[key]; ok {
+			delete(m.expires, key)
+			m.expires[newKey] = expires
+		}
+		m.bumpGenerations(key)
+		m.bumpGenerations(newKey)
+	}
 
 	return nil
 }
@@ -137,12 +534,64 @@ func (m *Memory) Delete(filePath string) error {
 		return err
 	}
 
-	if _, ok := m.data[cleanPath]; !ok {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	if _, ok := m.lookup(cleanPath); !ok {
 		return &stor.PathDoesntExistError{
 			Path: cleanPath,
 		}
 	}
 
-	delete(m.data, cleanPath)
+	m.remove(cleanPath)
 	return nil
 }
+
+// Generation returns the generation of a directory. It changes whenever a file within the
+// directory or one of its subdirectories is saved, renamed, deleted, or expires.
+func (m *Memory) Generation(dirPath string) (uint64, error) {
+	cleanPath, err := stor.CleanPath(dirPath)
+	if err != nil {
+		return 0, err
+	}
+
+	m.sweepIfDue()
+	m.mutex.RLock()
+	defer m.mutex.RUnlock()
+	return m.generations[cleanPath], nil
+}
+
+// Stats returns the statistics of all files within a directory and its subdirectories. Expired
+// files that haven't been swept yet are skipped.
+func (m *Memory) Stats(dirPath string) (*stor.StorageStats, error) {
+	cleanPath, err := stor.CleanPath(dirPath)
+	if err != nil {
+		return nil, err
+	}
+
+	m.sweepIfDue()
+
+	stats := stor.NewStorageStats()
+	m.mutex.RLock()
+	defer m.mutex.RUnlock()
+	now := m.now()
+	for _, filePath := range m.filesWithin(cleanPath) {
+		if !m.isExpired(filePath, now) {
+			stats.Add(cleanPath, filePath, int64(len(m.data[filePath])))
+		}
+	}
+	return stats, nil
+}
+
+// bumpGenerations increments the generations of all directories that contain a file. The mutex
+// must be held for writing.
+func (m *Memory) bumpGenerations(cleanPath string) {
+	for {
+		idx := strings.LastIndexByte(cleanPath, '/')
+		if idx < 0 {
+			m.generations[""]++
+			return
+		}
+		cleanPath = cleanPath[:idx]
+		m.generations[cleanPath]++
+	}
+}
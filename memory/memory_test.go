@@ -1,7 +1,17 @@
 package memory
 
 import (
+	"bytes"
+	"encoding/gob"
+	"errors"
+	"io/ioutil"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strconv"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/suite"
 
@@ -17,9 +27,897 @@ func TestMemoryStorageTester(t *testing.T) {
 		}
 	}
 
+	report := tester.NewReport(MemoryStorageType)
 	testSuite := &tester.StorageTester{
 		ConfFactory: myConfFactory,
+		Report:      report,
 	}
 
 	suite.Run(t, testSuite)
+
+	if len(report.Tests(tester.ResultPassed)) == 0 || !report.Passed() {
+		t.Errorf("Unexpected conformance report: %v", report.Results)
+	}
+
+	// The Memory doesn't have CapabilityDirs, so its tests are skipped
+	expected := map[stor.Capability]tester.Result{
+		stor.CapabilityRename:     tester.ResultPassed,
+		stor.CapabilitySortedList: tester.ResultPassed,
+		stor.CapabilityDirs:       tester.ResultSkipped,
+	}
+	for capability, result := range expected {
+		if report.Capabilities[capability] != result {
+			t.Errorf("Unexpected result for %s: %v", capability, report.Capabilities[capability])
+		}
+	}
+
+	tester.RegisterConformance(report)
+	info, err := stor.TypeInfo(MemoryStorageType)
+	if err != nil || info.Conformance[stor.CapabilityRename] != tester.ResultPassed {
+		t.Errorf("Unexpected conformance in Info: %v, %v", info.Conformance, err)
+	}
+}
+
+// TestMemoryFixturesStorageTester calls the generic storage tests with alternative fixtures
+func TestMemoryFixturesStorageTester(t *testing.T) {
+	fixtures := map[string]tester.Fixture{
+		"deep":     tester.DeepFixture(20),
+		"wide":     tester.WideFixture(2000),
+		"empty":    tester.EmptyFilesFixture(),
+		"longpath": tester.LongPathFixture(),
+		"unusual":  tester.UnusualNamesFixture(),
+	}
+
+	for name, fixture := range fixtures {
+		testSuite := &tester.StorageTester{
+			ConfFactory: func() *stor.Conf {
+				return &stor.Conf{Type: MemoryStorageType}
+			},
+			Fixture: fixture,
+		}
+		t.Run(name, func(t *testing.T) {
+			suite.Run(t, testSuite)
+		})
+	}
+}
+
+func TestMemoryGeneration(t *testing.T) {
+	mem, _ := New(&stor.Conf{})
+
+	generations := func() []uint64 {
+		result := []uint64{}
+		for _, dir := range []string{"", "dir1", "dir1/dir2", "dir3"} {
+			gen, err := mem.Generation(dir)
+			if err != nil {
+				t.Fatalf("Generation(%q) failed: %v", dir, err)
+			}
+			result = append(result, gen)
+		}
+		return result
+	}
+
+	before := generations()
+	if err := mem.Save("dir1/dir2/file1", []byte("test123")); err != nil {
+		t.Fatal(err)
+	}
+	after := generations()
+
+	for i, changed := range []bool{true, true, true, false} {
+		if (before[i] != after[i]) != changed {
+			t.Errorf("Generation %d: before %d, after %d", i, before[i], after[i])
+		}
+	}
+
+	if err := mem.Delete("dir1/dir2/file1"); err != nil {
+		t.Fatal(err)
+	}
+	if generations()[2] == after[2] {
+		t.Errorf("Delete didn't change the generation")
+	}
+}
+
+// TestMemoryLimitsStorageTester calls the generic storage tests with limits that the tests don't
+// reach, so the bookkeeping of the limits is used but no files are evicted
+func TestMemoryLimitsStorageTester(t *testing.T) {
+	testSuite := &tester.StorageTester{
+		ConfFactory: func() *stor.Conf {
+			return &stor.Conf{Type: MemoryStorageType, Options: map[string]string{
+				OptionMaxBytes: "1073741824", OptionMaxObjects: "100000"}}
+		},
+	}
+	suite.Run(t, testSuite)
+}
+
+func TestMemoryEvictLRU(t *testing.T) {
+	mem, err := New(&stor.Conf{Options: map[string]string{OptionMaxBytes: "10"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, filePath := range []string{"file1", "file2", "dir1/file3"} {
+		if err := mem.Save(filePath, []byte("123")); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// Loading file1 makes file2 the least recently used file
+	if _, err := mem.Load("file1", 100); err != nil {
+		t.Fatal(err)
+	}
+	if err := mem.Save("file4", []byte("1234")); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := mem.Meta("file2"); !stor.IsPathDoesntExistError(err) {
+		t.Errorf("file2 wasn't evicted: %v", err)
+	}
+	for _, filePath := range []string{"file1", "dir1/file3", "file4"} {
+		if _, err := mem.Meta(filePath); err != nil {
+			t.Errorf("%s was evicted: %v", filePath, err)
+		}
+	}
+
+	// Overwriting a file only needs room for the difference
+	if err := mem.Save("file4", []byte("12")); err != nil {
+		t.Fatal(err)
+	}
+	if files, _, _ := mem.List(""); len(files) != 2 {
+		t.Errorf("Unexpected files after overwriting: %v", files)
+	}
+
+	// A file that is larger than the limit evicts nothing
+	err = mem.Save("file5", []byte("12345678901"))
+	if !IsCapacityError(err) || !stor.IsInsufficientSpaceError(err) {
+		t.Errorf("Unexpected error for a file larger than the limit: %v", err)
+	}
+	if _, err := mem.Meta("file1"); err != nil {
+		t.Errorf("file1 was evicted: %v", err)
+	}
+}
+
+func TestMemoryEvictReject(t *testing.T) {
+	mem, err := New(&stor.Conf{Options: map[string]string{OptionMaxObjects: "2",
+		OptionEvictPolicy: EvictReject}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := mem.Save("file1", []byte("123")); err != nil {
+		t.Fatal(err)
+	}
+	if err := mem.Create("file2", []byte("123")); err != nil {
+		t.Fatal(err)
+	}
+
+	err = mem.Save("file3", []byte("123"))
+	var capacityErr *CapacityError
+	if !errors.As(err, &capacityErr) {
+		t.Fatalf("Expected a CapacityError, got %v", err)
+	}
+	if *capacityErr != (CapacityError{Path: "file3", Limit: "objects", Max: 2}) {
+		t.Errorf("Unexpected CapacityError: %+v", capacityErr)
+	}
+	if err := mem.Create("file3", []byte("123")); !IsCapacityError(err) {
+		t.Errorf("Expected a CapacityError from Create, got %v", err)
+	}
+
+	// Overwriting doesn't add a file, and deleting makes room again
+	if err := mem.Save("file1", []byte("1234")); err != nil {
+		t.Error(err)
+	}
+	if err := mem.Delete("file2"); err != nil {
+		t.Fatal(err)
+	}
+	if err := mem.Save("file3", []byte("123")); err != nil {
+		t.Error(err)
+	}
+}
+
+// TestMemoryRenameNested verifies that Rename rejects paths that are equal or nested, instead of
+// moving files onto files that are still to be moved.
+func TestMemoryRenameNested(t *testing.T) {
+	mem, err := New(&stor.Conf{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, filePath := range []string{"a/x", "a/b/x"} {
+		if err := mem.Save(filePath, []byte(filePath)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	for _, paths := range [][2]string{{"a", "a/b"}, {"a/b", "a"}, {"a", "a"}, {"a", "./a/"}} {
+		if err := mem.Rename(paths[0], paths[1]); !stor.IsInvalidPathError(err) {
+			t.Errorf("Rename %s to %s: %v", paths[0], paths[1], err)
+		}
+	}
+
+	files, err := stor.ListRecursive(mem, "", 100)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(files, []string{"a/b/x", "a/x"}) {
+		t.Errorf("Unexpected files: %v", files)
+	}
+}
+
+func TestMemoryEvictRename(t *testing.T) {
+	mem, err := New(&stor.Conf{Options: map[string]string{OptionMaxObjects: "2"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, filePath := range []string{"dir1/file1", "file2"} {
+		if err := mem.Save(filePath, []byte("123")); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// The renamed file keeps its position, so it is evicted first
+	if err := mem.Rename("dir1", "dir2"); err != nil {
+		t.Fatal(err)
+	}
+	if err := mem.Save("file3", []byte("123")); err != nil {
+		t.Fatal(err)
+	}
+	files, dirs, err := mem.List("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(dirs) != 0 || len(files) != 2 {
+		t.Errorf("Unexpected entries after eviction: %v, %v", files, dirs)
+	}
+}
+
+func TestMemoryValidate(t *testing.T) {
+	for _, options := range []map[string]string{
+		{OptionMaxBytes: "-1"},
+		{OptionMaxBytes: "1k"},
+		{OptionMaxObjects: "many"},
+		{OptionEvictPolicy: "fifo"},
+		{OptionTTL: "-1s"},
+		{OptionTTL: "soon"},
+	} {
+		if _, err := New(&stor.Conf{Options: options}); !stor.IsConfError(err) {
+			t.Errorf("Expected a ConfError for %v, got %v", options, err)
+		}
+	}
+
+	if _, err := New(&stor.Conf{Options: map[string]string{OptionMaxBytes: "0",
+		OptionEvictPolicy: EvictLRU}}); err != nil {
+		t.Error(err)
+	}
+}
+
+// TestMemoryTTLStorageTester calls the generic storage tests with a default TTL that the tests
+// don't reach
+func TestMemoryTTLStorageTester(t *testing.T) {
+	testSuite := &tester.StorageTester{
+		ConfFactory: func() *stor.Conf {
+			return &stor.Conf{Type: MemoryStorageType, Options: map[string]string{OptionTTL: "1h"}}
+		},
+	}
+	suite.Run(t, testSuite)
+}
+
+// TestMemoryTTLDirs verifies that List doesn't return directories of which all files have expired.
+func TestMemoryTTLDirs(t *testing.T) {
+	mem, err := New(&stor.Conf{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	now := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+	mem.now = func() time.Time { return now }
+
+	if err := mem.SaveWithTTL("dir1/dir2/file1", []byte("test123"), time.Minute); err != nil {
+		t.Fatal(err)
+	}
+	if err := mem.Save("dir1/file2", []byte("test123")); err != nil {
+		t.Fatal(err)
+	}
+	if _, dirs, _ := mem.List("dir1"); !reflect.DeepEqual(dirs, []string{"dir1/dir2"}) {
+		t.Errorf("Unexpected directories: %v", dirs)
+	}
+
+	now = now.Add(time.Minute)
+	if _, dirs, _ := mem.List("dir1"); len(dirs) != 0 {
+		t.Errorf("Unexpected directories: %v", dirs)
+	}
+	if _, dirs, _ := mem.List(""); !reflect.DeepEqual(dirs, []string{"dir1"}) {
+		t.Errorf("Unexpected directories: %v", dirs)
+	}
+	if count := mem.index[""].fileCount; count != 1 {
+		t.Errorf("Unexpected file count: %d", count)
+	}
+}
+
+func TestMemoryTTL(t *testing.T) {
+	mem, err := New(&stor.Conf{Options: map[string]string{OptionTTL: "1m"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	now := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+	mem.now = func() time.Time { return now }
+
+	if err := mem.Save("dir1/file1", []byte("test123")); err != nil {
+		t.Fatal(err)
+	}
+	if err := mem.SaveWithTTL("dir1/file2", []byte("test123"), time.Hour); err != nil {
+		t.Fatal(err)
+	}
+	if err := mem.SaveWithTTL("dir1/file3", []byte("test123"), 0); err != nil {
+		t.Fatal(err)
+	}
+
+	now = now.Add(time.Minute)
+	if _, err := mem.Load("dir1/file1", 100); !stor.IsPathDoesntExistError(err) {
+		t.Errorf("Load of an expired file: %v", err)
+	}
+	if _, err := mem.Meta("dir1/file1"); !stor.IsPathDoesntExistError(err) {
+		t.Errorf("Meta of an expired file: %v", err)
+	}
+	if err := mem.Delete("dir1/file1"); !stor.IsPathDoesntExistError(err) {
+		t.Errorf("Delete of an expired file: %v", err)
+	}
+	if files, _, _ := mem.List("dir1"); len(files) != 2 {
+		t.Errorf("Unexpected files: %v", files)
+	}
+
+	// Overwriting a file restarts its TTL
+	if err := mem.Save("dir1/file2", []byte("test456")); err != nil {
+		t.Fatal(err)
+	}
+	now = now.Add(59 * time.Second)
+	if _, err := mem.Load("dir1/file2", 100); err != nil {
+		t.Error(err)
+	}
+	now = now.Add(time.Second)
+	if _, err := mem.Load("dir1/file2", 100); !stor.IsPathDoesntExistError(err) {
+		t.Errorf("Load of an expired file: %v", err)
+	}
+
+	// An expired file can be created again, and isn't moved by Rename
+	if err := mem.Create("dir1/file1", []byte("test123")); err != nil {
+		t.Error(err)
+	}
+	if err := mem.Rename("dir1", "dir2"); err != nil {
+		t.Fatal(err)
+	}
+	files, _, err := mem.List("dir2")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(files) != 2 {
+		t.Errorf("Unexpected files after Rename: %v", files)
+	}
+	now = now.Add(time.Minute)
+	if _, err := mem.Load("dir2/file1", 100); !stor.IsPathDoesntExistError(err) {
+		t.Errorf("A renamed file must keep its TTL: %v", err)
+	}
+	if _, err := mem.Load("dir2/file3", 100); err != nil {
+		t.Errorf("A file without TTL must not expire: %v", err)
+	}
+}
+
+func TestMemorySweep(t *testing.T) {
+	mem, err := New(&stor.Conf{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	now := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+	mem.now = func() time.Time { return now }
+
+	if err := mem.SaveWithTTL("dir1/file1", []byte("test123"), time.Minute); err != nil {
+		t.Fatal(err)
+	}
+	if err := mem.Save("dir1/file2", []byte("test123")); err != nil {
+		t.Fatal(err)
+	}
+	if swept := mem.Sweep(); swept != 0 {
+		t.Errorf("Swept %d files before they expired", swept)
+	}
+
+	before, _ := mem.Generation("dir1")
+	now = now.Add(time.Minute)
+	if swept := mem.Sweep(); swept != 1 {
+		t.Errorf("Swept %d files, expected 1", swept)
+	}
+	if after, _ := mem.Generation("dir1"); after == before {
+		t.Errorf("Sweep didn't change the generation")
+	}
+	if len(mem.data) != 1 || len(mem.expires) != 0 || mem.bytes != 7 {
+		t.Errorf("Unexpected state after Sweep: %d files, %d expiries, %d bytes", len(mem.data),
+			len(mem.expires), mem.bytes)
+	}
+}
+
+// TestMemoryExpireGeneration verifies that the generation changes as soon as an expired file
+// disappears from List, also without a Sweep.
+func TestMemoryExpireGeneration(t *testing.T) {
+	mem, err := New(&stor.Conf{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	now := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+	mem.now = func() time.Time { return now }
+
+	if err := mem.SaveWithTTL("dir1/file1", []byte("test123"), time.Minute); err != nil {
+		t.Fatal(err)
+	}
+	if err := mem.SaveWithTTL("dir1/file2", []byte("test123"), 2*time.Minute); err != nil {
+		t.Fatal(err)
+	}
+	before, _ := mem.Generation("dir1")
+
+	now = now.Add(time.Minute)
+	files, _, err := mem.List("dir1")
+	if err != nil || len(files) != 1 {
+		t.Errorf("Unexpected files after expiry: %v, %v", files, err)
+	}
+	after, _ := mem.Generation("dir1")
+	if after == before {
+		t.Errorf("The generation didn't change when the file expired")
+	}
+	if len(mem.data) != 1 {
+		t.Errorf("The expired file wasn't swept")
+	}
+
+	// No sweep is needed before the next file expires
+	if generation, _ := mem.Generation("dir1"); generation != after {
+		t.Errorf("The generation changed without a change")
+	}
+	now = now.Add(time.Minute)
+	if generation, _ := mem.Generation("dir1"); generation == after {
+		t.Errorf("The generation didn't change when the last file expired")
+	}
+	if len(mem.data) != 0 || len(mem.expires) != 0 {
+		t.Errorf("The expired file wasn't swept")
+	}
+}
+
+func TestMemorySweepEvery(t *testing.T) {
+	mem, err := New(&stor.Conf{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	stop := mem.SweepEvery(time.Millisecond)
+	defer stop()
+
+	if err := mem.SaveWithTTL("file1", []byte("test123"), time.Millisecond); err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 1000; i++ {
+		mem.mutex.RLock()
+		n := len(mem.data)
+		mem.mutex.RUnlock()
+		if n == 0 {
+			stop()
+			return
+		}
+		time.Sleep(time.Millisecond)
+	}
+	t.Errorf("The expired file wasn't swept")
+}
+
+func TestMemoryTTLMakesRoom(t *testing.T) {
+	mem, err := New(&stor.Conf{Options: map[string]string{OptionMaxObjects: "2",
+		OptionEvictPolicy: EvictReject}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	now := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+	mem.now = func() time.Time { return now }
+
+	if err := mem.SaveWithTTL("file1", []byte("test123"), time.Minute); err != nil {
+		t.Fatal(err)
+	}
+	if err := mem.Save("file2", []byte("test123")); err != nil {
+		t.Fatal(err)
+	}
+	if err := mem.Save("file3", []byte("test123")); !IsCapacityError(err) {
+		t.Errorf("Expected a CapacityError, got %v", err)
+	}
+
+	// Expired files are removed before a Save is rejected
+	now = now.Add(time.Minute)
+	if err := mem.Save("file3", []byte("test123")); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestMemorySnapshot(t *testing.T) {
+	mem, err := New(&stor.Conf{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for filePath, content := range tester.StandardFiles {
+		if err := mem.Save(filePath, []byte(content)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := mem.Save("empty", nil); err != nil {
+		t.Fatal(err)
+	}
+	if err := mem.SaveWithTTL("expiring", []byte("test123"), time.Hour); err != nil {
+		t.Fatal(err)
+	}
+	if err := mem.SaveWithTTL("expired", []byte("test123"), time.Nanosecond); err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(time.Millisecond)
+
+	data, err := mem.Snapshot()
+	if err != nil {
+		t.Fatal(err)
+	}
+	restored, err := Restore(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	files, _, err := restored.List("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, filePath := range []string{"empty", "expiring"} {
+		if !contains(files, filePath) {
+			t.Errorf("%s wasn't restored: %v", filePath, files)
+		}
+	}
+	if contains(files, "expired") {
+		t.Errorf("The expired file was restored")
+	}
+	for filePath, content := range tester.StandardFiles {
+		loaded, err := restored.Load(filePath, 1e6)
+		if err != nil || string(loaded) != content {
+			t.Errorf("Load(%q) = %q, %v", filePath, loaded, err)
+		}
+	}
+	if !restored.expires["expiring"].Equal(mem.expires["expiring"]) {
+		t.Errorf("The expiry time wasn't restored")
+	}
+
+	// The snapshot is independent of later changes
+	if err := mem.Save("file1", []byte("changed")); err != nil {
+		t.Fatal(err)
+	}
+	if loaded, _ := restored.Load("file1", 1e6); string(loaded) != tester.StandardFiles["file1"] {
+		t.Errorf("The restored file changed: %q", loaded)
+	}
+}
+
+func TestMemoryRestoreSnapshotLimits(t *testing.T) {
+	mem, err := New(&stor.Conf{Options: map[string]string{OptionMaxObjects: "3"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, filePath := range []string{"file1", "file2", "file3"} {
+		if err := mem.Save(filePath, []byte("test123")); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, err := mem.Load("file1", 100); err != nil {
+		t.Fatal(err)
+	}
+	data, err := mem.Snapshot()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The restored files keep their order of use, so file2 is evicted first
+	restored, err := New(&stor.Conf{Options: map[string]string{OptionMaxObjects: "3"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := restored.Save("old", []byte("test123")); err != nil {
+		t.Fatal(err)
+	}
+	if err := restored.RestoreSnapshot(data); err != nil {
+		t.Fatal(err)
+	}
+	if err := restored.Save("file4", []byte("test123")); err != nil {
+		t.Fatal(err)
+	}
+	files, _, err := restored.List("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(files) != 3 || contains(files, "old") || contains(files, "file2") {
+		t.Errorf("Unexpected files: %v", files)
+	}
+
+	// Too many files for the limit are rejected with EvictReject
+	small, err := New(&stor.Conf{Options: map[string]string{OptionMaxObjects: "2",
+		OptionEvictPolicy: EvictReject}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := small.RestoreSnapshot(data); !IsCapacityError(err) {
+		t.Errorf("Expected a CapacityError, got %v", err)
+	}
+}
+
+func TestMemoryRestoreInvalid(t *testing.T) {
+	for _, data := range [][]byte{nil, []byte("garbage")} {
+		if _, err := Restore(data); err == nil {
+			t.Errorf("Restore(%q) succeeded", data)
+		}
+	}
+
+	invalid := [][]snapshotFile{{{Path: "../file1"}}}
+	for _, files := range invalid {
+		var buf bytes.Buffer
+		if err := gob.NewEncoder(&buf).Encode(&snapshot{Version: snapshotVersion,
+			Files: files}); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := Restore(buf.Bytes()); err == nil {
+			t.Errorf("Restore of %v succeeded", files)
+		}
+	}
+
+	var buf bytes.Buffer
+	if err := gob.NewEncoder(&buf).Encode(&snapshot{Version: 2}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := Restore(buf.Bytes()); err == nil {
+		t.Errorf("Restore of another version succeeded")
+	}
+}
+
+func contains(list []string, s string) bool {
+	for _, item := range list {
+		if item == s {
+			return true
+		}
+	}
+	return false
+}
+
+func TestMemoryIndex(t *testing.T) {
+	mem, err := New(&stor.Conf{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Rebuilds the index from the files, to compare it with the maintained index
+	rebuild := func() map[string]*dirIndex {
+		index := map[string]*dirIndex{"": newDirIndex()}
+		for filePath := range mem.data {
+			dir, name := splitPath(filePath)
+			isFile := true
+			for {
+				if index[dir] == nil {
+					index[dir] = newDirIndex()
+				}
+				if isFile {
+					index[dir].files[name] = true
+				} else {
+					index[dir].dirs[name] = true
+				}
+				index[dir].fileCount++
+				if dir == "" {
+					break
+				}
+				dir, name = splitPath(dir)
+				isFile = false
+			}
+		}
+		return index
+	}
+
+	rnd := rand.New(rand.NewSource(1))
+	paths := []string{"file1", "dir1", "dir1/file1", "dir1/dir2/file1", "dir1/dir2/dir3/file1",
+		"dir3/file1", "dir3/dir1/file2"}
+	for i := 0; i < 2000; i++ {
+		filePath := paths[rnd.Intn(len(paths))]
+		var err error
+		switch rnd.Intn(3) {
+		case 0:
+			err = mem.Save(filePath, []byte("test123"))
+		case 1:
+			err = mem.Delete(filePath)
+		case 2:
+			err = mem.Rename(filePath, paths[rnd.Intn(len(paths))])
+		}
+		if err != nil && !stor.IsPathDoesntExistError(err) && !stor.IsInvalidPathError(err) {
+			t.Fatalf("Operation %d on %s: %v", i, filePath, err)
+		}
+
+		if !reflect.DeepEqual(rebuild(), mem.index) {
+			t.Fatalf("The index differs after operation %d on %s", i, filePath)
+		}
+	}
+}
+
+// TestMemoryZeroCopyStorageTester calls the generic storage tests in zero-copy mode
+func TestMemoryZeroCopyStorageTester(t *testing.T) {
+	testSuite := &tester.StorageTester{
+		ConfFactory: func() *stor.Conf {
+			return &stor.Conf{Type: MemoryStorageType,
+				Options: map[string]string{OptionZeroCopy: "true"}}
+		},
+	}
+	suite.Run(t, testSuite)
+}
+
+func TestMemoryZeroCopy(t *testing.T) {
+	for _, zeroCopy := range []bool{false, true} {
+		mem, err := New(&stor.Conf{Options: map[string]string{
+			OptionZeroCopy: strconv.FormatBool(zeroCopy)}})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		data := []byte("test123")
+		if err := mem.Save("file1", data); err != nil {
+			t.Fatal(err)
+		}
+		loaded, err := mem.Load("file1", 100)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if shared := &loaded[0] == &data[0]; shared != zeroCopy {
+			t.Errorf("zero-copy %v: the loaded data is shared: %v", zeroCopy, shared)
+		}
+
+		// An empty file is never nil
+		if err := mem.Save("file2", nil); err != nil {
+			t.Fatal(err)
+		}
+		if loaded, err := mem.Load("file2", 0); err != nil || loaded == nil {
+			t.Errorf("zero-copy %v: Load of an empty file = %v, %v", zeroCopy, loaded, err)
+		}
+	}
+
+	_, err := New(&stor.Conf{Options: map[string]string{OptionZeroCopy: "yes"}})
+	if !stor.IsConfError(err) {
+		t.Errorf("Expected a ConfError, got %v", err)
+	}
+}
+
+func BenchmarkMemorySaveLoad(b *testing.B) {
+	for _, zeroCopy := range []bool{false, true} {
+		b.Run("zerocopy="+strconv.FormatBool(zeroCopy), func(b *testing.B) {
+			mem, err := New(&stor.Conf{Options: map[string]string{
+				OptionZeroCopy: strconv.FormatBool(zeroCopy)}})
+			if err != nil {
+				b.Fatal(err)
+			}
+			data := make([]byte, 1<<20)
+
+			b.SetBytes(int64(len(data)))
+			b.ReportAllocs()
+			for i := 0; i < b.N; i++ {
+				if err := mem.Save("file1", data); err != nil {
+					b.Fatal(err)
+				}
+				if _, err := mem.Load("file1", int64(len(data))); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
+
+func TestMemoryPersist(t *testing.T) {
+	persistFile := filepath.Join(t.TempDir(), "memory.snapshot")
+	conf := &stor.Conf{Options: map[string]string{OptionPersistFile: persistFile}}
+
+	// The file doesn't exist yet
+	mem, err := New(conf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := mem.Save("dir1/file1", []byte("test123")); err != nil {
+		t.Fatal(err)
+	}
+	if err := mem.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	restored, err := New(conf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if data, err := restored.Load("dir1/file1", 100); err != nil || string(data) != "test123" {
+		t.Errorf("Load after restoring = %q, %v", data, err)
+	}
+
+	// Nothing is written if nothing changed
+	if err := os.Remove(persistFile); err != nil {
+		t.Fatal(err)
+	}
+	if err := restored.Persist(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(persistFile); !os.IsNotExist(err) {
+		t.Errorf("Persist wrote an unchanged Memory: %v", err)
+	}
+	if err := restored.Delete("dir1/file1"); err != nil {
+		t.Fatal(err)
+	}
+	if err := restored.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if restored, err = New(conf); err != nil {
+		t.Fatal(err)
+	}
+	if files, dirs, _ := restored.List(""); len(files) != 0 || len(dirs) != 0 {
+		t.Errorf("Unexpected entries after restoring: %v, %v", files, dirs)
+	}
+
+	// No temporary files are left behind
+	entries, err := ioutil.ReadDir(filepath.Dir(persistFile))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 1 {
+		t.Errorf("Unexpected files next to the persist file: %d", len(entries))
+	}
+}
+
+func TestMemoryPersistInterval(t *testing.T) {
+	persistFile := filepath.Join(t.TempDir(), "memory.snapshot")
+	mem, err := New(&stor.Conf{Options: map[string]string{OptionPersistFile: persistFile,
+		OptionPersistInterval: "1ms"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer mem.Close()
+
+	if err := mem.Save("file1", []byte("test123")); err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 1000; i++ {
+		if _, err := os.Stat(persistFile); err == nil {
+			if err := mem.Close(); err != nil {
+				t.Fatal(err)
+			}
+			return
+		}
+		time.Sleep(time.Millisecond)
+	}
+	t.Errorf("The content wasn't persisted in the background")
+}
+
+func TestMemoryPersistErrors(t *testing.T) {
+	persistFile := filepath.Join(t.TempDir(), "memory.snapshot")
+	if err := ioutil.WriteFile(persistFile, []byte("garbage"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	_, err := New(&stor.Conf{Options: map[string]string{OptionPersistFile: persistFile}})
+	if err == nil {
+		t.Errorf("New with an invalid persist file succeeded")
+	}
+
+	// The directory of the file doesn't exist
+	mem, err := New(&stor.Conf{Options: map[string]string{
+		OptionPersistFile: filepath.Join(persistFile+".dir", "memory.snapshot")}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := mem.Save("file1", []byte("test123")); err != nil {
+		t.Fatal(err)
+	}
+	if err := mem.Close(); err == nil {
+		t.Errorf("Close succeeded without persisting")
+	}
+
+	for _, options := range []map[string]string{
+		{OptionPersistInterval: "1s"},
+		{OptionPersistFile: persistFile, OptionPersistInterval: "often"},
+	} {
+		if _, err := New(&stor.Conf{Options: options}); !stor.IsConfError(err) {
+			t.Errorf("Expected a ConfError for %v, got %v", options, err)
+		}
+	}
+
+	// Without a persist file, Persist and Close do nothing
+	mem, _ = New(&stor.Conf{})
+	if err := mem.Persist(); err != nil {
+		t.Error(err)
+	}
+	if err := mem.Close(); err != nil {
+		t.Error(err)
+	}
 }
@@ -0,0 +1,133 @@
+package memory
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// persister persists the content of a Memory to a file (see OptionPersistFile).
+type persister struct {
+	file     string
+	interval time.Duration
+
+	// mutex serializes Persist, so an older snapshot never replaces a newer one. It protects
+	// persisted, which is the generation of the root at the last Persist.
+	mutex     sync.Mutex
+	persisted uint64
+
+	done    chan struct{}
+	stopped chan struct{}
+}
+
+// loadPersisted restores the content of the Memory from the persist file, if the file exists.
+func (m *Memory) loadPersisted() error {
+	data, err := ioutil.ReadFile(m.persister.file)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if err := m.RestoreSnapshot(data); err != nil {
+		return err
+	}
+
+	m.mutex.RLock()
+	m.persister.persisted = m.generations[""]
+	m.mutex.RUnlock()
+	return nil
+}
+
+// persistLoop persists the content periodically, until Close is called.
+func (m *Memory) persistLoop() {
+	defer close(m.persister.stopped)
+
+	ticker := time.NewTicker(m.persister.interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-m.persister.done:
+			return
+		case <-ticker.C:
+			// Errors are retried at the next tick, and returned by Close at the latest
+			m.Persist()
+		}
+	}
+}
+
+// Persist writes a snapshot of the content of the Memory (see Snapshot) to the persist file (see
+// OptionPersistFile), unless nothing changed since the last Persist. The file is replaced
+// atomically, so it always contains a complete snapshot. Persist does nothing if the Memory has
+// no persist file.
+func (m *Memory) Persist() error {
+	if m.persister == nil {
+		return nil
+	}
+
+	m.persister.mutex.Lock()
+	defer m.persister.mutex.Unlock()
+
+	m.mutex.RLock()
+	generation := m.generations[""]
+	m.mutex.RUnlock()
+	if generation == m.persister.persisted {
+		return nil
+	}
+
+	data, err := m.Snapshot()
+	if err != nil {
+		return err
+	}
+	if err := writeFileAtomic(m.persister.file, data); err != nil {
+		return err
+	}
+
+	// The snapshot may contain changes after generation, which are then persisted again
+	m.persister.persisted = generation
+	return nil
+}
+
+// Close stops the periodic persisting, and persists the content a last time. It does nothing if
+// the Memory has no persist file. The Memory can still be used afterwards, but its changes are
+// only persisted by calling Persist.
+func (m *Memory) Close() error {
+	if m.persister == nil {
+		return nil
+	}
+
+	if m.persister.interval > 0 {
+		m.closeOnce.Do(func() {
+			close(m.persister.done)
+			<-m.persister.stopped
+		})
+	}
+	return m.Persist()
+}
+
+// writeFileAtomic writes data to a temporary file next to fileName, and renames it to fileName.
+func writeFileAtomic(fileName string, data []byte) error {
+	file, err := ioutil.TempFile(filepath.Dir(fileName), filepath.Base(fileName)+".tmp")
+	if err != nil {
+		return err
+	}
+	tempName := file.Name()
+
+	_, err = file.Write(data)
+	if err == nil {
+		err = file.Sync()
+	}
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Rename(tempName, fileName)
+	}
+	if err != nil {
+		os.Remove(tempName)
+	}
+	return err
+}
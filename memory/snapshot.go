@@ -0,0 +1,132 @@
+package memory
+
+import (
+	"bytes"
+	"encoding/gob"
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/pw1/stor"
+)
+
+// snapshotVersion is the version of the format of the snapshots. Restore rejects other versions.
+const snapshotVersion = 1
+
+// snapshot is the gob-encoded content of a Memory.
+type snapshot struct {
+	Version int
+	Files   []snapshotFile
+}
+
+// snapshotFile is a file within a snapshot. Expires is zero if the file doesn't expire.
+type snapshotFile struct {
+	Path    string
+	Data    []byte
+	Expires time.Time
+}
+
+// Snapshot serializes the content of the Memory, so it can be restored with Restore, e.g. to
+// capture test fixtures, or to keep a warm cache across restarts. The files are captured at a
+// single moment. Expired files are left out, and the expiry times of the other files are kept.
+// The files are ordered from the least to the most recently used, so a restored Memory evicts
+// them in the same order. The options of the Memory (e.g. its limits) are not part of the
+// snapshot.
+func (m *Memory) Snapshot() ([]byte, error) {
+	m.mutex.RLock()
+	defer m.mutex.RUnlock()
+
+	paths := make([]string, 0, len(m.data))
+	if m.lru != nil {
+		for element := m.lru.order.Back(); element != nil; element = element.Prev() {
+			paths = append(paths, element.Value.(string))
+		}
+	} else {
+		for cleanPath := range m.data {
+			paths = append(paths, cleanPath)
+		}
+		sort.Strings(paths)
+	}
+
+	snap := snapshot{Version: snapshotVersion, Files: make([]snapshotFile, 0, len(paths))}
+	now := m.now()
+	for _, cleanPath := range paths {
+		if m.isExpired(cleanPath, now) {
+			continue
+		}
+		snap.Files = append(snap.Files, snapshotFile{
+			Path:    cleanPath,
+			Data:    m.data[cleanPath],
+			Expires: m.expires[cleanPath],
+		})
+	}
+
+	var buf bytes.Buffer
+	if err := gob.NewEncoder(&buf).Encode(&snap); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// Restore creates a new Memory with the content of a snapshot that was taken with Snapshot. The
+// new Memory has no limits and no default TTL; use New and RestoreSnapshot for those.
+func Restore(data []byte) (*Memory, error) {
+	mem, err := New(&stor.Conf{Type: MemoryStorageType})
+	if err != nil {
+		return nil, err
+	}
+
+	if err := mem.RestoreSnapshot(data); err != nil {
+		return nil, err
+	}
+	return mem, nil
+}
+
+// RestoreSnapshot replaces the content of the Memory with the content of a snapshot that was taken
+// with Snapshot. Files that expired since the snapshot was taken are left out. The limits of the
+// Memory apply as if the files were saved one by one, so the least recently used files may be
+// evicted. If a file is rejected (see EvictReject), then the CapacityError is returned, and the
+// Memory contains the files that were restored until then.
+func (m *Memory) RestoreSnapshot(data []byte) error {
+	var snap snapshot
+	if err := gob.NewDecoder(bytes.NewReader(data)).Decode(&snap); err != nil {
+		return fmt.Errorf("Invalid memory snapshot: %v", err)
+	}
+	if snap.Version != snapshotVersion {
+		return fmt.Errorf("Unsupported memory snapshot version %d", snap.Version)
+	}
+	for i, file := range snap.Files {
+		cleanPath, err := stor.CleanPath(file.Path)
+		if err != nil {
+			return fmt.Errorf("Invalid memory snapshot: %v", err)
+		}
+		snap.Files[i].Path = cleanPath
+	}
+
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	for cleanPath := range m.data {
+		m.remove(cleanPath)
+	}
+
+	now := m.now()
+	for _, file := range snap.Files {
+		if !file.Expires.IsZero() && !now.Before(file.Expires) {
+			continue
+		}
+
+		fileData := file.Data
+		if fileData == nil {
+			fileData = []byte{}
+		}
+		if err := m.makeRoom(file.Path, int64(len(fileData))); err != nil {
+			return err
+		}
+		m.store(file.Path, fileData, 0)
+		if !file.Expires.IsZero() {
+			m.setExpiry(file.Path, file.Expires)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,125 @@
+package memory
+
+import (
+	"sync"
+	"time"
+
+	"github.com/pw1/stor"
+)
+
+// SaveWithTTL saves the data to the specified file, like Save, but the file expires after ttl
+// instead of after the default TTL of the Memory (see OptionTTL). If ttl is 0 or negative, then
+// the file doesn't expire. An expired file is no longer returned by any operation. It is swept
+// (which changes the generations of its directories) by the first List, Stats or Generation after
+// it has expired, or else by the next Sweep.
+func (m *Memory) SaveWithTTL(filePath string, data []byte, ttl time.Duration) error {
+	cleanPath, err := stor.CleanPath(filePath)
+	if err != nil {
+		return err
+	}
+
+	stored := m.copyData(data)
+
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	if err := m.makeRoom(cleanPath, int64(len(stored))); err != nil {
+		return err
+	}
+	m.store(cleanPath, stored, ttl)
+
+	return nil
+}
+
+// Sweep removes all expired files, and returns how many it removed.
+func (m *Memory) Sweep() int {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	return m.sweep()
+}
+
+// SweepEvery sweeps the expired files periodically in the background. Call the returned function
+// to stop.
+func (m *Memory) SweepEvery(interval time.Duration) (stop func()) {
+	done := make(chan struct{})
+	ticker := time.NewTicker(interval)
+
+	go func() {
+		defer ticker.Stop()
+		for {
+			select {
+			case <-done:
+				return
+			case <-ticker.C:
+				m.Sweep()
+			}
+		}
+	}()
+
+	var once sync.Once
+	return func() { once.Do(func() { close(done) }) }
+}
+
+// sweep removes all expired files, and returns how many it removed. The mutex must be held for
+// writing.
+func (m *Memory) sweep() int {
+	now := m.now()
+	swept := 0
+	m.sweepAt = time.Time{}
+	for cleanPath, expires := range m.expires {
+		if !now.Before(expires) {
+			m.remove(cleanPath)
+			swept++
+		} else if m.sweepAt.IsZero() || expires.Before(m.sweepAt) {
+			m.sweepAt = expires
+		}
+	}
+	return swept
+}
+
+// sweepIfDue sweeps the expired files if a file may have expired since the last sweep. The mutex
+// must not be held.
+func (m *Memory) sweepIfDue() {
+	m.mutex.RLock()
+	due := m.sweepDue()
+	m.mutex.RUnlock()
+	if !due {
+		return
+	}
+
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	if m.sweepDue() {
+		m.sweep()
+	}
+}
+
+// sweepDue checks whether a file may have expired since the last sweep. The mutex must be held.
+func (m *Memory) sweepDue() bool {
+	return len(m.expires) > 0 && !m.now().Before(m.sweepAt)
+}
+
+// setExpiry sets the time at which the file at cleanPath expires. The mutex must be held for
+// writing.
+func (m *Memory) setExpiry(cleanPath string, expires time.Time) {
+	m.expires[cleanPath] = expires
+	if expires.Before(m.sweepAt) {
+		m.sweepAt = expires
+	}
+}
+
+// isExpired checks whether the file at cleanPath has expired at now. The mutex must be held.
+func (m *Memory) isExpired(cleanPath string, now time.Time) bool {
+	expires, ok := m.expires[cleanPath]
+	return ok && !now.Before(expires)
+}
+
+// lookup returns the data of the file at cleanPath, unless it doesn't exist or has expired. The
+// mutex must be held.
+func (m *Memory) lookup(cleanPath string) ([]byte, bool) {
+	data, ok := m.data[cleanPath]
+	if !ok || m.isExpired(cleanPath, m.now()) {
+		return nil, false
+	}
+	return data, true
+}
@@ -0,0 +1,193 @@
+// Package merkle builds Merkle trees over the files in a stor.Storage and compares them.
+//
+// Every file in the tree is represented by the SHA-256 hash of its content. Every directory is
+// represented by a hash that is computed from the names and hashes of its children. Two trees can
+// therefore be compared by only descending into subtrees whose hashes differ, which makes
+// comparing large, mostly unchanged hierarchies cheap.
+package merkle
+
+import (
+	"crypto/sha256"
+	"path"
+	"sort"
+
+	"github.com/pw1/stor"
+)
+
+// Hash is a SHA-256 hash of a file or a directory.
+type Hash [sha256.Size]byte
+
+// Node is a file or a directory in a Merkle tree.
+type Node struct {
+	// Path is the slash-separated path of the file or directory in the Storage.
+	Path string
+
+	// Dir indicates whether the Node is a directory.
+	Dir bool
+
+	// Hash is the hash of the file content, or the hash computed from the children of a
+	// directory.
+	Hash Hash
+
+	// Children contains the entries within a directory, keyed by their name. It is nil for files.
+	Children map[string]*Node
+}
+
+// Build builds a Merkle tree of the directory prefix in r. Every file within the directory (and
+// its subdirectories) is loaded to compute its hash. The maxSize argument is passed on to
+// r.Load(). The returned Node is the directory node for prefix.
+func Build(r stor.Reader, prefix string, maxSize int64) (*Node, error) {
+	cleanPrefix, err := stor.CleanPath(prefix)
+	if err != nil {
+		return nil, err
+	}
+
+	return buildDir(r, cleanPrefix, maxSize)
+}
+
+func buildDir(r stor.Reader, dirPath string, maxSize int64) (*Node, error) {
+	files, dirs, err := r.List(dirPath)
+	if err != nil {
+		return nil, err
+	}
+
+	node := &Node{
+		Path:     dirPath,
+		Dir:      true,
+		Children: make(map[string]*Node, len(files)+len(dirs)),
+	}
+
+	for _, filePath := range files {
+		data, err := r.Load(filePath, maxSize)
+		if err != nil {
+			return nil, err
+		}
+		node.Children[path.Base(filePath)] = &Node{
+			Path: filePath,
+			Hash: sha256.Sum256(data),
+		}
+	}
+
+	for _, subDir := range dirs {
+		child, err := buildDir(r, subDir, maxSize)
+		if err != nil {
+			return nil, err
+		}
+		node.Children[path.Base(subDir)] = child
+	}
+
+	node.Hash = dirHash(node.Children)
+	return node, nil
+}
+
+// dirHash computes the hash of a directory from its children.
+func dirHash(children map[string]*Node) Hash {
+	names := make([]string, 0, len(children))
+	for name := range children {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	h := sha256.New()
+	for _, name := range names {
+		child := children[name]
+		if child.Dir {
+			h.Write([]byte{'d'})
+		} else {
+			h.Write([]byte{'f'})
+		}
+		h.Write([]byte(name))
+		h.Write([]byte{0})
+		h.Write(child.Hash[:])
+	}
+
+	var sum Hash
+	copy(sum[:], h.Sum(nil))
+	return sum
+}
+
+// ChangeType indicates how a path differs between two trees.
+type ChangeType int
+
+const (
+	// Added indicates that a path only exists in the new tree.
+	Added ChangeType = iota
+
+	// Removed indicates that a path only exists in the old tree.
+	Removed
+
+	// Modified indicates that a file has different content in both trees.
+	Modified
+)
+
+func (c ChangeType) String() string {
+	switch c {
+	case Added:
+		return "added"
+	case Removed:
+		return "removed"
+	case Modified:
+		return "modified"
+	default:
+		return "unknown"
+	}
+}
+
+// Change describes a difference between two trees.
+type Change struct {
+	// Path is the path of the file or directory that differs. For an added or removed directory,
+	// only the directory itself is reported, not its content.
+	Path string
+
+	// Dir indicates whether Path is a directory.
+	Dir bool
+
+	// Type indicates how the path differs.
+	Type ChangeType
+}
+
+// Diff compares the trees oldTree and newTree and returns the differences, sorted by path.
+// Subtrees with equal hashes are not visited, so the cost of Diff is proportional to the number
+// of changes rather than to the size of the trees. The trees are compared by the names of their
+// entries, so the root nodes may have different paths (e.g. to compare two prefixes).
+func Diff(oldTree, newTree *Node) []Change {
+	changes := []Change{}
+	diffNodes(oldTree, newTree, &changes)
+
+	sort.Slice(changes, func(i, j int) bool {
+		return changes[i].Path < changes[j].Path
+	})
+	return changes
+}
+
+func diffNodes(oldNode, newNode *Node, changes *[]Change) {
+	if oldNode.Hash == newNode.Hash && oldNode.Dir == newNode.Dir {
+		return
+	}
+
+	if !oldNode.Dir && !newNode.Dir {
+		*changes = append(*changes, Change{Path: newNode.Path, Type: Modified})
+		return
+	}
+
+	if oldNode.Dir != newNode.Dir {
+		*changes = append(*changes, Change{Path: oldNode.Path, Dir: oldNode.Dir, Type: Removed})
+		*changes = append(*changes, Change{Path: newNode.Path, Dir: newNode.Dir, Type: Added})
+		return
+	}
+
+	for name, oldChild := range oldNode.Children {
+		newChild, ok := newNode.Children[name]
+		if !ok {
+			*changes = append(*changes, Change{Path: oldChild.Path, Dir: oldChild.Dir, Type: Removed})
+			continue
+		}
+		diffNodes(oldChild, newChild, changes)
+	}
+
+	for name, newChild := range newNode.Children {
+		if _, ok := oldNode.Children[name]; !ok {
+			*changes = append(*changes, Change{Path: newChild.Path, Dir: newChild.Dir, Type: Added})
+		}
+	}
+}
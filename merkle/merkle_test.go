@@ -0,0 +1,96 @@
+package merkle
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/suite"
+
+	"github.com/pw1/stor"
+	"github.com/pw1/stor/memory"
+)
+
+func TestMerkleSuite(t *testing.T) {
+	suite.Run(t, new(MerkleSuite))
+}
+
+// MerkleSuite contains the tests for building and comparing Merkle trees.
+type MerkleSuite struct {
+	suite.Suite
+	st *memory.Memory
+}
+
+func (s *MerkleSuite) SetupTest() {
+	s.st, _ = memory.New(&stor.Conf{})
+
+	files := map[string]string{
+		"file1":           "test123",
+		"dir1/file2":      "test456",
+		"dir1/file3":      "test789",
+		"dir1/dir4/file5": "test788909",
+		"dir2/dir3/file4": "test0123",
+	}
+	for filePath, content := range files {
+		s.Require().Nil(s.st.Save(filePath, []byte(content)))
+	}
+}
+
+func (s *MerkleSuite) build(prefix string) *Node {
+	tree, err := Build(s.st, prefix, 1e6)
+	s.Require().Nil(err)
+	return tree
+}
+
+func (s *MerkleSuite) TestBuild() {
+	tree := s.build("")
+	s.True(tree.Dir)
+	s.Equal("", tree.Path)
+	s.Len(tree.Children, 3)
+	s.Equal("dir1/dir4/file5", tree.Children["dir1"].Children["dir4"].Children["file5"].Path)
+}
+
+func (s *MerkleSuite) TestBuildInvalidPath() {
+	tree, err := Build(s.st, "../dir1", 1e6)
+	s.Nil(tree)
+	s.True(stor.IsInvalidPathError(err))
+}
+
+func (s *MerkleSuite) TestBuildTooLarge() {
+	tree, err := Build(s.st, "", 3)
+	s.Nil(tree)
+	s.True(stor.IsTooLargeError(err))
+}
+
+func (s *MerkleSuite) TestDiffEqual() {
+	s.Empty(Diff(s.build(""), s.build("")))
+}
+
+func (s *MerkleSuite) TestDiff() {
+	oldTree := s.build("")
+
+	s.Nil(s.st.Save("dir1/file2", []byte("changed")))
+	s.Nil(s.st.Save("dir1/dir4/file6", []byte("new")))
+	s.Nil(s.st.Delete("dir2/dir3/file4"))
+	s.Nil(s.st.Save("dir5/file7", []byte("new")))
+
+	s.Equal([]Change{
+		{Path: "dir1/dir4/file6", Type: Added},
+		{Path: "dir1/file2", Type: Modified},
+		{Path: "dir2", Dir: true, Type: Removed},
+		{Path: "dir5", Dir: true, Type: Added},
+	}, Diff(oldTree, s.build("")))
+}
+
+// TestDiffPrefixes verifies that two different subtrees with the same content are equal.
+func (s *MerkleSuite) TestDiffPrefixes() {
+	s.Nil(s.st.Save("copy/file2", []byte("test456")))
+	s.Nil(s.st.Save("copy/file3", []byte("test789")))
+	s.Nil(s.st.Save("copy/dir4/file5", []byte("test788909")))
+
+	s.Empty(Diff(s.build("dir1"), s.build("copy")))
+}
+
+func (s *MerkleSuite) TestChangeTypeString() {
+	s.Equal("added", Added.String())
+	s.Equal("removed", Removed.String())
+	s.Equal("modified", Modified.String())
+}
@@ -0,0 +1,209 @@
+package migrate
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"math"
+	"sort"
+
+	"github.com/pw1/stor"
+	"github.com/pw1/stor/ratelimit"
+)
+
+const (
+	// DefaultCheckpointPath is the default path of the checkpoint of Copy in the destination.
+	DefaultCheckpointPath = ".stor-migrate-checkpoint"
+
+	// DefaultCheckpointEvery is the default number of copied files after which Copy saves the
+	// checkpoint.
+	DefaultCheckpointEvery = 100
+
+	// checkpointVersion is the version of the checkpoint format.
+	checkpointVersion = 1
+)
+
+// CopyOptions configures Copy.
+type CopyOptions struct {
+	// CheckpointPath is the path of the checkpoint in the destination. The default is
+	// DefaultCheckpointPath.
+	CheckpointPath string
+
+	// CheckpointEvery is the number of copied files after which the checkpoint is saved. The
+	// default (0) is DefaultCheckpointEvery.
+	CheckpointEvery int
+
+	// BytesPerSecond limits the number of bytes that is saved to the destination per second. The
+	// default (0) is unlimited.
+	BytesPerSecond float64
+
+	// Verify loads every file back from the destination after it has been saved, and compares it
+	// with the source. A difference is reported as a stor.CorruptDataError.
+	Verify bool
+
+	// OnProgress is called after every file, if it is set.
+	OnProgress func(Progress)
+}
+
+// checkpoint records the progress of Copy in the destination.
+type checkpoint struct {
+	Version int `json:"version"`
+
+	// Last is the last file that has been copied. The files are copied in lexicographical order,
+	// so all files up to and including Last have been copied.
+	Last string `json:"last"`
+
+	Copied int64 `json:"copied"`
+	Bytes  int64 `json:"bytes"`
+}
+
+// Copy copies all files of src to dst, and overwrites files that already exist in dst. Unlike a
+// Migration, it doesn't serve the files meanwhile; it is meant for offline migrations of large
+// storages.
+//
+// The files are copied in lexicographical order, and the progress is saved regularly in a
+// checkpoint in dst. If Copy is interrupted, e.g. by a crash or an error, then calling it again
+// with the same arguments resumes after the last file in the checkpoint, instead of copying all
+// files again. The checkpoint is deleted when Copy has completed. Files that are added to src
+// during an interrupted Copy are only copied if they come after the checkpoint.
+//
+// The returned Progress includes the files that were copied by previous, interrupted calls.
+// Skipped counts the files that were deleted from src since they were listed.
+func Copy(src, dst stor.Storage, options CopyOptions) (Progress, error) {
+	checkpointPath := options.CheckpointPath
+	if checkpointPath == "" {
+		checkpointPath = DefaultCheckpointPath
+	}
+	checkpointPath, err := stor.CleanPath(checkpointPath)
+	if err != nil {
+		return Progress{}, err
+	}
+	checkpointEvery := options.CheckpointEvery
+	if checkpointEvery <= 0 {
+		checkpointEvery = DefaultCheckpointEvery
+	}
+
+	var saver stor.Saver = dst
+	if options.BytesPerSecond != 0 {
+		limited, err := ratelimit.New(dst, ratelimit.Limits{
+			WriteBytes: ratelimit.Rate{PerSecond: options.BytesPerSecond},
+		})
+		if err != nil {
+			return Progress{}, err
+		}
+		saver = limited
+	}
+
+	state, err := loadCheckpoint(dst, checkpointPath)
+	if err != nil {
+		return Progress{}, err
+	}
+
+	files, err := listAll(src, "")
+	if err != nil {
+		return Progress{}, fmt.Errorf("failed to list the source storage: %v", err)
+	}
+	sort.Strings(files)
+
+	progress := Progress{Total: int64(len(files)), Copied: state.Copied, Bytes: state.Bytes}
+	uncheckpointed := 0
+	for _, file := range files {
+		if file <= state.Last || file == checkpointPath {
+			continue
+		}
+
+		size, err := copyFile(src, dst, saver, file, options.Verify)
+		if err != nil {
+			err = fmt.Errorf("failed to copy %s: %v", file, err)
+			if uncheckpointed > 0 {
+				if checkpointErr := saveCheckpoint(dst, checkpointPath, state); checkpointErr != nil {
+					err = fmt.Errorf("%v (and failed to save the checkpoint: %v)", err, checkpointErr)
+				}
+			}
+			return progress, err
+		}
+
+		if size < 0 {
+			progress.Skipped++
+		} else {
+			progress.Copied++
+			progress.Bytes += size
+		}
+		state = checkpoint{Last: file, Copied: progress.Copied, Bytes: progress.Bytes}
+		if uncheckpointed++; uncheckpointed >= checkpointEvery {
+			if err := saveCheckpoint(dst, checkpointPath, state); err != nil {
+				return progress, fmt.Errorf("failed to save the checkpoint: %v", err)
+			}
+			uncheckpointed = 0
+		}
+
+		if options.OnProgress != nil {
+			options.OnProgress(progress)
+		}
+	}
+
+	if err := dst.Delete(checkpointPath); err != nil && !stor.IsPathDoesntExistError(err) {
+		return progress, fmt.Errorf("failed to delete the checkpoint: %v", err)
+	}
+	progress.Done = true
+	return progress, nil
+}
+
+// copyFile copies a file from src to dst, saving it through saver, and verifies the copy in dst if
+// verify is set. Returns the size of the copied file, or -1 if it no longer exists in src.
+func copyFile(src, dst stor.Storage, saver stor.Saver, file string, verify bool) (int64, error) {
+	data, err := src.Load(file, math.MaxInt64)
+	if stor.IsPathDoesntExistError(err) {
+		return -1, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+
+	if err := saver.Save(file, data); err != nil {
+		return 0, err
+	}
+
+	if verify {
+		copied, err := dst.Load(file, math.MaxInt64)
+		if err != nil {
+			return 0, err
+		}
+		if !bytes.Equal(data, copied) {
+			return 0, &stor.CorruptDataError{Path: file, Msg: "the copy differs from the source"}
+		}
+	}
+	return int64(len(data)), nil
+}
+
+// loadCheckpoint loads the checkpoint from dst. If it doesn't exist, then Copy starts from the
+// beginning.
+func loadCheckpoint(dst stor.Storage, checkpointPath string) (checkpoint, error) {
+	data, err := dst.Load(checkpointPath, math.MaxInt64)
+	if stor.IsPathDoesntExistError(err) {
+		return checkpoint{Version: checkpointVersion}, nil
+	}
+	if err != nil {
+		return checkpoint{}, fmt.Errorf("failed to load the checkpoint: %v", err)
+	}
+
+	var state checkpoint
+	if err := json.Unmarshal(data, &state); err != nil {
+		return checkpoint{}, fmt.Errorf("checkpoint %s is corrupt: %v", checkpointPath, err)
+	}
+	if state.Version != checkpointVersion {
+		return checkpoint{}, fmt.Errorf("checkpoint %s has unsupported version %d", checkpointPath,
+			state.Version)
+	}
+	return state, nil
+}
+
+// saveCheckpoint saves the checkpoint in dst.
+func saveCheckpoint(dst stor.Storage, checkpointPath string, state checkpoint) error {
+	state.Version = checkpointVersion
+	data, err := json.Marshal(state)
+	if err != nil {
+		return err
+	}
+	return dst.Save(checkpointPath, data)
+}
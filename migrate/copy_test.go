@@ -0,0 +1,144 @@
+package migrate
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/suite"
+
+	"github.com/pw1/stor"
+	"github.com/pw1/stor/memory"
+)
+
+func TestCopySuite(t *testing.T) {
+	suite.Run(t, new(CopySuite))
+}
+
+// CopySuite contains the tests for Copy.
+type CopySuite struct {
+	suite.Suite
+	src *memory.Memory
+	dst *memory.Memory
+}
+
+// flakyStorage counts the saves of files other than checkpoints, and fails them once failAfter
+// saves have succeeded. If corrupt is set, then Load returns other content.
+type flakyStorage struct {
+	stor.Storage
+	saves     int
+	failAfter int
+	corrupt   bool
+}
+
+func (f *flakyStorage) Save(filePath string, data []byte) error {
+	if strings.HasSuffix(filePath, "checkpoint") {
+		return f.Storage.Save(filePath, data)
+	}
+	if f.failAfter > 0 && f.saves >= f.failAfter {
+		return errors.New("broken")
+	}
+	f.saves++
+	return f.Storage.Save(filePath, data)
+}
+
+func (f *flakyStorage) Load(filePath string, maxSize int64) ([]byte, error) {
+	data, err := f.Storage.Load(filePath, maxSize)
+	if f.corrupt && err == nil {
+		return append(data, 'x'), nil
+	}
+	return data, err
+}
+
+func (s *CopySuite) SetupTest() {
+	s.src, _ = memory.New(&stor.Conf{})
+	s.dst, _ = memory.New(&stor.Conf{})
+
+	for i := 0; i < 10; i++ {
+		filePath := fmt.Sprintf("dir%d/file%d", i%3, i)
+		s.Require().Nil(s.src.Save(filePath, []byte(filePath)))
+	}
+}
+
+// requireCopied verifies that dst contains exactly the files of src.
+func (s *CopySuite) requireCopied() {
+	files, err := listAll(s.dst, "")
+	s.Require().Nil(err)
+	s.Len(files, 10)
+	for _, file := range files {
+		data, err := s.dst.Load(file, 1e6)
+		s.Nil(err)
+		s.Equal(file, string(data))
+	}
+}
+
+func (s *CopySuite) TestCopy() {
+	s.Require().Nil(s.dst.Save("dir1/file1", []byte("stale")))
+
+	progress, err := Copy(s.src, s.dst, CopyOptions{Verify: true, BytesPerSecond: 1e6})
+	s.Require().Nil(err)
+	s.Equal(Progress{Total: 10, Copied: 10, Bytes: 100, Done: true}, progress)
+	s.requireCopied()
+}
+
+func (s *CopySuite) TestResume() {
+	dst := &flakyStorage{Storage: s.dst, failAfter: 5}
+	progress, err := Copy(s.src, dst, CopyOptions{CheckpointEvery: 2})
+	s.EqualError(err, "failed to copy dir1/file4: broken")
+	s.Equal(int64(5), progress.Copied)
+
+	// The checkpoint records all copied files, also the ones after the last regular save
+	data, err := s.dst.Load(DefaultCheckpointPath, 1e6)
+	s.Require().Nil(err)
+	s.JSONEq(`{"version": 1, "last": "dir1/file1", "copied": 5, "bytes": 50}`, string(data))
+
+	dst = &flakyStorage{Storage: s.dst}
+	var reported []int64
+	progress, err = Copy(s.src, dst, CopyOptions{CheckpointEvery: 2,
+		OnProgress: func(p Progress) { reported = append(reported, p.Copied) }})
+	s.Require().Nil(err)
+	s.Equal(5, dst.saves, "the copied files are skipped")
+	s.Equal(Progress{Total: 10, Copied: 10, Bytes: 100, Done: true}, progress)
+	s.Equal([]int64{6, 7, 8, 9, 10}, reported)
+	s.requireCopied()
+
+	_, err = s.dst.Meta(DefaultCheckpointPath)
+	s.True(stor.IsPathDoesntExistError(err), "the checkpoint is deleted")
+}
+
+func (s *CopySuite) TestVerify() {
+	dst := &flakyStorage{Storage: s.dst, corrupt: true}
+	_, err := Copy(s.src, dst, CopyOptions{})
+	s.Nil(err)
+
+	_, err = Copy(s.src, dst, CopyOptions{Verify: true})
+	s.EqualError(err, "failed to copy dir0/file0: data of dir0/file0 is corrupt: the copy differs "+
+		"from the source")
+}
+
+func (s *CopySuite) TestCheckpointPath() {
+	dst := &flakyStorage{Storage: s.dst, failAfter: 1}
+	_, err := Copy(s.src, dst, CopyOptions{CheckpointPath: "migration/checkpoint"})
+	s.NotNil(err)
+	_, err = s.dst.Meta("migration/checkpoint")
+	s.Nil(err)
+
+	_, err = Copy(s.src, s.dst, CopyOptions{CheckpointPath: "../checkpoint"})
+	s.True(stor.IsInvalidPathError(err))
+}
+
+func (s *CopySuite) TestInvalidCheckpoint() {
+	s.Require().Nil(s.dst.Save(DefaultCheckpointPath, []byte("{")))
+	_, err := Copy(s.src, s.dst, CopyOptions{})
+	s.Contains(fmt.Sprint(err), "is corrupt")
+
+	s.Require().Nil(s.dst.Save(DefaultCheckpointPath, []byte(`{"version": 2}`)))
+	_, err = Copy(s.src, s.dst, CopyOptions{})
+	s.Contains(fmt.Sprint(err), "unsupported version 2")
+}
+
+func (s *CopySuite) TestInvalidBandwidth() {
+	_, err := Copy(s.src, s.dst, CopyOptions{BytesPerSecond: -1})
+	s.NotNil(err)
+}
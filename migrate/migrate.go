@@ -0,0 +1,301 @@
+// Package migrate implements a stor.Storage wrapper for live migrations from one storage to
+// another, e.g. from a LocalDir to S3, without downtime.
+//
+// During a migration, reads try the new storage first and fall back to the old storage. Writes go
+// to both storages (so the old storage remains usable to roll back), or only to the new storage.
+// Meanwhile, Drain copies the files that only exist in the old storage to the new storage. Once
+// Drain has completed, the old storage can be retired.
+//
+// For offline migrations, Copy copies all files to another storage with a checkpoint, so an
+// interrupted copy resumes where it left off.
+package migrate
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"os"
+	"sync"
+
+	"github.com/pw1/stor"
+)
+
+// Mode determines where a Migration writes to.
+type Mode int
+
+const (
+	// WriteBoth writes to the new and the old storage. This is the default.
+	WriteBoth Mode = iota
+
+	// WriteNew only writes to the new storage. Deletes still go to both storages, because the file
+	// would otherwise reappear from the old storage.
+	WriteNew
+)
+
+// Progress reports the progress of Drain.
+type Progress struct {
+	// Total is the number of files in the old storage. It is known once Drain has listed them.
+	Total int64
+
+	// Copied is the number of files that has been copied to the new storage.
+	Copied int64
+
+	// Skipped is the number of files that already existed in the new storage, or that have been
+	// deleted since they were listed.
+	Skipped int64
+
+	// Bytes is the number of bytes that has been copied.
+	Bytes int64
+
+	// Done indicates that Drain has completed successfully.
+	Done bool
+}
+
+// Migration is a stor.Storage wrapper that migrates files from an old to a new storage.
+type Migration struct {
+	old  stor.Storage
+	new  stor.Storage
+	mode Mode
+
+	// mutex serializes writes and the copies of Drain, so a copy never overwrites a newer write.
+	mutex sync.Mutex
+
+	progressMutex sync.Mutex
+	progress      Progress
+}
+
+// New creates a new Migration from oldStorage to newStorage.
+func New(oldStorage, newStorage stor.Storage, mode Mode) (*Migration, error) {
+	if mode != WriteBoth && mode != WriteNew {
+		return nil, fmt.Errorf("Invalid migration mode %d", mode)
+	}
+
+	m := &Migration{
+		old:  oldStorage,
+		new:  newStorage,
+		mode: mode,
+	}
+	return m, nil
+}
+
+// Progress returns the progress of Drain.
+func (m *Migration) Progress() Progress {
+	m.progressMutex.Lock()
+	defer m.progressMutex.Unlock()
+
+	return m.progress
+}
+
+// updateProgress applies update to the progress.
+func (m *Migration) updateProgress(update func(p *Progress)) {
+	m.progressMutex.Lock()
+	defer m.progressMutex.Unlock()
+
+	update(&m.progress)
+}
+
+// Drain copies all files that only exist in the old storage to the new storage. It blocks until
+// all files have been copied; run it in a separate goroutine to migrate in the background, and use
+// Progress to follow it. Drain can be called again after it failed, e.g. after a restart; files
+// that have already been copied are skipped.
+func (m *Migration) Drain() error {
+	m.updateProgress(func(p *Progress) {
+		*p = Progress{}
+	})
+
+	files, err := listAll(m.old, "")
+	if err != nil {
+		return fmt.Errorf("failed to list the old storage: %v", err)
+	}
+	m.updateProgress(func(p *Progress) {
+		p.Total = int64(len(files))
+	})
+
+	for _, file := range files {
+		size, err := m.copy(file)
+		if err != nil {
+			return fmt.Errorf("failed to copy %s: %v", file, err)
+		}
+
+		m.updateProgress(func(p *Progress) {
+			if size < 0 {
+				p.Skipped++
+			} else {
+				p.Copied++
+				p.Bytes += size
+			}
+		})
+	}
+
+	m.updateProgress(func(p *Progress) {
+		p.Done = true
+	})
+	return nil
+}
+
+// copy copies a file from the old to the new storage, unless it already exists in the new storage,
+// or no longer exists in the old storage. Returns the size of the copied file, or -1 if it was
+// skipped.
+func (m *Migration) copy(cleanPath string) (int64, error) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	if _, err := m.new.Meta(cleanPath); err == nil {
+		return -1, nil
+	} else if !stor.IsPathDoesntExistError(err) {
+		return 0, err
+	}
+
+	data, err := m.old.Load(cleanPath, math.MaxInt64)
+	if stor.IsPathDoesntExistError(err) {
+		return -1, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+
+	if err := m.new.Save(cleanPath, data); err != nil {
+		return 0, err
+	}
+	return int64(len(data)), nil
+}
+
+// listAll returns all files within a directory of s, and its subdirectories.
+func listAll(s stor.Storage, dirPath string) ([]string, error) {
+	files, dirs, err := list(s, dirPath)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, dir := range dirs {
+		subFiles, err := listAll(s, dir)
+		if err != nil {
+			return nil, err
+		}
+		files = append(files, subFiles...)
+	}
+	return files, nil
+}
+
+// list lists a directory of s. A directory that doesn't exist is empty.
+func list(s stor.Storage, dirPath string) ([]string, []string, error) {
+	files, dirs, err := s.List(dirPath)
+	if stor.IsPathDoesntExistError(err) || errors.Is(err, os.ErrNotExist) {
+		return []string{}, []string{}, nil
+	}
+	return files, dirs, err
+}
+
+// Meta returns meta information about a file in the new storage, or else in the old storage.
+func (m *Migration) Meta(filePath string) (*stor.Meta, error) {
+	meta, err := m.new.Meta(filePath)
+	if stor.IsPathDoesntExistError(err) {
+		return m.old.Meta(filePath)
+	}
+	return meta, err
+}
+
+// List returns the files and subdirectories within the specified directory in both storages.
+func (m *Migration) List(dirPath string) ([]string, []string, error) {
+	cleanPath, err := stor.CleanPath(dirPath)
+	if err != nil {
+		return []string{}, []string{}, err
+	}
+
+	newFiles, newDirs, err := list(m.new, cleanPath)
+	if err != nil {
+		return []string{}, []string{}, err
+	}
+	oldFiles, oldDirs, err := list(m.old, cleanPath)
+	if err != nil {
+		return []string{}, []string{}, err
+	}
+
+	return merge(newFiles, oldFiles), merge(newDirs, oldDirs), nil
+}
+
+// merge returns the union of a and b.
+func merge(a, b []string) []string {
+	seen := make(map[string]bool, len(a))
+	for _, s := range a {
+		seen[s] = true
+	}
+	for _, s := range b {
+		if !seen[s] {
+			seen[s] = true
+			a = append(a, s)
+		}
+	}
+	return a
+}
+
+// Load loads the content of the specified file from the new storage, or else from the old storage.
+func (m *Migration) Load(filePath string, maxSize int64) ([]byte, error) {
+	data, err := m.new.Load(filePath, maxSize)
+	if stor.IsPathDoesntExistError(err) {
+		return m.old.Load(filePath, maxSize)
+	}
+	return data, err
+}
+
+// Save saves the data to the specified file in the new storage, and in the old storage if the mode
+// is WriteBoth.
+func (m *Migration) Save(filePath string, data []byte) error {
+	cleanPath, err := stor.CleanPath(filePath)
+	if err != nil {
+		return err
+	}
+
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	if err := m.new.Save(cleanPath, data); err != nil {
+		return err
+	}
+	if m.mode == WriteBoth {
+		return m.old.Save(cleanPath, data)
+	}
+	return nil
+}
+
+// Delete removes a file from both storages. It only returns a PathDoesntExistError if the file
+// exists in neither storage.
+func (m *Migration) Delete(filePath string) error {
+	cleanPath, err := stor.CleanPath(filePath)
+	if err != nil {
+		return err
+	}
+
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	newErr := m.new.Delete(cleanPath)
+	if newErr != nil && !stor.IsPathDoesntExistError(newErr) {
+		return newErr
+	}
+
+	oldErr := m.old.Delete(cleanPath)
+	if oldErr != nil && !stor.IsPathDoesntExistError(oldErr) {
+		return oldErr
+	}
+
+	if newErr != nil && oldErr != nil {
+		return &stor.PathDoesntExistError{Path: cleanPath}
+	}
+	return nil
+}
+
+// Type returns the Type of the new storage.
+func (m *Migration) Type() stor.Type {
+	return stor.TypeOf(m.new)
+}
+
+// Close closes the new and the old storage. It returns the first error, after it has tried to close
+// both. A Drain in progress must be finished first.
+func (m *Migration) Close() error {
+	err := stor.Close(m.new)
+	if oldErr := stor.Close(m.old); err == nil {
+		err = oldErr
+	}
+	return err
+}
@@ -0,0 +1,132 @@
+package migrate
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/suite"
+
+	"github.com/pw1/stor"
+	"github.com/pw1/stor/localdir"
+	"github.com/pw1/stor/memory"
+	"github.com/pw1/stor/tester"
+)
+
+// TestMigrationStorageTester calls the generic storage tests, with half of the standard files in
+// the old storage. LocalDir storages are used, because they can't list directories that don't
+// exist.
+func TestMigrationStorageTester(t *testing.T) {
+	for _, mode := range []Mode{WriteBoth, WriteNew} {
+		testSuite := &tester.StorageTester{
+			SetupTestFunc: func(s *tester.StorageTester) {
+				oldStorage, err := localdir.New(&stor.Conf{Path: s.T().TempDir()})
+				s.Require().Nil(err)
+				newStorage, err := localdir.New(&stor.Conf{Path: s.T().TempDir()})
+				s.Require().Nil(err)
+
+				s.Require().Nil(oldStorage.Save("file1", []byte("old content")))
+				s.Require().Nil(oldStorage.Save("dir1/file2", []byte("old content")))
+				s.Require().Nil(oldStorage.Save("dir1/dir4/file5", []byte("old content")))
+
+				st, err := New(oldStorage, newStorage, mode)
+				s.Require().Nil(err)
+				s.Storage = st
+			},
+		}
+
+		suite.Run(t, testSuite)
+	}
+}
+
+func TestMigrationSuite(t *testing.T) {
+	suite.Run(t, new(MigrationSuite))
+}
+
+// MigrationSuite contains tests that are specific for the Migration wrapper.
+type MigrationSuite struct {
+	suite.Suite
+	old *memory.Memory
+	new *memory.Memory
+}
+
+func (s *MigrationSuite) SetupTest() {
+	s.old, _ = memory.New(&stor.Conf{})
+	s.new, _ = memory.New(&stor.Conf{})
+
+	s.Require().Nil(s.old.Save("file1", []byte("old")))
+	s.Require().Nil(s.old.Save("dir/file2", []byte("old")))
+	s.Require().Nil(s.old.Save("dir/sub/file3", []byte("old")))
+}
+
+func (s *MigrationSuite) newMigration(mode Mode) *Migration {
+	m, err := New(s.old, s.new, mode)
+	s.Require().Nil(err)
+	return m
+}
+
+func (s *MigrationSuite) TestNewInvalidMode() {
+	_, err := New(s.old, s.new, Mode(5))
+	s.NotNil(err)
+}
+
+func (s *MigrationSuite) TestReadFallback() {
+	m := s.newMigration(WriteBoth)
+	s.Require().Nil(s.new.Save("file1", []byte("new")))
+
+	data, err := m.Load("file1", 100)
+	s.Nil(err)
+	s.Equal("new", string(data))
+
+	data, err = m.Load("dir/file2", 100)
+	s.Nil(err)
+	s.Equal("old", string(data))
+
+	files, dirs, err := m.List("")
+	s.Nil(err)
+	s.Equal([]string{"file1"}, files)
+	s.Equal([]string{"dir"}, dirs)
+}
+
+func (s *MigrationSuite) TestWriteModes() {
+	m := s.newMigration(WriteBoth)
+	s.Nil(m.Save("file4", []byte("both")))
+	_, err := s.old.Meta("file4")
+	s.Nil(err)
+	_, err = s.new.Meta("file4")
+	s.Nil(err)
+
+	m = s.newMigration(WriteNew)
+	s.Nil(m.Save("file5", []byte("new")))
+	_, err = s.old.Meta("file5")
+	s.True(stor.IsPathDoesntExistError(err))
+	_, err = s.new.Meta("file5")
+	s.Nil(err)
+
+	// Deletes always go to both storages
+	s.Nil(m.Delete("file4"))
+	_, err = m.Meta("file4")
+	s.True(stor.IsPathDoesntExistError(err))
+	s.True(stor.IsPathDoesntExistError(m.Delete("file4")))
+}
+
+func (s *MigrationSuite) TestDrain() {
+	m := s.newMigration(WriteNew)
+	s.Require().Nil(m.Save("file1", []byte("new")))
+
+	s.Nil(m.Drain())
+	s.Equal(Progress{Total: 3, Copied: 2, Skipped: 1, Bytes: 6, Done: true}, m.Progress())
+
+	// The newer write is not overwritten
+	for filePath, expected := range map[string]string{
+		"file1":         "new",
+		"dir/file2":     "old",
+		"dir/sub/file3": "old",
+	} {
+		data, err := s.new.Load(filePath, 100)
+		s.Nil(err)
+		s.Equal(expected, string(data))
+	}
+
+	// A second Drain has nothing left to do
+	s.Nil(m.Drain())
+	s.Equal(Progress{Total: 3, Skipped: 3, Done: true}, m.Progress())
+}
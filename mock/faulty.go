@@ -0,0 +1,197 @@
+package mock
+
+import (
+	"path"
+	"sync"
+	"time"
+
+	"github.com/pw1/stor"
+)
+
+// Faulty is a test double that passes all operations to a base Storage (e.g. a memory.Memory),
+// except when a declared Fault applies. This allows testing error paths and wrappers (e.g.
+// retry.Retry) with a few declarations instead of an expectation for every call:
+//
+//	f := mock.NewFaulty(base)
+//	f.On(stor.OpLoad, "dir1/file1").After(50 * time.Millisecond).
+//		Fail(&stor.PathDoesntExistError{Path: "dir1/file1"})
+//	f.On(stor.OpSave, "").Every(3).Fail(&stor.TemporaryError{Err: errors.New("throttled")})
+//
+// It is safe for concurrent use if the base Storage is.
+type Faulty struct {
+	base   stor.Storage
+	mutex  sync.Mutex
+	faults []*Fault
+
+	// sleep waits for the latency of a Fault. It is replaced in tests.
+	sleep func(time.Duration)
+}
+
+// Fault declares how a Faulty Storage deviates from its base Storage for the calls of an
+// operation on matching paths. Its methods return the Fault, so they can be chained. They must
+// not be called concurrently with the operations of the Faulty Storage.
+type Fault struct {
+	op      string
+	pattern string
+	err     error
+	delay   time.Duration
+	every   int
+	times   int
+
+	// calls is the number of matching calls, and fired the number of calls that the Fault applied
+	// to. Both are protected by the mutex of the Faulty Storage.
+	calls int
+	fired int
+}
+
+// NewFaulty returns a Faulty Storage that passes all operations to base, until Faults are
+// declared with On.
+func NewFaulty(base stor.Storage) *Faulty {
+	return &Faulty{
+		base:  base,
+		sleep: time.Sleep,
+	}
+}
+
+// On declares a Fault for the operation op (e.g. stor.OpLoad) on the paths that match pattern
+// (see path.Match, e.g. "dir1/*"). The empty pattern matches all paths. Without further
+// declarations the Fault has no effect. If several Faults apply to a call, then their latencies
+// add up, and the error of the first one is returned.
+func (f *Faulty) On(op, pattern string) *Fault {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+
+	fault := &Fault{op: op, pattern: pattern}
+	f.faults = append(f.faults, fault)
+	return fault
+}
+
+// Reset removes all Faults, so all operations are passed to the base Storage again.
+func (f *Faulty) Reset() {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+
+	f.faults = nil
+}
+
+// Fail makes the calls to which the Fault applies return err, instead of calling the base Storage.
+func (fault *Fault) Fail(err error) *Fault {
+	fault.err = err
+	return fault
+}
+
+// After delays the calls to which the Fault applies by d, e.g. to simulate a slow backend.
+func (fault *Fault) After(d time.Duration) *Fault {
+	fault.delay = d
+	return fault
+}
+
+// Every makes the Fault apply only to every nth matching call, e.g. the 3rd, 6th, etc.
+func (fault *Fault) Every(n int) *Fault {
+	fault.every = n
+	return fault
+}
+
+// Times makes the Fault apply only to the first n calls to which it would otherwise apply.
+func (fault *Fault) Times(n int) *Fault {
+	fault.times = n
+	return fault
+}
+
+// matches checks whether the Fault is declared for an operation on a path.
+func (fault *Fault) matches(op, filePath string) bool {
+	if fault.op != op {
+		return false
+	}
+	if fault.pattern == "" {
+		return true
+	}
+	matched, _ := path.Match(fault.pattern, filePath)
+	return matched
+}
+
+// inject applies the Faults that match a call: it waits for their latency, and returns the error
+// that the call must return instead of calling the base Storage, if any.
+func (f *Faulty) inject(op, filePath string) error {
+	if cleanPath, err := stor.CleanPath(filePath); err == nil {
+		filePath = cleanPath
+	}
+
+	var delay time.Duration
+	var err error
+	f.mutex.Lock()
+	for _, fault := range f.faults {
+		if !fault.matches(op, filePath) {
+			continue
+		}
+		fault.calls++
+		if fault.every > 0 && fault.calls%fault.every != 0 {
+			continue
+		}
+		if fault.times > 0 && fault.fired >= fault.times {
+			continue
+		}
+		fault.fired++
+
+		delay += fault.delay
+		if err == nil {
+			err = fault.err
+		}
+	}
+	f.mutex.Unlock()
+
+	if delay > 0 {
+		f.sleep(delay)
+	}
+	return err
+}
+
+// Meta returns meta information about a file.
+func (f *Faulty) Meta(filePath string) (*stor.Meta, error) {
+	if err := f.inject(stor.OpMeta, filePath); err != nil {
+		return nil, err
+	}
+	return f.base.Meta(filePath)
+}
+
+// List returns all entries within a directory.
+func (f *Faulty) List(dirPath string) ([]string, []string, error) {
+	if err := f.inject(stor.OpList, dirPath); err != nil {
+		return []string{}, []string{}, err
+	}
+	return f.base.List(dirPath)
+}
+
+// Load a file and return its content.
+func (f *Faulty) Load(filePath string, maxSize int64) ([]byte, error) {
+	if err := f.inject(stor.OpLoad, filePath); err != nil {
+		return []byte{}, err
+	}
+	return f.base.Load(filePath, maxSize)
+}
+
+// Save data to a file.
+func (f *Faulty) Save(filePath string, data []byte) error {
+	if err := f.inject(stor.OpSave, filePath); err != nil {
+		return err
+	}
+	return f.base.Save(filePath, data)
+}
+
+// Delete a file.
+func (f *Faulty) Delete(filePath string) error {
+	if err := f.inject(stor.OpDelete, filePath); err != nil {
+		return err
+	}
+	return f.base.Delete(filePath)
+}
+
+// Type returns the Type of the base Storage.
+func (f *Faulty) Type() stor.Type {
+	return stor.TypeOf(f.base)
+}
+
+// Close closes the base Storage.
+func (f *Faulty) Close() error {
+	return stor.Close(f.base)
+}
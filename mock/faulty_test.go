@@ -0,0 +1,114 @@
+package mock
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/suite"
+
+	"github.com/pw1/stor"
+	"github.com/pw1/stor/memory"
+	"github.com/pw1/stor/tester"
+)
+
+// TestFaultyStorageTester calls the generic storage tests for a Faulty Storage without Faults.
+func TestFaultyStorageTester(t *testing.T) {
+	testSuite := &tester.StorageTester{
+		SetupTestFunc: func(s *tester.StorageTester) {
+			base, _ := memory.New(&stor.Conf{})
+			s.Storage = NewFaulty(base)
+		},
+	}
+
+	suite.Run(t, testSuite)
+}
+
+func TestFaultySuite(t *testing.T) {
+	suite.Run(t, new(FaultySuite))
+}
+
+// FaultySuite contains tests that are specific for the Faulty Storage.
+type FaultySuite struct {
+	suite.Suite
+	faulty *Faulty
+	sleeps []time.Duration
+}
+
+func (s *FaultySuite) SetupTest() {
+	base, _ := memory.New(&stor.Conf{})
+	s.Require().Nil(base.Save("dir1/file1", []byte("test123")))
+	s.Require().Nil(base.Save("dir1/file2", []byte("test456")))
+
+	s.faulty = NewFaulty(base)
+	s.sleeps = nil
+	s.faulty.sleep = func(d time.Duration) {
+		s.sleeps = append(s.sleeps, d)
+	}
+}
+
+func (s *FaultySuite) TestFailAfter() {
+	s.faulty.On(stor.OpLoad, "dir1/file1").After(50 * time.Millisecond).
+		Fail(&stor.PathDoesntExistError{Path: "dir1/file1"})
+
+	data, err := s.faulty.Load("dir1/file1", 1e6)
+	s.True(stor.IsPathDoesntExistError(err))
+	s.Equal([]byte{}, data)
+	s.Equal([]time.Duration{50 * time.Millisecond}, s.sleeps)
+
+	// Other paths and operations are not affected
+	data, err = s.faulty.Load("dir1/file2", 1e6)
+	s.Nil(err)
+	s.Equal("test456", string(data))
+	meta, err := s.faulty.Meta("dir1/file1")
+	s.Nil(err)
+	s.Equal(int64(7), meta.Size)
+	s.Len(s.sleeps, 1)
+}
+
+func (s *FaultySuite) TestEvery() {
+	tempErr := &stor.TemporaryError{Err: errors.New("throttled")}
+	s.faulty.On(stor.OpSave, "").Every(3).Fail(tempErr)
+
+	failed := []int{}
+	for i := 1; i <= 9; i++ {
+		if err := s.faulty.Save("file1", []byte("a")); err != nil {
+			s.True(stor.IsTemporaryError(err))
+			failed = append(failed, i)
+		}
+	}
+	s.Equal([]int{3, 6, 9}, failed)
+}
+
+func (s *FaultySuite) TestTimes() {
+	s.faulty.On(stor.OpDelete, "dir1/*").Times(1).Fail(&stor.StorageUnavailableError{})
+
+	s.True(stor.IsStorageUnavailableError(s.faulty.Delete("dir1/file1")))
+	s.Nil(s.faulty.Delete("dir1/file1"))
+	s.True(stor.IsPathDoesntExistError(s.faulty.Delete("dir1/file1")))
+}
+
+func (s *FaultySuite) TestLatency() {
+	s.faulty.On(stor.OpList, "").After(time.Second)
+	s.faulty.On(stor.OpList, "dir1").After(2 * time.Second)
+
+	files, _, err := s.faulty.List("dir1/")
+	s.Nil(err)
+	s.ElementsMatch([]string{"dir1/file1", "dir1/file2"}, files)
+	s.Equal([]time.Duration{3 * time.Second}, s.sleeps)
+}
+
+func (s *FaultySuite) TestFirstError() {
+	s.faulty.On(stor.OpList, "").Fail(errors.New("first"))
+	s.faulty.On(stor.OpList, "").Fail(errors.New("second"))
+
+	files, dirs, err := s.faulty.List("")
+	s.EqualError(err, "first")
+	s.Equal([]string{}, files)
+	s.Equal([]string{}, dirs)
+
+	s.faulty.Reset()
+	_, dirs, err = s.faulty.List("")
+	s.Nil(err)
+	s.Equal([]string{"dir1"}, dirs)
+}
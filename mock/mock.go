@@ -2,6 +2,7 @@ package mock
 
 import (
 	"github.com/pw1/stor"
+	"github.com/pw1/stor/memory"
 	"github.com/stretchr/testify/mock"
 )
 
@@ -17,9 +18,13 @@ func init() {
 	stor.RegisterType(MockStorageType, newStorageFunc)
 }
 
-// Mock is a mock object for mocking storage in testing.
+// Mock is a mock object for mocking storage in testing. A hybrid Mock (see NewHybrid) delegates
+// the calls without an expectation to a real Storage.
 type Mock struct {
 	mock.Mock
+
+	// base is the Storage to which a hybrid Mock delegates calls. It is nil for a plain Mock.
+	base stor.Storage
 }
 
 // New creates a new storage.Mock
@@ -28,38 +33,135 @@ func New(conf *stor.Conf) (*Mock, error) {
 	return s, nil
 }
 
+// NewHybrid creates a Mock that delegates the calls for which no expectation is set (with On) to
+// base, or to a new memory.Memory if base is nil. All calls are recorded, so they can be asserted
+// (e.g. with AssertCalled). Tests therefore get realistic behavior by default, and only set
+// expectations for the calls they care about. Expectations that are used up (e.g. with Once)
+// don't apply anymore, and the calls are delegated again. A hybrid Mock records the delegated
+// calls without the lock of mock.Mock, so it is not safe for concurrent use.
+func NewHybrid(base stor.Storage) *Mock {
+	if base == nil {
+		base, _ = memory.New(&stor.Conf{})
+	}
+	return &Mock{base: base}
+}
+
+// delegated checks whether a call must be delegated to the base Storage: if the Mock is hybrid,
+// and has no expectation for the call. If so, then it records the call.
+func (m *Mock) delegated(method string, arguments ...interface{}) bool {
+	if m.base == nil {
+		return false
+	}
+
+	for _, call := range m.ExpectedCalls {
+		if call.Method != method || call.Repeatability < 0 {
+			continue
+		}
+		if _, diffCount := call.Arguments.Diff(arguments); diffCount == 0 {
+			return false
+		}
+	}
+
+	m.Calls = append(m.Calls, mock.Call{Parent: &m.Mock, Method: method, Arguments: arguments})
+	return true
+}
+
 // Meta returns all entries within a directory.
 func (m *Mock) Meta(path string) (*stor.Meta, error) {
+	if m.delegated("Meta", path) {
+		return m.base.Meta(path)
+	}
 	args := m.Called(path)
 	return args.Get(0).(*stor.Meta), args.Error(1)
 }
 
 // List returns all entries within a directory.
 func (m *Mock) List(path string) ([]string, []string, error) {
+	if m.delegated("List", path) {
+		return m.base.List(path)
+	}
 	args := m.Called(path)
 	return args.Get(0).([]string), args.Get(1).([]string), args.Error(2)
 }
 
 // Load a file and return its content.
 func (m *Mock) Load(path string, maxSize int64) ([]byte, error) {
+	if m.delegated("Load", path, maxSize) {
+		return m.base.Load(path, maxSize)
+	}
 	args := m.Called(path, maxSize)
 	return args.Get(0).([]byte), args.Error(1)
 }
 
 // Save data to a file.
 func (m *Mock) Save(path string, data []byte) error {
+	if m.delegated("Save", path, data) {
+		return m.base.Save(path, data)
+	}
 	args := m.Called(path, data)
 	return args.Error(0)
 }
 
 // Delete a file.
 func (m *Mock) Delete(path string) error {
+	if m.delegated("Delete", path) {
+		return m.base.Delete(path)
+	}
 	args := m.Called(path)
 	return args.Error(0)
 }
 
 // Type returns the storage.Type of this storega object.
 func (m *Mock) Type() stor.Type {
+	if m.delegated("Type") {
+		return MockStorageType
+	}
 	args := m.Called()
 	return args.Get(0).(stor.Type)
 }
+
+// Close releases the resources of the storage. A hybrid Mock closes its base Storage.
+func (m *Mock) Close() error {
+	if m.delegated("Close") {
+		return stor.Close(m.base)
+	}
+	args := m.Called()
+	return args.Error(0)
+}
+
+// samePath returns an argument matcher for paths that are equal to filePath after cleaning (see
+// stor.CleanPath), so e.g. "dir1/file1" also matches "dir1//file1".
+func samePath(filePath string) interface{} {
+	cleanPath, err := stor.CleanPath(filePath)
+	if err != nil {
+		cleanPath = filePath
+	}
+	return mock.MatchedBy(func(p string) bool {
+		if cleaned, err := stor.CleanPath(p); err == nil {
+			p = cleaned
+		}
+		return p == cleanPath
+	})
+}
+
+// AssertSaved asserts that Save was called for filePath with data. If data is nil, then any data
+// matches. Paths are compared after cleaning.
+func (m *Mock) AssertSaved(t mock.TestingT, filePath string, data []byte) bool {
+	var dataArg interface{} = data
+	if data == nil {
+		dataArg = mock.Anything
+	}
+	return m.AssertCalled(t, "Save", samePath(filePath), dataArg)
+}
+
+// AssertDeleted asserts that Delete was called for filePath. Paths are compared after cleaning.
+func (m *Mock) AssertDeleted(t mock.TestingT, filePath string) bool {
+	return m.AssertCalled(t, "Delete", samePath(filePath))
+}
+
+// AssertNoWrites asserts that neither Save nor Delete was called.
+func (m *Mock) AssertNoWrites(t mock.TestingT) bool {
+	saved := m.AssertNotCalled(t, "Save", mock.Anything, mock.Anything)
+	deleted := m.AssertNotCalled(t, "Delete", mock.Anything)
+	return saved && deleted
+}
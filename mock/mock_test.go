@@ -1,9 +1,12 @@
 package mock
 
 import (
+	"errors"
 	"testing"
 
 	"github.com/pw1/stor"
+	"github.com/pw1/stor/memory"
+	"github.com/pw1/stor/tester"
 	"github.com/stretchr/testify/suite"
 )
 
@@ -25,3 +28,109 @@ func (s *StorMockSuite) TestStorMockAsStorage() {
 	s.NotNil(storage)
 	s.Nil(err)
 }
+
+// TestHybridStorageTester calls the generic storage tests for a hybrid Mock without expectations.
+func TestHybridStorageTester(t *testing.T) {
+	testSuite := &tester.StorageTester{
+		NotConcurrent: true,
+		SetupTestFunc: func(s *tester.StorageTester) {
+			s.Storage = NewHybrid(nil)
+		},
+	}
+
+	suite.Run(t, testSuite)
+}
+
+func TestHybridSuite(t *testing.T) {
+	suite.Run(t, new(HybridSuite))
+}
+
+// HybridSuite contains tests that are specific for a hybrid Mock.
+type HybridSuite struct {
+	suite.Suite
+	mock *Mock
+}
+
+func (s *HybridSuite) SetupTest() {
+	s.mock = NewHybrid(nil)
+	s.Require().Nil(s.mock.Save("dir1/file1", []byte("test123")))
+}
+
+func (s *HybridSuite) TestDelegate() {
+	data, err := s.mock.Load("dir1/file1", 1e6)
+	s.Nil(err)
+	s.Equal("test123", string(data))
+
+	s.mock.AssertCalled(s.T(), "Save", "dir1/file1", []byte("test123"))
+	s.mock.AssertCalled(s.T(), "Load", "dir1/file1", int64(1e6))
+	s.mock.AssertNumberOfCalls(s.T(), "Load", 1)
+	s.mock.AssertNotCalled(s.T(), "Delete", "dir1/file1")
+}
+
+func (s *HybridSuite) TestOverride() {
+	tempErr := &stor.TemporaryError{Err: errors.New("throttled")}
+	s.mock.On("Load", "dir1/file1", int64(1e6)).Return([]byte{}, tempErr).Once()
+
+	_, err := s.mock.Load("dir1/file1", 1e6)
+	s.True(stor.IsTemporaryError(err))
+
+	// Other arguments, and calls after the expectation is used up, are delegated
+	_, err = s.mock.Load("dir1/file1", 1e3)
+	s.Nil(err)
+	data, err := s.mock.Load("dir1/file1", 1e6)
+	s.Nil(err)
+	s.Equal("test123", string(data))
+
+	s.mock.AssertNumberOfCalls(s.T(), "Load", 3)
+	s.mock.AssertExpectations(s.T())
+}
+
+func (s *HybridSuite) TestBase() {
+	base, _ := memory.New(&stor.Conf{})
+	s.Require().Nil(base.Save("file2", []byte("test456")))
+	m := NewHybrid(base)
+
+	files, _, err := m.List("")
+	s.Nil(err)
+	s.Equal([]string{"file2"}, files)
+	s.Equal(MockStorageType, m.Type())
+}
+
+func (s *HybridSuite) TestAssertSaved() {
+	s.Nil(s.mock.Save("dir2//file2/", []byte("test456")))
+
+	s.True(s.mock.AssertSaved(s.T(), "dir1/file1", []byte("test123")))
+	s.True(s.mock.AssertSaved(s.T(), "dir2/file2", nil))
+	s.True(s.mock.AssertSaved(s.T(), "dir2/file2", []byte("test456")))
+
+	t := new(testing.T)
+	s.False(s.mock.AssertSaved(t, "dir1/file1", []byte("test456")))
+	s.False(s.mock.AssertSaved(t, "dir1/file2", nil))
+	s.True(t.Failed())
+}
+
+func (s *HybridSuite) TestAssertDeleted() {
+	s.Nil(s.mock.Delete("dir1//file1"))
+
+	s.True(s.mock.AssertDeleted(s.T(), "dir1/file1"))
+
+	t := new(testing.T)
+	s.False(s.mock.AssertDeleted(t, "dir1/file2"))
+	s.True(t.Failed())
+}
+
+func (s *HybridSuite) TestAssertNoWrites() {
+	m := NewHybrid(nil)
+	_, err := m.Load("file1", 1e6)
+	s.True(stor.IsPathDoesntExistError(err))
+	s.True(m.AssertNoWrites(s.T()))
+
+	t := new(testing.T)
+	s.False(s.mock.AssertNoWrites(t))
+	s.True(t.Failed())
+
+	m.On("Delete", "file1").Return(nil)
+	s.Nil(m.Delete("file1"))
+	t = new(testing.T)
+	s.False(m.AssertNoWrites(t))
+}
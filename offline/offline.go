@@ -0,0 +1,488 @@
+// Package offline implements a stor.Storage wrapper that tolerates an unreachable remote storage.
+//
+// Saves and Deletes that fail because the remote storage is unreachable are written to a durable
+// journal (e.g. a LocalDir storage) instead, and replayed in order once the remote storage is
+// reachable again. Reads see the queued changes, so the application observes its own writes while
+// it is offline. This is intended for edge and IoT deployments with intermittent connectivity.
+package offline
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"math"
+	"path"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pw1/stor"
+)
+
+const (
+	// journalDir is the directory in the journal storage that contains the queued operations.
+	journalDir = "ops"
+
+	opSave   = 'S'
+	opDelete = 'D'
+)
+
+// op is a queued operation.
+type op struct {
+	seq  uint64
+	kind byte
+	path string
+}
+
+// Offline is a stor.Storage wrapper that queues writes in a journal while the remote storage is
+// unreachable.
+//
+// Note that directories which only become empty through queued Deletes are still listed until the
+// queue has been replayed.
+type Offline struct {
+	// IsUnreachable decides whether an error of the remote storage means that it is unreachable.
+	// Only operations that fail with such errors are queued. By default, connection errors (see
+	// stor.IsConnectionError) and stor.StorageUnavailableError errors are considered unreachable
+	// errors.
+	IsUnreachable func(err error) bool
+
+	// OnReplayError is called for every queued operation that is dropped because replaying it
+	// failed with an error that isn't an unreachable error (e.g. the remote storage rejects the
+	// path), or because its journal entry is corrupt. Such operations would never succeed, and
+	// would block the operations after them. It is called without locks held. Optional.
+	OnReplayError func(path string, err error)
+
+	remote  stor.Storage
+	journal stor.Storage
+
+	// writeMutex serializes the writes and replays, so the remote storage sees them in order.
+	writeMutex sync.Mutex
+
+	// mutex protects the queue. It isn't held during calls to the remote storage.
+	mutex   sync.Mutex
+	queue   []op
+	pending map[string]op
+	nextSeq uint64
+}
+
+// New creates a new Offline wrapper around remote, which queues writes in journal. Operations that
+// are left in journal by a previous Offline (e.g. before a restart) are queued again.
+func New(remote, journal stor.Storage) (*Offline, error) {
+	o := &Offline{
+		IsUnreachable: isUnreachable,
+		remote:        remote,
+		journal:       journal,
+		pending:       make(map[string]op),
+		nextSeq:       1,
+	}
+
+	// Not all storages can list a directory that doesn't exist, so check that it exists first
+	_, dirs, err := journal.List("")
+	if err != nil {
+		return nil, fmt.Errorf("failed to read the journal: %v", err)
+	}
+
+	entries := []string{}
+	for _, dir := range dirs {
+		if dir == journalDir {
+			entries, _, err = stor.SortedList(journal, journalDir)
+			if err != nil {
+				return nil, fmt.Errorf("failed to read the journal: %v", err)
+			}
+		}
+	}
+
+	for _, entry := range entries {
+		seq, err := strconv.ParseUint(path.Base(entry), 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid journal entry %s", entry)
+		}
+
+		data, err := journal.Load(entry, math.MaxInt64)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read the journal: %v", err)
+		}
+		queued, _, err := decodeOp(data)
+		if err != nil {
+			return nil, fmt.Errorf("invalid journal entry %s: %v", entry, err)
+		}
+
+		queued.seq = seq
+		o.queue = append(o.queue, queued)
+		o.pending[queued.path] = queued
+		o.nextSeq = seq + 1
+	}
+
+	return o, nil
+}
+
+// isUnreachable checks whether err is (or wraps) a connection error or a
+// stor.StorageUnavailableError.
+func isUnreachable(err error) bool {
+	return stor.IsConnectionError(err) || stor.IsStorageUnavailableError(err)
+}
+
+// entryPath returns the path of a journal entry.
+func entryPath(seq uint64) string {
+	return fmt.Sprintf("%s/%020d", journalDir, seq)
+}
+
+// encodeOp encodes an operation (with the data of a save) as a journal entry.
+func encodeOp(kind byte, cleanPath string, data []byte) []byte {
+	buf := make([]byte, 0, len(cleanPath)+len(data)+2)
+	buf = append(buf, kind)
+	buf = append(buf, cleanPath...)
+	buf = append(buf, '\n')
+	return append(buf, data...)
+}
+
+// decodeOp decodes a journal entry. It returns the operation and the data of a save.
+func decodeOp(entry []byte) (op, []byte, error) {
+	idx := bytes.IndexByte(entry, '\n')
+	if idx < 1 || (entry[0] != opSave && entry[0] != opDelete) {
+		return op{}, nil, errors.New("corrupt entry")
+	}
+	return op{kind: entry[0], path: string(entry[1:idx])}, entry[idx+1:], nil
+}
+
+// Pending returns the number of queued operations.
+func (o *Offline) Pending() int {
+	o.mutex.Lock()
+	defer o.mutex.Unlock()
+
+	return len(o.queue)
+}
+
+// Replay applies the queued operations to the remote storage, in order. It stops at the first
+// operation that fails because the remote storage is unreachable, and returns its error.
+// Operations that fail with other errors are dropped and reported to OnReplayError, and Replay
+// then returns the first of those errors. Deleting a file that doesn't exist (anymore) in the
+// remote storage is not an error.
+func (o *Offline) Replay() error {
+	o.writeMutex.Lock()
+	defer o.writeMutex.Unlock()
+
+	dropped, err := o.replay()
+	if err != nil {
+		return err
+	}
+	return dropped
+}
+
+// replay applies the queued operations. It returns the first error of the dropped operations, and
+// the error that stopped the replay. The caller must hold the writeMutex.
+func (o *Offline) replay() (dropped error, err error) {
+	for {
+		o.mutex.Lock()
+		if len(o.queue) == 0 {
+			o.mutex.Unlock()
+			return dropped, nil
+		}
+		queued := o.queue[0]
+		o.mutex.Unlock()
+
+		entry := entryPath(queued.seq)
+		data, err := o.journal.Load(entry, math.MaxInt64)
+		if err != nil {
+			return dropped, fmt.Errorf("failed to read the journal: %v", err)
+		}
+
+		_, saveData, err := decodeOp(data)
+		if err != nil {
+			err = fmt.Errorf("invalid journal entry %s: %v", entry, err)
+		} else if queued.kind == opSave {
+			err = o.remote.Save(queued.path, saveData)
+		} else {
+			err = o.remote.Delete(queued.path)
+			if stor.IsPathDoesntExistError(err) {
+				err = nil
+			}
+		}
+		if err != nil && o.IsUnreachable(err) {
+			return dropped, err
+		}
+
+		// Reads of the path go to the remote storage before the entry is gone from the journal
+		o.mutex.Lock()
+		o.queue = o.queue[1:]
+		if o.pending[queued.path].seq == queued.seq {
+			delete(o.pending, queued.path)
+		}
+		o.mutex.Unlock()
+		if err := o.journal.Delete(entry); err != nil {
+			return dropped, fmt.Errorf("failed to update the journal: %v", err)
+		}
+
+		if err != nil {
+			if dropped == nil {
+				dropped = err
+			}
+			if o.OnReplayError != nil {
+				o.OnReplayError(queued.path, err)
+			}
+		}
+	}
+}
+
+// ReplayEvery replays the queued operations periodically in the background. Call the returned
+// function to stop.
+func (o *Offline) ReplayEvery(interval time.Duration) (stop func()) {
+	done := make(chan struct{})
+	ticker := time.NewTicker(interval)
+
+	go func() {
+		defer ticker.Stop()
+		for {
+			select {
+			case <-done:
+				return
+			case <-ticker.C:
+				o.Replay()
+			}
+		}
+	}()
+
+	var once sync.Once
+	return func() { once.Do(func() { close(done) }) }
+}
+
+// enqueue writes an operation to the journal. The caller must hold the writeMutex.
+func (o *Offline) enqueue(kind byte, cleanPath string, data []byte) error {
+	o.mutex.Lock()
+	queued := op{seq: o.nextSeq, kind: kind, path: cleanPath}
+	o.mutex.Unlock()
+
+	if err := o.journal.Save(entryPath(queued.seq), encodeOp(kind, cleanPath, data)); err != nil {
+		return fmt.Errorf("failed to write the journal: %v", err)
+	}
+
+	o.mutex.Lock()
+	defer o.mutex.Unlock()
+
+	o.nextSeq++
+	o.queue = append(o.queue, queued)
+	o.pending[cleanPath] = queued
+	return nil
+}
+
+// write performs a write on the remote storage, or queues it if the remote storage is unreachable.
+// Writes are queued as long as older writes are queued, so the order of the writes is kept.
+// The caller must hold the writeMutex.
+func (o *Offline) write(kind byte, cleanPath string, data []byte, apply func() error) error {
+	if o.Pending() > 0 {
+		// Dropped operations are reported by replay, and don't concern this write
+		if _, err := o.replay(); err != nil && !o.IsUnreachable(err) {
+			return err
+		}
+	}
+
+	if o.Pending() == 0 {
+		err := apply()
+		if err == nil || !o.IsUnreachable(err) {
+			return err
+		}
+	}
+
+	return o.enqueue(kind, cleanPath, data)
+}
+
+// loadPending loads the data of a queued save. It returns a stor.PathDoesntExistError if the save
+// has been replayed (and its journal entry removed) since it was looked up.
+func (o *Offline) loadPending(queued op) ([]byte, error) {
+	data, err := o.journal.Load(entryPath(queued.seq), math.MaxInt64)
+	if stor.IsPathDoesntExistError(err) {
+		return nil, err
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read the journal: %v", err)
+	}
+
+	_, saveData, err := decodeOp(data)
+	return saveData, err
+}
+
+// Meta returns meta information about a file. Queued writes are taken into account.
+func (o *Offline) Meta(filePath string) (*stor.Meta, error) {
+	cleanPath, err := stor.CleanPath(filePath)
+	if err != nil {
+		return nil, err
+	}
+
+	o.mutex.Lock()
+	queued, ok := o.pending[cleanPath]
+	o.mutex.Unlock()
+
+	if !ok {
+		return o.remote.Meta(cleanPath)
+	}
+	if queued.kind == opDelete {
+		return nil, &stor.PathDoesntExistError{Path: cleanPath}
+	}
+
+	data, err := o.loadPending(queued)
+	if stor.IsPathDoesntExistError(err) {
+		// The save has been replayed in the meantime
+		return o.remote.Meta(cleanPath)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &stor.Meta{Size: int64(len(data))}, nil
+}
+
+// List returns the files and subdirectories within the specified directory. Queued writes are
+// taken into account. The files of the remote storage can't be listed while it is unreachable, so
+// List then returns its error, even if writes are queued.
+func (o *Offline) List(dirPath string) ([]string, []string, error) {
+	cleanPath, err := stor.CleanPath(dirPath)
+	if err != nil {
+		return []string{}, []string{}, err
+	}
+
+	o.mutex.Lock()
+	pending := make([]op, 0, len(o.pending))
+	for _, queued := range o.pending {
+		pending = append(pending, queued)
+	}
+	o.mutex.Unlock()
+
+	files, dirs, err := o.remote.List(cleanPath)
+	if err != nil {
+		return []string{}, []string{}, err
+	}
+
+	fileSet := make(map[string]bool)
+	for _, file := range files {
+		fileSet[file] = true
+	}
+	dirSet := make(map[string]bool)
+	for _, dir := range dirs {
+		dirSet[dir] = true
+	}
+
+	prefix := cleanPath
+	if prefix != "" {
+		prefix += "/"
+	}
+
+	for _, queued := range pending {
+		if !strings.HasPrefix(queued.path, prefix) {
+			continue
+		}
+
+		rest := queued.path[len(prefix):]
+		if idx := strings.IndexByte(rest, '/'); idx >= 0 {
+			if queued.kind == opSave {
+				dirSet[prefix+rest[:idx]] = true
+			}
+			continue
+		}
+
+		fileSet[queued.path] = queued.kind == opSave
+	}
+
+	files = []string{}
+	for file, exists := range fileSet {
+		if exists {
+			files = append(files, file)
+		}
+	}
+	dirs = []string{}
+	for dir := range dirSet {
+		dirs = append(dirs, dir)
+	}
+
+	return files, dirs, nil
+}
+
+// Load loads the content of the specified file. Queued writes are taken into account.
+func (o *Offline) Load(filePath string, maxSize int64) ([]byte, error) {
+	cleanPath, err := stor.CleanPath(filePath)
+	if err != nil {
+		return []byte{}, err
+	}
+
+	o.mutex.Lock()
+	queued, ok := o.pending[cleanPath]
+	o.mutex.Unlock()
+
+	if !ok {
+		return o.remote.Load(cleanPath, maxSize)
+	}
+	if queued.kind == opDelete {
+		return []byte{}, &stor.PathDoesntExistError{Path: cleanPath}
+	}
+
+	data, err := o.loadPending(queued)
+	if stor.IsPathDoesntExistError(err) {
+		// The save has been replayed in the meantime
+		return o.remote.Load(cleanPath, maxSize)
+	}
+	if err != nil {
+		return []byte{}, err
+	}
+	if int64(len(data)) > maxSize {
+		return []byte{}, &stor.TooLargeError{What: cleanPath}
+	}
+	return data, nil
+}
+
+// Save saves the data to the specified file, or queues the save if the remote storage is
+// unreachable.
+func (o *Offline) Save(filePath string, data []byte) error {
+	cleanPath, err := stor.CleanPath(filePath)
+	if err != nil {
+		return err
+	}
+
+	o.writeMutex.Lock()
+	defer o.writeMutex.Unlock()
+
+	return o.write(opSave, cleanPath, data, func() error {
+		return o.remote.Save(cleanPath, data)
+	})
+}
+
+// Delete removes a file from storage, or queues the delete if the remote storage is unreachable.
+func (o *Offline) Delete(filePath string) error {
+	cleanPath, err := stor.CleanPath(filePath)
+	if err != nil {
+		return err
+	}
+
+	o.writeMutex.Lock()
+	defer o.writeMutex.Unlock()
+
+	// A queued delete can't report a missing file later, so check that now
+	o.mutex.Lock()
+	queued, ok := o.pending[cleanPath]
+	o.mutex.Unlock()
+	if ok && queued.kind == opDelete {
+		return &stor.PathDoesntExistError{Path: cleanPath}
+	} else if !ok {
+		if _, err := o.remote.Meta(cleanPath); err != nil && !o.IsUnreachable(err) {
+			return err
+		}
+	}
+
+	return o.write(opDelete, cleanPath, nil, func() error {
+		return o.remote.Delete(cleanPath)
+	})
+}
+
+// Type returns the Type of the remote Storage.
+func (o *Offline) Type() stor.Type {
+	return stor.TypeOf(o.remote)
+}
+
+// Close closes the remote and the journal Storage. It returns the first error, after it has tried
+// to close both. The queued operations remain in the journal. The replays of ReplayEvery must be
+// stopped separately.
+func (o *Offline) Close() error {
+	err := stor.Close(o.remote)
+	if journalErr := stor.Close(o.journal); err == nil {
+		err = journalErr
+	}
+	return err
+}
@@ -0,0 +1,330 @@
+package offline
+
+import (
+	"errors"
+	"io/ioutil"
+	"net"
+	"os"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/suite"
+
+	"github.com/pw1/stor"
+	"github.com/pw1/stor/localdir"
+	"github.com/pw1/stor/memory"
+	"github.com/pw1/stor/tester"
+)
+
+// flakyStorage is a stor.Storage that fails with a network error while it is down. Saves to the
+// rejected path always fail with a ReadOnlyError, and Saves wait for gate if it is set.
+type flakyStorage struct {
+	stor.Storage
+	mutex    sync.Mutex
+	down     bool
+	rejected string
+	gate     chan struct{}
+}
+
+func (f *flakyStorage) setDown(down bool) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	f.down = down
+}
+
+func (f *flakyStorage) err() error {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	if f.down {
+		return &net.OpError{Op: "dial", Net: "tcp", Err: errors.New("connection refused")}
+	}
+	return nil
+}
+
+func (f *flakyStorage) Meta(filePath string) (*stor.Meta, error) {
+	if err := f.err(); err != nil {
+		return nil, err
+	}
+	return f.Storage.Meta(filePath)
+}
+
+func (f *flakyStorage) List(dirPath string) ([]string, []string, error) {
+	if err := f.err(); err != nil {
+		return []string{}, []string{}, err
+	}
+	return f.Storage.List(dirPath)
+}
+
+func (f *flakyStorage) Load(filePath string, maxSize int64) ([]byte, error) {
+	if err := f.err(); err != nil {
+		return []byte{}, err
+	}
+	return f.Storage.Load(filePath, maxSize)
+}
+
+func (f *flakyStorage) Save(filePath string, data []byte) error {
+	if err := f.err(); err != nil {
+		return err
+	}
+	if filePath == f.rejected {
+		return &stor.ReadOnlyError{Path: filePath}
+	}
+	if f.gate != nil {
+		<-f.gate
+	}
+	return f.Storage.Save(filePath, data)
+}
+
+func (f *flakyStorage) Delete(filePath string) error {
+	if err := f.err(); err != nil {
+		return err
+	}
+	return f.Storage.Delete(filePath)
+}
+
+// TestOfflineStorageTester calls the generic storage tests with a reachable remote storage.
+func TestOfflineStorageTester(t *testing.T) {
+	testSuite := &tester.StorageTester{
+		SetupTestFunc: func(s *tester.StorageTester) {
+			remote, _ := memory.New(&stor.Conf{})
+			journal, _ := memory.New(&stor.Conf{})
+			st, err := New(remote, journal)
+			s.Require().Nil(err)
+			s.Storage = st
+		},
+	}
+	suite.Run(t, testSuite)
+}
+
+func TestOfflineSuite(t *testing.T) {
+	suite.Run(t, new(OfflineSuite))
+}
+
+// OfflineSuite contains the tests for queueing writes while the remote storage is unreachable.
+type OfflineSuite struct {
+	suite.Suite
+	tempDir string
+	remote  *flakyStorage
+	journal *localdir.LocalDir
+	offline *Offline
+}
+
+func (s *OfflineSuite) SetupTest() {
+	tempDir, err := ioutil.TempDir("", "TestOfflineSuite")
+	s.Require().Nil(err)
+	s.tempDir = tempDir
+
+	mem, _ := memory.New(&stor.Conf{})
+	s.remote = &flakyStorage{Storage: mem}
+	s.Require().Nil(s.remote.Save("dir1/file1", []byte("test123")))
+	s.Require().Nil(s.remote.Save("dir1/file2", []byte("test456")))
+
+	s.journal, err = localdir.New(&stor.Conf{Path: tempDir})
+	s.Require().Nil(err)
+
+	s.offline, err = New(s.remote, s.journal)
+	s.Require().Nil(err)
+}
+
+func (s *OfflineSuite) TearDownTest() {
+	os.RemoveAll(s.tempDir)
+}
+
+// TestQueueWhileDown verifies that writes are queued while the remote storage is down, and that
+// reads see the queued writes.
+func (s *OfflineSuite) TestQueueWhileDown() {
+	s.remote.setDown(true)
+
+	s.Nil(s.offline.Save("dir1/file3", []byte("new")))
+	s.Nil(s.offline.Save("dir2/file4", []byte("new")))
+	s.Nil(s.offline.Delete("dir1/file1"))
+	s.Equal(3, s.offline.Pending())
+
+	data, err := s.offline.Load("dir1/file3", 1e6)
+	s.Nil(err)
+	s.Equal([]byte("new"), data)
+
+	meta, err := s.offline.Meta("dir1/file3")
+	s.Nil(err)
+	s.Equal(int64(3), meta.Size)
+
+	_, err = s.offline.Load("dir1/file1", 1e6)
+	s.True(stor.IsPathDoesntExistError(err))
+
+	s.True(stor.IsPathDoesntExistError(s.offline.Delete("dir1/file1")))
+
+	_, err = s.offline.Load("dir1/file3", 2)
+	s.True(stor.IsTooLargeError(err))
+
+	// The remote files can't be listed, and aren't silently left out
+	_, _, err = s.offline.List("dir1")
+	s.NotNil(err)
+
+	s.remote.setDown(false)
+	files, dirs, err := s.offline.List("")
+	s.Nil(err)
+	s.Empty(files)
+	s.ElementsMatch([]string{"dir1", "dir2"}, dirs)
+	files, _, err = s.offline.List("dir1")
+	s.Nil(err)
+	s.ElementsMatch([]string{"dir1/file2", "dir1/file3"}, files)
+}
+
+// TestReplay verifies that queued writes are replayed in order.
+func (s *OfflineSuite) TestReplay() {
+	s.remote.setDown(true)
+	s.Nil(s.offline.Save("dir1/file3", []byte("first")))
+	s.Nil(s.offline.Save("dir1/file3", []byte("second")))
+	s.Nil(s.offline.Delete("dir1/file1"))
+
+	s.NotNil(s.offline.Replay())
+	s.Equal(3, s.offline.Pending())
+
+	s.remote.setDown(false)
+	s.Nil(s.offline.Replay())
+	s.Equal(0, s.offline.Pending())
+
+	data, err := s.remote.Load("dir1/file3", 1e6)
+	s.Nil(err)
+	s.Equal([]byte("second"), data)
+
+	files, _, err := s.remote.List("dir1")
+	s.Nil(err)
+	s.ElementsMatch([]string{"dir1/file2", "dir1/file3"}, files)
+
+	files, _, err = s.journal.List("")
+	s.Nil(err)
+	s.Empty(files)
+}
+
+// TestWriteKeepsOrder verifies that a write while operations are queued replays the queue first.
+func (s *OfflineSuite) TestWriteKeepsOrder() {
+	s.remote.setDown(true)
+	s.Nil(s.offline.Save("dir1/file3", []byte("first")))
+
+	s.remote.setDown(false)
+	s.Nil(s.offline.Save("dir1/file3", []byte("second")))
+	s.Equal(0, s.offline.Pending())
+
+	data, err := s.remote.Load("dir1/file3", 1e6)
+	s.Nil(err)
+	s.Equal([]byte("second"), data)
+}
+
+// TestReplayDropsRejected verifies that a queued operation that the remote storage rejects is
+// dropped and reported, so it doesn't block the operations after it.
+func (s *OfflineSuite) TestReplayDropsRejected() {
+	var failed []string
+	s.offline.OnReplayError = func(path string, err error) {
+		s.True(stor.IsReadOnlyError(err))
+		failed = append(failed, path)
+	}
+	s.remote.rejected = "dir1/file3"
+
+	s.remote.setDown(true)
+	s.Nil(s.offline.Save("dir1/file3", []byte("rejected")))
+	s.Nil(s.offline.Save("dir1/file4", []byte("new")))
+	s.Equal(2, s.offline.Pending())
+
+	s.remote.setDown(false)
+	s.True(stor.IsReadOnlyError(s.offline.Replay()))
+	s.Equal(0, s.offline.Pending())
+	s.Equal([]string{"dir1/file3"}, failed)
+
+	data, err := s.remote.Load("dir1/file4", 1e6)
+	s.Nil(err)
+	s.Equal([]byte("new"), data)
+
+	// A write isn't failed by the dropped operations before it
+	s.remote.setDown(true)
+	s.Nil(s.offline.Save("dir1/file3", []byte("rejected")))
+	s.remote.setDown(false)
+	s.Nil(s.offline.Save("dir1/file5", []byte("new")))
+	s.Equal(0, s.offline.Pending())
+	s.Equal([]string{"dir1/file3", "dir1/file3"}, failed)
+}
+
+// TestReadReplayedEntry verifies that a read falls back to the remote storage if the queued save it
+// looked up is replayed before its journal entry is read.
+func (s *OfflineSuite) TestReadReplayedEntry() {
+	s.remote.setDown(true)
+	s.Nil(s.offline.Save("dir1/file3", []byte("new")))
+	s.remote.setDown(false)
+
+	// Emulate a replay between the lookup of the save and the read of its journal entry
+	queued := s.offline.pending["dir1/file3"]
+	s.Nil(s.remote.Save("dir1/file3", []byte("replayed")))
+	s.Nil(s.journal.Delete(entryPath(queued.seq)))
+
+	data, err := s.offline.Load("dir1/file3", 1e6)
+	s.Nil(err)
+	s.Equal([]byte("replayed"), data)
+
+	meta, err := s.offline.Meta("dir1/file3")
+	s.Nil(err)
+	s.Equal(int64(8), meta.Size)
+}
+
+// TestReadDuringReplay verifies that reads don't wait for a replay.
+func (s *OfflineSuite) TestReadDuringReplay() {
+	s.remote.setDown(true)
+	s.Nil(s.offline.Save("dir1/file3", []byte("new")))
+	s.remote.setDown(false)
+
+	s.remote.gate = make(chan struct{})
+	done := make(chan error)
+	go func() { done <- s.offline.Replay() }()
+
+	data, err := s.offline.Load("dir1/file3", 1e6)
+	s.Nil(err)
+	s.Equal([]byte("new"), data)
+	s.Equal(1, s.offline.Pending())
+
+	close(s.remote.gate)
+	s.Nil(<-done)
+	s.Equal(0, s.offline.Pending())
+}
+
+// TestRestart verifies that a new Offline continues with the journal of a previous one.
+func (s *OfflineSuite) TestRestart() {
+	s.remote.setDown(true)
+	s.Nil(s.offline.Save("dir1/file3", []byte("new")))
+	s.Nil(s.offline.Delete("dir1/file2"))
+
+	restarted, err := New(s.remote, s.journal)
+	s.Nil(err)
+	s.Equal(2, restarted.Pending())
+
+	s.remote.setDown(false)
+	s.Nil(restarted.Replay())
+
+	data, err := s.remote.Load("dir1/file3", 1e6)
+	s.Nil(err)
+	s.Equal([]byte("new"), data)
+	_, err = s.remote.Meta("dir1/file2")
+	s.True(stor.IsPathDoesntExistError(err))
+}
+
+// TestOtherErrorsNotQueued verifies that errors other than unreachable errors are returned.
+func (s *OfflineSuite) TestOtherErrorsNotQueued() {
+	s.offline.IsUnreachable = func(err error) bool { return false }
+	s.remote.setDown(true)
+
+	s.NotNil(s.offline.Save("dir1/file3", []byte("new")))
+	s.Equal(0, s.offline.Pending())
+}
+
+func (s *OfflineSuite) TestReplayEvery() {
+	s.remote.setDown(true)
+	s.Nil(s.offline.Save("dir1/file3", []byte("new")))
+
+	stop := s.offline.ReplayEvery(10 * time.Millisecond)
+	defer stop()
+	s.remote.setDown(false)
+
+	for i := 0; i < 100 && s.offline.Pending() > 0; i++ {
+		time.Sleep(10 * time.Millisecond)
+	}
+	s.Equal(0, s.offline.Pending())
+}
@@ -0,0 +1,177 @@
+package pack
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+
+	"github.com/pw1/stor"
+)
+
+// A pack file consists of the concatenated content of all files, followed by an index and a
+// trailer:
+//
+//  [blob 0][blob 1]...[blob n-1][index][trailer]
+//
+// The index contains one entry per file:
+//
+//  [path length (uint16)][path][offset (uint64)][size (uint64)]
+//
+// The trailer has a fixed size:
+//
+//  [index offset (uint64)][number of entries (uint64)][format version (uint32)][magic]
+//
+// All integers are stored in little endian byte order.
+
+const (
+	// magic identifies a pack file.
+	magic = "STORPACK"
+
+	// formatVersion is the version of the pack file format.
+	formatVersion uint32 = 1
+
+	// trailerSize is the size of the trailer at the end of a pack file.
+	trailerSize = 8 + 8 + 4 + len(magic)
+
+	// maxPathLen is the maximum length of a path in a pack file.
+	maxPathLen = 1<<16 - 1
+)
+
+// entry is the location of a file in a pack file.
+type entry struct {
+	offset uint64
+	size   uint64
+}
+
+// Builder writes a pack file. Files are added with Add(), and the pack file is completed with
+// Close().
+type Builder struct {
+	w      *bufio.Writer
+	offset uint64
+	paths  []string
+	index  map[string]entry
+	closed bool
+}
+
+// NewBuilder creates a new Builder that writes a pack file to w.
+func NewBuilder(w io.Writer) *Builder {
+	return &Builder{
+		w:     bufio.NewWriter(w),
+		index: make(map[string]entry),
+	}
+}
+
+// Add adds a file to the pack file.
+func (b *Builder) Add(filePath string, data []byte) error {
+	if b.closed {
+		return errors.New("pack builder is closed")
+	}
+
+	cleanPath, err := stor.CleanPath(filePath)
+	if err != nil {
+		return err
+	}
+	if cleanPath == "" {
+		return &stor.InvalidPathError{Path: filePath, Msg: "path is empty"}
+	}
+	if len(cleanPath) > maxPathLen {
+		return &stor.InvalidPathError{Path: filePath, Msg: "path is too long"}
+	}
+	if _, ok := b.index[cleanPath]; ok {
+		return fmt.Errorf("file %s is already added", cleanPath)
+	}
+
+	if _, err := b.w.Write(data); err != nil {
+		return err
+	}
+
+	b.paths = append(b.paths, cleanPath)
+	b.index[cleanPath] = entry{offset: b.offset, size: uint64(len(data))}
+	b.offset += uint64(len(data))
+	return nil
+}
+
+// Close writes the index and the trailer of the pack file. It doesn't close the underlying
+// io.Writer.
+func (b *Builder) Close() error {
+	if b.closed {
+		return errors.New("pack builder is closed")
+	}
+	b.closed = true
+
+	var buf [8]byte
+	for _, filePath := range b.paths {
+		e := b.index[filePath]
+
+		binary.LittleEndian.PutUint16(buf[:2], uint16(len(filePath)))
+		b.w.Write(buf[:2])
+		b.w.WriteString(filePath)
+		binary.LittleEndian.PutUint64(buf[:], e.offset)
+		b.w.Write(buf[:])
+		binary.LittleEndian.PutUint64(buf[:], e.size)
+		b.w.Write(buf[:])
+	}
+
+	binary.LittleEndian.PutUint64(buf[:], b.offset)
+	b.w.Write(buf[:])
+	binary.LittleEndian.PutUint64(buf[:], uint64(len(b.paths)))
+	b.w.Write(buf[:])
+	binary.LittleEndian.PutUint32(buf[:4], formatVersion)
+	b.w.Write(buf[:4])
+	b.w.WriteString(magic)
+
+	return b.w.Flush()
+}
+
+// parseIndex parses the index of a pack file.
+func parseIndex(data []byte) (map[string]entry, error) {
+	if len(data) < trailerSize {
+		return nil, errors.New("pack file is too short")
+	}
+
+	trailer := data[len(data)-trailerSize:]
+	if string(trailer[20:]) != magic {
+		return nil, errors.New("not a pack file")
+	}
+	if version := binary.LittleEndian.Uint32(trailer[16:20]); version != formatVersion {
+		return nil, fmt.Errorf("unsupported pack file version %d", version)
+	}
+
+	indexOffset := binary.LittleEndian.Uint64(trailer[0:8])
+	count := binary.LittleEndian.Uint64(trailer[8:16])
+	indexEnd := uint64(len(data) - trailerSize)
+	if indexOffset > indexEnd {
+		return nil, errors.New("corrupt pack file: invalid index offset")
+	}
+
+	index := make(map[string]entry)
+	pos := indexOffset
+	for i := uint64(0); i < count; i++ {
+		if pos+2 > indexEnd {
+			return nil, errors.New("corrupt pack file: truncated index")
+		}
+		pathLen := uint64(binary.LittleEndian.Uint16(data[pos:]))
+		pos += 2
+
+		if pos+pathLen+16 > indexEnd {
+			return nil, errors.New("corrupt pack file: truncated index")
+		}
+		filePath := string(data[pos : pos+pathLen])
+		pos += pathLen
+
+		e := entry{
+			offset: binary.LittleEndian.Uint64(data[pos:]),
+			size:   binary.LittleEndian.Uint64(data[pos+8:]),
+		}
+		pos += 16
+
+		if e.offset > indexOffset || e.size > indexOffset-e.offset {
+			return nil, fmt.Errorf("corrupt pack file: file %s is out of bounds", filePath)
+		}
+		index[filePath] = e
+	}
+
+	return index, nil
+}
@@ -0,0 +1,23 @@
+//go:build !darwin && !dragonfly && !freebsd && !linux && !netbsd && !openbsd
+// +build !darwin,!dragonfly,!freebsd,!linux,!netbsd,!openbsd
+
+package pack
+
+import (
+	"io"
+	"os"
+)
+
+// mapFile reads a file into memory. Memory-mapping is not supported on this platform.
+func mapFile(f *os.File, size int) ([]byte, error) {
+	data := make([]byte, size)
+	if _, err := io.ReadFull(f, data); err != nil {
+		return nil, err
+	}
+	return data, nil
+}
+
+// unmapFile releases memory returned by mapFile.
+func unmapFile(data []byte) error {
+	return nil
+}
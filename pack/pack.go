@@ -0,0 +1,243 @@
+// Package pack implements a read-only stor.Storage on top of a single packed dataset file.
+//
+// A pack file contains the content of many files followed by an index. It is created with a
+// Builder, and opened with New. The file is memory-mapped (where the platform supports it), so
+// loading a file doesn't copy or allocate: the returned data refers directly to the mapped memory.
+// This makes pack files well suited for static datasets that are shipped with a service.
+package pack
+
+import (
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/pw1/stor"
+)
+
+const (
+	// PackStorageType is the storage type of the Pack storage.
+	PackStorageType stor.Type = "Pack"
+
+	// URLScheme is the scheme of Pack storage URLs (see stor.NewFromURL), e.g.
+	// "pack:///srv/dataset.pack".
+	URLScheme = "pack"
+)
+
+func init() {
+	newStorageFunc := func(conf *stor.Conf) (stor.Storage, error) {
+		return New(conf)
+	}
+	stor.RegisterType(PackStorageType, newStorageFunc)
+	stor.RegisterValidator(PackStorageType, Validate)
+	stor.RegisterInfo(stor.Info{
+		Type:         PackStorageType,
+		Description:  "Memory-mapped pack file (read-only)",
+		Path:         "Path of the pack file",
+		Capabilities: []stor.Capability{stor.CapabilityPersistent, stor.CapabilityReadOnly,
+			stor.CapabilityListMeta},
+	})
+	stor.RegisterScheme(URLScheme, PackStorageType, nil)
+}
+
+// dirEntries contains the files and subdirectories within a directory.
+type dirEntries struct {
+	files []string
+	dirs  []string
+}
+
+// Pack is a read-only stor.Storage that serves files from a memory-mapped pack file.
+//
+// The data returned by Load refers to the mapped memory. It must not be modified, and must not be
+// used after Close has been called.
+type Pack struct {
+	data  []byte
+	index map[string]entry
+	dirs  map[string]*dirEntries
+}
+
+// Validate checks a configuration of the Pack storage. It doesn't open the pack file; New does
+// that.
+func Validate(conf *stor.Conf) error {
+	if conf.Path == "" {
+		return &stor.ConfError{Type: PackStorageType, Field: "Path", Msg: "must specify a pack file"}
+	}
+	return nil
+}
+
+// New opens the pack file at conf.Path.
+func New(conf *stor.Conf) (*Pack, error) {
+	if err := Validate(conf); err != nil {
+		return nil, err
+	}
+
+	f, err := os.Open(conf.Path)
+	if err != nil {
+		return nil, fmt.Errorf("Unable to open pack file %v: %v", conf.Path, err)
+	}
+	defer f.Close()
+
+	info, err := f.Stat()
+	if err != nil {
+		return nil, fmt.Errorf("Unable to open pack file %v: %v", conf.Path, err)
+	}
+	if info.Size() < int64(trailerSize) {
+		return nil, fmt.Errorf("Invalid pack file %v: file is too short", conf.Path)
+	}
+
+	data, err := mapFile(f, int(info.Size()))
+	if err != nil {
+		return nil, fmt.Errorf("Unable to map pack file %v: %v", conf.Path, err)
+	}
+
+	index, err := parseIndex(data)
+	if err != nil {
+		unmapFile(data)
+		return nil, fmt.Errorf("Invalid pack file %v: %v", conf.Path, err)
+	}
+
+	p := &Pack{
+		data:  data,
+		index: index,
+		dirs:  buildDirs(index),
+	}
+	return p, nil
+}
+
+// buildDirs creates the directory tree of all files in the index, so List doesn't have to scan
+// the whole index.
+func buildDirs(index map[string]entry) map[string]*dirEntries {
+	dirs := map[string]*dirEntries{
+		"": {},
+	}
+
+	// getDir returns the entries of a directory. A new directory is also added to its parent.
+	var getDir func(dirPath string) *dirEntries
+	getDir = func(dirPath string) *dirEntries {
+		d, ok := dirs[dirPath]
+		if !ok {
+			d = &dirEntries{}
+			dirs[dirPath] = d
+
+			parent := getDir(dirName(dirPath))
+			parent.dirs = append(parent.dirs, dirPath)
+		}
+		return d
+	}
+
+	for filePath := range index {
+		d := getDir(dirName(filePath))
+		d.files = append(d.files, filePath)
+	}
+
+	return dirs
+}
+
+// dirName returns the parent directory of a clean path. The root directory is "".
+func dirName(filePath string) string {
+	idx := strings.LastIndexByte(filePath, '/')
+	if idx < 0 {
+		return ""
+	}
+	return filePath[:idx]
+}
+
+// Close unmaps the pack file. Data returned by Load must not be used after Close.
+func (p *Pack) Close() error {
+	if p.data == nil {
+		return nil
+	}
+
+	err := unmapFile(p.data)
+	p.data = nil
+	p.index = map[string]entry{}
+	p.dirs = map[string]*dirEntries{"": {}}
+	return err
+}
+
+// Type returns PackStorageType.
+func (p *Pack) Type() stor.Type {
+	return PackStorageType
+}
+
+// Meta returns meta information about a file.
+func (p *Pack) Meta(filePath string) (*stor.Meta, error) {
+	cleanPath, err := stor.CleanPath(filePath)
+	if err != nil {
+		return nil, err
+	}
+
+	e, ok := p.index[cleanPath]
+	if !ok {
+		return nil, &stor.PathDoesntExistError{Path: cleanPath}
+	}
+
+	meta := &stor.Meta{
+		Size: int64(e.size),
+	}
+	return meta, nil
+}
+
+// List returns the files and subdirectories within the specified directory.
+func (p *Pack) List(dirPath string) ([]string, []string, error) {
+	cleanPath, err := stor.CleanPath(dirPath)
+	if err != nil {
+		return []string{}, []string{}, err
+	}
+
+	d, ok := p.dirs[cleanPath]
+	if !ok {
+		return []string{}, []string{}, nil
+	}
+
+	files := make([]string, len(d.files))
+	copy(files, d.files)
+	dirs := make([]string, len(d.dirs))
+	copy(dirs, d.dirs)
+
+	return files, dirs, nil
+}
+
+// ListWithMeta returns the files within the specified directory with their Meta, and the
+// subdirectories. The sizes are taken from the index. The ModTime is zero.
+func (p *Pack) ListWithMeta(dirPath string) ([]stor.Entry, []string, error) {
+	files, dirs, err := p.List(dirPath)
+	if err != nil {
+		return []stor.Entry{}, []string{}, err
+	}
+
+	entries := make([]stor.Entry, len(files))
+	for i, file := range files {
+		entries[i] = stor.Entry{Path: file, Meta: stor.Meta{Size: int64(p.index[file].size)}}
+	}
+	return entries, dirs, nil
+}
+
+// Load returns the content of the specified file. If the file is larger than maxSize, then an
+// error is returned. The returned data refers to the mapped pack file, and must not be modified.
+func (p *Pack) Load(filePath string, maxSize int64) ([]byte, error) {
+	cleanPath, err := stor.CleanPath(filePath)
+	if err != nil {
+		return []byte{}, err
+	}
+
+	e, ok := p.index[cleanPath]
+	if !ok {
+		return []byte{}, &stor.PathDoesntExistError{Path: cleanPath}
+	}
+
+	if int64(e.size) > maxSize {
+		return []byte{}, &stor.TooLargeError{What: cleanPath}
+	}
+
+	return p.data[e.offset : e.offset+e.size : e.offset+e.size], nil
+}
+
+// Save always returns a stor.ReadOnlyError, because a Pack is read-only.
+func (p *Pack) Save(filePath string, data []byte) error {
+	return &stor.ReadOnlyError{Path: filePath}
+}
+
+// Delete always returns a stor.ReadOnlyError, because a Pack is read-only.
+func (p *Pack) Delete(filePath string) error {
+	return &stor.ReadOnlyError{Path: filePath}
+}
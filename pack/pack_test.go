@@ -0,0 +1,203 @@
+package pack
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/suite"
+
+	"github.com/pw1/stor"
+	"github.com/pw1/stor/tester"
+)
+
+// TestPackStorageTester calls the generic storage tests
+func TestPackStorageTester(t *testing.T) {
+	tempDir, err := ioutil.TempDir("", "TestPackStorageTester")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tempDir)
+
+	packPath := filepath.Join(tempDir, "standard.pack")
+	f, err := os.Create(packPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	b := NewBuilder(f)
+	for filePath, content := range tester.StandardFiles {
+		if err := b.Add(filePath, []byte(content)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := b.Close(); err != nil {
+		t.Fatal(err)
+	}
+	f.Close()
+
+	var p *Pack
+	testSuite := &tester.StorageTester{
+		SetupTestFunc: func(s *tester.StorageTester) {
+			p, err = New(&stor.Conf{Type: PackStorageType, Path: packPath})
+			s.Require().Nil(err)
+			s.Storage = p
+		},
+		TearDownTestFunc: func(s *tester.StorageTester) {
+			p.Close()
+		},
+		ReadOnly: true,
+	}
+
+	suite.Run(t, testSuite)
+}
+
+func TestPackSuite(t *testing.T) {
+	suite.Run(t, new(PackSuite))
+}
+
+// PackSuite contains the tests for the Builder and the Pack storage.
+type PackSuite struct {
+	suite.Suite
+	tempDir  string
+	packPath string
+	pack     *Pack
+}
+
+func (s *PackSuite) SetupSuite() {
+	tempDir, err := ioutil.TempDir("", "TestPackSuite")
+	s.Require().Nil(err)
+	s.tempDir = tempDir
+	s.packPath = filepath.Join(tempDir, "test.pack")
+
+	f, err := os.Create(s.packPath)
+	s.Require().Nil(err)
+	defer f.Close()
+
+	b := NewBuilder(f)
+	files := []struct{ path, content string }{
+		{"file1", "test123"},
+		{"dir1/file2", "test456"},
+		{"dir1/file3", "test789"},
+		{"dir1/dir4/file5", "test788909"},
+		{"dir2/dir3/file4", "test0123"},
+		{"empty", ""},
+	}
+	for _, file := range files {
+		s.Require().Nil(b.Add(file.path, []byte(file.content)))
+	}
+	s.Require().Nil(b.Close())
+}
+
+func (s *PackSuite) TearDownSuite() {
+	os.RemoveAll(s.tempDir)
+}
+
+func (s *PackSuite) SetupTest() {
+	p, err := New(&stor.Conf{Type: PackStorageType, Path: s.packPath})
+	s.Require().Nil(err)
+	s.pack = p
+}
+
+func (s *PackSuite) TearDownTest() {
+	s.Nil(s.pack.Close())
+}
+
+func (s *PackSuite) TestNewViaStor() {
+	st, err := stor.New(&stor.Conf{Type: PackStorageType, Path: s.packPath})
+	s.Nil(err)
+	s.NotNil(st)
+	st.(*Pack).Close()
+}
+
+func (s *PackSuite) TestNewNonExisting() {
+	p, err := New(&stor.Conf{Type: PackStorageType, Path: filepath.Join(s.tempDir, "nope")})
+	s.Nil(p)
+	s.NotNil(err)
+}
+
+func (s *PackSuite) TestNewNotAPack() {
+	filePath := filepath.Join(s.tempDir, "not-a-pack")
+	s.Nil(ioutil.WriteFile(filePath, bytes.Repeat([]byte("x"), 100), 0600))
+
+	p, err := New(&stor.Conf{Type: PackStorageType, Path: filePath})
+	s.Nil(p)
+	s.NotNil(err)
+}
+
+func (s *PackSuite) TestBuilderDuplicate() {
+	b := NewBuilder(ioutil.Discard)
+	s.Nil(b.Add("file1", []byte("a")))
+	s.NotNil(b.Add("./file1", []byte("b")))
+}
+
+func (s *PackSuite) TestBuilderInvalidPath() {
+	b := NewBuilder(ioutil.Discard)
+	s.True(stor.IsInvalidPathError(b.Add("../file1", []byte("a"))))
+	s.True(stor.IsInvalidPathError(b.Add("", []byte("a"))))
+}
+
+func (s *PackSuite) TestBuilderClosed() {
+	b := NewBuilder(ioutil.Discard)
+	s.Nil(b.Close())
+	s.NotNil(b.Add("file1", []byte("a")))
+	s.NotNil(b.Close())
+}
+
+func (s *PackSuite) TestMeta() {
+	meta, err := s.pack.Meta("dir1/dir4/file5")
+	s.Nil(err)
+	s.Equal(&stor.Meta{Size: 10}, meta)
+
+	_, err = s.pack.Meta("dir1/nope")
+	s.True(stor.IsPathDoesntExistError(err))
+}
+
+func (s *PackSuite) TestList() {
+	files, dirs, err := s.pack.List("")
+	s.Nil(err)
+	s.ElementsMatch([]string{"file1", "empty"}, files)
+	s.ElementsMatch([]string{"dir1", "dir2"}, dirs)
+
+	files, dirs, err = s.pack.List("dir1/")
+	s.Nil(err)
+	s.ElementsMatch([]string{"dir1/file2", "dir1/file3"}, files)
+	s.ElementsMatch([]string{"dir1/dir4"}, dirs)
+
+	files, dirs, err = s.pack.List("dir2")
+	s.Nil(err)
+	s.Empty(files)
+	s.ElementsMatch([]string{"dir2/dir3"}, dirs)
+
+	files, dirs, err = s.pack.List("nope")
+	s.Nil(err)
+	s.Empty(files)
+	s.Empty(dirs)
+
+	_, _, err = s.pack.List("..")
+	s.True(stor.IsInvalidPathError(err))
+}
+
+func (s *PackSuite) TestLoad() {
+	data, err := s.pack.Load("dir2/dir3/file4", 1e6)
+	s.Nil(err)
+	s.Equal([]byte("test0123"), data)
+
+	data, err = s.pack.Load("empty", 0)
+	s.Nil(err)
+	s.Empty(data)
+
+	data, err = s.pack.Load("file1", 6)
+	s.True(stor.IsTooLargeError(err))
+	s.Equal([]byte{}, data)
+
+	data, err = s.pack.Load("nope", 1e6)
+	s.True(stor.IsPathDoesntExistError(err))
+	s.Equal([]byte{}, data)
+}
+
+func (s *PackSuite) TestReadOnly() {
+	s.True(stor.IsReadOnlyError(s.pack.Save("file1", []byte("x"))))
+	s.True(stor.IsReadOnlyError(s.pack.Delete("file1")))
+}
@@ -0,0 +1,350 @@
+// Package pathhash implements a stor.Storage wrapper that hides the paths of the files from the
+// underlying Storage.
+//
+// Every file is stored under the hex encoded HMAC-SHA256 of its path, so the underlying Storage
+// (and anyone that compromises it) only sees random looking names. The contents of the directories
+// are kept in encrypted index objects (AES-256-GCM), which are used by List. Note that the number
+// and sizes of the files are not hidden, and that the file contents are stored as-is: combine this
+// wrapper with encryption of the contents to hide those as well.
+//
+// The underlying Storage contains the following paths:
+//
+//	d/<xx>/<hmac>   The data of a file. <xx> are the first two characters of <hmac>.
+//	i/<hmac>        The index of a directory.
+package pathhash
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"math"
+	"path"
+	"sort"
+	"sync"
+
+	"github.com/pw1/stor"
+)
+
+const (
+	// MinKeySize is the minimum size of the secret key in bytes.
+	MinKeySize = 16
+
+	dataDir  = "d"
+	indexDir = "i"
+)
+
+// index contains the names (not the full paths) of the entries of a directory.
+type index struct {
+	Files []string `json:"files"`
+	Dirs  []string `json:"dirs"`
+}
+
+// PathHash is a stor.Storage wrapper that stores files under HMAC hashed paths.
+//
+// The indexes are updated by every Save and Delete, so PathHash must be the only writer of the
+// underlying Storage. Writes through a PathHash are serialized.
+type PathHash struct {
+	base    stor.Storage
+	pathKey []byte
+	aead    cipher.AEAD
+	mutex   sync.Mutex
+}
+
+// New creates a new PathHash that stores its data in base. The secret key must be at least
+// MinKeySize bytes. The same key is needed to access the files again.
+func New(base stor.Storage, key []byte) (*PathHash, error) {
+	if len(key) < MinKeySize {
+		return nil, fmt.Errorf("Invalid key: must be at least %d bytes", MinKeySize)
+	}
+
+	block, err := aes.NewCipher(deriveKey(key, "index"))
+	if err != nil {
+		return nil, err
+	}
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+
+	p := &PathHash{
+		base:    base,
+		pathKey: deriveKey(key, "path"),
+		aead:    aead,
+	}
+	return p, nil
+}
+
+// deriveKey derives an independent 256 bit key for a purpose from the secret key.
+func deriveKey(key []byte, purpose string) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte("stor/pathhash " + purpose))
+	return mac.Sum(nil)
+}
+
+// hash returns the hex encoded HMAC of a cleaned path.
+func (p *PathHash) hash(kind, cleanPath string) string {
+	mac := hmac.New(sha256.New, p.pathKey)
+	mac.Write([]byte(kind + ":" + cleanPath))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// dataPath returns the physical path of the data of a file.
+func (p *PathHash) dataPath(cleanPath string) string {
+	hash := p.hash(dataDir, cleanPath)
+	return dataDir + "/" + hash[:2] + "/" + hash
+}
+
+// indexPath returns the physical path of the index of a directory.
+func (p *PathHash) indexPath(cleanDir string) string {
+	return indexDir + "/" + p.hash(indexDir, cleanDir)
+}
+
+// loadIndex loads and decrypts the index of a directory. A directory without index is empty.
+func (p *PathHash) loadIndex(cleanDir string) (*index, error) {
+	sealed, err := p.base.Load(p.indexPath(cleanDir), math.MaxInt64)
+	if stor.IsPathDoesntExistError(err) {
+		return &index{}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	nonceSize := p.aead.NonceSize()
+	if len(sealed) < nonceSize {
+		return nil, fmt.Errorf("index of directory %q is corrupt", cleanDir)
+	}
+	plain, err := p.aead.Open(nil, sealed[:nonceSize], sealed[nonceSize:], []byte(cleanDir))
+	if err != nil {
+		return nil, fmt.Errorf("index of directory %q is corrupt or has another key", cleanDir)
+	}
+
+	idx := &index{}
+	if err := json.Unmarshal(plain, idx); err != nil {
+		return nil, fmt.Errorf("index of directory %q is corrupt: %v", cleanDir, err)
+	}
+	return idx, nil
+}
+
+// saveIndex encrypts and saves the index of a directory. An empty index is deleted.
+func (p *PathHash) saveIndex(cleanDir string, idx *index) error {
+	if len(idx.Files) == 0 && len(idx.Dirs) == 0 {
+		err := p.base.Delete(p.indexPath(cleanDir))
+		if stor.IsPathDoesntExistError(err) {
+			return nil
+		}
+		return err
+	}
+
+	plain, err := json.Marshal(idx)
+	if err != nil {
+		return err
+	}
+
+	nonce := make([]byte, p.aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return err
+	}
+	sealed := p.aead.Seal(nonce, nonce, plain, []byte(cleanDir))
+
+	return p.base.Save(p.indexPath(cleanDir), sealed)
+}
+
+// Meta returns meta information about a file.
+func (p *PathHash) Meta(filePath string) (*stor.Meta, error) {
+	cleanPath, err := stor.CleanPath(filePath)
+	if err != nil {
+		return nil, err
+	}
+
+	meta, err := p.base.Meta(p.dataPath(cleanPath))
+	if stor.IsPathDoesntExistError(err) {
+		return nil, &stor.PathDoesntExistError{Path: cleanPath}
+	}
+	return meta, err
+}
+
+// List returns the files and subdirectories within the specified directory.
+func (p *PathHash) List(dirPath string) ([]string, []string, error) {
+	cleanPath, err := stor.CleanPath(dirPath)
+	if err != nil {
+		return []string{}, []string{}, err
+	}
+
+	idx, err := p.loadIndex(cleanPath)
+	if err != nil {
+		return []string{}, []string{}, err
+	}
+
+	files := make([]string, 0, len(idx.Files))
+	for _, name := range idx.Files {
+		files = append(files, path.Join(cleanPath, name))
+	}
+	dirs := make([]string, 0, len(idx.Dirs))
+	for _, name := range idx.Dirs {
+		dirs = append(dirs, path.Join(cleanPath, name))
+	}
+
+	return files, dirs, nil
+}
+
+// Load loads the content of the specified file. If the file is larger than maxSize, the an error is
+// returned.
+func (p *PathHash) Load(filePath string, maxSize int64) ([]byte, error) {
+	cleanPath, err := stor.CleanPath(filePath)
+	if err != nil {
+		return []byte{}, err
+	}
+
+	data, err := p.base.Load(p.dataPath(cleanPath), maxSize)
+	if stor.IsPathDoesntExistError(err) {
+		return []byte{}, &stor.PathDoesntExistError{Path: cleanPath}
+	}
+	if stor.IsTooLargeError(err) {
+		return []byte{}, &stor.TooLargeError{What: cleanPath}
+	}
+	return data, err
+}
+
+// Save saves the data to the specified file, and adds it to the indexes.
+func (p *PathHash) Save(filePath string, data []byte) error {
+	cleanPath, err := stor.CleanPath(filePath)
+	if err != nil {
+		return err
+	}
+	if cleanPath == "" {
+		return &stor.InvalidPathError{Path: filePath, Msg: "path is a directory"}
+	}
+
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	if err := p.base.Save(p.dataPath(cleanPath), data); err != nil {
+		return err
+	}
+
+	// Add the file to its directory, and every new directory to its parent
+	entry := cleanPath
+	isDir := false
+	for entry != "" {
+		dir := parentDir(entry)
+		idx, err := p.loadIndex(dir)
+		if err != nil {
+			return err
+		}
+
+		existed := len(idx.Files) > 0 || len(idx.Dirs) > 0
+		var added bool
+		if isDir {
+			idx.Dirs, added = insert(idx.Dirs, path.Base(entry))
+		} else {
+			idx.Files, added = insert(idx.Files, path.Base(entry))
+		}
+		if !added {
+			return nil
+		}
+		if err := p.saveIndex(dir, idx); err != nil {
+			return err
+		}
+		if existed {
+			return nil
+		}
+
+		entry = dir
+		isDir = true
+	}
+
+	return nil
+}
+
+// Delete removes a file from storage, and removes directories that become empty from the indexes.
+func (p *PathHash) Delete(filePath string) error {
+	cleanPath, err := stor.CleanPath(filePath)
+	if err != nil {
+		return err
+	}
+
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	err = p.base.Delete(p.dataPath(cleanPath))
+	if stor.IsPathDoesntExistError(err) {
+		return &stor.PathDoesntExistError{Path: cleanPath}
+	}
+	if err != nil {
+		return err
+	}
+
+	// Remove the file from its directory, and every directory that becomes empty from its parent
+	entry := cleanPath
+	isDir := false
+	for entry != "" {
+		dir := parentDir(entry)
+		idx, err := p.loadIndex(dir)
+		if err != nil {
+			return err
+		}
+
+		if isDir {
+			idx.Dirs = remove(idx.Dirs, path.Base(entry))
+		} else {
+			idx.Files = remove(idx.Files, path.Base(entry))
+		}
+		if err := p.saveIndex(dir, idx); err != nil {
+			return err
+		}
+		if len(idx.Files) > 0 || len(idx.Dirs) > 0 {
+			return nil
+		}
+
+		entry = dir
+		isDir = true
+	}
+
+	return nil
+}
+
+// parentDir returns the parent directory of a cleaned path. The parent of a top level entry is "".
+func parentDir(cleanPath string) string {
+	dir := path.Dir(cleanPath)
+	if dir == "." {
+		return ""
+	}
+	return dir
+}
+
+// insert adds name to the sorted list, if it isn't in it yet. The second return value is true if
+// name was added.
+func insert(list []string, name string) ([]string, bool) {
+	i := sort.SearchStrings(list, name)
+	if i < len(list) && list[i] == name {
+		return list, false
+	}
+	list = append(list, "")
+	copy(list[i+1:], list[i:])
+	list[i] = name
+	return list, true
+}
+
+// remove removes name from the sorted list.
+func remove(list []string, name string) []string {
+	i := sort.SearchStrings(list, name)
+	if i < len(list) && list[i] == name {
+		return append(list[:i], list[i+1:]...)
+	}
+	return list
+}
+
+// Type returns the Type of the base Storage.
+func (p *PathHash) Type() stor.Type {
+	return stor.TypeOf(p.base)
+}
+
+// Close closes the base Storage.
+func (p *PathHash) Close() error {
+	return stor.Close(p.base)
+}
@@ -0,0 +1,140 @@
+package pathhash
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/suite"
+
+	"github.com/pw1/stor"
+	"github.com/pw1/stor/memory"
+	"github.com/pw1/stor/tester"
+)
+
+var testKey = []byte("0123456789abcdef")
+
+// TestPathHashStorageTester calls the generic storage tests
+func TestPathHashStorageTester(t *testing.T) {
+	testSuite := &tester.StorageTester{
+		SetupTestFunc: func(s *tester.StorageTester) {
+			base, _ := memory.New(&stor.Conf{})
+			st, err := New(base, testKey)
+			s.Require().Nil(err)
+			s.Storage = st
+		},
+	}
+
+	suite.Run(t, testSuite)
+}
+
+func TestPathHashSuite(t *testing.T) {
+	suite.Run(t, new(PathHashSuite))
+}
+
+// PathHashSuite contains tests that are specific for the PathHash wrapper.
+type PathHashSuite struct {
+	suite.Suite
+	base *memory.Memory
+	st   *PathHash
+}
+
+func (s *PathHashSuite) SetupTest() {
+	s.base, _ = memory.New(&stor.Conf{})
+
+	var err error
+	s.st, err = New(s.base, testKey)
+	s.Require().Nil(err)
+}
+
+// basePaths returns all paths in the underlying storage.
+func (s *PathHashSuite) basePaths() []string {
+	paths := []string{}
+	dirs := []string{""}
+	for len(dirs) > 0 {
+		files, subdirs, err := s.base.List(dirs[0])
+		s.Require().Nil(err)
+		paths = append(paths, files...)
+		dirs = append(dirs[1:], subdirs...)
+	}
+	return paths
+}
+
+func (s *PathHashSuite) TestNewShortKey() {
+	_, err := New(s.base, []byte("short"))
+	s.NotNil(err)
+}
+
+func (s *PathHashSuite) TestNamesHidden() {
+	s.Require().Nil(s.st.Save("secret/report-2020.txt", []byte("test123")))
+
+	paths := s.basePaths()
+	s.Len(paths, 3, "data, and indexes of the root and the secret directory")
+	for _, p := range paths {
+		s.NotContains(p, "secret")
+		s.NotContains(p, "report")
+	}
+	for _, p := range paths[1:] {
+		data, err := s.base.Load(p, 1000)
+		s.Nil(err)
+		s.NotContains(string(data), "secret")
+		s.NotContains(string(data), "report")
+	}
+}
+
+func (s *PathHashSuite) TestListNested() {
+	s.Require().Nil(s.st.Save("dir1/dir2/file1", []byte("a")))
+	s.Require().Nil(s.st.Save("dir1/file2", []byte("b")))
+	s.Require().Nil(s.st.Save("dir1/file2", []byte("c")))
+
+	files, dirs, err := s.st.List("dir1")
+	s.Nil(err)
+	s.Equal([]string{"dir1/file2"}, files)
+	s.Equal([]string{"dir1/dir2"}, dirs)
+
+	s.Require().Nil(s.st.Delete("dir1/dir2/file1"))
+	files, dirs, err = s.st.List("dir1")
+	s.Nil(err)
+	s.Equal([]string{"dir1/file2"}, files)
+	s.Empty(dirs)
+
+	s.Require().Nil(s.st.Delete("dir1/file2"))
+	files, dirs, err = s.st.List("")
+	s.Nil(err)
+	s.Empty(files)
+	s.Empty(dirs)
+	s.Empty(s.basePaths(), "all indexes are removed")
+}
+
+func (s *PathHashSuite) TestWrongKey() {
+	s.Require().Nil(s.st.Save("file1", []byte("test123")))
+
+	other, err := New(s.base, []byte(strings.Repeat("x", MinKeySize)))
+	s.Require().Nil(err)
+
+	_, err = other.Load("file1", 100)
+	s.True(stor.IsPathDoesntExistError(err))
+
+	files, dirs, err := other.List("")
+	s.Nil(err)
+	s.Empty(files)
+	s.Empty(dirs)
+}
+
+func (s *PathHashSuite) TestCorruptIndex() {
+	s.Require().Nil(s.st.Save("dir1/file1", []byte("test123")))
+
+	// An index that is moved to another directory doesn't authenticate
+	rootIndex, err := s.base.Load(s.st.indexPath(""), 1000)
+	s.Require().Nil(err)
+	s.Require().Nil(s.base.Save(s.st.indexPath("dir1"), rootIndex))
+	_, _, err = s.st.List("dir1")
+	s.NotNil(err)
+
+	s.Require().Nil(s.base.Save(s.st.indexPath(""), []byte("x")))
+	_, _, err = s.st.List("")
+	s.NotNil(err)
+}
+
+func (s *PathHashSuite) TestSaveRoot() {
+	s.True(stor.IsInvalidPathError(s.st.Save("", []byte("a"))))
+}
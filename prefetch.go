@@ -0,0 +1,58 @@
+package stor
+
+import "sync"
+
+// DefaultPrefetchConcurrency is the number of files that Prefetch loads at the same time if the
+// concurrency isn't set.
+const DefaultPrefetchConcurrency = 16
+
+// LoadResult is the result of loading a single file with Prefetch.
+type LoadResult struct {
+	// Path is the path of the file, as passed to Prefetch.
+	Path string
+
+	// Data is the content of the file. It is empty if Err is set.
+	Data []byte
+
+	// Err is the error of the Load, if any.
+	Err error
+}
+
+// Prefetch loads many files in parallel, with at most concurrency loads at the same time. The
+// default concurrency (0) is DefaultPrefetchConcurrency. This is much faster than loading the files
+// one after the other on backends where every Load has a high latency, e.g. network backends.
+// Every file is loaded with LoadDefault.
+//
+// The results are in the same order as paths. A file that fails to load doesn't stop the other
+// loads; its error is reported in its result.
+func Prefetch(l Loader, paths []string, concurrency int) []LoadResult {
+	if concurrency <= 0 {
+		concurrency = DefaultPrefetchConcurrency
+	}
+	if concurrency > len(paths) {
+		concurrency = len(paths)
+	}
+
+	results := make([]LoadResult, len(paths))
+	indexes := make(chan int)
+
+	var wg sync.WaitGroup
+	for i := 0; i < concurrency; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for index := range indexes {
+				data, err := LoadDefault(l, paths[index])
+				results[index] = LoadResult{Path: paths[index], Data: data, Err: err}
+			}
+		}()
+	}
+
+	for index := range paths {
+		indexes <- index
+	}
+	close(indexes)
+	wg.Wait()
+
+	return results
+}
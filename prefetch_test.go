@@ -0,0 +1,105 @@
+package stor_test
+
+import (
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/suite"
+
+	"github.com/pw1/stor"
+	"github.com/pw1/stor/memory"
+)
+
+func TestPrefetchSuite(t *testing.T) {
+	suite.Run(t, new(PrefetchSuite))
+}
+
+// PrefetchSuite contains the tests for Prefetch.
+type PrefetchSuite struct {
+	suite.Suite
+	mem *memory.Memory
+}
+
+func (s *PrefetchSuite) SetupTest() {
+	s.mem, _ = memory.New(&stor.Conf{})
+	for i := 0; i < 20; i++ {
+		s.Require().Nil(s.mem.Save(fmt.Sprintf("dir1/file%d", i), []byte(fmt.Sprint(i))))
+	}
+}
+
+// slowLoader is a stor.Loader with a delay on every Load, which records the highest number of
+// concurrent loads.
+type slowLoader struct {
+	stor.Loader
+
+	mutex   sync.Mutex
+	active  int
+	maxSeen int
+}
+
+func (l *slowLoader) Load(filePath string, maxSize int64) ([]byte, error) {
+	l.mutex.Lock()
+	l.active++
+	if l.active > l.maxSeen {
+		l.maxSeen = l.active
+	}
+	l.mutex.Unlock()
+
+	time.Sleep(5 * time.Millisecond)
+
+	l.mutex.Lock()
+	l.active--
+	l.mutex.Unlock()
+	return l.Loader.Load(filePath, maxSize)
+}
+
+func (s *PrefetchSuite) TestResults() {
+	paths := []string{"dir1/file3", "dir1/missing", "dir1/file1", "../file1", "dir1/file3"}
+
+	results := stor.Prefetch(s.mem, paths, 2)
+	s.Require().Len(results, len(paths))
+	for i, result := range results {
+		s.Equal(paths[i], result.Path)
+	}
+
+	s.Nil(results[0].Err)
+	s.Equal("3", string(results[0].Data))
+	s.True(stor.IsPathDoesntExistError(results[1].Err))
+	s.Equal([]byte{}, results[1].Data)
+	s.Nil(results[2].Err)
+	s.Equal("1", string(results[2].Data))
+	s.True(stor.IsInvalidPathError(results[3].Err))
+	s.Nil(results[4].Err)
+	s.Equal("3", string(results[4].Data))
+}
+
+func (s *PrefetchSuite) TestConcurrency() {
+	paths := make([]string, 20)
+	for i := range paths {
+		paths[i] = fmt.Sprintf("dir1/file%d", i)
+	}
+
+	for _, concurrency := range []int{1, 4, 0} {
+		loader := &slowLoader{Loader: s.mem}
+		results := stor.Prefetch(loader, paths, concurrency)
+		for i, result := range results {
+			s.Nil(result.Err, result.Path)
+			s.Equal(fmt.Sprint(i), string(result.Data), result.Path)
+		}
+
+		expected := concurrency
+		if concurrency == 0 {
+			expected = stor.DefaultPrefetchConcurrency
+		}
+		s.LessOrEqual(loader.maxSeen, expected, concurrency)
+		if concurrency == 1 {
+			s.Equal(1, loader.maxSeen)
+		}
+	}
+}
+
+func (s *PrefetchSuite) TestEmpty() {
+	s.Equal([]stor.LoadResult{}, stor.Prefetch(s.mem, nil, 0))
+}
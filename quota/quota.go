@@ -0,0 +1,224 @@
+// Package quota implements a stor.Storage wrapper that limits the number of entries and the number
+// of bytes per directory, so a runaway producer can't fill a directory until listing it becomes
+// slow for everyone that shares the Storage. It can also limit the size of individual files and
+// the length of listings, so application bugs can't fill a bucket with runaway blobs.
+package quota
+
+import (
+	"errors"
+	"fmt"
+	"path"
+	"strings"
+	"sync"
+
+	"github.com/pw1/stor"
+)
+
+// Limits configures the caps of a Quota. A zero value disables the corresponding cap.
+type Limits struct {
+	// MaxEntries is the maximum number of entries (files and subdirectories) within a directory.
+	MaxEntries int
+
+	// MaxBytes is the maximum total size of the files directly within a directory. Files within
+	// subdirectories are not counted.
+	MaxBytes int64
+
+	// MaxObjectSize is the maximum size of a single file. Larger files are rejected by Save with a
+	// stor.TooLargeError.
+	MaxObjectSize int64
+
+	// MaxListEntries is the maximum number of entries (files and subdirectories) that List
+	// returns. Longer listings fail with a stor.TooLargeError.
+	MaxListEntries int
+}
+
+// LimitExceededError is returned by Save if saving a file would exceed a limit of its directory.
+type LimitExceededError struct {
+	// Dir is the directory of which the limit would be exceeded.
+	Dir string
+
+	// Limit is the name of the exceeded limit: "entries" or "bytes".
+	Limit string
+
+	// Max is the value of the exceeded limit.
+	Max int64
+}
+
+// ErrLimitExceeded is matched by a LimitExceededError with errors.Is.
+var ErrLimitExceeded = errors.New("directory limit exceeded")
+
+func (e *LimitExceededError) Error() string {
+	return fmt.Sprintf("directory %q would exceed the limit of %d %s", e.Dir, e.Max, e.Limit)
+}
+
+// Is returns true for ErrLimitExceeded, so errors.Is works for wrapped errors.
+func (e *LimitExceededError) Is(target error) bool {
+	return target == ErrLimitExceeded
+}
+
+// IsLimitExceededError returns true if an error is, or wraps, a LimitExceededError. Returns false
+// otherwise.
+func IsLimitExceededError(err error) bool {
+	return errors.Is(err, ErrLimitExceeded)
+}
+
+// Quota is a stor.Storage wrapper that enforces Limits on Save. Files that exist already may still
+// be overwritten, as long as the MaxBytes limit isn't exceeded by that.
+//
+// The limits are checked by listing the affected directories before every Save, so they only hold
+// if all writes go through the same Quota. Saves through a Quota are serialized.
+type Quota struct {
+	stor.Storage
+	limits Limits
+	mutex  sync.Mutex
+}
+
+// New creates a new Quota that stores its data in base.
+func New(base stor.Storage, limits Limits) (*Quota, error) {
+	if limits.MaxEntries < 0 || limits.MaxBytes < 0 || limits.MaxObjectSize < 0 ||
+		limits.MaxListEntries < 0 {
+		return nil, fmt.Errorf("Invalid limits %+v: must not be negative", limits)
+	}
+
+	q := &Quota{
+		Storage: base,
+		limits:  limits,
+	}
+	return q, nil
+}
+
+// List returns the files and subdirectories within the specified directory. If there are more
+// than MaxListEntries, then a stor.TooLargeError is returned.
+func (q *Quota) List(dirPath string) ([]string, []string, error) {
+	files, dirs, err := q.Storage.List(dirPath)
+	if err != nil {
+		return files, dirs, err
+	}
+
+	if q.limits.MaxListEntries > 0 && len(files)+len(dirs) > q.limits.MaxListEntries {
+		return []string{}, []string{}, &stor.TooLargeError{What: "list of " + dirPath}
+	}
+	return files, dirs, nil
+}
+
+// Save saves the data to the specified file. If the data is larger than MaxObjectSize, then a
+// stor.TooLargeError is returned. If saving the file would exceed a limit of its directory, then a
+// LimitExceededError is returned.
+func (q *Quota) Save(filePath string, data []byte) error {
+	cleanPath, err := stor.CleanPath(filePath)
+	if err != nil {
+		return err
+	}
+
+	if q.limits.MaxObjectSize > 0 && int64(len(data)) > q.limits.MaxObjectSize {
+		return &stor.TooLargeError{What: cleanPath}
+	}
+
+	if q.limits.MaxEntries == 0 && q.limits.MaxBytes == 0 {
+		return q.Storage.Save(cleanPath, data)
+	}
+
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+
+	if err := q.check(cleanPath, int64(len(data))); err != nil {
+		return err
+	}
+
+	return q.Storage.Save(cleanPath, data)
+}
+
+// check verifies that saving size bytes to a cleaned path stays within the limits. The directories
+// are walked from the root down, because not every Storage can list directories that don't exist.
+func (q *Quota) check(cleanPath string, size int64) error {
+	dir := ""
+	entry := cleanPath
+	for {
+		files, dirs, err := q.Storage.List(dir)
+		if err != nil {
+			return err
+		}
+
+		// The path of the entry within dir that leads to the file
+		var child string
+		if dir == "" {
+			child = firstElement(entry)
+		} else {
+			child = dir + "/" + firstElement(entry)
+		}
+
+		if child == cleanPath {
+			return q.checkDir(dir, files, dirs, cleanPath, size)
+		}
+
+		if !contains(dirs, child) {
+			// The file creates a new subdirectory, and thus a new entry. It is the only file of
+			// its own directory.
+			if q.limits.MaxEntries > 0 && len(files)+len(dirs) >= q.limits.MaxEntries {
+				return &LimitExceededError{dir, "entries", int64(q.limits.MaxEntries)}
+			}
+			if q.limits.MaxBytes > 0 && size > q.limits.MaxBytes {
+				return &LimitExceededError{path.Dir(cleanPath), "bytes", q.limits.MaxBytes}
+			}
+			return nil
+		}
+
+		dir = child
+		entry = cleanPath[len(dir)+1:]
+	}
+}
+
+// checkDir verifies the limits of the directory in which a file is saved.
+func (q *Quota) checkDir(dir string, files, dirs []string, cleanPath string, size int64) error {
+	exists := contains(files, cleanPath)
+	if q.limits.MaxEntries > 0 && !exists && len(files)+len(dirs) >= q.limits.MaxEntries {
+		return &LimitExceededError{dir, "entries", int64(q.limits.MaxEntries)}
+	}
+
+	if q.limits.MaxBytes > 0 {
+		total := size
+		for _, file := range files {
+			if file == cleanPath {
+				continue
+			}
+			meta, err := q.Storage.Meta(file)
+			if err != nil {
+				return err
+			}
+			total += meta.Size
+		}
+		if total > q.limits.MaxBytes {
+			return &LimitExceededError{dir, "bytes", q.limits.MaxBytes}
+		}
+	}
+
+	return nil
+}
+
+// firstElement returns the first element of a slash-separated path.
+func firstElement(filePath string) string {
+	if i := strings.IndexByte(filePath, '/'); i >= 0 {
+		return filePath[:i]
+	}
+	return filePath
+}
+
+// contains returns true if list contains s.
+func contains(list []string, s string) bool {
+	for _, item := range list {
+		if item == s {
+			return true
+		}
+	}
+	return false
+}
+
+// Type returns the Type of the base Storage.
+func (q *Quota) Type() stor.Type {
+	return stor.TypeOf(q.Storage)
+}
+
+// Close closes the base Storage.
+func (q *Quota) Close() error {
+	return stor.Close(q.Storage)
+}
@@ -0,0 +1,144 @@
+package quota
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/suite"
+
+	"github.com/pw1/stor"
+	"github.com/pw1/stor/localdir"
+	"github.com/pw1/stor/memory"
+)
+
+func TestQuotaSuite(t *testing.T) {
+	suite.Run(t, new(QuotaSuite))
+}
+
+// QuotaSuite contains the tests for the Quota wrapper.
+type QuotaSuite struct {
+	suite.Suite
+	base *memory.Memory
+}
+
+func (s *QuotaSuite) SetupTest() {
+	s.base, _ = memory.New(&stor.Conf{})
+}
+
+func (s *QuotaSuite) TestNewInvalid() {
+	_, err := New(s.base, Limits{MaxEntries: -1})
+	s.NotNil(err)
+
+	_, err = New(s.base, Limits{MaxObjectSize: -1})
+	s.NotNil(err)
+}
+
+func (s *QuotaSuite) TestMaxEntries() {
+	q, err := New(s.base, Limits{MaxEntries: 2})
+	s.Require().Nil(err)
+
+	s.Nil(q.Save("dir1/file1", []byte("a")))
+	s.Nil(q.Save("dir1/file2", []byte("b")))
+
+	err = q.Save("dir1/file3", []byte("c"))
+	s.True(IsLimitExceededError(err))
+	s.Equal(&LimitExceededError{Dir: "dir1", Limit: "entries", Max: 2}, err)
+
+	// Overwriting an existing file doesn't add an entry
+	s.Nil(q.Save("dir1/file2", []byte("new")))
+
+	// Other directories have their own limit
+	s.Nil(q.Save("dir2/file1", []byte("a")))
+}
+
+func (s *QuotaSuite) TestMaxEntriesNewSubdir() {
+	q, err := New(s.base, Limits{MaxEntries: 2})
+	s.Require().Nil(err)
+
+	s.Nil(q.Save("file1", []byte("a")))
+	s.Nil(q.Save("dir1/file2", []byte("b")))
+	s.Nil(q.Save("dir1/dir2/file3", []byte("c")))
+
+	// A new subdirectory of the root is an additional entry
+	err = q.Save("dir3/dir4/file4", []byte("d"))
+	s.Equal(&LimitExceededError{Dir: "", Limit: "entries", Max: 2}, err)
+
+	// Existing subdirectories are fine
+	s.Nil(q.Save("dir1/dir2/file5", []byte("e")))
+}
+
+func (s *QuotaSuite) TestMaxBytes() {
+	q, err := New(s.base, Limits{MaxBytes: 10})
+	s.Require().Nil(err)
+
+	s.Nil(q.Save("dir1/file1", []byte("12345")))
+	s.Nil(q.Save("dir1/file2", []byte("12345")))
+
+	err = q.Save("dir1/file3", []byte("1"))
+	s.Equal(&LimitExceededError{Dir: "dir1", Limit: "bytes", Max: 10}, err)
+
+	// The old content of an overwritten file is not counted
+	s.Nil(q.Save("dir1/file2", []byte("54321")))
+	err = q.Save("dir1/file2", []byte("123456"))
+	s.True(IsLimitExceededError(err))
+
+	// Files within subdirectories are not counted
+	s.Nil(q.Save("dir1/dir2/file4", []byte("1234567890")))
+
+	// The limit applies to the first file of a new directory too
+	large := make([]byte, 100)
+	err = q.Save("file5", large)
+	s.Equal(&LimitExceededError{Dir: "", Limit: "bytes", Max: 10}, err)
+	err = q.Save("dir3/file6", large)
+	s.Equal(&LimitExceededError{Dir: "dir3", Limit: "bytes", Max: 10}, err)
+	err = q.Save("dir4/dir5/file7", large)
+	s.Equal(&LimitExceededError{Dir: "dir4/dir5", Limit: "bytes", Max: 10}, err)
+	s.Nil(q.Save("dir4/dir5/file7", []byte("1234567890")))
+
+	data, err := q.Load("dir1/file2", 100)
+	s.Nil(err)
+	s.Equal("54321", string(data))
+}
+
+func (s *QuotaSuite) TestLocalDir() {
+	base, err := localdir.New(&stor.Conf{Path: s.T().TempDir()})
+	s.Require().Nil(err)
+
+	q, err := New(base, Limits{MaxEntries: 1})
+	s.Require().Nil(err)
+
+	s.Nil(q.Save("dir1/dir2/file1", []byte("a")))
+	s.True(IsLimitExceededError(q.Save("dir1/dir2/file2", []byte("b"))))
+	s.True(IsLimitExceededError(q.Save("dir3/file3", []byte("c"))))
+}
+
+func (s *QuotaSuite) TestMaxObjectSize() {
+	q, err := New(s.base, Limits{MaxObjectSize: 5})
+	s.Require().Nil(err)
+
+	s.Nil(q.Save("dir1/file1", []byte("12345")))
+	err = q.Save("dir1/file2", []byte("123456"))
+	s.True(stor.IsTooLargeError(err))
+	s.Equal("dir1/file2 is too large", err.Error())
+
+	_, err = s.base.Meta("dir1/file2")
+	s.True(stor.IsPathDoesntExistError(err))
+}
+
+func (s *QuotaSuite) TestMaxListEntries() {
+	q, err := New(s.base, Limits{MaxListEntries: 2})
+	s.Require().Nil(err)
+
+	s.Require().Nil(q.Save("dir1/file1", []byte("a")))
+	s.Require().Nil(q.Save("dir1/dir2/file2", []byte("b")))
+
+	files, dirs, err := q.List("dir1")
+	s.Nil(err)
+	s.Equal([]string{"dir1/file1"}, files)
+	s.Equal([]string{"dir1/dir2"}, dirs)
+
+	s.Require().Nil(q.Save("dir1/file3", []byte("c")))
+	files, dirs, err = q.List("dir1")
+	s.True(stor.IsTooLargeError(err))
+	s.Empty(files)
+	s.Empty(dirs)
+}
@@ -0,0 +1,213 @@
+// Package ratelimit implements a stor.Storage wrapper that limits the rate of operations and bytes
+// on an underlying Storage, e.g. to keep batch jobs from overwhelming a shared NFS mount, or from
+// triggering the throttling of a cloud storage service.
+//
+// The limits are enforced with token buckets, and are configured separately for reads (Meta, List
+// and Load) and writes (Save and Delete). Operations wait until the limits allow them.
+package ratelimit
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/pw1/stor"
+)
+
+// Rate is the limit of a token bucket. The zero value means unlimited.
+type Rate struct {
+	// PerSecond is the number of tokens (operations or bytes) that is added to the bucket every
+	// second. Zero means unlimited.
+	PerSecond float64
+
+	// Burst is the size of the bucket, i.e. the number of tokens that can be used at once after a
+	// quiet period. If Burst is 0, then it is equal to PerSecond.
+	Burst float64
+}
+
+// Limits configures the rates of a RateLimit.
+type Limits struct {
+	// ReadOps limits the number of Meta, List and Load operations.
+	ReadOps Rate
+
+	// ReadBytes limits the number of bytes that is loaded. The size of a file is only known after
+	// it has been loaded, so a Load that exceeds the limit delays the reads after it.
+	ReadBytes Rate
+
+	// WriteOps limits the number of Save and Delete operations.
+	WriteOps Rate
+
+	// WriteBytes limits the number of bytes that is saved.
+	WriteBytes Rate
+}
+
+// bucket is a token bucket. Tokens can be taken on credit, so requests that are larger than the
+// bucket are possible; they delay the requests after them.
+type bucket struct {
+	rate   float64
+	burst  float64
+	tokens float64
+	last   time.Time
+}
+
+func newBucket(rate Rate, now time.Time) *bucket {
+	if rate.PerSecond == 0 {
+		return nil
+	}
+
+	burst := rate.Burst
+	if burst == 0 {
+		burst = rate.PerSecond
+	}
+	return &bucket{rate: rate.PerSecond, burst: burst, tokens: burst, last: now}
+}
+
+// take takes n tokens from the bucket, and returns how long the caller must wait before the tokens
+// are available. A nil bucket is unlimited.
+func (b *bucket) take(n float64, now time.Time) time.Duration {
+	if b == nil {
+		return 0
+	}
+
+	if now.After(b.last) {
+		b.tokens += now.Sub(b.last).Seconds() * b.rate
+		if b.tokens > b.burst {
+			b.tokens = b.burst
+		}
+		b.last = now
+	}
+
+	b.tokens -= n
+	if b.tokens >= 0 {
+		return 0
+	}
+	return time.Duration(-b.tokens / b.rate * float64(time.Second))
+}
+
+// RateLimit is a stor.Storage wrapper that limits the rate of operations and bytes.
+//
+// If an operation fails with an error that carries a RetryAfter (see stor.RetryAfter), e.g.
+// because the underlying storage throttles requests, then all operations are paused until that
+// time has passed.
+type RateLimit struct {
+	base stor.Storage
+
+	mutex       sync.Mutex
+	readOps     *bucket
+	readBytes   *bucket
+	writeOps    *bucket
+	writeBytes  *bucket
+	pausedUntil time.Time
+
+	now   func() time.Time
+	sleep func(time.Duration)
+}
+
+// New creates a new RateLimit around base.
+func New(base stor.Storage, limits Limits) (*RateLimit, error) {
+	for _, rate := range []Rate{limits.ReadOps, limits.ReadBytes, limits.WriteOps,
+		limits.WriteBytes} {
+		if rate.PerSecond < 0 || rate.Burst < 0 {
+			return nil, fmt.Errorf("Invalid rate limits %+v: must not be negative", limits)
+		}
+	}
+
+	now := time.Now()
+	r := &RateLimit{
+		base:       base,
+		readOps:    newBucket(limits.ReadOps, now),
+		readBytes:  newBucket(limits.ReadBytes, now),
+		writeOps:   newBucket(limits.WriteOps, now),
+		writeBytes: newBucket(limits.WriteBytes, now),
+		now:        time.Now,
+		sleep:      time.Sleep,
+	}
+	return r, nil
+}
+
+// wait takes one token from ops and n tokens from bytes, and waits until they are available and
+// the RateLimit isn't paused.
+func (r *RateLimit) wait(ops, bytes *bucket, n int64) {
+	r.mutex.Lock()
+	now := r.now()
+	delay := ops.take(1, now)
+	if bytesDelay := bytes.take(float64(n), now); bytesDelay > delay {
+		delay = bytesDelay
+	}
+	if pause := r.pausedUntil.Sub(now); pause > delay {
+		delay = pause
+	}
+	r.mutex.Unlock()
+
+	if delay > 0 {
+		r.sleep(delay)
+	}
+}
+
+// done processes the result of an operation: the read bytes are taken from the bucket (delaying
+// the next read), and a RetryAfter in err pauses all operations.
+func (r *RateLimit) done(bytes *bucket, n int64, err error) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	now := r.now()
+	if n > 0 {
+		bytes.take(float64(n), now)
+	}
+
+	if retryAfter, ok := stor.RetryAfter(err); ok {
+		if until := now.Add(retryAfter); until.After(r.pausedUntil) {
+			r.pausedUntil = until
+		}
+	}
+}
+
+// Meta returns meta information about a file.
+func (r *RateLimit) Meta(filePath string) (*stor.Meta, error) {
+	r.wait(r.readOps, nil, 0)
+	meta, err := r.base.Meta(filePath)
+	r.done(nil, 0, err)
+	return meta, err
+}
+
+// List returns the files and subdirectories within the specified directory.
+func (r *RateLimit) List(dirPath string) ([]string, []string, error) {
+	r.wait(r.readOps, nil, 0)
+	files, dirs, err := r.base.List(dirPath)
+	r.done(nil, 0, err)
+	return files, dirs, err
+}
+
+// Load loads the content of the specified file.
+func (r *RateLimit) Load(filePath string, maxSize int64) ([]byte, error) {
+	r.wait(r.readOps, r.readBytes, 0)
+	data, err := r.base.Load(filePath, maxSize)
+	r.done(r.readBytes, int64(len(data)), err)
+	return data, err
+}
+
+// Save saves the data to the specified file.
+func (r *RateLimit) Save(filePath string, data []byte) error {
+	r.wait(r.writeOps, r.writeBytes, int64(len(data)))
+	err := r.base.Save(filePath, data)
+	r.done(nil, 0, err)
+	return err
+}
+
+// Delete removes a file from storage.
+func (r *RateLimit) Delete(filePath string) error {
+	r.wait(r.writeOps, nil, 0)
+	err := r.base.Delete(filePath)
+	r.done(nil, 0, err)
+	return err
+}
+
+// Type returns the Type of the base Storage.
+func (r *RateLimit) Type() stor.Type {
+	return stor.TypeOf(r.base)
+}
+
+// Close closes the base Storage.
+func (r *RateLimit) Close() error {
+	return stor.Close(r.base)
+}
@@ -0,0 +1,172 @@
+package ratelimit
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/suite"
+
+	"github.com/pw1/stor"
+	"github.com/pw1/stor/memory"
+	"github.com/pw1/stor/mock"
+	"github.com/pw1/stor/tester"
+)
+
+// TestRateLimitStorageTester calls the generic storage tests.
+func TestRateLimitStorageTester(t *testing.T) {
+	testSuite := &tester.StorageTester{
+		SetupTestFunc: func(s *tester.StorageTester) {
+			base, _ := memory.New(&stor.Conf{})
+			st, err := New(base, Limits{
+				ReadOps:    Rate{PerSecond: 1e6},
+				ReadBytes:  Rate{PerSecond: 1e9},
+				WriteOps:   Rate{PerSecond: 1e6},
+				WriteBytes: Rate{PerSecond: 1e9},
+			})
+			s.Require().Nil(err)
+			s.Storage = st
+		},
+	}
+
+	suite.Run(t, testSuite)
+}
+
+func TestRateLimitSuite(t *testing.T) {
+	suite.Run(t, new(RateLimitSuite))
+}
+
+// RateLimitSuite contains tests that are specific for the RateLimit wrapper. The tests use a fake
+// clock, which advances when the RateLimit sleeps.
+type RateLimitSuite struct {
+	suite.Suite
+	base  *memory.Memory
+	clock time.Time
+	start time.Time
+}
+
+func (s *RateLimitSuite) SetupTest() {
+	s.base, _ = memory.New(&stor.Conf{})
+	s.start = time.Unix(1e9, 0)
+	s.clock = s.start
+}
+
+func (s *RateLimitSuite) newRateLimit(base stor.Storage, limits Limits) *RateLimit {
+	r, err := New(base, limits)
+	s.Require().Nil(err)
+
+	r.now = func() time.Time { return s.clock }
+	r.sleep = func(d time.Duration) { s.clock = s.clock.Add(d) }
+	for _, b := range []*bucket{r.readOps, r.readBytes, r.writeOps, r.writeBytes} {
+		if b != nil {
+			b.last = s.clock
+		}
+	}
+	return r
+}
+
+// elapsed returns the time that has passed on the fake clock.
+func (s *RateLimitSuite) elapsed() time.Duration {
+	return s.clock.Sub(s.start)
+}
+
+func (s *RateLimitSuite) TestNewInvalid() {
+	_, err := New(s.base, Limits{ReadOps: Rate{PerSecond: -1}})
+	s.NotNil(err)
+
+	_, err = New(s.base, Limits{WriteBytes: Rate{PerSecond: 1, Burst: -1}})
+	s.NotNil(err)
+}
+
+func (s *RateLimitSuite) TestUnlimited() {
+	r := s.newRateLimit(s.base, Limits{})
+
+	for i := 0; i < 100; i++ {
+		s.Nil(r.Save("file1", make([]byte, 1000)))
+		_, err := r.Load("file1", 1000)
+		s.Nil(err)
+	}
+	s.Equal(time.Duration(0), s.elapsed())
+}
+
+func (s *RateLimitSuite) TestReadOps() {
+	r := s.newRateLimit(s.base, Limits{ReadOps: Rate{PerSecond: 10, Burst: 5}})
+	s.Require().Nil(s.base.Save("file1", []byte("test")))
+
+	// The burst is available immediately, after that 10 operations per second
+	for i := 0; i < 25; i++ {
+		_, err := r.Meta("file1")
+		s.Nil(err)
+	}
+	s.Equal(2*time.Second, s.elapsed())
+
+	// Writes have their own limits
+	s.Nil(r.Save("file2", []byte("test")))
+	s.Equal(2*time.Second, s.elapsed())
+}
+
+func (s *RateLimitSuite) TestWriteOps() {
+	r := s.newRateLimit(s.base, Limits{WriteOps: Rate{PerSecond: 2}})
+	s.Require().Nil(s.base.Save("file1", []byte("test")))
+
+	s.Nil(r.Save("file1", []byte("test")))
+	s.Nil(r.Save("file1", []byte("test")))
+	s.Nil(r.Delete("file1"))
+	s.Nil(r.Save("file1", []byte("test")))
+	s.Equal(time.Second, s.elapsed())
+
+	_, _, err := r.List("")
+	s.Nil(err)
+	s.Equal(time.Second, s.elapsed())
+}
+
+func (s *RateLimitSuite) TestWriteBytes() {
+	r := s.newRateLimit(s.base, Limits{WriteBytes: Rate{PerSecond: 1000}})
+
+	s.Nil(r.Save("file1", make([]byte, 1000)))
+	s.Equal(time.Duration(0), s.elapsed())
+
+	// A write that is larger than the bucket is possible, but takes its time
+	s.Nil(r.Save("file2", make([]byte, 3000)))
+	s.Equal(3*time.Second, s.elapsed())
+}
+
+// TestReadBytes verifies that the bytes of a Load delay the next Load.
+func (s *RateLimitSuite) TestReadBytes() {
+	r := s.newRateLimit(s.base, Limits{ReadBytes: Rate{PerSecond: 1000}})
+	s.Require().Nil(s.base.Save("file1", make([]byte, 3000)))
+
+	_, err := r.Load("file1", 3000)
+	s.Nil(err)
+	s.Equal(time.Duration(0), s.elapsed())
+
+	_, err = r.Meta("file1")
+	s.Nil(err)
+	s.Equal(time.Duration(0), s.elapsed())
+
+	_, err = r.Load("file1", 3000)
+	s.Nil(err)
+	s.Equal(2*time.Second, s.elapsed())
+}
+
+// TestRetryAfter verifies that a RetryAfter of the underlying storage pauses all operations.
+func (s *RateLimitSuite) TestRetryAfter() {
+	base, _ := mock.New(&stor.Conf{})
+	r := s.newRateLimit(base, Limits{})
+
+	throttled := &stor.TemporaryError{Err: errors.New("slow down"), RetryAfter: 5 * time.Second}
+	base.On("Save", "file1", []byte("test")).Return(throttled).Once()
+	base.On("Save", "file1", []byte("test")).Return(nil).Once()
+	base.On("Load", "file1", int64(10)).Return([]byte("test"), nil).Once()
+
+	s.True(stor.IsTemporaryError(r.Save("file1", []byte("test"))))
+	s.Equal(time.Duration(0), s.elapsed())
+
+	s.Nil(r.Save("file1", []byte("test")))
+	s.Equal(5*time.Second, s.elapsed())
+
+	_, err := r.Load("file1", 10)
+	s.Nil(err)
+	s.Equal(5*time.Second, s.elapsed())
+	base.AssertExpectations(s.T())
+}
@@ -0,0 +1,26 @@
+package stor
+
+// ReadOnly returns a Storage that serves the files of r, and of which Save and Delete always
+// return a ReadOnlyError. This allows handing out a read-only view of a Storage to code that
+// expects a Storage. The view reports the Type of r, but it doesn't implement io.Closer, so the
+// code that it is handed out to can't close r.
+func ReadOnly(r Reader) Storage {
+	return &readOnly{Reader: r}
+}
+
+// readOnly is the Storage returned by ReadOnly.
+type readOnly struct {
+	Reader
+}
+
+func (r *readOnly) Save(filePath string, data []byte) error {
+	return &ReadOnlyError{Path: filePath}
+}
+
+func (r *readOnly) Delete(filePath string) error {
+	return &ReadOnlyError{Path: filePath}
+}
+
+func (r *readOnly) Type() Type {
+	return TypeOf(r.Reader)
+}
@@ -0,0 +1,44 @@
+package stor_test
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/suite"
+
+	"github.com/pw1/stor"
+	"github.com/pw1/stor/memory"
+	"github.com/pw1/stor/tester"
+)
+
+// TestReadOnlyStorageTester calls the generic storage tests for a read-only view
+func TestReadOnlyStorageTester(t *testing.T) {
+	report := tester.NewReport("ReadOnly")
+	testSuite := &tester.StorageTester{
+		SetupTestFunc: func(s *tester.StorageTester) {
+			base, _ := memory.New(&stor.Conf{})
+			for filePath, content := range tester.StandardFiles {
+				s.Require().Nil(base.Save(filePath, []byte(content)))
+			}
+			s.Storage = stor.ReadOnly(base)
+		},
+		ReadOnly: true,
+		Report:   report,
+	}
+
+	suite.Run(t, testSuite)
+
+	if !report.Passed() || report.Results["TestSaveReadOnly"] != tester.ResultPassed {
+		t.Errorf("Unexpected conformance report: %v", report.Results)
+	}
+}
+
+func TestReadOnlyError(t *testing.T) {
+	err := stor.ReadOnly(nil).Save("dir1/file1", []byte("test"))
+	if !stor.IsReadOnlyError(err) || err.Error() != "can't modify dir1/file1: storage is read-only" {
+		t.Errorf("Unexpected error: %v", err)
+	}
+
+	if stor.IsReadOnlyError(&stor.TooLargeError{}) {
+		t.Errorf("TooLargeError is not a ReadOnlyError")
+	}
+}
@@ -0,0 +1,225 @@
+// Package recorder implements a stor.Storage wrapper that records every operation, with its
+// arguments, results and timing, to a structured log. The log can later be replayed against
+// another Storage (see Replay), e.g. to reproduce a production bug locally, or to build a
+// realistic benchmark.
+//
+// The log consists of JSON objects (see Record), one per line. Loaded data is not recorded, only
+// its size and checksum. Saved data is recorded, unless Options.OmitData is set.
+package recorder
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/pw1/stor"
+)
+
+// Record is a single recorded operation.
+type Record struct {
+	// Op is the operation, e.g. stor.OpLoad.
+	Op string `json:"op"`
+
+	// Path is the path of the file or directory of the operation.
+	Path string `json:"path"`
+
+	// MaxSize is the maxSize argument of Load.
+	MaxSize int64 `json:"maxSize,omitempty"`
+
+	// Data is the saved data of Save, unless Options.OmitData is set.
+	Data []byte `json:"data,omitempty"`
+
+	// Size is the number of bytes that was loaded or saved, or the size reported by Meta.
+	Size int64 `json:"size,omitempty"`
+
+	// Sum is the hex-encoded SHA-256 of the loaded or saved data.
+	Sum string `json:"sum,omitempty"`
+
+	// Files and Dirs are the sorted files and subdirectories returned by List.
+	Files []string `json:"files,omitempty"`
+	Dirs  []string `json:"dirs,omitempty"`
+
+	// Err is the message of the error of the operation, and ErrKind its kind (see ErrorKind).
+	// Both are empty if the operation succeeded.
+	Err     string `json:"err,omitempty"`
+	ErrKind string `json:"errKind,omitempty"`
+
+	// Start is the time at which the operation started, and Duration how long it took.
+	Start    time.Time     `json:"start"`
+	Duration time.Duration `json:"duration"`
+}
+
+// ErrorKind returns the kind of an error of this package, e.g. "path-doesnt-exist" for a
+// stor.PathDoesntExistError, so errors can be compared between Storages. Other errors are
+// "other", and nil is "".
+func ErrorKind(err error) string {
+	switch {
+	case err == nil:
+		return ""
+	case stor.IsPathDoesntExistError(err):
+		return "path-doesnt-exist"
+	case stor.IsTooLargeError(err):
+		return "too-large"
+	case stor.IsInvalidPathError(err):
+		return "invalid-path"
+	case stor.IsReadOnlyError(err):
+		return "read-only"
+	case stor.IsAlreadyExistsError(err):
+		return "already-exists"
+	case stor.IsInsufficientSpaceError(err):
+		return "insufficient-space"
+	case stor.IsDirNotEmptyError(err):
+		return "dir-not-empty"
+	case stor.IsTemporaryError(err):
+		return "temporary"
+	case stor.IsStorageUnavailableError(err):
+		return "unavailable"
+	default:
+		return "other"
+	}
+}
+
+// Options configure a Recorder.
+type Options struct {
+	// OmitData omits the saved data from the log, e.g. because it is confidential, or too large.
+	// Replay then saves zeros of the recorded size.
+	OmitData bool
+}
+
+// Recorder is a stor.Storage wrapper that records every operation to a log.
+type Recorder struct {
+	base    stor.Storage
+	options Options
+
+	// emit receives every Record.
+	emit func(Record)
+
+	// mutex protects the encoder, and err.
+	mutex   sync.Mutex
+	encoder *json.Encoder
+	err     error
+}
+
+// New creates a new Recorder around base, which writes its log to w. It is safe for concurrent
+// use if base is; the Records of concurrent operations are written in the order in which the
+// operations finish.
+func New(base stor.Storage, w io.Writer, options Options) *Recorder {
+	r := &Recorder{
+		base:    base,
+		options: options,
+		encoder: json.NewEncoder(w),
+	}
+	r.emit = r.write
+	return r
+}
+
+// Err returns the first error that occurred while writing the log, or nil. Records are not
+// written anymore after an error.
+func (r *Recorder) Err() error {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	return r.err
+}
+
+// write writes a Record to the log.
+func (r *Recorder) write(rec Record) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	if r.err == nil {
+		r.err = r.encoder.Encode(rec)
+	}
+}
+
+// finish completes a Record with the error and the duration of the operation, and emits it.
+func (r *Recorder) finish(rec Record, err error) {
+	rec.Duration = time.Since(rec.Start)
+	if err != nil {
+		rec.Err = err.Error()
+		rec.ErrKind = ErrorKind(err)
+	}
+	r.emit(rec)
+}
+
+// sum returns the hex-encoded SHA-256 of data.
+func sum(data []byte) string {
+	s := sha256.Sum256(data)
+	return hex.EncodeToString(s[:])
+}
+
+// sorted returns a sorted copy of entries.
+func sorted(entries []string) []string {
+	result := append([]string{}, entries...)
+	sort.Strings(result)
+	return result
+}
+
+// Meta returns meta information about a file.
+func (r *Recorder) Meta(filePath string) (*stor.Meta, error) {
+	rec := Record{Op: stor.OpMeta, Path: filePath, Start: time.Now()}
+	meta, err := r.base.Meta(filePath)
+	if err == nil {
+		rec.Size = meta.Size
+	}
+	r.finish(rec, err)
+	return meta, err
+}
+
+// List returns the files and subdirectories within the specified directory.
+func (r *Recorder) List(dirPath string) ([]string, []string, error) {
+	rec := Record{Op: stor.OpList, Path: dirPath, Start: time.Now()}
+	files, dirs, err := r.base.List(dirPath)
+	if err == nil {
+		rec.Files = sorted(files)
+		rec.Dirs = sorted(dirs)
+	}
+	r.finish(rec, err)
+	return files, dirs, err
+}
+
+// Load loads the content of the specified file.
+func (r *Recorder) Load(filePath string, maxSize int64) ([]byte, error) {
+	rec := Record{Op: stor.OpLoad, Path: filePath, MaxSize: maxSize, Start: time.Now()}
+	data, err := r.base.Load(filePath, maxSize)
+	if err == nil {
+		rec.Size = int64(len(data))
+		rec.Sum = sum(data)
+	}
+	r.finish(rec, err)
+	return data, err
+}
+
+// Save saves the data to the specified file.
+func (r *Recorder) Save(filePath string, data []byte) error {
+	rec := Record{Op: stor.OpSave, Path: filePath, Size: int64(len(data)), Start: time.Now()}
+	if !r.options.OmitData {
+		rec.Data = data
+		rec.Sum = sum(data)
+	}
+	err := r.base.Save(filePath, data)
+	r.finish(rec, err)
+	return err
+}
+
+// Delete removes a file from storage.
+func (r *Recorder) Delete(filePath string) error {
+	rec := Record{Op: stor.OpDelete, Path: filePath, Start: time.Now()}
+	err := r.base.Delete(filePath)
+	r.finish(rec, err)
+	return err
+}
+
+// Type returns the Type of the base Storage.
+func (r *Recorder) Type() stor.Type {
+	return stor.TypeOf(r.base)
+}
+
+// Close closes the base Storage.
+func (r *Recorder) Close() error {
+	return stor.Close(r.base)
+}
@@ -0,0 +1,179 @@
+package recorder
+
+import (
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/suite"
+
+	"github.com/pw1/stor"
+	"github.com/pw1/stor/memory"
+	"github.com/pw1/stor/tester"
+)
+
+// TestRecorderStorageTester calls the generic storage tests for the Recorder.
+func TestRecorderStorageTester(t *testing.T) {
+	testSuite := &tester.StorageTester{
+		SetupTestFunc: func(s *tester.StorageTester) {
+			base, _ := memory.New(&stor.Conf{})
+			s.Storage = New(base, ioutil.Discard, Options{})
+		},
+	}
+
+	suite.Run(t, testSuite)
+}
+
+func TestRecorderSuite(t *testing.T) {
+	suite.Run(t, new(RecorderSuite))
+}
+
+// RecorderSuite contains tests that are specific for the Recorder and Replay.
+type RecorderSuite struct {
+	suite.Suite
+	log      bytes.Buffer
+	recorder *Recorder
+}
+
+func (s *RecorderSuite) SetupTest() {
+	base, _ := memory.New(&stor.Conf{})
+	s.log.Reset()
+	s.recorder = New(base, &s.log, Options{})
+}
+
+// operations performs a few operations on the Recorder.
+func (s *RecorderSuite) operations() {
+	s.Nil(s.recorder.Save("dir1/file1", []byte("test123")))
+	s.Nil(s.recorder.Save("dir1/file2", []byte{}))
+	_, _, err := s.recorder.List("dir1")
+	s.Nil(err)
+	_, err = s.recorder.Load("dir1/file1", 1e6)
+	s.Nil(err)
+	_, err = s.recorder.Load("dir1/file1", 3)
+	s.True(stor.IsTooLargeError(err))
+	_, err = s.recorder.Meta("dir1/file1")
+	s.Nil(err)
+	s.Nil(s.recorder.Delete("dir1/file1"))
+	s.True(stor.IsPathDoesntExistError(s.recorder.Delete("dir1/file1")))
+	_, _, err = s.recorder.List("")
+	s.Nil(err)
+	s.Nil(s.recorder.Err())
+}
+
+// records decodes the log.
+func (s *RecorderSuite) records() []Record {
+	records := []Record{}
+	decoder := json.NewDecoder(bytes.NewReader(s.log.Bytes()))
+	for decoder.More() {
+		var rec Record
+		s.Require().Nil(decoder.Decode(&rec))
+		records = append(records, rec)
+	}
+	return records
+}
+
+func (s *RecorderSuite) TestRecord() {
+	s.operations()
+
+	records := s.records()
+	s.Require().Len(records, 9)
+	s.Equal(Record{Op: stor.OpSave, Path: "dir1/file1", Data: []byte("test123"), Size: 7,
+		Sum: sum([]byte("test123"))}, withoutTiming(records[0]))
+	s.Equal(Record{Op: stor.OpList, Path: "dir1", Files: []string{"dir1/file1", "dir1/file2"}},
+		withoutTiming(records[2]))
+	s.Equal(Record{Op: stor.OpLoad, Path: "dir1/file1", MaxSize: 1e6, Size: 7,
+		Sum: sum([]byte("test123"))}, withoutTiming(records[3]))
+	s.Equal("too-large", records[4].ErrKind)
+	s.NotEmpty(records[4].Err)
+	s.Equal(int64(7), records[5].Size)
+	s.Equal("path-doesnt-exist", records[7].ErrKind)
+	s.False(records[0].Start.IsZero())
+}
+
+// withoutTiming returns a Record without its timing.
+func withoutTiming(rec Record) Record {
+	rec.Start = time.Time{}
+	rec.Duration = 0
+	return rec
+}
+
+func (s *RecorderSuite) TestOmitData() {
+	base, _ := memory.New(&stor.Conf{})
+	s.recorder = New(base, &s.log, Options{OmitData: true})
+	s.operations()
+
+	records := s.records()
+	s.Nil(records[0].Data)
+	s.Equal(int64(7), records[0].Size)
+
+	// Zeros are saved instead, so the checksum of the Load differs
+	replay, _ := memory.New(&stor.Conf{})
+	mismatches, err := Replay(&s.log, replay, ReplayOptions{})
+	s.Nil(err)
+	s.Require().Len(mismatches, 1)
+	s.Equal(3, mismatches[0].Index)
+	data, _ := replay.Load("dir1/file2", 1e6)
+	s.Equal([]byte{}, data)
+}
+
+func (s *RecorderSuite) TestReplay() {
+	s.operations()
+
+	replay, _ := memory.New(&stor.Conf{})
+	mismatches, err := Replay(&s.log, replay, ReplayOptions{})
+	s.Nil(err)
+	s.Empty(mismatches)
+
+	files, _, err := replay.List("dir1")
+	s.Nil(err)
+	s.Equal([]string{"dir1/file2"}, files)
+}
+
+func (s *RecorderSuite) TestReplayMismatch() {
+	s.operations()
+
+	// The file already exists, so the second Delete succeeds
+	replay, _ := memory.New(&stor.Conf{})
+	s.Require().Nil(replay.Save("file3", []byte("test456")))
+	mismatches, err := Replay(&s.log, replay, ReplayOptions{})
+	s.Nil(err)
+	s.Require().Len(mismatches, 1)
+	s.Equal(8, mismatches[0].Index)
+	s.Equal([]string{"file3"}, mismatches[0].Replayed.Files)
+	s.Equal(`operation 8 (list ): recorded files [] and dirs [dir1], replayed files [file3] `+
+		`and dirs [dir1]`, mismatches[0].String())
+}
+
+func (s *RecorderSuite) TestReplayTiming() {
+	start := time.Now()
+	for i, offset := range []time.Duration{0, time.Hour, 3 * time.Hour} {
+		rec := Record{Op: stor.OpSave, Path: "file1", Data: []byte{byte(i)}, Size: 1,
+			Start: start.Add(offset)}
+		s.Require().Nil(json.NewEncoder(&s.log).Encode(rec))
+	}
+
+	sleeps := []time.Duration{}
+	sleep = func(d time.Duration) { sleeps = append(sleeps, d) }
+	defer func() { sleep = time.Sleep }()
+
+	replay, _ := memory.New(&stor.Conf{})
+	mismatches, err := Replay(&s.log, replay, ReplayOptions{Timing: true})
+	s.Nil(err)
+	s.Empty(mismatches)
+	s.Require().Len(sleeps, 2)
+	s.InDelta(float64(time.Hour), float64(sleeps[0]), float64(time.Minute))
+	s.InDelta(float64(3*time.Hour), float64(sleeps[1]), float64(time.Minute))
+}
+
+func (s *RecorderSuite) TestReplayInvalid() {
+	replay, _ := memory.New(&stor.Conf{})
+	_, err := Replay(strings.NewReader(`{"op": "rename", "path": "dir1"}`), replay,
+		ReplayOptions{})
+	s.EqualError(err, `operation 0 has unknown op "rename"`)
+
+	_, err = Replay(strings.NewReader(`{"op": `), replay, ReplayOptions{})
+	s.NotNil(err)
+}
@@ -0,0 +1,125 @@
+package recorder
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"reflect"
+	"time"
+
+	"github.com/pw1/stor"
+)
+
+// sleep waits between replayed operations. It is replaced in tests.
+var sleep = time.Sleep
+
+// ReplayOptions configure Replay.
+type ReplayOptions struct {
+	// Timing replays the operations at the same pace as they were recorded: each operation
+	// starts at the same offset from the first one as in the log, or later if the Storage is
+	// slower. Without it, the operations are replayed as fast as possible.
+	Timing bool
+}
+
+// Mismatch is a replayed operation of which the result differs from the recorded result.
+type Mismatch struct {
+	// Index is the index of the operation in the log, starting at 0.
+	Index int
+
+	// Recorded is the Record from the log, and Replayed the Record of the replayed operation.
+	Recorded Record
+	Replayed Record
+}
+
+func (m Mismatch) String() string {
+	return fmt.Sprintf("operation %d (%s %s): recorded %s, replayed %s", m.Index, m.Recorded.Op,
+		m.Recorded.Path, result(m.Recorded), result(m.Replayed))
+}
+
+// result describes the result of an operation, for Mismatch.String.
+func result(rec Record) string {
+	switch {
+	case rec.Err != "":
+		return fmt.Sprintf("error %q", rec.Err)
+	case rec.Op == stor.OpList:
+		return fmt.Sprintf("files %v and dirs %v", rec.Files, rec.Dirs)
+	default:
+		return fmt.Sprintf("size %d", rec.Size)
+	}
+}
+
+// Replay reads a log that was written by a Recorder, and performs its operations in order on st.
+// It returns the operations of which the result differs from the recorded result: a different
+// kind of error (see ErrorKind), or different sizes, checksums or directory entries. The error
+// messages themselves are not compared, because they differ between Storages. An error is
+// returned if the log can't be read.
+func Replay(r io.Reader, st stor.Storage, options ReplayOptions) ([]Mismatch, error) {
+	var replayed Record
+	recorder := &Recorder{base: st, emit: func(rec Record) { replayed = rec }}
+
+	mismatches := []Mismatch{}
+	decoder := json.NewDecoder(r)
+	var first time.Time
+	replayStart := time.Now()
+	for index := 0; ; index++ {
+		var rec Record
+		if err := decoder.Decode(&rec); err == io.EOF {
+			return mismatches, nil
+		} else if err != nil {
+			return mismatches, fmt.Errorf("failed to read operation %d: %v", index, err)
+		}
+
+		if options.Timing {
+			if index == 0 {
+				first = rec.Start
+			}
+			if wait := rec.Start.Sub(first) - time.Since(replayStart); wait > 0 {
+				sleep(wait)
+			}
+		}
+
+		switch rec.Op {
+		case stor.OpMeta:
+			recorder.Meta(rec.Path)
+		case stor.OpList:
+			recorder.List(rec.Path)
+		case stor.OpLoad:
+			recorder.Load(rec.Path, rec.MaxSize)
+		case stor.OpSave:
+			data := rec.Data
+			if data == nil {
+				data = make([]byte, rec.Size)
+			}
+			recorder.Save(rec.Path, data)
+		case stor.OpDelete:
+			recorder.Delete(rec.Path)
+		default:
+			return mismatches, fmt.Errorf("operation %d has unknown op %q", index, rec.Op)
+		}
+
+		if !sameResult(rec, replayed) {
+			mismatches = append(mismatches, Mismatch{Index: index, Recorded: rec, Replayed: replayed})
+		}
+	}
+}
+
+// sameResult checks whether a replayed operation has the same result as the recorded one.
+func sameResult(recorded, replayed Record) bool {
+	if recorded.ErrKind != replayed.ErrKind {
+		return false
+	}
+	if recorded.Err != "" {
+		return true
+	}
+	return recorded.Size == replayed.Size && (recorded.Sum == "" || recorded.Sum == replayed.Sum) &&
+		sameEntries(recorded.Files, replayed.Files) && sameEntries(recorded.Dirs, replayed.Dirs)
+}
+
+// sameEntries checks whether two lists of directory entries are equal. Empty lists are omitted
+// from the log, so nil equals an empty list.
+func sameEntries(a, b []string) bool {
+	if len(a) == 0 && len(b) == 0 {
+		return true
+	}
+	return reflect.DeepEqual(a, b)
+}
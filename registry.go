@@ -0,0 +1,306 @@
+package stor
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+)
+
+// Registry contains storage Types with their Factory, Validator, Info and URL schemes. The
+// package level functions (RegisterType, New, NewFromURL, etc.) use DefaultRegistry, in which the
+// backends register themselves. A separate Registry is useful in tests, e.g. to register fake
+// Types without unique names, or without affecting tests that run in parallel. A Registry is safe
+// for concurrent use.
+type Registry struct {
+	mutex      sync.RWMutex
+	factories  map[Type]Factory
+	validators map[Type]Validator
+	infos      map[Type]Info
+	schemes    map[string]urlScheme
+
+	conformance map[Type]map[Capability]ConformanceResult
+}
+
+// DefaultRegistry is the Registry that is used by the package level functions.
+var DefaultRegistry = NewRegistry()
+
+// NewRegistry creates an empty Registry.
+func NewRegistry() *Registry {
+	return &Registry{
+		factories:  make(map[Type]Factory),
+		validators: make(map[Type]Validator),
+		infos:      make(map[Type]Info),
+		schemes:    make(map[string]urlScheme),
+
+		conformance: make(map[Type]map[Capability]ConformanceResult),
+	}
+}
+
+// Clone returns a copy of the Registry. Changes to the copy don't affect the original, so a test
+// can start from a clone of DefaultRegistry (with all backends that are linked in) and register
+// or replace Types as it likes.
+func (r *Registry) Clone() *Registry {
+	r.mutex.RLock()
+	defer r.mutex.RUnlock()
+
+	clone := NewRegistry()
+	for storageType, factory := range r.factories {
+		clone.factories[storageType] = factory
+	}
+	for storageType, validator := range r.validators {
+		clone.validators[storageType] = validator
+	}
+	for storageType, info := range r.infos {
+		clone.infos[storageType] = info
+	}
+	for name, scheme := range r.schemes {
+		clone.schemes[name] = scheme
+	}
+	for storageType, results := range r.conformance {
+		clone.conformance[storageType] = results
+	}
+	return clone
+}
+
+// RegisterType registers a new Type and its Factory. See the package level RegisterType.
+func (r *Registry) RegisterType(storageType Type, factory Factory) {
+	if len(storageType) > MaxTypeLen {
+		panic(fmt.Sprintf("stor: name of Type %s is too long", storageType))
+	}
+
+	if storageType == TypeUnspecified {
+		panic("stor: undefined Type")
+	}
+
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	if _, ok := r.factories[storageType]; ok {
+		panic(fmt.Sprintf("stor: Type %s is already registered", storageType))
+	}
+
+	r.factories[storageType] = factory
+}
+
+// ReplaceType replaces the Factory of a registered Type, and returns a function that restores the
+// previous Factory. The Validator, Info and URL schemes of the Type are kept. It is intended for
+// tests, e.g. to inject a mock in code that creates its Storage with New:
+//
+//	restore := registry.ReplaceType(localdir.LocalDirStorageType, mockFactory)
+//	defer restore()
+//
+// If the Type is not registered, then this function will panic.
+func (r *Registry) ReplaceType(storageType Type, factory Factory) (restore func()) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	previous, ok := r.factories[storageType]
+	if !ok {
+		panic(fmt.Sprintf("stor: Type %s is not registered", storageType))
+	}
+
+	r.factories[storageType] = factory
+	return func() {
+		r.mutex.Lock()
+		defer r.mutex.Unlock()
+		r.factories[storageType] = previous
+	}
+}
+
+// UnregisterType removes a Type, together with its Validator, Info, conformance outcome and URL
+// schemes, so that it can be registered again. It returns false if the Type was not registered.
+func (r *Registry) UnregisterType(storageType Type) bool {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	if _, ok := r.factories[storageType]; !ok {
+		return false
+	}
+
+	delete(r.factories, storageType)
+	delete(r.validators, storageType)
+	delete(r.infos, storageType)
+	delete(r.conformance, storageType)
+	for name, scheme := range r.schemes {
+		if scheme.storageType == storageType {
+			delete(r.schemes, name)
+		}
+	}
+	return true
+}
+
+// RegisterValidator registers the Validator of a registered Type. See the package level
+// RegisterValidator.
+func (r *Registry) RegisterValidator(storageType Type, validator Validator) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	if _, ok := r.factories[storageType]; !ok {
+		panic(fmt.Sprintf("stor: Type %s is not registered", storageType))
+	}
+
+	if _, ok := r.validators[storageType]; ok {
+		panic(fmt.Sprintf("stor: Type %s already has a Validator", storageType))
+	}
+
+	r.validators[storageType] = validator
+}
+
+// RegisterInfo registers the Info of a registered Type. See the package level RegisterInfo.
+func (r *Registry) RegisterInfo(info Info) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	if _, ok := r.factories[info.Type]; !ok {
+		panic(fmt.Sprintf("stor: Type %s is not registered", info.Type))
+	}
+
+	if _, ok := r.infos[info.Type]; ok {
+		panic(fmt.Sprintf("stor: Type %s already has an Info", info.Type))
+	}
+
+	options := append([]OptionInfo{}, info.Options...)
+	sort.Slice(options, func(i, j int) bool {
+		return options[i].Name < options[j].Name
+	})
+	info.Options = options
+
+	r.infos[info.Type] = info
+}
+
+// RegisterConformance registers the outcome of the conformance tests of a Type. See the package
+// level RegisterConformance.
+func (r *Registry) RegisterConformance(storageType Type, results map[Capability]ConformanceResult) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	if _, ok := r.factories[storageType]; !ok {
+		panic(fmt.Sprintf("stor: Type %s is not registered", storageType))
+	}
+
+	// Copy the results, so the caller can't modify the registered outcome
+	copied := make(map[Capability]ConformanceResult, len(results))
+	for capability, result := range results {
+		copied[capability] = result
+	}
+	r.conformance[storageType] = copied
+}
+
+// RegisterScheme registers a URL scheme for a Type. See the package level RegisterScheme.
+func (r *Registry) RegisterScheme(scheme string, storageType Type, path URLPathFunc) {
+	if scheme == "" {
+		panic("stor: undefined URL scheme")
+	}
+
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	if _, ok := r.schemes[scheme]; ok {
+		panic(fmt.Sprintf("stor: URL scheme %s is already registered", scheme))
+	}
+
+	if path == nil {
+		path = hostAndPath
+	}
+	r.schemes[scheme] = urlScheme{storageType: storageType, path: path}
+}
+
+// lookupScheme returns a registered URL scheme.
+func (r *Registry) lookupScheme(scheme string) (urlScheme, bool) {
+	r.mutex.RLock()
+	defer r.mutex.RUnlock()
+
+	s, ok := r.schemes[scheme]
+	return s, ok
+}
+
+// Validate checks conf with the Validator of its Type. See the package level Validate.
+func (r *Registry) Validate(conf *Conf) error {
+	_, err := r.validate(conf)
+	return err
+}
+
+// validate checks conf, and returns the Factory of its Type.
+func (r *Registry) validate(conf *Conf) (Factory, error) {
+	if conf.Type == TypeUnspecified {
+		return nil, &UnspecifiedTypeError{}
+	}
+
+	r.mutex.RLock()
+	factory, ok := r.factories[conf.Type]
+	validator, hasValidator := r.validators[conf.Type]
+	r.mutex.RUnlock()
+
+	if !ok {
+		return nil, &UnregisteredTypeError{conf.Type}
+	}
+
+	if hasValidator {
+		if err := validator(conf); err != nil {
+			return nil, err
+		}
+	}
+	return factory, nil
+}
+
+// New creates a new Storage object based on conf. See the package level New.
+func (r *Registry) New(conf *Conf) (Storage, error) {
+	factory, err := r.validate(conf)
+	if err != nil {
+		return nil, err
+	}
+
+	return factory(conf)
+}
+
+// NewFromURL creates a new Storage object based on a storage URL. See the package level
+// NewFromURL.
+func (r *Registry) NewFromURL(rawURL string) (Storage, error) {
+	conf, err := r.ParseURL(rawURL)
+	if err != nil {
+		return nil, err
+	}
+	return r.New(conf)
+}
+
+// Types returns all registered Types, sorted by name.
+func (r *Registry) Types() []Type {
+	r.mutex.RLock()
+	defer r.mutex.RUnlock()
+
+	types := make([]Type, 0, len(r.factories))
+	for storageType := range r.factories {
+		types = append(types, storageType)
+	}
+	sort.Slice(types, func(i, j int) bool {
+		return types[i] < types[j]
+	})
+	return types
+}
+
+// TypeInfo returns the Info of a registered Type. See the package level TypeInfo.
+func (r *Registry) TypeInfo(storageType Type) (Info, error) {
+	r.mutex.RLock()
+	defer r.mutex.RUnlock()
+
+	if _, ok := r.factories[storageType]; !ok {
+		return Info{}, &UnregisteredTypeError{storageType}
+	}
+
+	info, ok := r.infos[storageType]
+	if !ok {
+		info = Info{Type: storageType}
+	} else {
+		// Copy the slices, so the caller can't modify the registered Info
+		info.Options = append([]OptionInfo{}, info.Options...)
+		info.Capabilities = append([]Capability{}, info.Capabilities...)
+	}
+
+	if results, ok := r.conformance[storageType]; ok {
+		info.Conformance = make(map[Capability]ConformanceResult, len(results))
+		for capability, result := range results {
+			info.Conformance[capability] = result
+		}
+	}
+	return info, nil
+}
@@ -0,0 +1,176 @@
+package stor
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/suite"
+)
+
+func TestRegistrySuite(t *testing.T) {
+	suite.Run(t, new(RegistrySuite))
+}
+
+// RegistrySuite contains the tests for the Registry.
+type RegistrySuite struct {
+	suite.Suite
+	registry *Registry
+}
+
+// fakeStorage is a Storage that is only used to tell factories apart.
+type fakeStorage struct {
+	Storage
+	name string
+}
+
+func fakeFactory(name string) Factory {
+	return func(conf *Conf) (Storage, error) {
+		return &fakeStorage{name: name}, nil
+	}
+}
+
+func (s *RegistrySuite) SetupTest() {
+	// Every test gets its own Registry, so the same names can be used in all tests
+	s.registry = NewRegistry()
+	s.registry.RegisterType("Fake", fakeFactory("original"))
+	s.registry.RegisterScheme("fake", "Fake", nil)
+	s.registry.RegisterInfo(Info{Type: "Fake", Description: "Fake storage"})
+	s.registry.RegisterValidator("Fake", func(conf *Conf) error {
+		if conf.Path == "" {
+			return &ConfError{Type: conf.Type, Field: "Path", Msg: "is required"}
+		}
+		return nil
+	})
+}
+
+// newName creates a Storage and returns the name of its factory.
+func (s *RegistrySuite) newName(conf *Conf) string {
+	st, err := s.registry.New(conf)
+	s.Require().Nil(err)
+	return st.(*fakeStorage).name
+}
+
+func (s *RegistrySuite) TestNew() {
+	s.Equal("original", s.newName(&Conf{Type: "Fake", Path: "test"}))
+
+	_, err := s.registry.New(&Conf{Type: "Fake"})
+	s.True(IsConfError(err))
+
+	_, err = s.registry.New(&Conf{})
+	s.True(IsUnspecifiedTypeError(err))
+}
+
+func (s *RegistrySuite) TestSeparateFromDefault() {
+	s.Equal([]Type{"Fake"}, s.registry.Types())
+
+	_, err := New(&Conf{Type: "Fake", Path: "test"})
+	s.True(IsUnregisteredTypeError(err))
+
+	_, err = NewRegistry().New(&Conf{Type: "Fake", Path: "test"})
+	s.True(IsUnregisteredTypeError(err))
+}
+
+func (s *RegistrySuite) TestNewFromURL() {
+	st, err := s.registry.NewFromURL("fake://dir")
+	s.Require().Nil(err)
+	s.Equal("original", st.(*fakeStorage).name)
+
+	_, err = NewFromURL("fake://dir")
+	s.NotNil(err)
+}
+
+func (s *RegistrySuite) TestReplaceType() {
+	restore := s.registry.ReplaceType("Fake", fakeFactory("replacement"))
+	s.Equal("replacement", s.newName(&Conf{Type: "Fake", Path: "test"}))
+
+	// The Validator and Info are kept
+	_, err := s.registry.New(&Conf{Type: "Fake"})
+	s.True(IsConfError(err))
+	info, err := s.registry.TypeInfo("Fake")
+	s.Nil(err)
+	s.Equal("Fake storage", info.Description)
+
+	restore()
+	s.Equal("original", s.newName(&Conf{Type: "Fake", Path: "test"}))
+}
+
+func (s *RegistrySuite) TestReplaceTypeUnregistered() {
+	s.Panics(func() {
+		s.registry.ReplaceType("Unknown", fakeFactory("replacement"))
+	})
+}
+
+func (s *RegistrySuite) TestUnregisterType() {
+	s.registry.RegisterConformance("Fake",
+		map[Capability]ConformanceResult{CapabilityRename: ConformancePassed})
+	s.True(s.registry.UnregisterType("Fake"))
+	s.False(s.registry.UnregisterType("Fake"))
+
+	_, err := s.registry.New(&Conf{Type: "Fake", Path: "test"})
+	s.True(IsUnregisteredTypeError(err))
+	_, err = s.registry.ParseURL("fake://dir")
+	s.NotNil(err)
+	s.Equal([]Type{}, s.registry.Types())
+
+	// The Type, its Validator, Info and scheme can be registered again
+	s.registry.RegisterType("Fake", fakeFactory("again"))
+	s.registry.RegisterValidator("Fake", func(conf *Conf) error { return nil })
+	s.registry.RegisterInfo(Info{Type: "Fake"})
+	s.registry.RegisterScheme("fake", "Fake", nil)
+	s.Equal("again", s.newName(&Conf{Type: "Fake"}))
+
+	// The conformance outcome of the removed Type doesn't apply to the new registration
+	info, err := s.registry.TypeInfo("Fake")
+	s.Nil(err)
+	s.Nil(info.Conformance)
+}
+
+func (s *RegistrySuite) TestClone() {
+	clone := s.registry.Clone()
+	clone.RegisterType("Other", fakeFactory("other"))
+	clone.ReplaceType("Fake", fakeFactory("clone"))
+
+	s.Equal([]Type{"Fake", "Other"}, clone.Types())
+	s.Equal([]Type{"Fake"}, s.registry.Types())
+	s.Equal("original", s.newName(&Conf{Type: "Fake", Path: "test"}))
+
+	st, err := clone.New(&Conf{Type: "Fake", Path: "test"})
+	s.Require().Nil(err)
+	s.Equal("clone", st.(*fakeStorage).name)
+}
+
+func (s *RegistrySuite) TestDuplicates() {
+	s.Panics(func() {
+		s.registry.RegisterType("Fake", fakeFactory("duplicate"))
+	})
+	s.Panics(func() {
+		s.registry.RegisterScheme("fake", "Fake", nil)
+	})
+	s.Panics(func() {
+		s.registry.RegisterInfo(Info{Type: "Fake"})
+	})
+}
+
+func (s *RegistrySuite) TestRegisterConformance() {
+	info, err := s.registry.TypeInfo("Fake")
+	s.Nil(err)
+	s.Nil(info.Conformance)
+
+	results := map[Capability]ConformanceResult{CapabilityRename: ConformancePassed}
+	s.registry.RegisterConformance("Fake", results)
+	results[CapabilityRename] = ConformanceFailed
+
+	info, err = s.registry.TypeInfo("Fake")
+	s.Nil(err)
+	s.Equal("Fake storage", info.Description)
+	s.Equal(map[Capability]ConformanceResult{CapabilityRename: ConformancePassed}, info.Conformance)
+
+	// The outcome is replaced by a new registration
+	s.registry.RegisterConformance("Fake", map[Capability]ConformanceResult{})
+	info, err = s.registry.TypeInfo("Fake")
+	s.Nil(err)
+	s.Empty(info.Conformance)
+
+	s.Panics(func() {
+		s.registry.RegisterConformance("Other", results)
+	})
+}
@@ -0,0 +1,68 @@
+package stor
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Renamer can natively move all files within a directory to another directory.
+type Renamer interface {
+	// Rename moves all files within the directory oldPath (and its subdirectories) to newPath.
+	// Existing files in newPath with the same name are overwritten. It is not an error if oldPath
+	// doesn't contain any files. The paths are slash-separated, and must not be nested within each
+	// other.
+	Rename(oldPath, newPath string) error
+}
+
+// RenamePrefix moves all files within the directory oldPrefix (and its subdirectories) to
+// newPrefix. If s implements Renamer, then the native rename is used. Otherwise each file is
+// loaded, saved under its new path, and then deleted. The maxSize argument is the maximum
+// accepted size of a single file in that case.
+//
+// Every file is deleted from oldPrefix directly after it has been saved in newPrefix. The state
+// in s therefore records the progress of the rename: if RenamePrefix fails half-way, calling it
+// again with the same arguments resumes the rename.
+func RenamePrefix(s Storage, oldPrefix, newPrefix string, maxSize int64) error {
+	oldPath, newPath, err := cleanRenamePaths(oldPrefix, newPrefix)
+	if err != nil {
+		return err
+	}
+
+	if renamer, ok := s.(Renamer); ok {
+		return renamer.Rename(oldPath, newPath)
+	}
+
+	return walkFiles(s, oldPath, func(filePath string) error {
+		data, err := s.Load(filePath, maxSize)
+		if err != nil {
+			return err
+		}
+
+		if err := s.Save(newPath+"/"+relativePath(oldPath, filePath), data); err != nil {
+			return err
+		}
+
+		return s.Delete(filePath)
+	})
+}
+
+// cleanRenamePaths cleans the paths of a rename, and verifies that they are not nested.
+func cleanRenamePaths(oldPrefix, newPrefix string) (string, string, error) {
+	oldPath, err := CleanPath(oldPrefix)
+	if err != nil {
+		return "", "", err
+	}
+
+	newPath, err := CleanPath(newPrefix)
+	if err != nil {
+		return "", "", err
+	}
+
+	if oldPath == "" || newPath == "" || oldPath == newPath ||
+		strings.HasPrefix(newPath, oldPath+"/") || strings.HasPrefix(oldPath, newPath+"/") {
+		msg := fmt.Sprintf("can't rename %s to %s, the paths are nested", oldPath, newPath)
+		return "", "", &InvalidPathError{Path: newPrefix, Msg: msg}
+	}
+
+	return oldPath, newPath, nil
+}
@@ -0,0 +1,102 @@
+package stor_test
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/suite"
+
+	"github.com/pw1/stor"
+	"github.com/pw1/stor/memory"
+)
+
+func TestRenameSuite(t *testing.T) {
+	suite.Run(t, &RenameSuite{})
+	suite.Run(t, &RenameSuite{plain: true})
+}
+
+// plainStorage hides all optional interfaces (such as stor.Renamer) of a Storage.
+type plainStorage struct {
+	stor.Storage
+}
+
+// RenameSuite contains the tests for RenamePrefix. If plain is set, then the tests run against a
+// Storage that doesn't implement stor.Renamer.
+type RenameSuite struct {
+	suite.Suite
+	plain bool
+	st    stor.Storage
+}
+
+func (s *RenameSuite) SetupTest() {
+	mem, _ := memory.New(&stor.Conf{})
+	s.st = mem
+	if s.plain {
+		s.st = plainStorage{mem}
+	}
+
+	files := map[string]string{
+		"file1":           "test123",
+		"dir1/file2":      "test456",
+		"dir1/file3":      "test789",
+		"dir1/dir4/file5": "test788909",
+		"dir2/dir3/file4": "test0123",
+	}
+	for filePath, content := range files {
+		s.Require().Nil(s.st.Save(filePath, []byte(content)))
+	}
+}
+
+func (s *RenameSuite) TestRenamePrefix() {
+	err := stor.RenamePrefix(s.st, "dir1", "new/dir", 1e6)
+	s.Nil(err)
+
+	files, dirs, err := s.st.List("")
+	s.Nil(err)
+	s.ElementsMatch([]string{"file1"}, files)
+	s.ElementsMatch([]string{"dir2", "new"}, dirs)
+
+	files, dirs, err = s.st.List("new/dir")
+	s.Nil(err)
+	s.ElementsMatch([]string{"new/dir/file2", "new/dir/file3"}, files)
+	s.ElementsMatch([]string{"new/dir/dir4"}, dirs)
+
+	data, err := s.st.Load("new/dir/dir4/file5", 1e6)
+	s.Nil(err)
+	s.Equal([]byte("test788909"), data)
+}
+
+func (s *RenameSuite) TestRenamePrefixMerge() {
+	err := stor.RenamePrefix(s.st, "dir1/dir4", "dir2/dir3", 1e6)
+	s.Nil(err)
+
+	files, _, err := s.st.List("dir2/dir3")
+	s.Nil(err)
+	s.ElementsMatch([]string{"dir2/dir3/file4", "dir2/dir3/file5"}, files)
+
+	_, dirs, err := s.st.List("dir1")
+	s.Nil(err)
+	s.Empty(dirs)
+}
+
+func (s *RenameSuite) TestRenamePrefixNonExisting() {
+	s.Nil(stor.RenamePrefix(s.st, "nope", "dir5", 1e6))
+}
+
+func (s *RenameSuite) TestRenamePrefixNested() {
+	err := stor.RenamePrefix(s.st, "dir1", "dir1/sub", 1e6)
+	s.True(stor.IsInvalidPathError(err))
+
+	err = stor.RenamePrefix(s.st, "dir1/dir4", "dir1", 1e6)
+	s.True(stor.IsInvalidPathError(err))
+
+	err = stor.RenamePrefix(s.st, "dir1", "dir1/", 1e6)
+	s.True(stor.IsInvalidPathError(err))
+
+	err = stor.RenamePrefix(s.st, "", "dir5", 1e6)
+	s.True(stor.IsInvalidPathError(err))
+}
+
+func (s *RenameSuite) TestRenamePrefixInvalid() {
+	err := stor.RenamePrefix(s.st, "../dir1", "dir5", 1e6)
+	s.True(stor.IsInvalidPathError(err))
+}
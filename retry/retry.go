@@ -0,0 +1,144 @@
+// Package retry implements a stor.Storage wrapper that retries operations which fail with a
+// retryable error (see stor.IsRetryable).
+package retry
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/pw1/stor"
+)
+
+const (
+	// DefaultAttempts is the number of attempts that is used if none is configured.
+	DefaultAttempts = 3
+
+	// DefaultBackoff is the first backoff that is used if none is configured.
+	DefaultBackoff = 100 * time.Millisecond
+
+	// DefaultMaxBackoff is the maximum backoff that is used if none is configured.
+	DefaultMaxBackoff = 10 * time.Second
+)
+
+// Policy configures how often and how long a Retry waits between attempts. Zero values are replaced
+// by the defaults.
+type Policy struct {
+	// Attempts is the maximum number of attempts per operation, including the first one.
+	Attempts int
+
+	// Backoff is the time to wait after the first failed attempt. It doubles after every
+	// subsequent failed attempt, up to MaxBackoff.
+	Backoff time.Duration
+
+	// MaxBackoff limits the time to wait between attempts. It also limits the RetryAfter that is
+	// requested by a backend.
+	MaxBackoff time.Duration
+}
+
+// Retry is a stor.Storage wrapper that retries operations that fail with a retryable error (see
+// stor.IsRetryable). If the error specifies a RetryAfter, then Retry waits exactly that long (up to
+// MaxBackoff) before the next attempt. Otherwise, it backs off exponentially.
+type Retry struct {
+	base   stor.Storage
+	policy Policy
+	sleep  func(time.Duration)
+}
+
+// New creates a new Retry around base.
+func New(base stor.Storage, policy Policy) (*Retry, error) {
+	if policy.Attempts < 0 || policy.Backoff < 0 || policy.MaxBackoff < 0 {
+		return nil, fmt.Errorf("Invalid retry policy %+v: must not be negative", policy)
+	}
+	if policy.Attempts == 0 {
+		policy.Attempts = DefaultAttempts
+	}
+	if policy.Backoff == 0 {
+		policy.Backoff = DefaultBackoff
+	}
+	if policy.MaxBackoff == 0 {
+		policy.MaxBackoff = DefaultMaxBackoff
+	}
+
+	r := &Retry{
+		base:   base,
+		policy: policy,
+		sleep:  time.Sleep,
+	}
+	return r, nil
+}
+
+// do calls operation until it succeeds, fails with an error that is not retryable, or the attempts
+// are exhausted. Returns the last error.
+func (r *Retry) do(operation func() error) error {
+	backoff := r.policy.Backoff
+	for attempt := 1; ; attempt++ {
+		err := operation()
+		if err == nil || !stor.IsRetryable(err) || attempt >= r.policy.Attempts {
+			return err
+		}
+
+		wait, ok := stor.RetryAfter(err)
+		if !ok {
+			wait = backoff
+			backoff *= 2
+		}
+		if wait > r.policy.MaxBackoff {
+			wait = r.policy.MaxBackoff
+		}
+		r.sleep(wait)
+	}
+}
+
+// Meta returns meta information about a file.
+func (r *Retry) Meta(filePath string) (*stor.Meta, error) {
+	var meta *stor.Meta
+	err := r.do(func() (err error) {
+		meta, err = r.base.Meta(filePath)
+		return err
+	})
+	return meta, err
+}
+
+// List returns the files and subdirectories within the specified directory.
+func (r *Retry) List(dirPath string) ([]string, []string, error) {
+	var files, dirs []string
+	err := r.do(func() (err error) {
+		files, dirs, err = r.base.List(dirPath)
+		return err
+	})
+	return files, dirs, err
+}
+
+// Load loads the content of the specified file.
+func (r *Retry) Load(filePath string, maxSize int64) ([]byte, error) {
+	var data []byte
+	err := r.do(func() (err error) {
+		data, err = r.base.Load(filePath, maxSize)
+		return err
+	})
+	return data, err
+}
+
+// Save saves the data to the specified file.
+func (r *Retry) Save(filePath string, data []byte) error {
+	return r.do(func() error {
+		return r.base.Save(filePath, data)
+	})
+}
+
+// Delete removes a file from storage.
+func (r *Retry) Delete(filePath string) error {
+	return r.do(func() error {
+		return r.base.Delete(filePath)
+	})
+}
+
+// Type returns the Type of the base Storage.
+func (r *Retry) Type() stor.Type {
+	return stor.TypeOf(r.base)
+}
+
+// Close closes the base Storage.
+func (r *Retry) Close() error {
+	return stor.Close(r.base)
+}
@@ -0,0 +1,108 @@
+package retry
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/suite"
+
+	"github.com/pw1/stor"
+	"github.com/pw1/stor/mock"
+)
+
+func TestRetrySuite(t *testing.T) {
+	suite.Run(t, new(RetrySuite))
+}
+
+// RetrySuite contains the tests for the Retry wrapper.
+type RetrySuite struct {
+	suite.Suite
+	base   *mock.Mock
+	retry  *Retry
+	sleeps []time.Duration
+}
+
+func (s *RetrySuite) SetupTest() {
+	s.base, _ = mock.New(&stor.Conf{})
+
+	var err error
+	s.retry, err = New(s.base, Policy{Attempts: 4, Backoff: time.Second, MaxBackoff: 3 * time.Second})
+	s.Require().Nil(err)
+
+	s.sleeps = nil
+	s.retry.sleep = func(d time.Duration) {
+		s.sleeps = append(s.sleeps, d)
+	}
+}
+
+func (s *RetrySuite) TestNewDefaults() {
+	r, err := New(s.base, Policy{})
+	s.Nil(err)
+	s.Equal(Policy{DefaultAttempts, DefaultBackoff, DefaultMaxBackoff}, r.policy)
+
+	_, err = New(s.base, Policy{Attempts: -1})
+	s.NotNil(err)
+}
+
+func (s *RetrySuite) TestSuccess() {
+	s.base.On("Load", "file1", int64(10)).Return([]byte("test123"), nil).Once()
+
+	data, err := s.retry.Load("file1", 10)
+	s.Nil(err)
+	s.Equal("test123", string(data))
+	s.Empty(s.sleeps)
+	s.base.AssertExpectations(s.T())
+}
+
+func (s *RetrySuite) TestRetryTemporary() {
+	tempErr := &stor.TemporaryError{Err: errors.New("throttled")}
+	s.base.On("Save", "file1", []byte("a")).Return(tempErr).Twice()
+	s.base.On("Save", "file1", []byte("a")).Return(nil).Once()
+
+	s.Nil(s.retry.Save("file1", []byte("a")))
+	s.Equal([]time.Duration{time.Second, 2 * time.Second}, s.sleeps)
+	s.base.AssertExpectations(s.T())
+}
+
+func (s *RetrySuite) TestRetryUnavailable() {
+	unavailableErr := &stor.OpError{Op: stor.OpSave, Path: "file1",
+		Err: &stor.StorageUnavailableError{Err: errors.New("bad gateway")}}
+	s.base.On("Save", "file1", []byte("a")).Return(unavailableErr).Once()
+	s.base.On("Save", "file1", []byte("a")).Return(nil).Once()
+
+	s.Nil(s.retry.Save("file1", []byte("a")))
+	s.Equal([]time.Duration{time.Second}, s.sleeps)
+	s.base.AssertExpectations(s.T())
+}
+
+func (s *RetrySuite) TestRetryAfter() {
+	s.base.On("Delete", "file1").
+		Return(&stor.TemporaryError{Err: errors.New("slow"), RetryAfter: 1500 * time.Millisecond}).
+		Once()
+	s.base.On("Delete", "file1").
+		Return(&stor.TemporaryError{Err: errors.New("slow"), RetryAfter: time.Minute}).Once()
+	s.base.On("Delete", "file1").Return(nil).Once()
+
+	s.Nil(s.retry.Delete("file1"))
+	s.Equal([]time.Duration{1500 * time.Millisecond, 3 * time.Second}, s.sleeps)
+}
+
+func (s *RetrySuite) TestExhausted() {
+	tempErr := &stor.TemporaryError{Err: errors.New("throttled")}
+	s.base.On("Meta", "file1").Return((*stor.Meta)(nil), tempErr).Times(4)
+
+	_, err := s.retry.Meta("file1")
+	s.Equal(tempErr, err)
+	s.Equal([]time.Duration{time.Second, 2 * time.Second, 3 * time.Second}, s.sleeps)
+	s.base.AssertExpectations(s.T())
+}
+
+func (s *RetrySuite) TestNotTemporary() {
+	s.base.On("List", "dir1").Return([]string{}, []string{}, errors.New("broken")).Once()
+
+	_, _, err := s.retry.List("dir1")
+	s.NotNil(err)
+	s.Empty(s.sleeps)
+	s.base.AssertExpectations(s.T())
+}
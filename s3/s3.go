@@ -9,6 +9,10 @@ import (
 const (
 	// S3StorageType is the type of the S3 storage.
 	S3StorageType stor.Type = "S3"
+
+	// URLScheme is the scheme of S3 storage URLs (see stor.NewFromURL), e.g.
+	// "s3://bucket/prefix?region=eu-west-1".
+	URLScheme = "s3"
 )
 
 func init() {
@@ -16,18 +20,47 @@ func init() {
 		return New(conf)
 	}
 	stor.RegisterType(S3StorageType, newStorageFunc)
+	stor.RegisterValidator(S3StorageType, Validate)
+	stor.RegisterInfo(stor.Info{
+		Type:         S3StorageType,
+		Description:  "Amazon S3 bucket (not yet implemented)",
+		Path:         "Bucket name, optionally followed by a key prefix (e.g. \"bucket/prefix\")",
+		Capabilities: []stor.Capability{stor.CapabilityPersistent},
+	})
+	stor.RegisterScheme(URLScheme, S3StorageType, nil)
 }
 
 // S3 is in implementation of stor.Storage. It uses Amazon's S3, or another compatible service, as
 // it storage backend.
 type S3 struct{}
 
+// Validate checks a configuration of the S3 storage without making any requests.
+func Validate(conf *stor.Conf) error {
+	bucketPath, err := stor.CleanPath(conf.Path)
+	if err != nil {
+		return err
+	}
+	if bucketPath == "" {
+		return &stor.ConfError{Type: S3StorageType, Field: "Path", Msg: "must specify a bucket"}
+	}
+	return nil
+}
+
 // New create a new S3 object with the specified configuration.
 func New(conf *stor.Conf) (*S3, error) {
+	if err := Validate(conf); err != nil {
+		return nil, err
+	}
+
 	am := &S3{}
 	return am, nil
 }
 
+// Type returns S3StorageType.
+func (s *S3) Type() stor.Type {
+	return S3StorageType
+}
+
 // Meta returns meta information about a file.
 func (s *S3) Meta(filePath string) (*stor.Meta, error) {
 	return nil, errors.New("not yet implemented")
@@ -0,0 +1,237 @@
+// Package shard implements a stor.Storage wrapper that distributes files over multiple backends,
+// e.g. to spread a dataset that is too large for a single disk over several mounts or buckets.
+//
+// Every file is assigned to a backend by consistent hashing of its path. Each backend owns a number
+// of points on a hash ring, derived from its name, and a file belongs to the backend that owns the
+// first point at or after the hash of its path. Adding or removing a backend therefore only moves
+// the files of that backend (about 1/n of all files), but those files do have to be moved by the
+// caller. Renaming a backend moves its files too, so the names must be stable.
+//
+// Directories exist implicitly on every backend that holds a file within them. List asks all
+// backends, and merges their results.
+package shard
+
+import (
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"os"
+	"sort"
+	"strconv"
+	"sync"
+
+	"github.com/pw1/stor"
+)
+
+// DefaultPoints is the number of points per backend on the hash ring that is used if none is
+// configured. More points give a more even distribution.
+const DefaultPoints = 128
+
+// Backend is a named backend of a Shard.
+type Backend struct {
+	// Name identifies the backend on the hash ring. It must be unique and stable.
+	Name string
+
+	// Storage is the storage of the backend.
+	Storage stor.Storage
+}
+
+// point is a point on the hash ring.
+type point struct {
+	hash    uint64
+	backend int
+}
+
+// Shard is a stor.Storage wrapper that distributes files over multiple backends.
+type Shard struct {
+	backends []Backend
+	ring     []point
+}
+
+// New creates a new Shard over backends, with the specified number of points per backend on the
+// hash ring. If points is 0, then DefaultPoints is used.
+func New(backends []Backend, points int) (*Shard, error) {
+	if len(backends) == 0 {
+		return nil, fmt.Errorf("Invalid backends: at least one backend is required")
+	}
+	if points < 0 {
+		return nil, fmt.Errorf("Invalid number of points %d: must not be negative", points)
+	}
+	if points == 0 {
+		points = DefaultPoints
+	}
+
+	names := make(map[string]bool)
+	ring := make([]point, 0, len(backends)*points)
+	for i, backend := range backends {
+		if backend.Name == "" || names[backend.Name] {
+			return nil, fmt.Errorf("Invalid backend name %q: must be unique and not empty",
+				backend.Name)
+		}
+		names[backend.Name] = true
+
+		for j := 0; j < points; j++ {
+			ring = append(ring, point{hash: hash(backend.Name + "#" + strconv.Itoa(j)), backend: i})
+		}
+	}
+	sort.Slice(ring, func(i, j int) bool {
+		return ring[i].hash < ring[j].hash
+	})
+
+	s := &Shard{
+		backends: backends,
+		ring:     ring,
+	}
+	return s, nil
+}
+
+// hash returns the position of a string on the hash ring.
+func hash(s string) uint64 {
+	sum := sha256.Sum256([]byte(s))
+	return binary.BigEndian.Uint64(sum[:8])
+}
+
+// Locate returns the name of the backend that holds a file.
+func (s *Shard) Locate(filePath string) (string, error) {
+	cleanPath, err := stor.CleanPath(filePath)
+	if err != nil {
+		return "", err
+	}
+	return s.backends[s.locate(cleanPath)].Name, nil
+}
+
+// locate returns the index of the backend of a cleaned path.
+func (s *Shard) locate(cleanPath string) int {
+	h := hash(cleanPath)
+	i := sort.Search(len(s.ring), func(i int) bool {
+		return s.ring[i].hash >= h
+	})
+	if i == len(s.ring) {
+		i = 0
+	}
+	return s.ring[i].backend
+}
+
+// storage returns the storage of the backend of a file, and the cleaned path of the file.
+func (s *Shard) storage(filePath string) (stor.Storage, string, error) {
+	cleanPath, err := stor.CleanPath(filePath)
+	if err != nil {
+		return nil, "", err
+	}
+	return s.backends[s.locate(cleanPath)].Storage, cleanPath, nil
+}
+
+// Meta returns meta information about a file.
+func (s *Shard) Meta(filePath string) (*stor.Meta, error) {
+	st, cleanPath, err := s.storage(filePath)
+	if err != nil {
+		return nil, err
+	}
+	return st.Meta(cleanPath)
+}
+
+// List returns the files and subdirectories within the specified directory. All backends are
+// listed concurrently. A directory that doesn't exist on a backend is empty.
+func (s *Shard) List(dirPath string) ([]string, []string, error) {
+	cleanPath, err := stor.CleanPath(dirPath)
+	if err != nil {
+		return []string{}, []string{}, err
+	}
+
+	type result struct {
+		files []string
+		dirs  []string
+		err   error
+	}
+	results := make([]result, len(s.backends))
+
+	var wg sync.WaitGroup
+	for i, backend := range s.backends {
+		wg.Add(1)
+		go func(i int, st stor.Storage) {
+			defer wg.Done()
+			files, dirs, err := st.List(cleanPath)
+			if stor.IsPathDoesntExistError(err) || errors.Is(err, os.ErrNotExist) {
+				files, dirs, err = []string{}, []string{}, nil
+			}
+			results[i] = result{files, dirs, err}
+		}(i, backend.Storage)
+	}
+	wg.Wait()
+
+	files := []string{}
+	dirs := []string{}
+	seenFiles := make(map[string]bool)
+	seenDirs := make(map[string]bool)
+	for i, r := range results {
+		if r.err != nil {
+			return []string{}, []string{}, fmt.Errorf("failed to list backend %s: %v",
+				s.backends[i].Name, r.err)
+		}
+		for _, file := range r.files {
+			if !seenFiles[file] {
+				seenFiles[file] = true
+				files = append(files, file)
+			}
+		}
+		for _, dir := range r.dirs {
+			if !seenDirs[dir] {
+				seenDirs[dir] = true
+				dirs = append(dirs, dir)
+			}
+		}
+	}
+
+	return files, dirs, nil
+}
+
+// Load loads the content of the specified file.
+func (s *Shard) Load(filePath string, maxSize int64) ([]byte, error) {
+	st, cleanPath, err := s.storage(filePath)
+	if err != nil {
+		return []byte{}, err
+	}
+	return st.Load(cleanPath, maxSize)
+}
+
+// Save saves the data to the specified file.
+func (s *Shard) Save(filePath string, data []byte) error {
+	st, cleanPath, err := s.storage(filePath)
+	if err != nil {
+		return err
+	}
+	return st.Save(cleanPath, data)
+}
+
+// Delete removes a file from storage.
+func (s *Shard) Delete(filePath string) error {
+	st, cleanPath, err := s.storage(filePath)
+	if err != nil {
+		return err
+	}
+	return st.Delete(cleanPath)
+}
+
+// Type returns the Type of the backends if they all have the same Type, or stor.TypeUnspecified
+// otherwise.
+func (s *Shard) Type() stor.Type {
+	storageType := stor.TypeOf(s.backends[0].Storage)
+	for _, backend := range s.backends[1:] {
+		if stor.TypeOf(backend.Storage) != storageType {
+			return stor.TypeUnspecified
+		}
+	}
+	return storageType
+}
+
+// Close closes all backends. It returns the first error, after it has tried to close all of them.
+func (s *Shard) Close() error {
+	var err error
+	for _, backend := range s.backends {
+		if closeErr := stor.Close(backend.Storage); err == nil {
+			err = closeErr
+		}
+	}
+	return err
+}
@@ -0,0 +1,153 @@
+package shard
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/suite"
+
+	"github.com/pw1/stor"
+	"github.com/pw1/stor/localdir"
+	"github.com/pw1/stor/memory"
+	"github.com/pw1/stor/tester"
+)
+
+// TestShardStorageTester calls the generic storage tests. LocalDir backends are used, because they
+// can't list directories that don't exist.
+func TestShardStorageTester(t *testing.T) {
+	testSuite := &tester.StorageTester{
+		SetupTestFunc: func(s *tester.StorageTester) {
+			backends := make([]Backend, 3)
+			for i := range backends {
+				base, err := localdir.New(&stor.Conf{Path: s.T().TempDir()})
+				s.Require().Nil(err)
+				backends[i] = Backend{Name: fmt.Sprintf("disk%d", i), Storage: base}
+			}
+			st, err := New(backends, 0)
+			s.Require().Nil(err)
+			s.Storage = st
+		},
+	}
+
+	suite.Run(t, testSuite)
+}
+
+func TestShardSuite(t *testing.T) {
+	suite.Run(t, new(ShardSuite))
+}
+
+// ShardSuite contains tests that are specific for the Shard wrapper.
+type ShardSuite struct {
+	suite.Suite
+	bases    []*memory.Memory
+	backends []Backend
+}
+
+func (s *ShardSuite) SetupTest() {
+	s.bases = make([]*memory.Memory, 4)
+	s.backends = make([]Backend, 4)
+	for i := range s.bases {
+		s.bases[i], _ = memory.New(&stor.Conf{})
+		s.backends[i] = Backend{Name: fmt.Sprintf("bucket%d", i), Storage: s.bases[i]}
+	}
+}
+
+// countFiles returns the number of files in the root directory of each base.
+func (s *ShardSuite) countFiles() []int {
+	counts := make([]int, len(s.bases))
+	for i, base := range s.bases {
+		files, _, err := base.List("")
+		s.Require().Nil(err)
+		counts[i] = len(files)
+	}
+	return counts
+}
+
+func (s *ShardSuite) TestNewInvalid() {
+	_, err := New(nil, 0)
+	s.NotNil(err)
+
+	_, err = New(s.backends, -1)
+	s.NotNil(err)
+
+	_, err = New([]Backend{s.backends[0], s.backends[0]}, 0)
+	s.NotNil(err)
+
+	_, err = New([]Backend{{Storage: s.bases[0]}}, 0)
+	s.NotNil(err)
+}
+
+// TestDistribution verifies that files are spread evenly over the backends, and stored where
+// Locate says they are.
+func (s *ShardSuite) TestDistribution() {
+	sh, err := New(s.backends, 0)
+	s.Require().Nil(err)
+
+	for i := 0; i < 4000; i++ {
+		filePath := fmt.Sprintf("file%d", i)
+		s.Require().Nil(sh.Save(filePath, []byte("test")))
+
+		name, err := sh.Locate(filePath)
+		s.Nil(err)
+		for j, backend := range s.backends {
+			_, err := s.bases[j].Meta(filePath)
+			s.Equal(backend.Name == name, err == nil)
+		}
+	}
+
+	for _, count := range s.countFiles() {
+		s.InDelta(1000, count, 250)
+	}
+}
+
+// TestAddBackend verifies that adding a backend only moves files to the new backend.
+func (s *ShardSuite) TestAddBackend() {
+	before, err := New(s.backends[:3], 0)
+	s.Require().Nil(err)
+	after, err := New(s.backends, 0)
+	s.Require().Nil(err)
+
+	moved := 0
+	for i := 0; i < 4000; i++ {
+		filePath := fmt.Sprintf("file%d", i)
+		oldName, _ := before.Locate(filePath)
+		newName, _ := after.Locate(filePath)
+		if oldName != newName {
+			s.Equal(s.backends[3].Name, newName)
+			moved++
+		}
+	}
+	s.InDelta(1000, moved, 250)
+}
+
+// TestListMerge verifies that List merges the files and directories of all backends.
+func (s *ShardSuite) TestListMerge() {
+	sh, err := New(s.backends, 0)
+	s.Require().Nil(err)
+
+	expected := []string{}
+	for i := 0; i < 20; i++ {
+		filePath := fmt.Sprintf("dir/file%d", i)
+		expected = append(expected, filePath)
+		s.Require().Nil(sh.Save(filePath, []byte("test")))
+	}
+	s.Require().Nil(sh.Save("dir/sub/file", []byte("test")))
+
+	files, dirs, err := sh.List("dir")
+	s.Nil(err)
+	s.ElementsMatch(expected, files)
+	s.Equal([]string{"dir/sub"}, dirs)
+
+	files, dirs, err = sh.List("")
+	s.Nil(err)
+	s.Empty(files)
+	s.Equal([]string{"dir"}, dirs)
+}
+
+func (s *ShardSuite) TestLocateInvalidPath() {
+	sh, err := New(s.backends, 0)
+	s.Require().Nil(err)
+
+	_, err = sh.Locate("../file")
+	s.True(stor.IsInvalidPathError(err))
+}
@@ -0,0 +1,409 @@
+// Package snapshot implements a stor.Storage wrapper that provides copy-on-write snapshots, e.g.
+// to take a consistent backup of a storage that is in use, without stopping the writers.
+//
+// A Snapshot is a read-only view of the state of the storage at the time it was taken. Taking a
+// snapshot copies nothing. Instead, while a Snapshot is open, the first Save or Delete of every
+// file copies the previous content of that file to a delta storage, where the Snapshot reads it
+// from. Files that didn't exist when the snapshot was taken are remembered, so the Snapshot doesn't
+// see them. The delta is removed when the Snapshot is released.
+//
+// Snapshots only live as long as the Snapshotter that created them; they don't survive a restart.
+package snapshot
+
+import (
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"math"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/pw1/stor"
+)
+
+// pathLocks is the number of locks that serialize the writes of the same path.
+const pathLocks = 64
+
+// Snapshotter is a stor.Storage wrapper that can take Snapshots of its state.
+type Snapshotter struct {
+	base  stor.Storage
+	delta stor.Storage
+
+	// writes is held for reading by Save and Delete, and for writing by Snapshot, so a Snapshot
+	// isn't taken while a file is being modified.
+	writes sync.RWMutex
+
+	// pathLocks serialize the Saves and Deletes of the same path (by hash), so the previous
+	// content of a file is preserved before it is modified by another write.
+	pathLocks [pathLocks]sync.Mutex
+
+	// mutex protects the Snapshots. It is held for reading by Snapshot reads, across the I/O, and
+	// for writing by Save and Delete when they mark a file as preserved, before they modify it.
+	// A Snapshot therefore never observes a file between its copy and its modification.
+	mutex     sync.RWMutex
+	snapshots map[uint64]*Snapshot
+	nextID    uint64
+}
+
+// New creates a new Snapshotter around base. The previous content of modified files is copied to
+// delta while Snapshots are open.
+func New(base, delta stor.Storage) *Snapshotter {
+	return &Snapshotter{
+		base:      base,
+		delta:     delta,
+		snapshots: make(map[uint64]*Snapshot),
+		nextID:    1,
+	}
+}
+
+// Snapshot takes a snapshot of the current state. The Snapshot must be released when it is no
+// longer needed, because every open Snapshot makes writes more expensive.
+func (s *Snapshotter) Snapshot() *Snapshot {
+	s.writes.Lock()
+	defer s.writes.Unlock()
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	snap := &Snapshot{
+		s:         s,
+		id:        s.nextID,
+		preserved: make(map[string]bool),
+	}
+	s.nextID++
+	s.snapshots[snap.id] = snap
+	return snap
+}
+
+// write modifies a file of the base storage with apply, after it has preserved the previous
+// content of the file for the open Snapshots. The mutex isn't held while apply runs.
+func (s *Snapshotter) write(cleanPath string, apply func() error) error {
+	s.writes.RLock()
+	defer s.writes.RUnlock()
+
+	s.mutex.RLock()
+	open := len(s.snapshots)
+	s.mutex.RUnlock()
+	if open == 0 {
+		return apply()
+	}
+
+	hash := fnv.New32a()
+	hash.Write([]byte(cleanPath))
+	pathLock := &s.pathLocks[hash.Sum32()%pathLocks]
+	pathLock.Lock()
+	defer pathLock.Unlock()
+
+	if err := s.preserve(cleanPath); err != nil {
+		return err
+	}
+	return apply()
+}
+
+// preserve copies the current content of a file to the delta of every open Snapshot that doesn't
+// have it yet, and then marks it as preserved in those Snapshots. The caller must hold the writes
+// mutex for reading, and the lock of the path.
+func (s *Snapshotter) preserve(cleanPath string) error {
+	s.mutex.RLock()
+	snaps := []*Snapshot{}
+	for _, snap := range s.snapshots {
+		if _, ok := snap.preserved[cleanPath]; !ok {
+			snaps = append(snaps, snap)
+		}
+	}
+	s.mutex.RUnlock()
+	if len(snaps) == 0 {
+		return nil
+	}
+
+	data, err := s.base.Load(cleanPath, math.MaxInt64)
+	if err != nil && !stor.IsPathDoesntExistError(err) {
+		return fmt.Errorf("failed to preserve %s for snapshot: %v", cleanPath, err)
+	}
+	exists := err == nil
+
+	if exists {
+		for _, snap := range snaps {
+			if err := s.delta.Save(snap.deltaPath(cleanPath), data); err != nil {
+				return fmt.Errorf("failed to preserve %s for snapshot: %v", cleanPath, err)
+			}
+		}
+	}
+
+	released := []*Snapshot{}
+	s.mutex.Lock()
+	for _, snap := range snaps {
+		if snap.released {
+			released = append(released, snap)
+			continue
+		}
+		snap.preserved[cleanPath] = exists
+	}
+	s.mutex.Unlock()
+
+	// The delta of a Snapshot that was released in the meantime isn't removed by Release
+	for _, snap := range released {
+		if exists {
+			s.delta.Delete(snap.deltaPath(cleanPath))
+		}
+	}
+	return nil
+}
+
+// Meta returns meta information about a file.
+func (s *Snapshotter) Meta(filePath string) (*stor.Meta, error) {
+	return s.base.Meta(filePath)
+}
+
+// List returns the files and subdirectories within the specified directory.
+func (s *Snapshotter) List(dirPath string) ([]string, []string, error) {
+	return s.base.List(dirPath)
+}
+
+// Load loads the content of the specified file.
+func (s *Snapshotter) Load(filePath string, maxSize int64) ([]byte, error) {
+	return s.base.Load(filePath, maxSize)
+}
+
+// Save saves the data to the specified file. The previous content of the file is preserved for the
+// open Snapshots first.
+func (s *Snapshotter) Save(filePath string, data []byte) error {
+	cleanPath, err := stor.CleanPath(filePath)
+	if err != nil {
+		return err
+	}
+
+	return s.write(cleanPath, func() error {
+		return s.base.Save(cleanPath, data)
+	})
+}
+
+// Delete removes a file from storage. The content of the file is preserved for the open Snapshots
+// first.
+func (s *Snapshotter) Delete(filePath string) error {
+	cleanPath, err := stor.CleanPath(filePath)
+	if err != nil {
+		return err
+	}
+
+	return s.write(cleanPath, func() error {
+		return s.base.Delete(cleanPath)
+	})
+}
+
+// Snapshot is a read-only view of the state of a Snapshotter at the time it was taken. It
+// implements stor.Reader; use stor.ReadOnly to get a stor.Storage.
+type Snapshot struct {
+	s  *Snapshotter
+	id uint64
+
+	// preserved contains the files that have been modified since the Snapshot was taken. The
+	// value indicates whether the file existed; if so, its content is in the delta.
+	preserved map[string]bool
+	released  bool
+}
+
+// deltaPath returns the path of the preserved content of a file in the delta.
+func (snap *Snapshot) deltaPath(cleanPath string) string {
+	return strconv.FormatUint(snap.id, 10) + "/" + cleanPath
+}
+
+// check returns an error if the Snapshot has been released. The caller must hold the mutex.
+func (snap *Snapshot) check() error {
+	if snap.released {
+		return errors.New("snapshot has been released")
+	}
+	return nil
+}
+
+// Meta returns meta information about a file, as it was when the Snapshot was taken.
+func (snap *Snapshot) Meta(filePath string) (*stor.Meta, error) {
+	cleanPath, err := stor.CleanPath(filePath)
+	if err != nil {
+		return nil, err
+	}
+
+	snap.s.mutex.RLock()
+	defer snap.s.mutex.RUnlock()
+
+	if err := snap.check(); err != nil {
+		return nil, err
+	}
+
+	exists, ok := snap.preserved[cleanPath]
+	switch {
+	case !ok:
+		return snap.s.base.Meta(cleanPath)
+	case exists:
+		return snap.s.delta.Meta(snap.deltaPath(cleanPath))
+	default:
+		return nil, &stor.PathDoesntExistError{Path: cleanPath}
+	}
+}
+
+// Load loads the content of the specified file, as it was when the Snapshot was taken.
+func (snap *Snapshot) Load(filePath string, maxSize int64) ([]byte, error) {
+	cleanPath, err := stor.CleanPath(filePath)
+	if err != nil {
+		return []byte{}, err
+	}
+
+	snap.s.mutex.RLock()
+	defer snap.s.mutex.RUnlock()
+
+	if err := snap.check(); err != nil {
+		return []byte{}, err
+	}
+
+	exists, ok := snap.preserved[cleanPath]
+	switch {
+	case !ok:
+		return snap.s.base.Load(cleanPath, maxSize)
+	case exists:
+		data, err := snap.s.delta.Load(snap.deltaPath(cleanPath), maxSize)
+		if stor.IsTooLargeError(err) {
+			return []byte{}, &stor.TooLargeError{What: cleanPath}
+		}
+		return data, err
+	default:
+		return []byte{}, &stor.PathDoesntExistError{Path: cleanPath}
+	}
+}
+
+// List returns the files and subdirectories within the specified directory, as they were when the
+// Snapshot was taken.
+func (snap *Snapshot) List(dirPath string) ([]string, []string, error) {
+	cleanPath, err := stor.CleanPath(dirPath)
+	if err != nil {
+		return []string{}, []string{}, err
+	}
+
+	snap.s.mutex.RLock()
+	defer snap.s.mutex.RUnlock()
+
+	if err := snap.check(); err != nil {
+		return []string{}, []string{}, err
+	}
+
+	files, dirs, err := snap.listBase(cleanPath)
+	if err != nil {
+		return []string{}, []string{}, err
+	}
+
+	fileSet := make(map[string]bool)
+	for _, file := range files {
+		fileSet[file] = true
+	}
+	dirSet := make(map[string]bool)
+	for _, dir := range dirs {
+		// The directory may only contain files that have been created since
+		if exists, err := snap.hasFiles(dir); err != nil {
+			return []string{}, []string{}, err
+		} else if exists {
+			dirSet[dir] = true
+		}
+	}
+
+	prefix := cleanPath
+	if prefix != "" {
+		prefix += "/"
+	}
+	for file, exists := range snap.preserved {
+		if !strings.HasPrefix(file, prefix) {
+			continue
+		}
+
+		rest := file[len(prefix):]
+		if idx := strings.IndexByte(rest, '/'); idx >= 0 {
+			if exists {
+				dirSet[prefix+rest[:idx]] = true
+			}
+			continue
+		}
+		fileSet[file] = exists
+	}
+
+	files = []string{}
+	for file, exists := range fileSet {
+		if exists {
+			files = append(files, file)
+		}
+	}
+	dirs = []string{}
+	for dir := range dirSet {
+		dirs = append(dirs, dir)
+	}
+
+	return files, dirs, nil
+}
+
+// listBase lists a directory of the base storage. A directory that doesn't exist (anymore) is
+// empty.
+func (snap *Snapshot) listBase(cleanPath string) ([]string, []string, error) {
+	files, dirs, err := snap.s.base.List(cleanPath)
+	if stor.IsPathDoesntExistError(err) || errors.Is(err, os.ErrNotExist) {
+		return []string{}, []string{}, nil
+	}
+	return files, dirs, err
+}
+
+// hasFiles checks whether a directory of the base storage contains a file (directly or in a
+// subdirectory) that already existed when the Snapshot was taken.
+func (snap *Snapshot) hasFiles(cleanPath string) (bool, error) {
+	files, dirs, err := snap.listBase(cleanPath)
+	if err != nil {
+		return false, err
+	}
+
+	for _, file := range files {
+		if exists, ok := snap.preserved[file]; !ok || exists {
+			return true, nil
+		}
+	}
+	for _, dir := range dirs {
+		if exists, err := snap.hasFiles(dir); err != nil || exists {
+			return exists, err
+		}
+	}
+	return false, nil
+}
+
+// Release releases the Snapshot, and removes its delta. The Snapshot can't be used afterwards.
+// Releasing a Snapshot twice is a no-op.
+func (snap *Snapshot) Release() error {
+	snap.s.mutex.Lock()
+	defer snap.s.mutex.Unlock()
+
+	if snap.released {
+		return nil
+	}
+	snap.released = true
+	delete(snap.s.snapshots, snap.id)
+
+	for file, exists := range snap.preserved {
+		if !exists {
+			continue
+		}
+		if err := snap.s.delta.Delete(snap.deltaPath(file)); err != nil &&
+			!stor.IsPathDoesntExistError(err) {
+			return fmt.Errorf("failed to remove the delta of the snapshot: %v", err)
+		}
+	}
+	return nil
+}
+
+// Type returns the Type of the base Storage.
+func (s *Snapshotter) Type() stor.Type {
+	return stor.TypeOf(s.base)
+}
+
+// Close closes the base and the delta Storage. It returns the first error, after it has tried to
+// close both.
+func (s *Snapshotter) Close() error {
+	err := stor.Close(s.base)
+	if deltaErr := stor.Close(s.delta); err == nil {
+		err = deltaErr
+	}
+	return err
+}
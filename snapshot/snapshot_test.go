@@ -0,0 +1,209 @@
+package snapshot
+
+import (
+	"fmt"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/suite"
+
+	"github.com/pw1/stor"
+	"github.com/pw1/stor/localdir"
+	"github.com/pw1/stor/memory"
+	"github.com/pw1/stor/tester"
+)
+
+// TestSnapshotterStorageTester calls the generic storage tests for the live storage, with an open
+// Snapshot.
+func TestSnapshotterStorageTester(t *testing.T) {
+	testSuite := &tester.StorageTester{
+		SetupTestFunc: func(s *tester.StorageTester) {
+			base, _ := memory.New(&stor.Conf{})
+			delta, _ := memory.New(&stor.Conf{})
+			st := New(base, delta)
+			st.Snapshot()
+			s.Storage = st
+		},
+	}
+
+	suite.Run(t, testSuite)
+}
+
+// TestSnapshotStorageTester calls the generic storage tests for a Snapshot, of which all files
+// have been modified or deleted since it was taken. A LocalDir base is used, because it can't list
+// directories that don't exist.
+func TestSnapshotStorageTester(t *testing.T) {
+	testSuite := &tester.StorageTester{
+		SetupTestFunc: func(s *tester.StorageTester) {
+			base, err := localdir.New(&stor.Conf{Path: s.T().TempDir()})
+			s.Require().Nil(err)
+			delta, _ := memory.New(&stor.Conf{})
+			st := New(base, delta)
+			for filePath, content := range tester.StandardFiles {
+				s.Require().Nil(st.Save(filePath, []byte(content)))
+			}
+
+			snap := st.Snapshot()
+			for filePath := range tester.StandardFiles {
+				s.Require().Nil(st.Delete(filePath))
+			}
+			s.Require().Nil(st.Save("dir1/file1", []byte("new")))
+			s.Require().Nil(st.Save("dir3/file1", []byte("new")))
+			s.Require().Nil(st.Save("dir2/dir3/file4", []byte("modified")))
+
+			s.Storage = stor.ReadOnly(snap)
+		},
+		ReadOnly: true,
+	}
+
+	suite.Run(t, testSuite)
+}
+
+func TestSnapshotSuite(t *testing.T) {
+	suite.Run(t, new(SnapshotSuite))
+}
+
+// SnapshotSuite contains tests that are specific for Snapshots.
+type SnapshotSuite struct {
+	suite.Suite
+	delta *memory.Memory
+	st    *Snapshotter
+}
+
+func (s *SnapshotSuite) SetupTest() {
+	base, _ := memory.New(&stor.Conf{})
+	s.delta, _ = memory.New(&stor.Conf{})
+	s.st = New(base, s.delta)
+	s.Require().Nil(s.st.Save("file1", []byte("v1")))
+}
+
+func (s *SnapshotSuite) TestPointInTime() {
+	snap1 := s.st.Snapshot()
+	s.Nil(s.st.Save("file1", []byte("v2")))
+	snap2 := s.st.Snapshot()
+	s.Nil(s.st.Save("file1", []byte("v3")))
+	s.Nil(s.st.Save("file2", []byte("v3")))
+
+	for expected, reader := range map[string]stor.Reader{"v1": snap1, "v2": snap2, "v3": s.st} {
+		data, err := reader.Load("file1", 100)
+		s.Nil(err)
+		s.Equal(expected, string(data))
+	}
+
+	_, err := snap2.Meta("file2")
+	s.True(stor.IsPathDoesntExistError(err))
+	files, _, err := snap2.List("")
+	s.Nil(err)
+	s.Equal([]string{"file1"}, files)
+}
+
+// TestCopyOnce verifies that a file is only copied on its first modification.
+func (s *SnapshotSuite) TestCopyOnce() {
+	snap := s.st.Snapshot()
+	s.Nil(s.st.Save("file1", []byte("v2")))
+	s.Nil(s.st.Save("file1", []byte("v3")))
+	s.Nil(s.st.Delete("file1"))
+
+	data, err := snap.Load("file1", 100)
+	s.Nil(err)
+	s.Equal("v1", string(data))
+}
+
+func (s *SnapshotSuite) TestRelease() {
+	snap := s.st.Snapshot()
+	s.Nil(s.st.Save("file1", []byte("v2")))
+
+	files, _, err := s.delta.List("1")
+	s.Nil(err)
+	s.Equal([]string{"1/file1"}, files)
+
+	s.Nil(snap.Release())
+	_, dirs, err := s.delta.List("")
+	s.Nil(err)
+	s.Empty(dirs)
+
+	_, err = snap.Load("file1", 100)
+	s.NotNil(err)
+	s.Nil(snap.Release())
+
+	// Without open Snapshots, nothing is copied
+	s.Nil(s.st.Save("file1", []byte("v3")))
+	_, dirs, err = s.delta.List("")
+	s.Nil(err)
+	s.Empty(dirs)
+}
+
+func (s *SnapshotSuite) TestLoadMaxSize() {
+	snap := s.st.Snapshot()
+	s.Nil(s.st.Delete("file1"))
+
+	_, err := snap.Load("file1", 1)
+	s.True(stor.IsTooLargeError(err))
+	s.Equal("file1 is too large", err.Error())
+}
+
+// gatedStorage is a stor.Storage of which the Saves of gatedPath wait until gate is closed.
+type gatedStorage struct {
+	stor.Storage
+	gatedPath string
+	gate      chan struct{}
+}
+
+func (g *gatedStorage) Save(filePath string, data []byte) error {
+	if filePath == g.gatedPath {
+		<-g.gate
+	}
+	return g.Storage.Save(filePath, data)
+}
+
+// TestWritesDontBlock verifies that a slow write of the base storage doesn't block other writes,
+// or Snapshot reads.
+func (s *SnapshotSuite) TestWritesDontBlock() {
+	mem, _ := memory.New(&stor.Conf{})
+	base := &gatedStorage{Storage: mem, gatedPath: "slow", gate: make(chan struct{})}
+	st := New(base, s.delta)
+	s.Nil(st.Save("file1", []byte("v1")))
+
+	snap := st.Snapshot()
+	s.Nil(st.Save("file1", []byte("v2")))
+
+	done := make(chan error)
+	go func() { done <- st.Save("slow", []byte("v1")) }()
+
+	s.Nil(st.Save("file2", []byte("v1")))
+	data, err := snap.Load("file1", 100)
+	s.Nil(err)
+	s.Equal("v1", string(data))
+
+	close(base.gate)
+	s.Nil(<-done)
+	_, err = snap.Meta("slow")
+	s.True(stor.IsPathDoesntExistError(err))
+}
+
+// TestConcurrentWrites verifies that Snapshot reads never see the writes that are done
+// concurrently with them.
+func (s *SnapshotSuite) TestConcurrentWrites() {
+	snap := s.st.Snapshot()
+
+	var wg sync.WaitGroup
+	for worker := 0; worker < 4; worker++ {
+		wg.Add(1)
+		go func(worker int) {
+			defer wg.Done()
+			for i := 0; i < 50; i++ {
+				s.Nil(s.st.Save("file1", []byte(fmt.Sprintf("worker%d-%d", worker, i))))
+				s.Nil(s.st.Save(fmt.Sprintf("dir%d/file%d", worker, i), []byte("new")))
+
+				data, err := snap.Load("file1", 100)
+				s.Nil(err)
+				s.Equal("v1", string(data))
+				files, dirs, err := snap.List("")
+				s.Nil(err)
+				s.Equal([]string{"file1"}, files)
+				s.Empty(dirs)
+			}
+		}(worker)
+	}
+	wg.Wait()
+}
@@ -0,0 +1,58 @@
+package stor
+
+import "sort"
+
+// SortedLister is implemented by Storages of which List returns the files and subdirectories
+// sorted lexicographically (by bytes). Unlike CapabilitySortedList, which describes a Type, it
+// describes a Storage value: a wrapper that reports the Type of the Storage it wraps doesn't
+// implement it, unless it keeps the order of List.
+type SortedLister interface {
+	Lister
+
+	// ListIsSorted does nothing. It marks List as sorted.
+	ListIsSorted()
+}
+
+// SortedList lists a directory like l.List, and returns the files and subdirectories sorted
+// lexicographically (by bytes). The results of a SortedLister, and other results that are already
+// sorted, are returned as-is.
+func SortedList(l Lister, dirPath string) ([]string, []string, error) {
+	files, dirs, err := l.List(dirPath)
+	if _, ok := l.(SortedLister); err != nil || ok {
+		return files, dirs, err
+	}
+
+	if !sort.StringsAreSorted(files) {
+		sort.Strings(files)
+	}
+	if !sort.StringsAreSorted(dirs) {
+		sort.Strings(dirs)
+	}
+	return files, dirs, nil
+}
+
+// WithSortedList returns a Storage of which List always returns sorted results (see SortedList),
+// so its users don't need to sort them. Note that the Storage only implements the Storage, Typer
+// and io.Closer interfaces, and hides the other optional interfaces of s.
+func WithSortedList(s Storage) Storage {
+	return &withSortedList{Storage: s}
+}
+
+// withSortedList is the Storage returned by WithSortedList.
+type withSortedList struct {
+	Storage
+}
+
+func (w *withSortedList) List(dirPath string) ([]string, []string, error) {
+	return SortedList(w.Storage, dirPath)
+}
+
+func (w *withSortedList) ListIsSorted() {}
+
+func (w *withSortedList) Type() Type {
+	return TypeOf(w.Storage)
+}
+
+func (w *withSortedList) Close() error {
+	return Close(w.Storage)
+}
@@ -0,0 +1,84 @@
+package stor_test
+
+import (
+	"sort"
+	"testing"
+
+	"github.com/stretchr/testify/suite"
+
+	"github.com/pw1/stor"
+	"github.com/pw1/stor/memory"
+	"github.com/pw1/stor/tester"
+)
+
+// reversedStorage returns the results of List in reverse order.
+type reversedStorage struct {
+	stor.Storage
+}
+
+func (r reversedStorage) List(dirPath string) ([]string, []string, error) {
+	files, dirs, err := r.Storage.List(dirPath)
+	sort.Sort(sort.Reverse(sort.StringSlice(files)))
+	sort.Sort(sort.Reverse(sort.StringSlice(dirs)))
+	return files, dirs, err
+}
+
+// TestWithSortedListStorageTester calls the generic storage tests for a Storage of which List
+// returns unsorted results, wrapped by WithSortedList.
+func TestWithSortedListStorageTester(t *testing.T) {
+	testSuite := &tester.StorageTester{
+		SortedList: true,
+		SetupTestFunc: func(s *tester.StorageTester) {
+			mem, _ := memory.New(&stor.Conf{})
+			s.Storage = stor.WithSortedList(reversedStorage{mem})
+		},
+	}
+
+	suite.Run(t, testSuite)
+}
+
+func TestSortedListSuite(t *testing.T) {
+	suite.Run(t, &SortedListSuite{})
+}
+
+// SortedListSuite contains the tests for SortedList.
+type SortedListSuite struct {
+	suite.Suite
+	st stor.Storage
+}
+
+func (s *SortedListSuite) SetupTest() {
+	mem, _ := memory.New(&stor.Conf{})
+	s.st = reversedStorage{mem}
+
+	for _, filePath := range []string{"b", "a", "c", "dir2/x", "dir1/y", "dir10/z"} {
+		s.Require().Nil(s.st.Save(filePath, []byte("test123")))
+	}
+}
+
+func (s *SortedListSuite) TestSortedList() {
+	files, dirs, err := stor.SortedList(s.st, "")
+	s.Nil(err)
+	s.Equal([]string{"a", "b", "c"}, files)
+	s.Equal([]string{"dir1", "dir10", "dir2"}, dirs)
+}
+
+func (s *SortedListSuite) TestSortedListError() {
+	files, dirs, err := stor.SortedList(s.st, "../dir1")
+	s.True(stor.IsInvalidPathError(err))
+	s.Equal([]string{}, files)
+	s.Equal([]string{}, dirs)
+}
+
+// TestSortedLister verifies that wrappers only implement SortedLister if they keep List sorted.
+func (s *SortedListSuite) TestSortedLister() {
+	mem, _ := memory.New(&stor.Conf{})
+	for st, sorted := range map[stor.Storage]bool{
+		mem:                       true,
+		s.st:                      false,
+		stor.WithSortedList(s.st): true,
+	} {
+		_, ok := st.(stor.SortedLister)
+		s.Equal(sorted, ok, "%T", st)
+	}
+}
@@ -0,0 +1,100 @@
+package stor
+
+import "strings"
+
+// Statser can compute the statistics of a directory natively, without a Meta call for every file.
+type Statser interface {
+	// Stats returns the statistics of all files within a directory and its subdirectories. The
+	// statistics of a directory that doesn't contain any files are empty.
+	// The path argument is a slash-separated path.
+	Stats(path string) (*StorageStats, error)
+}
+
+// DirStats contains the number and total size of the files within a directory.
+type DirStats struct {
+	// Objects is the number of files.
+	Objects int64
+
+	// Bytes is the total size of the files.
+	Bytes int64
+}
+
+// StorageStats contains the statistics of all files within a directory and its subdirectories.
+type StorageStats struct {
+	DirStats
+
+	// Largest is the path of the largest file, or "" if there are no files. Of several files with
+	// the largest size, it is the first one in lexicographical order.
+	Largest string
+
+	// LargestSize is the size of the largest file.
+	LargestSize int64
+
+	// Dirs contains the statistics of every subdirectory directly within the directory, by name.
+	// The files directly within the directory are only included in the totals.
+	Dirs map[string]DirStats
+}
+
+// NewStorageStats returns empty statistics.
+func NewStorageStats() *StorageStats {
+	return &StorageStats{Dirs: make(map[string]DirStats)}
+}
+
+// Add adds a file to the statistics of the directory dir. Both paths must be clean, and filePath
+// must be within dir. It is meant for implementations of Statser.
+func (s *StorageStats) Add(dir, filePath string, size int64) {
+	s.Objects++
+	s.Bytes += size
+	if s.Largest == "" || size > s.LargestSize || (size == s.LargestSize && filePath < s.Largest) {
+		s.Largest = filePath
+		s.LargestSize = size
+	}
+
+	relPath := relativePath(dir, filePath)
+	if i := strings.IndexByte(relPath, '/'); i >= 0 {
+		dirStats := s.Dirs[relPath[:i]]
+		dirStats.Objects++
+		dirStats.Bytes += size
+		s.Dirs[relPath[:i]] = dirStats
+	}
+}
+
+// Stats returns the statistics of all files within a directory of s and its subdirectories. If s
+// implements Statser, then the native statistics are used. Otherwise the directories are listed
+// with ListWithMeta. Files and directories that are deleted while they are listed are skipped.
+func Stats(s Reader, dir string) (*StorageStats, error) {
+	if statser, ok := s.(Statser); ok {
+		return statser.Stats(dir)
+	}
+
+	cleanDir, err := CleanPath(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	stats := NewStorageStats()
+	if err := addStats(s, cleanDir, cleanDir, stats); err != nil && !IsPathDoesntExistError(err) {
+		return nil, err
+	}
+
+	return stats, nil
+}
+
+// addStats adds the files within dirPath and its subdirectories to the statistics of dir.
+func addStats(s Reader, dir, dirPath string, stats *StorageStats) error {
+	entries, subDirs, err := ListWithMeta(s, dirPath)
+	if err != nil {
+		return err
+	}
+
+	for _, entry := range entries {
+		stats.Add(dir, entry.Path, entry.Meta.Size)
+	}
+
+	for _, subDir := range subDirs {
+		if err := addStats(s, dir, subDir, stats); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,92 @@
+package stor_test
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/suite"
+
+	"github.com/pw1/stor"
+	"github.com/pw1/stor/memory"
+)
+
+func TestStatsSuite(t *testing.T) {
+	suite.Run(t, new(StatsSuite))
+}
+
+// StatsSuite contains the tests for Stats.
+type StatsSuite struct {
+	suite.Suite
+	mem *memory.Memory
+}
+
+func (s *StatsSuite) SetupTest() {
+	s.mem, _ = memory.New(&stor.Conf{})
+	files := map[string]string{
+		"file1":           "test123",
+		"dir1/file2":      "test4567",
+		"dir1/dir2/file3": "test89",
+		"dir1/dir2/file4": "test0123",
+		"dir3/file5":      "",
+	}
+	for filePath, content := range files {
+		s.Require().Nil(s.mem.Save(filePath, []byte(content)))
+	}
+}
+
+func (s *StatsSuite) TestNative() {
+	var _ stor.Statser = s.mem
+
+	stats, err := stor.Stats(s.mem, "")
+	s.Require().Nil(err)
+	s.Equal(&stor.StorageStats{
+		DirStats:    stor.DirStats{Objects: 5, Bytes: 29},
+		Largest:     "dir1/dir2/file4",
+		LargestSize: 8,
+		Dirs: map[string]stor.DirStats{
+			"dir1": {Objects: 3, Bytes: 22},
+			"dir3": {Objects: 1, Bytes: 0},
+		},
+	}, stats)
+
+	stats, err = stor.Stats(s.mem, "dir1")
+	s.Require().Nil(err)
+	s.Equal(&stor.StorageStats{
+		DirStats:    stor.DirStats{Objects: 3, Bytes: 22},
+		Largest:     "dir1/dir2/file4",
+		LargestSize: 8,
+		Dirs:        map[string]stor.DirStats{"dir2": {Objects: 2, Bytes: 14}},
+	}, stats)
+}
+
+func (s *StatsSuite) TestFallback() {
+	st := &plainStorage{s.mem}
+
+	for _, dir := range []string{"", "dir1", "dir1/dir2", "dir3", "dir4"} {
+		expected, err := s.mem.Stats(dir)
+		s.Require().Nil(err)
+		stats, err := stor.Stats(st, dir)
+		s.Nil(err, dir)
+		s.Equal(expected, stats, dir)
+	}
+
+	_, err := stor.Stats(st, "../dir1")
+	s.True(stor.IsInvalidPathError(err))
+}
+
+func (s *StatsSuite) TestFallbackMetaFails() {
+	_, err := stor.Stats(&failingMeta{&plainStorage{s.mem}}, "dir1")
+	s.EqualError(err, "broken")
+}
+
+func (s *StatsSuite) TestLargestTie() {
+	stats := stor.NewStorageStats()
+	stats.Add("", "file2", 5)
+	stats.Add("", "file1", 5)
+	stats.Add("", "file3", 5)
+	s.Equal("file1", stats.Largest)
+
+	stats = stor.NewStorageStats()
+	stats.Add("", "file1", 0)
+	s.Equal("file1", stats.Largest)
+	s.Equal(int64(0), stats.LargestSize)
+}
@@ -1,7 +1,12 @@
 package stor
 
 import (
+	"errors"
 	"fmt"
+	"net"
+	"os"
+	"syscall"
+	"time"
 )
 
 // Metaer (Meta-er) can retrieve meta information about a file.
@@ -18,7 +23,8 @@ type Lister interface {
 	// Returns three values. The first return value is a list of files within the directory. The
 	// second return value is the list of subdirectories within the directory. And the third return
 	// value is any error that occured. The returned file and subdirectory entries are not
-	// necessarily sorted. The returned file and subdirectory entries are always full paths (with
+	// necessarily sorted, unless the Storage implements SortedLister (see also SortedList and
+	// WithSortedList). The returned file and subdirectory entries are always full paths (with
 	// respect to the storage root).
 	List(path string) ([]string, []string, error)
 }
@@ -27,15 +33,20 @@ type Lister interface {
 type Loader interface {
 	// Load a file and return its content.
 	// The path argument is a slash-separated path.
-	// The maxSize gives the maximum accepted file size. If the file is larger, then an error is
-	// returned and no data.
+	// The maxSize gives the maximum accepted file size. If the file is larger, then a
+	// TooLargeError is returned and no data. A file of exactly maxSize bytes is accepted, a maxSize
+	// of 0 only accepts empty files, and a negative maxSize rejects every file, including empty
+	// files.
+	// The maxSize always applies to the data that is returned to the caller. Wrappers that decode
+	// data (e.g. decompress it) enforce maxSize on the decoded data, and must not decode more than
+	// maxSize+1 bytes, so a small stored file can't expand into an unbounded amount of memory.
 	Load(path string, maxSize int64) ([]byte, error)
 }
 
 // Saver can save files in Storage.
 type Saver interface {
 	// Save data to a file. If the file already exists, than Save() will overwrite that file without
-	// error.
+	// error. Empty (or nil) data saves a file of zero bytes.
 	// The path argument is a slash-separated path.
 	Save(path string, data []byte) error
 }
@@ -64,6 +75,15 @@ type Writer interface {
 // The storage interface is for loading and saving blobs of data. The data is accessed via a
 // hierarichal path. The directories within the path are separated by the slash '/' (even on Windows
 // platforms).
+//
+// Implementations must be safe for concurrent use by multiple goroutines, including the optional
+// interfaces that they implement (e.g. Creator and Renamer), unless their documentation states
+// otherwise. Concurrent operations on distinct paths must not affect each other, and a Load that
+// runs concurrently with a Save of the same file returns either the old or the new content.
+// Wrappers are safe for concurrent use if their base Storage is.
+//
+// A Storage can report its Type by implementing Typer, and release its resources by implementing
+// io.Closer (see TypeOf and Close).
 type Storage interface {
 	Reader
 	Writer
@@ -73,6 +93,10 @@ type Storage interface {
 type Meta struct {
 	// Size (in bytes) of the file. This value is set to SizeUnknown if the Size can't be retrieved.
 	Size int64
+
+	// ModTime is the time at which the file was last saved. It is zero if the Storage doesn't track
+	// modification times.
+	ModTime time.Time
 }
 
 const (
@@ -94,51 +118,163 @@ const (
 	TypeUnspecified Type = ""
 )
 
-var (
-	// typeFactoryMap contains the mapping between Types and their Factory functions.
-	typeFactoryMap = make(map[Type]Factory)
-)
-
 // RegisterType registers a new storage.Type and its associated Factory function.
 // If the Type is already registered, or if the Type is invalid, then this function will panic.
 // This function is intended to be called from the init function of packages that implement the
 // Storage interface.
 func RegisterType(storageType Type, factory Factory) {
-	if len(storageType) > MaxTypeLen {
-		panic(fmt.Sprintf("stor: name of Type %s is too long", storageType))
-	}
-
-	if storageType == TypeUnspecified {
-		panic("stor: undefined Type")
-	}
+	DefaultRegistry.RegisterType(storageType, factory)
+}
 
-	if _, ok := typeFactoryMap[storageType]; ok {
-		panic(fmt.Sprintf("stor: Type %s is already registered", storageType))
-	}
+// ReplaceType replaces the Factory of a registered Type in the DefaultRegistry, and returns a
+// function that restores the previous Factory. This function is intended for tests. Tests that run
+// in parallel should use their own Registry instead (see NewRegistry and Registry.Clone).
+func ReplaceType(storageType Type, factory Factory) (restore func()) {
+	return DefaultRegistry.ReplaceType(storageType, factory)
+}
 
-	typeFactoryMap[storageType] = factory
+// UnregisterType removes a Type from the DefaultRegistry, together with its Validator, Info and URL
+// schemes. It returns false if the Type was not registered.
+func UnregisterType(storageType Type) bool {
+	return DefaultRegistry.UnregisterType(storageType)
 }
 
 // New creates a new Storage object based on conf. It will read the Type from the conf and get the
-// Factory function registered for that type. It will then call that Factory with conf and return
-// the result.
+// Factory function registered for that type. It will then validate conf (see Validate), call that
+// Factory with conf and return the result.
 func New(conf *Conf) (Storage, error) {
-	if conf.Type == TypeUnspecified {
-		return nil, &UnspecifiedTypeError{}
-	}
+	return DefaultRegistry.New(conf)
+}
 
-	factory, ok := typeFactoryMap[conf.Type]
-	if !ok {
-		return nil, &UnregisteredTypeError{conf.Type}
-	}
+// Validator checks a Conf for a Type without performing any I/O, and returns a ConfError (or
+// another typed error, e.g. an InvalidPathError) if it is invalid.
+type Validator func(conf *Conf) error
 
-	return factory(conf)
+// RegisterValidator registers the Validator of a registered Type. If the Type is not registered,
+// or if it already has a Validator, then this function will panic. This function is intended to be
+// called from the init function of packages that implement the Storage interface, after
+// RegisterType.
+func RegisterValidator(storageType Type, validator Validator) {
+	DefaultRegistry.RegisterValidator(storageType, validator)
+}
+
+// Validate checks conf before a Storage is created from it, so configuration errors are reported
+// precisely and early, instead of on first use. It checks that the Type is registered, and calls
+// the Validator of the Type if it has one.
+func Validate(conf *Conf) error {
+	return DefaultRegistry.Validate(conf)
 }
 
 // Conf contains the configuration for the storege objects.
 type Conf struct {
 	Type Type
 	Path string
+
+	// Options contains backend specific options. See the documentation of each backend for the
+	// options that it supports.
+	Options map[string]string
+
+	// Credentials contains the secrets of backends that use them. See the documentation of each
+	// backend for the fields that it uses. Use String or MarshalJSON (e.g. with %v or a JSON logger)
+	// to log a Conf, so the secrets are redacted.
+	Credentials Credentials
+}
+
+// Sentinel errors that the error types of this package match with errors.Is, also when they are
+// wrapped (e.g. with fmt.Errorf and %w). The IsXxxError functions are equivalent to errors.Is with
+// the sentinel, and errors.As can be used to get the details of an error.
+var (
+	ErrInvalidConf      = errors.New("invalid configuration")
+	ErrUnregisteredType = errors.New("storage type is not registered")
+	ErrUnspecifiedType  = errors.New("storage type is not specified")
+	ErrInvalidPath      = errors.New("invalid path")
+	ErrNotExist         = errors.New("path does not exist")
+	ErrExist            = errors.New("path already exists")
+	ErrTooLarge         = errors.New("too large")
+	ErrReadOnly         = errors.New("storage is read-only")
+	ErrCorruptData      = errors.New("data is corrupt")
+	ErrTemporary        = errors.New("temporary error")
+	ErrUnavailable      = errors.New("storage is unavailable")
+	ErrNoSpace          = errors.New("insufficient space")
+	ErrDirNotEmpty      = errors.New("directory is not empty")
+)
+
+// ConfError indicates that a Conf is invalid for its Type.
+type ConfError struct {
+	// Type is the Type of the Conf.
+	Type Type
+
+	// Field is the invalid field, e.g. "Path" or "Options[key]".
+	Field string
+
+	// Msg describes the problem.
+	Msg string
+}
+
+func (e *ConfError) Error() string {
+	return fmt.Sprintf("invalid %s configuration: %s %s", e.Type, e.Field, e.Msg)
+}
+
+// Is returns true for ErrInvalidConf, so errors.Is works for wrapped errors.
+func (e *ConfError) Is(target error) bool {
+	return target == ErrInvalidConf
+}
+
+// IsConfError returns true if an error is, or wraps, a ConfError. Returns false otherwise.
+func IsConfError(err error) bool {
+	return errors.Is(err, ErrInvalidConf)
+}
+
+// Operations of a Storage, for the Op of an OpError.
+const (
+	OpMeta       = "meta"
+	OpList       = "list"
+	OpLoad       = "load"
+	OpSave       = "save"
+	OpDelete     = "delete"
+	OpRename     = "rename"
+	OpGeneration = "generation"
+	OpMkDir      = "mkdir"
+	OpRemoveDir  = "rmdir"
+	OpStats      = "stats"
+	OpGC         = "gc"
+	OpCopy       = "copy"
+)
+
+// OpError wraps an error of the system underneath a backend (e.g. an *os.PathError of LocalDir, or
+// a *url.Error of an HTTP request) with the operation, backend and path that caused it. The
+// underlying error is available through errors.Is and errors.As, e.g. errors.Is(err,
+// os.ErrPermission). Backends return the typed errors of this package (e.g. PathDoesntExistError)
+// directly, not wrapped in an OpError.
+type OpError struct {
+	// Op is the operation, e.g. OpLoad.
+	Op string
+
+	// Backend is the Type of the backend.
+	Backend Type
+
+	// Path is the path that the operation was performed on.
+	Path string
+
+	// Err is the underlying error.
+	Err error
+
+	// RequestID identifies the request at the service that failed it (e.g. from the X-Request-Id
+	// header of an HTTP response), for correlation with the logs of that service. It is empty if
+	// the service didn't report one, or the request didn't get a response.
+	RequestID string
+}
+
+func (e *OpError) Error() string {
+	if e.RequestID != "" {
+		return fmt.Sprintf("%s %s %s (request %s): %v", e.Backend, e.Op, e.Path, e.RequestID, e.Err)
+	}
+	return fmt.Sprintf("%s %s %s: %v", e.Backend, e.Op, e.Path, e.Err)
+}
+
+// Unwrap returns the underlying error.
+func (e *OpError) Unwrap() error {
+	return e.Err
 }
 
 // UnregisteredTypeError is returned when a storage Type is specified but has never been registered.
@@ -150,15 +286,15 @@ func (e *UnregisteredTypeError) Error() string {
 	return fmt.Sprintf("storage type %s is not registered", e.Type)
 }
 
-// IsUnregisteredTypeError returns true if an error is a UnspecifiedTypeError. Returns false
-// otherwise.
+// Is returns true for ErrUnregisteredType, so errors.Is works for wrapped errors.
+func (e *UnregisteredTypeError) Is(target error) bool {
+	return target == ErrUnregisteredType
+}
+
+// IsUnregisteredTypeError returns true if an error is, or wraps, a UnspecifiedTypeError. Returns
+// false otherwise.
 func IsUnregisteredTypeError(err error) bool {
-	switch err.(type) {
-	case *UnregisteredTypeError:
-		return true
-	default:
-		return false
-	}
+	return errors.Is(err, ErrUnregisteredType)
 }
 
 // UnspecifiedTypeError is returned when trying to create Storage but Type is not specified.
@@ -168,15 +304,15 @@ func (e *UnspecifiedTypeError) Error() string {
 	return "storage Type is not specified"
 }
 
-// IsUnspecifiedTypeError returns true if an error is a UnspecifiedTypeError. Returns false
-// otherwise.
+// Is returns true for ErrUnspecifiedType, so errors.Is works for wrapped errors.
+func (e *UnspecifiedTypeError) Is(target error) bool {
+	return target == ErrUnspecifiedType
+}
+
+// IsUnspecifiedTypeError returns true if an error is, or wraps, a UnspecifiedTypeError. Returns
+// false otherwise.
 func IsUnspecifiedTypeError(err error) bool {
-	switch err.(type) {
-	case *UnspecifiedTypeError:
-		return true
-	default:
-		return false
-	}
+	return errors.Is(err, ErrUnspecifiedType)
 }
 
 // InvalidPathError indicates that a path is invalid.
@@ -193,14 +329,14 @@ func (e *InvalidPathError) Error() string {
 	return msg
 }
 
-// IsInvalidPathError checks whether an error is an InvalidPathError, or not.
+// Is returns true for ErrInvalidPath, so errors.Is works for wrapped errors.
+func (e *InvalidPathError) Is(target error) bool {
+	return target == ErrInvalidPath
+}
+
+// IsInvalidPathError checks whether an error is, or wraps, an InvalidPathError, or not.
 func IsInvalidPathError(err error) bool {
-	switch err.(type) {
-	case *InvalidPathError:
-		return true
-	default:
-		return false
-	}
+	return errors.Is(err, ErrInvalidPath)
 }
 
 // PathDoesntExistError indicates that a specified path doesn't exist.
@@ -213,15 +349,59 @@ func (f *PathDoesntExistError) Error() string {
 	return fmt.Sprintf("path %s does not exist", f.Path)
 }
 
-// IsPathDoesntExistError returns true if an error is a PathDoesntExistError. Returns false
-// otherwise.
+// Is returns true for ErrNotExist and os.ErrNotExist, so errors.Is works for wrapped errors.
+func (f *PathDoesntExistError) Is(target error) bool {
+	return target == ErrNotExist || target == os.ErrNotExist
+}
+
+// IsPathDoesntExistError returns true if an error is, or wraps, a PathDoesntExistError. Returns
+// false otherwise.
 func IsPathDoesntExistError(err error) bool {
-	switch err.(type) {
-	case *PathDoesntExistError:
-		return true
-	default:
-		return false
-	}
+	return errors.Is(err, ErrNotExist)
+}
+
+// AlreadyExistsError indicates that a file can't be created, because it already exists (see
+// Creator).
+type AlreadyExistsError struct {
+	// Path is the path that already exists.
+	Path string
+}
+
+func (e *AlreadyExistsError) Error() string {
+	return fmt.Sprintf("path %s already exists", e.Path)
+}
+
+// Is returns true for ErrExist and os.ErrExist, so errors.Is works for wrapped errors.
+func (e *AlreadyExistsError) Is(target error) bool {
+	return target == ErrExist || target == os.ErrExist
+}
+
+// IsAlreadyExistsError returns true if an error is, or wraps, an AlreadyExistsError. Returns false
+// otherwise.
+func IsAlreadyExistsError(err error) bool {
+	return errors.Is(err, ErrExist)
+}
+
+// DirNotEmptyError indicates that a directory can't be removed, because it contains files or
+// subdirectories (see DirMaker).
+type DirNotEmptyError struct {
+	// Path is the path of the directory.
+	Path string
+}
+
+func (e *DirNotEmptyError) Error() string {
+	return fmt.Sprintf("directory %s is not empty", e.Path)
+}
+
+// Is returns true for ErrDirNotEmpty, so errors.Is works for wrapped errors.
+func (e *DirNotEmptyError) Is(target error) bool {
+	return target == ErrDirNotEmpty
+}
+
+// IsDirNotEmptyError returns true if an error is, or wraps, a DirNotEmptyError. Returns false
+// otherwise.
+func IsDirNotEmptyError(err error) bool {
+	return errors.Is(err, ErrDirNotEmpty)
 }
 
 // TooLargeError indicates that a file is too large, or a list is too long.
@@ -238,12 +418,192 @@ func (e *TooLargeError) Error() string {
 	return msg
 }
 
-// IsTooLargeError returns true if an error is a TooLargeError. Returns false otherwise.
+// Is returns true for ErrTooLarge, so errors.Is works for wrapped errors.
+func (e *TooLargeError) Is(target error) bool {
+	return target == ErrTooLarge
+}
+
+// IsTooLargeError returns true if an error is, or wraps, a TooLargeError. Returns false otherwise.
 func IsTooLargeError(err error) bool {
-	switch err.(type) {
-	case *TooLargeError:
-		return true
-	default:
+	return errors.Is(err, ErrTooLarge)
+}
+
+// ReadOnlyError indicates that a file can't be saved or deleted, because the Storage is read-only.
+type ReadOnlyError struct {
+	// Path is the path of the file that can't be modified.
+	Path string
+}
+
+func (e *ReadOnlyError) Error() string {
+	msg := "storage is read-only"
+	if e.Path != "" {
+		msg = "can't modify " + e.Path + ": " + msg
+	}
+	return msg
+}
+
+// Is returns true for ErrReadOnly, so errors.Is works for wrapped errors.
+func (e *ReadOnlyError) Is(target error) bool {
+	return target == ErrReadOnly
+}
+
+// IsReadOnlyError returns true if an error is, or wraps, a ReadOnlyError. Returns false otherwise.
+func IsReadOnlyError(err error) bool {
+	return errors.Is(err, ErrReadOnly)
+}
+
+// CorruptDataError indicates that the content of a file doesn't match its checksum, e.g. because of
+// bit rot or a truncated transfer.
+type CorruptDataError struct {
+	// Path is the path of the corrupt file.
+	Path string
+
+	// Msg describes the corruption.
+	Msg string
+}
+
+func (e *CorruptDataError) Error() string {
+	msg := fmt.Sprintf("data of %s is corrupt", e.Path)
+	if e.Msg != "" {
+		msg += ": " + e.Msg
+	}
+	return msg
+}
+
+// Is returns true for ErrCorruptData, so errors.Is works for wrapped errors.
+func (e *CorruptDataError) Is(target error) bool {
+	return target == ErrCorruptData
+}
+
+// IsCorruptDataError returns true if an error is, or wraps, a CorruptDataError. Returns false
+// otherwise.
+func IsCorruptDataError(err error) bool {
+	return errors.Is(err, ErrCorruptData)
+}
+
+// InsufficientSpaceError indicates that a file can't be saved, because the storage doesn't have
+// enough free space for it. Nothing is written in that case.
+type InsufficientSpaceError struct {
+	// Path is the path of the file that can't be saved.
+	Path string
+
+	// Required is the number of free bytes that the save requires, and Available the number of
+	// free bytes that the storage has.
+	Required  int64
+	Available int64
+}
+
+func (e *InsufficientSpaceError) Error() string {
+	return fmt.Sprintf("insufficient space to save %s: %d bytes are required, %d are available",
+		e.Path, e.Required, e.Available)
+}
+
+// Is returns true for ErrNoSpace, so errors.Is works for wrapped errors.
+func (e *InsufficientSpaceError) Is(target error) bool {
+	return target == ErrNoSpace
+}
+
+// IsInsufficientSpaceError returns true if an error is, or wraps, an InsufficientSpaceError.
+// Returns false otherwise.
+func IsInsufficientSpaceError(err error) bool {
+	return errors.Is(err, ErrNoSpace)
+}
+
+// TemporaryError indicates that an operation failed because of a temporary condition (e.g.
+// throttling or an overloaded server), and may succeed if it is tried again later.
+type TemporaryError struct {
+	// Err is the underlying error.
+	Err error
+
+	// RetryAfter is the time that the backend asked to wait before trying again. Zero if the
+	// backend didn't specify it.
+	RetryAfter time.Duration
+}
+
+func (e *TemporaryError) Error() string {
+	if e.RetryAfter > 0 {
+		return fmt.Sprintf("temporary error (retry after %v): %v", e.RetryAfter, e.Err)
+	}
+	return fmt.Sprintf("temporary error: %v", e.Err)
+}
+
+// Is returns true for ErrTemporary, so errors.Is works for wrapped errors.
+func (e *TemporaryError) Is(target error) bool {
+	return target == ErrTemporary
+}
+
+// Unwrap returns the underlying error.
+func (e *TemporaryError) Unwrap() error {
+	return e.Err
+}
+
+// IsTemporaryError returns true if an error is, or wraps, a TemporaryError. Returns false
+// otherwise.
+func IsTemporaryError(err error) bool {
+	return errors.Is(err, ErrTemporary)
+}
+
+// StorageUnavailableError indicates that the system behind a backend could not be reached, or
+// failed to handle an operation (e.g. with an HTTP 500, 502 or 504 status). Unlike a
+// TemporaryError, the backend didn't ask to slow down, but the operation may still succeed if it is
+// tried again, or on another replica.
+type StorageUnavailableError struct {
+	// Err is the underlying error.
+	Err error
+}
+
+func (e *StorageUnavailableError) Error() string {
+	return fmt.Sprintf("storage is unavailable: %v", e.Err)
+}
+
+// Is returns true for ErrUnavailable, so errors.Is works for wrapped errors.
+func (e *StorageUnavailableError) Is(target error) bool {
+	return target == ErrUnavailable
+}
+
+// Unwrap returns the underlying error.
+func (e *StorageUnavailableError) Unwrap() error {
+	return e.Err
+}
+
+// IsStorageUnavailableError returns true if an error is, or wraps, a StorageUnavailableError.
+// Returns false otherwise.
+func IsStorageUnavailableError(err error) bool {
+	return errors.Is(err, ErrUnavailable)
+}
+
+// IsRetryable returns true if an operation that failed with err may succeed if it is tried again:
+// if err is, or wraps, a TemporaryError, a StorageUnavailableError, or a connection error (see
+// IsConnectionError). Errors that will recur on every attempt (e.g. a PathDoesntExistError, an
+// InvalidPathError, or an invalid certificate of a server) are not retryable.
+func IsRetryable(err error) bool {
+	if err == nil {
 		return false
 	}
+	return IsTemporaryError(err) || IsStorageUnavailableError(err) || IsConnectionError(err)
+}
+
+// IsConnectionError returns true if err is, or wraps, a timeout or an error of a network
+// connection: a *net.OpError (e.g. a refused dial), ECONNREFUSED or ECONNRESET. Other net.Error
+// errors are not connection errors, because they wrap client failures that are permanent (e.g. the
+// *url.Error of an unsupported scheme or an invalid certificate).
+func IsConnectionError(err error) bool {
+	var netErr net.Error
+	if errors.As(err, &netErr) && netErr.Timeout() {
+		return true
+	}
+
+	var opErr *net.OpError
+	return errors.As(err, &opErr) || errors.Is(err, syscall.ECONNREFUSED) ||
+		errors.Is(err, syscall.ECONNRESET)
+}
+
+// RetryAfter returns the time to wait before retrying an operation that failed with err. The second
+// return value is false if err is not a TemporaryError, or if it doesn't specify a time.
+func RetryAfter(err error) (time.Duration, bool) {
+	var tempErr *TemporaryError
+	if !errors.As(err, &tempErr) || tempErr.RetryAfter <= 0 {
+		return 0, false
+	}
+	return tempErr.RetryAfter, true
 }
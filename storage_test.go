@@ -1,10 +1,16 @@
 package stor
 
 import (
+	"crypto/x509"
 	"errors"
 	"fmt"
+	"net"
+	"net/url"
+	"os"
 	"strings"
+	"syscall"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/suite"
 )
@@ -107,6 +113,158 @@ func (s *StorageErrorsSuite) TestIsUnspecifiedTypeError() {
 	s.False(IsUnspecifiedTypeError(errors.New("test")))
 }
 
+func (s *StorageErrorsSuite) TestIsCorruptDataError() {
+	s.False(IsCorruptDataError(&PathDoesntExistError{}))
+	s.False(IsCorruptDataError(&TooLargeError{}))
+	s.True(IsCorruptDataError(&CorruptDataError{}))
+	s.False(IsCorruptDataError(errors.New("test")))
+
+	s.Equal("data of file1 is corrupt: checksum mismatch",
+		(&CorruptDataError{Path: "file1", Msg: "checksum mismatch"}).Error())
+}
+
+func (s *StorageErrorsSuite) TestIsInsufficientSpaceError() {
+	s.False(IsInsufficientSpaceError(&TooLargeError{}))
+	s.True(IsInsufficientSpaceError(&InsufficientSpaceError{}))
+	s.False(IsInsufficientSpaceError(errors.New("test")))
+
+	s.Equal("insufficient space to save file1: 1024 bytes are required, 10 are available",
+		(&InsufficientSpaceError{Path: "file1", Required: 1024, Available: 10}).Error())
+}
+
+func (s *StorageErrorsSuite) TestIsDirNotEmptyError() {
+	s.False(IsDirNotEmptyError(&PathDoesntExistError{}))
+	s.True(IsDirNotEmptyError(&DirNotEmptyError{}))
+	s.False(IsDirNotEmptyError(errors.New("test")))
+
+	s.Equal("directory dir1 is not empty", (&DirNotEmptyError{Path: "dir1"}).Error())
+}
+
+func (s *StorageErrorsSuite) TestIsAlreadyExistsError() {
+	s.False(IsAlreadyExistsError(&PathDoesntExistError{}))
+	s.True(IsAlreadyExistsError(&AlreadyExistsError{}))
+	s.True(IsAlreadyExistsError(fmt.Errorf("context: %w", &AlreadyExistsError{})))
+	s.False(IsAlreadyExistsError(errors.New("test")))
+
+	s.Equal("path file1 already exists", (&AlreadyExistsError{Path: "file1"}).Error())
+}
+
+func (s *StorageErrorsSuite) TestIsTemporaryError() {
+	s.False(IsTemporaryError(&PathDoesntExistError{}))
+	s.False(IsTemporaryError(&TooLargeError{}))
+	s.True(IsTemporaryError(&TemporaryError{}))
+	s.False(IsTemporaryError(errors.New("test")))
+}
+
+func (s *StorageErrorsSuite) TestTemporaryError() {
+	inner := errors.New("slow down")
+	err := &TemporaryError{Err: inner, RetryAfter: 5 * time.Second}
+	s.Equal("temporary error (retry after 5s): slow down", err.Error())
+	s.True(errors.Is(err, inner))
+
+	retryAfter, ok := RetryAfter(err)
+	s.True(ok)
+	s.Equal(5*time.Second, retryAfter)
+
+	_, ok = RetryAfter(&TemporaryError{Err: inner})
+	s.False(ok)
+	_, ok = RetryAfter(inner)
+	s.False(ok)
+
+	retryAfter, ok = RetryAfter(fmt.Errorf("load: %w", err))
+	s.True(ok)
+	s.Equal(5*time.Second, retryAfter)
+}
+
+func (s *StorageErrorsSuite) TestOpError() {
+	inner := &os.PathError{Op: "open", Path: "/data/file1", Err: os.ErrPermission}
+	err := fmt.Errorf("context: %w", &OpError{Op: OpLoad, Backend: "LocalDir", Path: "file1",
+		Err: inner})
+	s.Equal("context: LocalDir load file1: open /data/file1: permission denied", err.Error())
+	s.True(errors.Is(err, os.ErrPermission))
+
+	var pathErr *os.PathError
+	s.True(errors.As(err, &pathErr))
+	s.Equal("/data/file1", pathErr.Path)
+
+	err = &OpError{Op: OpLoad, Backend: "Consul", Path: "file1", Err: &TemporaryError{Err: inner}}
+	s.True(IsTemporaryError(err))
+}
+
+func (s *StorageErrorsSuite) TestStorageUnavailableError() {
+	inner := errors.New("bad gateway")
+	err := &StorageUnavailableError{Err: inner}
+	s.Equal("storage is unavailable: bad gateway", err.Error())
+	s.True(errors.Is(err, inner))
+	s.True(IsStorageUnavailableError(fmt.Errorf("context: %w", err)))
+	s.False(IsStorageUnavailableError(&TemporaryError{Err: inner}))
+}
+
+func (s *StorageErrorsSuite) TestIsRetryable() {
+	netErr := &net.OpError{Op: "dial", Net: "tcp", Err: errors.New("connection refused")}
+	urlErr := func(err error) error {
+		return &url.Error{Op: "Get", URL: "https://example.com", Err: err}
+	}
+	table := map[error]bool{
+		nil:                               false,
+		errors.New("test"):                false,
+		&PathDoesntExistError{}:           false,
+		&InvalidPathError{}:               false,
+		&TooLargeError{}:                  false,
+		&TemporaryError{}:                 true,
+		&StorageUnavailableError{}:        true,
+		netErr:                            true,
+		&OpError{Op: OpLoad, Err: netErr}: true,
+		fmt.Errorf("load: %w", &TemporaryError{}):  true,
+		fmt.Errorf("read: %w", syscall.ECONNRESET): true,
+		urlErr(netErr):                                    true,
+		urlErr(&net.DNSError{IsTimeout: true}):            true,
+		urlErr(x509.UnknownAuthorityError{}):              false,
+		urlErr(errors.New("unsupported protocol scheme")): false,
+	}
+	for err, retryable := range table {
+		s.Equal(retryable, IsRetryable(err), "%v", err)
+	}
+}
+
+func (s *StorageErrorsSuite) TestSentinels() {
+	table := map[error]error{
+		&ConfError{}:               ErrInvalidConf,
+		&UnregisteredTypeError{}:   ErrUnregisteredType,
+		&UnspecifiedTypeError{}:    ErrUnspecifiedType,
+		&InvalidPathError{}:        ErrInvalidPath,
+		&PathDoesntExistError{}:    ErrNotExist,
+		&AlreadyExistsError{}:      ErrExist,
+		&TooLargeError{}:           ErrTooLarge,
+		&ReadOnlyError{}:           ErrReadOnly,
+		&CorruptDataError{}:        ErrCorruptData,
+		&TemporaryError{}:          ErrTemporary,
+		&StorageUnavailableError{}: ErrUnavailable,
+		&InsufficientSpaceError{}:  ErrNoSpace,
+		&DirNotEmptyError{}:        ErrDirNotEmpty,
+	}
+	for err, sentinel := range table {
+		wrapped := fmt.Errorf("context: %w", err)
+		s.True(errors.Is(err, sentinel), "%T", err)
+		s.True(errors.Is(wrapped, sentinel), "%T", err)
+		s.False(errors.Is(wrapped, ErrNotExist) && sentinel != ErrNotExist, "%T", err)
+		s.False(errors.Is(errors.New(err.Error()), sentinel), "%T", err)
+	}
+
+	s.True(errors.Is(&PathDoesntExistError{}, os.ErrNotExist))
+	s.True(errors.Is(&AlreadyExistsError{}, os.ErrExist))
+}
+
+func (s *StorageErrorsSuite) TestIsWrapped() {
+	s.True(IsPathDoesntExistError(fmt.Errorf("context: %w", &PathDoesntExistError{Path: "file1"})))
+	s.True(IsTooLargeError(fmt.Errorf("context: %w", &TooLargeError{})))
+	s.False(IsTooLargeError(fmt.Errorf("context: %v", &TooLargeError{})))
+
+	var pathErr *PathDoesntExistError
+	s.True(errors.As(fmt.Errorf("context: %w", &PathDoesntExistError{Path: "file1"}), &pathErr))
+	s.Equal("file1", pathErr.Path)
+}
+
 //
 // Test Suite for the Type
 //
@@ -182,3 +340,64 @@ func (s *NewSuite) TestNew() {
 	s.Nil(err)
 	s.True(factCalled)
 }
+
+func (s *NewSuite) TestValidator() {
+	myTestType := Type("TypeTestValidator")
+	factCalled := false
+	fact := func(conf *Conf) (Storage, error) {
+		factCalled = true
+		return nil, nil
+	}
+	validator := func(conf *Conf) error {
+		if conf.Path == "" {
+			return &ConfError{Type: conf.Type, Field: "Path", Msg: "is required"}
+		}
+		return nil
+	}
+
+	s.Panics(func() {
+		RegisterValidator(myTestType, validator)
+	}, "the type must be registered first")
+
+	RegisterType(myTestType, fact)
+	RegisterValidator(myTestType, validator)
+	s.Panics(func() {
+		RegisterValidator(myTestType, validator)
+	})
+
+	_, err := New(&Conf{Type: myTestType})
+	s.True(IsConfError(err))
+	s.Equal("invalid TypeTestValidator configuration: Path is required", err.Error())
+	s.False(factCalled)
+
+	s.Nil(Validate(&Conf{Type: myTestType, Path: "test"}))
+	s.True(IsUnregisteredTypeError(Validate(&Conf{Type: Type("Doesn't exist")})))
+	s.True(IsUnspecifiedTypeError(Validate(&Conf{})))
+
+	_, err = New(&Conf{Type: myTestType, Path: "test"})
+	s.Nil(err)
+	s.True(factCalled)
+}
+
+func (s *NewSuite) TestReplaceAndUnregisterType() {
+	myTestType := Type("TypeTestReplace")
+	calls := []string{}
+	fact := func(name string) Factory {
+		return func(conf *Conf) (Storage, error) {
+			calls = append(calls, name)
+			return nil, nil
+		}
+	}
+	RegisterType(myTestType, fact("original"))
+
+	restore := ReplaceType(myTestType, fact("replacement"))
+	New(&Conf{Type: myTestType})
+	restore()
+	New(&Conf{Type: myTestType})
+	s.Equal([]string{"replacement", "original"}, calls)
+
+	s.True(UnregisterType(myTestType))
+	s.False(UnregisterType(myTestType))
+	_, err := New(&Conf{Type: myTestType})
+	s.True(IsUnregisteredTypeError(err))
+}
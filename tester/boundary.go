@@ -0,0 +1,110 @@
+package tester
+
+import (
+	"math"
+
+	"github.com/pw1/stor"
+)
+
+// TestSaveEmpty verifies that a file of zero bytes is saved, that Meta reports size 0, that List
+// returns it, and that Load returns it with a maximum size of 0, but not with a negative maximum.
+func (s *StorageTester) TestSaveEmpty() {
+	s.skipIfReadOnly()
+	s.insertFixture()
+
+	for _, dir := range append([]string{""}, s.fixture().dirs()...) {
+		filePath := s.fixture().missing(dir)
+		s.Nil(s.Storage.Save(filePath, []byte{}), filePath)
+
+		meta, err := s.Storage.Meta(filePath)
+		s.Nil(err, filePath)
+		s.checkMeta(meta, 0, filePath)
+
+		files, _, err := s.Storage.List(dir)
+		s.Nil(err, dir)
+		s.Contains(files, filePath, dir)
+
+		data, err := s.Storage.Load(filePath, 0)
+		s.Nil(err, filePath)
+		s.Empty(data, filePath)
+
+		data, err = s.Storage.Load(filePath, -1)
+		s.True(stor.IsTooLargeError(err), filePath)
+		s.Equal([]byte{}, data, filePath)
+	}
+}
+
+// TestSaveEmptyOverwrite verifies that overwriting a file with zero bytes leaves an empty file,
+// and not the old content.
+func (s *StorageTester) TestSaveEmptyOverwrite() {
+	s.skipIfReadOnly()
+	s.insertFixture()
+
+	for filePath := range s.fixture() {
+		s.Nil(s.Storage.Save(filePath, nil), filePath)
+
+		meta, err := s.Storage.Meta(filePath)
+		s.Nil(err, filePath)
+		s.checkMeta(meta, 0, filePath)
+
+		data, err := s.Storage.Load(filePath, 1e6)
+		s.Nil(err, filePath)
+		s.Empty(data, filePath)
+	}
+}
+
+// TestLoadMaxSizeZero verifies that Load() with a maximum size of 0 only accepts empty files.
+func (s *StorageTester) TestLoadMaxSizeZero() {
+	s.insertFixture()
+
+	for filePath, content := range s.fixture() {
+		data, err := s.Storage.Load(filePath, 0)
+		if content == "" {
+			s.Nil(err, filePath)
+			s.Empty(data, filePath)
+		} else {
+			s.True(stor.IsTooLargeError(err), filePath)
+			s.Equal([]byte{}, data, filePath)
+		}
+	}
+}
+
+// TestLoadNegativeMaxSize verifies that Load() with a negative maximum size rejects every file,
+// including empty files.
+func (s *StorageTester) TestLoadNegativeMaxSize() {
+	s.insertFixture()
+
+	for filePath := range s.fixture() {
+		for _, maxSize := range []int64{-1, math.MinInt64} {
+			data, err := s.Storage.Load(filePath, maxSize)
+			s.True(stor.IsTooLargeError(err), "%s, %d", filePath, maxSize)
+			s.Equal([]byte{}, data, "%s, %d", filePath, maxSize)
+		}
+	}
+}
+
+// TestLoadMaxSizeBoundary verifies that Load() accepts a file that is exactly as large as the
+// maximum size, or smaller, and rejects a file that is one byte larger.
+func (s *StorageTester) TestLoadMaxSizeBoundary() {
+	s.skipIfReadOnly()
+	s.insertFixture()
+
+	filePath := s.fixture().missing("")
+	for _, size := range []int{1, 2, 255, 256, 4096, 65536} {
+		content := make([]byte, size)
+		for i := range content {
+			content[i] = byte('a' + i%26)
+		}
+		s.Nil(s.Storage.Save(filePath, content), size)
+
+		for _, maxSize := range []int64{int64(size), int64(size) + 1, math.MaxInt64} {
+			data, err := s.Storage.Load(filePath, maxSize)
+			s.Nil(err, "%d, %d", size, maxSize)
+			s.Equal(content, data, "%d, %d", size, maxSize)
+		}
+
+		data, err := s.Storage.Load(filePath, int64(size)-1)
+		s.True(stor.IsTooLargeError(err), size)
+		s.Equal([]byte{}, data, size)
+	}
+}
@@ -0,0 +1,460 @@
+package tester
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pw1/stor"
+)
+
+// capabilityChecks tells for each Capability that corresponds to an optional interface whether a
+// Storage implements that interface.
+var capabilityChecks = map[stor.Capability]func(stor.Storage) bool{
+	stor.CapabilityRename: func(st stor.Storage) bool {
+		_, ok := st.(stor.Renamer)
+		return ok
+	},
+	stor.CapabilityGeneration: func(st stor.Storage) bool {
+		_, ok := st.(stor.Generationer)
+		return ok
+	},
+	stor.CapabilityCreate: func(st stor.Storage) bool {
+		_, ok := st.(stor.Creator)
+		return ok
+	},
+	stor.CapabilitySortedList: func(st stor.Storage) bool {
+		_, ok := st.(stor.SortedLister)
+		return ok
+	},
+	stor.CapabilityDirs: func(st stor.Storage) bool {
+		_, ok := st.(stor.DirMaker)
+		return ok
+	},
+	stor.CapabilityStats: func(st stor.Storage) bool {
+		_, ok := st.(stor.Statser)
+		return ok
+	},
+	stor.CapabilityGC: func(st stor.Storage) bool {
+		_, ok := st.(stor.GarbageCollector)
+		return ok
+	},
+	stor.CapabilityListMeta: func(st stor.Storage) bool {
+		_, ok := st.(stor.MetaLister)
+		return ok
+	},
+	stor.CapabilityListRecursive: func(st stor.Storage) bool {
+		_, ok := st.(stor.RecursiveLister)
+		return ok
+	},
+	stor.CapabilityCopy: func(st stor.Storage) bool {
+		_, ok := st.(stor.Copier)
+		return ok
+	},
+}
+
+// Capabilities returns the capabilities of which st implements the optional interface, e.g.
+// stor.CapabilityRename if st implements stor.Renamer.
+func Capabilities(st stor.Storage) []stor.Capability {
+	capabilities := []stor.Capability{}
+	for _, capability := range []stor.Capability{stor.CapabilityRename,
+		stor.CapabilityGeneration, stor.CapabilityCreate, stor.CapabilitySortedList,
+		stor.CapabilityDirs, stor.CapabilityStats, stor.CapabilityGC, stor.CapabilityListMeta,
+		stor.CapabilityListRecursive, stor.CapabilityCopy} {
+		if capabilityChecks[capability](st) {
+			capabilities = append(capabilities, capability)
+		}
+	}
+	return capabilities
+}
+
+// skipUnlessCapable skips tests of an optional interface that the Storage doesn't implement.
+func (s *StorageTester) skipUnlessCapable(capability stor.Capability) {
+	s.testsCapability(capability)
+	if !capabilityChecks[capability](s.Storage) {
+		s.T().Skip(fmt.Sprintf("storage doesn't have capability %s", capability))
+	}
+}
+
+// TestCapabilities verifies that the Info of the Type lists exactly the capabilities of which the
+// Storage implements the optional interface. It is skipped if the Storage isn't created from a
+// ConfFactory.
+func (s *StorageTester) TestCapabilities() {
+	if s.ConfFactory == nil {
+		s.T().Skip("the Type of the storage is unknown")
+	}
+
+	info, err := stor.TypeInfo(s.ConfFactory().Type)
+	if err != nil {
+		s.T().Skip("the Type of the storage has no Info")
+	}
+
+	implemented := Capabilities(s.Storage)
+	listed := []stor.Capability{}
+	for capability := range capabilityChecks {
+		if info.Has(capability) {
+			listed = append(listed, capability)
+		}
+	}
+	s.ElementsMatch(implemented, listed)
+}
+
+// TestType verifies that the Storage reports the Type from which it is created (see stor.TypeOf).
+// It is skipped if the Storage isn't created from a ConfFactory.
+func (s *StorageTester) TestType() {
+	if s.ConfFactory == nil {
+		s.T().Skip("the Type of the storage is unknown")
+	}
+
+	s.Equal(s.ConfFactory().Type, stor.TypeOf(s.Storage))
+}
+
+// TestRename verifies that the native Rename moves all files within a directory, and overwrites
+// existing files.
+func (s *StorageTester) TestRename() {
+	s.skipUnlessCapable(stor.CapabilityRename)
+	s.skipIfReadOnly()
+	s.insertFixture()
+
+	nested := s.fixture().nestedFile()
+	if nested == "" {
+		s.T().Skip("the fixture has no files in directories")
+	}
+	oldDir := nested[:strings.IndexByte(nested, '/')]
+	newDir := s.fixture().missing("")
+
+	// The renamed file overwrites this one
+	s.Nil(s.Storage.Save(newDir+nested[len(oldDir):], []byte("qwerty")))
+
+	err := s.Storage.(stor.Renamer).Rename(oldDir, newDir)
+	s.Nil(err)
+
+	expected := make(Fixture)
+	for filePath, content := range s.fixture() {
+		if strings.HasPrefix(filePath, oldDir+"/") {
+			filePath = newDir + filePath[len(oldDir):]
+		}
+		expected[filePath] = content
+	}
+	s.Equal(expected, s.allFiles(s.Storage))
+}
+
+// TestRenameEmpty verifies that the native Rename of a directory without files succeeds.
+func (s *StorageTester) TestRenameEmpty() {
+	s.skipUnlessCapable(stor.CapabilityRename)
+	s.skipIfReadOnly()
+	s.insertFixture()
+
+	oldDir := s.fixture().missing("")
+	reserved := s.fixture().without()
+	reserved[oldDir] = ""
+	newDir := reserved.missing("")
+	err := s.Storage.(stor.Renamer).Rename(oldDir, newDir)
+	s.Nil(err)
+
+	s.Equal(s.fixture(), s.allFiles(s.Storage))
+}
+
+// TestRenameEscapes verifies that the native Rename returns an error if a path is invalid.
+func (s *StorageTester) TestRenameEscapes() {
+	s.skipUnlessCapable(stor.CapabilityRename)
+	s.skipIfReadOnly()
+	s.insertFixture()
+
+	renamer := s.Storage.(stor.Renamer)
+	s.True(stor.IsInvalidPathError(renamer.Rename("../dir1", "dir5")))
+	s.True(stor.IsInvalidPathError(renamer.Rename("dir1", "../dir5")))
+}
+
+// TestGeneration verifies that the generation of a directory changes when a file is added to or
+// removed from it, also if the directory didn't exist before.
+func (s *StorageTester) TestGeneration() {
+	s.skipUnlessCapable(stor.CapabilityGeneration)
+	s.skipIfReadOnly()
+	s.insertFixture()
+
+	// An existing directory (or the root), and a directory that doesn't exist
+	dirs := append([]string{""}, s.fixture().dirs()...)
+	generationer := s.Storage.(stor.Generationer)
+	for _, dir := range []string{dirs[len(dirs)-1], s.fixture().missing("")} {
+		gen1, err := generationer.Generation(dir)
+		s.Nil(err, dir)
+
+		newFile := s.fixture().missing(dir)
+		s.Nil(s.Storage.Save(newFile, []byte("qwerty")))
+		gen2, err := generationer.Generation(dir)
+		s.Nil(err, dir)
+		s.NotEqual(gen1, gen2, dir)
+
+		s.Nil(s.Storage.Delete(newFile))
+		gen3, err := generationer.Generation(dir)
+		s.Nil(err, dir)
+		s.NotEqual(gen2, gen3, dir)
+	}
+
+	_, err := generationer.Generation("../dir1")
+	s.True(stor.IsInvalidPathError(err))
+}
+
+// TestCreateConcurrent verifies that exactly one of several concurrent native Creates of the same
+// file succeeds.
+func (s *StorageTester) TestCreateConcurrent() {
+	s.skipUnlessCapable(stor.CapabilityCreate)
+	s.skipIfReadOnly()
+	s.skipIfNotConcurrent()
+	s.insertFixture()
+
+	filePath := s.fixture().missing("")
+	creator := s.Storage.(stor.Creator)
+	var mutex sync.Mutex
+	created := 0
+	s.runConcurrently(func(worker int) error {
+		err := creator.Create(filePath, []byte(fmt.Sprintf("worker%d", worker)))
+		if stor.IsAlreadyExistsError(err) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+
+		mutex.Lock()
+		created++
+		mutex.Unlock()
+		return nil
+	})
+
+	s.Equal(1, created)
+}
+
+// TestMkDir verifies that MkDir creates a directory and its parents, that List returns them while
+// they are empty, and that creating an existing directory succeeds.
+func (s *StorageTester) TestMkDir() {
+	s.skipUnlessCapable(stor.CapabilityDirs)
+	s.skipIfReadOnly()
+	s.insertFixture()
+
+	dirMaker := s.Storage.(stor.DirMaker)
+	dir := s.fixture().missing("")
+	subDir := dir + "/sub"
+	s.Nil(dirMaker.MkDir(subDir))
+	s.Nil(dirMaker.MkDir(subDir))
+	for _, existing := range s.fixture().dirs() {
+		s.Nil(dirMaker.MkDir(existing), existing)
+	}
+
+	_, expectedDirs := s.fixture().list("")
+	_, dirs, err := s.Storage.List("")
+	s.Nil(err)
+	s.ElementsMatch(append(expectedDirs, dir), dirs)
+
+	files, dirs, err := s.Storage.List(dir)
+	s.Nil(err)
+	s.ElementsMatch([]string{}, files)
+	s.ElementsMatch([]string{subDir}, dirs)
+
+	files, dirs, err = s.Storage.List(subDir)
+	s.Nil(err)
+	s.ElementsMatch([]string{}, files)
+	s.ElementsMatch([]string{}, dirs)
+
+	s.Equal(s.fixture(), s.allFiles(s.Storage))
+}
+
+// TestMkDirFile verifies that MkDir returns a stor.AlreadyExistsError if a file exists at the path.
+func (s *StorageTester) TestMkDirFile() {
+	s.skipUnlessCapable(stor.CapabilityDirs)
+	s.skipIfReadOnly()
+	s.insertFixture()
+
+	for filePath := range s.fixture() {
+		err := s.Storage.(stor.DirMaker).MkDir(filePath)
+		s.True(stor.IsAlreadyExistsError(err), filePath)
+	}
+	s.Equal(s.fixture(), s.allFiles(s.Storage))
+}
+
+// TestRemoveDir verifies that RemoveDir removes an empty directory, but not its parents, and that
+// it returns an error if the directory isn't empty or doesn't exist.
+func (s *StorageTester) TestRemoveDir() {
+	s.skipUnlessCapable(stor.CapabilityDirs)
+	s.skipIfReadOnly()
+	s.insertFixture()
+
+	dirMaker := s.Storage.(stor.DirMaker)
+	dir := s.fixture().missing("")
+	subDir := dir + "/sub"
+	s.Nil(dirMaker.MkDir(subDir))
+
+	s.True(stor.IsDirNotEmptyError(dirMaker.RemoveDir(dir)))
+	for _, existing := range s.fixture().dirs() {
+		s.True(stor.IsDirNotEmptyError(dirMaker.RemoveDir(existing)), existing)
+	}
+
+	s.Nil(dirMaker.RemoveDir(subDir))
+	_, dirs, err := s.Storage.List(dir)
+	s.Nil(err)
+	s.ElementsMatch([]string{}, dirs)
+
+	s.Nil(dirMaker.RemoveDir(dir))
+	_, expectedDirs := s.fixture().list("")
+	_, dirs, err = s.Storage.List("")
+	s.Nil(err)
+	s.ElementsMatch(expectedDirs, dirs)
+
+	s.True(stor.IsPathDoesntExistError(dirMaker.RemoveDir(dir)))
+	s.Equal(s.fixture(), s.allFiles(s.Storage))
+}
+
+// TestDirsEscapes verifies that MkDir and RemoveDir return an error if a path is invalid.
+func (s *StorageTester) TestDirsEscapes() {
+	s.skipUnlessCapable(stor.CapabilityDirs)
+	s.skipIfReadOnly()
+	s.insertFixture()
+
+	dirMaker := s.Storage.(stor.DirMaker)
+	s.True(stor.IsInvalidPathError(dirMaker.MkDir("../dir5")))
+	s.True(stor.IsInvalidPathError(dirMaker.RemoveDir("../dir5")))
+}
+
+// TestStats verifies that Stats returns the statistics of the files within the root and within
+// every directory, and empty statistics for a directory that doesn't exist.
+func (s *StorageTester) TestStats() {
+	s.skipUnlessCapable(stor.CapabilityStats)
+	s.skipIfReadOnly()
+	s.insertFixture()
+
+	statser := s.Storage.(stor.Statser)
+	for _, dir := range append(s.fixture().dirs(), "", s.fixture().missing("")) {
+		expected := stor.NewStorageStats()
+		for _, filePath := range s.fixture().files() {
+			if dir == "" || strings.HasPrefix(filePath, dir+"/") {
+				expected.Add(dir, filePath, int64(len(s.fixture()[filePath])))
+			}
+		}
+
+		stats, err := statser.Stats(dir)
+		s.Nil(err, dir)
+		s.Equal(expected, stats, dir)
+	}
+
+	_, err := statser.Stats("../dir5")
+	s.True(stor.IsInvalidPathError(err))
+}
+
+// TestCollectGarbage verifies that CollectGarbage doesn't find artifacts after the files have been
+// saved, and that it never removes the files that List returns.
+func (s *StorageTester) TestCollectGarbage() {
+	s.skipUnlessCapable(stor.CapabilityGC)
+	s.skipIfReadOnly()
+	s.insertFixture()
+
+	collector := s.Storage.(stor.GarbageCollector)
+	for _, dryRun := range []bool{true, false} {
+		report, err := collector.CollectGarbage("", stor.GCOptions{MinAge: time.Nanosecond,
+			DryRun: dryRun})
+		s.Nil(err)
+		s.Equal([]stor.Artifact{}, report.Artifacts)
+	}
+	s.Equal(s.fixture(), s.allFiles(s.Storage))
+
+	_, err := collector.CollectGarbage("../dir5", stor.GCOptions{})
+	s.True(stor.IsInvalidPathError(err))
+}
+
+// TestListWithMeta verifies that ListWithMeta returns the same files and subdirectories as List,
+// with the same Meta as Meta.
+func (s *StorageTester) TestListWithMeta() {
+	s.skipUnlessCapable(stor.CapabilityListMeta)
+	s.insertFixture()
+
+	metaLister := s.Storage.(stor.MetaLister)
+	for _, dir := range append(s.fixture().dirs(), "", s.fixture().missing("")) {
+		expectedFiles, expectedDirs := s.fixture().list(dir)
+		entries, dirs, err := metaLister.ListWithMeta(dir)
+		if dir == s.fixture().missing("") && err != nil {
+			// Like List, ListWithMeta may fail for a directory that doesn't exist
+			continue
+		}
+		s.Nil(err, dir)
+		s.ElementsMatch(expectedDirs, dirs, dir)
+
+		files := []string{}
+		for _, entry := range entries {
+			files = append(files, entry.Path)
+			meta, err := s.Storage.Meta(entry.Path)
+			s.Nil(err, entry.Path)
+			s.Equal(meta, &entry.Meta, entry.Path)
+			s.checkMeta(meta, int64(len(s.fixture()[entry.Path])), entry.Path)
+		}
+		s.ElementsMatch(expectedFiles, files, dir)
+	}
+
+	_, _, err := metaLister.ListWithMeta("../dir5")
+	s.True(stor.IsInvalidPathError(err))
+}
+
+// TestListRecursive verifies that ListRecursive returns the sorted paths of all files within the
+// root and within every directory, and that it returns a stor.TooLargeError if there are more
+// files than maxEntries.
+func (s *StorageTester) TestListRecursive() {
+	s.skipUnlessCapable(stor.CapabilityListRecursive)
+	s.insertFixture()
+
+	recursiveLister := s.Storage.(stor.RecursiveLister)
+	for _, dir := range append(s.fixture().dirs(), "", s.fixture().missing("")) {
+		expected := []string{}
+		for _, filePath := range s.fixture().files() {
+			if dir == "" || strings.HasPrefix(filePath, dir+"/") {
+				expected = append(expected, filePath)
+			}
+		}
+
+		files, err := recursiveLister.ListRecursive(dir, len(expected))
+		s.Nil(err, dir)
+		s.Equal(expected, files, dir)
+
+		if len(expected) > 0 {
+			files, err = recursiveLister.ListRecursive(dir, len(expected)-1)
+			s.True(stor.IsTooLargeError(err), dir)
+			s.Equal([]string{}, files, dir)
+		}
+	}
+
+	_, err := recursiveLister.ListRecursive("../dir5", 1e6)
+	s.True(stor.IsInvalidPathError(err))
+}
+
+// TestCopyFrom verifies that CopyFrom copies files within the Storage, that it reports missing
+// files, and that it declines Storages that it can't copy from natively.
+func (s *StorageTester) TestCopyFrom() {
+	s.skipUnlessCapable(stor.CapabilityCopy)
+	s.skipIfReadOnly()
+	s.insertFixture()
+
+	copier := s.Storage.(stor.Copier)
+	for filePath, content := range s.fixture() {
+		copied, err := copier.CopyFrom(s.Storage, filePath)
+		s.True(copied, filePath)
+		s.Nil(err, filePath)
+
+		data, err := s.Storage.Load(filePath, 1e6)
+		s.Nil(err, filePath)
+		s.Equal(content, string(data), filePath)
+	}
+
+	missing := s.fixture().missing("")
+	copied, err := copier.CopyFrom(s.Storage, missing)
+	s.True(copied)
+	s.True(stor.IsPathDoesntExistError(err), missing)
+
+	// A wrapper hides the backend, so the file must be copied by the caller
+	for filePath := range s.fixture() {
+		copied, err := copier.CopyFrom(struct{ stor.Reader }{s.Storage}, filePath)
+		s.False(copied, filePath)
+		s.Nil(err, filePath)
+	}
+
+	_, err = copier.CopyFrom(s.Storage, "../file1")
+	s.True(stor.IsInvalidPathError(err))
+}
@@ -0,0 +1,297 @@
+package tester
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"os"
+	"sync"
+
+	"github.com/pw1/stor"
+)
+
+const (
+	// concurrentWorkers is the number of goroutines that the concurrency tests start.
+	concurrentWorkers = 8
+
+	// concurrentIterations is the number of operations of every goroutine.
+	concurrentIterations = 25
+)
+
+// skipIfNotConcurrent skips tests that use the Storage from several goroutines at once.
+func (s *StorageTester) skipIfNotConcurrent() {
+	if s.NotConcurrent {
+		s.T().Skip("storage is not safe for concurrent use")
+	}
+}
+
+// isNotExist checks whether err indicates that a file or directory doesn't exist. Files and
+// directories come and go in the concurrency tests, so this is not an error.
+func isNotExist(err error) bool {
+	return stor.IsPathDoesntExistError(err) || errors.Is(err, os.ErrNotExist)
+}
+
+// runConcurrently calls f from concurrentWorkers goroutines, and waits until they are done. The
+// errors returned by f are collected and reported in the test goroutine.
+func (s *StorageTester) runConcurrently(f func(worker int) error) {
+	errs := make(chan error, concurrentWorkers)
+	var wg sync.WaitGroup
+	for worker := 0; worker < concurrentWorkers; worker++ {
+		wg.Add(1)
+		go func(worker int) {
+			defer wg.Done()
+			defer func() {
+				if r := recover(); r != nil {
+					errs <- fmt.Errorf("worker %d panicked: %v", worker, r)
+				}
+			}()
+			if err := f(worker); err != nil {
+				errs <- fmt.Errorf("worker %d: %v", worker, err)
+			}
+		}(worker)
+	}
+	wg.Wait()
+	close(errs)
+
+	for err := range errs {
+		s.Fail(err.Error())
+	}
+}
+
+// TestConcurrentSave verifies that files saved from many goroutines at once to distinct paths are
+// all stored, and listed.
+func (s *StorageTester) TestConcurrentSave() {
+	s.skipIfReadOnly()
+	s.skipIfNotConcurrent()
+	s.insertFixture()
+
+	s.runConcurrently(func(worker int) error {
+		for i := 0; i < concurrentIterations; i++ {
+			filePath := fmt.Sprintf("concurrent/worker%d/file%d", worker, i)
+			if err := s.Storage.Save(filePath, []byte(filePath)); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+
+	for worker := 0; worker < concurrentWorkers; worker++ {
+		dir := fmt.Sprintf("concurrent/worker%d", worker)
+		expected := []string{}
+		for i := 0; i < concurrentIterations; i++ {
+			filePath := fmt.Sprintf("%s/file%d", dir, i)
+			expected = append(expected, filePath)
+
+			data, err := s.Storage.Load(filePath, 1e6)
+			s.Nil(err, filePath)
+			s.Equal(filePath, string(data))
+		}
+
+		files, _, err := s.Storage.List(dir)
+		s.Nil(err)
+		s.ElementsMatch(expected, files, dir)
+	}
+}
+
+// TestConcurrentSaveSameFile verifies that concurrent saves of the same file, while it is being
+// loaded, leave one of the saved contents in the file. Once a worker saved the file, loads must
+// return one of the saved contents in full.
+func (s *StorageTester) TestConcurrentSaveSameFile() {
+	s.skipIfReadOnly()
+	s.skipIfNotConcurrent()
+	s.insertFixture()
+
+	filePath := "concurrent/shared"
+	payloads := make(map[string]bool)
+	for worker := 0; worker < concurrentWorkers; worker++ {
+		payloads[string(bytes.Repeat([]byte{byte('a' + worker)}, 1000))] = true
+	}
+
+	s.runConcurrently(func(worker int) error {
+		payload := bytes.Repeat([]byte{byte('a' + worker)}, 1000)
+		for i := 0; i < concurrentIterations; i++ {
+			if err := s.Storage.Save(filePath, payload); err != nil {
+				return err
+			}
+			data, err := s.Storage.Load(filePath, 1e6)
+			if err != nil {
+				return err
+			}
+			if !payloads[string(data)] {
+				return fmt.Errorf("loaded partial content of %d bytes", len(data))
+			}
+		}
+		return nil
+	})
+
+	data, err := s.Storage.Load(filePath, 1e6)
+	s.Nil(err)
+	s.True(payloads[string(data)], "the file must contain one of the saved payloads")
+}
+
+// TestConcurrentMixed verifies that a mix of Save, Load, Meta, List and Delete calls from many
+// goroutines on shared paths doesn't return unexpected errors, and doesn't affect other files.
+func (s *StorageTester) TestConcurrentMixed() {
+	s.skipIfReadOnly()
+	s.skipIfNotConcurrent()
+	s.insertFixture()
+
+	s.runConcurrently(func(worker int) error {
+		for i := 0; i < concurrentIterations; i++ {
+			filePath := fmt.Sprintf("concurrent/mixed/file%d", (worker+i)%3)
+			var err error
+			switch i % 5 {
+			case 0:
+				err = s.Storage.Save(filePath, []byte("test123"))
+			case 1:
+				_, err = s.Storage.Load(filePath, 1e6)
+			case 2:
+				_, err = s.Storage.Meta(filePath)
+			case 3:
+				_, _, err = s.Storage.List("concurrent/mixed")
+			case 4:
+				err = s.Storage.Delete(filePath)
+			}
+			if err != nil && !isNotExist(err) {
+				return err
+			}
+		}
+		return nil
+	})
+
+	for filePath, content := range s.fixture() {
+		data, err := s.Storage.Load(filePath, 1e6)
+		s.Nil(err, filePath)
+		s.Equal(content, string(data), filePath)
+	}
+}
+
+// TestConcurrentLoadDuringSave verifies that loads of a file that is overwritten concurrently
+// never see partial content: every load returns one of the saved contents in full. The same is
+// verified for files that are created with a native Create.
+func (s *StorageTester) TestConcurrentLoadDuringSave() {
+	s.skipIfReadOnly()
+	s.skipIfNotConcurrent()
+	s.insertFixture()
+
+	// Large payloads, so a non-atomic write takes a while
+	filePath := s.fixture().missing("")
+	payloads := make(map[string]bool)
+	for worker := 0; worker < concurrentWorkers; worker++ {
+		payloads[string(bytes.Repeat([]byte{byte('a' + worker)}, 256<<10))] = true
+	}
+	s.Require().Nil(s.Storage.Save(filePath, bytes.Repeat([]byte{'a'}, 256<<10)))
+
+	// Half of the workers save, the other half loads
+	s.runConcurrently(func(worker int) error {
+		payload := bytes.Repeat([]byte{byte('a' + worker)}, 256<<10)
+		for i := 0; i < concurrentIterations; i++ {
+			if worker%2 == 0 {
+				if err := s.Storage.Save(filePath, payload); err != nil {
+					return err
+				}
+				continue
+			}
+
+			data, err := s.Storage.Load(filePath, 1e6)
+			if err != nil {
+				return err
+			}
+			if !payloads[string(data)] {
+				return fmt.Errorf("loaded partial content of %d bytes", len(data))
+			}
+		}
+		return nil
+	})
+
+	creator, ok := s.Storage.(stor.Creator)
+	if !ok {
+		return
+	}
+
+	// Every iteration creates a new file, which the loading workers either don't see yet, or see in
+	// full
+	s.runConcurrently(func(worker int) error {
+		payload := bytes.Repeat([]byte{byte('a' + worker)}, 256<<10)
+		for i := 0; i < concurrentIterations; i++ {
+			createdPath := fmt.Sprintf("%s-created/file%d", filePath, i)
+			if worker%2 == 0 {
+				err := creator.Create(createdPath, payload)
+				if err != nil && !stor.IsAlreadyExistsError(err) {
+					return err
+				}
+				continue
+			}
+
+			data, err := s.Storage.Load(createdPath, 1e6)
+			if isNotExist(err) {
+				continue
+			} else if err != nil {
+				return err
+			}
+			if !payloads[string(data)] {
+				return fmt.Errorf("loaded partial content of %d bytes", len(data))
+			}
+		}
+		return nil
+	})
+}
+
+// TestConcurrentOptional verifies that the optional interfaces that the Storage implements (e.g.
+// stor.Creator and stor.Renamer) can be used from many goroutines at once, together with List and
+// Load. Run it with the race detector to detect unsynchronized access.
+func (s *StorageTester) TestConcurrentOptional() {
+	s.skipIfReadOnly()
+	s.skipIfNotConcurrent()
+	s.insertFixture()
+
+	root := s.fixture().missing("")
+	s.runConcurrently(func(worker int) error {
+		for i := 0; i < concurrentIterations; i++ {
+			dir := fmt.Sprintf("%s/worker%d/iteration%d", root, worker, i)
+			filePath := dir + "/file1"
+			content := filePath
+			if creator, ok := s.Storage.(stor.Creator); ok {
+				if err := creator.Create(filePath, []byte(content)); err != nil {
+					return err
+				}
+			} else if err := s.Storage.Save(filePath, []byte(content)); err != nil {
+				return err
+			}
+			if dirMaker, ok := s.Storage.(stor.DirMaker); ok {
+				if err := dirMaker.MkDir(dir + "/sub"); err != nil {
+					return err
+				}
+			}
+			if generationer, ok := s.Storage.(stor.Generationer); ok {
+				if _, err := generationer.Generation(dir); err != nil {
+					return err
+				}
+			}
+			if renamer, ok := s.Storage.(stor.Renamer); ok {
+				if err := renamer.Rename(dir, dir+"-renamed"); err != nil {
+					return err
+				}
+				filePath = dir + "-renamed/file1"
+			}
+
+			if _, _, err := s.Storage.List(root); err != nil && !isNotExist(err) {
+				return err
+			}
+			data, err := s.Storage.Load(filePath, 1e6)
+			if err != nil {
+				return err
+			}
+			if string(data) != content {
+				return fmt.Errorf("Load(%q) = %q, expected %q", filePath, data, content)
+			}
+		}
+		return nil
+	})
+
+	for filePath, content := range s.fixture() {
+		data, err := s.Storage.Load(filePath, 1e6)
+		s.Nil(err, filePath)
+		s.Equal(content, string(data), filePath)
+	}
+}
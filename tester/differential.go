@@ -0,0 +1,122 @@
+package tester
+
+import (
+	"fmt"
+	"math/rand"
+	"path"
+	"sort"
+	"time"
+
+	"github.com/pw1/stor"
+)
+
+// differentialSteps is the number of random operations of TestDifferential.
+const differentialSteps = 200
+
+var (
+	// differentialDirs are the directories in which TestDifferential saves files. The last one is
+	// never created.
+	differentialDirs = []string{"", "dir1", "dir1/dir4", "dir5", "dir6"}
+
+	// differentialNames are the names of the files that TestDifferential saves. They differ from
+	// the names of the directories, so a file never replaces a directory.
+	differentialNames = []string{"file1", "file2", "file3"}
+)
+
+// errorClass returns the kind of error that err is, so errors of different Storages can be
+// compared. Unexpected errors are returned as-is.
+func errorClass(err error) string {
+	switch {
+	case err == nil:
+		return ""
+	case isNotExist(err):
+		return "doesn't exist"
+	case stor.IsTooLargeError(err):
+		return "too large"
+	case stor.IsInvalidPathError(err):
+		return "invalid path"
+	default:
+		return err.Error()
+	}
+}
+
+// TestDifferential applies a random sequence of operations both to the Storage and to the
+// reference Storage created by ReferenceFactory, and verifies that both return the same results,
+// and contain the same files after each step. It is skipped if ReferenceFactory is not set. The
+// seed of the sequence is logged, so failures can be reproduced.
+func (s *StorageTester) TestDifferential() {
+	s.skipIfReadOnly()
+	if s.ReferenceFactory == nil {
+		s.T().Skip("no reference storage")
+	}
+
+	// Both Storages start empty, so the paths of the operations don't depend on the Fixture
+	reference := s.ReferenceFactory()
+
+	seed := time.Now().UnixNano()
+	s.T().Logf("seed: %d", seed)
+	rnd := rand.New(rand.NewSource(seed))
+
+	for step := 0; step < differentialSteps; step++ {
+		dir := differentialDirs[rnd.Intn(len(differentialDirs))]
+		filePath := path.Join(dir, differentialNames[rnd.Intn(len(differentialNames))])
+
+		var op string
+		var expected, actual interface{}
+		var expectedErr, actualErr error
+		switch rnd.Intn(5) {
+		case 0:
+			data := []byte(fmt.Sprintf("step%d", step))
+			op = fmt.Sprintf("Save(%q)", filePath)
+			expectedErr = reference.Save(filePath, data)
+			actualErr = s.Storage.Save(filePath, data)
+		case 1:
+			op = fmt.Sprintf("Delete(%q)", filePath)
+			expectedErr = reference.Delete(filePath)
+			actualErr = s.Storage.Delete(filePath)
+		case 2:
+			maxSize := int64(rnd.Intn(8))
+			op = fmt.Sprintf("Load(%q, %d)", filePath, maxSize)
+			expected, expectedErr = reference.Load(filePath, maxSize)
+			actual, actualErr = s.Storage.Load(filePath, maxSize)
+			if expectedErr != nil || actualErr != nil {
+				expected, actual = nil, nil
+			}
+		case 3:
+			op = fmt.Sprintf("Meta(%q)", filePath)
+			var expectedMeta, actualMeta *stor.Meta
+			expectedMeta, expectedErr = reference.Meta(filePath)
+			actualMeta, actualErr = s.Storage.Meta(filePath)
+			expected, actual = nil, nil
+			if expectedErr == nil && actualErr == nil {
+				// Only the size is compared, the reference doesn't track modification times
+				expected, actual = expectedMeta.Size, actualMeta.Size
+			}
+		case 4:
+			op = fmt.Sprintf("List(%q)", dir)
+			expected, expectedErr = s.sortedList(reference, dir)
+			actual, actualErr = s.sortedList(s.Storage, dir)
+		}
+
+		s.Require().Equal(errorClass(expectedErr), errorClass(actualErr), "step %d: %s", step, op)
+		s.Require().Equal(expected, actual, "step %d: %s", step, op)
+		s.Require().Equal(s.allFiles(reference), s.allFiles(s.Storage), "step %d: after %s", step,
+			op)
+	}
+}
+
+// sortedList lists a directory, and returns the sorted files and subdirectories. A directory that
+// doesn't exist is empty.
+func (s *StorageTester) sortedList(st stor.Storage, dirPath string) ([][]string, error) {
+	files, dirs, err := st.List(dirPath)
+	if isNotExist(err) {
+		return [][]string{{}, {}}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	sort.Strings(files)
+	sort.Strings(dirs)
+	return [][]string{files, dirs}, nil
+}
@@ -0,0 +1,194 @@
+package tester
+
+import (
+	"fmt"
+	"path"
+	"sort"
+	"strings"
+
+	"github.com/pw1/stor"
+)
+
+// Fixture is a set of files by path, with their contents. The generic tests save the Fixture of
+// the StorageTester before each test, and derive their expectations from it.
+type Fixture map[string]string
+
+// DeepFixture returns a Fixture with a file in each of depth nested directories.
+func DeepFixture(depth int) Fixture {
+	fixture := Fixture{"file0": "test0"}
+	dir := ""
+	for i := 1; i <= depth; i++ {
+		dir = path.Join(dir, fmt.Sprintf("dir%d", i))
+		fixture[fmt.Sprintf("%s/file%d", dir, i)] = fmt.Sprintf("test%d", i)
+	}
+	return fixture
+}
+
+// WideFixture returns a Fixture with n files in a single directory, and a single file in the root.
+func WideFixture(n int) Fixture {
+	fixture := Fixture{"file0": "test0"}
+	for i := 0; i < n; i++ {
+		fixture[fmt.Sprintf("dir1/file%d", i)] = fmt.Sprintf("test%d", i)
+	}
+	return fixture
+}
+
+// EmptyFilesFixture returns a Fixture of which some files are empty.
+func EmptyFilesFixture() Fixture {
+	return Fixture{
+		"empty1":           "",
+		"dir1/empty2":      "",
+		"dir1/file3":       "test123",
+		"dir2/dir3/empty4": "",
+	}
+}
+
+// LongPathFixture returns a Fixture with the longest names that stor.CleanPath allows (see
+// stor.MaxComponentLen), and the longest path that consists of such names (see stor.MaxPathLen).
+func LongPathFixture() Fixture {
+	fileName := strings.Repeat("f", stor.MaxComponentLen)
+	dirName := strings.Repeat("d", stor.MaxComponentLen)
+	longPath := fileName
+	for len(longPath)+1+len(dirName) <= stor.MaxPathLen {
+		longPath = dirName + "/" + longPath
+	}
+	return Fixture{
+		fileName:           "test123",
+		"dir1/" + fileName: "test456",
+		longPath:           "test789",
+		"dir2/file4":       "test0123",
+	}
+}
+
+// UnusualNamesFixture returns a Fixture with names that are unusual, but valid for
+// stor.CleanPath, e.g. names that start with a dot or consist of a single character.
+func UnusualNamesFixture() Fixture {
+	return Fixture{
+		".hidden":         "test123",
+		"-":               "test456",
+		"_/.x":            "test789",
+		"a.b.c/-.-._":     "test0123",
+		"0/1.2/3_4/5-6.7": "test788909",
+	}
+}
+
+// fixture returns the Fixture of the tests: Fixture, or StandardFiles if that isn't set.
+func (s *StorageTester) fixture() Fixture {
+	if s.Fixture != nil {
+		return s.Fixture
+	}
+	return StandardFiles
+}
+
+// files returns the sorted paths of the files in the Fixture.
+func (f Fixture) files() []string {
+	files := make([]string, 0, len(f))
+	for filePath := range f {
+		files = append(files, filePath)
+	}
+	sort.Strings(files)
+	return files
+}
+
+// dirs returns the sorted paths of all directories in the Fixture, except the root.
+func (f Fixture) dirs() []string {
+	set := make(map[string]bool)
+	for filePath := range f {
+		for dir := path.Dir(filePath); dir != "."; dir = path.Dir(dir) {
+			set[dir] = true
+		}
+	}
+
+	dirs := make([]string, 0, len(set))
+	for dir := range set {
+		dirs = append(dirs, dir)
+	}
+	sort.Strings(dirs)
+	return dirs
+}
+
+// list returns the files and subdirectories that List should return for a directory.
+func (f Fixture) list(dirPath string) ([]string, []string) {
+	prefix := ""
+	if dirPath != "" {
+		prefix = dirPath + "/"
+	}
+
+	files := []string{}
+	dirSet := make(map[string]bool)
+	for filePath := range f {
+		if !strings.HasPrefix(filePath, prefix) {
+			continue
+		}
+		if idx := strings.IndexByte(filePath[len(prefix):], '/'); idx >= 0 {
+			dirSet[filePath[:len(prefix)+idx]] = true
+		} else {
+			files = append(files, filePath)
+		}
+	}
+
+	dirs := []string{}
+	for dir := range dirSet {
+		dirs = append(dirs, dir)
+	}
+	return files, dirs
+}
+
+// without returns a copy of the Fixture without the specified files.
+func (f Fixture) without(filePaths ...string) Fixture {
+	result := make(Fixture, len(f))
+	for filePath, content := range f {
+		result[filePath] = content
+	}
+	for _, filePath := range filePaths {
+		delete(result, filePath)
+	}
+	return result
+}
+
+// missing returns the path of a file in dirPath that is neither a file nor a directory in the
+// Fixture.
+func (f Fixture) missing(dirPath string) string {
+	dirs := make(map[string]bool)
+	for _, dir := range f.dirs() {
+		dirs[dir] = true
+	}
+
+	for i := 0; ; i++ {
+		filePath := path.Join(dirPath, fmt.Sprintf("no-such-file%d", i))
+		if _, ok := f[filePath]; !ok && !dirs[filePath] {
+			return filePath
+		}
+	}
+}
+
+// nestedFile returns a file within a directory (preferably the first), or "" if all files are in
+// the root.
+func (f Fixture) nestedFile() string {
+	for _, filePath := range f.files() {
+		if strings.Contains(filePath, "/") {
+			return filePath
+		}
+	}
+	return ""
+}
+
+// allFiles returns the content of all files in st by path, by listing all directories.
+func (s *StorageTester) allFiles(st stor.Storage) Fixture {
+	result := make(Fixture)
+	var walk func(dirPath string)
+	walk = func(dirPath string) {
+		files, dirs, err := st.List(dirPath)
+		s.Require().Nil(err, "List(%q)", dirPath)
+		for _, filePath := range files {
+			data, err := st.Load(filePath, 1e6)
+			s.Require().Nil(err, "Load(%q)", filePath)
+			result[filePath] = string(data)
+		}
+		for _, dir := range dirs {
+			walk(dir)
+		}
+	}
+	walk("")
+	return result
+}
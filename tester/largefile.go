@@ -0,0 +1,100 @@
+package tester
+
+import (
+	"crypto/sha256"
+	"math"
+	"math/rand"
+
+	"github.com/pw1/stor"
+)
+
+// skipUnlessLargeFiles skips the large file tests, unless they are enabled with LargeFileSize.
+func (s *StorageTester) skipUnlessLargeFiles() {
+	if s.LargeFileSize <= 0 {
+		s.T().Skip("large file tests are disabled")
+	}
+}
+
+// largeFile returns LargeFileSize bytes of pseudo-random (and therefore incompressible) data. The
+// data is the same every time.
+func (s *StorageTester) largeFile() []byte {
+	data := make([]byte, s.LargeFileSize)
+	rand.New(rand.NewSource(1)).Read(data)
+	return data
+}
+
+// largeFilePath returns the path of the large file, which is not in the Fixture.
+func (s *StorageTester) largeFilePath() string {
+	return s.fixture().missing("")
+}
+
+// saveLargeFile saves the large file, and returns the SHA-256 of its content.
+func (s *StorageTester) saveLargeFile() [sha256.Size]byte {
+	data := s.largeFile()
+	s.Require().Nil(s.Storage.Save(s.largeFilePath(), data))
+	return sha256.Sum256(data)
+}
+
+// TestLargeFile verifies that a large file is saved, that Meta reports its size, and that Load
+// returns the same content.
+func (s *StorageTester) TestLargeFile() {
+	s.skipIfReadOnly()
+	s.skipUnlessLargeFiles()
+	s.insertFixture()
+
+	sum := s.saveLargeFile()
+
+	meta, err := s.Storage.Meta(s.largeFilePath())
+	s.Nil(err)
+	s.Equal(s.LargeFileSize, meta.Size)
+
+	// Compare checksums, so a difference doesn't print hundreds of MBs
+	data, err := s.Storage.Load(s.largeFilePath(), s.LargeFileSize)
+	s.Nil(err)
+	s.Equal(s.LargeFileSize, int64(len(data)))
+	s.Equal(sum, sha256.Sum256(data))
+
+	data, err = s.Storage.Load(s.largeFilePath(), math.MaxInt64)
+	s.Nil(err)
+	s.Equal(sum, sha256.Sum256(data))
+}
+
+// TestLargeFileMaxSize verifies that Load returns a TooLargeError if a large file is one byte
+// larger than the maximum size.
+func (s *StorageTester) TestLargeFileMaxSize() {
+	s.skipIfReadOnly()
+	s.skipUnlessLargeFiles()
+	s.insertFixture()
+
+	s.saveLargeFile()
+
+	data, err := s.Storage.Load(s.largeFilePath(), s.LargeFileSize-1)
+	s.True(stor.IsTooLargeError(err))
+	s.Empty(data)
+}
+
+// TestLargeFileOverwrite verifies that a large file can be overwritten by a small file and vice
+// versa, e.g. that no parts of a multipart upload are left behind.
+func (s *StorageTester) TestLargeFileOverwrite() {
+	s.skipIfReadOnly()
+	s.skipUnlessLargeFiles()
+	s.insertFixture()
+
+	s.saveLargeFile()
+	s.Nil(s.Storage.Save(s.largeFilePath(), []byte("qwerty")))
+
+	meta, err := s.Storage.Meta(s.largeFilePath())
+	s.Nil(err)
+	s.Equal(int64(6), meta.Size)
+	data, err := s.Storage.Load(s.largeFilePath(), 1e6)
+	s.Nil(err)
+	s.Equal([]byte("qwerty"), data)
+
+	sum := s.saveLargeFile()
+	data, err = s.Storage.Load(s.largeFilePath(), s.LargeFileSize)
+	s.Nil(err)
+	s.Equal(sum, sha256.Sum256(data))
+
+	s.Nil(s.Storage.Delete(s.largeFilePath()))
+	s.Equal(s.fixture(), s.allFiles(s.Storage))
+}
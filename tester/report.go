@@ -0,0 +1,138 @@
+package tester
+
+import (
+	"encoding/json"
+	"io"
+	"os"
+	"sort"
+	"sync"
+
+	"github.com/pw1/stor"
+)
+
+// Result is the outcome of a single test, or of all tests of a Capability, in a conformance
+// Report.
+type Result = stor.ConformanceResult
+
+const (
+	// ResultPassed indicates that the Storage passed the test.
+	ResultPassed = stor.ConformancePassed
+
+	// ResultFailed indicates that the Storage failed the test.
+	ResultFailed = stor.ConformanceFailed
+
+	// ResultSkipped indicates that the test was skipped. E.g. because the Storage doesn't support
+	// the tested capability.
+	ResultSkipped = stor.ConformanceSkipped
+)
+
+// Report is a machine-readable conformance report of a StorageTester run. Backend authors can
+// publish it to document which parts of the stor.Storage contract their implementation meets.
+type Report struct {
+	// Type is the storage Type that was tested (optional).
+	Type stor.Type `json:"type,omitempty"`
+
+	// Results maps the name of each test to its outcome.
+	Results map[string]Result `json:"results"`
+
+	// Capabilities maps each tested Capability to the aggregated outcome of its tests: failed if
+	// any test failed, passed if none failed and any passed, and skipped otherwise.
+	Capabilities map[stor.Capability]Result `json:"capabilities,omitempty"`
+
+	mutex sync.Mutex
+}
+
+// NewReport creates a new, empty Report for a storage Type.
+func NewReport(storageType stor.Type) *Report {
+	return &Report{
+		Type:         storageType,
+		Results:      make(map[string]Result),
+		Capabilities: make(map[stor.Capability]Result),
+	}
+}
+
+// RegisterConformance registers the outcome per Capability of a Report for its Type (see
+// stor.RegisterConformance), so it is listed in the Info of the Type.
+func RegisterConformance(report *Report) {
+	report.mutex.Lock()
+	defer report.mutex.Unlock()
+
+	stor.RegisterConformance(report.Type, report.Capabilities)
+}
+
+// ReadReport reads a Report in JSON format.
+func ReadReport(r io.Reader) (*Report, error) {
+	report := NewReport(stor.TypeUnspecified)
+	if err := json.NewDecoder(r).Decode(report); err != nil {
+		return nil, err
+	}
+	return report, nil
+}
+
+// record saves the Result of a single test, and aggregates it in the outcome of the Capability
+// that the test verifies, if any.
+func (r *Report) record(test string, capability stor.Capability, result Result) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	if r.Results == nil {
+		r.Results = make(map[string]Result)
+	}
+	r.Results[test] = result
+
+	if capability == "" {
+		return
+	}
+	if r.Capabilities == nil {
+		r.Capabilities = make(map[stor.Capability]Result)
+	}
+	switch current, ok := r.Capabilities[capability]; {
+	case !ok, result == ResultFailed, current == ResultSkipped:
+		r.Capabilities[capability] = result
+	}
+}
+
+// Tests returns the sorted names of all tests with the specified Result.
+func (r *Report) Tests(result Result) []string {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	tests := []string{}
+	for test, res := range r.Results {
+		if res == result {
+			tests = append(tests, test)
+		}
+	}
+	sort.Strings(tests)
+	return tests
+}
+
+// Passed returns true if no test in the Report failed.
+func (r *Report) Passed() bool {
+	return len(r.Tests(ResultFailed)) == 0
+}
+
+// WriteJSON writes the Report in JSON format to w.
+func (r *Report) WriteJSON(w io.Writer) error {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(r)
+}
+
+// WriteFile writes the Report in JSON format to a file.
+func (r *Report) WriteFile(filePath string) error {
+	f, err := os.Create(filePath)
+	if err != nil {
+		return err
+	}
+
+	if err = r.WriteJSON(f); err != nil {
+		f.Close()
+		return err
+	}
+
+	return f.Close()
+}
@@ -0,0 +1,171 @@
+package tester
+
+import (
+	"fmt"
+	"math/rand"
+	"sort"
+	"sync"
+	"time"
+)
+
+const (
+	// soakRoundOps is the number of operations of every goroutine between two samples of the
+	// invariants of TestSoak.
+	soakRoundOps = 50
+
+	// soakDirs is the number of directories in which every goroutine of TestSoak saves files.
+	soakDirs = 4
+
+	// soakNames is the number of file names in every directory of TestSoak.
+	soakNames = 8
+)
+
+// skipUnlessSoak skips the soak test, unless it is enabled with SoakDuration or SoakOps.
+func (s *StorageTester) skipUnlessSoak() {
+	if s.SoakDuration <= 0 && s.SoakOps <= 0 {
+		s.T().Skip("soak test is disabled")
+	}
+}
+
+// soakWorker is a goroutine of TestSoak. It owns a directory, and tracks the files that it
+// contains.
+type soakWorker struct {
+	dir   string
+	rnd   *rand.Rand
+	files Fixture
+}
+
+// step performs a random operation within the directory of the worker, and verifies its result
+// against the tracked files.
+func (w *soakWorker) step(s *StorageTester, op int) error {
+	filePath := fmt.Sprintf("%s/dir%d/file%d", w.dir, w.rnd.Intn(soakDirs), w.rnd.Intn(soakNames))
+	content, exists := w.files[filePath]
+
+	switch w.rnd.Intn(4) {
+	case 0, 1:
+		content = fmt.Sprintf("%s-%d", filePath, op)
+		if err := s.Storage.Save(filePath, []byte(content)); err != nil {
+			return fmt.Errorf("Save(%q): %v", filePath, err)
+		}
+		w.files[filePath] = content
+	case 2:
+		err := s.Storage.Delete(filePath)
+		if !exists {
+			if !isNotExist(err) {
+				return fmt.Errorf("Delete(%q) of a missing file: %v", filePath, err)
+			}
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("Delete(%q): %v", filePath, err)
+		}
+		delete(w.files, filePath)
+	case 3:
+		data, err := s.Storage.Load(filePath, 1e6)
+		if !exists {
+			if !isNotExist(err) {
+				return fmt.Errorf("Load(%q) of a missing file: %v", filePath, err)
+			}
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("Load(%q): %v", filePath, err)
+		}
+		if string(data) != content {
+			return fmt.Errorf("Load(%q) = %q, expected %q", filePath, data, content)
+		}
+	}
+	return nil
+}
+
+// TestSoak continuously saves, overwrites, loads and deletes files, for SoakDuration or until
+// SoakOps operations are done, whichever comes first. The operations run in concurrentWorkers
+// goroutines, unless the Storage is NotConcurrent. Between rounds of operations it verifies that
+// the Storage contains exactly the tracked files, and no directories without files (unless the
+// Storage has KeepEmptyDirs). The seed of the operations is logged, so failures can be reproduced.
+func (s *StorageTester) TestSoak() {
+	s.skipIfReadOnly()
+	s.skipUnlessSoak()
+	s.insertFixture()
+
+	seed := time.Now().UnixNano()
+	s.T().Logf("seed: %d", seed)
+
+	numWorkers := concurrentWorkers
+	if s.NotConcurrent {
+		numWorkers = 1
+	}
+	root := s.fixture().missing("")
+	workers := make([]*soakWorker, numWorkers)
+	for i := range workers {
+		workers[i] = &soakWorker{
+			dir:   fmt.Sprintf("%s/worker%d", root, i),
+			rnd:   rand.New(rand.NewSource(seed + int64(i))),
+			files: make(Fixture),
+		}
+	}
+
+	var deadline time.Time
+	if s.SoakDuration > 0 {
+		deadline = time.Now().Add(s.SoakDuration)
+	}
+	ops := 0
+	for round := 0; ; round++ {
+		if s.SoakOps > 0 && ops >= s.SoakOps {
+			break
+		}
+		if !deadline.IsZero() && time.Now().After(deadline) {
+			break
+		}
+
+		var wg sync.WaitGroup
+		errs := make([]error, numWorkers)
+		for i, worker := range workers {
+			wg.Add(1)
+			go func(i int, worker *soakWorker) {
+				defer wg.Done()
+				for op := 0; op < soakRoundOps; op++ {
+					if err := worker.step(s, round*soakRoundOps+op); err != nil {
+						errs[i] = fmt.Errorf("worker %d: %v", i, err)
+						return
+					}
+				}
+			}(i, worker)
+		}
+		wg.Wait()
+		ops += numWorkers * soakRoundOps
+
+		for _, err := range errs {
+			s.Require().Nil(err, "round %d", round)
+		}
+
+		expected := s.fixture().without()
+		for _, worker := range workers {
+			for filePath, content := range worker.files {
+				expected[filePath] = content
+			}
+		}
+		s.Require().Equal(expected, s.allFiles(s.Storage), "round %d", round)
+		if !s.KeepEmptyDirs {
+			s.Require().Equal(expected.dirs(), s.allDirs(), "round %d: orphaned directories", round)
+		}
+	}
+	s.T().Logf("operations: %d", ops)
+}
+
+// allDirs returns the sorted paths of all directories in the Storage, by listing all directories.
+func (s *StorageTester) allDirs() []string {
+	result := []string{}
+	var walk func(dirPath string)
+	walk = func(dirPath string) {
+		_, dirs, err := s.Storage.List(dirPath)
+		s.Require().Nil(err, "List(%q)", dirPath)
+		for _, dir := range dirs {
+			result = append(result, dir)
+			walk(dir)
+		}
+	}
+	walk("")
+	sort.Strings(result)
+	return result
+}
@@ -24,10 +24,40 @@
 // 	    suite.Run(t, testSuite)
 //  }
 //
+// The outcome of each test can be collected in a machine-readable conformance Report by setting
+// StorageTester.Report:
+//
+//  report := tester.NewReport(MyStorageType)
+//  testSuite := &tester.StorageTester{
+//      ConfFactory: myConfFactory,
+//      Report:      report,
+//  }
+//  suite.Run(t, testSuite)
+//  report.WriteFile("conformance.json")
+//
+// The Report also aggregates the outcome per stor.Capability. RegisterConformance registers that
+// outcome, so it is listed in the stor.Info of the Type.
+//
+// The tests save a Fixture (StandardFiles by default) before each test, and derive their
+// expectations from it. Set StorageTester.Fixture to use another one, e.g. a DeepFixture or a
+// LongPathFixture.
+//
+// Read-only storages can't be prepared by the tests. Fill them with the Fixture beforehand, and
+// set StorageTester.ReadOnly.
+//
+// The tests of optional interfaces (e.g. stor.Renamer) are skipped if the Storage doesn't implement
+// them, so the same suite validates both minimal and rich storages. If the Storage is created by
+// a ConfFactory, then its Type's Info must list exactly the implemented capabilities.
+//
+// Some tests are opt-in, because they take long: set StorageTester.LargeFileSize for the large
+// file tests, and StorageTester.SoakDuration or StorageTester.SoakOps for the soak test.
+//
 package tester
 
 import (
 	"fmt"
+	"sort"
+	"time"
 
 	"github.com/pw1/stor"
 	"github.com/stretchr/testify/suite"
@@ -59,6 +89,60 @@ type StorageTester struct {
 
 	// TearDownTestFunc is called after each test.
 	TearDownTestFunc func(*StorageTester)
+
+	// Report receives the outcome of each test, if it is set.
+	Report *Report
+
+	// Fixture contains the files that are saved before each test. If it is nil, then StandardFiles
+	// is used. It must contain at least one file.
+	Fixture Fixture
+
+	// ReadOnly indicates that the Storage is read-only, and that it already contains the
+	// Fixture. The tests that write are skipped, and Save and Delete are verified to return a
+	// stor.ReadOnlyError instead.
+	ReadOnly bool
+
+	// NotConcurrent indicates that the Storage is not safe for concurrent use (see the contract of
+	// stor.Storage). The tests that use the Storage from several goroutines at once are skipped.
+	// Run the other tests with the race detector to verify that a Storage is safe.
+	NotConcurrent bool
+
+	// LargeFileSize is the size in bytes of the file that is saved and loaded by the large file
+	// tests, e.g. several hundreds of MBs, or more than the part size of multipart uploads. The
+	// large file tests are skipped if it is zero.
+	LargeFileSize int64
+
+	// ReferenceFactory creates an empty reference Storage, typically a memory.Memory. If it is set,
+	// TestDifferential compares the results of random operations on the Storage with those on the
+	// reference.
+	ReferenceFactory func() stor.Storage
+
+	// SoakDuration and SoakOps bound the duration and the number of operations of TestSoak, which
+	// continuously churns files, e.g. to validate a backend against a real endpoint. TestSoak stops
+	// at whichever bound is reached first, and is skipped if both are zero.
+	SoakDuration time.Duration
+	SoakOps      int
+
+	// SortedList indicates that List returns sorted results, which TestListSorted then verifies.
+	// It is implied if the Storage implements stor.SortedLister.
+	SortedList bool
+
+	// KeepEmptyDirs indicates that directories remain when the last file within them is deleted.
+	// The tests then expect the empty directories, instead of verifying that they are removed.
+	KeepEmptyDirs bool
+
+	// capability is the Capability that the current test verifies, if any (see testsCapability).
+	capability stor.Capability
+}
+
+// StandardFiles is the default Fixture. It is saved before each test, unless the StorageTester is
+// ReadOnly.
+var StandardFiles = Fixture{
+	"file1":           "test123",
+	"dir1/file2":      "test456",
+	"dir1/file3":      "test789",
+	"dir1/dir4/file5": "test788909",
+	"dir2/dir3/file4": "test0123",
 }
 
 // SetupSuite is executed before the first test is executed. It will call SetupSuiteFunc if that is
@@ -104,16 +188,39 @@ func (s *StorageTester) TearDownTest() {
 	s.Storage = nil
 }
 
-func (s *StorageTester) insertStandardFiles() {
-	files := map[string]string{
-		"file1":           "test123",
-		"dir1/file2":      "test456",
-		"dir1/file3":      "test789",
-		"dir1/dir4/file5": "test788909",
-		"dir2/dir3/file4": "test0123",
+// AfterTest is called after each test is executed, before TearDownTest. It records the outcome
+// of the test in s.Report if that is defined.
+func (s *StorageTester) AfterTest(suiteName, testName string) {
+	if s.Report == nil {
+		return
 	}
 
-	for filepath, content := range files {
+	capability := s.capability
+	s.capability = ""
+
+	switch {
+	case s.T().Failed():
+		s.Report.record(testName, capability, ResultFailed)
+	case s.T().Skipped():
+		s.Report.record(testName, capability, ResultSkipped)
+	default:
+		s.Report.record(testName, capability, ResultPassed)
+	}
+}
+
+// testsCapability marks the current test as a test of a Capability, so its outcome is aggregated
+// per Capability in the Report.
+func (s *StorageTester) testsCapability(capability stor.Capability) {
+	s.capability = capability
+}
+
+// insertFixture saves the Fixture in the Storage, unless the Storage is read-only.
+func (s *StorageTester) insertFixture() {
+	if s.ReadOnly {
+		return
+	}
+
+	for filepath, content := range s.fixture() {
 		err := s.Storage.Save(filepath, []byte(content))
 		if err != nil {
 			msg := fmt.Sprintf("Failed to prepare test:\n  -> Failed to insert file: %s", filepath)
@@ -123,20 +230,40 @@ func (s *StorageTester) insertStandardFiles() {
 	}
 }
 
+// skipIfReadOnly skips tests that write if the Storage is read-only.
+func (s *StorageTester) skipIfReadOnly() {
+	if s.ReadOnly {
+		s.T().Skip("storage is read-only")
+	}
+}
+
 // TestMeta verifies that Meta() returns meta information about a file.
 func (s *StorageTester) TestMeta() {
-	s.insertStandardFiles()
+	s.insertFixture()
 
-	meta, err := s.Storage.Meta("dir1/file3")
-	s.Nil(err)
-	s.Equal(&stor.Meta{
-		Size: 7,
-	}, meta)
+	for filePath, content := range s.fixture() {
+		meta, err := s.Storage.Meta(filePath)
+		s.Nil(err, filePath)
+		s.checkMeta(meta, int64(len(content)), filePath)
+	}
+}
+
+// checkMeta verifies that meta reports size, and a plausible ModTime if the Storage reports one.
+// Read-only storages may serve files that were saved long ago.
+func (s *StorageTester) checkMeta(meta *stor.Meta, size int64, filePath string) {
+	if !s.NotNil(meta, filePath) {
+		return
+	}
+
+	s.Equal(size, meta.Size, filePath)
+	if !meta.ModTime.IsZero() && !s.ReadOnly {
+		s.WithinDuration(time.Now(), meta.ModTime, time.Hour, filePath)
+	}
 }
 
 // TestMetaEscapes verifies that Meta() returns an error if the supplied path is invalid.
 func (s *StorageTester) TestMetaEscapes() {
-	s.insertStandardFiles()
+	s.insertFixture()
 
 	meta, err := s.Storage.Meta("../file1")
 	s.NotNil(err)
@@ -146,23 +273,27 @@ func (s *StorageTester) TestMetaEscapes() {
 
 // TestMetaNonExisting verifies that Meta() returns an error if the supplied path doesn't exist.
 func (s *StorageTester) TestMetaNonExisting() {
-	s.insertStandardFiles()
-
-	meta, err := s.Storage.Meta("dir1/file1")
-	s.NotNil(err)
-	s.True(stor.IsPathDoesntExistError(err))
-	s.Nil(meta)
+	s.insertFixture()
+
+	for _, dir := range append([]string{""}, s.fixture().dirs()...) {
+		filePath := s.fixture().missing(dir)
+		meta, err := s.Storage.Meta(filePath)
+		s.NotNil(err, filePath)
+		s.True(stor.IsPathDoesntExistError(err), filePath)
+		s.Nil(meta, filePath)
+	}
 }
 
 // TestList verifies that List() returns a list of files and subdirectories in the root of the
 // storage.
 func (s *StorageTester) TestList() {
-	s.insertStandardFiles()
+	s.insertFixture()
 
+	expectedFiles, expectedDirs := s.fixture().list("")
 	files, dirs, err := s.Storage.List("")
 	s.Nil(err)
-	s.ElementsMatch([]string{"file1"}, files)
-	s.ElementsMatch([]string{"dir1", "dir2"}, dirs)
+	s.ElementsMatch(expectedFiles, files)
+	s.ElementsMatch(expectedDirs, dirs)
 }
 
 // TestListEscapes verifies that List() returns an error if the supplied path is invalid.
@@ -177,36 +308,61 @@ func (s *StorageTester) TestListEscapes() {
 // TestListDot verifies that List(".") lists the files and subdirectories in the root of the
 // storage.
 func (s *StorageTester) TestListDot() {
-	s.insertStandardFiles()
+	s.insertFixture()
 
+	expectedFiles, expectedDirs := s.fixture().list("")
 	files, dirs, err := s.Storage.List(".")
 	s.Nil(err)
-	s.ElementsMatch([]string{"file1"}, files)
-	s.ElementsMatch([]string{"dir1", "dir2"}, dirs)
+	s.ElementsMatch(expectedFiles, files)
+	s.ElementsMatch(expectedDirs, dirs)
 }
 
-// TestListDir1 verifies that List() returns files and subdirectories in a directory.
+// TestListDir1 verifies that List() returns files and subdirectories in a directory, for every
+// directory of the Fixture.
 func (s *StorageTester) TestListDir1() {
-	s.insertStandardFiles()
+	s.insertFixture()
+
+	for _, dir := range s.fixture().dirs() {
+		expectedFiles, expectedDirs := s.fixture().list(dir)
+		files, dirs, err := s.Storage.List(dir)
+		s.Nil(err, dir)
+		s.ElementsMatch(expectedFiles, files, dir)
+		s.ElementsMatch(expectedDirs, dirs, dir)
+	}
+}
 
-	files, dirs, err := s.Storage.List("dir1")
-	s.Nil(err)
-	s.ElementsMatch([]string{"dir1/file2", "dir1/file3"}, files)
-	s.ElementsMatch([]string{"dir1/dir4"}, dirs)
+// TestListSorted verifies that List() returns sorted files and subdirectories in every directory.
+// It is skipped unless the tester has SortedList or the Storage implements stor.SortedLister.
+func (s *StorageTester) TestListSorted() {
+	s.testsCapability(stor.CapabilitySortedList)
+	_, sorted := s.Storage.(stor.SortedLister)
+	if !s.SortedList && !sorted {
+		s.T().Skip("the order of List is unspecified")
+	}
+	s.insertFixture()
+
+	for _, dir := range append([]string{""}, s.fixture().dirs()...) {
+		files, dirs, err := s.Storage.List(dir)
+		s.Nil(err, dir)
+		s.True(sort.StringsAreSorted(files), "files of %q are not sorted: %v", dir, files)
+		s.True(sort.StringsAreSorted(dirs), "dirs of %q are not sorted: %v", dir, dirs)
+	}
 }
 
 // TestLoad verifies that Load() returns the content of a file.
 func (s *StorageTester) TestLoad() {
-	s.insertStandardFiles()
+	s.insertFixture()
 
-	data, err := s.Storage.Load("file1", 1e6)
-	s.Nil(err)
-	s.Equal([]byte("test123"), data)
+	for filePath, content := range s.fixture() {
+		data, err := s.Storage.Load(filePath, 1e6)
+		s.Nil(err, filePath)
+		s.Equal([]byte(content), data, filePath)
+	}
 }
 
 // TestLoadEscapes verifies that Load() returns an error if the supplied path is invalid.
 func (s *StorageTester) TestLoadEscapes() {
-	s.insertStandardFiles()
+	s.insertFixture()
 
 	data, err := s.Storage.Load("../file1", 1e6)
 	s.NotNil(err)
@@ -216,139 +372,237 @@ func (s *StorageTester) TestLoadEscapes() {
 
 // TestLoadInDir verifies that Load() returns the content of a file in a directory.
 func (s *StorageTester) TestLoadInDir() {
-	s.insertStandardFiles()
+	s.insertFixture()
+
+	filePath := s.fixture().nestedFile()
+	if filePath == "" {
+		s.T().Skip("the fixture has no files in directories")
+	}
 
-	data, err := s.Storage.Load("dir1/file2", 1e6)
+	data, err := s.Storage.Load(filePath, 1e6)
 	s.Nil(err)
-	s.Equal([]byte("test456"), data)
+	s.Equal([]byte(s.fixture()[filePath]), data)
 }
 
 // TestLoadWithMaxSize verifies that Load() returns an error if the specified file is larger than
-// the specified maximum size.
+// the specified maximum size, and that a file of exactly the maximum size is loaded.
 func (s *StorageTester) TestLoadWithMaxSize() {
-	s.insertStandardFiles()
-
-	data, err := s.Storage.Load("file1", 6)
-	s.NotNil(err)
-	s.True(stor.IsTooLargeError(err))
-	s.Equal([]byte{}, data)
+	s.insertFixture()
+
+	for filePath, content := range s.fixture() {
+		size := int64(len(content))
+		data, err := s.Storage.Load(filePath, size-1)
+		s.NotNil(err, filePath)
+		s.True(stor.IsTooLargeError(err), filePath)
+		s.Equal([]byte{}, data, filePath)
+
+		data, err = s.Storage.Load(filePath, size)
+		s.Nil(err, filePath)
+		s.Equal([]byte(content), data, filePath)
+	}
 }
 
 // TestLoadNonExisting verifies that Load() returns an error if the supplied path doesn't exist.
 func (s *StorageTester) TestLoadNonExisting() {
-	s.insertStandardFiles()
-
-	data, err := s.Storage.Load("dir1/file1", 1e6)
-	s.NotNil(err)
-	s.True(stor.IsPathDoesntExistError(err))
-	s.Equal([]byte{}, data)
+	s.insertFixture()
+
+	for _, dir := range append([]string{""}, s.fixture().dirs()...) {
+		filePath := s.fixture().missing(dir)
+		data, err := s.Storage.Load(filePath, 1e6)
+		s.NotNil(err, filePath)
+		s.True(stor.IsPathDoesntExistError(err), filePath)
+		s.Equal([]byte{}, data, filePath)
+	}
 }
 
 // TestSave verifies that Save() saves data to a file.
 func (s *StorageTester) TestSave() {
-	s.insertStandardFiles()
+	s.skipIfReadOnly()
+	s.insertFixture()
 
-	testFile := "dir1/new-file.txt"
 	testData := []byte("my-data")
-
-	err := s.Storage.Save(testFile, testData)
-	s.Nil(err)
-
-	savedData, err := s.Storage.Load(testFile, 1e6)
-	s.Nil(err)
-	s.Equal(testData, savedData)
+	for _, dir := range append([]string{""}, s.fixture().dirs()...) {
+		testFile := s.fixture().missing(dir)
+		err := s.Storage.Save(testFile, testData)
+		s.Nil(err, testFile)
+
+		savedData, err := s.Storage.Load(testFile, 1e6)
+		s.Nil(err, testFile)
+		s.Equal(testData, savedData, testFile)
+	}
 }
 
 // TestSaveOverwrite verifies that Save() overwrites an existing file without any error.
 func (s *StorageTester) TestSaveOverwrite() {
-	s.insertStandardFiles()
+	s.skipIfReadOnly()
+	s.insertFixture()
 
-	testFile := "file1"
 	testData := []byte("my-data")
+	for filePath := range s.fixture() {
+		err := s.Storage.Save(filePath, testData)
+		s.Nil(err, filePath)
 
-	err := s.Storage.Save(testFile, testData)
-	s.Nil(err)
-
-	savedData, err := s.Storage.Load(testFile, 1e6)
-	s.Nil(err)
-	s.Equal(testData, savedData)
+		savedData, err := s.Storage.Load(filePath, 1e6)
+		s.Nil(err, filePath)
+		s.Equal(testData, savedData, filePath)
+	}
 }
 
 // TestSaveEscapes verifies that Save() returns an error if the supplied path is invalid.
 func (s *StorageTester) TestSaveEscapes() {
-	s.insertStandardFiles()
+	s.skipIfReadOnly()
+	s.insertFixture()
 
 	err := s.Storage.Save("../file1", []byte("qwerty"))
 	s.NotNil(err)
 	s.True(stor.IsInvalidPathError(err))
 }
 
-// TestDelete verifies that Delete() removes a file from storage.
+// TestDelete verifies that Delete() removes a file from storage, and leaves the other files.
 func (s *StorageTester) TestDelete() {
-	s.insertStandardFiles()
+	s.skipIfReadOnly()
+	s.insertFixture()
 
-	err := s.Storage.Delete("dir1/file2")
+	filePath := s.fixture().files()[0]
+	err := s.Storage.Delete(filePath)
 	s.Nil(err)
 
-	_, err = s.Storage.Load("dir1/file2", 1e6)
+	_, err = s.Storage.Load(filePath, 1e6)
 	s.NotNil(err)
 
-	files, dirs, err := s.Storage.List("dir1")
-	s.Nil(err)
-	s.ElementsMatch([]string{"dir1/file3"}, files)
-	s.ElementsMatch([]string{"dir1/dir4"}, dirs)
+	s.Equal(s.fixture().without(filePath), s.allFiles(s.Storage))
 }
 
 // TestDeleteDir verifies that if the last file inside a subdirectory is removed, that the parent
-// subdirectory (which is now empty) is also removed.
+// subdirectory (which is now empty) is also removed, unless the Storage has KeepEmptyDirs.
 func (s *StorageTester) TestDeleteDir() {
-	s.insertStandardFiles()
-
-	err := s.Storage.Delete("dir2/dir3/file4")
-	s.Nil(err)
+	s.skipIfReadOnly()
+	s.insertFixture()
 
-	_, err = s.Storage.Load("dir2/dir3/file4", 1e6)
-	s.NotNil(err)
+	// Delete all files of the last directory of the Fixture
+	dirs := s.fixture().dirs()
+	if len(dirs) == 0 {
+		s.T().Skip("the fixture has no directories")
+	}
+	dir := dirs[len(dirs)-1]
+	files, _ := s.fixture().list(dir)
+	for _, filePath := range files {
+		err := s.Storage.Delete(filePath)
+		s.Nil(err)
+
+		_, err = s.Storage.Load(filePath, 1e6)
+		s.NotNil(err)
+	}
 
+	remaining := s.fixture().without(files...)
+	expectedFiles, expectedDirs := remaining.list("")
+	if s.KeepEmptyDirs {
+		_, expectedDirs = s.fixture().list("")
+	}
 	files, dirs, err := s.Storage.List("")
 	s.Nil(err)
-	s.ElementsMatch([]string{"file1"}, files)
-	s.ElementsMatch([]string{"dir1"}, dirs)
+	s.ElementsMatch(expectedFiles, files)
+	s.ElementsMatch(expectedDirs, dirs)
+	s.Equal(remaining, s.allFiles(s.Storage))
 }
 
 // TestDeleteNonExisting verifies that Delete() returns an error if the supplied path doesn't exist.
 func (s *StorageTester) TestDeleteNonExisting() {
+	s.skipIfReadOnly()
 	err := s.Storage.Delete("dir1/file1")
 	s.NotNil(err)
 	s.True(stor.IsPathDoesntExistError(err))
 }
 
 // TestDeleteAll verifies if all files are deleted one by one that the storage is empty afterwards.
+// If the Storage has KeepEmptyDirs, then only the directories remain.
 func (s *StorageTester) TestDeleteAll() {
-	s.insertStandardFiles()
+	s.skipIfReadOnly()
+	s.insertFixture()
 
-	err := s.Storage.Delete("file1")
-	s.Nil(err)
-	err = s.Storage.Delete("dir1/file2")
-	s.Nil(err)
-	err = s.Storage.Delete("dir1/file3")
-	s.Nil(err)
-	err = s.Storage.Delete("dir1/dir4/file5")
-	s.Nil(err)
-	err = s.Storage.Delete("dir2/dir3/file4")
-	s.Nil(err)
+	for filePath := range s.fixture() {
+		err := s.Storage.Delete(filePath)
+		s.Nil(err, filePath)
+	}
 
+	expectedDirs := []string{}
+	if s.KeepEmptyDirs {
+		_, expectedDirs = s.fixture().list("")
+	}
 	files, dirs, err := s.Storage.List("")
 	s.Nil(err)
 	s.ElementsMatch([]string{}, files)
-	s.ElementsMatch([]string{}, dirs)
+	s.ElementsMatch(expectedDirs, dirs)
+	s.Empty(s.allFiles(s.Storage))
 }
 
 // TestDeleteEscapes verifies that Delete() returns an error if the supplied path is invalid.
 func (s *StorageTester) TestDeleteEscapes() {
-	s.insertStandardFiles()
+	s.skipIfReadOnly()
+	s.insertFixture()
 
 	err := s.Storage.Delete("../file1")
 	s.NotNil(err)
 	s.True(stor.IsInvalidPathError(err))
 }
+
+// TestCreate verifies that stor.Create() creates new files, and returns an AlreadyExistsError for
+// files that exist. The native Create is used if the Storage implements stor.Creator.
+func (s *StorageTester) TestCreate() {
+	s.skipIfReadOnly()
+	s.insertFixture()
+
+	newFile := s.fixture().missing("")
+	err := stor.Create(s.Storage, newFile, []byte("qwerty"))
+	s.Nil(err)
+	data, err := s.Storage.Load(newFile, 1e6)
+	s.Nil(err)
+	s.Equal([]byte("qwerty"), data)
+
+	existing := s.fixture().files()[0]
+	err = stor.Create(s.Storage, existing, []byte("qwerty"))
+	s.True(stor.IsAlreadyExistsError(err))
+	data, err = s.Storage.Load(existing, 1e6)
+	s.Nil(err)
+	s.Equal([]byte(s.fixture()[existing]), data)
+
+	err = stor.Create(s.Storage, "../file1", []byte("qwerty"))
+	s.True(stor.IsInvalidPathError(err))
+}
+
+// TestSaveReadOnly verifies that Save() returns a ReadOnlyError if the Storage is read-only, and
+// that the file is not modified.
+func (s *StorageTester) TestSaveReadOnly() {
+	s.testsCapability(stor.CapabilityReadOnly)
+	if !s.ReadOnly {
+		s.T().Skip("storage is not read-only")
+	}
+
+	existing := s.fixture().files()[0]
+	err := s.Storage.Save(existing, []byte("qwerty"))
+	s.True(stor.IsReadOnlyError(err))
+
+	err = s.Storage.Save(s.fixture().missing(""), []byte("qwerty"))
+	s.True(stor.IsReadOnlyError(err))
+
+	data, err := s.Storage.Load(existing, 1e6)
+	s.Nil(err)
+	s.Equal([]byte(s.fixture()[existing]), data)
+}
+
+// TestDeleteReadOnly verifies that Delete() returns a ReadOnlyError if the Storage is read-only, and
+// that the file is not removed.
+func (s *StorageTester) TestDeleteReadOnly() {
+	s.testsCapability(stor.CapabilityReadOnly)
+	if !s.ReadOnly {
+		s.T().Skip("storage is not read-only")
+	}
+
+	existing := s.fixture().files()[0]
+	err := s.Storage.Delete(existing)
+	s.True(stor.IsReadOnlyError(err))
+
+	data, err := s.Storage.Load(existing, 1e6)
+	s.Nil(err)
+	s.Equal([]byte(s.fixture()[existing]), data)
+}
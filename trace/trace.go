@@ -0,0 +1,139 @@
+// Package trace implements a stor.Storage wrapper that reports every operation as a span to a
+// tracing system, so storage latency shows up in distributed traces.
+//
+// The wrapper doesn't depend on a specific tracing library. It reports to a Tracer, which is a
+// small interface that can be implemented on top of e.g. OpenTelemetry in a few lines.
+//
+// The Storage interface doesn't carry a context.Context, so spans can't be linked to the span of
+// the caller by this package. A Tracer can still link them, e.g. to a span that it keeps per
+// goroutine or per request.
+package trace
+
+import (
+	"github.com/pw1/stor"
+)
+
+// The attribute keys that are set on spans.
+const (
+	// AttrType is the type of the underlying storage.
+	AttrType = "stor.type"
+
+	// AttrPath is the path of the file or directory of the operation.
+	AttrPath = "stor.path"
+
+	// AttrSize is the number of bytes that was loaded or saved, or the size reported by Meta.
+	AttrSize = "stor.size"
+
+	// AttrMaxSize is the maxSize argument of Load.
+	AttrMaxSize = "stor.max_size"
+
+	// AttrEntries is the number of files and directories that was listed.
+	AttrEntries = "stor.entries"
+)
+
+// Tracer starts spans.
+type Tracer interface {
+	// Start starts a span for an operation. The name is "stor." followed by the name of the
+	// method, e.g. "stor.Load".
+	Start(name string) Span
+}
+
+// Span is a single traced operation.
+type Span interface {
+	// SetAttribute sets an attribute of the span. The value is a string or an int64.
+	SetAttribute(key string, value interface{})
+
+	// End ends the span. The err argument is the error of the operation, or nil if it succeeded.
+	End(err error)
+}
+
+// Trace is a stor.Storage wrapper that reports every operation as a span to a Tracer.
+type Trace struct {
+	base        stor.Storage
+	backendType stor.Type
+	tracer      Tracer
+}
+
+// New creates a new Trace around base, which reports to tracer. The backendType is set as
+// attribute on all spans. If it is empty, then the Type of base is used (see stor.TypeOf).
+func New(base stor.Storage, backendType stor.Type, tracer Tracer) *Trace {
+	if backendType == "" {
+		backendType = stor.TypeOf(base)
+	}
+	return &Trace{
+		base:        base,
+		backendType: backendType,
+		tracer:      tracer,
+	}
+}
+
+// start starts a span with the common attributes.
+func (t *Trace) start(name, path string) Span {
+	span := t.tracer.Start("stor." + name)
+	if t.backendType != "" {
+		span.SetAttribute(AttrType, string(t.backendType))
+	}
+	span.SetAttribute(AttrPath, path)
+	return span
+}
+
+// Meta returns meta information about a file.
+func (t *Trace) Meta(filePath string) (*stor.Meta, error) {
+	span := t.start("Meta", filePath)
+	meta, err := t.base.Meta(filePath)
+	if err == nil {
+		span.SetAttribute(AttrSize, meta.Size)
+	}
+	span.End(err)
+	return meta, err
+}
+
+// List returns the files and subdirectories within the specified directory.
+func (t *Trace) List(dirPath string) ([]string, []string, error) {
+	span := t.start("List", dirPath)
+	files, dirs, err := t.base.List(dirPath)
+	if err == nil {
+		span.SetAttribute(AttrEntries, int64(len(files)+len(dirs)))
+	}
+	span.End(err)
+	return files, dirs, err
+}
+
+// Load loads the content of the specified file.
+func (t *Trace) Load(filePath string, maxSize int64) ([]byte, error) {
+	span := t.start("Load", filePath)
+	span.SetAttribute(AttrMaxSize, maxSize)
+	data, err := t.base.Load(filePath, maxSize)
+	if err == nil {
+		span.SetAttribute(AttrSize, int64(len(data)))
+	}
+	span.End(err)
+	return data, err
+}
+
+// Save saves the data to the specified file.
+func (t *Trace) Save(filePath string, data []byte) error {
+	span := t.start("Save", filePath)
+	span.SetAttribute(AttrSize, int64(len(data)))
+	err := t.base.Save(filePath, data)
+	span.End(err)
+	return err
+}
+
+// Delete removes a file from storage.
+func (t *Trace) Delete(filePath string) error {
+	span := t.start("Delete", filePath)
+	err := t.base.Delete(filePath)
+	span.End(err)
+	return err
+}
+
+// Type returns the Type of the base Storage.
+func (t *Trace) Type() stor.Type {
+	return stor.TypeOf(t.base)
+}
+
+// Close closes the base Storage.
+func (t *Trace) Close() error {
+	return stor.Close(t.base)
+}
@@ -0,0 +1,147 @@
+package trace
+
+import (
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/suite"
+
+	"github.com/pw1/stor"
+	"github.com/pw1/stor/memory"
+	"github.com/pw1/stor/tester"
+)
+
+// recordedSpan is a span that has been recorded by recordingTracer.
+type recordedSpan struct {
+	name  string
+	attrs map[string]interface{}
+	err   error
+	ended bool
+}
+
+func (s *recordedSpan) SetAttribute(key string, value interface{}) {
+	s.attrs[key] = value
+}
+
+func (s *recordedSpan) End(err error) {
+	s.err = err
+	s.ended = true
+}
+
+// recordingTracer is a Tracer that records all spans.
+type recordingTracer struct {
+	mutex sync.Mutex
+	spans []*recordedSpan
+}
+
+func (r *recordingTracer) Start(name string) Span {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	span := &recordedSpan{name: name, attrs: make(map[string]interface{})}
+	r.spans = append(r.spans, span)
+	return span
+}
+
+// TestTraceStorageTester calls the generic storage tests.
+func TestTraceStorageTester(t *testing.T) {
+	testSuite := &tester.StorageTester{
+		SetupTestFunc: func(s *tester.StorageTester) {
+			base, _ := memory.New(&stor.Conf{})
+			s.Storage = New(base, memory.MemoryStorageType, &recordingTracer{})
+		},
+	}
+
+	suite.Run(t, testSuite)
+}
+
+func TestTraceSuite(t *testing.T) {
+	suite.Run(t, new(TraceSuite))
+}
+
+// TraceSuite contains tests that are specific for the Trace wrapper.
+type TraceSuite struct {
+	suite.Suite
+	tracer *recordingTracer
+	trace  *Trace
+}
+
+func (s *TraceSuite) SetupTest() {
+	base, _ := memory.New(&stor.Conf{})
+	s.tracer = &recordingTracer{}
+	s.trace = New(base, memory.MemoryStorageType, s.tracer)
+}
+
+// lastSpan returns the last recorded span.
+func (s *TraceSuite) lastSpan() *recordedSpan {
+	s.Require().NotEmpty(s.tracer.spans)
+	return s.tracer.spans[len(s.tracer.spans)-1]
+}
+
+func (s *TraceSuite) TestSave() {
+	s.Nil(s.trace.Save("dir/file1", []byte("test123")))
+
+	span := s.lastSpan()
+	s.Equal("stor.Save", span.name)
+	s.True(span.ended)
+	s.Nil(span.err)
+	s.Equal(map[string]interface{}{
+		AttrType: string(memory.MemoryStorageType),
+		AttrPath: "dir/file1",
+		AttrSize: int64(7),
+	}, span.attrs)
+}
+
+func (s *TraceSuite) TestLoad() {
+	s.Require().Nil(s.trace.Save("file1", []byte("test123")))
+
+	_, err := s.trace.Load("file1", 100)
+	s.Nil(err)
+	span := s.lastSpan()
+	s.Equal("stor.Load", span.name)
+	s.Equal(int64(7), span.attrs[AttrSize])
+	s.Equal(int64(100), span.attrs[AttrMaxSize])
+
+	_, err = s.trace.Load("file1", 1)
+	s.True(stor.IsTooLargeError(err))
+	span = s.lastSpan()
+	s.Equal(err, span.err)
+	s.NotContains(span.attrs, AttrSize)
+}
+
+func (s *TraceSuite) TestMetaAndList() {
+	s.Require().Nil(s.trace.Save("dir/file1", []byte("test123")))
+	s.Require().Nil(s.trace.Save("dir/sub/file2", []byte("test")))
+
+	_, err := s.trace.Meta("dir/file1")
+	s.Nil(err)
+	s.Equal("stor.Meta", s.lastSpan().name)
+	s.Equal(int64(7), s.lastSpan().attrs[AttrSize])
+
+	_, _, err = s.trace.List("dir")
+	s.Nil(err)
+	s.Equal("stor.List", s.lastSpan().name)
+	s.Equal(int64(2), s.lastSpan().attrs[AttrEntries])
+}
+
+func (s *TraceSuite) TestDeleteError() {
+	err := s.trace.Delete("file1")
+	s.True(stor.IsPathDoesntExistError(err))
+
+	span := s.lastSpan()
+	s.Equal("stor.Delete", span.name)
+	s.True(span.ended)
+	s.Equal(err, span.err)
+}
+
+func (s *TraceSuite) TestNoType() {
+	base, _ := memory.New(&stor.Conf{})
+	tr := New(base, "", s.tracer)
+	s.Nil(tr.Save("file1", []byte("test")))
+	s.Equal(string(memory.MemoryStorageType), s.lastSpan().attrs[AttrType])
+
+	// A base without a Type
+	tr = New(struct{ stor.Storage }{base}, "", s.tracer)
+	s.Nil(tr.Save("file1", []byte("test")))
+	s.NotContains(s.lastSpan().attrs, AttrType)
+}
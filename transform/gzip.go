@@ -0,0 +1,65 @@
+package transform
+
+import (
+	"bytes"
+	"compress/gzip"
+
+	"github.com/pw1/stor"
+	"github.com/pw1/stor/header"
+)
+
+func init() {
+	header.RegisterDecoder(Gzip{}.Layer(), Gzip{}.Decode)
+}
+
+// Gzip is a Transformer that compresses data with gzip.
+type Gzip struct {
+	// Level is the gzip compression level. The zero value selects gzip.DefaultCompression.
+	Level int
+}
+
+// Layer returns the header.Layer of gzip compressed data.
+func (g Gzip) Layer() header.Layer {
+	return header.Layer{Name: "gzip", Version: 1}
+}
+
+// Encode compresses data.
+func (g Gzip) Encode(data []byte) ([]byte, error) {
+	level := g.Level
+	if level == 0 {
+		level = gzip.DefaultCompression
+	}
+
+	// The compressed data is written to a pooled buffer, and returned in a buffer of its exact size
+	pool := stor.GetDefaultBufferPool()
+	buf := bytes.NewBuffer(pool.Get(len(data)/2 + 512))
+	defer func() {
+		pool.Put(buf.Bytes())
+	}()
+
+	w, err := gzip.NewWriterLevel(buf, level)
+	if err != nil {
+		return nil, err
+	}
+	if _, err = w.Write(data); err != nil {
+		return nil, err
+	}
+	if err = w.Close(); err != nil {
+		return nil, err
+	}
+
+	encoded := make([]byte, buf.Len())
+	copy(encoded, buf.Bytes())
+	return encoded, nil
+}
+
+// Decode decompresses data. At most maxSize+1 bytes are decompressed.
+func (g Gzip) Decode(data []byte, maxSize int64) ([]byte, error) {
+	r, err := gzip.NewReader(bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+
+	return stor.ReadAllMax(r, maxSize, "decompressed data")
+}
@@ -0,0 +1,70 @@
+package transform
+
+import (
+	"encoding/binary"
+	"math"
+
+	"github.com/pw1/stor"
+	"github.com/pw1/stor/header"
+)
+
+// sizeLen is the length of the plain size at the start of the payload of sizeLayer.
+const sizeLen = 8
+
+// sizeLayer is the last layer of files that are transformed by a Transform. Its payload starts
+// with the size of the plain data (as a big endian uint64), so Meta doesn't need to decode the
+// file, and Load can reject files that are too large before it decodes them.
+var sizeLayer = header.Layer{Name: "plain-size", Version: 1}
+
+func init() {
+	header.RegisterDecoder(sizeLayer, decodeSize)
+}
+
+// encodeSize prefixes the encoded data with the size of the plain data.
+func encodeSize(plainSize int, encoded []byte) []byte {
+	data := make([]byte, sizeLen, sizeLen+len(encoded))
+	binary.BigEndian.PutUint64(data, uint64(plainSize))
+	return append(data, encoded...)
+}
+
+// decodeSize is the decoder of sizeLayer.
+func decodeSize(payload []byte, maxSize int64) ([]byte, error) {
+	size, encoded, err := splitSize(payload)
+	if err != nil {
+		return nil, err
+	}
+	if size > maxSize {
+		return nil, &stor.TooLargeError{What: "decoded data"}
+	}
+	return encoded, nil
+}
+
+// splitSize splits the payload of sizeLayer in the size of the plain data, and the encoded data.
+func splitSize(payload []byte) (int64, []byte, error) {
+	if len(payload) < sizeLen {
+		return 0, nil, &header.FormatError{Msg: "truncated plain size"}
+	}
+	size := binary.BigEndian.Uint64(payload)
+	if size > math.MaxInt64 {
+		return 0, nil, &header.FormatError{Msg: "invalid plain size"}
+	}
+	return int64(size), payload[sizeLen:], nil
+}
+
+// plainSize returns the size of the plain data of a stored file, and true, if its header records
+// it. Files that were transformed before the size was recorded return false.
+func plainSize(stored []byte) (int64, bool, error) {
+	h, payload, err := header.Parse(stored)
+	if err != nil {
+		return 0, false, err
+	}
+	if len(h.Layers) == 0 || h.Layers[len(h.Layers)-1] != sizeLayer {
+		return 0, false, nil
+	}
+
+	size, _, err := splitSize(payload)
+	if err != nil {
+		return 0, false, err
+	}
+	return size, true, nil
+}
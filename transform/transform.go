@@ -0,0 +1,303 @@
+// Package transform implements a stor.Storage wrapper that transforms data (e.g. compresses it)
+// before it is saved to an underlying Storage, and reverses that transformation when it is loaded.
+// Which transformations are applied is configured per path prefix.
+//
+// Transformed files start with a header (see package header) that lists the Transformers that
+// encoded them, followed by a layer that records the size of the plain data. Files are decoded
+// according to that header, so they remain readable when the rules change later on. Files that are
+// stored untouched don't have a header, unless their data starts with header.Magic: those are
+// stored with a header without layers, so they aren't mistaken for transformed files.
+package transform
+
+import (
+	"bytes"
+	"fmt"
+	"math"
+	"sort"
+	"strings"
+
+	"github.com/pw1/stor"
+	"github.com/pw1/stor/header"
+)
+
+// Transformer encodes data before it is saved, and decodes it again after it is loaded.
+type Transformer interface {
+	// Layer identifies the Transformer and the version of its format in the header of transformed
+	// files. Files are decoded by the Transformer with the same Layer in the rules of the
+	// Transform, or else by the decoder that is registered for it with header.RegisterDecoder.
+	Layer() header.Layer
+
+	// Encode transforms plain data into its stored form.
+	Encode(data []byte) ([]byte, error)
+
+	// Decode transforms stored data back into its plain form. If the decoded data is larger than
+	// maxSize, then Decode returns a stor.TooLargeError. Decode must stop decoding as soon as
+	// that limit is exceeded.
+	Decode(data []byte, maxSize int64) ([]byte, error)
+}
+
+// Rule assigns a chain of Transformers to all files below a path prefix.
+type Rule struct {
+	// Prefix is the slash-separated directory the rule applies to. The rule applies to all files
+	// within that directory and its subdirectories. An empty Prefix matches all files.
+	Prefix string
+
+	// Transformers are applied in order on Save, and in reverse order on Load. A Rule without
+	// Transformers leaves the data under its Prefix untouched.
+	Transformers []Transformer
+}
+
+// Transform is a stor.Storage wrapper that applies the Transformers of the best matching Rule to
+// every file that is saved or loaded. If multiple rules match a path, the rule with the longest
+// Prefix wins. Files that are not matched by any rule are stored untouched.
+type Transform struct {
+	base     stor.Storage
+	rules    []Rule
+	decoders map[header.Layer]header.Decoder
+}
+
+// New creates a new Transform that stores its data in base, transformed according to rules.
+func New(base stor.Storage, rules ...Rule) (*Transform, error) {
+	cleanRules := make([]Rule, 0, len(rules))
+	seen := make(map[string]bool)
+	decoders := make(map[header.Layer]header.Decoder)
+	for _, rule := range rules {
+		prefix, err := stor.CleanPath(rule.Prefix)
+		if err != nil {
+			return nil, err
+		}
+		if seen[prefix] {
+			return nil, fmt.Errorf("duplicate rule for prefix %q", prefix)
+		}
+		seen[prefix] = true
+
+		for _, transformer := range rule.Transformers {
+			decoders[transformer.Layer()] = transformer.Decode
+		}
+
+		cleanRules = append(cleanRules, Rule{Prefix: prefix, Transformers: rule.Transformers})
+	}
+
+	// Sort the rules from the longest to the shortest prefix, so the first match is the best match
+	sort.Slice(cleanRules, func(i, j int) bool {
+		return len(cleanRules[i].Prefix) > len(cleanRules[j].Prefix)
+	})
+
+	t := &Transform{
+		base:     base,
+		rules:    cleanRules,
+		decoders: decoders,
+	}
+	return t, nil
+}
+
+// transformers returns the chain of Transformers for a cleaned path.
+func (t *Transform) transformers(cleanPath string) []Transformer {
+	for _, rule := range t.rules {
+		if rule.Prefix == "" || cleanPath == rule.Prefix ||
+			strings.HasPrefix(cleanPath, rule.Prefix+"/") {
+			return rule.Transformers
+		}
+	}
+	return nil
+}
+
+// metaOverhead is the room for the header and the encoding overhead of the layers, on top of
+// stor.GetDefaultMaxSize, of the stored files that Meta loads.
+const metaOverhead = 1 << 20
+
+// Meta returns meta information about a file. The reported size is the size of the plain data,
+// which is read from the header of the file. For files that no rule applies to, it is the size of
+// the stored data, which differs for files that were transformed by a rule that was removed since.
+//
+// The Storage interface can't load part of a file, so Meta loads the stored file to read its
+// header. To bound the memory that this takes, a stor.TooLargeError is returned for files that are
+// stored larger than stor.GetDefaultMaxSize (plus some overhead). Files that were transformed
+// before their header recorded the plain size are decoded to determine it, and a
+// stor.TooLargeError is returned for those that are larger than stor.GetDefaultMaxSize when
+// decoded.
+func (t *Transform) Meta(filePath string) (*stor.Meta, error) {
+	cleanPath, err := stor.CleanPath(filePath)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(t.transformers(cleanPath)) == 0 {
+		return t.base.Meta(cleanPath)
+	}
+
+	maxStored := int64(math.MaxInt64)
+	if maxSize := stor.GetDefaultMaxSize(); maxSize < math.MaxInt64-metaOverhead {
+		maxStored = maxSize + metaOverhead
+	}
+	stored, err := t.base.Load(cleanPath, maxStored)
+	if stor.IsTooLargeError(err) {
+		return nil, &stor.TooLargeError{What: cleanPath}
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	size := int64(len(stored))
+	if bytes.HasPrefix(stored, []byte(header.Magic)) {
+		var ok bool
+		if size, ok, err = plainSize(stored); err != nil {
+			return nil, err
+		} else if !ok {
+			data, err := t.decode(cleanPath, stored, stor.GetDefaultMaxSize())
+			if err != nil {
+				return nil, err
+			}
+			size = int64(len(data))
+		}
+	}
+
+	meta := &stor.Meta{
+		Size: size,
+	}
+	return meta, nil
+}
+
+// List returns the files and subdirectories within the specified directory.
+func (t *Transform) List(dirPath string) ([]string, []string, error) {
+	return t.base.List(dirPath)
+}
+
+// Load loads and decodes the content of the specified file. If the decoded file is larger than
+// maxSize, then an error is returned.
+func (t *Transform) Load(filePath string, maxSize int64) ([]byte, error) {
+	cleanPath, err := stor.CleanPath(filePath)
+	if err != nil {
+		return []byte{}, err
+	}
+
+	return t.load(cleanPath, t.transformers(cleanPath), maxSize)
+}
+
+// load loads a file, and decodes it according to its header, whatever the current rules are. Files
+// without a header are returned as they are stored.
+func (t *Transform) load(cleanPath string, chain []Transformer, maxSize int64) ([]byte, error) {
+	// The size of the stored data says nothing about the size of the decoded data. Where no rule
+	// applies, files are expected to be stored untouched, so the stored data is bounded by maxSize.
+	baseMaxSize := maxSize
+	if len(chain) > 0 {
+		baseMaxSize = math.MaxInt64
+	}
+
+	data, err := t.base.Load(cleanPath, baseMaxSize)
+	if err != nil {
+		return []byte{}, err
+	}
+
+	// Files that were saved before a rule applied to them have no header
+	if !bytes.HasPrefix(data, []byte(header.Magic)) {
+		if int64(len(data)) > maxSize {
+			return []byte{}, &stor.TooLargeError{What: cleanPath}
+		}
+		return data, nil
+	}
+
+	return t.decode(cleanPath, data, maxSize)
+}
+
+// decode decodes stored data with a header. Data of which the header records a plain size larger
+// than maxSize is rejected before it is decoded, and the recorded size bounds the decoding.
+func (t *Transform) decode(cleanPath string, data []byte, maxSize int64) ([]byte, error) {
+	size, sized, err := plainSize(data)
+	if err != nil {
+		return []byte{}, err
+	}
+	if sized {
+		if size > maxSize {
+			return []byte{}, &stor.TooLargeError{What: cleanPath}
+		}
+		maxSize = size
+	}
+
+	data, err = header.DecodeWith(data, maxSize, t.lookupDecoder)
+	if stor.IsTooLargeError(err) && sized {
+		return []byte{}, wrongSizeError(cleanPath)
+	}
+	if stor.IsTooLargeError(err) {
+		return []byte{}, &stor.TooLargeError{What: cleanPath}
+	}
+	if header.IsFormatError(err) {
+		return []byte{}, err
+	}
+	if err != nil {
+		return []byte{}, fmt.Errorf("failed to decode %s: %v", cleanPath, err)
+	}
+
+	if int64(len(data)) > maxSize {
+		return []byte{}, &stor.TooLargeError{What: cleanPath}
+	}
+	if sized && int64(len(data)) != size {
+		return []byte{}, wrongSizeError(cleanPath)
+	}
+
+	return data, nil
+}
+
+// wrongSizeError returns the error for a file of which the decoded data doesn't have the recorded
+// plain size.
+func wrongSizeError(cleanPath string) error {
+	return &header.FormatError{Msg: cleanPath + " doesn't have the recorded plain size"}
+}
+
+// lookupDecoder returns the decoder of a layer. The Transformers of the rules take precedence over
+// the registered decoders.
+func (t *Transform) lookupDecoder(layer header.Layer) (header.Decoder, bool) {
+	if decoder, ok := t.decoders[layer]; ok {
+		return decoder, true
+	}
+	return header.LookupDecoder(layer)
+}
+
+// Save encodes the data and saves it to the specified file.
+func (t *Transform) Save(filePath string, data []byte) error {
+	cleanPath, err := stor.CleanPath(filePath)
+	if err != nil {
+		return err
+	}
+
+	chain := t.transformers(cleanPath)
+	if len(chain) == 0 && !bytes.HasPrefix(data, []byte(header.Magic)) {
+		return t.base.Save(cleanPath, data)
+	}
+
+	h := header.Header{Layers: make([]header.Layer, len(chain))}
+	size := len(data)
+	for i, transformer := range chain {
+		h.Layers[i] = transformer.Layer()
+		data, err = transformer.Encode(data)
+		if err != nil {
+			return fmt.Errorf("failed to encode %s: %v", cleanPath, err)
+		}
+	}
+	if len(chain) > 0 {
+		h.Layers = append(h.Layers, sizeLayer)
+		data = encodeSize(size, data)
+	}
+
+	data, err = h.Wrap(data)
+	if err != nil {
+		return fmt.Errorf("failed to encode %s: %v", cleanPath, err)
+	}
+
+	return t.base.Save(cleanPath, data)
+}
+
+// Delete removes a file from storage.
+func (t *Transform) Delete(filePath string) error {
+	return t.base.Delete(filePath)
+}
+
+// Type returns the Type of the base Storage.
+func (t *Transform) Type() stor.Type {
+	return stor.TypeOf(t.base)
+}
+
+// Close closes the base Storage.
+func (t *Transform) Close() error {
+	return stor.Close(t.base)
+}
@@ -0,0 +1,346 @@
+package transform
+
+import (
+	"bytes"
+	"compress/gzip"
+	"math/rand"
+	"runtime"
+	"testing"
+
+	"github.com/stretchr/testify/suite"
+
+	"github.com/pw1/stor"
+	"github.com/pw1/stor/crypt"
+	"github.com/pw1/stor/header"
+	"github.com/pw1/stor/memory"
+	"github.com/pw1/stor/tester"
+)
+
+// TestTransformStorageTester calls the generic storage tests
+func TestTransformStorageTester(t *testing.T) {
+	testSuite := &tester.StorageTester{
+		SetupTestFunc: func(s *tester.StorageTester) {
+			base, _ := memory.New(&stor.Conf{})
+			st, err := New(base,
+				Rule{Prefix: "", Transformers: []Transformer{Gzip{}}},
+				Rule{Prefix: "dir2", Transformers: []Transformer{}},
+			)
+			s.Require().Nil(err)
+			s.Storage = st
+		},
+	}
+
+	suite.Run(t, testSuite)
+}
+
+func TestTransformSuite(t *testing.T) {
+	suite.Run(t, new(TransformSuite))
+}
+
+// TransformSuite contains tests that are specific for the Transform wrapper.
+type TransformSuite struct {
+	suite.Suite
+	base *memory.Memory
+	st   *Transform
+}
+
+func (s *TransformSuite) SetupTest() {
+	s.base, _ = memory.New(&stor.Conf{})
+
+	st, err := New(s.base,
+		Rule{Prefix: "logs", Transformers: []Transformer{Gzip{}}},
+		Rule{Prefix: "logs/raw"},
+	)
+	s.Require().Nil(err)
+	s.st = st
+}
+
+func (s *TransformSuite) TestNewInvalidPrefix() {
+	st, err := New(s.base, Rule{Prefix: "../logs"})
+	s.Nil(st)
+	s.True(stor.IsInvalidPathError(err))
+}
+
+func (s *TransformSuite) TestNewDuplicatePrefix() {
+	st, err := New(s.base, Rule{Prefix: "logs"}, Rule{Prefix: "logs/"})
+	s.Nil(st)
+	s.NotNil(err)
+}
+
+// TestPerPrefix verifies that only files below a prefix with Transformers are transformed.
+func (s *TransformSuite) TestPerPrefix() {
+	data := bytes.Repeat([]byte("abc"), 100)
+
+	s.Nil(s.st.Save("logs/app.log", data))
+	s.Nil(s.st.Save("logs/raw/app.log", data))
+	s.Nil(s.st.Save("images/img.png", data))
+
+	stored, err := s.base.Load("logs/app.log", 1e6)
+	s.Nil(err)
+	s.NotEqual(data, stored)
+	s.True(len(stored) < len(data))
+
+	stored, err = s.base.Load("logs/raw/app.log", 1e6)
+	s.Nil(err)
+	s.Equal(data, stored)
+
+	stored, err = s.base.Load("images/img.png", 1e6)
+	s.Nil(err)
+	s.Equal(data, stored)
+
+	loaded, err := s.st.Load("logs/app.log", 1e6)
+	s.Nil(err)
+	s.Equal(data, loaded)
+}
+
+// TestMetaPlainSize verifies that Meta reports the size of the decoded data.
+func (s *TransformSuite) TestMetaPlainSize() {
+	data := bytes.Repeat([]byte("abc"), 100)
+	s.Nil(s.st.Save("logs/app.log", data))
+
+	meta, err := s.st.Meta("logs/app.log")
+	s.Nil(err)
+	s.Equal(int64(len(data)), meta.Size)
+}
+
+// TestLoadMaxSizeDecoded verifies that maxSize applies to the decoded data.
+func (s *TransformSuite) TestLoadMaxSizeDecoded() {
+	data := bytes.Repeat([]byte("abc"), 100)
+	s.Nil(s.st.Save("logs/app.log", data))
+
+	loaded, err := s.st.Load("logs/app.log", 299)
+	s.True(stor.IsTooLargeError(err))
+	s.Equal([]byte{}, loaded)
+
+	loaded, err = s.st.Load("logs/app.log", 300)
+	s.Nil(err)
+	s.Equal(data, loaded)
+}
+
+// TestLoadDecompressionBomb verifies that a highly compressed file isn't decompressed beyond
+// maxSize.
+func (s *TransformSuite) TestLoadDecompressionBomb() {
+	bomb, err := Gzip{}.Encode(make([]byte, 100e6))
+	s.Require().Nil(err)
+	s.True(len(bomb) < 1e6)
+
+	stored, err := header.Header{Layers: []header.Layer{Gzip{}.Layer()}}.Wrap(bomb)
+	s.Require().Nil(err)
+	s.Nil(s.base.Save("logs/bomb", stored))
+
+	loaded, err := s.st.Load("logs/bomb", 1e6)
+	s.True(stor.IsTooLargeError(err))
+	s.Equal([]byte{}, loaded)
+
+	_, err = Gzip{}.Decode(bomb, 1e3)
+	s.True(stor.IsTooLargeError(err))
+}
+
+// TestLoadNestedDecompressionBomb verifies that the intermediate layers of a file are bounded by
+// maxSize too, not only the last one.
+func (s *TransformSuite) TestLoadNestedDecompressionBomb() {
+	// The second layer decompresses to 64 MB that the last layer never gets to see
+	bomb := make([]byte, 64e6)
+	for i := 0; i < 2; i++ {
+		var err error
+		bomb, err = Gzip{Level: gzip.BestCompression}.Encode(bomb)
+		s.Require().Nil(err)
+	}
+	s.True(len(bomb) < 10e3)
+
+	gzipLayer := Gzip{}.Layer()
+	stored, err := header.Header{Layers: []header.Layer{gzipLayer, gzipLayer, gzipLayer}}.Wrap(
+		bomb)
+	s.Require().Nil(err)
+	s.Nil(s.base.Save("logs/bomb", stored))
+
+	var before, after runtime.MemStats
+	runtime.ReadMemStats(&before)
+	loaded, err := s.st.Load("logs/bomb", 1024)
+	runtime.ReadMemStats(&after)
+	s.True(stor.IsTooLargeError(err))
+	s.Equal([]byte{}, loaded)
+	s.Less(after.TotalAlloc-before.TotalAlloc, uint64(16e6))
+}
+
+func (s *TransformSuite) TestGzipDecodeLimit() {
+	encoded, err := Gzip{}.Encode([]byte("test123"))
+	s.Require().Nil(err)
+
+	decoded, err := Gzip{}.Decode(encoded, 7)
+	s.Nil(err)
+	s.Equal([]byte("test123"), decoded)
+
+	_, err = Gzip{}.Decode(encoded, 6)
+	s.True(stor.IsTooLargeError(err))
+
+	_, err = Gzip{}.Decode([]byte("not gzip"), 100)
+	s.NotNil(err)
+	s.False(stor.IsTooLargeError(err))
+}
+
+// TestHeader verifies that transformed files start with a header that lists the Transformers.
+func (s *TransformSuite) TestHeader() {
+	s.Nil(s.st.Save("logs/app.log", []byte("test123")))
+
+	stored, err := s.base.Load("logs/app.log", 1e6)
+	s.Nil(err)
+	h, _, err := header.Parse(stored)
+	s.Nil(err)
+	s.Equal([]header.Layer{{Name: "gzip", Version: 1}, {Name: "plain-size", Version: 1}}, h.Layers)
+}
+
+// TestMetaBounded verifies that Meta takes the plain size from the header, and doesn't decode
+// files without it beyond the default maximum size.
+func (s *TransformSuite) TestMetaBounded() {
+	defer stor.SetDefaultMaxSize(stor.GetDefaultMaxSize())
+	stor.SetDefaultMaxSize(1e6)
+
+	bomb, err := Gzip{}.Encode(make([]byte, 100e6))
+	s.Require().Nil(err)
+	stored, err := header.Header{Layers: []header.Layer{Gzip{}.Layer()}}.Wrap(bomb)
+	s.Require().Nil(err)
+	s.Nil(s.base.Save("logs/bomb", stored))
+
+	_, err = s.st.Meta("logs/bomb")
+	s.True(stor.IsTooLargeError(err))
+
+	// The recorded size is used, and Load rejects the file before decoding it
+	stored, err = header.Header{Layers: []header.Layer{Gzip{}.Layer(), sizeLayer}}.Wrap(
+		encodeSize(100e6, bomb))
+	s.Require().Nil(err)
+	s.Nil(s.base.Save("logs/bomb", stored))
+
+	meta, err := s.st.Meta("logs/bomb")
+	s.Nil(err)
+	s.Equal(int64(100e6), meta.Size)
+	_, err = s.st.Load("logs/bomb", 1e6)
+	s.True(stor.IsTooLargeError(err))
+
+	// The recorded size bounds the decoding
+	stored, err = header.Header{Layers: []header.Layer{Gzip{}.Layer(), sizeLayer}}.Wrap(
+		encodeSize(10, bomb))
+	s.Require().Nil(err)
+	s.Nil(s.base.Save("logs/bomb", stored))
+	_, err = s.st.Load("logs/bomb", 1e9)
+	s.True(header.IsFormatError(err))
+	// Files that are stored larger than the default maximum size (plus overhead) aren't loaded
+	large := make([]byte, 3e6)
+	rand.New(rand.NewSource(1)).Read(large)
+	s.Nil(s.st.Save("logs/large", large))
+	_, err = s.st.Meta("logs/large")
+	s.True(stor.IsTooLargeError(err))
+}
+
+// TestRulesChanged verifies that files remain readable after the rules have changed.
+func (s *TransformSuite) TestRulesChanged() {
+	s.Nil(s.st.Save("logs/app.log", []byte("test123")))
+
+	st, err := New(s.base, Rule{Prefix: "logs", Transformers: []Transformer{Gzip{Level: 9},
+		Gzip{Level: 1}}})
+	s.Require().Nil(err)
+
+	data, err := st.Load("logs/app.log", 1e6)
+	s.Nil(err)
+	s.Equal([]byte("test123"), data)
+}
+
+// TestRuleAdded verifies that files that were saved before a rule applied to them are loaded as
+// they are stored.
+func (s *TransformSuite) TestRuleAdded() {
+	s.Nil(s.base.Save("logs/app.log", []byte("not transformed")))
+
+	data, err := s.st.Load("logs/app.log", 1e6)
+	s.Nil(err)
+	s.Equal([]byte("not transformed"), data)
+
+	_, err = s.st.Load("logs/app.log", 14)
+	s.True(stor.IsTooLargeError(err))
+}
+
+// TestRuleRemoved verifies that files that were transformed by a rule that was removed since are
+// still decoded.
+func (s *TransformSuite) TestRuleRemoved() {
+	s.Nil(s.st.Save("logs/app.log", []byte("test123")))
+
+	st, err := New(s.base)
+	s.Require().Nil(err)
+	data, err := st.Load("logs/app.log", 1e6)
+	s.Nil(err)
+	s.Equal([]byte("test123"), data)
+
+	st, err = New(s.base, Rule{Prefix: "logs/app.log"})
+	s.Require().Nil(err)
+	data, err = st.Load("logs/app.log", 1e6)
+	s.Nil(err)
+	s.Equal([]byte("test123"), data)
+}
+
+// TestMagicData verifies that untouched data that looks like a header isn't decoded.
+func (s *TransformSuite) TestMagicData() {
+	plain := []byte(header.Magic + "\x01\x01abc")
+	s.Nil(s.st.Save("images/img.png", plain))
+
+	data, err := s.st.Load("images/img.png", 1e6)
+	s.Nil(err)
+	s.Equal(plain, data)
+
+	// Data with a corrupt header is still detected
+	s.Nil(s.base.Save("images/img.png", plain))
+	_, err = s.st.Load("images/img.png", 1e6)
+	s.True(header.IsFormatError(err))
+}
+
+// TestMixedPrefixes verifies that compressed, encrypted and untouched files can be combined in one
+// Transform.
+func (s *TransformSuite) TestMixedPrefixes() {
+	encrypt, err := crypt.NewTransformer(crypt.Keyring{Keys: map[uint32][]byte{
+		0: bytes.Repeat([]byte{1}, crypt.KeySize)}})
+	s.Require().Nil(err)
+
+	st, err := New(s.base,
+		Rule{Prefix: "logs", Transformers: []Transformer{Gzip{}, encrypt}},
+		Rule{Prefix: "secrets", Transformers: []Transformer{encrypt}},
+		Rule{Prefix: "public"},
+	)
+	s.Require().Nil(err)
+
+	data := bytes.Repeat([]byte("secret"), 100)
+	expectedLayers := map[string][]header.Layer{
+		"logs/app.log":   {Gzip{}.Layer(), crypt.Layer, sizeLayer},
+		"secrets/key":    {crypt.Layer, sizeLayer},
+		"public/img.png": nil,
+	}
+	for filePath, layers := range expectedLayers {
+		s.Nil(st.Save(filePath, data))
+
+		stored, err := s.base.Load(filePath, 1e6)
+		s.Nil(err)
+		if layers == nil {
+			s.Equal(data, stored)
+		} else {
+			h, _, err := header.Parse(stored)
+			s.Nil(err)
+			s.Equal(layers, h.Layers, filePath)
+			s.False(bytes.Contains(stored, []byte("secret")), filePath)
+		}
+
+		loaded, err := st.Load(filePath, 1e6)
+		s.Nil(err)
+		s.Equal(data, loaded, filePath)
+
+		meta, err := st.Meta(filePath)
+		s.Nil(err)
+		s.Equal(int64(len(data)), meta.Size, filePath)
+
+		_, err = st.Load(filePath, int64(len(data)-1))
+		s.True(stor.IsTooLargeError(err), filePath)
+	}
+
+	// Encrypted files can't be decoded without the key
+	_, err = s.st.Load("secrets/key", 1e6)
+	s.True(header.IsFormatError(err))
+
+	_, err = encrypt.Decode([]byte("short"), 100)
+	s.NotNil(err)
+}
@@ -0,0 +1,143 @@
+package stor
+
+// Capability is an optional feature of a storage Type.
+type Capability string
+
+const (
+	// CapabilityPersistent indicates that files outlive the process.
+	CapabilityPersistent Capability = "persistent"
+
+	// CapabilityReadOnly indicates that Save and Delete always return a ReadOnlyError.
+	CapabilityReadOnly Capability = "read-only"
+
+	// CapabilityRename indicates that the Storage implements Renamer.
+	CapabilityRename Capability = "rename"
+
+	// CapabilityGeneration indicates that the Storage implements Generationer.
+	CapabilityGeneration Capability = "generation"
+
+	// CapabilityCreate indicates that the Storage implements Creator.
+	CapabilityCreate Capability = "create"
+
+	// CapabilitySortedList indicates that the Storage implements SortedLister. Wrappers may
+	// report the Type of the Storage they wrap without keeping the order, so check a Storage value
+	// for SortedLister instead of its Type for this capability.
+	CapabilitySortedList Capability = "sorted-list"
+
+	// CapabilityDirs indicates that the Storage implements DirMaker.
+	CapabilityDirs Capability = "dirs"
+
+	// CapabilityStats indicates that the Storage implements Statser.
+	CapabilityStats Capability = "stats"
+
+	// CapabilityGC indicates that the Storage implements GarbageCollector.
+	CapabilityGC Capability = "gc"
+
+	// CapabilityListMeta indicates that the Storage implements MetaLister.
+	CapabilityListMeta Capability = "list-meta"
+
+	// CapabilityListRecursive indicates that the Storage implements RecursiveLister.
+	CapabilityListRecursive Capability = "list-recursive"
+
+	// CapabilityCopy indicates that the Storage implements Copier.
+	CapabilityCopy Capability = "copy"
+)
+
+// ConformanceResult is the outcome of the conformance tests of a Capability (see package tester).
+type ConformanceResult string
+
+const (
+	// ConformancePassed indicates that all tests passed.
+	ConformancePassed ConformanceResult = "passed"
+
+	// ConformanceFailed indicates that at least one test failed.
+	ConformanceFailed ConformanceResult = "failed"
+
+	// ConformanceSkipped indicates that all tests were skipped, e.g. because the Storage doesn't
+	// have the Capability.
+	ConformanceSkipped ConformanceResult = "skipped"
+)
+
+// OptionInfo describes an option of a storage Type (see Conf.Options).
+type OptionInfo struct {
+	// Name is the key of the option.
+	Name string
+
+	// Description describes the option, including its default value.
+	Description string
+
+	// Required indicates that the option must be specified.
+	Required bool
+
+	// Secret indicates that the value of the option is redacted by Conf.String and
+	// Conf.MarshalJSON.
+	Secret bool
+}
+
+// Info describes a storage Type, e.g. for the help output of a command line tool.
+type Info struct {
+	// Type is the described Type.
+	Type Type
+
+	// Description is a short description of the Type.
+	Description string
+
+	// Path describes the meaning of Conf.Path for the Type. It is empty if the Path is not used.
+	Path string
+
+	// Options are the options that the Type supports, sorted by name.
+	Options []OptionInfo
+
+	// Capabilities are the optional features of the Type.
+	Capabilities []Capability
+
+	// Conformance is the outcome of the conformance tests of the Type per Capability, if they
+	// have been registered with RegisterConformance. It is nil otherwise.
+	Conformance map[Capability]ConformanceResult
+}
+
+// Has returns true if the Info lists a Capability.
+func (i Info) Has(capability Capability) bool {
+	for _, c := range i.Capabilities {
+		if c == capability {
+			return true
+		}
+	}
+	return false
+}
+
+// RequiredOptions returns the names of the options that must be specified.
+func (i Info) RequiredOptions() []string {
+	names := []string{}
+	for _, option := range i.Options {
+		if option.Required {
+			names = append(names, option.Name)
+		}
+	}
+	return names
+}
+
+// RegisterInfo registers the Info of a registered Type. If the Type is not registered, or if it
+// already has an Info, then this function will panic. This function is intended to be called from
+// the init function of packages that implement the Storage interface, after RegisterType.
+func RegisterInfo(info Info) {
+	DefaultRegistry.RegisterInfo(info)
+}
+
+// RegisterConformance registers the outcome of the conformance tests of a registered Type per
+// Capability, e.g. from a tester.Report. It replaces the previously registered outcome. If the
+// Type is not registered, then this function will panic.
+func RegisterConformance(storageType Type, results map[Capability]ConformanceResult) {
+	DefaultRegistry.RegisterConformance(storageType, results)
+}
+
+// Types returns all registered Types, sorted by name.
+func Types() []Type {
+	return DefaultRegistry.Types()
+}
+
+// TypeInfo returns the Info of a registered Type. Types that didn't register an Info get an Info
+// with only the Type set. If the Type is not registered, then an UnregisteredTypeError is returned.
+func TypeInfo(storageType Type) (Info, error) {
+	return DefaultRegistry.TypeInfo(storageType)
+}
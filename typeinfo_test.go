@@ -0,0 +1,83 @@
+package stor_test
+
+import (
+	"sort"
+	"testing"
+
+	"github.com/stretchr/testify/suite"
+
+	"github.com/pw1/stor"
+	"github.com/pw1/stor/b2"
+	"github.com/pw1/stor/localdir"
+	"github.com/pw1/stor/memory"
+)
+
+func TestTypeInfoSuite(t *testing.T) {
+	suite.Run(t, new(TypeInfoSuite))
+}
+
+// TypeInfoSuite contains the tests for the introspection of registered Types.
+type TypeInfoSuite struct {
+	suite.Suite
+}
+
+func (s *TypeInfoSuite) TestTypes() {
+	types := stor.Types()
+	s.Subset(types, []stor.Type{b2.B2StorageType, localdir.LocalDirStorageType,
+		memory.MemoryStorageType})
+	s.True(sort.SliceIsSorted(types, func(i, j int) bool { return types[i] < types[j] }))
+}
+
+func (s *TypeInfoSuite) TestTypeInfo() {
+	info, err := stor.TypeInfo(b2.B2StorageType)
+	s.Nil(err)
+	s.Equal(b2.B2StorageType, info.Type)
+	s.NotEmpty(info.Description)
+	s.Empty(info.RequiredOptions(), "the key can also be passed as Credentials")
+	s.Equal("authurl", info.Options[0].Name, "options are sorted")
+	secrets := []string{}
+	for _, option := range info.Options {
+		if option.Secret {
+			secrets = append(secrets, option.Name)
+		}
+	}
+	s.Equal([]string{"key"}, secrets)
+	s.True(info.Has(stor.CapabilityPersistent))
+	s.False(info.Has(stor.CapabilityReadOnly))
+
+	info, err = stor.TypeInfo(localdir.LocalDirStorageType)
+	s.Nil(err)
+	s.Empty(info.RequiredOptions())
+	s.True(info.Has(stor.CapabilityRename))
+
+	// The registered Info can't be modified through the result
+	info.Capabilities[0] = stor.CapabilityReadOnly
+	info, _ = stor.TypeInfo(localdir.LocalDirStorageType)
+	s.False(info.Has(stor.CapabilityReadOnly))
+}
+
+func (s *TypeInfoSuite) TestTypeInfoUnregistered() {
+	_, err := stor.TypeInfo("Doesn't exist")
+	s.True(stor.IsUnregisteredTypeError(err))
+}
+
+func (s *TypeInfoSuite) TestRegisterInfo() {
+	myTestType := stor.Type("TypeTestInfo")
+	s.Panics(func() {
+		stor.RegisterInfo(stor.Info{Type: myTestType})
+	}, "the type must be registered first")
+
+	stor.RegisterType(myTestType, nil)
+	info, err := stor.TypeInfo(myTestType)
+	s.Nil(err)
+	s.Equal(stor.Info{Type: myTestType}, info)
+
+	stor.RegisterInfo(stor.Info{Type: myTestType, Description: "Test"})
+	info, err = stor.TypeInfo(myTestType)
+	s.Nil(err)
+	s.Equal("Test", info.Description)
+
+	s.Panics(func() {
+		stor.RegisterInfo(stor.Info{Type: myTestType})
+	})
+}
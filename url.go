@@ -0,0 +1,92 @@
+package stor
+
+import (
+	"fmt"
+	"net/url"
+)
+
+// URLPathFunc returns the Path of the Conf for a storage URL.
+type URLPathFunc func(u *url.URL) string
+
+// urlScheme is a registered URL scheme.
+type urlScheme struct {
+	storageType Type
+	path        URLPathFunc
+}
+
+// RegisterScheme registers a URL scheme for NewFromURL. URLs with the scheme create a Storage of
+// storageType. The path function converts the URL to the Path of the Conf. If it is nil, then the
+// Path is the host followed by the path of the URL, e.g. "bucket/prefix" for "s3://bucket/prefix".
+// If the scheme is already registered, then this function will panic. This function is intended
+// to be called from the init function of packages that implement the Storage interface.
+func RegisterScheme(scheme string, storageType Type, path URLPathFunc) {
+	DefaultRegistry.RegisterScheme(scheme, storageType, path)
+}
+
+// hostAndPath returns the host followed by the path of a URL.
+func hostAndPath(u *url.URL) string {
+	return u.Host + u.Path
+}
+
+// ParseURL parses a storage URL into a Conf. The scheme of the URL selects the Type (see
+// RegisterScheme), and the query parameters become the Options. For example:
+//
+//	file:///var/data            LocalDir in /var/data
+//	mem://                      Memory
+//	s3://bucket/prefix?region=eu-west-1
+//
+// See the documentation of each backend for its scheme, and the options that it supports.
+func ParseURL(rawURL string) (*Conf, error) {
+	return DefaultRegistry.ParseURL(rawURL)
+}
+
+// ParseURL parses a storage URL into a Conf, using the URL schemes of the Registry. See the
+// package level ParseURL.
+func (r *Registry) ParseURL(rawURL string) (*Conf, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		// The error of url.Parse contains the URL, which may contain credentials
+		if urlErr, ok := err.(*url.Error); ok {
+			err = urlErr.Err
+		}
+		return nil, fmt.Errorf("Invalid storage URL: %v", err)
+	}
+
+	scheme, ok := r.lookupScheme(u.Scheme)
+	if !ok {
+		return nil, fmt.Errorf("Invalid storage URL: unknown scheme %q", u.Scheme)
+	}
+	if u.User != nil {
+		return nil, fmt.Errorf("Invalid storage URL: user info is not supported")
+	}
+	if u.Fragment != "" {
+		return nil, fmt.Errorf("Invalid storage URL: fragments are not supported")
+	}
+
+	query, err := url.ParseQuery(u.RawQuery)
+	if err != nil {
+		return nil, fmt.Errorf("Invalid storage URL: %v", err)
+	}
+
+	options := make(map[string]string)
+	for key, values := range query {
+		if len(values) > 1 {
+			return nil, fmt.Errorf("Invalid storage URL: option %s is specified more than once",
+				key)
+		}
+		options[key] = values[0]
+	}
+
+	conf := &Conf{
+		Type:    scheme.storageType,
+		Path:    scheme.path(u),
+		Options: options,
+	}
+	return conf, nil
+}
+
+// NewFromURL creates a new Storage object based on a storage URL. See ParseURL for the format of
+// the URL.
+func NewFromURL(rawURL string) (Storage, error) {
+	return DefaultRegistry.NewFromURL(rawURL)
+}
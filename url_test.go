@@ -0,0 +1,92 @@
+package stor_test
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/suite"
+
+	"github.com/pw1/stor"
+	"github.com/pw1/stor/b2"
+	"github.com/pw1/stor/localdir"
+	"github.com/pw1/stor/memory"
+)
+
+func TestURLSuite(t *testing.T) {
+	suite.Run(t, new(URLSuite))
+}
+
+// URLSuite contains the tests for storage URLs.
+type URLSuite struct {
+	suite.Suite
+}
+
+func (s *URLSuite) TestParseURL() {
+	table := []struct {
+		url  string
+		conf stor.Conf
+	}{
+		{"file:///var/data", stor.Conf{Type: localdir.LocalDirStorageType, Path: "/var/data"}},
+		{"file://data/dir", stor.Conf{Type: localdir.LocalDirStorageType, Path: "data/dir"}},
+		{"mem://", stor.Conf{Type: memory.MemoryStorageType}},
+		{"b2://bucket/prefix?keyid=id&key=secret", stor.Conf{
+			Type:    b2.B2StorageType,
+			Path:    "bucket/prefix",
+			Options: map[string]string{"keyid": "id", "key": "secret"},
+		}},
+		{"file:///var/data?statcachettl=2s", stor.Conf{
+			Type:    localdir.LocalDirStorageType,
+			Path:    "/var/data",
+			Options: map[string]string{localdir.OptionStatCacheTTL: "2s"},
+		}},
+	}
+
+	for _, entry := range table {
+		conf, err := stor.ParseURL(entry.url)
+		s.Require().Nil(err, entry.url)
+		if entry.conf.Options == nil {
+			entry.conf.Options = map[string]string{}
+		}
+		s.Equal(&entry.conf, conf, entry.url)
+	}
+}
+
+func (s *URLSuite) TestParseURLInvalid() {
+	for _, url := range []string{
+		"unknown://bucket",
+		"data/dir",
+		"b2://id:secret@bucket",
+		"mem://#fragment",
+		"mem://?a=1&a=2",
+		"mem://?a=%zz",
+		"mem://%zz",
+	} {
+		conf, err := stor.ParseURL(url)
+		s.Nil(conf, url)
+		s.NotNil(err, url)
+		s.True(strings.HasPrefix(err.Error(), "Invalid storage URL"), url)
+		s.NotContains(err.Error(), "secret", url)
+	}
+}
+
+func (s *URLSuite) TestNewFromURL() {
+	st, err := stor.NewFromURL("file://" + s.T().TempDir())
+	s.Require().Nil(err)
+	s.IsType(&localdir.LocalDir{}, st)
+
+	st, err = stor.NewFromURL("mem://")
+	s.Require().Nil(err)
+	s.IsType(&memory.Memory{}, st)
+
+	_, err = stor.NewFromURL("unknown://")
+	s.NotNil(err)
+}
+
+func (s *URLSuite) TestRegisterSchemeDuplicate() {
+	s.Panics(func() {
+		stor.RegisterScheme(memory.URLScheme, memory.MemoryStorageType, nil)
+	})
+	s.Panics(func() {
+		stor.RegisterScheme("", memory.MemoryStorageType, nil)
+	})
+}
@@ -2,8 +2,13 @@ package stor
 
 import (
 	"fmt"
+	"io"
+	"math"
+	"net/http"
 	"path"
+	"strconv"
 	"strings"
+	"time"
 )
 
 const (
@@ -20,18 +25,28 @@ var (
 		"..",
 	}
 
-	validCharDict = make(map[byte]bool)
-)
+	// MaxPathLen is the maximum length of a cleaned path in bytes, which is the maximum length of an
+	// S3 key. Longer paths are rejected by CleanPath. Zero disables the check.
+	MaxPathLen = 1024
 
-func init() {
-	// Create a dictionary with all allowed characters. This is to allow quick lookup.
-	validCharDict[Delimiter] = true
-	for i := 0; i < len(ValidBytes); i++ {
-		validCharDict[ValidBytes[i]] = true
-	}
-}
+	// MaxComponentLen is the maximum length in bytes of a single path component (the name of a file
+	// or directory), which is the limit of most file systems. Longer components are rejected by
+	// CleanPath. Zero disables the check.
+	MaxComponentLen = 255
+
+	// validChars tells for every byte whether it is allowed in a path: the ValidBytes and the
+	// Delimiter. A table is quicker to look up than a map.
+	validChars = func() (table [256]bool) {
+		table[Delimiter] = true
+		for i := 0; i < len(ValidBytes); i++ {
+			table[ValidBytes[i]] = true
+		}
+		return table
+	}()
+)
 
-// CleanPath cleans up a path for use in Storage objects.
+// CleanPath cleans up a path for use in Storage objects. Paths that are already clean are returned
+// as they are, without allocating.
 func CleanPath(filePath string) (string, error) {
 	// Check for any forbidden combinations
 	for _, forbid := range Forbidden {
@@ -46,22 +61,154 @@ func CleanPath(filePath string) (string, error) {
 		return "", &InvalidPathError{filePath, "path must be relative"}
 	}
 
-	// Check for any forbidden characters
+	// Check for any forbidden characters. In the same pass, find out whether the path needs to be
+	// cleaned: whether it has empty components (e.g. "a//b" or a trailing slash) or "." components.
+	clean := true
+	start := 0
 	for i := 0; i < len(filePath); i++ {
 		char := filePath[i]
-		_, ok := validCharDict[char]
-		if !ok {
+		if !validChars[char] {
 			msg := fmt.Sprintf("contains forbidden byte 0x%x (%s) at index %d",
 				char, string(char), i)
 			return "", &InvalidPathError{filePath, msg}
 		}
+		if char == Delimiter {
+			if i == start || (i == start+1 && filePath[start] == '.') {
+				clean = false
+			}
+			start = i + 1
+		}
+	}
+	if (start == len(filePath) && start > 0) || filePath[start:] == "." {
+		clean = false
 	}
 
 	// Clean the path (removing any // combinations)
-	cleanPath := path.Clean(filePath)
-	if cleanPath == "." {
-		cleanPath = ""
+	cleanPath := filePath
+	if !clean {
+		cleanPath = path.Clean(filePath)
+		if cleanPath == "." {
+			cleanPath = ""
+		}
+	}
+
+	if err := checkPathLen(cleanPath, MaxPathLen, MaxComponentLen); err != nil {
+		return "", err
 	}
 
 	return cleanPath, nil
 }
+
+// checkPathLen returns an InvalidPathError if cleanPath is longer than maxPathLen, or if one of its
+// components is longer than maxComponentLen. Zero disables a check.
+func checkPathLen(cleanPath string, maxPathLen, maxComponentLen int) error {
+	if maxPathLen > 0 && len(cleanPath) > maxPathLen {
+		msg := fmt.Sprintf("path is %d bytes long, the maximum is %d", len(cleanPath), maxPathLen)
+		return &InvalidPathError{cleanPath, msg}
+	}
+
+	if maxComponentLen > 0 && len(cleanPath) > maxComponentLen {
+		component := 1
+		start := 0
+		for i := 0; i <= len(cleanPath); i++ {
+			if i < len(cleanPath) && cleanPath[i] != Delimiter {
+				continue
+			}
+			if i-start > maxComponentLen {
+				msg := fmt.Sprintf("component %d is %d bytes long, the maximum is %d",
+					component, i-start, maxComponentLen)
+				return &InvalidPathError{cleanPath, msg}
+			}
+			component++
+			start = i + 1
+		}
+	}
+
+	return nil
+}
+
+// ReadAllMax reads from r until EOF and returns the data. If r provides more than maxSize bytes,
+// then a TooLargeError (for what) is returned and no data. At most maxSize+1 bytes are read from
+// r, so a misbehaving source can't exhaust memory. A negative maxSize rejects all data, even empty
+// data, in line with the Loader contract.
+// The data is read into temporary buffers of the default BufferPool, and returned in a buffer of
+// its exact size.
+func ReadAllMax(r io.Reader, maxSize int64, what string) ([]byte, error) {
+	limit := maxSize
+	if limit < 0 {
+		limit = 0
+	} else if limit < math.MaxInt64 {
+		limit++
+	}
+	r = io.LimitReader(r, limit)
+
+	pool := GetDefaultBufferPool()
+	buf := pool.Get(0)
+	defer func() {
+		pool.Put(buf)
+	}()
+
+	for {
+		if len(buf) == cap(buf) {
+			larger := append(pool.Get(2*cap(buf)), buf...)
+			pool.Put(buf)
+			buf = larger
+		}
+
+		n, err := r.Read(buf[len(buf):cap(buf)])
+		buf = buf[:len(buf)+n]
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return []byte{}, err
+		}
+	}
+
+	if int64(len(buf)) > maxSize {
+		return []byte{}, &TooLargeError{What: what}
+	}
+
+	data := make([]byte, len(buf))
+	copy(data, buf)
+	return data, nil
+}
+
+// HTTPTemporaryError returns a TemporaryError wrapping err if resp has a status code that
+// indicates a temporary condition (429 Too Many Requests or 503 Service Unavailable). The
+// RetryAfter of the error is taken from the Retry-After header of resp. It returns a
+// StorageUnavailableError wrapping err for other server errors that may be transient (500 Internal
+// Server Error, 502 Bad Gateway and 504 Gateway Timeout). Returns err unchanged otherwise.
+func HTTPTemporaryError(resp *http.Response, err error) error {
+	switch resp.StatusCode {
+	case http.StatusTooManyRequests, http.StatusServiceUnavailable:
+		retryAfter := ParseRetryAfter(resp.Header.Get("Retry-After"), time.Now())
+		return &TemporaryError{Err: err, RetryAfter: retryAfter}
+	case http.StatusInternalServerError, http.StatusBadGateway, http.StatusGatewayTimeout:
+		return &StorageUnavailableError{Err: err}
+	default:
+		return err
+	}
+}
+
+// ParseRetryAfter parses the value of a Retry-After HTTP header, which is either a number of
+// seconds or an HTTP date. Returns 0 if the value is empty, invalid, or in the past.
+func ParseRetryAfter(value string, now time.Time) time.Duration {
+	value = strings.TrimSpace(value)
+	if value == "" {
+		return 0
+	}
+
+	if seconds, err := strconv.ParseInt(value, 10, 64); err == nil {
+		if seconds <= 0 || seconds > math.MaxInt64/int64(time.Second) {
+			return 0
+		}
+		return time.Duration(seconds) * time.Second
+	}
+
+	date, err := http.ParseTime(value)
+	if err != nil || !date.After(now) {
+		return 0
+	}
+	return date.Sub(now)
+}
@@ -1,8 +1,13 @@
 package stor
 
 import (
+	"errors"
 	"fmt"
+	"math"
+	"net/http"
+	"strings"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/suite"
 )
@@ -26,6 +31,10 @@ func (s *StorageUtilSuite) TestCleanPath() {
 		[]string{"", ""},
 		[]string{".", ""},
 		[]string{"./", ""},
+		[]string{"./dir1/./file.1", "dir1/file.1"},
+		[]string{"dir1/.", "dir1"},
+		[]string{".dir1/.file1", ".dir1/.file1"},
+		[]string{"dir1/.f", "dir1/.f"},
 	}
 
 	for _, row := range table {
@@ -40,6 +49,19 @@ func (s *StorageUtilSuite) TestCleanPath() {
 	}
 }
 
+// Test that clean paths are returned without allocating.
+func (s *StorageUtilSuite) TestCleanPathNoAlloc() {
+	for _, inputPath := range []string{"", "file1", "dir1/dir2/.file-1", "a/b"} {
+		allocs := testing.AllocsPerRun(100, func() {
+			cleanPath, _ := CleanPath(inputPath)
+			if cleanPath != inputPath {
+				s.Fail("path changed", inputPath)
+			}
+		})
+		s.Zero(allocs, inputPath)
+	}
+}
+
 // Test invalid paths. All these path must return an error
 func (s *StorageUtilSuite) TestCleanPathInvalid() {
 	table := []string{
@@ -62,3 +84,138 @@ func (s *StorageUtilSuite) TestCleanPathInvalid() {
 		s.True(IsInvalidPathError(err), fmt.Sprintf("Input: %s, Actual error: %v", inputPath, err))
 	}
 }
+
+func (s *StorageUtilSuite) TestCleanPathLength() {
+	name := strings.Repeat("a", MaxComponentLen)
+	cleanPath, err := CleanPath("dir/" + name)
+	s.Nil(err)
+	s.Equal("dir/"+name, cleanPath)
+
+	_, err = CleanPath("dir/" + name + "a")
+	s.True(IsInvalidPathError(err))
+	s.Contains(err.Error(), "component 2 is 256 bytes long, the maximum is 255")
+
+	long := strings.Repeat(name+"/", 5)
+	_, err = CleanPath(long)
+	s.True(IsInvalidPathError(err))
+	s.Contains(err.Error(), "path is 1279 bytes long, the maximum is 1024")
+}
+
+func (s *StorageUtilSuite) TestCleanPathLengthConfigurable() {
+	defer func(maxPathLen, maxComponentLen int) {
+		MaxPathLen, MaxComponentLen = maxPathLen, maxComponentLen
+	}(MaxPathLen, MaxComponentLen)
+
+	MaxPathLen, MaxComponentLen = 8, 3
+	_, err := CleanPath("abc/def")
+	s.Nil(err)
+	_, err = CleanPath("abc/defg")
+	s.True(IsInvalidPathError(err))
+	_, err = CleanPath("abc/def/g")
+	s.True(IsInvalidPathError(err))
+
+	// Zero disables the checks
+	MaxPathLen, MaxComponentLen = 0, 0
+	_, err = CleanPath(strings.Repeat("abcdefgh/", 200))
+	s.Nil(err)
+}
+
+func (s *StorageUtilSuite) TestReadAllMax() {
+	data, err := ReadAllMax(strings.NewReader("test123"), 7, "file1")
+	s.Nil(err)
+	s.Equal([]byte("test123"), data)
+
+	data, err = ReadAllMax(strings.NewReader("test123"), math.MaxInt64, "file1")
+	s.Nil(err)
+	s.Equal([]byte("test123"), data)
+
+	data, err = ReadAllMax(strings.NewReader(""), 0, "file1")
+	s.Nil(err)
+	s.Equal([]byte{}, data)
+}
+
+func (s *StorageUtilSuite) TestReadAllMaxLarge() {
+	// The data spans several size classes of the buffer pool
+	content := strings.Repeat("test123", 10000)
+	data, err := ReadAllMax(strings.NewReader(content), int64(len(content)), "file1")
+	s.Nil(err)
+	s.Equal(content, string(data))
+	s.Equal(len(content), cap(data), "the data is returned in a buffer of its exact size")
+
+	pool := NewBufferPool(16, 64)
+	SetDefaultBufferPool(pool)
+	defer SetDefaultBufferPool(NewBufferPool(DefaultBufferPoolMinSize, DefaultBufferPoolMaxSize))
+
+	data, err = ReadAllMax(strings.NewReader(content), math.MaxInt64, "file1")
+	s.Nil(err)
+	s.Equal(content, string(data))
+	stats := pool.Stats()
+	s.Equal(stats.Gets, stats.Puts, "all buffers are returned")
+	s.NotZero(stats.Oversized)
+}
+
+func (s *StorageUtilSuite) TestReadAllMaxTooLarge() {
+	r := strings.NewReader("test123")
+	data, err := ReadAllMax(r, 6, "file1")
+	s.True(IsTooLargeError(err))
+	s.Contains(err.Error(), "file1")
+	s.Equal([]byte{}, data)
+	s.Equal(0, r.Len())
+
+	r = strings.NewReader("test123test123")
+	_, err = ReadAllMax(r, 3, "file1")
+	s.True(IsTooLargeError(err))
+	s.Equal(10, r.Len(), "reads at most maxSize+1 bytes")
+
+	_, err = ReadAllMax(strings.NewReader(""), -1, "file1")
+	s.True(IsTooLargeError(err))
+}
+
+func (s *StorageUtilSuite) TestParseRetryAfter() {
+	now := time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC)
+	table := map[string]time.Duration{
+		"":                              0,
+		"120":                           2 * time.Minute,
+		" 3 ":                           3 * time.Second,
+		"0":                             0,
+		"-5":                            0,
+		"soon":                          0,
+		"99999999999999999999":          0,
+		"Thu, 02 Jan 2020 03:05:05 GMT": time.Minute,
+		"Thu, 02 Jan 2020 03:04:00 GMT": 0,
+	}
+	for value, expected := range table {
+		s.Equal(expected, ParseRetryAfter(value, now), "Input: %q", value)
+	}
+}
+
+func (s *StorageUtilSuite) TestHTTPTemporaryError() {
+	inner := errors.New("failed")
+
+	resp := &http.Response{StatusCode: http.StatusServiceUnavailable, Header: http.Header{}}
+	resp.Header.Set("Retry-After", "7")
+	err := HTTPTemporaryError(resp, inner)
+	s.Equal(&TemporaryError{Err: inner, RetryAfter: 7 * time.Second}, err)
+
+	resp = &http.Response{StatusCode: http.StatusTooManyRequests, Header: http.Header{}}
+	s.Equal(&TemporaryError{Err: inner}, HTTPTemporaryError(resp, inner))
+
+	resp = &http.Response{StatusCode: http.StatusInternalServerError, Header: http.Header{}}
+	s.Equal(&StorageUnavailableError{Err: inner}, HTTPTemporaryError(resp, inner))
+
+	resp = &http.Response{StatusCode: http.StatusNotFound, Header: http.Header{}}
+	s.Equal(inner, HTTPTemporaryError(resp, inner))
+}
+
+func BenchmarkCleanPath(b *testing.B) {
+	for _, inputPath := range []string{"file1", "dir1/dir2/dir3/file-1.txt", "dir1//dir2/./file1/"} {
+		b.Run(inputPath, func(b *testing.B) {
+			b.ReportAllocs()
+			for i := 0; i < b.N; i++ {
+				if _, err := CleanPath(inputPath); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
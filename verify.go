@@ -0,0 +1,215 @@
+package stor
+
+import (
+	"bytes"
+	"fmt"
+	"math"
+)
+
+// ProblemKind classifies a problem that Verify found.
+type ProblemKind string
+
+const (
+	// ProblemCorrupt indicates that Load reported a CorruptDataError, e.g. because the content
+	// doesn't match the checksum that a checksum.Checksum stored with it.
+	ProblemCorrupt ProblemKind = "corrupt"
+
+	// ProblemUnreadable indicates that Load failed with another error, e.g. because the file
+	// wasn't saved through the same wrappers.
+	ProblemUnreadable ProblemKind = "unreadable"
+
+	// ProblemSizeMismatch indicates that the size reported by Meta differs from the size of the
+	// loaded content.
+	ProblemSizeMismatch ProblemKind = "size-mismatch"
+
+	// ProblemMissing indicates that a file is listed, but doesn't exist when it is loaded, or that
+	// it only exists in the replica.
+	ProblemMissing ProblemKind = "missing"
+
+	// ProblemDiffers indicates that the content of a file differs from the content in the replica.
+	ProblemDiffers ProblemKind = "differs"
+
+	// ProblemOrphaned indicates that a file doesn't exist in the replica.
+	ProblemOrphaned ProblemKind = "orphaned"
+)
+
+// VerifyOptions configures Verify.
+type VerifyOptions struct {
+	// Dir is the directory that is verified, including its subdirectories. The default is the root.
+	Dir string
+
+	// MaxSize is the maximum size of a file that is loaded. Larger files are reported as
+	// ProblemUnreadable. The default (0) is unlimited.
+	MaxSize int64
+
+	// Replica is an optional Storage with the same content, e.g. a mirror or a backup. If it is
+	// set, then the content of every file is compared with the replica, and files that exist in
+	// only one of them are reported.
+	Replica Reader
+
+	// Repair replaces the files that are corrupt, unreadable, missing, differ from the replica or
+	// have an inconsistent size with the content from the replica. It requires a Replica.
+	// Orphaned files are reported, but not deleted.
+	Repair bool
+}
+
+// Problem is a problem with a file that Verify found.
+type Problem struct {
+	// Path is the path of the file.
+	Path string
+
+	// Kind classifies the problem.
+	Kind ProblemKind
+
+	// Err is the error of the operation that revealed the problem, if any.
+	Err error
+
+	// Repaired is true if the file was replaced with the content from the replica.
+	Repaired bool
+
+	// RepairErr is the error of the repair, if it failed.
+	RepairErr error
+}
+
+func (p Problem) String() string {
+	msg := fmt.Sprintf("%s: %s", p.Path, p.Kind)
+	if p.Err != nil {
+		msg += fmt.Sprintf(" (%v)", p.Err)
+	}
+	if p.Repaired {
+		msg += ", repaired"
+	} else if p.RepairErr != nil {
+		msg += fmt.Sprintf(", repair failed: %v", p.RepairErr)
+	}
+	return msg
+}
+
+// VerifyReport is the result of Verify.
+type VerifyReport struct {
+	// Checked is the number of files that were checked.
+	Checked int
+
+	// Problems are the problems that were found, in the order in which they were found.
+	Problems []Problem
+}
+
+// OK returns true if no problems were found, or if all problems were repaired.
+func (r *VerifyReport) OK() bool {
+	for _, problem := range r.Problems {
+		if !problem.Repaired {
+			return false
+		}
+	}
+	return true
+}
+
+// Verify checks the integrity of all files within a directory of s. It loads every file, which
+// verifies its checksum if s (or one of its wrappers, e.g. checksum.Checksum) stores checksums,
+// and checks that Meta reports the same size. With a Replica, the content is also compared with
+// the replica, and files that exist in only one of them are reported. The problems are collected
+// in the VerifyReport; an error is only returned if the directories can't be listed, or if the
+// options are invalid. If Repair is set, then s must implement Saver.
+func Verify(s Reader, options VerifyOptions) (*VerifyReport, error) {
+	cleanDir, err := CleanPath(options.Dir)
+	if err != nil {
+		return nil, err
+	}
+	maxSize := options.MaxSize
+	if maxSize <= 0 {
+		maxSize = math.MaxInt64
+	}
+
+	var saver Saver
+	if options.Repair {
+		if options.Replica == nil {
+			return nil, fmt.Errorf("Repair requires a replica")
+		}
+		var ok bool
+		if saver, ok = s.(Saver); !ok {
+			return nil, fmt.Errorf("Repair requires a storage that implements Saver")
+		}
+	}
+
+	report := &VerifyReport{}
+	add := func(problem Problem) {
+		if saver != nil && problem.Kind != ProblemOrphaned {
+			problem.RepairErr = repairFile(saver, options.Replica, problem.Path, maxSize)
+			problem.Repaired = problem.RepairErr == nil
+		}
+		report.Problems = append(report.Problems, problem)
+	}
+
+	seen := make(map[string]bool)
+	err = walkFiles(s, cleanDir, func(filePath string) error {
+		seen[filePath] = true
+		report.Checked++
+		if problem := verifyFile(s, options.Replica, filePath, maxSize); problem != nil {
+			add(*problem)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	if options.Replica != nil {
+		err = walkFiles(options.Replica, cleanDir, func(filePath string) error {
+			if !seen[filePath] {
+				add(Problem{Path: filePath, Kind: ProblemMissing})
+			}
+			return nil
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return report, nil
+}
+
+// verifyFile checks a single file, and returns its problem, or nil if it is fine.
+func verifyFile(s Reader, replica Reader, filePath string, maxSize int64) *Problem {
+	data, err := s.Load(filePath, maxSize)
+	switch {
+	case IsPathDoesntExistError(err):
+		return &Problem{Path: filePath, Kind: ProblemMissing, Err: err}
+	case IsCorruptDataError(err):
+		return &Problem{Path: filePath, Kind: ProblemCorrupt, Err: err}
+	case err != nil:
+		return &Problem{Path: filePath, Kind: ProblemUnreadable, Err: err}
+	}
+
+	meta, err := s.Meta(filePath)
+	if err != nil {
+		return &Problem{Path: filePath, Kind: ProblemUnreadable, Err: err}
+	}
+	if meta.Size != SizeUnknown && meta.Size != int64(len(data)) {
+		err := fmt.Errorf("Meta reports %d bytes, %d bytes were loaded", meta.Size, len(data))
+		return &Problem{Path: filePath, Kind: ProblemSizeMismatch, Err: err}
+	}
+
+	if replica == nil {
+		return nil
+	}
+	replicaData, err := replica.Load(filePath, maxSize)
+	if IsPathDoesntExistError(err) {
+		return &Problem{Path: filePath, Kind: ProblemOrphaned}
+	}
+	if err != nil {
+		err = fmt.Errorf("unable to load the replica: %v", err)
+		return &Problem{Path: filePath, Kind: ProblemUnreadable, Err: err}
+	}
+	if !bytes.Equal(data, replicaData) {
+		return &Problem{Path: filePath, Kind: ProblemDiffers}
+	}
+	return nil
+}
+
+// repairFile replaces a file with the content from the replica.
+func repairFile(saver Saver, replica Reader, filePath string, maxSize int64) error {
+	data, err := replica.Load(filePath, maxSize)
+	if err != nil {
+		return err
+	}
+	return saver.Save(filePath, data)
+}
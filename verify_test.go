@@ -0,0 +1,161 @@
+package stor_test
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/suite"
+
+	"github.com/pw1/stor"
+	"github.com/pw1/stor/checksum"
+	"github.com/pw1/stor/memory"
+)
+
+func TestVerifySuite(t *testing.T) {
+	suite.Run(t, new(VerifySuite))
+}
+
+// VerifySuite contains the tests of Verify.
+type VerifySuite struct {
+	suite.Suite
+	base    *memory.Memory
+	st      *checksum.Checksum
+	replica *memory.Memory
+}
+
+// wrongSizeStorage reports a wrong size for every file.
+type wrongSizeStorage struct {
+	stor.Storage
+}
+
+func (w wrongSizeStorage) Meta(filePath string) (*stor.Meta, error) {
+	meta, err := w.Storage.Meta(filePath)
+	if err != nil {
+		return nil, err
+	}
+	return &stor.Meta{Size: meta.Size + 1}, nil
+}
+
+func (s *VerifySuite) SetupTest() {
+	s.base, _ = memory.New(&stor.Conf{})
+	s.st = checksum.New(s.base)
+	s.replica, _ = memory.New(&stor.Conf{})
+
+	files := map[string]string{
+		"file1":           "test123",
+		"dir1/file2":      "test456",
+		"dir1/dir2/file3": "test789",
+	}
+	for filePath, content := range files {
+		s.Require().Nil(s.st.Save(filePath, []byte(content)))
+		s.Require().Nil(s.replica.Save(filePath, []byte(content)))
+	}
+}
+
+// kinds returns the kind of every problem by path.
+func kinds(report *stor.VerifyReport) map[string]stor.ProblemKind {
+	result := make(map[string]stor.ProblemKind)
+	for _, problem := range report.Problems {
+		result[problem.Path] = problem.Kind
+	}
+	return result
+}
+
+func (s *VerifySuite) TestClean() {
+	report, err := stor.Verify(s.st, stor.VerifyOptions{Replica: s.replica})
+	s.Require().Nil(err)
+	s.Equal(3, report.Checked)
+	s.Empty(report.Problems)
+	s.True(report.OK())
+
+	report, err = stor.Verify(s.st, stor.VerifyOptions{Dir: "dir1"})
+	s.Require().Nil(err)
+	s.Equal(2, report.Checked)
+	s.True(report.OK())
+}
+
+func (s *VerifySuite) TestCorrupt() {
+	// Flip a bit of the data behind the checksum, and save a file without checksum
+	stored, err := s.base.Load("dir1/file2", 1e6)
+	s.Require().Nil(err)
+	stored[len(stored)-1] ^= 1
+	s.Require().Nil(s.base.Save("dir1/file2", stored))
+	s.Require().Nil(s.base.Save("dir1/raw", []byte("test123")))
+
+	report, err := stor.Verify(s.st, stor.VerifyOptions{})
+	s.Require().Nil(err)
+	s.Equal(4, report.Checked)
+	s.Equal(map[string]stor.ProblemKind{
+		"dir1/file2": stor.ProblemCorrupt,
+		"dir1/raw":   stor.ProblemUnreadable,
+	}, kinds(report))
+	s.False(report.OK())
+	s.True(stor.IsCorruptDataError(report.Problems[0].Err))
+	s.Contains(report.Problems[0].String(), "dir1/file2: corrupt")
+}
+
+func (s *VerifySuite) TestSizeMismatch() {
+	report, err := stor.Verify(wrongSizeStorage{s.replica}, stor.VerifyOptions{Dir: "dir1/dir2"})
+	s.Require().Nil(err)
+	s.Equal(map[string]stor.ProblemKind{"dir1/dir2/file3": stor.ProblemSizeMismatch},
+		kinds(report))
+}
+
+func (s *VerifySuite) TestReplica() {
+	s.Require().Nil(s.st.Save("dir1/file2", []byte("changed")))
+	s.Require().Nil(s.st.Delete("file1"))
+	s.Require().Nil(s.st.Save("dir3/file4", []byte("test123")))
+
+	report, err := stor.Verify(s.st, stor.VerifyOptions{Replica: s.replica})
+	s.Require().Nil(err)
+	s.Equal(3, report.Checked)
+	s.Equal(map[string]stor.ProblemKind{
+		"file1":      stor.ProblemMissing,
+		"dir1/file2": stor.ProblemDiffers,
+		"dir3/file4": stor.ProblemOrphaned,
+	}, kinds(report))
+}
+
+func (s *VerifySuite) TestRepair() {
+	stored, err := s.base.Load("dir1/file2", 1e6)
+	s.Require().Nil(err)
+	stored[len(stored)-1] ^= 1
+	s.Require().Nil(s.base.Save("dir1/file2", stored))
+	s.Require().Nil(s.st.Delete("file1"))
+	s.Require().Nil(s.st.Save("dir3/file4", []byte("test123")))
+
+	report, err := stor.Verify(s.st, stor.VerifyOptions{Replica: s.replica, Repair: true})
+	s.Require().Nil(err)
+	s.Len(report.Problems, 3)
+	for _, problem := range report.Problems {
+		s.Equal(problem.Kind != stor.ProblemOrphaned, problem.Repaired, problem.String())
+	}
+	s.False(report.OK(), "the orphaned file isn't repaired")
+
+	data, err := s.st.Load("dir1/file2", 1e6)
+	s.Nil(err)
+	s.Equal("test456", string(data))
+	data, err = s.st.Load("file1", 1e6)
+	s.Nil(err)
+	s.Equal("test123", string(data))
+
+	report, err = stor.Verify(s.st, stor.VerifyOptions{Replica: s.replica})
+	s.Require().Nil(err)
+	s.Equal(map[string]stor.ProblemKind{"dir3/file4": stor.ProblemOrphaned}, kinds(report))
+}
+
+func (s *VerifySuite) TestOptions() {
+	_, err := stor.Verify(s.st, stor.VerifyOptions{Repair: true})
+	s.NotNil(err)
+
+	_, err = stor.Verify(s.st, stor.VerifyOptions{Dir: "../dir1"})
+	s.True(stor.IsInvalidPathError(err))
+
+	// Files larger than MaxSize can't be verified
+	report, err := stor.Verify(s.st, stor.VerifyOptions{MaxSize: 3})
+	s.Require().Nil(err)
+	s.Len(report.Problems, 3)
+	for _, problem := range report.Problems {
+		s.Equal(stor.ProblemUnreadable, problem.Kind)
+		s.True(stor.IsTooLargeError(problem.Err))
+	}
+}